@@ -0,0 +1,185 @@
+// Package client is the client SDK of the apps: it keeps the local behavior
+// sequence of the user, encodes the user features by the shared encoding of
+// the server and builds the recommend requests of the http api. It depends
+// on the standard library and package encoding only, to be bound to the
+// mobile apps by:
+//
+//	gomobile bind -target=android github.com/auxten/go-ctr/client
+//
+// The user features are the event count features of the edgerec quickstart
+// by default, SetUserFeature sets the ones of another model. The requests
+// carry the DeviceProfile of the user, as required by the privacy mode of
+// the server.
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/auxten/go-ctr/recommend/encoding"
+)
+
+// MaxSeen is the max items seen kept of a session
+const MaxSeen = 200
+
+// DeviceProfile is the user profile of the requests, see
+// recommend.DeviceProfile
+type DeviceProfile struct {
+	UserFeature []float32 `json:"userFeature"`
+	Behaviors   []int     `json:"behaviors"`
+}
+
+// Request is the request of the recommend api, see recommend.RecApiRequest
+type Request struct {
+	UserId        int            `json:"userId"`
+	ItemIdList    []int          `json:"itemIdList,omitempty"`
+	SessionId     string         `json:"sessionId,omitempty"`
+	Surface       string         `json:"surface,omitempty"`
+	SeenItemIds   []int          `json:"seenItemIds,omitempty"`
+	DeviceProfile *DeviceProfile `json:"deviceProfile,omitempty"`
+	// Query is the search query of the results ranked, empty of the feeds
+	Query string `json:"query,omitempty"`
+	// DeviceClass and Locale are the request context of the device, see
+	// rcmd.RequestContext
+	DeviceClass string `json:"deviceClass,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+}
+
+// JSON is the body of the request
+func (r *Request) JSON() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// state is the local state of Client, persisted by State and Restore
+type state struct {
+	UserId    int `json:"userId"`
+	Events    int `json:"events"`
+	Positives int `json:"positives"`
+	// Behaviors is the positive items, the latest first
+	Behaviors   []int     `json:"behaviors"`
+	UserFeature []float32 `json:"userFeature,omitempty"`
+	SessionId   string    `json:"sessionId,omitempty"`
+	Seen        []int     `json:"seen,omitempty"`
+}
+
+// Client keeps the local state of a user, it's safe for concurrent use
+type Client struct {
+	mu sync.Mutex
+	s  state
+}
+
+// New creates the Client of userId of no event
+func New(userId int) *Client {
+	return &Client{s: state{UserId: userId}}
+}
+
+// Record records an event of the user on itemId, the positive items, e.g.
+// the clicked ones, are prepended to the behavior sequence
+func (c *Client) Record(itemId int, positive bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.s.Events++
+	if !positive {
+		return
+	}
+	c.s.Positives++
+	behaviors := make([]int, 0, encoding.UserBehaviorLen)
+	behaviors = append(behaviors, itemId)
+	for _, id := range c.s.Behaviors {
+		if len(behaviors) == encoding.UserBehaviorLen {
+			break
+		}
+		behaviors = append(behaviors, id)
+	}
+	c.s.Behaviors = behaviors
+}
+
+// StartSession starts the session of sessionId, the items seen of the former
+// session are forgotten
+func (c *Client) StartSession(sessionId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.s.SessionId, c.s.Seen = sessionId, nil
+}
+
+// Shown records itemId as seen in the session, the oldest are forgotten
+// past MaxSeen
+func (c *Client) Shown(itemId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.s.Seen = append(c.s.Seen, itemId)
+	if len(c.s.Seen) > MaxSeen {
+		c.s.Seen = c.s.Seen[len(c.s.Seen)-MaxSeen:]
+	}
+}
+
+// SetUserFeature sets the user features of the model not of the edgerec
+// quickstart, nil restores the default
+func (c *Client) SetUserFeature(feature []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.s.UserFeature = append([]float32(nil), feature...)
+}
+
+func (c *Client) userFeature() []float32 {
+	if c.s.UserFeature != nil {
+		return append([]float32(nil), c.s.UserFeature...)
+	}
+	return encoding.CountFeature(c.s.Events, c.s.Positives)
+}
+
+// Profile returns the DeviceProfile of the user
+func (c *Client) Profile() *DeviceProfile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &DeviceProfile{UserFeature: c.userFeature(), Behaviors: append([]int(nil), c.s.Behaviors...)}
+}
+
+// EncodeUser returns the user part of the sample vector of the server, the
+// user profile and the behaviors of the item embeddings of emb, e.g. for the
+// models scoring on the device
+func (c *Client) EncodeUser(emb func(itemId int) ([]float32, bool)) []float32 {
+	p := c.Profile()
+	return append(p.UserFeature, encoding.BehaviorTensor(p.Behaviors, emb)...)
+}
+
+// Request returns the request of surface ranking itemIds, or the recalled
+// items if none
+func (c *Client) Request(surface string, itemIds ...int) *Request {
+	profile := c.Profile()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &Request{
+		UserId:        c.s.UserId,
+		ItemIdList:    append([]int(nil), itemIds...),
+		SessionId:     c.s.SessionId,
+		Surface:       surface,
+		SeenItemIds:   append([]int(nil), c.s.Seen...),
+		DeviceProfile: profile,
+	}
+}
+
+// RequestJSON is the body of the request of surface of the recalled items
+func (c *Client) RequestJSON(surface string) ([]byte, error) {
+	return c.Request(surface).JSON()
+}
+
+// State returns the local state to be persisted on the device
+func (c *Client) State() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(&c.s)
+}
+
+// Restore restores the local state returned by State
+func (c *Client) Restore(data []byte) error {
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("restore client state: %v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.s = s
+	return nil
+}
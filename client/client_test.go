@@ -0,0 +1,83 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClient(t *testing.T) {
+	Convey("local behaviors and features", t, func() {
+		c := New(7)
+		So(c.Profile().UserFeature, ShouldResemble, encoding.CountFeature(0, 0))
+		for i := 0; i < encoding.UserBehaviorLen+2; i++ {
+			c.Record(i, i%2 == 0)
+		}
+		p := c.Profile()
+		So(p.UserFeature, ShouldResemble, encoding.CountFeature(encoding.UserBehaviorLen+2, 6))
+		So(p.Behaviors, ShouldResemble, []int{10, 8, 6, 4, 2, 0})
+
+		c.SetUserFeature([]float32{1, 2, 3})
+		So(c.Profile().UserFeature, ShouldResemble, []float32{1, 2, 3})
+		c.SetUserFeature(nil)
+		So(c.Profile().UserFeature, ShouldResemble, p.UserFeature)
+
+		emb := func(itemId int) ([]float32, bool) {
+			if itemId != 8 {
+				return nil, false
+			}
+			e := make([]float32, encoding.ItemEmbDim)
+			e[0] = 1
+			return e, true
+		}
+		vec := c.EncodeUser(emb)
+		So(vec, ShouldHaveLength, 2+encoding.ItemEmbDim*encoding.UserBehaviorLen)
+		So(vec[2+encoding.ItemEmbDim], ShouldEqual, 1)
+	})
+
+	Convey("requests of the server api", t, func() {
+		c := New(7)
+		c.Record(3, true)
+		c.StartSession("s1")
+		for i := 0; i < MaxSeen+1; i++ {
+			c.Shown(i)
+		}
+		data, err := c.Request("feed", 1, 2).JSON()
+		So(err, ShouldBeNil)
+		var req rcmd.RecApiRequest
+		So(json.Unmarshal(data, &req), ShouldBeNil)
+		So(req.UserId, ShouldEqual, 7)
+		So(req.ItemIdList, ShouldResemble, []int{1, 2})
+		So(req.SessionId, ShouldEqual, "s1")
+		So(req.Surface, ShouldEqual, "feed")
+		So(req.SeenItemIds, ShouldHaveLength, MaxSeen)
+		So(req.SeenItemIds[0], ShouldEqual, 1)
+		So(req.DeviceProfile, ShouldResemble, &rcmd.DeviceProfile{
+			UserFeature: rcmd.Tensor(encoding.CountFeature(1, 1)),
+			Behaviors:   []int{3},
+		})
+
+		c.StartSession("s2")
+		data, err = c.RequestJSON("")
+		So(err, ShouldBeNil)
+		req = rcmd.RecApiRequest{}
+		So(json.Unmarshal(data, &req), ShouldBeNil)
+		So(req.ItemIdList, ShouldBeEmpty)
+		So(req.SeenItemIds, ShouldBeEmpty)
+	})
+
+	Convey("persisted state", t, func() {
+		c := New(7)
+		c.Record(3, true)
+		c.Record(4, false)
+		data, err := c.State()
+		So(err, ShouldBeNil)
+		restored := New(0)
+		So(restored.Restore(data), ShouldBeNil)
+		So(restored.Request(""), ShouldResemble, c.Request(""))
+		So(restored.Restore([]byte("{")), ShouldNotBeNil)
+	})
+}
@@ -1,6 +1,374 @@
+// Package config is the serving and training configuration of edgeRec.
+// Load reads it from a YAML file on top of the Default, then applies the
+// environment overrides and validates it:
+//
+//	cfg, err := config.Load("edgerec.yaml")
+//
+// Every key could be overridden by the environment variable of EnvPrefix and
+// the upper cased key path, e.g. serving.max_p99 by EDGEREC_SERVING_MAX_P99.
 package config
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const EnvPrefix = "EDGEREC"
+
 type Config struct {
-	DbType string `json:"db_type"` // mysql, sqlite
-	Dsn    string `json:"dsn"`
+	DbType string `json:"db_type" yaml:"db_type"` // mysql, sqlite
+	Dsn    string `json:"dsn" yaml:"dsn"`
+
+	Serving  ServingConfig  `json:"serving" yaml:"serving"`
+	Training TrainingConfig `json:"training" yaml:"training"`
+}
+
+type ServingConfig struct {
+	Addr string `json:"addr" yaml:"addr"`
+	Path string `json:"path" yaml:"path"`
+	// RateLimit is the requests per second of every client, 0 is unlimited
+	RateLimit float64 `json:"rate_limit" yaml:"rate_limit"`
+	RateBurst int     `json:"rate_burst" yaml:"rate_burst"`
+	// MaxP99 and MaxCPU are the load shedding thresholds, 0 disables them
+	MaxP99 time.Duration `json:"max_p99" yaml:"max_p99"`
+	MaxCPU float64       `json:"max_cpu" yaml:"max_cpu"`
+	// WarmUpRounds of scoring before ready, 0 disables the warm-up
+	WarmUpRounds int `json:"warm_up_rounds" yaml:"warm_up_rounds"`
+	// the time budgets of the ranking stages, 0 is unlimited
+	RecallTimeout  time.Duration `json:"recall_timeout" yaml:"recall_timeout"`
+	FeatureTimeout time.Duration `json:"feature_timeout" yaml:"feature_timeout"`
+	ScoringTimeout time.Duration `json:"scoring_timeout" yaml:"scoring_timeout"`
+	ReRankTimeout  time.Duration `json:"rerank_timeout" yaml:"rerank_timeout"`
+	// MaxCandidates is the recalled candidates scored, the others are
+	// dropped as the counterfactual recall, 0 scores all
+	MaxCandidates int `json:"max_candidates" yaml:"max_candidates"`
+	// RulesPath is the rerank rules file, empty disables the rerank
+	RulesPath   string        `json:"rules_path" yaml:"rules_path"`
+	RulesReload time.Duration `json:"rules_reload" yaml:"rules_reload"`
+	// UtilityPath is the utility formula file of the value model rerank, it
+	// blends the CTR with the business values, see package utility. Empty
+	// disables the value model rerank.
+	UtilityPath   string        `json:"utility_path" yaml:"utility_path"`
+	UtilityReload time.Duration `json:"utility_reload" yaml:"utility_reload"`
+	// IndexRebuild is the interval of the background rebuilds of the ANN
+	// index of the item embeddings and the item CF of the samples, 0
+	// disables the indexes. The requests of the surface "index" are recalled
+	// by them and the item CF explains the items served.
+	IndexRebuild time.Duration `json:"index_rebuild" yaml:"index_rebuild"`
+}
+
+type TrainingConfig struct {
+	// Model is one of mlp, din, bst, pnn, youtube, wide, gbdt. gbdt is the
+	// trees of package gbdt scoring the samples, trained by the default
+	// options of gbdt.Fitter instead of the batches and the learning rate.
+	Model     string `json:"model" yaml:"model"`
+	SampleCnt int    `json:"sample_cnt" yaml:"sample_cnt"`
+	Epochs    int    `json:"epochs" yaml:"epochs"`
+	BatchSize int    `json:"batch_size" yaml:"batch_size"`
+	// EarlyStop is the epochs of no loss improvement the training stops
+	// after, 0 never stops early
+	EarlyStop    int     `json:"early_stop" yaml:"early_stop"`
+	LearningRate float64 `json:"learning_rate" yaml:"learning_rate"`
+	// Dropout of the hidden layers of the din, bst, pnn and youtube models,
+	// the default of the model if 0
+	Dropout float64 `json:"dropout" yaml:"dropout"`
+	// LatentCross gates the first hidden layer by the ctx features instead of
+	// concatenating them, of the din and wide models, see din.WithLatentCross
+	// and wide.Options
+	LatentCross bool `json:"latent_cross" yaml:"latent_cross"`
+	// TreeModel feeds the GBDT leaves into the neural Model, gbdt trains the
+	// trees on the same samples, otherwise it's the LightGBM or XGBoost model
+	// file to import. Empty disables the leaf features.
+	TreeModel string `json:"tree_model" yaml:"tree_model"`
+	// LeafEncoding of the leaves is onehot or index, onehot by default. The
+	// leaf ids of index are looked up by the wide model only, see
+	// gbdt.LeafIndex
+	LeafEncoding string `json:"leaf_encoding" yaml:"leaf_encoding"`
+	// ItemContent is the .csv or .npy file of the item content embeddings
+	// appended to the item features, see rcmd.ReadContentCSV and
+	// rcmd.ReadContentNPY. Empty disables the content features.
+	ItemContent string `json:"item_content" yaml:"item_content"`
+}
+
+// Default is the configuration of the MovieLens demo
+func Default() *Config {
+	return &Config{
+		DbType: "sqlite",
+		Dsn:    "movielens.db",
+		Serving: ServingConfig{
+			Addr:          ":8080",
+			Path:          "/api/v1/recommend",
+			RateBurst:     10,
+			RulesReload:   30 * time.Second,
+			UtilityReload: 30 * time.Second,
+		},
+		Training: TrainingConfig{
+			Model:        "mlp",
+			SampleCnt:    80000,
+			Epochs:       20,
+			BatchSize:    200,
+			LearningRate: 0.001,
+		},
+	}
+}
+
+// Load reads the YAML file of path over the Default, applies the environment
+// overrides and validates the result. Empty path loads the Default.
+func Load(path string) (cfg *Config, err error) {
+	cfg = Default()
+	if path != "" {
+		var data []byte
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		// unknown keys are errors, they are usually typos
+		dec.KnownFields(true)
+		if err = dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parse config %s: %v", path, err)
+		}
+	}
+	if err = cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// KeyError is an invalid value of Key
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("config %s: %v", e.Key, e.Err)
+}
+
+// ValidationError aggregates all the invalid keys found by Validate
+type ValidationError struct {
+	Errors []*KeyError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, m := range e.Errors {
+		msgs[i] = m.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ApplyEnv overrides the keys having the environment variable set, lookup
+// is usually os.LookupEnv
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	return walk(reflect.ValueOf(c).Elem(), "", func(key string, v reflect.Value) error {
+		env := envName(key)
+		s, ok := lookup(env)
+		if !ok {
+			return nil
+		}
+		if err := setValue(v, s); err != nil {
+			return &KeyError{Key: key, Err: fmt.Errorf("env %s=%q: %v", env, s, err)}
+		}
+		return nil
+	})
+}
+
+// envName is the environment variable of key, e.g. EDGEREC_SERVING_ADDR
+func envName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// walk calls fn with the dotted yaml key of every leaf field of v
+func walk(v reflect.Value, prefix string, fn func(key string, v reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := walk(field, key, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(key, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported kind %s", v.Kind())
+	}
+	return nil
+}
+
+// Validate returns all the invalid keys in one *ValidationError, or nil
+func (c *Config) Validate() (err error) {
+	var errs []*KeyError
+	addf := func(key, format string, a ...interface{}) {
+		errs = append(errs, &KeyError{Key: key, Err: fmt.Errorf(format, a...)})
+	}
+
+	switch c.DbType {
+	case "mysql", "sqlite":
+	default:
+		addf("db_type", "%q should be mysql or sqlite", c.DbType)
+	}
+	if c.Dsn == "" {
+		addf("dsn", "should not be empty")
+	}
+
+	s := &c.Serving
+	if s.Addr == "" {
+		addf("serving.addr", "should not be empty")
+	}
+	if !strings.HasPrefix(s.Path, "/") {
+		addf("serving.path", "%q should start with /", s.Path)
+	}
+	if s.RateLimit < 0 {
+		addf("serving.rate_limit", "%v should not be negative", s.RateLimit)
+	}
+	if s.RateLimit > 0 && s.RateBurst < 1 {
+		addf("serving.rate_burst", "%d should be positive with rate_limit", s.RateBurst)
+	}
+	if s.MaxP99 < 0 {
+		addf("serving.max_p99", "%v should not be negative", s.MaxP99)
+	}
+	if s.MaxCPU < 0 || s.MaxCPU > 1 {
+		addf("serving.max_cpu", "%v should be in [0, 1]", s.MaxCPU)
+	}
+	for _, f := range []struct {
+		key string
+		val time.Duration
+	}{
+		{"serving.recall_timeout", s.RecallTimeout},
+		{"serving.feature_timeout", s.FeatureTimeout},
+		{"serving.scoring_timeout", s.ScoringTimeout},
+		{"serving.rerank_timeout", s.ReRankTimeout},
+	} {
+		if f.val < 0 {
+			addf(f.key, "%v should not be negative", f.val)
+		}
+	}
+	if s.MaxCandidates < 0 {
+		addf("serving.max_candidates", "%d should not be negative", s.MaxCandidates)
+	}
+	if s.WarmUpRounds < 0 {
+		addf("serving.warm_up_rounds", "%d should not be negative", s.WarmUpRounds)
+	}
+	if s.RulesPath != "" && s.RulesReload <= 0 {
+		addf("serving.rules_reload", "%v should be positive with rules_path", s.RulesReload)
+	}
+	if s.UtilityPath != "" && s.UtilityReload <= 0 {
+		addf("serving.utility_reload", "%v should be positive with utility_path", s.UtilityReload)
+	}
+	if s.IndexRebuild < 0 {
+		addf("serving.index_rebuild", "%v should not be negative", s.IndexRebuild)
+	}
+
+	t := &c.Training
+	switch t.Model {
+	case "mlp", "din", "bst", "pnn", "youtube", "wide", "gbdt":
+	default:
+		addf("training.model", "%q should be one of mlp, din, bst, pnn, youtube, wide, gbdt", t.Model)
+	}
+	for _, f := range []struct {
+		key string
+		val int
+	}{
+		{"training.sample_cnt", t.SampleCnt},
+		{"training.epochs", t.Epochs},
+		{"training.batch_size", t.BatchSize},
+	} {
+		if f.val <= 0 {
+			addf(f.key, "%d should be positive", f.val)
+		}
+	}
+	if t.EarlyStop < 0 {
+		addf("training.early_stop", "%d should not be negative", t.EarlyStop)
+	}
+	if t.LearningRate <= 0 {
+		addf("training.learning_rate", "%v should be positive", t.LearningRate)
+	}
+	if t.Dropout < 0 || t.Dropout >= 1 {
+		addf("training.dropout", "%v should be in [0, 1)", t.Dropout)
+	} else if t.Dropout > 0 && (t.Model == "mlp" || t.Model == "wide" || t.Model == "gbdt") {
+		addf("training.dropout", "%v should be 0 of the %s model", t.Dropout, t.Model)
+	}
+	if t.LatentCross && t.Model != "din" && t.Model != "wide" {
+		addf("training.latent_cross", "should be of the din or wide model, not %q", t.Model)
+	}
+	if t.TreeModel != "" && t.Model == "gbdt" {
+		addf("training.tree_model", "%q should be empty of the gbdt model", t.TreeModel)
+	}
+	switch t.LeafEncoding {
+	case "", "onehot":
+	case "index":
+		if t.Model != "wide" {
+			addf("training.leaf_encoding", "index should be of the wide model, not %q", t.Model)
+		}
+	default:
+		addf("training.leaf_encoding", "%q should be onehot or index", t.LeafEncoding)
+	}
+	switch ext := strings.ToLower(filepath.Ext(t.ItemContent)); {
+	case t.ItemContent == "", ext == ".csv", ext == ".npy":
+	default:
+		addf("training.item_content", "%q should be a .csv or .npy file", t.ItemContent)
+	}
+
+	if len(errs) != 0 {
+		err = &ValidationError{Errors: errs}
+	}
+	return
 }
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfig(t *testing.T) {
+	Convey("default", t, func() {
+		cfg, err := Load("")
+		So(err, ShouldBeNil)
+		So(cfg, ShouldResemble, Default())
+	})
+
+	Convey("load yaml with env overrides", t, func() {
+		path := filepath.Join(t.TempDir(), "edgerec.yaml")
+		So(os.WriteFile(path, []byte(`
+db_type: mysql
+dsn: "user:pass@/rec"
+serving:
+  addr: ":9090"
+  max_p99: 150ms
+training:
+  model: din
+  epochs: 5
+`), 0644), ShouldBeNil)
+		t.Setenv("EDGEREC_SERVING_MAX_CPU", "0.8")
+		t.Setenv("EDGEREC_TRAINING_EPOCHS", "7")
+		cfg, err := Load(path)
+		So(err, ShouldBeNil)
+		So(cfg.DbType, ShouldEqual, "mysql")
+		So(cfg.Serving.Addr, ShouldEqual, ":9090")
+		So(cfg.Serving.MaxP99, ShouldEqual, 150*time.Millisecond)
+		So(cfg.Serving.MaxCPU, ShouldEqual, 0.8)
+		So(cfg.Training.Model, ShouldEqual, "din")
+		So(cfg.Training.Epochs, ShouldEqual, 7)
+		// not set keys keep the defaults
+		So(cfg.Serving.Path, ShouldEqual, "/api/v1/recommend")
+		So(cfg.Training.BatchSize, ShouldEqual, 200)
+	})
+
+	Convey("errors name the offending key", t, func() {
+		path := filepath.Join(t.TempDir(), "edgerec.yaml")
+		So(os.WriteFile(path, []byte("serving:\n  adr: \":9090\"\n"), 0644), ShouldBeNil)
+		_, err := Load(path)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "adr")
+
+		cfg := Default()
+		err = cfg.ApplyEnv(func(key string) (string, bool) {
+			return "fast", key == "EDGEREC_SERVING_RULES_RELOAD"
+		})
+		var keyErr *KeyError
+		So(errors.As(err, &keyErr), ShouldBeTrue)
+		So(keyErr.Key, ShouldEqual, "serving.rules_reload")
+
+		cfg = Default()
+		cfg.DbType = "oracle"
+		cfg.Serving.MaxCPU = 2
+		cfg.Serving.UtilityPath = "utility.yaml"
+		cfg.Serving.UtilityReload = 0
+		cfg.Serving.IndexRebuild = -time.Minute
+		cfg.Serving.MaxCandidates = -1
+		cfg.Training.BatchSize = 0
+		cfg.Training.Dropout = 0.1
+		cfg.Training.Model = "gbdt"
+		cfg.Training.TreeModel = "gbdt"
+		cfg.Training.LatentCross = true
+		cfg.Training.LeafEncoding = "hash"
+		cfg.Training.ItemContent = "images.txt"
+		err = cfg.Validate()
+		var validationErr *ValidationError
+		So(errors.As(err, &validationErr), ShouldBeTrue)
+		keys := make([]string, len(validationErr.Errors))
+		for i, e := range validationErr.Errors {
+			keys[i] = e.Key
+		}
+		So(keys, ShouldResemble, []string{"db_type", "serving.max_cpu", "serving.max_candidates", "serving.utility_reload", "serving.index_rebuild",
+			"training.batch_size", "training.dropout", "training.latent_cross", "training.tree_model", "training.leaf_encoding", "training.item_content"})
+
+		// the leaf ids of the wide model
+		cfg = Default()
+		cfg.Training.TreeModel = "gbdt"
+		cfg.Training.LeafEncoding = "index"
+		err = cfg.Validate()
+		So(errors.As(err, &validationErr), ShouldBeTrue)
+		So(validationErr.Errors[0].Key, ShouldEqual, "training.leaf_encoding")
+		cfg.Training.Model = "wide"
+		So(cfg.Validate(), ShouldBeNil)
+
+		// the latent cross of the din and wide models
+		for _, m := range []string{"din", "wide"} {
+			cfg = Default()
+			cfg.Training.Model = m
+			cfg.Training.LatentCross = true
+			So(cfg.Validate(), ShouldBeNil)
+		}
+	})
+}
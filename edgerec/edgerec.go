@@ -0,0 +1,173 @@
+// Package edgerec is the quickstart API of edgeRec, the recommender of the
+// fed events with the defaults of the storage, features and model wired:
+//
+//	rec, err := edgerec.New(edgerec.Config{})
+//	err = rec.Feed([]edgerec.Event{{UserId: 1, ItemId: 2, Label: 1, Timestamp: ts}})
+//	err = rec.Train(ctx)
+//	top10, err := rec.Recommend(1, 10)
+//
+// The events are kept in the Store, in memory by default or persisted of
+// OpenStore, every Train trains on all of them. The user and item features
+// are the event counts and the positive rates as of the sample time, and the
+// item embeddings are trained on the positive item sequences of the users.
+// The model is the MLP of package mlp by default, any rcmd.Fitter could be
+// set instead. The packages recommend and model are the full API under it.
+package edgerec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/auxten/go-ctr/model/mlp"
+	nn "github.com/auxten/go-ctr/nn/neural_network"
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+const (
+	DefaultEpochs     = 10
+	DefaultNegatives  = 1
+	DefaultCandidates = 1000
+)
+
+// ErrNotTrained is returned by Recommend before the first Train
+var ErrNotTrained = errors.New("edgerec: recommend before train")
+
+// snapshots numbers the snapshots of all the Recommenders of the process
+var snapshots uint64
+
+// Config of New, the zero values are the defaults
+type Config struct {
+	// Store of the events, a MemoryStore by default
+	Store Store
+	// Fitter of the model, the MLP of Epochs by default
+	Fitter rcmd.Fitter
+	Epochs int
+	// Negatives is the random items sampled as the negatives of every
+	// positive event. By default DefaultNegatives are sampled if no event
+	// fed is negative, otherwise none.
+	Negatives int
+	Seed      int64
+	// Candidates is the most popular items ranked by Recommend
+	Candidates int
+}
+
+// Recommender is the recommender of the fed events
+type Recommender struct {
+	conf Config
+
+	mu    sync.RWMutex
+	index *featureIndex
+	model rcmd.Predictor
+	// snapshot serves model by the feature caches and the item embeddings
+	// of its own, so the features of the other snapshots or Recommenders are
+	// never served
+	snapshot *rcmd.Tenant
+}
+
+// New returns the Recommender of conf
+func New(conf Config) (*Recommender, error) {
+	if conf.Epochs < 0 || conf.Negatives < 0 || conf.Candidates < 0 {
+		return nil, fmt.Errorf("edgerec config of negative epochs %d, negatives %d or candidates %d",
+			conf.Epochs, conf.Negatives, conf.Candidates)
+	}
+	if conf.Store == nil {
+		conf.Store = NewMemoryStore()
+	}
+	if conf.Epochs == 0 {
+		conf.Epochs = DefaultEpochs
+	}
+	if conf.Candidates == 0 {
+		conf.Candidates = DefaultCandidates
+	}
+	return &Recommender{conf: conf}, nil
+}
+
+// Feed appends events to the Store, they are trained by the next Train
+func (r *Recommender) Feed(events []Event) error {
+	for _, e := range events {
+		if e.Label != 0 && e.Label != 1 {
+			return fmt.Errorf("event of user %d item %d label %v not 0 or 1", e.UserId, e.ItemId, e.Label)
+		}
+	}
+	return r.conf.Store.Append(events)
+}
+
+// Train trains the model of all the events fed, Recommend serves the last
+// model trained
+func (r *Recommender) Train(ctx context.Context) (err error) {
+	events, err := r.conf.Store.Events()
+	if err != nil {
+		return
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("edgerec train of no event")
+	}
+	negatives := r.conf.Negatives
+	if negatives == 0 {
+		negatives = DefaultNegatives
+		for _, e := range events {
+			if e.Label == 0 {
+				negatives = 0
+				break
+			}
+		}
+	}
+	index := newFeatureIndex(events, negatives, r.conf.Seed)
+	fitter := r.conf.Fitter
+	if fitter == nil {
+		classifier := nn.NewMLPClassifier([]int{100}, "relu", "adam", 1e-5)
+		classifier.MaxIter = r.conf.Epochs
+		fitter = &mlp.SimpleMlpFitWrap{Model: classifier}
+	}
+	// the features cached by the training are of the snapshot scope, the
+	// ones of the previous snapshots are left to the LRU of the caches
+	name := "edgerec/" + strconv.FormatUint(atomic.AddUint64(&snapshots, 1), 10)
+	model, err := rcmd.Train(rcmd.WithFeatureScope(ctx, name), index, fitter)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	r.index, r.model, r.snapshot = index, model, rcmd.NewTenant(name, model)
+	r.mu.Unlock()
+	return
+}
+
+// Recommend returns the top k items of userId by the score desc, of the
+// Candidates most popular items not in the positive events of the user
+func (r *Recommender) Recommend(userId, k int) (items []rcmd.ItemScore, err error) {
+	r.mu.RLock()
+	index, model, snapshot := r.index, r.model, r.snapshot
+	r.mu.RUnlock()
+	if model == nil {
+		return nil, ErrNotTrained
+	}
+	seen := make(map[int]bool)
+	for _, item := range index.user(userId).items {
+		seen[item] = true
+	}
+	var candidates []int
+	for _, item := range index.popular {
+		if len(candidates) == r.conf.Candidates {
+			break
+		}
+		if !seen[item] {
+			candidates = append(candidates, item)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	if items, err = rcmd.Rank(rcmd.WithTenant(context.Background(), snapshot), model, userId, candidates); err != nil {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Score > items[j].Score })
+	if k >= 0 && len(items) > k {
+		items = items[:k]
+	}
+	return
+}
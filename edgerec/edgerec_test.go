@@ -0,0 +1,248 @@
+package edgerec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/auxten/go-ctr/client"
+	"github.com/auxten/go-ctr/model/encryption"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecommender(t *testing.T) {
+	userCache, itemCache := rcmd.UserFeatureCache, rcmd.ItemFeatureCache
+	defer func() {
+		rcmd.UserFeatureCache, rcmd.ItemFeatureCache = userCache, itemCache
+	}()
+	// the even users like the items [0, 50), the odd users [50, 100)
+	rnd := rand.New(rand.NewSource(1))
+	var events []Event
+	for ts := int64(0); ts < 2000; ts++ {
+		user := rnd.Intn(100)
+		item := rnd.Intn(50) + user%2*50
+		events = append(events, Event{UserId: user, ItemId: item, Label: 1, Timestamp: ts})
+	}
+
+	Convey("recommend after train", t, func() {
+		rec, err := New(Config{Seed: 1})
+		So(err, ShouldBeNil)
+		_, err = rec.Recommend(0, 10)
+		So(err, ShouldEqual, ErrNotTrained)
+		So(rec.Train(context.Background()), ShouldNotBeNil)
+
+		So(rec.Feed(events), ShouldBeNil)
+		So(rec.Feed([]Event{{UserId: 1, ItemId: 1, Label: 2}}), ShouldNotBeNil)
+		So(rec.Train(context.Background()), ShouldBeNil)
+
+		// the cold user of no event
+		items, err := rec.Recommend(100, 10)
+		So(err, ShouldBeNil)
+		So(items, ShouldHaveLength, 10)
+		for i := 1; i < len(items); i++ {
+			So(items[i].Score, ShouldBeLessThanOrEqualTo, items[i-1].Score)
+		}
+
+		var hits, total int
+		for user := 0; user < 100; user++ {
+			seen := make(map[int]bool)
+			for _, item := range rec.index.user(user).items {
+				seen[item] = true
+			}
+			items, err = rec.Recommend(user, 3)
+			So(err, ShouldBeNil)
+			for _, item := range items {
+				So(seen[item.ItemId], ShouldBeFalse)
+				if item.ItemId/50 == user%2 {
+					hits++
+				}
+				total++
+			}
+		}
+		So(total, ShouldBeGreaterThan, 0)
+		So(float64(hits)/float64(total), ShouldBeGreaterThan, 0.8)
+
+		// the features cached are of the snapshot, the Train of another
+		// Recommender serves none of them
+		before, err := rec.Recommend(0, 10)
+		So(err, ShouldBeNil)
+		other, err := New(Config{Seed: 2, Epochs: 1})
+		So(err, ShouldBeNil)
+		So(other.Feed([]Event{{UserId: 0, ItemId: 1, Label: 1, Timestamp: 1}, {UserId: 1, ItemId: 2, Label: 1, Timestamp: 2}}), ShouldBeNil)
+		So(other.Train(context.Background()), ShouldBeNil)
+		So(other.snapshot, ShouldNotEqual, rec.snapshot)
+		after, err := rec.Recommend(0, 10)
+		So(err, ShouldBeNil)
+		So(after, ShouldResemble, before)
+	})
+
+	Convey("config", t, func() {
+		_, err := New(Config{Epochs: -1})
+		So(err, ShouldNotBeNil)
+		rec, err := New(Config{})
+		So(err, ShouldBeNil)
+		So(rec.conf.Epochs, ShouldEqual, DefaultEpochs)
+		So(rec.conf.Candidates, ShouldEqual, DefaultCandidates)
+	})
+}
+
+func TestFeatureIndex(t *testing.T) {
+	idx := newFeatureIndex([]Event{
+		{UserId: 1, ItemId: 1, Label: 1, Timestamp: 10},
+		{UserId: 1, ItemId: 2, Label: 0, Timestamp: 20},
+		{UserId: 1, ItemId: 3, Label: 1, Timestamp: 30},
+		{UserId: 2, ItemId: 3, Label: 1, Timestamp: 5},
+	}, 0, 0)
+
+	Convey("features as of the sample time", t, func() {
+		ctx := context.Background()
+		f, err := idx.GetUserFeatureAsOf(ctx, 1, 30)
+		So(err, ShouldBeNil)
+		So(f[1], ShouldEqual, float32(2)/4)
+		f, err = idx.GetUserFeature(ctx, 1)
+		So(err, ShouldBeNil)
+		So(f[1], ShouldEqual, float32(3)/5)
+		f, err = idx.GetItemFeatureAsOf(ctx, 3, 5)
+		So(err, ShouldBeNil)
+		So(f, ShouldResemble, rcmd.Tensor{0, 0.5})
+		So(idx.popular, ShouldResemble, []int{3, 1, 2})
+		So(idx.samples, ShouldHaveLength, 4)
+
+		seq, err := idx.GetUserBehavior(ctx, 1, 10, -1, 29)
+		So(err, ShouldBeNil)
+		So(seq, ShouldResemble, []int{1})
+		seq, err = idx.GetUserBehavior(ctx, 1, 1, -1, -1)
+		So(err, ShouldBeNil)
+		So(seq, ShouldResemble, []int{3})
+	})
+
+	Convey("negatives of the positives", t, func() {
+		idx := newFeatureIndex([]Event{
+			{UserId: 1, ItemId: 1, Label: 1, Timestamp: 10},
+			{UserId: 1, ItemId: 2, Label: 1, Timestamp: 20},
+		}, 3, 1)
+		var negatives int
+		for _, s := range idx.samples {
+			if s.Label == 0 {
+				negatives++
+				So(s.ItemId, ShouldBeIn, []int{1, 2})
+			}
+		}
+		So(negatives, ShouldBeBetweenOrEqual, 1, 6)
+	})
+
+	Convey("client features are the served ones", t, func() {
+		var events []Event
+		c := client.New(1)
+		for ts := int64(0); ts < 30; ts++ {
+			e := Event{UserId: 1, ItemId: int(ts % 7), Label: float32(ts % 3 / 2), Timestamp: ts}
+			events = append(events, e)
+			c.Record(e.ItemId, e.Label == 1)
+		}
+		idx := newFeatureIndex(events, 0, 1)
+		ctx := context.Background()
+		f, err := idx.GetUserFeature(ctx, 1)
+		So(err, ShouldBeNil)
+		seq, err := idx.GetUserBehavior(ctx, 1, rcmd.UserBehaviorLen, -1, -1)
+		So(err, ShouldBeNil)
+		p := c.Profile()
+		So(p.UserFeature, ShouldResemble, []float32(f))
+		So(p.Behaviors, ShouldResemble, seq)
+	})
+}
+
+func TestStore(t *testing.T) {
+	events := []Event{
+		{UserId: 1, ItemId: 2, Label: 1, Timestamp: 10},
+		{UserId: 1, ItemId: 3, Label: 0, Timestamp: 20},
+	}
+
+	Convey("file store of the events", t, func() {
+		path := filepath.Join(t.TempDir(), "events.jsonl")
+		s, err := NewFileStore(path)
+		So(err, ShouldBeNil)
+		So(s.Append(events), ShouldBeNil)
+		So(s.Append(events[:1]), ShouldBeNil)
+		So(s.Close(), ShouldBeNil)
+
+		// the torn last line of the crash is skipped
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		So(err, ShouldBeNil)
+		_, err = f.WriteString(`{"userId":9`)
+		So(err, ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		s, err = NewFileStore(path)
+		So(err, ShouldBeNil)
+		defer s.Close()
+		read, err := s.Events()
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, append(events, events[0]))
+	})
+
+	Convey("default persistent store", t, func() {
+		s, err := OpenStore(filepath.Join(t.TempDir(), "events.db"))
+		So(err, ShouldBeNil)
+		defer s.(io.Closer).Close()
+		So(s.Append(events), ShouldBeNil)
+		read, err := s.Events()
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, events)
+		_, isFile := s.(*FileStore)
+		So(isFile, ShouldEqual, !SQLiteEnabled)
+	})
+
+	Convey("stores sealed of the storage key", t, func() {
+		defer encryption.SetKeyProvider(nil)
+		defer encryption.SetAllowPlaintext(false)
+		key := bytes.Repeat([]byte{7}, 32)
+		for _, open := range []func(path string) (Store, error){
+			func(path string) (Store, error) { return NewFileStore(path) },
+			OpenStore,
+		} {
+			dir := t.TempDir()
+			plainPath, sealedPath := filepath.Join(dir, "plain"), filepath.Join(dir, "sealed")
+			encryption.SetKeyProvider(func() ([]byte, error) { return nil, nil })
+			s, err := open(plainPath)
+			So(err, ShouldBeNil)
+			So(s.Append(events[:1]), ShouldBeNil)
+			So(s.(io.Closer).Close(), ShouldBeNil)
+
+			encryption.SetKeyProvider(func() ([]byte, error) { return key, nil })
+			s, err = open(sealedPath)
+			So(err, ShouldBeNil)
+			So(s.Append(events), ShouldBeNil)
+			So(s.(io.Closer).Close(), ShouldBeNil)
+			data, err := os.ReadFile(sealedPath)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(data, []byte("userId")), ShouldBeFalse)
+			s, err = open(sealedPath)
+			So(err, ShouldBeNil)
+			read, err := s.Events()
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, events)
+			So(s.(io.Closer).Close(), ShouldBeNil)
+
+			// the plain store is refused of the key unless allowed
+			if s, err = open(plainPath); err == nil {
+				_, err = s.Events()
+				So(s.(io.Closer).Close(), ShouldBeNil)
+			}
+			So(err, ShouldNotBeNil)
+			encryption.SetAllowPlaintext(true)
+			s, err = open(plainPath)
+			So(err, ShouldBeNil)
+			So(s.Append(events[1:]), ShouldBeNil)
+			read, err = s.Events()
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, events)
+			So(s.(io.Closer).Close(), ShouldBeNil)
+			encryption.SetAllowPlaintext(false)
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package edgerec
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/encoding"
+)
+
+// history is the events of a user or an item in time order
+type history struct {
+	ts []int64
+	// positives[i] is the positives of the events before i, of len(ts)+1
+	positives []int
+	// items and itemTs are the positive items of a user
+	items  []int
+	itemTs []int64
+}
+
+// before returns the events and the positives strictly before ts
+func (h *history) before(ts int64) (events, positives int) {
+	events = sort.Search(len(h.ts), func(i int) bool { return h.ts[i] >= ts })
+	return events, h.positives[events]
+}
+
+// feature is the log event count and the smoothed positive rate
+func (h *history) feature(events, positives int) rcmd.Tensor {
+	return encoding.CountFeature(events, positives)
+}
+
+// emptyHistory is the history of the users and items of no event
+var emptyHistory = &history{positives: []int{0}}
+
+// featureIndex is the snapshot of the events of a Train, it's the
+// rcmd.RecSys of the user and item histories. The features of the training
+// samples are as of the sample time, the serving features are of all the
+// events of the snapshot.
+type featureIndex struct {
+	users map[int]*history
+	items map[int]*history
+	// popular is the item ids by the positives desc
+	popular []int
+	samples []rcmd.Sample
+}
+
+// newFeatureIndex indexes events, negatives random items are sampled as the
+// negatives of every positive event
+func newFeatureIndex(events []Event, negatives int, seed int64) *featureIndex {
+	sorted := append([]Event(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp < sorted[j].Timestamp })
+	idx := &featureIndex{users: make(map[int]*history), items: make(map[int]*history)}
+	add := func(histories map[int]*history, id int, e Event) *history {
+		h := histories[id]
+		if h == nil {
+			h = &history{positives: []int{0}}
+			histories[id] = h
+		}
+		h.ts = append(h.ts, e.Timestamp)
+		h.positives = append(h.positives, h.positives[len(h.positives)-1]+int(e.Label))
+		return h
+	}
+	for _, e := range sorted {
+		if u := add(idx.users, e.UserId, e); e.Label == 1 {
+			u.items = append(u.items, e.ItemId)
+			u.itemTs = append(u.itemTs, e.Timestamp)
+		}
+		add(idx.items, e.ItemId, e)
+		idx.samples = append(idx.samples, rcmd.Sample{
+			UserId: e.UserId, ItemId: e.ItemId, Label: e.Label, Timestamp: e.Timestamp,
+		})
+	}
+	for id := range idx.items {
+		idx.popular = append(idx.popular, id)
+	}
+	sort.Slice(idx.popular, func(i, j int) bool {
+		pi := idx.items[idx.popular[i]].positives
+		pj := idx.items[idx.popular[j]].positives
+		if pi[len(pi)-1] != pj[len(pj)-1] {
+			return pi[len(pi)-1] > pj[len(pj)-1]
+		}
+		return idx.popular[i] < idx.popular[j]
+	})
+
+	if negatives > 0 && len(idx.popular) > 1 {
+		rnd := rand.New(rand.NewSource(seed))
+		for _, e := range sorted {
+			if e.Label != 1 {
+				continue
+			}
+			for n := 0; n < negatives; n++ {
+				item := idx.popular[rnd.Intn(len(idx.popular))]
+				if item == e.ItemId {
+					continue
+				}
+				idx.samples = append(idx.samples, rcmd.Sample{
+					UserId: e.UserId, ItemId: item, Label: 0, Timestamp: e.Timestamp,
+				})
+			}
+		}
+	}
+	return idx
+}
+
+func (idx *featureIndex) user(userId int) *history {
+	if h := idx.users[userId]; h != nil {
+		return h
+	}
+	return emptyHistory
+}
+
+func (idx *featureIndex) item(itemId int) *history {
+	if h := idx.items[itemId]; h != nil {
+		return h
+	}
+	return emptyHistory
+}
+
+func (idx *featureIndex) GetUserFeature(_ context.Context, userId int) (rcmd.Tensor, error) {
+	h := idx.user(userId)
+	return h.feature(len(h.ts), h.positives[len(h.ts)]), nil
+}
+
+func (idx *featureIndex) GetItemFeature(_ context.Context, itemId int) (rcmd.Tensor, error) {
+	h := idx.item(itemId)
+	return h.feature(len(h.ts), h.positives[len(h.ts)]), nil
+}
+
+func (idx *featureIndex) GetUserFeatureAsOf(_ context.Context, userId int, ts int64) (rcmd.Tensor, error) {
+	h := idx.user(userId)
+	return h.feature(h.before(ts)), nil
+}
+
+func (idx *featureIndex) GetItemFeatureAsOf(_ context.Context, itemId int, ts int64) (rcmd.Tensor, error) {
+	h := idx.item(itemId)
+	return h.feature(h.before(ts)), nil
+}
+
+// GetUserBehavior implements rcmd.UserBehavior of the positive items of the
+// user, the latest first
+func (idx *featureIndex) GetUserBehavior(_ context.Context, userId int,
+	maxLen int64, _ int64, maxTs int64) (itemSeq []int, err error) {
+	h := idx.user(userId)
+	end := len(h.items)
+	if maxTs >= 0 {
+		end = sort.Search(len(h.itemTs), func(i int) bool { return h.itemTs[i] > maxTs })
+	}
+	for i := end - 1; i >= 0 && (maxLen < 0 || int64(len(itemSeq)) < maxLen); i-- {
+		itemSeq = append(itemSeq, h.items[i])
+	}
+	return
+}
+
+// ItemSeqGenerator implements rcmd.ItemEmbedding of the positive items of
+// every user in time order
+func (idx *featureIndex) ItemSeqGenerator(ctx context.Context) (<-chan string, error) {
+	ch := make(chan string, 100)
+	go func() {
+		defer close(ch)
+		users := make([]int, 0, len(idx.users))
+		for id := range idx.users {
+			users = append(users, id)
+		}
+		sort.Ints(users)
+		for _, id := range users {
+			for _, item := range idx.users[id].items {
+				select {
+				case ch <- strconv.Itoa(item):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// SampleOptions implements rcmd.SampleOptioner, the behaviors of a sample
+// exclude its own event
+func (idx *featureIndex) SampleOptions() rcmd.SampleOptions {
+	return rcmd.SampleOptions{Dedup: true, PointInTime: true}
+}
+
+func (idx *featureIndex) SampleGenerator(ctx context.Context) (<-chan rcmd.Sample, error) {
+	ch := make(chan rcmd.Sample, 100)
+	go func() {
+		defer close(ch)
+		for _, s := range idx.samples {
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,89 @@
+package edgerec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/auxten/go-ctr/model/encryption"
+)
+
+// FileStore is the Store of the append only file of the Event json lines,
+// the pure Go one of no cgo, so it's the default of OpenStore in the builds
+// without SQLite, e.g. of the cross builds to ARM or Windows. The lines are
+// sealed of the storage key if set, see package encryption.
+type FileStore struct {
+	mu     sync.RWMutex
+	file   *os.File
+	events []Event
+}
+
+// NewFileStore opens the store of path, the events of it are read if it
+// exists. The plain lines are refused of the storage key set unless allowed
+// by encryption.AllowPlaintextEnv.
+func NewFileStore(path string) (s *FileStore, err error) {
+	s = &FileStore{}
+	if s.file, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(s.file)
+	for {
+		line, er := reader.ReadBytes('\n')
+		if er == io.EOF {
+			// the last line could be torn by the crash, it's skipped
+			break
+		}
+		if er != nil {
+			s.file.Close()
+			return nil, er
+		}
+		if line = bytes.TrimRight(line, "\n"); len(line) == 0 {
+			continue
+		}
+		if line, er = encryption.DecodeLine(line); er != nil {
+			s.file.Close()
+			return nil, fmt.Errorf("read %s: %v", path, er)
+		}
+		var e Event
+		if json.Unmarshal(line, &e) == nil {
+			s.events = append(s.events, e)
+		}
+	}
+	return
+}
+
+func (s *FileStore) Append(events []Event) error {
+	var buf []byte
+	for i := range events {
+		line, err := json.Marshal(&events[i])
+		if err != nil {
+			return err
+		}
+		if line, err = encryption.EncodeLine(line); err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(buf); err != nil {
+		return err
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// Events returns a copy of the events in the order fed
+func (s *FileStore) Events() ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Event(nil), s.events...), nil
+}
+
+func (s *FileStore) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,11 @@
+//go:build !cgo || nosqlite
+
+package edgerec
+
+// SQLiteEnabled tells the SQLiteStore is built in, it's of cgo and not the
+// nosqlite build tag
+const SQLiteEnabled = false
+
+func openStore(path string) (Store, error) {
+	return NewFileStore(path)
+}
@@ -0,0 +1,164 @@
+//go:build cgo && !nosqlite
+
+package edgerec
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/auxten/go-ctr/model/encryption"
+	_ "github.com/mattn/go-sqlite3" //keep
+)
+
+// SQLiteEnabled tells the SQLiteStore is built in, it's of cgo and not the
+// nosqlite build tag
+const SQLiteEnabled = true
+
+// SQLiteStore is the Store of the events table of the SQLite db. Of the
+// storage key set, see package encryption, the events are appended to the
+// sealed_events table of the sealed Event json, the plain events table is
+// read only if the plain data is allowed.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens the store of the SQLite db file of path, the events
+// tables are created if not exists
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(`CREATE TABLE IF NOT EXISTS events (
+		user_id INTEGER, item_id INTEGER, label REAL, timestamp INTEGER)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create events table of %s: %v", path, err)
+	}
+	if _, err = db.Exec(`CREATE TABLE IF NOT EXISTS sealed_events (data BLOB)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create sealed_events table of %s: %v", path, err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Append(events []Event) (err error) {
+	key, err := encryption.Key()
+	if err != nil {
+		return
+	}
+	if key != nil {
+		return s.appendSealed(key, events)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	stmt, err := tx.Prepare("INSERT INTO events (user_id, item_id, label, timestamp) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	for _, e := range events {
+		if _, err = stmt.Exec(e.UserId, e.ItemId, e.Label, e.Timestamp); err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) appendSealed(key []byte, events []Event) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	stmt, err := tx.Prepare("INSERT INTO sealed_events (data) VALUES (?)")
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	for i := range events {
+		var data []byte
+		if data, err = json.Marshal(&events[i]); err != nil {
+			return
+		}
+		if data, err = encryption.Seal(key, data); err != nil {
+			return
+		}
+		if _, err = stmt.Exec(data); err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
+
+// Events returns the events in the order fed, the plain events before the
+// sealed ones
+func (s *SQLiteStore) Events() (events []Event, err error) {
+	var plain int
+	if err = s.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&plain); err != nil {
+		return
+	}
+	if plain > 0 {
+		if err = encryption.CheckPlaintext(); err != nil {
+			return nil, fmt.Errorf("events table: %v", err)
+		}
+		if events, err = s.plainEvents(); err != nil {
+			return
+		}
+	}
+	rows, err := s.db.Query("SELECT data FROM sealed_events ORDER BY rowid")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return
+		}
+		if data, err = encryption.Decode(data); err != nil {
+			return nil, fmt.Errorf("sealed_events table: %v", err)
+		}
+		var e Event
+		if err = json.Unmarshal(data, &e); err != nil {
+			return
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *SQLiteStore) plainEvents() (events []Event, err error) {
+	rows, err := s.db.Query("SELECT user_id, item_id, label, timestamp FROM events ORDER BY rowid")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Event
+		if err = rows.Scan(&e.UserId, &e.ItemId, &e.Label, &e.Timestamp); err != nil {
+			return
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func openStore(path string) (Store, error) {
+	return NewSQLiteStore(path)
+}
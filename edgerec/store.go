@@ -0,0 +1,56 @@
+package edgerec
+
+import (
+	"sync"
+)
+
+// Event is a user action on an item
+type Event struct {
+	UserId int `json:"userId"`
+	ItemId int `json:"itemId"`
+	// Label is 1 of the positive actions, e.g. the click or purchase, and 0
+	// of the items shown but not acted on
+	Label     float32 `json:"label"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// Store keeps the fed events, the events of every Train are read from it.
+// NewMemoryStore is the default.
+type Store interface {
+	Append(events []Event) error
+	Events() ([]Event, error)
+}
+
+// MemoryStore is the Store in memory, the events are lost on exit
+type MemoryStore struct {
+	mu     sync.RWMutex
+	events []Event
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Append(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// Events returns a copy of the events in the order fed
+func (s *MemoryStore) Events() ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Event(nil), s.events...), nil
+}
+
+// OpenStore opens the persistent Store of path: the SQLiteStore of the db
+// file if SQLiteEnabled, otherwise the FileStore. Both are io.Closer. The
+// SQLite one is left out by the nosqlite build tag or of no cgo, so the
+// serving binaries cross build without a C toolchain:
+//
+//	CGO_ENABLED=0 GOOS=windows GOARCH=arm64 go build -tags nosqlite ./...
+func OpenStore(path string) (Store, error) {
+	return openStore(path)
+}
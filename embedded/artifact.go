@@ -0,0 +1,282 @@
+// Package embedded is the single binary mode of the air-gapped kiosks and
+// devices: the trained artifact directory, the model bundle, the item
+// features, the item embeddings and the popularity, is embedded by go:embed
+// into the binary serving it, so nothing is trained, fetched or stored at
+// runtime and the same binary always serves the same results. The binary is
+// generated by the packaging tool of Package:
+//
+//	go run ./embedded/cmd/edgerec-embed -artifact ./artifact -out ./kiosk
+//	go build -o kiosk ./kiosk
+package embedded
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/encryption"
+	"github.com/auxten/go-ctr/model/signing"
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+// the files of the artifact directory
+const (
+	MetaFile           = "artifact.json"
+	ModelFile          = "model.json"
+	ItemFeaturesFile   = "item_features.txt"
+	ItemEmbeddingsFile = "item_embeddings.txt"
+	PopularityFile     = "popularity.txt"
+	SignatureFile      = "signature.txt"
+)
+
+// artifactFiles are the files of the artifact in the digest order
+var artifactFiles = []string{MetaFile, ModelFile, ItemFeaturesFile, ItemEmbeddingsFile, PopularityFile}
+
+// Artifact is the trained artifact directory:
+//
+//	artifact.json        the model type and the version
+//	model.json           the bundle of model.MarshalBundle
+//	item_features.txt    the "id v1 v2 ..." rows of the item features
+//	item_embeddings.txt  the rows of rcmd.ExportItemEmbeddings, optional
+//	popularity.txt       the "id score" rows of the item popularity, optional
+//	signature.txt        the ed25519 signature of the files, see SignDir
+//
+// The item features are of the width of the ctx feature of the bundle.
+type Artifact struct {
+	ModelType string `json:"modelType"`
+	// Version is the sha1 of the bundle if empty
+	Version        string                  `json:"version"`
+	Bundle         []byte                  `json:"-"`
+	ItemFeatures   word2vec.EmbeddingMap32 `json:"-"`
+	ItemEmbeddings word2vec.EmbeddingMap32 `json:"-"`
+	Popularity     map[int]float64         `json:"-"`
+}
+
+func (a *Artifact) version() string {
+	if a.Version != "" {
+		return a.Version
+	}
+	sum := sha1.Sum(a.Bundle)
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteDir writes a into dir, the files are the same of the same artifact
+// unless encrypted of the storage key, see package encryption, which are of
+// the random nonces. The signature of SignDir is of the encrypted files.
+func WriteDir(dir string, a *Artifact) (err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	meta := *a
+	meta.Version = a.version()
+	data, err := json.MarshalIndent(&meta, "", "  ")
+	if err != nil {
+		return
+	}
+	files := []struct {
+		name  string
+		write func(w io.Writer) error
+		skip  bool
+	}{
+		{MetaFile, func(w io.Writer) error { _, err := w.Write(append(data, '\n')); return err }, false},
+		{ModelFile, func(w io.Writer) error { _, err := w.Write(a.Bundle); return err }, false},
+		{ItemFeaturesFile, func(w io.Writer) error { return rcmd.WriteEmbeddings(w, a.ItemFeatures) }, false},
+		{ItemEmbeddingsFile, func(w io.Writer) error { return rcmd.WriteEmbeddings(w, a.ItemEmbeddings) }, len(a.ItemEmbeddings) == 0},
+		{PopularityFile, func(w io.Writer) error { return writePopularity(w, a.Popularity) }, len(a.Popularity) == 0},
+	}
+	for _, f := range files {
+		if f.skip {
+			continue
+		}
+		var buf bytes.Buffer
+		if err = f.write(&buf); err != nil {
+			return fmt.Errorf("write %s: %v", f.name, err)
+		}
+		if data, err = encryption.Encode(buf.Bytes()); err != nil {
+			return
+		}
+		if err = os.WriteFile(filepath.Join(dir, f.name), data, 0644); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func writePopularity(w io.Writer, popularity map[int]float64) error {
+	ids := make([]int, 0, len(popularity))
+	for id := range popularity {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	bw := bufio.NewWriter(w)
+	for _, id := range ids {
+		if _, err := fmt.Fprintf(bw, "%d %s\n", id, strconv.FormatFloat(popularity[id], 'g', -1, 64)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func readPopularity(r io.Reader) (popularity map[int]float64, err error) {
+	popularity = make(map[int]float64)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("popularity line %d of %d fields, expected 2", line, len(fields))
+		}
+		var (
+			id    int
+			score float64
+		)
+		if id, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, fmt.Errorf("popularity line %d: %v", line, err)
+		}
+		if score, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return nil, fmt.Errorf("popularity line %d: %v", line, err)
+		}
+		popularity[id] = score
+	}
+	return popularity, scanner.Err()
+}
+
+// readFile is fs.ReadFile of the file decrypted of the storage key
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = encryption.Decode(data); err != nil {
+		return nil, fmt.Errorf("read %s: %v", name, err)
+	}
+	return data, nil
+}
+
+// Load reads the artifact of fsys, e.g. os.DirFS of the artifact directory
+// or the sub FS of the embed.FS
+func Load(fsys fs.FS) (a *Artifact, err error) {
+	a = &Artifact{}
+	meta, err := readFile(fsys, MetaFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(meta, a); err != nil {
+		return nil, fmt.Errorf("read %s: %v", MetaFile, err)
+	}
+	if a.ModelType == "" {
+		return nil, fmt.Errorf("%s of no model type", MetaFile)
+	}
+	if a.Bundle, err = readFile(fsys, ModelFile); err != nil {
+		return nil, err
+	}
+	si, _, err := model.UnmarshalBundle(a.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", ModelFile, err)
+	}
+	read := func(name string, optional bool, parse func(r io.Reader) error) error {
+		data, err := readFile(fsys, name)
+		if optional && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = parse(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("read %s: %v", name, err)
+		}
+		return nil
+	}
+	if err = read(ItemFeaturesFile, false, func(r io.Reader) (err error) {
+		a.ItemFeatures, err = rcmd.ReadEmbeddings(r, si.CtxFeatureRange[1]-si.CtxFeatureRange[0])
+		return
+	}); err != nil {
+		return nil, err
+	}
+	if err = read(ItemEmbeddingsFile, true, func(r io.Reader) (err error) {
+		a.ItemEmbeddings, err = rcmd.ReadEmbeddings(r, rcmd.ItemEmbDim)
+		return
+	}); err != nil {
+		return nil, err
+	}
+	if err = read(PopularityFile, true, func(r io.Reader) (err error) {
+		a.Popularity, err = readPopularity(r)
+		return
+	}); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// digest is the "name sha256" lines of the files of the artifact in fsys,
+// the files absent are skipped
+func digest(fsys fs.FS) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, name := range artifactFiles {
+		data, err := fs.ReadFile(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(&buf, "%s %s\n", name, hex.EncodeToString(sum[:]))
+	}
+	return buf.Bytes(), nil
+}
+
+// SignDir signs the artifact of dir by key, e.g. at the export after WriteDir
+func SignDir(dir string, key ed25519.PrivateKey) error {
+	d, err := digest(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, SignatureFile), []byte(signing.Encode(signing.Sign(key, d))+"\n"), 0644)
+}
+
+// Verify checks the artifact of fsys is signed by the private key of key,
+// signing.ErrUnsigned if not signed
+func Verify(fsys fs.FS, key ed25519.PublicKey) error {
+	data, err := fs.ReadFile(fsys, SignatureFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return signing.ErrUnsigned
+	}
+	if err != nil {
+		return err
+	}
+	sig, err := signing.Decode(string(data), ed25519.SignatureSize)
+	if err != nil {
+		return fmt.Errorf("read %s: %v", SignatureFile, err)
+	}
+	d, err := digest(fsys)
+	if err != nil {
+		return err
+	}
+	return signing.Verify(key, d, sig)
+}
+
+// LoadVerified is Load of the artifact verified by key
+func LoadVerified(fsys fs.FS, key ed25519.PublicKey) (*Artifact, error) {
+	if err := Verify(fsys, key); err != nil {
+		return nil, err
+	}
+	return Load(fsys)
+}
@@ -0,0 +1,42 @@
+// Command edgerec-embed packages a trained artifact directory into the main
+// package of the single binary serving it, see package embedded:
+//
+//	go run ./embedded/cmd/edgerec-embed -artifact ./artifact -out ./kiosk
+//	go build -o kiosk ./kiosk
+package main
+
+import (
+	"flag"
+
+	"github.com/auxten/go-ctr/embedded"
+	"github.com/auxten/go-ctr/model/signing"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	artifactFlag = flag.String("artifact", "", "the trained artifact directory, see embedded.Artifact")
+	outFlag      = flag.String("out", "", "the directory of the generated main package")
+	addrFlag     = flag.String("addr", embedded.DefaultAddr, "the default listen address of the binary")
+	pathFlag     = flag.String("path", embedded.DefaultPath, "the path of the recommend api")
+	pubKeyFlag   = flag.String("public-key", "", "the public key file verifying the signed artifact, see edgerec-sign")
+)
+
+func main() {
+	flag.Parse()
+	if *artifactFlag == "" || *outFlag == "" {
+		flag.Usage()
+		log.Fatal("-artifact and -out are required")
+	}
+	conf := embedded.PackageConfig{Addr: *addrFlag, Path: *pathFlag}
+	if *pubKeyFlag != "" {
+		key, err := signing.ReadPublicKeyFile(*pubKeyFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		conf.PublicKey = key
+	}
+	if err := embedded.Package(*artifactFlag, *outFlag, conf); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("packaged %s into %s", *artifactFlag, *outFlag)
+}
@@ -0,0 +1,81 @@
+// Command edgerec-replay replays the requests recorded by
+// rcmd.WithRequestRecording against the trained artifact directory to be
+// deployed, of the recorded features, and diffs the rankings with the
+// recorded ones, see rcmd.Replay:
+//
+//	go run ./embedded/cmd/edgerec-replay -records requests.jsonl \
+//	  -artifact ./artifact -tolerance 1e-4 -out replay.json -fail
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/auxten/go-ctr/embedded"
+	"github.com/auxten/go-ctr/model/signing"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	recordsFlag   = flag.String("records", "", "the replay records file of rcmd.RequestRecorder")
+	artifactFlag  = flag.String("artifact", "", "the trained artifact directory, see embedded.Artifact")
+	pubKeyFlag    = flag.String("public-key", "", "the public key file verifying the signed artifact, see edgerec-sign")
+	toleranceFlag = flag.Float64("tolerance", 1e-4, "the score changes not counted as changed")
+	outFlag       = flag.String("out", "", "the json file of the replay report")
+	failFlag      = flag.Bool("fail", false, "exit 1 if any ranking changed, e.g. to gate the deploy")
+)
+
+func main() {
+	flag.Parse()
+	if *recordsFlag == "" || *artifactFlag == "" {
+		flag.Usage()
+		log.Fatal("-records and -artifact are required")
+	}
+	f, err := os.Open(*recordsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	records, err := rcmd.ReadReplayRecords(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("read %s: %v", *recordsFlag, err)
+	}
+
+	var a *embedded.Artifact
+	if *pubKeyFlag != "" {
+		key, er := signing.ReadPublicKeyFile(*pubKeyFlag)
+		if er != nil {
+			log.Fatal(er)
+		}
+		a, err = embedded.LoadVerified(os.DirFS(*artifactFlag), key)
+	} else {
+		a, err = embedded.Load(os.DirFS(*artifactFlag))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	predictor, err := embedded.NewPredictor(ctx, a)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := rcmd.Replay(ctx, predictor, records, *toleranceFlag)
+	log.Infof("replayed %d requests: %d changed, %d errors, max score delta %g",
+		report.Requests, report.Changed, report.Errors, report.MaxScoreDelta)
+	if *outFlag != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = os.WriteFile(*outFlag, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *failFlag && report.Changed > 0 {
+		os.Exit(1)
+	}
+}
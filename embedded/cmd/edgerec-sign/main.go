@@ -0,0 +1,62 @@
+// Command edgerec-sign generates the signing keys and signs or verifies the
+// trained artifact directory, see embedded.SignDir:
+//
+//	go run ./embedded/cmd/edgerec-sign -keygen ./keys/edgerec
+//	go run ./embedded/cmd/edgerec-sign -key ./keys/edgerec.key -artifact ./artifact
+//	go run ./embedded/cmd/edgerec-sign -verify ./keys/edgerec.pub -artifact ./artifact
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/auxten/go-ctr/embedded"
+	"github.com/auxten/go-ctr/model/signing"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	keygenFlag   = flag.String("keygen", "", "generate the key pair of the prefix, prefix.key and prefix.pub")
+	keyFlag      = flag.String("key", "", "the private key file signing the artifact")
+	verifyFlag   = flag.String("verify", "", "the public key file verifying the artifact")
+	artifactFlag = flag.String("artifact", "", "the trained artifact directory, see embedded.Artifact")
+)
+
+func main() {
+	flag.Parse()
+	switch {
+	case *keygenFlag != "":
+		pub, priv, err := signing.GenerateKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = signing.WriteKeyFile(*keygenFlag+".key", priv); err != nil {
+			log.Fatal(err)
+		}
+		if err = signing.WriteKeyFile(*keygenFlag+".pub", pub); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("generated %s.key and %s.pub", *keygenFlag, *keygenFlag)
+	case *keyFlag != "" && *artifactFlag != "":
+		key, err := signing.ReadPrivateKeyFile(*keyFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = embedded.SignDir(*artifactFlag, key); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("signed %s", *artifactFlag)
+	case *verifyFlag != "" && *artifactFlag != "":
+		key, err := signing.ReadPublicKeyFile(*verifyFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = embedded.Verify(os.DirFS(*artifactFlag), key); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("verified %s", *artifactFlag)
+	default:
+		flag.Usage()
+		log.Fatal("-keygen, or -key or -verify with -artifact is required")
+	}
+}
@@ -0,0 +1,227 @@
+package embedded
+
+import (
+	"bytes"
+	"context"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/din"
+	"github.com/auxten/go-ctr/model/encryption"
+	"github.com/auxten/go-ctr/model/signing"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testArtifact(t *testing.T) *Artifact {
+	si, err := rcmd.NewSampleInfoBuilder().
+		UserProfile(2).
+		UserBehavior(rcmd.UserBehaviorLen, rcmd.ItemEmbDim).
+		ItemFeature(rcmd.ItemEmbDim).
+		CtxFeature(1).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := model.MarshalBundle(din.NewDinNet(2, rcmd.UserBehaviorLen, rcmd.ItemEmbDim, rcmd.ItemEmbDim, 1), si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emb := make([]float32, rcmd.ItemEmbDim)
+	for i := range emb {
+		emb[i] = 0.1
+	}
+	return &Artifact{
+		ModelType:      "din",
+		Bundle:         bundle,
+		ItemFeatures:   word2vec.EmbeddingMap32{"1": {1}, "2": {2}, "3": {3}},
+		ItemEmbeddings: word2vec.EmbeddingMap32{"1": emb},
+		Popularity:     map[int]float64{1: 5, 2: 7, 3: 5},
+	}
+}
+
+func TestArtifact(t *testing.T) {
+	a := testArtifact(t)
+
+	Convey("artifact directory round trip", t, func() {
+		dir := t.TempDir()
+		So(WriteDir(dir, a), ShouldBeNil)
+		read, err := Load(os.DirFS(dir))
+		So(err, ShouldBeNil)
+		So(read.ModelType, ShouldEqual, "din")
+		So(read.Version, ShouldEqual, a.version())
+		So(read.Bundle, ShouldResemble, a.Bundle)
+		So(read.ItemFeatures, ShouldResemble, a.ItemFeatures)
+		So(read.ItemEmbeddings, ShouldResemble, a.ItemEmbeddings)
+		So(read.Popularity, ShouldResemble, a.Popularity)
+
+		// the optional files
+		So(os.Remove(filepath.Join(dir, ItemEmbeddingsFile)), ShouldBeNil)
+		So(os.Remove(filepath.Join(dir, PopularityFile)), ShouldBeNil)
+		read, err = Load(os.DirFS(dir))
+		So(err, ShouldBeNil)
+		So(read.ItemEmbeddings, ShouldBeNil)
+		So(read.Popularity, ShouldBeNil)
+
+		So(os.WriteFile(filepath.Join(dir, PopularityFile), []byte("1 2 3\n"), 0644), ShouldBeNil)
+		_, err = Load(os.DirFS(dir))
+		So(err, ShouldNotBeNil)
+		So(os.Remove(filepath.Join(dir, ItemFeaturesFile)), ShouldBeNil)
+		_, err = Load(os.DirFS(dir))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("serving of the artifact", t, func() {
+		userCache, itemCache := rcmd.UserFeatureCache, rcmd.ItemFeatureCache
+		defer func() {
+			rcmd.UserFeatureCache, rcmd.ItemFeatureCache = userCache, itemCache
+		}()
+		rcmd.UserFeatureCache, rcmd.ItemFeatureCache = nil, nil
+
+		ctx := context.Background()
+		p, err := NewPredictor(ctx, a)
+		So(err, ShouldBeNil)
+		ids, err := p.Recall(ctx, 0)
+		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []int{2, 1, 3})
+		itemScores, err := p.PopularityRank(ctx, 0, []int{3, 2})
+		So(err, ShouldBeNil)
+		So(itemScores, ShouldResemble, []rcmd.ItemScore{{ItemId: 3, Score: 5}, {ItemId: 2, Score: 7}})
+		_, err = p.GetItemFeature(ctx, 4)
+		So(err, ShouldNotBeNil)
+
+		ranked, err := rcmd.Rank(ctx, p, 0, ids)
+		So(err, ShouldBeNil)
+		So(ranked, ShouldHaveLength, 3)
+		// the same artifact always serves the same scores
+		again, err := NewPredictor(ctx, a)
+		So(err, ShouldBeNil)
+		rankedAgain, err := rcmd.Rank(ctx, again, 0, ids)
+		So(err, ShouldBeNil)
+		So(rankedAgain, ShouldResemble, ranked)
+
+		_, err = NewPredictor(ctx, &Artifact{ModelType: "gbdt", Bundle: a.Bundle})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPackage(t *testing.T) {
+	a := testArtifact(t)
+
+	Convey("package of the artifact", t, func() {
+		src, out := t.TempDir(), t.TempDir()
+		So(WriteDir(src, a), ShouldBeNil)
+		So(Package(src, out, PackageConfig{Addr: ":9090"}), ShouldBeNil)
+		main, err := os.ReadFile(filepath.Join(out, "main.go"))
+		So(err, ShouldBeNil)
+		So(string(main), ShouldContainSubstring, "//go:embed artifact")
+		So(string(main), ShouldContainSubstring, `":9090"`)
+		So(string(main), ShouldContainSubstring, `"/api/v1/recommend"`)
+		_, err = parser.ParseFile(token.NewFileSet(), "main.go", main, 0)
+		So(err, ShouldBeNil)
+
+		// the same artifact is packaged into the same files
+		for _, name := range []string{MetaFile, ModelFile, ItemFeaturesFile, ItemEmbeddingsFile, PopularityFile} {
+			want, err := os.ReadFile(filepath.Join(src, name))
+			So(err, ShouldBeNil)
+			got, err := os.ReadFile(filepath.Join(out, ArtifactDir, name))
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, want)
+		}
+		packaged, err := Load(os.DirFS(filepath.Join(out, ArtifactDir)))
+		So(err, ShouldBeNil)
+		So(packaged.Version, ShouldEqual, a.version())
+
+		So(Package(t.TempDir(), out, PackageConfig{}), ShouldNotBeNil)
+	})
+}
+
+func TestSignature(t *testing.T) {
+	a := testArtifact(t)
+	pub, priv, err := signing.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("signed artifact", t, func() {
+		dir := t.TempDir()
+		So(WriteDir(dir, a), ShouldBeNil)
+		_, err := LoadVerified(os.DirFS(dir), pub)
+		So(err, ShouldEqual, signing.ErrUnsigned)
+
+		So(SignDir(dir, priv), ShouldBeNil)
+		read, err := LoadVerified(os.DirFS(dir), pub)
+		So(err, ShouldBeNil)
+		So(read.Bundle, ShouldResemble, a.Bundle)
+
+		other, _, err := signing.GenerateKey()
+		So(err, ShouldBeNil)
+		So(Verify(os.DirFS(dir), other), ShouldEqual, signing.ErrBadSignature)
+
+		Convey("the tampered files are refused", func() {
+			So(os.WriteFile(filepath.Join(dir, PopularityFile), []byte("1 5\n2 7\n3 500\n"), 0644), ShouldBeNil)
+			_, err := LoadVerified(os.DirFS(dir), pub)
+			So(err, ShouldEqual, signing.ErrBadSignature)
+		})
+
+		Convey("the removed files are refused", func() {
+			So(os.Remove(filepath.Join(dir, ItemEmbeddingsFile)), ShouldBeNil)
+			So(Verify(os.DirFS(dir), pub), ShouldEqual, signing.ErrBadSignature)
+		})
+
+		Convey("the corrupted signature is refused", func() {
+			So(os.WriteFile(filepath.Join(dir, SignatureFile), []byte("garbage"), 0644), ShouldBeNil)
+			So(Verify(os.DirFS(dir), pub), ShouldNotBeNil)
+		})
+	})
+
+	Convey("package of the signed artifact", t, func() {
+		src, out := t.TempDir(), t.TempDir()
+		So(WriteDir(src, a), ShouldBeNil)
+		So(Package(src, out, PackageConfig{PublicKey: pub}), ShouldNotBeNil)
+		So(SignDir(src, priv), ShouldBeNil)
+		So(Package(src, out, PackageConfig{PublicKey: pub}), ShouldBeNil)
+		main, err := os.ReadFile(filepath.Join(out, "main.go"))
+		So(err, ShouldBeNil)
+		So(string(main), ShouldContainSubstring, "embedded.ServeVerified")
+		So(string(main), ShouldContainSubstring, signing.Encode(pub))
+		So(Verify(os.DirFS(filepath.Join(out, ArtifactDir)), pub), ShouldBeNil)
+	})
+}
+
+func TestEncryptedArtifact(t *testing.T) {
+	a := testArtifact(t)
+	defer encryption.SetKeyProvider(nil)
+
+	Convey("encrypted artifact directory", t, func() {
+		encryption.SetKeyProvider(func() ([]byte, error) { return bytes.Repeat([]byte{1}, 32), nil })
+		dir := t.TempDir()
+		So(WriteDir(dir, a), ShouldBeNil)
+		for _, name := range []string{MetaFile, ModelFile, ItemFeaturesFile, ItemEmbeddingsFile, PopularityFile} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			So(err, ShouldBeNil)
+			So(encryption.IsSealed(data), ShouldBeTrue)
+		}
+		read, err := Load(os.DirFS(dir))
+		So(err, ShouldBeNil)
+		So(read.Bundle, ShouldResemble, a.Bundle)
+		So(read.Popularity, ShouldResemble, a.Popularity)
+
+		out := t.TempDir()
+		So(Package(dir, out, PackageConfig{}), ShouldBeNil)
+		_, err = Load(os.DirFS(filepath.Join(out, ArtifactDir)))
+		So(err, ShouldBeNil)
+
+		encryption.SetKeyProvider(func() ([]byte, error) { return nil, nil })
+		_, err = Load(os.DirFS(dir))
+		So(err, ShouldNotBeNil)
+		encryption.SetKeyProvider(func() ([]byte, error) { return bytes.Repeat([]byte{2}, 32), nil })
+		_, err = Load(os.DirFS(dir))
+		So(err, ShouldNotBeNil)
+	})
+}
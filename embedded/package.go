@@ -0,0 +1,144 @@
+package embedded
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/auxten/go-ctr/model/forward"
+	"github.com/auxten/go-ctr/model/signing"
+)
+
+const (
+	// DefaultAddr and DefaultPath are the http api of the binary
+	DefaultAddr = ":8080"
+	DefaultPath = "/api/v1/recommend"
+	// ArtifactDir is the directory of the artifact in the generated package
+	ArtifactDir = "artifact"
+)
+
+// PackageConfig configures the generated binary, the zero values are the
+// defaults
+type PackageConfig struct {
+	// Addr is the default listen address, overridden by the -addr flag
+	Addr string
+	// Path is the path of the recommend api
+	Path string
+	// PublicKey verifies the signature of the artifact of SignDir before
+	// packaging, and the binary verifies the embedded artifact by it on
+	// start. The artifact is not verified if nil.
+	PublicKey ed25519.PublicKey
+}
+
+var mainTemplate = template.Must(template.New("main").Parse(`// Code generated by edgerec-embed. DO NOT EDIT.
+
+// The single binary serving the embedded artifact {{.Version}} of the
+// {{.ModelType}} model.
+package main
+
+import (
+	"embed"
+	"flag"
+	"io/fs"
+
+	"github.com/auxten/go-ctr/embedded"
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed {{.Dir}}
+var artifact embed.FS
+
+var addrFlag = flag.String("addr", {{printf "%q" .Addr}}, "listen address")
+
+func main() {
+	flag.Parse()
+	fsys, err := fs.Sub(artifact, {{printf "%q" .Dir}})
+	if err != nil {
+		log.Fatal(err)
+	}
+{{- if .Key}}
+	log.Fatal(embedded.ServeVerified(fsys, {{printf "%q" .Key}}, {{printf "%q" .Path}}, *addrFlag))
+{{- else}}
+	log.Fatal(embedded.Serve(fsys, {{printf "%q" .Path}}, *addrFlag))
+{{- end}}
+}
+`))
+
+// Package generates the main package of the binary embedding the artifact
+// directory into out: the artifact is validated by loading it and copied
+// to out/artifact, and out/main.go serves it. out should be in a module
+// requiring this one, and is built by go build. The artifact of
+// conf.PublicKey is verified before it's loaded.
+func Package(artifactDir, out string, conf PackageConfig) (err error) {
+	if conf.Addr == "" {
+		conf.Addr = DefaultAddr
+	}
+	if conf.Path == "" {
+		conf.Path = DefaultPath
+	}
+	var (
+		fsys = os.DirFS(artifactDir)
+		a    *Artifact
+	)
+	if conf.PublicKey != nil {
+		a, err = LoadVerified(fsys, conf.PublicKey)
+	} else {
+		a, err = Load(fsys)
+	}
+	if err != nil {
+		return fmt.Errorf("load artifact %s: %v", artifactDir, err)
+	}
+	if _, err = forward.Load(a.ModelType, a.Bundle, 0); err != nil {
+		return fmt.Errorf("load artifact %s: %v", artifactDir, err)
+	}
+	dir := filepath.Join(out, ArtifactDir)
+	if err = os.RemoveAll(dir); err != nil {
+		return
+	}
+	// copied verbatim, the signature is of the bytes of the files
+	if err = copyArtifact(artifactDir, dir); err != nil {
+		return
+	}
+	var key string
+	if conf.PublicKey != nil {
+		key = signing.Encode(conf.PublicKey)
+	}
+	var buf bytes.Buffer
+	if err = mainTemplate.Execute(&buf, struct {
+		PackageConfig
+		ModelType, Version, Dir, Key string
+	}{conf, a.ModelType, a.version(), ArtifactDir, key}); err != nil {
+		return
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return
+	}
+	return os.WriteFile(filepath.Join(out, "main.go"), src, 0644)
+}
+
+// copyArtifact copies the files of the artifact and its signature of src
+// into dst
+func copyArtifact(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, name := range append(artifactFiles, SignatureFile) {
+		data, err := os.ReadFile(filepath.Join(src, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(dst, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+
+	"github.com/auxten/go-ctr/model/forward"
+	"github.com/auxten/go-ctr/model/signing"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+	"gorgonia.org/tensor"
+)
+
+// Predictor serves an Artifact, it's the rcmd.Predictor, rcmd.Recaller and
+// rcmd.PopularityRanker of the embedded items, the rcmd.ManifestProvider of
+// the bundle and the rcmd.ModelVersioner of the artifact. The users are
+// anonymous of the zero user profile unless the request carries the
+// rcmd.DeviceProfile.
+type Predictor struct {
+	artifact *Artifact
+	model    *forward.Model
+	// popular is the item ids by popularity desc, then by id
+	popular     []int
+	userFeature rcmd.Tensor
+}
+
+// NewPredictor loads the model of a and installs the item embeddings of a
+// as the item embeddings served in ctx
+func NewPredictor(ctx context.Context, a *Artifact) (p *Predictor, err error) {
+	p = &Predictor{artifact: a}
+	if p.model, err = forward.Load(a.ModelType, a.Bundle, 0); err != nil {
+		return nil, err
+	}
+	si := p.model.SampleInfo()
+	p.userFeature = make(rcmd.Tensor, si.UserProfileRange[1]-si.UserProfileRange[0])
+	if len(a.ItemEmbeddings) != 0 {
+		if _, err = rcmd.UpdateItemEmbeddings(ctx, a.ItemEmbeddings, true); err != nil {
+			return nil, err
+		}
+	}
+	for id := range a.ItemFeatures {
+		itemId, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("item id %q: %v", id, err)
+		}
+		p.popular = append(p.popular, itemId)
+	}
+	sort.Slice(p.popular, func(i, j int) bool {
+		pi, pj := a.Popularity[p.popular[i]], a.Popularity[p.popular[j]]
+		if pi != pj {
+			return pi > pj
+		}
+		return p.popular[i] < p.popular[j]
+	})
+	log.Infof("loaded embedded %s model version %s of %d items", a.ModelType, a.version(), len(p.popular))
+	return
+}
+
+// Serve serves the artifact of fsys at path and addr by rcmd.StartHttpApi
+func Serve(fsys fs.FS, path, addr string, opts ...rcmd.ApiOption) error {
+	a, err := Load(fsys)
+	if err != nil {
+		return err
+	}
+	return serve(a, path, addr, opts...)
+}
+
+// ServeVerified is Serve of the artifact verified by publicKey, the base64
+// ed25519 public key, the tampered artifact is refused
+func ServeVerified(fsys fs.FS, publicKey string, path, addr string, opts ...rcmd.ApiOption) error {
+	key, err := signing.DecodePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	a, err := LoadVerified(fsys, key)
+	if err != nil {
+		return err
+	}
+	return serve(a, path, addr, opts...)
+}
+
+func serve(a *Artifact, path, addr string, opts ...rcmd.ApiOption) error {
+	p, err := NewPredictor(context.Background(), a)
+	if err != nil {
+		return err
+	}
+	return rcmd.StartHttpApi(p, path, addr, nil, opts...)
+}
+
+func (p *Predictor) GetUserFeature(context.Context, int) (rcmd.Tensor, error) {
+	return p.userFeature, nil
+}
+
+func (p *Predictor) GetItemFeature(_ context.Context, itemId int) (rcmd.Tensor, error) {
+	f, ok := p.artifact.ItemFeatures[strconv.Itoa(itemId)]
+	if !ok {
+		return nil, fmt.Errorf("item %d not found", itemId)
+	}
+	return f, nil
+}
+
+func (p *Predictor) Predict(X tensor.Tensor) tensor.Tensor {
+	rows := X.Shape()[0]
+	y, err := p.model.Predict(rows, X.Data().([]float32))
+	if err != nil {
+		log.Errorf("predict embedded %s model failed: %v", p.artifact.ModelType, err)
+		return nil
+	}
+	return tensor.New(tensor.WithShape(rows, 1), tensor.WithBacking(y))
+}
+
+// Recall returns all the items by popularity
+func (p *Predictor) Recall(context.Context, int) ([]int, error) {
+	return append([]int(nil), p.popular...), nil
+}
+
+// PopularityRank scores itemIds by their popularity, in the order of itemIds
+func (p *Predictor) PopularityRank(_ context.Context, _ int, itemIds []int) ([]rcmd.ItemScore, error) {
+	itemScores := make([]rcmd.ItemScore, len(itemIds))
+	for i, id := range itemIds {
+		itemScores[i] = rcmd.ItemScore{ItemId: id, Score: float32(p.artifact.Popularity[id])}
+	}
+	return itemScores, nil
+}
+
+// ModelVersion is the version of the artifact
+func (p *Predictor) ModelVersion() string {
+	return p.artifact.version()
+}
+
+func (p *Predictor) Manifest() *rcmd.Manifest {
+	return p.model.Manifest()
+}
@@ -14,7 +14,6 @@ import (
 	"github.com/auxten/go-ctr/feature/ubcache"
 	rcmd "github.com/auxten/go-ctr/recommend"
 	"github.com/auxten/go-ctr/utils"
-	_ "github.com/mattn/go-sqlite3"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -199,6 +198,22 @@ func genreFeature(genre string) (tensor rcmd.Tensor) {
 	return feature.HashOneHot32([]byte(genre), 10)
 }
 
+const sampleQuery = "SELECT userId, movieId, rating, timestamp FROM ratings_train ORDER BY timestamp, userId ASC LIMIT ?"
+
+// DataSources reports the training samples query for the data lineage
+func (recSys *MovielensRec) DataSources(ctx context.Context) (sources []rcmd.DataSource, err error) {
+	source := rcmd.DataSource{
+		Name:  "ratings_train",
+		Query: strings.Replace(sampleQuery, "?", strconv.Itoa(recSys.SampleCnt), 1),
+	}
+	row := db.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT count(*), ifnull(min(timestamp), 0), ifnull(max(timestamp), 0) FROM (%s)", sampleQuery), recSys.SampleCnt)
+	if err = row.Scan(&source.Rows, &source.MinTimestamp, &source.MaxTimestamp); err != nil {
+		return
+	}
+	return []rcmd.DataSource{source}, nil
+}
+
 func (recSys *MovielensRec) SampleGenerator(_ context.Context) (ret <-chan rcmd.Sample, err error) {
 	sampleCh := make(chan rcmd.Sample, 10000)
 	var (
@@ -215,8 +230,7 @@ func (recSys *MovielensRec) SampleGenerator(_ context.Context) (ret <-chan rcmd.
 			close(sampleCh)
 		}()
 
-		rows, err = db.Query(
-			"SELECT userId, movieId, rating, timestamp FROM ratings_train ORDER BY timestamp, userId ASC LIMIT ?", recSys.SampleCnt)
+		rows, err = db.Query(sampleQuery, recSys.SampleCnt)
 		if err != nil {
 			log.Errorf("failed to query ratings: %v", err)
 			wg.Done()
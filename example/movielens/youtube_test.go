@@ -1,3 +1,5 @@
+//go:build cgo && !nosqlite
+
 package movielens
 
 import (
@@ -60,7 +62,7 @@ func TestYoutubeDnnOnMovielens(t *testing.T) {
 			}
 			//yTrue.Set(i, 0, BinarizeLabel(rating))
 			yTrue = append(yTrue, BinarizeLabel32(rating))
-			sampleKeys = append(sampleKeys, rcmd.Sample{userId, itemId, 0, timestamp})
+			sampleKeys = append(sampleKeys, rcmd.Sample{UserId: userId, ItemId: itemId, Timestamp: timestamp})
 		}
 		batchPredictCtx := context.Background()
 		yDnnPred := &dnnPredictor{
@@ -0,0 +1,190 @@
+package feature
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultStopwords are the common English words of no signal of the titles
+// and the queries
+var DefaultStopwords = NewStopwords(
+	"a", "an", "and", "are", "as", "at", "be", "by", "for", "from", "in", "is",
+	"it", "of", "on", "or", "that", "the", "this", "to", "was", "with",
+)
+
+// NewStopwords returns the stopword set of words, lower cased
+func NewStopwords(words ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		set[strings.ToLower(w)] = struct{}{}
+	}
+	return set
+}
+
+// Tokenizer splits the text into the words of the unicode letters and
+// numbers, every other rune is a separator. The ideographs of the scripts of
+// no spaces, e.g. Han, Hiragana, Katakana and Thai, are a token each, the
+// n-grams of NGramHasher join them back into the words.
+type Tokenizer struct {
+	// KeepCase keeps the case of the tokens, they are lower cased by default
+	KeepCase bool
+	// Stopwords are dropped, e.g. DefaultStopwords, nil keeps all. They are
+	// matched after lower casing unless KeepCase.
+	Stopwords map[string]struct{}
+}
+
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai)
+}
+
+// Tokenize returns the tokens of text in order
+func (t *Tokenizer) Tokenize(text string) (tokens []string) {
+	if !t.KeepCase {
+		text = strings.ToLower(text)
+	}
+	add := func(token string) {
+		if _, ok := t.Stopwords[token]; !ok {
+			tokens = append(tokens, token)
+		}
+	}
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+		if word && !isIdeograph(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			add(text[start:i])
+			start = -1
+		}
+		if word {
+			add(string(r))
+		}
+	}
+	if start >= 0 {
+		add(text[start:])
+	}
+	return
+}
+
+// NGramHasher is the hashing trick featurizer of the word n-grams of the
+// title or query text, of no vocabulary to fit, so the unseen words of the
+// serving still get features:
+//
+//	h := &NGramHasher{Tokenizer: Tokenizer{Stopwords: DefaultStopwords}, MaxN: 2, Buckets: 1 << 12}
+//	features := h.Transform("The Lord of the Rings")
+//
+// Every n-gram counts in the bucket of its fnv hash, the collisions are
+// unbiased by the alternate signs of the hashes.
+type NGramHasher struct {
+	Tokenizer Tokenizer
+	// MinN and MaxN are the n-gram lengths, 1 if 0
+	MinN, MaxN int
+	// Buckets is the number of the features, 1024 if 0
+	Buckets int
+	// Binary counts every n-gram once with the sign of its hash
+	Binary bool
+	// Normalizer of the counts, e.g. SampleNormalizerL2, nil keeps the counts
+	Normalizer interface {
+		TransformInplace(dest []float64, vs []float64)
+	}
+}
+
+const defaultNGramBuckets = 1024
+
+// Fit is empty, the hasher is stateless, kept only to keep the same
+// interface
+func (t *NGramHasher) Fit(_ []string) {}
+
+// NumFeatures is the number of the buckets
+func (t *NGramHasher) NumFeatures() int {
+	if t == nil {
+		return 0
+	}
+	if t.Buckets <= 0 {
+		return defaultNGramBuckets
+	}
+	return t.Buckets
+}
+
+func (t *NGramHasher) nRange() (minN, maxN int) {
+	minN, maxN = t.MinN, t.MaxN
+	if minN <= 0 {
+		minN = 1
+	}
+	if maxN < minN {
+		maxN = minN
+	}
+	return
+}
+
+// NGrams returns the n-grams of text, the words of an n-gram are joined by a
+// space
+func (t *NGramHasher) NGrams(text string) (ngrams []string) {
+	tokens := t.Tokenizer.Tokenize(text)
+	minN, maxN := t.nRange()
+	for n := minN; n <= maxN; n++ {
+		for i := 0; i+n <= len(tokens); i++ {
+			ngrams = append(ngrams, strings.Join(tokens[i:i+n], " "))
+		}
+	}
+	return
+}
+
+// Transform returns the hashed n-gram counts of text
+func (t *NGramHasher) Transform(text string) []float64 {
+	if t == nil {
+		return nil
+	}
+	features := make([]float64, t.NumFeatures())
+	t.TransformInplace(features, text)
+	return features
+}
+
+// TransformInplace adds the hashed n-gram counts of text to dest, it is
+// responsibility of caller to zero-out destination
+func (t *NGramHasher) TransformInplace(dest []float64, text string) {
+	if t == nil || len(dest) != t.NumFeatures() {
+		return
+	}
+	var seen map[uint32]bool
+	if t.Binary {
+		seen = make(map[uint32]bool)
+	}
+	for _, ngram := range t.NGrams(text) {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(ngram))
+		sum := h.Sum32()
+		if seen != nil {
+			if seen[sum] {
+				continue
+			}
+			seen[sum] = true
+		}
+		sign := 1.
+		if sum>>31 == 1 {
+			sign = -1
+		}
+		dest[int(sum&0x7fffffff)%len(dest)] += sign
+	}
+	if t.Normalizer != nil {
+		t.Normalizer.TransformInplace(dest, dest)
+	}
+}
+
+// FeatureNames returns the bucket names, ngram_0, ngram_1 ...
+func (t *NGramHasher) FeatureNames() []string {
+	if t == nil {
+		return nil
+	}
+	names := make([]string, t.NumFeatures())
+	for i := range names {
+		names[i] = "ngram_" + strconv.Itoa(i)
+	}
+	return names
+}
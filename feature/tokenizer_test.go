@@ -0,0 +1,74 @@
+package feature
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenizer(t *testing.T) {
+	samples := []struct {
+		name      string
+		tokenizer Tokenizer
+		input     string
+		output    []string
+	}{
+		{"basic", Tokenizer{}, "The Lord of the Rings (2001)", []string{"the", "lord", "of", "the", "rings", "2001"}},
+		{"stopwords", Tokenizer{Stopwords: DefaultStopwords}, "The Lord of the Rings", []string{"lord", "rings"}},
+		{"keep_case", Tokenizer{KeepCase: true}, "Toy Story", []string{"Toy", "Story"}},
+		{"punctuation", Tokenizer{}, "sci-fi, action!!  drama", []string{"sci", "fi", "action", "drama"}},
+		{"unicode", Tokenizer{}, "Amélie Café", []string{"amélie", "café"}},
+		{"ideographs", Tokenizer{}, "千与千寻 spirited", []string{"千", "与", "千", "寻", "spirited"}},
+		{"empty", Tokenizer{}, " ,. ", nil},
+	}
+	for _, s := range samples {
+		t.Run(s.name, func(t *testing.T) {
+			assert.Equal(t, s.output, s.tokenizer.Tokenize(s.input))
+		})
+	}
+}
+
+func TestNGramHasher(t *testing.T) {
+	t.Run("ngrams", func(t *testing.T) {
+		h := &NGramHasher{MinN: 1, MaxN: 2}
+		assert.Equal(t, []string{"toy", "story", "2", "toy story", "story 2"}, h.NGrams("Toy Story 2"))
+		h = &NGramHasher{MinN: 2}
+		assert.Equal(t, []string{"toy story"}, h.NGrams("toy story"))
+	})
+
+	t.Run("hashed counts", func(t *testing.T) {
+		h := &NGramHasher{Buckets: 16}
+		assert.Equal(t, 16, h.NumFeatures())
+		features := h.Transform("star wars star")
+		var total float64
+		for _, v := range features {
+			total += math.Abs(v)
+		}
+		assert.Equal(t, 3., total)
+		// the same text of the same features, case insensitive
+		assert.Equal(t, features, h.Transform("Star Wars STAR"))
+		assert.Len(t, h.FeatureNames(), 16)
+		assert.Equal(t, "ngram_15", h.FeatureNames()[15])
+	})
+
+	t.Run("binary and normalized", func(t *testing.T) {
+		h := &NGramHasher{Buckets: 64, Binary: true, Normalizer: &SampleNormalizerL2{}}
+		features := h.Transform("star star wars")
+		var norm float64
+		for _, v := range features {
+			norm += v * v
+			assert.LessOrEqual(t, math.Abs(v), 1.)
+		}
+		assert.InDelta(t, 1, norm, 1e-9)
+	})
+
+	t.Run("default buckets and nil", func(t *testing.T) {
+		assert.Equal(t, defaultNGramBuckets, (&NGramHasher{}).NumFeatures())
+		var h *NGramHasher
+		assert.Equal(t, 0, h.NumFeatures())
+		assert.Nil(t, h.Transform("a"))
+		// an expanding transformer of the StructTransformer
+		var _ expandingTransformer = &NGramHasher{}
+	})
+}
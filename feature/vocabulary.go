@@ -0,0 +1,229 @@
+package feature
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/auxten/go-ctr/model/encryption"
+)
+
+// OOVIndex is the index reserved for the out of vocabulary values, e.g. the
+// categories appeared after the model is trained
+const OOVIndex = 0
+
+// Vocabulary is the concurrent category to index mapping shared by the
+// encoders of training and serving. The indexes start from 1, 0 is the
+// OOVIndex.
+//
+// At serving time the new categories are added by Encode but still encoded
+// as OOVIndex, since the embedding table of the model has only Trained rows.
+// The vocabulary is saved and merged back into the next training run, which
+// learns the rows of them and calls SetTrained.
+type Vocabulary struct {
+	mu    sync.RWMutex
+	index map[string]uint
+	words []string
+	// trained is the indexes known to the model, including OOVIndex
+	trained int
+	// maxSize limits the growth at serving time, 0 is unlimited
+	maxSize int
+}
+
+type vocabularyJson struct {
+	Words   []string `json:"words"`
+	Trained int      `json:"trained"`
+	MaxSize int      `json:"maxSize,omitempty"`
+}
+
+// NewVocabulary creates an empty Vocabulary of at most maxSize indexes
+// including OOVIndex, 0 is unlimited
+func NewVocabulary(maxSize int) *Vocabulary {
+	return &Vocabulary{index: make(map[string]uint), trained: 1, maxSize: maxSize}
+}
+
+// Fit adds every value and marks all the indexes trained, the empty strings
+// are ignored
+func (v *Vocabulary) Fit(vals []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, val := range vals {
+		v.add(val)
+	}
+	v.trained = len(v.words) + 1
+}
+
+// Transform returns Encode(val), it makes the Vocabulary a string
+// transformer of StructTransformer
+func (v *Vocabulary) Transform(val string) float64 {
+	return float64(v.Encode(val))
+}
+
+// Encode returns the index of val, adding it if new. The untrained indexes
+// are encoded as OOVIndex.
+func (v *Vocabulary) Encode(val string) uint {
+	v.mu.RLock()
+	idx, ok := v.index[val]
+	trained := v.trained
+	v.mu.RUnlock()
+	if !ok {
+		v.mu.Lock()
+		idx = v.add(val)
+		trained = v.trained
+		v.mu.Unlock()
+	}
+	if int(idx) >= trained {
+		return OOVIndex
+	}
+	return idx
+}
+
+// Index returns the index of val without adding it, OOVIndex if not found.
+// Unlike Encode the untrained index is returned as is.
+func (v *Vocabulary) Index(val string) uint {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.index[val]
+}
+
+// add returns the index of val, OOVIndex if empty or the vocabulary is full
+func (v *Vocabulary) add(val string) uint {
+	if val == "" {
+		return OOVIndex
+	}
+	if idx, ok := v.index[val]; ok {
+		return idx
+	}
+	if v.maxSize > 0 && len(v.words)+1 >= v.maxSize {
+		return OOVIndex
+	}
+	v.words = append(v.words, val)
+	idx := uint(len(v.words))
+	v.index[val] = idx
+	return idx
+}
+
+// Size is the count of the indexes including OOVIndex, it's the rows of the
+// embedding table to train
+func (v *Vocabulary) Size() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.words) + 1
+}
+
+// Trained is the count of the indexes known to the model including OOVIndex
+func (v *Vocabulary) Trained() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.trained
+}
+
+// SetTrained marks the first size indexes trained, usually Size() after the
+// training run
+func (v *Vocabulary) SetTrained(size int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if size > len(v.words)+1 {
+		size = len(v.words) + 1
+	}
+	if size < 1 {
+		size = 1
+	}
+	v.trained = size
+}
+
+// Words returns the values ordered by index, the value of index i is
+// Words()[i-1]
+func (v *Vocabulary) Words() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return append([]string(nil), v.words...)
+}
+
+// Merge adds the words of other not in v, e.g. the categories grown at
+// serving time. The indexes of v are kept, so the trained embeddings are
+// still valid.
+func (v *Vocabulary) Merge(other *Vocabulary) {
+	words := other.Words()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, w := range words {
+		v.add(w)
+	}
+}
+
+// FeatureNames returns the names of the indexes, "<oov>" for OOVIndex
+func (v *Vocabulary) FeatureNames() []string {
+	return append([]string{"<oov>"}, v.Words()...)
+}
+
+func (v *Vocabulary) MarshalJSON() ([]byte, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return json.Marshal(vocabularyJson{Words: v.words, Trained: v.trained, MaxSize: v.maxSize})
+}
+
+func (v *Vocabulary) UnmarshalJSON(data []byte) (err error) {
+	var vj vocabularyJson
+	if err = json.Unmarshal(data, &vj); err != nil {
+		return
+	}
+	index := make(map[string]uint, len(vj.Words))
+	for i, w := range vj.Words {
+		if w == "" {
+			return fmt.Errorf("empty word of index %d", i+1)
+		}
+		if _, ok := index[w]; ok {
+			return fmt.Errorf("duplicated word %q of index %d", w, i+1)
+		}
+		index[w] = uint(i + 1)
+	}
+	if vj.Trained < 1 || vj.Trained > len(vj.Words)+1 {
+		return fmt.Errorf("trained %d out of [1, %d]", vj.Trained, len(vj.Words)+1)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.index, v.words, v.trained, v.maxSize = index, vj.Words, vj.Trained, vj.MaxSize
+	return
+}
+
+// Save writes the vocabulary to path as json, the file is replaced at once
+// so the concurrent LoadVocabulary never reads it half written. It's
+// encrypted of the storage key, see package encryption.
+func (v *Vocabulary) Save(path string) (err error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if data, err = encryption.Encode(data); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadVocabulary reads the vocabulary saved by Save
+func LoadVocabulary(path string) (v *Vocabulary, err error) {
+	data, err := encryption.ReadFile(path)
+	if err != nil {
+		return
+	}
+	v = NewVocabulary(0)
+	if err = json.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("load vocabulary %s: %v", path, err)
+	}
+	return
+}
@@ -0,0 +1,107 @@
+package feature
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/auxten/go-ctr/model/encryption"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVocabulary(t *testing.T) {
+	t.Run("fit and grow at serving", func(t *testing.T) {
+		v := NewVocabulary(0)
+		v.Fit([]string{"a", "b", "", "a"})
+		assert.Equal(t, 3, v.Size())
+		assert.Equal(t, 3, v.Trained())
+		assert.Equal(t, uint(1), v.Encode("a"))
+		assert.Equal(t, 2., v.Transform("b"))
+
+		// new category is added but encoded as oov until trained
+		assert.Equal(t, uint(OOVIndex), v.Encode("c"))
+		assert.Equal(t, uint(3), v.Index("c"))
+		assert.Equal(t, 4, v.Size())
+		assert.Equal(t, uint(OOVIndex), v.Encode(""))
+		assert.Equal(t, uint(OOVIndex), v.Index("d"))
+		assert.Equal(t, 4, v.Size())
+
+		v.SetTrained(v.Size())
+		assert.Equal(t, uint(3), v.Encode("c"))
+		assert.Equal(t, []string{"<oov>", "a", "b", "c"}, v.FeatureNames())
+	})
+
+	t.Run("max size", func(t *testing.T) {
+		v := NewVocabulary(3)
+		v.Fit([]string{"a", "b", "c"})
+		assert.Equal(t, []string{"a", "b"}, v.Words())
+		assert.Equal(t, uint(OOVIndex), v.Index("c"))
+	})
+
+	t.Run("concurrent encode", func(t *testing.T) {
+		v := NewVocabulary(0)
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					v.Encode(fmt.Sprintf("w%d", i))
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 101, v.Size())
+		for i, w := range v.Words() {
+			assert.Equal(t, uint(i+1), v.Index(w))
+		}
+	})
+
+	t.Run("persist and merge back", func(t *testing.T) {
+		trained := NewVocabulary(0)
+		trained.Fit([]string{"a", "b"})
+		path := filepath.Join(t.TempDir(), "vocab.json")
+		assert.NoError(t, trained.Save(path))
+
+		serving, err := LoadVocabulary(path)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(2), serving.Encode("b"))
+		serving.Encode("d")
+		serving.Encode("c")
+
+		trained.Encode("c")
+		trained.Merge(serving)
+		assert.Equal(t, []string{"a", "b", "c", "d"}, trained.Words())
+		assert.Equal(t, 3, trained.Trained())
+
+		data, err := json.Marshal(trained)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"words":["a","b","c","d"],"trained":3}`, string(data))
+
+		var bad Vocabulary
+		assert.Error(t, json.Unmarshal([]byte(`{"words":["a","a"],"trained":1}`), &bad))
+		assert.Error(t, json.Unmarshal([]byte(`{"words":["a"],"trained":3}`), &bad))
+		_, err = LoadVocabulary(filepath.Join(t.TempDir(), "missing.json"))
+		assert.Error(t, err)
+	})
+	t.Run("encrypted at rest", func(t *testing.T) {
+		defer encryption.SetKeyProvider(nil)
+		encryption.SetKeyProvider(func() ([]byte, error) { return bytes.Repeat([]byte{3}, 32), nil })
+		v := NewVocabulary(0)
+		v.Fit([]string{"secret-category"})
+		path := filepath.Join(t.TempDir(), "vocab.json")
+		assert.NoError(t, v.Save(path))
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.True(t, encryption.IsSealed(data))
+		assert.NotContains(t, string(data), "secret-category")
+		loaded, err := LoadVocabulary(path)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret-category"}, loaded.Words())
+	})
+}
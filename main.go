@@ -4,11 +4,24 @@ import (
 	"context"
 	"embed"
 	"flag"
+	"fmt"
 
+	"github.com/auxten/go-ctr/config"
 	"github.com/auxten/go-ctr/example/movielens"
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/bst"
+	"github.com/auxten/go-ctr/model/din"
+	"github.com/auxten/go-ctr/model/gbdt"
 	"github.com/auxten/go-ctr/model/mlp"
+	"github.com/auxten/go-ctr/model/pnn"
+	"github.com/auxten/go-ctr/model/wide"
+	"github.com/auxten/go-ctr/model/youtube"
 	nn "github.com/auxten/go-ctr/nn/neural_network"
 	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/ann"
+	"github.com/auxten/go-ctr/recommend/cf"
+	"github.com/auxten/go-ctr/recommend/rules"
+	"github.com/auxten/go-ctr/recommend/utility"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,6 +29,7 @@ import (
 var f embed.FS
 
 var verFlag = flag.Bool("v", false, "show binary version")
+var configFlag = flag.String("config", "", "yaml config file, see package config")
 
 var Version = "unknown-version"
 var Commit = "unknown-commit"
@@ -28,30 +42,228 @@ func main() {
 		return
 	}
 
+	cfg, err := config.Load(*configFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	var (
 		recSys = &movielens.MovielensRec{
-			DataPath:  "movielens.db",
-			SampleCnt: 80000,
+			DataPath:  cfg.Dsn,
+			SampleCnt: cfg.Training.SampleCnt,
 		}
 
 		model rcmd.Predictor
-		err   error
+		opts  []rcmd.ApiOption
 	)
 	log.SetLevel(log.DebugLevel)
 
-	fiter := nn.NewMLPClassifier(
-		[]int{100},
-		"relu", "adam", 1e-5,
-	)
-	fiter.Verbose = true
-	fiter.MaxIter = 20
-	// fiter.LearningRate = "adaptive"
-	// fiter.LearningRateInit = .0025
+	fitter, err := newFitter(&cfg.Training)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tree := cfg.Training.TreeModel; tree != "" {
+		// the leaves of the trees are the input features of the neural model
+		leafFitter := &gbdt.LeafFitter{Encoding: cfg.Training.LeafEncoding, Net: fitter}
+		if tree != "gbdt" {
+			if leafFitter.Trees, err = gbdt.Load(tree); err != nil {
+				log.Fatal(err)
+			}
+		}
+		fitter = leafFitter
+	}
+
+	if path := cfg.Training.ItemContent; path != "" {
+		content, err := rcmd.LoadContentEmbeddings(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = rcmd.RegisterContentEmbeddings("item_content", content); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	trainCtx := context.Background()
-	model, err = rcmd.Train(trainCtx, recSys, &mlp.SimpleMlpFitWrap{Model: fiter})
+	model, err = rcmd.Train(trainCtx, recSys, fitter)
 	if err != nil {
 		log.Fatal(err)
 	}
-	rcmd.StartHttpApi(model, "/api/v1/recommend", ":8080", &f)
+	if cfg.Serving.RateLimit > 0 {
+		opts = append(opts, rcmd.WithRateLimit(cfg.Serving.RateLimit, cfg.Serving.RateBurst))
+	}
+	if cfg.Serving.MaxP99 > 0 || cfg.Serving.MaxCPU > 0 {
+		opts = append(opts, rcmd.WithLoadShedding(cfg.Serving.MaxP99, cfg.Serving.MaxCPU))
+	}
+	if budget := (rcmd.StageBudget{
+		Recall:        cfg.Serving.RecallTimeout,
+		FeatureFetch:  cfg.Serving.FeatureTimeout,
+		Scoring:       cfg.Serving.ScoringTimeout,
+		ReRank:        cfg.Serving.ReRankTimeout,
+		MaxCandidates: cfg.Serving.MaxCandidates,
+	}); budget != (rcmd.StageBudget{}) {
+		opts = append(opts, rcmd.WithStageBudget(budget))
+	}
+	if path := cfg.Serving.RulesPath; path != "" {
+		engine, err := rules.NewEngine(trainCtx, &rules.FileSource{Path: path})
+		if err != nil {
+			log.Fatal(err)
+		}
+		engine.Watch(trainCtx, cfg.Serving.RulesReload)
+		// the item attributes and user segments of the rules are of the
+		// recSys if it serves them
+		attributer, _ := interface{}(recSys).(rules.ItemAttributer)
+		segmenter, _ := interface{}(recSys).(rules.UserSegmenter)
+		opts = append(opts, rcmd.WithReRankers(engine.ReRanker(attributer, segmenter)))
+	}
+	if path := cfg.Serving.UtilityPath; path != "" {
+		values, err := utility.NewEngine(trainCtx, &utility.FileSource{Path: path})
+		if err != nil {
+			log.Fatal(err)
+		}
+		values.Watch(trainCtx, cfg.Serving.UtilityReload)
+		// the item values of the terms are of the recSys if it serves them
+		valuer, _ := interface{}(recSys).(utility.ItemValuer)
+		opts = append(opts, rcmd.WithReRankers(values.ReRanker(valuer)))
+	}
+	if interval := cfg.Serving.IndexRebuild; interval > 0 {
+		var (
+			embIndex = ann.NewLiveIndex(rcmd.EmbeddingIndexBuilder(ann.Cosine))
+			itemCF   = cf.NewLiveItemCF(rcmd.ItemCFBuilder(recSys, cf.Options{}))
+			indexes  = map[string]rcmd.LiveIndex{"embedding": embIndex, "itemcf": itemCF}
+		)
+		// the first builds are served from the start, the later ones swapped
+		// in every interval
+		for name, index := range indexes {
+			if err = index.Rebuild(trainCtx); err != nil {
+				log.Fatalf("build %s index: %v", name, err)
+			}
+			index.RebuildEvery(trainCtx, interval)
+		}
+		opts = append(opts,
+			rcmd.WithLiveIndexes(indexes),
+			rcmd.WithSurfaces(&rcmd.Surface{Name: indexSurface, Recallers: []rcmd.Recaller{
+				&rcmd.HistoryRecaller{Neighbors: itemCF, Behavior: recSys},
+				&rcmd.HistoryRecaller{Neighbors: &rcmd.EmbeddingRecall{Index: embIndex}, Behavior: recSys},
+			}}),
+			rcmd.WithExplanations(rcmd.ExplainConfig{Similar: itemCF}),
+		)
+	}
+	if rounds := cfg.Serving.WarmUpRounds; rounds > 0 {
+		sampleKeys, err := warmUpSamples(trainCtx, recSys, warmUpSampleCnt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, rcmd.WithWarmUp(sampleKeys, rounds))
+	}
+	rcmd.StartHttpApi(model, cfg.Serving.Path, cfg.Serving.Addr, &f, opts...)
+}
+
+// newFitter returns the fitter of the model of t
+func newFitter(t *config.TrainingConfig) (rcmd.Fitter, error) {
+	var (
+		d        = float32(t.Dropout)
+		newModel func(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) model.Model
+		load     func(data []byte) (model.Model, error)
+	)
+	switch t.Model {
+	case "mlp":
+		fiter := nn.NewMLPClassifier(
+			[]int{100},
+			"relu", "adam", 1e-5,
+		)
+		fiter.Verbose = true
+		fiter.MaxIter = t.Epochs
+		fiter.BatchSize = t.BatchSize
+		fiter.LearningRateInit = t.LearningRate
+		// the epochs of no loss improvement, never reached if no early stop
+		fiter.NIterNoChange = t.Epochs
+		if t.EarlyStop > 0 {
+			fiter.NIterNoChange = t.EarlyStop
+		}
+		return &mlp.SimpleMlpFitWrap{Model: fiter}, nil
+	case "din":
+		newModel = func(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) model.Model {
+			var opts []din.Option
+			if d > 0 {
+				opts = append(opts, din.WithDropout(d, d))
+			}
+			if t.LatentCross {
+				opts = append(opts, din.WithLatentCross())
+			}
+			return din.NewDinNet(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim, opts...)
+		}
+		load = func(data []byte) (model.Model, error) { return din.NewDinNetFromJson(data) }
+	case "bst":
+		newModel = func(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) model.Model {
+			var opts []bst.Option
+			if d > 0 {
+				opts = append(opts, bst.WithDropout(d, d))
+			}
+			return bst.NewBstNet(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim, opts...)
+		}
+		load = func(data []byte) (model.Model, error) { return bst.NewBstNetFromJson(data) }
+	case "pnn":
+		newModel = func(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) model.Model {
+			var opts []pnn.Option
+			if d > 0 {
+				opts = append(opts, pnn.WithDropout(d, d))
+			}
+			return pnn.NewPnnNet(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim, opts...)
+		}
+		load = func(data []byte) (model.Model, error) { return pnn.NewPnnNetFromJson(data) }
+	case "youtube":
+		newModel = func(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) model.Model {
+			var opts []youtube.Option
+			if d > 0 {
+				opts = append(opts, youtube.WithDropout(d, d))
+			}
+			return youtube.NewYoutubeDnn(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim, opts...)
+		}
+		load = func(data []byte) (model.Model, error) { return youtube.NewYoutubeDnnFromJson(data) }
+	case "wide":
+		return &wide.Fitter{Options: wide.Options{
+			Epochs:       t.Epochs,
+			BatchSize:    t.BatchSize,
+			LearningRate: t.LearningRate,
+			LatentCross:  t.LatentCross,
+		}}, nil
+	case "gbdt":
+		// the trees score the samples, of the default options of package
+		// gbdt, the batches and the learning rate are of the neural models
+		return &gbdt.Fitter{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported training model %q", t.Model)
+	}
+	return &model.Fitter{
+		New:       newModel,
+		Load:      load,
+		BatchSize: t.BatchSize,
+		Epochs:    t.Epochs,
+		EarlyStop: t.EarlyStop,
+		Options:   []model.TrainOption{model.WithLearningRate(t.LearningRate)},
+	}, nil
+}
+
+// indexSurface is the surface recalled by the live indexes of
+// serving.index_rebuild
+const indexSurface = "index"
+
+// warmUpSampleCnt is the samples scored by every warm-up round
+const warmUpSampleCnt = 100
+
+// warmUpSamples returns the first n samples of recSys, the keys in the
+// stores to warm up the api by
+func warmUpSamples(ctx context.Context, recSys rcmd.Trainer, n int) (sampleKeys []rcmd.Sample, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err := recSys.SampleGenerator(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("warm up samples: %v", err)
+	}
+	for s := range ch {
+		if len(sampleKeys) < n {
+			sampleKeys = append(sampleKeys, s)
+		}
+	}
+	return
 }
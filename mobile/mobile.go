@@ -0,0 +1,247 @@
+// Package mobile is the on-device inference of the gomobile apps: the model
+// bundle trained on the server is embedded in the app and the candidates
+// are scored locally by the same assembler layout and forward graph as the
+// server. The exported API is gomobile compatible, the slices are passed by
+// Vector and IntList, so it's bound by:
+//
+//	gomobile bind -target=android,ios github.com/auxten/go-ctr/mobile github.com/auxten/go-ctr/client
+//
+// The sample vector of a candidate is assembled as the server does:
+//
+//	user profile | user behavior embeddings | item embedding | item feature
+//
+// The item feature of the app includes the values of the FeatureFuncs of the
+// model if any.
+package mobile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/auxten/go-ctr/client"
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/auxten/go-ctr/model/forward"
+	"github.com/auxten/go-ctr/model/signing"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/encoding"
+)
+
+// Vector is a float32 vector, gomobile passes no []float32
+type Vector struct {
+	v []float32
+}
+
+func NewVector() *Vector {
+	return &Vector{}
+}
+
+// VectorOfBytes returns the Vector of the little endian float32s of b
+func VectorOfBytes(b []byte) (*Vector, error) {
+	if len(b)%4 != 0 {
+		return nil, fmt.Errorf("%d bytes are not of float32s", len(b))
+	}
+	v := make([]float32, len(b)/4)
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, v); err != nil {
+		return nil, err
+	}
+	return &Vector{v: v}, nil
+}
+
+func (v *Vector) Append(f float32) {
+	v.v = append(v.v, f)
+}
+
+func (v *Vector) Len() int {
+	return len(v.v)
+}
+
+func (v *Vector) Get(i int) float32 {
+	return v.v[i]
+}
+
+// Bytes is the little endian float32s of v
+func (v *Vector) Bytes() []byte {
+	b := make([]byte, 4*len(v.v))
+	for i, f := range v.v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+// IntList is an int list, gomobile passes no []int
+type IntList struct {
+	l []int
+}
+
+func NewIntList() *IntList {
+	return &IntList{}
+}
+
+func (l *IntList) Append(i int) {
+	l.l = append(l.l, i)
+}
+
+func (l *IntList) Len() int {
+	return len(l.l)
+}
+
+func (l *IntList) Get(i int) int {
+	return l.l[i]
+}
+
+// Batch is the candidates of a user to be scored
+type Batch struct {
+	userFeature  []float32
+	behaviors    []int
+	itemIds      []int
+	itemFeatures [][]float32
+}
+
+// NewBatch creates the Batch of the user profile and behaviors, the latest
+// first. behaviors could be nil.
+func NewBatch(userFeature *Vector, behaviors *IntList) *Batch {
+	b := &Batch{userFeature: append([]float32(nil), userFeature.v...)}
+	if behaviors != nil {
+		b.behaviors = append([]int(nil), behaviors.l...)
+	}
+	return b
+}
+
+// NewClientBatch creates the Batch of the profile of the client SDK
+func NewClientBatch(c *client.Client) *Batch {
+	p := c.Profile()
+	return &Batch{userFeature: p.UserFeature, behaviors: p.Behaviors}
+}
+
+// Add adds the candidate of itemId and its item features
+func (b *Batch) Add(itemId int, itemFeature *Vector) {
+	b.itemIds = append(b.itemIds, itemId)
+	b.itemFeatures = append(b.itemFeatures, append([]float32(nil), itemFeature.v...))
+}
+
+func (b *Batch) Len() int {
+	return len(b.itemIds)
+}
+
+// Scorer scores the batches by the model of a bundle, it's safe for
+// concurrent use
+type Scorer struct {
+	model      *forward.Model
+	si         *rcmd.SampleInfo
+	normalizer *rcmd.Normalizer
+	embeddings word2vec.EmbeddingMap32
+}
+
+// NewScorer loads the bundle of model.MarshalBundle of modelType, one of
+// forward.ModelTypes, and the item embeddings table of
+// rcmd.ExportItemEmbeddings. embeddings could be nil of the zero embeddings.
+// The Normalizer of the bundle manifest is applied as the server does.
+func NewScorer(modelType string, bundle []byte, embeddings []byte) (s *Scorer, err error) {
+	s = &Scorer{}
+	if s.model, err = forward.Load(modelType, bundle, 0); err != nil {
+		return nil, err
+	}
+	s.si = s.model.SampleInfo()
+	if manifest := s.model.Manifest(); manifest != nil {
+		s.normalizer = manifest.Normalizer
+	}
+	if len(embeddings) != 0 {
+		if s.embeddings, err = rcmd.ReadEmbeddings(bytes.NewReader(embeddings), encoding.ItemEmbDim); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// NewVerifiedScorer is NewScorer of the bundle verified by signature, the
+// ed25519 signature of the bundle, and publicKey, so the tampered or
+// corrupted bundle downloaded is refused
+func NewVerifiedScorer(modelType string, bundle, signature, publicKey []byte, embeddings []byte) (*Scorer, error) {
+	if err := signing.Verify(publicKey, bundle, signature); err != nil {
+		return nil, err
+	}
+	return NewScorer(modelType, bundle, embeddings)
+}
+
+func (s *Scorer) width(r [2]int) int {
+	return r[1] - r[0]
+}
+
+func (s *Scorer) embedding(itemId int) ([]float32, bool) {
+	return s.embeddings.Get(strconv.Itoa(itemId))
+}
+
+// assemble returns the row major sample vectors of b
+func (s *Scorer) assemble(b *Batch) (x []float32, err error) {
+	if len(b.userFeature) != s.width(s.si.UserProfileRange) {
+		return nil, fmt.Errorf("user feature of width %d != %d", len(b.userFeature), s.width(s.si.UserProfileRange))
+	}
+	var (
+		behaviors = encoding.BehaviorTensor(b.behaviors, s.embedding)
+		zeroEmb   = make([]float32, encoding.ItemEmbDim)
+		width     = s.si.Width()
+	)
+	x = make([]float32, 0, width*len(b.itemIds))
+	for i, itemId := range b.itemIds {
+		if len(b.itemFeatures[i]) != s.width(s.si.CtxFeatureRange) {
+			return nil, fmt.Errorf("item %d feature of width %d != %d", itemId, len(b.itemFeatures[i]), s.width(s.si.CtxFeatureRange))
+		}
+		emb, ok := s.embedding(itemId)
+		if !ok {
+			emb = zeroEmb
+		}
+		x = append(x, b.userFeature...)
+		x = append(x, behaviors...)
+		x = append(x, emb...)
+		x = append(x, b.itemFeatures[i]...)
+		if s.normalizer != nil {
+			if err = s.normalizer.Apply(x[i*width:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
+
+// Score returns the scores of the candidates of b in order
+func (s *Scorer) Score(b *Batch) (*Vector, error) {
+	if b.Len() == 0 {
+		return NewVector(), nil
+	}
+	x, err := s.assemble(b)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := s.model.Predict(b.Len(), x)
+	if err != nil {
+		return nil, err
+	}
+	return &Vector{v: scores}, nil
+}
+
+// TopK returns the item ids of the top k candidates of b by score
+func (s *Scorer) TopK(b *Batch, k int) (*IntList, error) {
+	scores, err := s.Score(b)
+	if err != nil {
+		return nil, err
+	}
+	order := make([]int, b.Len())
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores.v[order[i]] > scores.v[order[j]]
+	})
+	if k < len(order) {
+		order = order[:k]
+	}
+	top := NewIntList()
+	for _, i := range order {
+		top.Append(b.itemIds[i])
+	}
+	return top, nil
+}
@@ -0,0 +1,155 @@
+package mobile
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/auxten/go-ctr/client"
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/din"
+	"github.com/auxten/go-ctr/model/signing"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/encoding"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+func TestScorer(t *testing.T) {
+	si, err := rcmd.NewSampleInfoBuilder().
+		UserProfile(2).
+		UserBehavior(encoding.UserBehaviorLen, encoding.ItemEmbDim).
+		ItemFeature(encoding.ItemEmbDim).
+		CtxFeature(1).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	net := din.NewDinNet(2, encoding.UserBehaviorLen, encoding.ItemEmbDim, encoding.ItemEmbDim, 1)
+	bundle, err := model.MarshalBundle(net, si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emb := func(v float32) []float32 {
+		e := make([]float32, encoding.ItemEmbDim)
+		for i := range e {
+			e[i] = v
+		}
+		return e
+	}
+	var embeddings bytes.Buffer
+	if err = rcmd.WriteEmbeddings(&embeddings, word2vec.EmbeddingMap32{"1": emb(0.1), "2": emb(-0.2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("scores of the server layout", t, func() {
+		s, err := NewScorer("din", bundle, embeddings.Bytes())
+		So(err, ShouldBeNil)
+		user := NewVector()
+		user.Append(0.5)
+		user.Append(1)
+		behaviors := NewIntList()
+		behaviors.Append(2)
+		b := NewBatch(user, behaviors)
+		for _, id := range []int{1, 2, 3} {
+			f := NewVector()
+			f.Append(float32(id))
+			b.Add(id, f)
+		}
+		scores, err := s.Score(b)
+		So(err, ShouldBeNil)
+		So(scores.Len(), ShouldEqual, 3)
+
+		// the vectors assembled by hand scored by the model of the bundle
+		_, data, err := model.UnmarshalBundle(bundle)
+		So(err, ShouldBeNil)
+		m, err := din.NewDinNetFromJson(data)
+		So(err, ShouldBeNil)
+		So(model.InitForwardOnlyVm(2, encoding.UserBehaviorLen, encoding.ItemEmbDim, encoding.ItemEmbDim, 1, 4, m), ShouldBeNil)
+		var x []float32
+		for _, id := range []int{1, 2, 3} {
+			x = append(x, 0.5, 1)
+			x = append(x, emb(-0.2)...)
+			x = append(x, make([]float32, encoding.ItemEmbDim*(encoding.UserBehaviorLen-1))...)
+			switch id {
+			case 1:
+				x = append(x, emb(0.1)...)
+			case 2:
+				x = append(x, emb(-0.2)...)
+			default:
+				x = append(x, make([]float32, encoding.ItemEmbDim)...)
+			}
+			x = append(x, float32(id))
+		}
+		y, err := model.Predict(m, 3, 4, si, tensor.New(tensor.WithShape(3, si.Width()), tensor.WithBacking(x)))
+		So(err, ShouldBeNil)
+		for i := range y {
+			So(scores.Get(i), ShouldAlmostEqual, y[i], 1e-5)
+		}
+
+		top, err := s.TopK(b, 2)
+		So(err, ShouldBeNil)
+		So(top.Len(), ShouldEqual, 2)
+		So(scores.Get(idx(top.Get(0))), ShouldBeGreaterThanOrEqualTo, scores.Get(idx(top.Get(1))))
+
+		empty, err := s.Score(NewBatch(user, nil))
+		So(err, ShouldBeNil)
+		So(empty.Len(), ShouldEqual, 0)
+
+		// the client profile is of the count features
+		c := client.New(1)
+		c.Record(2, true)
+		cb := NewClientBatch(c)
+		f := NewVector()
+		f.Append(1)
+		cb.Add(1, f)
+		_, err = s.Score(cb)
+		So(err, ShouldBeNil)
+
+		wrong := NewBatch(NewVector(), nil)
+		wrong.Add(1, NewVector())
+		_, err = s.Score(wrong)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("bad bundles", t, func() {
+		_, err := NewScorer("gbdt", bundle, nil)
+		So(err, ShouldNotBeNil)
+		_, err = NewScorer("din", []byte("{}"), nil)
+		So(err, ShouldNotBeNil)
+		_, err = NewScorer("din", bundle, []byte("1 0.5\n"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("signed bundles", t, func() {
+		pub, priv, err := signing.GenerateKey()
+		So(err, ShouldBeNil)
+		sig := signing.Sign(priv, bundle)
+		_, err = NewVerifiedScorer("din", bundle, sig, pub, nil)
+		So(err, ShouldBeNil)
+		_, err = NewVerifiedScorer("din", bundle, nil, pub, nil)
+		So(err, ShouldEqual, signing.ErrUnsigned)
+		tampered := append([]byte(nil), bundle...)
+		tampered[len(tampered)/2] ^= 1
+		_, err = NewVerifiedScorer("din", tampered, sig, pub, nil)
+		So(err, ShouldEqual, signing.ErrBadSignature)
+	})
+}
+
+// idx is the index of the item id of the candidates 1, 2, 3
+func idx(itemId int) int {
+	return itemId - 1
+}
+
+func TestVector(t *testing.T) {
+	Convey("vector bytes round trip", t, func() {
+		v := NewVector()
+		v.Append(1.5)
+		v.Append(-2)
+		read, err := VectorOfBytes(v.Bytes())
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, v)
+		_, err = VectorOfBytes([]byte{1, 2, 3})
+		So(err, ShouldNotBeNil)
+	})
+}
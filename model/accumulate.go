@@ -0,0 +1,99 @@
+package model
+
+import (
+	G "gorgonia.org/gorgonia"
+)
+
+// WithGradientAccumulation accumulates the gradients of steps micro-batches
+// before a solver step, which trains by the effective batch size of
+// steps * batchSize in the memory of batchSize. The step is by the mean of
+// the accumulated gradients, as the loss of the mean over all the
+// micro-batches.
+func WithGradientAccumulation(steps int) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.AccumulationSteps = steps
+	}
+}
+
+// gradAccumulator sums the gradients of the micro-batches run by the replicas
+// until the solver step. The gradients are zeroed once summed, since the
+// tape machines accumulate them into the nodes until the solver step.
+type gradAccumulator struct {
+	replicas []*replica
+	// steps is the runs of the replicas accumulated for a solver step
+	steps int
+	// sums of every learnable node of the trained Model, lazily allocated
+	sums    [][]float32
+	runs    int
+	batches int
+}
+
+func newGradAccumulator(replicas []*replica, steps int) *gradAccumulator {
+	if steps < 1 {
+		steps = 1
+	}
+	return &gradAccumulator{replicas: replicas, steps: steps}
+}
+
+// add accumulates the gradients of the first n replicas just run, it
+// returns true if a solver step is due
+func (a *gradAccumulator) add(n int) (step bool, err error) {
+	if a.steps == 1 && n == 1 {
+		// the gradients of the trained Model are the mean already
+		a.batches = 1
+		return true, nil
+	}
+	master := a.replicas[0].m.Learnable()
+	if a.sums == nil {
+		a.sums = make([][]float32, len(master))
+	}
+	for j := range master {
+		for _, r := range a.replicas[:n] {
+			var grad G.Value
+			if grad, err = r.m.Learnable()[j].Grad(); err != nil {
+				return
+			}
+			data := grad.Data().([]float32)
+			if a.sums[j] == nil {
+				a.sums[j] = make([]float32, len(data))
+			}
+			for k, v := range data {
+				a.sums[j][k] += v
+				data[k] = 0
+			}
+		}
+	}
+	a.runs++
+	a.batches += n
+	return a.runs >= a.steps, nil
+}
+
+// pending is true if any gradient is accumulated but not stepped
+func (a *gradAccumulator) pending() bool {
+	return a.batches != 0
+}
+
+// flush sets the gradients of the trained Model to the mean of the
+// accumulated and resets the accumulator
+func (a *gradAccumulator) flush() (err error) {
+	defer func() {
+		a.runs, a.batches = 0, 0
+	}()
+	if a.runs == 0 {
+		// stepped by the gradients of the trained Model as is
+		return
+	}
+	scale := 1 / float32(a.batches)
+	for j, node := range a.replicas[0].m.Learnable() {
+		var grad G.Value
+		if grad, err = node.Grad(); err != nil {
+			return
+		}
+		data := grad.Data().([]float32)
+		for k, v := range a.sums[j] {
+			data[k] = v * scale
+			a.sums[j][k] = 0
+		}
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"fmt"
+	"math"
+
+	G "gorgonia.org/gorgonia"
+)
+
+// AdamState is the state of the Adam solver to resume a training run
+type AdamState struct {
+	// Iter is the solver steps taken
+	Iter int `json:"iter"`
+	// M and V are the moving averages of the gradients and the squared
+	// gradients of every learnable node
+	M [][]float32 `json:"m"`
+	V [][]float32 `json:"v"`
+}
+
+// adamSolver is the Adam solver of Train. It updates the same as
+// gorgonia.AdamSolver, which keeps its moments unexported, so the moments
+// can be checkpointed without changing the trained models.
+type adamSolver struct {
+	// the hyper parameters are float64 as of gorgonia.AdamSolver, the bias
+	// corrections are computed in float64
+	eta, eps, beta1, beta2 float64
+	l2reg                  float64
+	batch                  float64
+	state                  AdamState
+}
+
+func newAdamSolver(learnRate float64, batchSize int, l2reg float64) *adamSolver {
+	return &adamSolver{
+		eta:   learnRate,
+		eps:   1e-8,
+		beta1: 0.9,
+		beta2: 0.999,
+		l2reg: l2reg,
+		batch: float64(batchSize),
+	}
+}
+
+// Step implements gorgonia.Solver, the gradients are zeroed after the step
+func (s *adamSolver) Step(model []G.ValueGrad) (err error) {
+	if s.state.M == nil {
+		s.state.M = make([][]float32, len(model))
+		s.state.V = make([][]float32, len(model))
+	}
+	if len(model) != len(s.state.M) {
+		return fmt.Errorf("adam solver of %d nodes steps %d", len(s.state.M), len(model))
+	}
+	s.state.Iter++
+	var (
+		correction1 = float32(1) / float32(1-math.Pow(s.beta1, float64(s.state.Iter)))
+		correction2 = float32(1) / float32(1-math.Pow(s.beta2, float64(s.state.Iter)))
+		beta1       = float32(s.beta1)
+		beta2       = float32(s.beta2)
+		omb1        = float32(1) - beta1
+		omb2        = float32(1) - beta2
+		eps         = float32(s.eps)
+		eta         = -float32(s.eta)
+		l2reg       = float32(s.l2reg)
+		onePerBatch = float32(1) / float32(s.batch)
+	)
+	for i, n := range model {
+		var grad G.Value
+		if grad, err = n.Grad(); err != nil {
+			return
+		}
+		w, ok := n.Value().Data().([]float32)
+		g, gOk := grad.Data().([]float32)
+		if !ok || !gOk {
+			return fmt.Errorf("adam solver supports float32 tensors only")
+		}
+		if s.state.M[i] == nil {
+			s.state.M[i] = make([]float32, len(w))
+			s.state.V[i] = make([]float32, len(w))
+		}
+		m, v := s.state.M[i], s.state.V[i]
+		if len(m) != len(w) || len(g) != len(w) {
+			return fmt.Errorf("adam solver node %d size %d != %d", i, len(w), len(m))
+		}
+		for k := range w {
+			gk := g[k] + w[k]*l2reg
+			if s.batch > 1 {
+				gk *= onePerBatch
+			}
+			m[k] = gk*omb1 + m[k]*beta1
+			v[k] = gk*gk*omb2 + v[k]*beta2
+			mHat := m[k] * correction1 * eta
+			vHat := float32(math.Sqrt(float64(v[k]*correction2))) + eps
+			// as gorgonia.AdamSolver, the update is added with the scaled
+			// moment itself
+			w[k] += mHat / vHat
+			w[k] += mHat
+			g[k] = 0
+		}
+	}
+	return
+}
+
+// State returns a copy of the solver state
+func (s *adamSolver) State() AdamState {
+	state := AdamState{Iter: s.state.Iter}
+	for i := range s.state.M {
+		state.M = append(state.M, append([]float32(nil), s.state.M[i]...))
+		state.V = append(state.V, append([]float32(nil), s.state.V[i]...))
+	}
+	return state
+}
+
+// SetState restores the solver state of nodes learnable nodes
+func (s *adamSolver) SetState(state AdamState, nodes G.Nodes) error {
+	if state.M == nil {
+		// not stepped yet
+		s.state = AdamState{Iter: state.Iter}
+		return nil
+	}
+	if len(state.M) != len(nodes) || len(state.V) != len(nodes) {
+		return fmt.Errorf("adam state of %d nodes != %d", len(state.M), len(nodes))
+	}
+	for i, n := range nodes {
+		size := n.Shape().TotalSize()
+		if len(state.M[i]) != size || len(state.V[i]) != size {
+			return fmt.Errorf("adam state of node %s size %d != %d", n.Name(), len(state.M[i]), size)
+		}
+	}
+	s.state = AdamState{Iter: state.Iter}
+	for i := range state.M {
+		s.state.M = append(s.state.M, append([]float32(nil), state.M[i]...))
+		s.state.V = append(s.state.V, append([]float32(nil), state.V[i]...))
+	}
+	return nil
+}
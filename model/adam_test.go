@@ -0,0 +1,81 @@
+package model
+
+import (
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// newQuadratic returns the learnable w of the cost sum((x * w)^2)
+func newQuadratic(init []float32, x []float32) (w *G.Node, vm G.VM) {
+	g := G.NewGraph()
+	w = G.NewVector(g, DT, G.WithShape(len(init)), G.WithName("w"),
+		G.WithValue(tensor.New(tensor.WithBacking(append([]float32(nil), init...)))))
+	xn := G.NewVector(g, DT, G.WithShape(len(x)), G.WithName("x"),
+		G.WithValue(tensor.New(tensor.WithBacking(x))))
+	cost := G.Must(G.Sum(G.Must(G.Square(G.Must(G.HadamardProd(w, xn))))))
+	if _, err := G.Grad(cost, w); err != nil {
+		panic(err)
+	}
+	return w, G.NewTapeMachine(g, G.BindDualValues(w))
+}
+
+func TestAdamSolver(t *testing.T) {
+	rand.Seed(42)
+	init := make([]float32, 16)
+	x := make([]float32, 16)
+	for i := range init {
+		init[i] = rand.Float32()*2 - 1
+		x[i] = rand.Float32()
+	}
+
+	Convey("Adam solver steps as gorgonia", t, func() {
+		want, wantVm := newQuadratic(init, x)
+		got, gotVm := newQuadratic(init, x)
+		wantSolver := G.NewAdamSolver(G.WithLearnRate(0.01), G.WithBatchSize(8), G.WithL2Reg(0.0001))
+		gotSolver := newAdamSolver(0.01, 8, 0.0001)
+		for i := 0; i < 20; i++ {
+			So(wantVm.RunAll(), ShouldBeNil)
+			So(gotVm.RunAll(), ShouldBeNil)
+			So(wantSolver.Step(G.NodesToValueGrads(G.Nodes{want})), ShouldBeNil)
+			So(gotSolver.Step(G.NodesToValueGrads(G.Nodes{got})), ShouldBeNil)
+			wantVm.Reset()
+			gotVm.Reset()
+		}
+		So(got.Value().Data(), ShouldResemble, want.Value().Data())
+	})
+
+	Convey("Adam solver resumed by its state", t, func() {
+		full, fullVm := newQuadratic(init, x)
+		solver := newAdamSolver(0.01, 1, 0)
+		var (
+			state   AdamState
+			weights []float32
+		)
+		for i := 0; i < 10; i++ {
+			if i == 5 {
+				state = solver.State()
+				weights = append([]float32(nil), full.Value().Data().([]float32)...)
+			}
+			So(fullVm.RunAll(), ShouldBeNil)
+			So(solver.Step(G.NodesToValueGrads(G.Nodes{full})), ShouldBeNil)
+			fullVm.Reset()
+		}
+
+		resumed, resumedVm := newQuadratic(weights, x)
+		resumedSolver := newAdamSolver(0.01, 1, 0)
+		So(resumedSolver.SetState(state, G.Nodes{resumed}), ShouldBeNil)
+		for i := 5; i < 10; i++ {
+			So(resumedVm.RunAll(), ShouldBeNil)
+			So(resumedSolver.Step(G.NodesToValueGrads(G.Nodes{resumed})), ShouldBeNil)
+			resumedVm.Reset()
+		}
+		So(resumed.Value().Data(), ShouldResemble, full.Value().Data())
+		So(resumedSolver.State().Iter, ShouldEqual, 10)
+
+		So(resumedSolver.SetState(AdamState{Iter: 1, M: [][]float32{{1}}, V: [][]float32{{1}}}, G.Nodes{resumed}), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,195 @@
+package model
+
+import (
+	"fmt"
+
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// batchFeed is an input node of a batch with the backing of batchSize rows
+// allocated once. The rows of a batch are copied into the backing in place,
+// so every batch lets the same tensor instead of slicing the source and
+// filling the last batch by a new tensor.
+type batchFeed struct {
+	node *G.Node
+	name string
+	// src is the row major source of width columns, the columns
+	// [cols[0], cols[1]) of the rows are fed
+	src     []float32
+	width   int
+	cols    [2]int
+	backing []float32
+	val     tensor.Tensor
+	// half rounds the rows to float16, see MixedPrecision
+	half bool
+}
+
+// preparedBatch is the feeds of the inputs and targets of a replica
+type preparedBatch struct {
+	feeds []*batchFeed
+	ids   []*idFeed
+}
+
+// rowMajor returns the row major data of t and its row width, the views are
+// materialized once
+func rowMajor(t tensor.Tensor) (data []float32, width int, err error) {
+	if d, ok := t.(*tensor.Dense); ok && d.IsView() {
+		t = d.Materialize()
+	}
+	var ok bool
+	if data, ok = t.Data().([]float32); !ok {
+		return nil, 0, fmt.Errorf("tensor of %T data, not []float32", t.Data())
+	}
+	width = 1
+	for _, d := range t.Shape()[1:] {
+		width *= d
+	}
+	return
+}
+
+// newBatchFeed returns the feed of node of the columns cols of src
+func newBatchFeed(node *G.Node, name string, src []float32, width int, cols [2]int) (*batchFeed, error) {
+	shape := node.Shape()
+	if len(shape) != 2 || shape[1] != cols[1]-cols[0] || cols[0] < 0 || cols[1] > width {
+		return nil, fmt.Errorf("%s of shape %v not fed by columns %v of width %d", name, shape, cols, width)
+	}
+	f := &batchFeed{
+		node:    node,
+		name:    name,
+		src:     src,
+		width:   width,
+		cols:    cols,
+		backing: make([]float32, shape[0]*shape[1]),
+	}
+	f.val = tensor.New(tensor.WithShape(shape[0], shape[1]), tensor.WithBacking(f.backing))
+	return f, nil
+}
+
+// let copies the rows [start, end) into the backing, the rows short of the
+// batch size are zeros
+func (f *batchFeed) let(start, end int) error {
+	dim := f.cols[1] - f.cols[0]
+	if (end-start)*dim > len(f.backing) || end*f.width > len(f.src) {
+		return fmt.Errorf("unable to let %s: rows [%d, %d) out of range", f.name, start, end)
+	}
+	n := 0
+	for i := start; i < end; i++ {
+		row := f.src[i*f.width:]
+		copied := copy(f.backing[n:n+dim], row[f.cols[0]:f.cols[1]])
+		if f.half {
+			for k := n; k < n+copied; k++ {
+				f.backing[k] = roundHalf(f.backing[k])
+			}
+		}
+		n += copied
+	}
+	for i := n; i < len(f.backing); i++ {
+		f.backing[i] = 0
+	}
+	if err := G.Let(f.node, f.val); err != nil {
+		return fmt.Errorf("unable to let %s: %v", f.name, err)
+	}
+	return nil
+}
+
+func (b *preparedBatch) let(start, end int) error {
+	for _, f := range b.feeds {
+		if err := f.let(start, end); err != nil {
+			return err
+		}
+	}
+	for _, f := range b.ids {
+		if err := f.let(start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IdInputer is implemented by the Model of the id inputs besides the
+// feature inputs, e.g. the bias table rows of the users and items. Train
+// and Predict feed the ids of the columns of SampleInfo.IdRange.
+type IdInputer interface {
+	IdInputs() []IdInput
+}
+
+// IdInput is the ids of the column Column of SampleInfo.IdRange of a table
+// of Rows rows. Ids is the tensor.Int vector of the batch, Mask is the
+// [batchSize, 1] matrix of 0 of the rows of id 0, reserved of the unseen
+// ids, and 1 of the others, so the masked lookups of the row 0 get no
+// gradient.
+type IdInput struct {
+	Column int
+	Rows   int
+	Ids    *G.Node
+	Mask   *G.Node
+}
+
+// idFeed is the batch feed of an IdInput of the float32 ids of src
+type idFeed struct {
+	in      IdInput
+	src     []float32
+	width   int
+	col     int
+	ids     []int
+	mask    []float32
+	idsVal  tensor.Tensor
+	maskVal tensor.Tensor
+}
+
+// newIdFeeds returns the feeds of the id inputs of m if it's an IdInputer
+func newIdFeeds(m Model, src []float32, width int, idRange [2]int) (feeds []*idFeed, err error) {
+	inputer, ok := m.(IdInputer)
+	if !ok {
+		return
+	}
+	for _, in := range inputer.IdInputs() {
+		if in.Column < 0 || idRange[0]+in.Column >= idRange[1] || idRange[1] > width {
+			return nil, fmt.Errorf("%s of column %d not fed by IdRange %v of width %d", in.Ids.Name(), in.Column, idRange, width)
+		}
+		batchSize := in.Ids.Shape()[0]
+		f := &idFeed{
+			in:    in,
+			src:   src,
+			width: width,
+			col:   idRange[0] + in.Column,
+			ids:   make([]int, batchSize),
+			mask:  make([]float32, batchSize),
+		}
+		f.idsVal = tensor.New(tensor.WithShape(batchSize), tensor.WithBacking(f.ids))
+		f.maskVal = tensor.New(tensor.WithShape(batchSize, 1), tensor.WithBacking(f.mask))
+		feeds = append(feeds, f)
+	}
+	return
+}
+
+// let feeds the ids of the rows [start, end), the rows short of the batch
+// size are of id 0
+func (f *idFeed) let(start, end int) error {
+	name := f.in.Ids.Name()
+	if end-start > len(f.ids) || end*f.width > len(f.src) {
+		return fmt.Errorf("unable to let %s: rows [%d, %d) out of range", name, start, end)
+	}
+	for i := range f.ids {
+		f.ids[i], f.mask[i] = 0, 0
+		if start+i >= end {
+			continue
+		}
+		v := f.src[(start+i)*f.width+f.col]
+		id := int(v)
+		if float32(id) != v || id < 0 || id >= f.in.Rows {
+			return fmt.Errorf("unable to let %s: id %v of row %d not in [0, %d)", name, v, start+i, f.in.Rows)
+		}
+		if f.ids[i] = id; id != 0 {
+			f.mask[i] = 1
+		}
+	}
+	if err := G.Let(f.in.Ids, f.idsVal); err != nil {
+		return fmt.Errorf("unable to let %s: %v", name, err)
+	}
+	if err := G.Let(f.in.Mask, f.maskVal); err != nil {
+		return fmt.Errorf("unable to let %s mask: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+func TestPreparedBatch(t *testing.T) {
+	Convey("rows copied into the prepared backing", t, func() {
+		g := G.NewGraph()
+		node := G.NewMatrix(g, DT, G.WithShape(2, 2), G.WithName("x"))
+		// 3 rows of 3 columns, the columns [1, 3) are fed
+		src := []float32{
+			1, 2, 3,
+			4, 5, 6,
+			7, 8, 9,
+		}
+		f, err := newBatchFeed(node, "x", src, 3, [2]int{1, 3})
+		So(err, ShouldBeNil)
+		So(f.let(0, 2), ShouldBeNil)
+		So(node.Value().Data(), ShouldResemble, []float32{2, 3, 5, 6})
+
+		// the last batch is filled with zeros in the same backing
+		So(f.let(2, 3), ShouldBeNil)
+		So(node.Value().Data(), ShouldResemble, []float32{8, 9, 0, 0})
+		So(&node.Value().Data().([]float32)[0], ShouldEqual, &f.backing[0])
+
+		So(f.let(0, 3), ShouldNotBeNil)
+		_, err = newBatchFeed(node, "x", src, 3, [2]int{0, 3})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("row major data of the views", t, func() {
+		x := tensor.New(tensor.WithShape(3, 2), tensor.WithBacking([]float32{1, 2, 3, 4, 5, 6}))
+		view, err := x.Slice(G.S(1, 3))
+		So(err, ShouldBeNil)
+		data, width, err := rowMajor(view)
+		So(err, ShouldBeNil)
+		So(width, ShouldEqual, 2)
+		So(data, ShouldResemble, []float32{3, 4, 5, 6})
+
+		_, _, err = rowMajor(tensor.New(tensor.WithShape(2), tensor.WithBacking([]float64{1, 2})))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// benchmarkSource is the inputs of 950 rows of the 4 input ranges, the
+// last batch of 100 rows is filled
+func benchmarkSource() (x tensor.Tensor, nodes []*G.Node, ranges [][2]int) {
+	const rows, batchSize = 950, 100
+	data := make([]float32, rows*40)
+	for i := range data {
+		data[i] = float32(i)
+	}
+	x = tensor.New(tensor.WithShape(rows, 40), tensor.WithBacking(data))
+	g := G.NewGraph()
+	ranges = [][2]int{{0, 5}, {5, 25}, {25, 35}, {35, 40}}
+	for _, r := range ranges {
+		nodes = append(nodes, G.NewMatrix(g, DT, G.WithShape(batchSize, r[1]-r[0])))
+	}
+	return
+}
+
+// BenchmarkSliceBatch is the inputs fed by slicing and filling every batch
+func BenchmarkSliceBatch(b *testing.B) {
+	x, nodes, ranges := benchmarkSource()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for start := 0; start < 950; start += 100 {
+			end := start + 100
+			if end > 950 {
+				end = 950
+			}
+			for j, r := range ranges {
+				var (
+					val tensor.Tensor
+					err error
+				)
+				val, err = x.Slice(G.S(start, end), G.S(r[0], r[1]))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if val.Shape()[0] < 100 {
+					if val, err = FillTensorRows(100, val); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err = G.Let(nodes[j], val); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkPreparedBatch is the inputs fed by the prepared batch
+func BenchmarkPreparedBatch(b *testing.B) {
+	x, nodes, ranges := benchmarkSource()
+	data, width, err := rowMajor(x)
+	if err != nil {
+		b.Fatal(err)
+	}
+	batch := &preparedBatch{}
+	for j, r := range ranges {
+		f, err := newBatchFeed(nodes[j], "x", data, width, r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		batch.feeds = append(batch.feeds, f)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for start := 0; start < 950; start += 100 {
+			end := start + 100
+			if end > 950 {
+				end = 950
+			}
+			if err = batch.let(start, end); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
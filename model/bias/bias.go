@@ -0,0 +1,235 @@
+// Package bias adds the per-user and per-item bias terms to the logit of a
+// model, the embeddings of size 1 of the hashed tables of the
+// rcmd.BiasBuckets rows, trained with the model by the same cost. The
+// baseline propensities of the users and items are captured cheaply, and as
+// the biases are in the graph the model is served as is, with no wrapper of
+// the Predictor.
+//
+// The rows are fed by model.Train and model.Predict of the columns of
+// SampleInfo.IdRange, see rcmd.SampleOptions.Biases. The row 0 is reserved
+// of the ids unseen in the training: its lookups are masked, so it gets no
+// gradient and keeps the bias 0.
+package bias
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/layers"
+	G "gorgonia.org/gorgonia"
+)
+
+// BiasedModel is the inner model of the logit added by the biases, the
+// inner model should be a model.LogitsOutputer. The head is applied to the
+// biased logit.
+type BiasedModel struct {
+	inner model.Model
+	head  model.Head
+
+	// user and item are the tables of [rows, 1], nil of no rows
+	user, item *G.Node
+	// the id inputs of the batch of Fwd
+	ids []model.IdInput
+
+	logits, out *G.Node
+}
+
+type biasedModel struct {
+	Inner    json.RawMessage `json:"inner"`
+	Head     model.Head      `json:"head,omitempty"`
+	UserRows int             `json:"userRows,omitempty"`
+	ItemRows int             `json:"itemRows,omitempty"`
+	User     []float32       `json:"user,omitempty"`
+	Item     []float32       `json:"item,omitempty"`
+}
+
+// NewBiasedModel returns inner biased by the user and item tables of the
+// rows, e.g. of rcmd.BiasBuckets.UserRows and ItemRows of the TrainSample,
+// a table of 0 rows is left out. The biases are initialized to 0.
+func NewBiasedModel(inner model.Model, userRows, itemRows int) *BiasedModel {
+	return newBiasedModel(inner, &biasedModel{UserRows: userRows, ItemRows: itemRows})
+}
+
+func newBiasedModel(inner model.Model, m *biasedModel) *BiasedModel {
+	b := &BiasedModel{inner: inner, head: m.Head}
+	if m.UserRows > 0 {
+		b.user = layers.NewWeight(inner.Graph(), "userBias", m.UserRows, 1, G.Zeroes(), m.User)
+	}
+	if m.ItemRows > 0 {
+		b.item = layers.NewWeight(inner.Graph(), "itemBias", m.ItemRows, 1, G.Zeroes(), m.Item)
+	}
+	return b
+}
+
+// NewBiasedModelFromJson restores the BiasedModel marshaled, the inner model
+// is restored by innerFromJson, e.g. of din.NewDinNetFromJson
+func NewBiasedModelFromJson(data []byte, innerFromJson func(data []byte) (model.Model, error)) (b *BiasedModel, err error) {
+	var m biasedModel
+	if err = json.Unmarshal(data, &m); err != nil {
+		return
+	}
+	if len(m.User) != m.UserRows || len(m.Item) != m.ItemRows {
+		return nil, fmt.Errorf("%d user and %d item biases of %d and %d rows", len(m.User), len(m.Item), m.UserRows, m.ItemRows)
+	}
+	inner, err := innerFromJson(m.Inner)
+	if err != nil {
+		return
+	}
+	return newBiasedModel(inner, &m), nil
+}
+
+// Inner is the model biased
+func (b *BiasedModel) Inner() model.Model {
+	return b.inner
+}
+
+// Biases are the trained biases of the table rows, nil of no table
+func (b *BiasedModel) Biases() (user, item []float32) {
+	return tableData(b.user), tableData(b.item)
+}
+
+func tableData(table *G.Node) []float32 {
+	if table == nil {
+		return nil
+	}
+	return table.Value().Data().([]float32)
+}
+
+// EmbeddingTables implements layers.EmbeddingTabler, "user" and "item" are
+// the bias tables of rows of 1 dim, if any
+func (b *BiasedModel) EmbeddingTables() (names []string) {
+	if b.user != nil {
+		names = append(names, "user")
+	}
+	if b.item != nil {
+		names = append(names, "item")
+	}
+	return
+}
+
+// WithEmbeddingTable implements layers.EmbeddingTabler
+func (b *BiasedModel) WithEmbeddingTable(name string, fn func(table *G.Node) error) error {
+	table := map[string]*G.Node{"user": b.user, "item": b.item}[name]
+	if table == nil {
+		return fmt.Errorf("biased model has no bias table %q", name)
+	}
+	return fn(table)
+}
+
+func (b *BiasedModel) Marshal() (data []byte, err error) {
+	m := biasedModel{Head: b.head}
+	if m.Inner, err = b.inner.Marshal(); err != nil {
+		return
+	}
+	if m.User = tableData(b.user); m.User != nil {
+		m.UserRows = b.user.Shape()[0]
+	}
+	if m.Item = tableData(b.item); m.Item != nil {
+		m.ItemRows = b.item.Shape()[0]
+	}
+	return json.Marshal(m)
+}
+
+// Fwd builds the inner model and adds the masked biases of the id inputs
+// to its logits
+func (b *BiasedModel) Fwd(xUserProfile, ubMatrix, xItemFeature, xCtxFeature *G.Node, batchSize, uBehaviorSize, uBehaviorDim int) (err error) {
+	if err = b.inner.Fwd(xUserProfile, ubMatrix, xItemFeature, xCtxFeature, batchSize, uBehaviorSize, uBehaviorDim); err != nil {
+		return
+	}
+	lo, ok := b.inner.(model.LogitsOutputer)
+	if !ok || lo.Logits() == nil {
+		return fmt.Errorf("model %T has no logits to bias", b.inner)
+	}
+	b.logits, b.ids = lo.Logits(), nil
+	for column, table := range []*G.Node{b.user, b.item} {
+		if table == nil {
+			continue
+		}
+		in := model.IdInput{
+			Column: column,
+			Rows:   table.Shape()[0],
+			Ids:    G.NewVector(b.Graph(), G.Int, G.WithShape(batchSize), G.WithName(table.Name()+"Ids")),
+			Mask:   G.NewMatrix(b.Graph(), model.DT, G.WithShape(batchSize, 1), G.WithName(table.Name()+"Mask")),
+		}
+		b.ids = append(b.ids, in)
+		var bias *G.Node
+		if bias, err = layers.Embedding(table, in.Ids); err != nil {
+			return
+		}
+		if bias, err = G.HadamardProd(bias, in.Mask); err != nil {
+			return
+		}
+		if b.logits.Shape()[1] == 1 {
+			b.logits, err = G.Add(b.logits, bias)
+		} else {
+			b.logits, err = G.BroadcastAdd(b.logits, bias, nil, []byte{1})
+		}
+		if err != nil {
+			return
+		}
+	}
+	b.out, err = layers.Activate(b.logits, b.head.Activation())
+	return
+}
+
+// IdInputs implements model.IdInputer
+func (b *BiasedModel) IdInputs() []model.IdInput {
+	return b.ids
+}
+
+// Logits implements model.LogitsOutputer
+func (b *BiasedModel) Logits() *G.Node {
+	return b.logits
+}
+
+// SetHead implements model.HeadSetter, the head is set to the inner model
+// too if it's a model.HeadSetter
+func (b *BiasedModel) SetHead(h model.Head) {
+	b.head = h
+	if setter, ok := b.inner.(model.HeadSetter); ok {
+		setter.SetHead(h)
+	}
+}
+
+// CheckShapes implements model.ShapeChecker of the inner model
+func (b *BiasedModel) CheckShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) []error {
+	if checker, ok := b.inner.(model.ShapeChecker); ok {
+		return checker.CheckShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim)
+	}
+	return nil
+}
+
+func (b *BiasedModel) Learnable() G.Nodes {
+	ret := append(G.Nodes(nil), b.inner.Learnable()...)
+	for _, table := range []*G.Node{b.user, b.item} {
+		if table != nil {
+			ret = append(ret, table)
+		}
+	}
+	return ret
+}
+
+func (b *BiasedModel) Out() *G.Node {
+	return b.out
+}
+
+func (b *BiasedModel) In() G.Nodes {
+	return b.inner.In()
+}
+
+func (b *BiasedModel) Graph() *G.ExprGraph {
+	return b.inner.Graph()
+}
+
+func (b *BiasedModel) Vm() G.VM {
+	return b.inner.Vm()
+}
+
+func (b *BiasedModel) SetVM(vm G.VM) {
+	b.inner.SetVM(vm)
+}
+
+func (b *BiasedModel) SetTraining(training bool) {
+	b.inner.SetTraining(training)
+}
@@ -0,0 +1,375 @@
+package bst
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/layers"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+const (
+	// magic numbers for bst paper
+	mlp0_1 = 200
+	mlp1_2 = 80
+
+	defaultHeads   = 2
+	defaultBlocks  = 1
+	defaultDropout = 0.005
+)
+
+// block is the learnable weights of a Transformer block
+type block struct {
+	wq, wk, wv, wo *G.Node // multi-head self attention
+	ffn0, ffn1     *G.Node // point-wise feed forward
+}
+
+// BstNet is the Behavior Sequence Transformer. The user behavior sequence
+// together with the target item goes through Transformer blocks before
+// being fed into the MLP.
+type BstNet struct {
+	uProfileDim, uBehaviorSize, uBehaviorDim int
+	iFeatureDim                              int
+	cFeatureDim                              int
+	heads                                    int
+
+	g  *G.ExprGraph
+	vm G.VM
+
+	//input nodes
+	xUserProfile, xUbMatrix, xItemFeature, xCtxFeature *G.Node
+
+	blocks           []block
+	mlp0, mlp1, mlp2 *G.Node    // weights of MLP layers
+	d0, d1           float32    // dropout probabilities
+	training         bool       // dropout is only applied in training mode
+	head             model.Head // output layer activation, see model.HeadSetter
+	outputs          int        // output layer width
+
+	// logits and out are the output before and after the head activation
+	logits, out *G.Node
+}
+
+type blockModel struct {
+	Wq   []float32 `json:"wq"`
+	Wk   []float32 `json:"wk"`
+	Wv   []float32 `json:"wv"`
+	Wo   []float32 `json:"wo"`
+	Ffn0 []float32 `json:"ffn0"`
+	Ffn1 []float32 `json:"ffn1"`
+}
+
+type bstModel struct {
+	UProfileDim   int          `json:"uProfileDim"`
+	UBehaviorSize int          `json:"uBehaviorSize"`
+	UBehaviorDim  int          `json:"uBehaviorDim"`
+	IFeatureDim   int          `json:"iFeatureDim"`
+	CFeatureDim   int          `json:"cFeatureDim"`
+	Heads         int          `json:"heads"`
+	Blocks        []blockModel `json:"blocks"`
+	Mlp0          []float32    `json:"mlp0"`
+	Mlp1          []float32    `json:"mlp1"`
+	Mlp2          []float32    `json:"mlp2"`
+	Head          model.Head   `json:"head,omitempty"`
+	Outputs       int          `json:"outputs,omitempty"`
+}
+
+// Option configures the BstNet created by NewBstNet
+type Option func(bst *BstNet)
+
+// WithDropout sets the dropout probabilities of the two hidden MLP layers,
+// 0 disables dropout of the layer.
+func WithDropout(d0, d1 float32) Option {
+	return func(bst *BstNet) {
+		for _, d := range []float32{d0, d1} {
+			if d < 0 || d >= 1 {
+				log.Fatalf("dropout probability %f out of range [0, 1)", d)
+			}
+		}
+		bst.d0, bst.d1 = d0, d1
+	}
+}
+
+// WithHeads sets the head count of the multi-head self attention,
+// uBehaviorDim should be divisible by heads.
+func WithHeads(heads int) Option {
+	return func(bst *BstNet) {
+		bst.heads = heads
+	}
+}
+
+// WithBlocks sets the count of the stacked Transformer blocks
+func WithBlocks(blocks int) Option {
+	return func(bst *BstNet) {
+		if blocks <= 0 {
+			log.Fatalf("transformer blocks %d should be positive", blocks)
+		}
+		bst.blocks = make([]block, blocks)
+	}
+}
+
+// WithOutputs sets the width of the output layer, e.g. the classes of the
+// model.SoftmaxObjective, default 1.
+func WithOutputs(outputs int) Option {
+	return func(bst *BstNet) {
+		if outputs <= 0 {
+			log.Fatalf("outputs %d should be positive", outputs)
+		}
+		bst.outputs = outputs
+	}
+}
+
+// seqLen is the Transformer sequence length, behaviors and the target item
+func (bst *BstNet) seqLen() int {
+	return bst.uBehaviorSize + 1
+}
+
+// newNodes creates the learnable nodes, values are used as node values if
+// not nil, or they will be initialized randomly.
+func (bst *BstNet) newNodes(values *bstModel) {
+	var (
+		g      = bst.g
+		dim    = bst.uBehaviorDim
+		mlp0_0 = bst.uProfileDim + bst.seqLen()*dim + bst.cFeatureDim
+	)
+	if values == nil {
+		values = &bstModel{Blocks: make([]blockModel, len(bst.blocks))}
+	}
+	glorot := G.GlorotN(1.0)
+	for i := range bst.blocks {
+		v := values.Blocks[i]
+		bst.blocks[i] = block{
+			wq:   layers.NewWeight(g, fmt.Sprintf("bst%d_wq", i), dim, dim, glorot, v.Wq),
+			wk:   layers.NewWeight(g, fmt.Sprintf("bst%d_wk", i), dim, dim, glorot, v.Wk),
+			wv:   layers.NewWeight(g, fmt.Sprintf("bst%d_wv", i), dim, dim, glorot, v.Wv),
+			wo:   layers.NewWeight(g, fmt.Sprintf("bst%d_wo", i), dim, dim, glorot, v.Wo),
+			ffn0: layers.NewWeight(g, fmt.Sprintf("bst%d_ffn0", i), dim, dim, glorot, v.Ffn0),
+			ffn1: layers.NewWeight(g, fmt.Sprintf("bst%d_ffn1", i), dim, dim, glorot, v.Ffn1),
+		}
+	}
+	gaussian := G.Gaussian(0, 1.0)
+	bst.mlp0 = layers.NewWeight(g, "mlp0", mlp0_0, mlp0_1, gaussian, values.Mlp0)
+	bst.mlp1 = layers.NewWeight(g, "mlp1", mlp0_1, mlp1_2, gaussian, values.Mlp1)
+	bst.mlp2 = layers.NewWeight(g, "mlp2", mlp1_2, bst.outputs, gaussian, values.Mlp2)
+}
+
+func NewBstNet(
+	uProfileDim, uBehaviorSize, uBehaviorDim int,
+	iFeatureDim int,
+	cFeatureDim int,
+	opts ...Option,
+) (bst *BstNet) {
+	if uBehaviorDim != iFeatureDim {
+		log.Fatalf("uBehaviorDim %d != iFeatureDim %d", uBehaviorDim, iFeatureDim)
+	}
+	bst = &BstNet{
+		uProfileDim:   uProfileDim,
+		uBehaviorSize: uBehaviorSize,
+		uBehaviorDim:  uBehaviorDim,
+		iFeatureDim:   iFeatureDim,
+		cFeatureDim:   cFeatureDim,
+		heads:         defaultHeads,
+		outputs:       1,
+
+		g:      G.NewGraph(),
+		blocks: make([]block, defaultBlocks),
+		d0:     defaultDropout,
+		d1:     defaultDropout,
+	}
+	for _, opt := range opts {
+		opt(bst)
+	}
+	if bst.heads <= 0 || uBehaviorDim%bst.heads != 0 {
+		log.Fatalf("uBehaviorDim %d can not be split into %d heads", uBehaviorDim, bst.heads)
+	}
+	bst.newNodes(nil)
+	return
+}
+
+func NewBstNetFromJson(data []byte) (bst *BstNet, err error) {
+	var m bstModel
+	if err = json.Unmarshal(data, &m); err != nil {
+		return
+	}
+	if m.Heads <= 0 || m.UBehaviorDim%m.Heads != 0 {
+		err = fmt.Errorf("uBehaviorDim %d can not be split into %d heads", m.UBehaviorDim, m.Heads)
+		return
+	}
+	bst = &BstNet{
+		uProfileDim:   m.UProfileDim,
+		uBehaviorSize: m.UBehaviorSize,
+		uBehaviorDim:  m.UBehaviorDim,
+		iFeatureDim:   m.IFeatureDim,
+		cFeatureDim:   m.CFeatureDim,
+		heads:         m.Heads,
+		head:          m.Head,
+		outputs:       model.OutputDim(m.Outputs),
+		g:             G.NewGraph(),
+		blocks:        make([]block, len(m.Blocks)),
+	}
+	bst.newNodes(&m)
+	return
+}
+
+func (bst *BstNet) Marshal() (data []byte, err error) {
+	m := bstModel{
+		UProfileDim:   bst.uProfileDim,
+		UBehaviorSize: bst.uBehaviorSize,
+		UBehaviorDim:  bst.uBehaviorDim,
+		IFeatureDim:   bst.iFeatureDim,
+		CFeatureDim:   bst.cFeatureDim,
+		Heads:         bst.heads,
+		Blocks:        make([]blockModel, len(bst.blocks)),
+		Mlp0:          bst.mlp0.Value().Data().([]float32),
+		Mlp1:          bst.mlp1.Value().Data().([]float32),
+		Mlp2:          bst.mlp2.Value().Data().([]float32),
+		Head:          bst.head,
+		Outputs:       bst.outputs,
+	}
+	for i, b := range bst.blocks {
+		m.Blocks[i] = blockModel{
+			Wq:   b.wq.Value().Data().([]float32),
+			Wk:   b.wk.Value().Data().([]float32),
+			Wv:   b.wv.Value().Data().([]float32),
+			Wo:   b.wo.Value().Data().([]float32),
+			Ffn0: b.ffn0.Value().Data().([]float32),
+			Ffn1: b.ffn1.Value().Data().([]float32),
+		}
+	}
+	return json.Marshal(m)
+}
+
+func (bst *BstNet) Vm() G.VM {
+	return bst.vm
+}
+
+func (bst *BstNet) SetVM(vm G.VM) {
+	bst.vm = vm
+}
+
+func (bst *BstNet) SetTraining(training bool) {
+	bst.training = training
+}
+
+// SetHead implements model.HeadSetter
+func (bst *BstNet) SetHead(h model.Head) {
+	bst.head = h
+}
+
+func (bst *BstNet) Graph() *G.ExprGraph {
+	return bst.g
+}
+
+func (bst *BstNet) Out() *G.Node {
+	return bst.out
+}
+
+// Logits implements model.LogitsOutputer
+func (bst *BstNet) Logits() *G.Node {
+	return bst.logits
+}
+
+func (bst *BstNet) In() G.Nodes {
+	return G.Nodes{bst.xUserProfile, bst.xUbMatrix, bst.xItemFeature, bst.xCtxFeature}
+}
+
+func (bst *BstNet) Learnable() G.Nodes {
+	ret := make(G.Nodes, 0, len(bst.blocks)*6+3)
+	for _, b := range bst.blocks {
+		ret = append(ret, b.wq, b.wk, b.wv, b.wo, b.ffn0, b.ffn1)
+	}
+	return append(ret, bst.mlp0, bst.mlp1, bst.mlp2)
+}
+
+// CheckShapes implements model.ShapeChecker, the target item is appended to
+// the behaviors and split into heads.
+func (bst *BstNet) CheckShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) (errs []error) {
+	if uBehaviorDim != iFeatureDim {
+		errs = append(errs, errors.Errorf("uBehaviorDim %d != iFeatureDim %d", uBehaviorDim, iFeatureDim))
+	}
+	if uBehaviorDim%bst.heads != 0 {
+		errs = append(errs, errors.Errorf("uBehaviorDim %d can not be split into %d heads", uBehaviorDim, bst.heads))
+	}
+	if uBehaviorSize != bst.uBehaviorSize {
+		errs = append(errs, errors.Errorf("uBehaviorSize %d != model uBehaviorSize %d", uBehaviorSize, bst.uBehaviorSize))
+	}
+	return
+}
+
+// Fwd performs the forward pass
+// xUserProfile: [batchSize, userProfileDim]
+// xUbMatrix: [batchSize, uBehaviorSize* uBehaviorDim]
+// xUserBehaviors: [batchSize, uBehaviorSize, uBehaviorDim]
+// xItemFeature: [batchSize, iFeatureDim]
+// xContextFeature: [batchSize, cFeatureDim]
+func (bst *BstNet) Fwd(xUserProfile, xUbMatrix, xItemFeature, xCtxFeature *G.Node, batchSize, uBehaviorSize, uBehaviorDim int) (err error) {
+	iFeatureDim := xItemFeature.Shape()[1]
+	if uBehaviorDim != iFeatureDim {
+		return errors.Errorf("uBehaviorDim %d != iFeatureDim %d", uBehaviorDim, iFeatureDim)
+	}
+	var (
+		seqLen = uBehaviorSize + 1
+		dim    = uBehaviorDim
+	)
+	// the target item is appended to the behavior sequence as the bst paper
+	// seq.Shape: [batchSize, seqLen, dim]
+	seq := G.Must(G.Concat(1,
+		G.Must(G.Reshape(xUbMatrix, tensor.Shape{batchSize, uBehaviorSize, uBehaviorDim})),
+		G.Must(G.Reshape(xItemFeature, tensor.Shape{batchSize, 1, iFeatureDim})),
+	))
+	// zero padded behaviors are masked out from the attention
+	mask, err := layers.SequenceMask(seq)
+	if err != nil {
+		return errors.Wrap(err, "sequence mask")
+	}
+
+	pe := G.NewMatrix(bst.g, model.DT,
+		G.WithShape(seqLen, dim),
+		G.WithName("positionalEncoding"),
+		G.WithValue(layers.SinusoidalPositionalEncoding(seqLen, dim)),
+	)
+	seq = G.Must(G.BroadcastAdd(seq, G.Must(G.Reshape(pe, tensor.Shape{1, seqLen, dim})), nil, []byte{0}))
+
+	for i, b := range bst.blocks {
+		var att *G.Node
+		if att, err = layers.MultiHeadSelfAttention(seq, mask, b.wq, b.wk, b.wv, b.wo, bst.heads); err != nil {
+			return errors.Wrapf(err, "transformer block %d", i)
+		}
+		// the residual adds are in place on their first operand, the seq and
+		// the h are kept for the gradients of the projections of them
+		// [batchSize * seqLen, dim]
+		h := G.Must(G.Reshape(G.Must(G.Add(att, seq)), tensor.Shape{batchSize * seqLen, dim}))
+		if h, err = layers.LayerNorm(h, nil, nil); err != nil {
+			return errors.Wrapf(err, "transformer block %d", i)
+		}
+		ffn := G.Must(layers.Dense(G.Must(layers.Dense(h, b.ffn0, G.Rectify)), b.ffn1, nil))
+		ffn = G.Must(layers.Dropout(ffn, bst.d0, bst.training))
+		if h, err = layers.LayerNorm(G.Must(G.Add(ffn, h)), nil, nil); err != nil {
+			return errors.Wrapf(err, "transformer block %d", i)
+		}
+		seq = G.Must(G.Reshape(h, tensor.Shape{batchSize, seqLen, dim}))
+	}
+
+	// Concat all xUserProfile, Transformer output, xCtxFeature
+	x := G.Must(G.Concat(1,
+		xUserProfile,
+		G.Must(G.Reshape(seq, tensor.Shape{batchSize, seqLen * dim})),
+		xCtxFeature,
+	))
+
+	// MLP
+	mlp0Out := G.Must(layers.Dropout(G.Must(layers.Dense(x, bst.mlp0, G.Sigmoid)), bst.d0, bst.training))
+	mlp1Out := G.Must(layers.Dropout(G.Must(layers.Dense(mlp0Out, bst.mlp1, G.Sigmoid)), bst.d1, bst.training))
+	bst.logits = G.Must(layers.Dense(mlp1Out, bst.mlp2, nil))
+	bst.out = G.Must(layers.Activate(bst.logits, bst.head.Activation()))
+
+	bst.xUserProfile = xUserProfile
+	bst.xItemFeature = xItemFeature
+	bst.xCtxFeature = xCtxFeature
+	bst.xUbMatrix = xUbMatrix
+	return
+}
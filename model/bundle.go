@@ -0,0 +1,64 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+// bundle is the model json together with the SampleInfo it is trained with
+type bundle struct {
+	SampleInfo *rcmd.SampleInfo `json:"sampleInfo"`
+	Manifest   *rcmd.Manifest   `json:"manifest,omitempty"`
+	Model      json.RawMessage  `json:"model"`
+}
+
+// MarshalBundle serializes m along with the SampleInfo used to cut its input
+// tensor, so the serving side never miscuts the input.
+func MarshalBundle(m Model, si *rcmd.SampleInfo) (data []byte, err error) {
+	return MarshalBundleWithManifest(m, si, nil)
+}
+
+// MarshalBundleWithManifest is MarshalBundle with the data lineage of the
+// model, see BundleManifest
+func MarshalBundleWithManifest(m Model, si *rcmd.SampleInfo, manifest *rcmd.Manifest) (data []byte, err error) {
+	if si == nil {
+		return nil, fmt.Errorf("sample info is nil")
+	}
+	if err = si.Validate(si.Width()); err != nil {
+		return
+	}
+	var modelData []byte
+	if modelData, err = m.Marshal(); err != nil {
+		return
+	}
+	return json.Marshal(bundle{SampleInfo: si, Manifest: manifest, Model: modelData})
+}
+
+// UnmarshalBundle returns the validated SampleInfo and the model json which
+// could be loaded by the NewXXXFromJson of the model.
+func UnmarshalBundle(data []byte) (si *rcmd.SampleInfo, modelData []byte, err error) {
+	var b bundle
+	if err = json.Unmarshal(data, &b); err != nil {
+		return
+	}
+	if b.SampleInfo == nil {
+		err = fmt.Errorf("sample info not found in bundle")
+		return
+	}
+	if err = b.SampleInfo.Validate(b.SampleInfo.Width()); err != nil {
+		return
+	}
+	return b.SampleInfo, b.Model, nil
+}
+
+// BundleManifest returns the data lineage stored in the bundle, nil if the
+// bundle is marshaled without it
+func BundleManifest(data []byte) (manifest *rcmd.Manifest, err error) {
+	var b bundle
+	if err = json.Unmarshal(data, &b); err != nil {
+		return
+	}
+	return b.Manifest, nil
+}
@@ -0,0 +1,199 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/auxten/go-ctr/model/encryption"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// Checkpoint is the state of a training run at a solver step, Train resumed
+// from it by WithResume continues bitwise the same as the run not
+// interrupted, given the same samples and options.
+//
+// The dropout masks of gorgonia are seeded by the time on every run, so the
+// models with dropout resume by the same weights and solver state but not
+// bitwise the same.
+type Checkpoint struct {
+	// Epoch and Batch are the position of the next batch to train
+	Epoch int `json:"epoch"`
+	Batch int `json:"batch"`
+	// Steps are the solver steps taken
+	Steps       int `json:"steps"`
+	NumExamples int `json:"numExamples"`
+	// BatchSize is the batch size of Train, ResizedBatchSize is the batch
+	// size trained after a switch by WithMemoryCap, 0 if none. The resumed
+	// Train switches to it by the NewModel of WithMemoryCap.
+	BatchSize        int `json:"batchSize"`
+	ResizedBatchSize int `json:"resizedBatchSize,omitempty"`
+	// BestCost, NoImprove and EarlyStopped are the early stop state
+	BestCost     float32 `json:"bestCost"`
+	NoImprove    int     `json:"noImprove"`
+	EarlyStopped bool    `json:"earlyStopped,omitempty"`
+	// Shuffle is the state of the batch order generator at the start of
+	// Epoch, see WithShuffle
+	Shuffle *uint64 `json:"shuffle,omitempty"`
+	// Nodes are the learnable nodes of the Model
+	Nodes  []CheckpointNode `json:"nodes"`
+	Solver AdamState        `json:"solver"`
+	// LearnRate is the learning rate of the solver, e.g. set by
+	// EvalConfig.OnEvaluation, the default if 0
+	LearnRate float64 `json:"learnRate,omitempty"`
+}
+
+// CheckpointNode is the value of a learnable node
+type CheckpointNode struct {
+	Name  string    `json:"name"`
+	Shape []int     `json:"shape"`
+	Value []float32 `json:"value"`
+}
+
+// WithCheckpoint calls save with the Checkpoint every solver steps and at
+// the end of every epoch, e.g. to Save it. Train fails on the save error.
+func WithCheckpoint(every int, save func(cp *Checkpoint) error) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.CheckpointEvery = every
+		opts.OnCheckpoint = save
+	}
+}
+
+// WithResume resumes the training run from cp, the Model is restored to the
+// weights of cp
+func WithResume(cp *Checkpoint) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.Resume = cp
+	}
+}
+
+// WithShuffle trains the batches of every epoch in the random order of seed,
+// the order generator is checkpointed
+func WithShuffle(seed int64) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.Shuffle = true
+		opts.Seed = seed
+	}
+}
+
+// Save writes cp to path as json, the file is replaced at once so it is
+// never left half written by a crash. It's encrypted of the storage key,
+// see package encryption.
+func (cp *Checkpoint) Save(path string) (err error) {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return
+	}
+	if data, err = encryption.Encode(data); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadCheckpoint reads the Checkpoint saved by Save
+func LoadCheckpoint(path string) (cp *Checkpoint, err error) {
+	data, err := encryption.ReadFile(path)
+	if err != nil {
+		return
+	}
+	cp = new(Checkpoint)
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("load checkpoint %s: %v", path, err)
+	}
+	return
+}
+
+func newCheckpointNodes(nodes G.Nodes) []CheckpointNode {
+	cps := make([]CheckpointNode, len(nodes))
+	for i, n := range nodes {
+		cps[i] = CheckpointNode{
+			Name:  n.Name(),
+			Shape: append([]int(nil), n.Shape()...),
+			Value: append([]float32(nil), n.Value().Data().([]float32)...),
+		}
+	}
+	return cps
+}
+
+// restore sets the values of nodes and the solver state by cp
+func (cp *Checkpoint) restore(nodes G.Nodes, solver *adamSolver, numExamples, batchSize int) (err error) {
+	if cp.NumExamples != numExamples || cp.BatchSize != batchSize {
+		return fmt.Errorf("checkpoint of %d examples by batch %d resumed by %d examples by batch %d",
+			cp.NumExamples, cp.BatchSize, numExamples, batchSize)
+	}
+	if cp.ResizedBatchSize < 0 || cp.ResizedBatchSize >= batchSize {
+		return fmt.Errorf("checkpoint of the batch %d resized to %d", cp.BatchSize, cp.ResizedBatchSize)
+	}
+	if len(cp.Nodes) != len(nodes) {
+		return fmt.Errorf("checkpoint of %d nodes != %d learnable nodes", len(cp.Nodes), len(nodes))
+	}
+	for i, n := range nodes {
+		c := cp.Nodes[i]
+		if c.Name != n.Name() || !tensor.Shape(c.Shape).Eq(n.Shape()) {
+			return fmt.Errorf("checkpoint node %s%v != learnable node %s%v", c.Name, c.Shape, n.Name(), n.Shape())
+		}
+		if len(c.Value) != n.Shape().TotalSize() {
+			return fmt.Errorf("checkpoint node %s size %d != %d", c.Name, len(c.Value), n.Shape().TotalSize())
+		}
+	}
+	if err = solver.SetState(cp.Solver, nodes); err != nil {
+		return
+	}
+	if cp.LearnRate > 0 {
+		solver.eta = cp.LearnRate
+	}
+	for i, n := range nodes {
+		copy(n.Value().Data().([]float32), cp.Nodes[i].Value)
+	}
+	return
+}
+
+// shuffleSource is the splitmix64 rand.Source, unlike the source of
+// math/rand its state is a single checkpointable uint64
+type shuffleSource struct {
+	state uint64
+}
+
+func (s *shuffleSource) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *shuffleSource) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *shuffleSource) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// batchOrder returns the order of batches trained in an epoch, shuffled by
+// src if not nil
+func batchOrder(batches int, src *shuffleSource) []int {
+	if src != nil {
+		return rand.New(src).Perm(batches)
+	}
+	order := make([]int, batches)
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
@@ -16,6 +16,22 @@ func BinaryCrossEntropy32(yPred, yTrue *G.Node) *G.Node {
 	return cost
 }
 
+// SigmoidBinaryCrossEntropy32 calculates the binary cross entropy cost of the
+// logits of the sigmoid head, by the fused SigmoidCrossEntropy stable of the
+// large logits
+func SigmoidBinaryCrossEntropy32(logits, yTrue *G.Node) *G.Node {
+	return G.Must(G.Mean(G.Must(SigmoidCrossEntropy(logits, yTrue))))
+}
+
+// CategoricalCrossEntropy32 calculates the categorical cross entropy cost of
+// the class probabilities and the one-hot targets of shape [batchSize, classes]
+// loss formula: -sum(y_true * log(y_pred)) of every row
+func CategoricalCrossEntropy32(yPred, yTrue *G.Node) *G.Node {
+	logProb := G.Must(G.Log(G.Must(G.Add(yPred, G.NewConstant(float32(1e-8))))))
+	rowLoss := G.Must(G.Sum(G.Must(G.HadamardProd(logProb, yTrue)), 1))
+	return G.Must(G.Neg(G.Must(G.Mean(rowLoss))))
+}
+
 // MSE32 calculates the Mean Squared Error cost
 func MSE32(yPred, yTrue *G.Node) *G.Node {
 	cost := G.Must(G.Mean(G.Must(G.Square(G.Must(G.Sub(yPred, yTrue))))))
@@ -27,3 +43,43 @@ func RMS32(yPred, yTrue *G.Node) *G.Node {
 	cost := G.Must(G.Sqrt(G.Must(G.Mean(G.Must(G.Square(G.Must(G.Sub(yPred, yTrue))))))))
 	return cost
 }
+
+// Huber32 calculates the Huber cost, which is the squared error for the
+// residual within delta and linear beyond:
+// loss formula: 0.5 * min(|r|, delta)^2 + delta * (|r| - min(|r|, delta))
+func Huber32(yPred, yTrue *G.Node, delta float32) *G.Node {
+	d := G.NewConstant(delta)
+	abs := G.Must(G.Abs(G.Must(G.Sub(yPred, yTrue))))
+	// min(|r|, delta) = (|r| + delta - ||r| - delta|) / 2
+	quadratic := G.Must(G.Mul(
+		G.Must(G.Sub(G.Must(G.Add(abs, d)), G.Must(G.Abs(G.Must(G.Sub(abs, d)))))),
+		G.NewConstant(float32(0.5)),
+	))
+	linear := G.Must(G.Mul(G.Must(G.Sub(abs, quadratic)), d))
+	losses := G.Must(G.Add(
+		G.Must(G.Mul(G.Must(G.Square(quadratic)), G.NewConstant(float32(0.5)))),
+		linear,
+	))
+	return G.Must(G.Mean(losses))
+}
+
+// SlateSoftmaxCrossEntropy32 calculates the listwise softmax cross entropy
+// cost of the scores yPred of shape [batchSize, 1] over the slates, slate is
+// the [batchSize, batchSize] matrix of 1 if the rows i and j are of the same
+// slate, including i == j. yTrue are the target weights of every slate summed
+// to 1, see SlateTargets.
+// loss formula: -sum(y_true * log(softmax(y_pred) of the slate)), which is
+// computed as y_true_i * log(sum_j(slate_ij * exp(y_pred_j - y_pred_i))) to
+// keep the log finite
+func SlateSoftmaxCrossEntropy32(yPred, yTrue, slate *G.Node) *G.Node {
+	batchSize := yPred.Shape()[0]
+	// diff_ij = y_pred_j - y_pred_i
+	diff := G.Must(G.BroadcastSub(
+		G.Must(G.Reshape(yPred, []int{1, batchSize})),
+		yPred,
+		[]byte{0}, []byte{1},
+	))
+	normalizer := G.Must(G.Sum(G.Must(G.HadamardProd(G.Must(G.Exp(diff)), slate)), 1))
+	rowLoss := G.Must(G.HadamardProd(G.Must(G.Log(normalizer)), G.Must(G.Reshape(yTrue, []int{batchSize}))))
+	return G.Must(G.Mean(rowLoss))
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"math"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -55,6 +56,64 @@ func TestBCECostFuncs(t *testing.T) {
 	})
 }
 
+func TestSigmoidBinaryCrossEntropy32(t *testing.T) {
+	Convey("Sigmoid Binary Cross Entropy of the logits of the sklearn case", t, func() {
+		probs := []float32{0.19, 0.33, 0.47, 0.7, 0.74, 0.81, 0.86, 0.94, 0.97, 0.99}
+		logits := make([]float32, len(probs))
+		for i, p := range probs {
+			logits[i] = float32(math.Log(float64(p / (1 - p))))
+		}
+		g := G.NewGraph()
+		x := G.NodeFromAny(g, tensor.New(tensor.WithShape(10, 1), tensor.WithBacking(logits)), G.WithName("logits"))
+		yTrue := G.NodeFromAny(g, tensor.New(tensor.WithShape(10, 1),
+			tensor.WithBacking([]float32{0.0, 0.0, 1.0, 0.0, 1.0, 1.0, 1.0, 1.0, 1.0, 1.0})), G.WithName("yTrue"))
+		output := SigmoidBinaryCrossEntropy32(x, yTrue)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So([]int(output.Shape()), ShouldResemble, []int{})
+		So(output.Value().Data(), ShouldAlmostEqual, 0.3335, 0.0001)
+	})
+
+	Convey("Stable of the large logits", t, func() {
+		var (
+			logits = []float32{100, -100, 1000, -1000, 0}
+			labels = []float32{1, 0, 0, 1, 1}
+			// the losses of the wrong ones are |x|
+			want = (1000 + 1000 + math.Ln2) / 5
+		)
+		g := G.NewGraph()
+		x := G.NewMatrix(g, DT, G.WithShape(5, 1), G.WithName("logits"),
+			G.WithValue(tensor.New(tensor.WithShape(5, 1), tensor.WithBacking(logits))))
+		yTrue := G.NodeFromAny(g, tensor.New(tensor.WithShape(5, 1), tensor.WithBacking(labels)), G.WithName("yTrue"))
+		fused := SigmoidBinaryCrossEntropy32(x, yTrue)
+		separate := BinaryCrossEntropy32(G.Must(G.Sigmoid(x)), yTrue)
+		_, err := G.Grad(fused, x)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g, G.BindDualValues(x))
+		So(m.RunAll(), ShouldBeNil)
+		defer m.Close()
+
+		So(fused.Value().Data(), ShouldAlmostEqual, want, 1e-3)
+		// the log of the sigmoid rounded to 1 or underflowed to 0
+		v := float64(separate.Value().Data().(float32))
+		So(math.IsNaN(v) || math.IsInf(v, 0), ShouldBeTrue)
+
+		// sigmoid(x) - y of the mean
+		grad, err := x.Grad()
+		So(err, ShouldBeNil)
+		for i, d := range grad.Data().([]float32) {
+			want := (1/(1+math.Exp(-float64(logits[i]))) - float64(labels[i])) / 5
+			So(d, ShouldAlmostEqual, want, 1e-6)
+		}
+
+		_, err = SigmoidCrossEntropy(x, G.NewMatrix(g, DT, G.WithShape(1, 5)))
+		So(err, ShouldNotBeNil)
+	})
+}
+
 func TestMSECostFuncs(t *testing.T) {
 	Convey("Mean Squared Error", t, func() {
 		g := G.NewGraph()
@@ -88,3 +147,142 @@ func TestRMS32(t *testing.T) {
 		So(output.Value().Data(), ShouldAlmostEqual, 0.0894427, 0.000001)
 	})
 }
+
+func TestHuber32(t *testing.T) {
+	Convey("Huber", t, func() {
+		g := G.NewGraph()
+
+		yPred := G.NodeFromAny(g, tensor.New(tensor.WithShape(4, 1), tensor.WithBacking([]float32{0.5, 3, 1, -2})), G.WithName("yPred"))
+		yTrue := G.NodeFromAny(g, tensor.New(tensor.WithShape(4, 1), tensor.WithBacking([]float32{0, 0, 1, 0})), G.WithName("yTrue"))
+		output := Huber32(yPred, yTrue, 1)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So([]int(output.Shape()), ShouldResemble, []int{})
+		// (0.125 + 2.5 + 0 + 1.5) / 4
+		So(output.Value().Data(), ShouldAlmostEqual, 1.03125, 0.000001)
+	})
+}
+
+func TestCategoricalCrossEntropy32(t *testing.T) {
+	Convey("Categorical cross entropy", t, func() {
+		g := G.NewGraph()
+
+		yPred := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 3), tensor.WithBacking([]float32{0.5, 0.25, 0.25, 0.1, 0.1, 0.8})), G.WithName("yPred"))
+		yTrue := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 3), tensor.WithBacking([]float32{1, 0, 0, 0, 0, 1})), G.WithName("yTrue"))
+		output := CategoricalCrossEntropy32(yPred, yTrue)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So([]int(output.Shape()), ShouldResemble, []int{})
+		So(output.Value().Data(), ShouldAlmostEqual, -(math.Log(0.5)+math.Log(0.8))/2, 0.00001)
+	})
+}
+
+func TestClassTargets(t *testing.T) {
+	Convey("Class targets", t, func() {
+		labels := tensor.New(tensor.WithShape(3, 1), tensor.WithBacking([]float32{0, 2, 1}))
+		encoded, err := ClassTargets(SoftmaxObjective, 3, labels)
+		So(err, ShouldBeNil)
+		So([]int(encoded.Shape()), ShouldResemble, []int{3, 3})
+		So(encoded.Data(), ShouldResemble, []float32{1, 0, 0, 0, 0, 1, 0, 1, 0})
+		So(DecodeClasses(SoftmaxObjective, 3, encoded.Data().([]float32)), ShouldResemble, []int{0, 2, 1})
+
+		encoded, err = ClassTargets(OrdinalObjective, 3, labels)
+		So(err, ShouldBeNil)
+		So([]int(encoded.Shape()), ShouldResemble, []int{3, 2})
+		So(encoded.Data(), ShouldResemble, []float32{0, 0, 1, 1, 1, 0})
+		So(DecodeClasses(OrdinalObjective, 3, encoded.Data().([]float32)), ShouldResemble, []int{0, 2, 1})
+
+		_, err = ClassTargets(SoftmaxObjective, 2, labels)
+		So(err, ShouldNotBeNil)
+		_, err = ClassTargets(SoftmaxObjective, 3, tensor.New(tensor.WithShape(1, 1), tensor.WithBacking([]float32{0.5})))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSmoothLabels(t *testing.T) {
+	Convey("Smooth labels", t, func() {
+		labels := tensor.New(tensor.WithShape(3, 1), tensor.WithBacking([]float32{0, 1, 1}))
+		smoothed, err := SmoothLabels(BinaryObjective, 0, 0.1, labels)
+		So(err, ShouldBeNil)
+		So([]int(smoothed.Shape()), ShouldResemble, []int{3, 1})
+		for i, want := range []float32{0.05, 0.95, 0.95} {
+			So(smoothed.Data().([]float32)[i], ShouldAlmostEqual, want, 1e-6)
+		}
+		So(labels.Data(), ShouldResemble, []float32{0, 1, 1})
+
+		encoded, err := ClassTargets(SoftmaxObjective, 4, tensor.New(tensor.WithShape(1, 1), tensor.WithBacking([]float32{2})))
+		So(err, ShouldBeNil)
+		smoothed, err = SmoothLabels(SoftmaxObjective, 4, 0.2, encoded)
+		So(err, ShouldBeNil)
+		var sum float32
+		for i, want := range []float32{0.05, 0.05, 0.85, 0.05} {
+			So(smoothed.Data().([]float32)[i], ShouldAlmostEqual, want, 1e-6)
+			sum += smoothed.Data().([]float32)[i]
+		}
+		So(sum, ShouldAlmostEqual, 1, 1e-6)
+
+		_, err = SmoothLabels(BinaryObjective, 0, 1, labels)
+		So(err, ShouldNotBeNil)
+		_, err = SmoothLabels(MSEObjective, 0, 0.1, labels)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSlateSoftmaxCrossEntropy32(t *testing.T) {
+	Convey("Slate softmax cross entropy", t, func() {
+		g := G.NewGraph()
+		yPred := G.NewMatrix(g, DT, G.WithShape(4, 1), G.WithName("yPred"),
+			G.WithValue(tensor.New(tensor.WithShape(4, 1), tensor.WithBacking([]float32{1, 2, 3, 0}))))
+		yTrue := G.NodeFromAny(g, tensor.New(tensor.WithShape(4, 1), tensor.WithBacking([]float32{0, 0, 1, 0})), G.WithName("yTrue"))
+		slate := &slateMatrix{
+			slates:  []int{7, 7, 7},
+			node:    G.NewMatrix(g, DT, G.WithShape(4, 4), G.WithName("slate")),
+			backing: make([]float32, 16),
+		}
+		// the 4th row is filled
+		So(slate.let(0, 3), ShouldBeNil)
+		So(slate.backing, ShouldResemble, []float32{
+			1, 1, 1, 0,
+			1, 1, 1, 0,
+			1, 1, 1, 0,
+			0, 0, 0, 1,
+		})
+		output := SlateSoftmaxCrossEntropy32(yPred, yTrue, slate.node)
+		_, err := G.Grad(output, yPred)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g, G.BindDualValues(yPred))
+		defer m.Close()
+		So(m.RunAll(), ShouldBeNil)
+		So([]int(output.Shape()), ShouldResemble, []int{})
+		want := (math.Log(math.Exp(1)+math.Exp(2)+math.Exp(3)) - 3) / 4
+		So(output.Value().Data(), ShouldAlmostEqual, want, 0.00001)
+
+		// d/ds_j = (softmax_j - y_j) / 4 in the slate
+		grad, err := yPred.Grad()
+		So(err, ShouldBeNil)
+		sum := math.Exp(1) + math.Exp(2) + math.Exp(3)
+		for i, want := range []float64{math.Exp(1) / sum / 4, math.Exp(2) / sum / 4, (math.Exp(3)/sum - 1) / 4, 0} {
+			So(grad.Data().([]float32)[i], ShouldAlmostEqual, want, 0.00001)
+		}
+	})
+}
+
+func TestSlateTargets(t *testing.T) {
+	Convey("Slate targets", t, func() {
+		labels := tensor.New(tensor.WithShape(5, 1), tensor.WithBacking([]float32{1, 0, 1, 0, 0}))
+		encoded, err := SlateTargets(labels, []int{1, 1, 1, 2, 2})
+		So(err, ShouldBeNil)
+		So(encoded.Data(), ShouldResemble, []float32{0.5, 0, 0.5, 0, 0})
+
+		_, err = SlateTargets(labels, []int{1, 1})
+		So(err, ShouldNotBeNil)
+		_, err = SlateTargets(tensor.New(tensor.WithShape(1, 1), tensor.WithBacking([]float32{2})), []int{1})
+		So(err, ShouldNotBeNil)
+	})
+}
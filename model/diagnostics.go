@@ -0,0 +1,266 @@
+package model
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"gorgonia.org/tensor"
+)
+
+// HardSample is a training sample of the highest data losses of an epoch,
+// dumped by WithHardSampleDump. The losses are of the weights at the batch of
+// the sample, so the samples of the early batches of an epoch are of the
+// less trained weights.
+type HardSample struct {
+	Epoch int `json:"epoch"`
+	// Row is the index of the sample in the training inputs
+	Row int `json:"row"`
+	// Loss is math.MaxFloat32 of the NaN or overflowed ones
+	Loss float32 `json:"loss"`
+	// Target is the row of the training targets as given, Prediction the
+	// outputs of the Model
+	Target     []float32 `json:"target"`
+	Prediction []float32 `json:"prediction"`
+	// Features are the columns of the sample vector
+	Features []rcmd.FeatureColumn `json:"features"`
+}
+
+// WithHardSampleDump appends the n highest loss samples of every epoch to the
+// file of path, as the HardSample json lines ordered by the loss. The columns
+// of the sample vector are named by names, e.g. of rcmd.FeatureColumnNames,
+// or "col_i" if the length mismatches. It's to find the labeling and join
+// bugs, e.g. the positives of the features of no user.
+func WithHardSampleDump(path string, n int, names []string) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.HardSamplePath = path
+		opts.HardSamples = n
+		opts.HardSampleNames = names
+	}
+}
+
+// hardSampleDump keeps the hardest samples of the epoch
+type hardSampleDump struct {
+	file      *os.File
+	n         int
+	objective Objective
+	slates    []int
+	names     []string
+	// x and y are the row major inputs and the encoded targets, raw the
+	// targets as given
+	x, y, raw                []float32
+	xWidth, yWidth, rawWidth int
+
+	samples hardSampleHeap
+}
+
+// newTrainDump returns the dump of trainOpts of the inputs, the encoded
+// targets and the targets as given of Train
+func newTrainDump(trainOpts *TrainOpts, inputs, targets, raw tensor.Tensor) (*hardSampleDump, error) {
+	if trainOpts.HardSamples <= 0 {
+		return nil, fmt.Errorf("hard samples %d not positive", trainOpts.HardSamples)
+	}
+	x, xWidth, err := rowMajor(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("inputs: %v", err)
+	}
+	y, yWidth, err := rowMajor(targets)
+	if err != nil {
+		return nil, fmt.Errorf("targets: %v", err)
+	}
+	rawY, rawWidth, err := rowMajor(raw)
+	if err != nil {
+		return nil, fmt.Errorf("targets: %v", err)
+	}
+	f, err := os.OpenFile(trainOpts.HardSamplePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	names := trainOpts.HardSampleNames
+	if len(names) != xWidth {
+		names = make([]string, xWidth)
+		for j := range names {
+			names[j] = fmt.Sprintf("col_%d", j)
+		}
+	}
+	return &hardSampleDump{
+		file:      f,
+		n:         trainOpts.HardSamples,
+		objective: trainOpts.Objective,
+		slates:    trainOpts.Slates,
+		names:     names,
+		x:         x,
+		y:         y,
+		raw:       rawY,
+		xWidth:    xWidth,
+		yWidth:    yWidth,
+		rawWidth:  rawWidth,
+	}, nil
+}
+
+// reset drops the samples kept, at the start of an epoch
+func (d *hardSampleDump) reset() {
+	d.samples = d.samples[:0]
+}
+
+// add keeps the hardest samples of the batch [start, end) just run by r
+func (d *hardSampleDump) add(r *replica, batch [2]int) error {
+	if r.out == nil {
+		return fmt.Errorf("model output not read")
+	}
+	out, ok := r.out.Data().([]float32)
+	if !ok {
+		return fmt.Errorf("model output of %T data, not []float32", r.out.Data())
+	}
+	var logits []float32
+	if r.logits != nil {
+		logits, _ = r.logits.Data().([]float32)
+	}
+	rows := batch[1] - batch[0]
+	losses := rowLosses(d.objective, d.slates, d.y, d.yWidth, out, logits, batch[0], rows)
+	for i, loss := range losses {
+		if math.IsNaN(loss) {
+			// the NaN are the hardest
+			loss = math.Inf(1)
+		}
+		if len(d.samples) == d.n {
+			if loss <= d.samples[0].loss {
+				continue
+			}
+			heap.Pop(&d.samples)
+		}
+		heap.Push(&d.samples, hardSampleItem{
+			row:        batch[0] + i,
+			loss:       loss,
+			prediction: append([]float32(nil), out[i*d.yWidth:(i+1)*d.yWidth]...),
+		})
+	}
+	return nil
+}
+
+// rowLosses returns the data loss of objective o of every row of the outputs
+// of the batch of rows from start of the row major targets y of yWidth, as
+// the loss of the row alone. The binary cross entropy is of the logits if
+// not nil.
+func rowLosses(o Objective, slates []int, y []float32, yWidth int, out, logits []float32, start, rows int) []float64 {
+	losses := make([]float64, rows)
+	if o == ListwiseObjective {
+		// the softmax of the scores of the slate in the batch
+		for i := range losses {
+			var (
+				yi         = float64(y[start+i])
+				normalizer float64
+			)
+			for j := 0; j < rows; j++ {
+				if slates == nil || slates[start+j] == slates[start+i] {
+					normalizer += math.Exp(float64(out[j] - out[i]))
+				}
+			}
+			losses[i] = yi * math.Log(normalizer)
+		}
+		return losses
+	}
+	for i := range losses {
+		var (
+			p    = out[i*yWidth : (i+1)*yWidth]
+			t    = y[(start+i)*yWidth : (start+i+1)*yWidth]
+			loss float64
+		)
+		for k := range p {
+			pk, yk := float64(p[k]), float64(t[k])
+			switch o {
+			case MSEObjective:
+				loss += (pk - yk) * (pk - yk)
+			case HuberObjective:
+				if r := math.Abs(pk - yk); r <= DefaultHuberDelta {
+					loss += 0.5 * r * r
+				} else {
+					loss += DefaultHuberDelta * (r - 0.5*DefaultHuberDelta)
+				}
+			case SoftmaxObjective:
+				loss -= yk * math.Log(pk+1e-8)
+			default:
+				if logits != nil {
+					loss += sigmoidCE(float64(logits[i*yWidth+k]), yk)
+				} else {
+					loss -= yk*math.Log(pk+1e-8) + (1-yk)*math.Log(1-pk+1e-8)
+				}
+			}
+		}
+		if o != SoftmaxObjective {
+			loss /= float64(len(p))
+		}
+		losses[i] = loss
+	}
+	return losses
+}
+
+// flush appends the hardest samples of the epoch to the file
+func (d *hardSampleDump) flush(epoch int) (err error) {
+	items := append(hardSampleHeap(nil), d.samples...)
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].loss != items[j].loss {
+			return items[i].loss > items[j].loss
+		}
+		return items[i].row < items[j].row
+	})
+	var buf []byte
+	for _, item := range items {
+		sample := HardSample{
+			Epoch:      epoch,
+			Row:        item.row,
+			Loss:       float32(math.Min(item.loss, math.MaxFloat32)),
+			Target:     d.raw[item.row*d.rawWidth : (item.row+1)*d.rawWidth],
+			Prediction: item.prediction,
+			Features:   make([]rcmd.FeatureColumn, d.xWidth),
+		}
+		for j := range sample.Features {
+			sample.Features[j] = rcmd.FeatureColumn{Name: d.names[j], Value: d.x[item.row*d.xWidth+j]}
+		}
+		var line []byte
+		if line, err = json.Marshal(&sample); err != nil {
+			return
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	_, err = d.file.Write(buf)
+	return
+}
+
+func (d *hardSampleDump) close() error {
+	return d.file.Close()
+}
+
+type hardSampleItem struct {
+	row        int
+	loss       float64
+	prediction []float32
+}
+
+// hardSampleHeap is the min heap of the loss
+type hardSampleHeap []hardSampleItem
+
+func (h hardSampleHeap) Len() int { return len(h) }
+
+func (h hardSampleHeap) Less(i, j int) bool {
+	if h[i].loss != h[j].loss {
+		return h[i].loss < h[j].loss
+	}
+	// the later rows are dropped first of the same loss
+	return h[i].row > h[j].row
+}
+
+func (h hardSampleHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *hardSampleHeap) Push(x interface{}) { *h = append(*h, x.(hardSampleItem)) }
+
+func (h *hardSampleHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -5,6 +5,7 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/layers"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	G "gorgonia.org/gorgonia"
@@ -16,6 +17,8 @@ const (
 	att0_1 = 36
 	mlp0_1 = 200
 	mlp1_2 = 80
+
+	defaultDropout = 0.005
 )
 
 type DinNet struct {
@@ -30,25 +33,36 @@ type DinNet struct {
 	//input nodes
 	xUserProfile, xUbMatrix, xItemFeature, xCtxFeature *G.Node
 
-	mlp0, mlp1, mlp2 *G.Node // weights of MLP layers
-	d0, d1           float32 // dropout probabilities
-	att0             *G.Node // weights of attention layer
+	mlp0, mlp1, mlp2 *G.Node    // weights of MLP layers
+	d0, d1           float32    // dropout probabilities
+	training         bool       // dropout is only applied in training mode
+	head             model.Head // output layer activation, see model.HeadSetter
+	outputs          int        // output layer width
+	att0             *G.Node    // weights of attention layer
+	attention        G.Value    // attention weights of the last run, see Attention
+	latentCross      bool       // gate the first MLP layer by the ctx features, see WithLatentCross
+	lc0              *G.Node    // weights of the latent cross gate, nil without it
 	//att1       *G.Node // weights of Attention layers
 
-	out *G.Node
+	// logits and out are the output before and after the head activation
+	logits, out *G.Node
 }
 
 type dinModel struct {
-	UProfileDim   int       `json:"uProfileDim"`
-	UBehaviorSize int       `json:"uBehaviorSize"`
-	UBehaviorDim  int       `json:"uBehaviorDim"`
-	IFeatureDim   int       `json:"iFeatureDim"`
-	CFeatureDim   int       `json:"cFeatureDim"`
-	Mlp0          []float32 `json:"mlp0"`
-	Mlp1          []float32 `json:"mlp1"`
-	Mlp2          []float32 `json:"mlp2"`
-	Att0          []float32 `json:"att0"`
+	UProfileDim   int        `json:"uProfileDim"`
+	UBehaviorSize int        `json:"uBehaviorSize"`
+	UBehaviorDim  int        `json:"uBehaviorDim"`
+	IFeatureDim   int        `json:"iFeatureDim"`
+	CFeatureDim   int        `json:"cFeatureDim"`
+	Mlp0          []float32  `json:"mlp0"`
+	Mlp1          []float32  `json:"mlp1"`
+	Mlp2          []float32  `json:"mlp2"`
+	Head          model.Head `json:"head,omitempty"`
+	Outputs       int        `json:"outputs,omitempty"`
+	Att0          []float32  `json:"att0"`
 	//Att1          []float32 `json:"att1"`
+	LatentCross bool      `json:"latentCross,omitempty"`
+	Lc0         []float32 `json:"lc0,omitempty"`
 }
 
 func (din *DinNet) Vm() G.VM {
@@ -59,6 +73,15 @@ func (din *DinNet) SetVM(vm G.VM) {
 	din.vm = vm
 }
 
+func (din *DinNet) SetTraining(training bool) {
+	din.training = training
+}
+
+// SetHead implements model.HeadSetter
+func (din *DinNet) SetHead(h model.Head) {
+	din.head = h
+}
+
 func (din *DinNet) Marshal() (data []byte, err error) {
 	modelData := dinModel{
 		UProfileDim:   din.uProfileDim,
@@ -69,9 +92,15 @@ func (din *DinNet) Marshal() (data []byte, err error) {
 		Mlp0:          din.mlp0.Value().Data().([]float32),
 		Mlp1:          din.mlp1.Value().Data().([]float32),
 		Mlp2:          din.mlp2.Value().Data().([]float32),
+		Head:          din.head,
+		Outputs:       din.outputs,
 		Att0:          din.att0.Value().Data().([]float32),
 		//Att1:          din.att1.Value().Data().([]float32),
 	}
+	if din.lc0 != nil {
+		modelData.LatentCross = true
+		modelData.Lc0 = din.lc0.Value().Data().([]float32)
+	}
 
 	//marshal to json
 	data, err = json.Marshal(modelData)
@@ -91,6 +120,7 @@ func NewDinNetFromJson(data []byte) (din *DinNet, err error) {
 		uBehaviorDim  = m.UBehaviorDim
 		iFeatureDim   = m.IFeatureDim
 		cFeatureDim   = m.CFeatureDim
+		outputs       = model.OutputDim(m.Outputs)
 	)
 
 	// attention layer
@@ -109,14 +139,24 @@ func NewDinNetFromJson(data []byte) (din *DinNet, err error) {
 	//	G.WithName("att1"),
 	//)
 
+	latentCross := m.LatentCross && cFeatureDim > 0
+	mlp0In := mlp0InDim(uProfileDim, uBehaviorDim, iFeatureDim, cFeatureDim, latentCross)
 	mlp0 := G.NewMatrix(g, model.DT,
-		G.WithShape(uProfileDim+uBehaviorDim+iFeatureDim+cFeatureDim, mlp0_1),
+		G.WithShape(mlp0In, mlp0_1),
 		G.WithName("mlp0"),
 		G.WithValue(tensor.New(
-			tensor.WithShape(uProfileDim+uBehaviorDim+iFeatureDim+cFeatureDim, mlp0_1),
+			tensor.WithShape(mlp0In, mlp0_1),
 			tensor.WithBacking(m.Mlp0)),
 		),
 	)
+	var lc0 *G.Node
+	if latentCross {
+		lc0 = G.NewMatrix(g, model.DT,
+			G.WithShape(cFeatureDim, mlp0_1),
+			G.WithName("lc0"),
+			G.WithValue(tensor.New(tensor.WithShape(cFeatureDim, mlp0_1), tensor.WithBacking(m.Lc0))),
+		)
+	}
 
 	mlp1 := G.NewMatrix(g, model.DT,
 		G.WithShape(mlp0_1, mlp1_2),
@@ -125,9 +165,9 @@ func NewDinNetFromJson(data []byte) (din *DinNet, err error) {
 	)
 
 	mlp2 := G.NewMatrix(g, model.DT,
-		G.WithShape(mlp1_2, 1),
+		G.WithShape(mlp1_2, outputs),
 		G.WithName("mlp2"),
-		G.WithValue(tensor.New(tensor.WithShape(mlp1_2, 1), tensor.WithBacking(m.Mlp2))),
+		G.WithValue(tensor.New(tensor.WithShape(mlp1_2, outputs), tensor.WithBacking(m.Mlp2))),
 	)
 
 	din = &DinNet{
@@ -139,21 +179,44 @@ func NewDinNetFromJson(data []byte) (din *DinNet, err error) {
 		g:             g,
 		att0:          att0,
 		//att1:          att1,
-		mlp0: mlp0,
-		mlp1: mlp1,
-		mlp2: mlp2,
+		mlp0:        mlp0,
+		mlp1:        mlp1,
+		mlp2:        mlp2,
+		head:        m.Head,
+		outputs:     outputs,
+		latentCross: latentCross,
+		lc0:         lc0,
 	}
 	return
 }
 
+// mlp0InDim is the input width of the first MLP layer, the ctx features are
+// not concatenated of the latent cross
+func mlp0InDim(uProfileDim, uBehaviorDim, iFeatureDim, cFeatureDim int, latentCross bool) int {
+	if latentCross {
+		return uProfileDim + uBehaviorDim + iFeatureDim
+	}
+	return uProfileDim + uBehaviorDim + iFeatureDim + cFeatureDim
+}
+
 func (din *DinNet) Graph() *G.ExprGraph {
 	return din.g
 }
 
+// Attention implements model.Attender
+func (din *DinNet) Attention() G.Value {
+	return din.attention
+}
+
 func (din *DinNet) Out() *G.Node {
 	return din.out
 }
 
+// Logits implements model.LogitsOutputer
+func (din *DinNet) Logits() *G.Node {
+	return din.logits
+}
+
 func (din *DinNet) In() G.Nodes {
 	return G.Nodes{din.xUserProfile, din.xUbMatrix, din.xItemFeature, din.xCtxFeature}
 }
@@ -165,13 +228,54 @@ func (din *DinNet) Learnable() G.Nodes {
 	ret[2] = din.mlp2
 	ret = append(ret, din.att0)
 	//ret = append(ret, din.att1)
+	if din.lc0 != nil {
+		ret = append(ret, din.lc0)
+	}
 	return ret
 }
 
+// Option configures the DinNet created by NewDinNet
+type Option func(din *DinNet)
+
+// WithDropout sets the dropout probabilities of the two hidden MLP layers,
+// 0 disables dropout of the layer.
+func WithDropout(d0, d1 float32) Option {
+	return func(din *DinNet) {
+		for _, d := range []float32{d0, d1} {
+			if d < 0 || d >= 1 {
+				log.Fatalf("dropout probability %f out of range [0, 1)", d)
+			}
+		}
+		din.d0, din.d1 = d0, d1
+	}
+}
+
+// WithOutputs sets the width of the output layer, e.g. the classes of the
+// model.SoftmaxObjective, default 1.
+func WithOutputs(outputs int) Option {
+	return func(din *DinNet) {
+		if outputs <= 0 {
+			log.Fatalf("outputs %d should be positive", outputs)
+		}
+		din.outputs = outputs
+	}
+}
+
+// WithLatentCross injects the ctx features by the element wise gating of the
+// first MLP layer, mlp0Out * (1 + ctx lc0), instead of the concatenation.
+// The Latent Cross of Beutel et al. 2018 models the multiplicative effects of
+// the time and device context better. It's ignored of no ctx features.
+func WithLatentCross() Option {
+	return func(din *DinNet) {
+		din.latentCross = true
+	}
+}
+
 func NewDinNet(
 	uProfileDim, uBehaviorSize, uBehaviorDim int,
 	iFeatureDim int,
 	cFeatureDim int,
+	opts ...Option,
 ) *DinNet {
 	if uBehaviorDim != iFeatureDim {
 		log.Fatalf("uBehaviorDim %d != iFeatureDim %d", uBehaviorDim, iFeatureDim)
@@ -184,13 +288,9 @@ func NewDinNet(
 	// user behaviors are represented as a sequence of item embeddings. Before
 	// being fed into the MLP, we need to flatten the sequence into a single with
 	// sum pooling with Attention as the weights which is the key point of DIN model.
-	mlp0 := G.NewMatrix(g, model.DT, G.WithShape(uProfileDim+uBehaviorDim+iFeatureDim+cFeatureDim, mlp0_1), G.WithName("mlp0"), G.WithInit(G.Gaussian(0, 1.0)))
-
 	mlp1 := G.NewMatrix(g, model.DT, G.WithShape(mlp0_1, mlp1_2), G.WithName("mlp1"), G.WithInit(G.Gaussian(0, 1.0)))
 
-	mlp2 := G.NewMatrix(g, model.DT, G.WithShape(mlp1_2, 1), G.WithName("mlp2"), G.WithInit(G.Gaussian(0, 1.0)))
-
-	return &DinNet{
+	din := &DinNet{
 		uProfileDim:   uProfileDim,
 		uBehaviorSize: uBehaviorSize,
 		uBehaviorDim:  uBehaviorDim,
@@ -201,13 +301,36 @@ func NewDinNet(
 		att0: att0,
 		//att1: att1,
 
-		d0: 0.005,
-		d1: 0.005,
+		d0: defaultDropout,
+		d1: defaultDropout,
 
-		mlp0: mlp0,
-		mlp1: mlp1,
-		mlp2: mlp2,
+		mlp1:    mlp1,
+		outputs: 1,
+	}
+	for _, opt := range opts {
+		opt(din)
+	}
+	din.latentCross = din.latentCross && cFeatureDim > 0
+	din.mlp0 = G.NewMatrix(g, model.DT, G.WithShape(mlp0InDim(uProfileDim, uBehaviorDim, iFeatureDim, cFeatureDim, din.latentCross), mlp0_1),
+		G.WithName("mlp0"), G.WithInit(G.Gaussian(0, 1.0)))
+	if din.latentCross {
+		// the zero weights start of the identity gate
+		din.lc0 = G.NewMatrix(g, model.DT, G.WithShape(cFeatureDim, mlp0_1), G.WithName("lc0"), G.WithInit(G.Zeroes()))
 	}
+	din.mlp2 = G.NewMatrix(g, model.DT, G.WithShape(mlp1_2, din.outputs), G.WithName("mlp2"), G.WithInit(G.Gaussian(0, 1.0)))
+	return din
+}
+
+// CheckShapes implements model.ShapeChecker, the attention needs the
+// behaviors and the target item in the same embedding space.
+func (din *DinNet) CheckShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) (errs []error) {
+	if uBehaviorDim != iFeatureDim {
+		errs = append(errs, errors.Errorf("uBehaviorDim %d != iFeatureDim %d", uBehaviorDim, iFeatureDim))
+	}
+	if uBehaviorSize != din.uBehaviorSize {
+		errs = append(errs, errors.Errorf("uBehaviorSize %d != model uBehaviorSize %d", uBehaviorSize, din.uBehaviorSize))
+	}
+	return
 }
 
 // Fwd performs the forward pass
@@ -222,99 +345,47 @@ func (din *DinNet) Fwd(xUserProfile, xUbMatrix, xItemFeature, xCtxFeature *G.Nod
 		return errors.Errorf("uBehaviorDim %d != iFeatureDim %d", uBehaviorDim, iFeatureDim)
 	}
 	xUserBehaviors := G.Must(G.Reshape(xUbMatrix, tensor.Shape{batchSize, uBehaviorSize, uBehaviorDim}))
-	xItemFeature3d := G.Must(G.Reshape(xItemFeature, tensor.Shape{batchSize, 1, iFeatureDim}))
 
 	// attention layer
+	// attention: [batchSize, uBehaviorSize]
+	attention, err := layers.ActivationWeights(xUserBehaviors, xItemFeature, din.att0)
+	if err != nil {
+		return errors.Wrap(err, "attention")
+	}
+	G.Read(attention, &din.attention)
+	// actOutSum: [batchSize, uBehaviorDim]
+	actOutSum, err := layers.ActivationPooling(xUserBehaviors, attention)
+	if err != nil {
+		return errors.Wrap(err, "attention")
+	}
 
-	// weight: [batchSize, uBehaviorSize]
-	//weight := G.Must(G.Sub(G.NewConstant(float32(1.0)), G.Must(model.EucDistance(xUserBehaviors, xItemFeature3d))))
-	weight := G.Must(G.Div(
-		G.Must(G.Add(
-			G.Must(model.CosineSimilarity(xUserBehaviors, xItemFeature3d)),
-			G.NewConstant(float32(1.0)),
-		)),
-		G.NewConstant(float32(2.0)),
-	))
-	//euclideanDistance3d := G.Must(G.Reshape(distance, tensor.Shape{batchSize, uBehaviorSize, 1}))
-
-	//// outProduct should computed batch by batch!!!!
-	//outProdVecs := make([]*G.Node, batchSize)
-	//for i := 0; i < batchSize; i++ {
-	//	// ubVec.Shape() = [uBehaviorSize * uBehaviorDim]
-	//	ubVec := G.Must(G.Slice(xUbMatrix, G.S(i)))
-	//	// item.Shape() = [iFeatureDim]
-	//	itemVec := G.Must(G.Slice(xItemFeature, G.S(i)))
-	//	// outProd.Shape() = [uBehaviorSize * uBehaviorDim, iFeatureDim]
-	//	outProd := G.Must(G.OuterProd(ubVec, itemVec))
-	//	outProdVecs[i] = G.Must(G.Reshape(outProd, tensor.Shape{uBehaviorSize * uBehaviorDim * iFeatureDim}))
-	//}
-	////outProductsVec.Shape() = [batchSize * uBehaviorSize * uBehaviorDim * iFeatureDim]
-	//outProductsVec := G.Must(G.Concat(0, outProdVecs...))
-
-	//xItemFeatureBroad.Shape() = [batchSize, uBehaviorSize, iFeatureDim]
-	//xItemFeatureBroad, _, err := G.Broadcast(xItemFeature3d, xUserBehaviors, G.NewBroadcastPattern([]byte{1}, nil))
-	//if err != nil {
-	//	return errors.Wrap(err, "Broadcast")
-	//}
-	////actConcat.Shape() = [batchSize, uBehaviorSize, uBehaviorDim+1+iFeatureDim]
-	//actConcat := G.Must(G.Concat(2, xUserBehaviors, euclideanDistance3d, xItemFeatureBroad))
-	//actConcat3d := G.Must(G.Reshape(actConcat, tensor.Shape{batchSize, uBehaviorSize * (uBehaviorDim + 1 + iFeatureDim), 1}))
-
-	//actOuts.Shape() = [batchSize, uBehaviorSize, uBehaviorDim]
-	actOuts := G.Must(G.BroadcastHadamardProd(
-		xUserBehaviors,
-		G.Must(G.Sigmoid(
-			//[batchSize, uBehaviorSize, 1]
-			G.Must(G.Reshape(
-				//[batchSize, uBehaviorSize]
-				//	⊙
-				//[:		, uBehaviorSize]
-				G.Must(G.BroadcastHadamardProd(weight, din.att0, nil, []byte{0})),
-				tensor.Shape{batchSize, uBehaviorSize, 1},
-			)))),
-		nil, []byte{2},
-	))
-
-	//actOuts := G.NewTensor(din.Graph(), model.DT, 2, G.WithShape(batchSize, uBehaviorDim), G.WithName("actOuts"), G.WithInit(G.Zeroes()))
-	//for i := 0; i < uBehaviorSize; i++ {
-	//	// xUserBehaviors[:, i, :], ub.shape: [batchSize, uBehaviorDim]
-	//	ub := G.Must(G.Slice(xUserBehaviors, []tensor.Slice{nil, G.S(i)}...))
-	//	// Concat all xUserBehaviors[i], outProducts, xItemFeature
-	//	// actConcat.Shape() = [batchSize, uBehaviorDim+iFeatureDim+uBehaviorSize]
-	//	actConcat := G.Must(G.Concat(1, ub, distance, xItemFeature))
-	//	actOut := G.Must(G.BroadcastHadamardProd(
-	//		ub,
-	//		G.Must(G.Sigmoid(
-	//			G.Must(G.Mul(
-	//				G.Must(G.HadamardProd(actConcat, din.att0)),
-	//				din.att1,
-	//			)))), // [batchSize, 1]
-	//		nil, []byte{1},
-	//	)) // [batchSize, uBehaviorDim]
-	//
-	//	// Sum pooling
-	//	actOuts = G.Must(G.Add(actOuts, actOut))
-	//}
-	actOutSum := G.Must(G.Mean(actOuts, 1))
-
-	// Concat all xUserProfile, actOuts, xItemFeature, xCtxFeature
-	concat := G.Must(G.Concat(1, xUserProfile, actOutSum, xItemFeature, xCtxFeature))
+	// Concat all xUserProfile, actOuts, xItemFeature, xCtxFeature, the
+	// xCtxFeature gates the mlp0Out instead of the latent cross
+	var concat *G.Node
+	if din.lc0 != nil {
+		concat = G.Must(G.Concat(1, xUserProfile, actOutSum, xItemFeature))
+	} else {
+		concat = G.Must(G.Concat(1, xUserProfile, actOutSum, xItemFeature, xCtxFeature))
+	}
 
 	// MLP
 
 	// mlp0.Shape: [userProfileDim+userBehaviorDim+itemFeatureDim+contextFeatureDim, 200]
 	// out.Shape: [batchSize, 200]
-	mlp0Out := G.Must(G.Sigmoid(G.Must(G.Mul(concat, din.mlp0))))
-	mlp0Out = G.Must(G.Dropout(mlp0Out, float64(din.d0)))
+	mlp0Out := G.Must(layers.Dense(concat, din.mlp0, G.Sigmoid))
+	if din.lc0 != nil {
+		// lc0.Shape: [contextFeatureDim, 200]
+		gate := G.Must(G.Add(G.Must(layers.Dense(xCtxFeature, din.lc0, nil)), G.NewConstant(float32(1))))
+		mlp0Out = G.Must(G.HadamardProd(mlp0Out, gate))
+	}
+	mlp0Out = G.Must(layers.Dropout(mlp0Out, din.d0, din.training))
 	// mlp1.Shape: [200, 80]
 	// out.Shape: [batchSize, 80]
-	mlp1Out := G.Must(G.Sigmoid(G.Must(G.Mul(mlp0Out, din.mlp1))))
-	mlp1Out = G.Must(G.Dropout(mlp1Out, float64(din.d1)))
-	// mlp2.Shape: [80, 1]
-	// out.Shape: [batchSize, 1]
-	mlp2Out := G.Must(G.Sigmoid(G.Must(G.Mul(mlp1Out, din.mlp2))))
-
-	din.out = mlp2Out
+	mlp1Out := G.Must(layers.Dropout(G.Must(layers.Dense(mlp0Out, din.mlp1, G.Sigmoid)), din.d1, din.training))
+	// mlp2.Shape: [80, outputs]
+	// out.Shape: [batchSize, outputs]
+	din.logits = G.Must(layers.Dense(mlp1Out, din.mlp2, nil))
+	din.out = G.Must(layers.Activate(din.logits, din.head.Activation()))
 	din.xUserProfile = xUserProfile
 	din.xItemFeature = xItemFeature
 	din.xCtxFeature = xCtxFeature
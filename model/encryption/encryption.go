@@ -0,0 +1,249 @@
+// Package encryption encrypts the persisted model files and feature stores
+// at rest by AES-GCM, for the deployments of which the device storage could
+// be physically extracted. The key is of the KeyEnv env by default, or of
+// the KMS hook of SetKeyProvider:
+//
+//	EDGEREC_STORAGE_KEY=$(head -c 32 /dev/urandom | base64) ./edgerec
+//
+// The files are sealed by Encode on save and opened by Decode on load. The
+// files saved with no key are kept plain. Once the key is set the plain data
+// is refused by Decode, so the extracted storage can't be swapped for the
+// forged plain files. The deployments setting the key on the existing plain
+// files allow them by AllowPlaintextEnv or SetAllowPlaintext till they are
+// saved again:
+//
+//	EDGEREC_STORAGE_ALLOW_PLAINTEXT=1 EDGEREC_STORAGE_KEY=... ./edgerec
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeyEnv is the env of the base64 AES key of 16, 24 or 32 bytes
+const KeyEnv = "EDGEREC_STORAGE_KEY"
+
+// AllowPlaintextEnv is the env of Decode keeping the plain data of the key
+// set, of 1 or true
+const AllowPlaintextEnv = "EDGEREC_STORAGE_ALLOW_PLAINTEXT"
+
+// magic is the header of the sealed data, followed by the nonce and the
+// ciphertext
+var magic = []byte("EDGEENC1")
+
+// ErrNoKey is returned by Decode of the sealed data of no key
+var ErrNoKey = fmt.Errorf("data is encrypted, no key of %s or the key provider", KeyEnv)
+
+// ErrPlaintext is returned by Decode of the plain data of the key set, see
+// AllowPlaintextEnv
+var ErrPlaintext = fmt.Errorf("data is not encrypted of the storage key, allow it by %s", AllowPlaintextEnv)
+
+// KeyProvider returns the key, nil of no encryption, e.g. the data key
+// decrypted by the KMS
+type KeyProvider func() ([]byte, error)
+
+var (
+	keyMu    sync.Mutex
+	provider KeyProvider = EnvKey
+	key      []byte
+	keyOk    bool
+	// allowPlaintext is of SetAllowPlaintext
+	allowPlaintext bool
+)
+
+// SetKeyProvider sets the KMS hook of the key, nil restores EnvKey. The key
+// is asked once and kept until the next SetKeyProvider.
+func SetKeyProvider(p KeyProvider) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	if p == nil {
+		p = EnvKey
+	}
+	provider, key, keyOk = p, nil, false
+}
+
+// SetAllowPlaintext sets if Decode keeps the plain data of the key set, it's
+// allowed either by it or AllowPlaintextEnv
+func SetAllowPlaintext(allow bool) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	allowPlaintext = allow
+}
+
+func plaintextAllowed() bool {
+	keyMu.Lock()
+	allow := allowPlaintext
+	keyMu.Unlock()
+	if allow {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(AllowPlaintextEnv))) {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+// EnvKey is the KeyProvider of the KeyEnv env, nil if unset
+func EnvKey() ([]byte, error) {
+	s, ok := os.LookupEnv(KeyEnv)
+	if !ok || strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %v", KeyEnv, err)
+	}
+	return k, nil
+}
+
+// Key returns the key of the provider, nil of no encryption
+func Key() ([]byte, error) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	if keyOk {
+		return key, nil
+	}
+	k, err := provider()
+	if err != nil {
+		return nil, fmt.Errorf("storage key: %v", err)
+	}
+	if k != nil {
+		if _, err = aes.NewCipher(k); err != nil {
+			return nil, fmt.Errorf("storage key: %v", err)
+		}
+	}
+	key, keyOk = k, true
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsSealed tells data is sealed by Seal
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Seal encrypts plaintext by key
+func Seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, len(magic)+gcm.NonceSize(), len(magic)+gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	copy(sealed, magic)
+	nonce := sealed[len(magic):]
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(sealed, nonce, plaintext, nil), nil
+}
+
+// Open decrypts the data sealed by Seal, the tampered data or another key
+// fails
+func Open(key, data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return nil, errors.New("data is not encrypted")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(magic):]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data truncated")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %v", err)
+	}
+	return plaintext, nil
+}
+
+// Encode seals data to be saved by the Key, data is returned as is of no key
+func Encode(data []byte) ([]byte, error) {
+	k, err := Key()
+	if err != nil || k == nil {
+		return data, err
+	}
+	return Seal(k, data)
+}
+
+// Decode opens the data loaded by the Key. The plain data is returned as is
+// of no key, it's ErrPlaintext of the key set unless allowed.
+func Decode(data []byte) ([]byte, error) {
+	k, err := Key()
+	if err != nil {
+		return nil, err
+	}
+	if !IsSealed(data) {
+		if k != nil && !plaintextAllowed() {
+			return nil, ErrPlaintext
+		}
+		return data, nil
+	}
+	if k == nil {
+		return nil, ErrNoKey
+	}
+	return Open(k, data)
+}
+
+// CheckPlaintext is the check of Decode of the plain records, e.g. the rows
+// of a db: ErrPlaintext of the key set unless allowed
+func CheckPlaintext() error {
+	k, err := Key()
+	if err != nil {
+		return err
+	}
+	if k != nil && !plaintextAllowed() {
+		return ErrPlaintext
+	}
+	return nil
+}
+
+// EncodeLine is Encode of the line of the line based files, the sealed line
+// is of base64 so it's of no newline
+func EncodeLine(line []byte) ([]byte, error) {
+	sealed, err := Encode(line)
+	if err != nil || !IsSealed(sealed) {
+		return sealed, err
+	}
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(encoded, sealed)
+	return encoded, nil
+}
+
+// DecodeLine is Decode of the line of EncodeLine, with no newline
+func DecodeLine(line []byte) ([]byte, error) {
+	if decoded, err := base64.StdEncoding.DecodeString(string(line)); err == nil && IsSealed(decoded) {
+		line = decoded
+	}
+	return Decode(line)
+}
+
+// ReadFile is os.ReadFile of the file decoded by Decode
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = Decode(data); err != nil {
+		return nil, fmt.Errorf("read %s: %v", path, err)
+	}
+	return data, nil
+}
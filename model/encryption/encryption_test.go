@@ -0,0 +1,137 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSeal(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+
+	Convey("seal and open", t, func() {
+		plaintext := []byte(`{"w":[1,2,3]}`)
+		sealed, err := Seal(key, plaintext)
+		So(err, ShouldBeNil)
+		So(IsSealed(sealed), ShouldBeTrue)
+		So(bytes.Contains(sealed, plaintext), ShouldBeFalse)
+		opened, err := Open(key, sealed)
+		So(err, ShouldBeNil)
+		So(opened, ShouldResemble, plaintext)
+
+		again, err := Seal(key, plaintext)
+		So(err, ShouldBeNil)
+		So(again, ShouldNotResemble, sealed)
+
+		_, err = Open(bytes.Repeat([]byte{8}, 32), sealed)
+		So(err, ShouldNotBeNil)
+		sealed[len(sealed)-1] ^= 1
+		_, err = Open(key, sealed)
+		So(err, ShouldNotBeNil)
+		_, err = Open(key, magic)
+		So(err, ShouldNotBeNil)
+		_, err = Open(key, plaintext)
+		So(err, ShouldNotBeNil)
+		_, err = Seal(key[:5], plaintext)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestKeyProvider(t *testing.T) {
+	defer SetKeyProvider(nil)
+	key := bytes.Repeat([]byte{7}, 16)
+
+	Convey("encode and decode of the key provider", t, func() {
+		calls := 0
+		SetKeyProvider(func() ([]byte, error) {
+			calls++
+			return key, nil
+		})
+		data := []byte("1 0.5 0.5\n")
+		sealed, err := Encode(data)
+		So(err, ShouldBeNil)
+		So(IsSealed(sealed), ShouldBeTrue)
+		decoded, err := Decode(sealed)
+		So(err, ShouldBeNil)
+		So(decoded, ShouldResemble, data)
+		So(calls, ShouldEqual, 1)
+
+		// the plain files are refused of the key unless allowed
+		_, err = Decode(data)
+		So(err, ShouldEqual, ErrPlaintext)
+		SetAllowPlaintext(true)
+		decoded, err = Decode(data)
+		So(err, ShouldBeNil)
+		So(decoded, ShouldResemble, data)
+		SetAllowPlaintext(false)
+		t.Setenv(AllowPlaintextEnv, "1")
+		decoded, err = Decode(data)
+		So(err, ShouldBeNil)
+		So(decoded, ShouldResemble, data)
+		t.Setenv(AllowPlaintextEnv, "")
+		_, err = Decode(data)
+		So(err, ShouldEqual, ErrPlaintext)
+
+		// the lines are sealed of no newline
+		line, err := EncodeLine([]byte(`{"userId":1}`))
+		So(err, ShouldBeNil)
+		So(bytes.ContainsAny(line, "\n{"), ShouldBeFalse)
+		decoded, err = DecodeLine(line)
+		So(err, ShouldBeNil)
+		So(decoded, ShouldResemble, []byte(`{"userId":1}`))
+		_, err = DecodeLine([]byte(`{"userId":1}`))
+		So(err, ShouldEqual, ErrPlaintext)
+
+		path := filepath.Join(t.TempDir(), "model.json")
+		So(os.WriteFile(path, sealed, 0600), ShouldBeNil)
+		read, err := ReadFile(path)
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, data)
+
+		SetKeyProvider(func() ([]byte, error) { return nil, nil })
+		plain, err := Encode(data)
+		So(err, ShouldBeNil)
+		So(plain, ShouldResemble, data)
+		decoded, err = Decode(plain)
+		So(err, ShouldBeNil)
+		So(decoded, ShouldResemble, data)
+		plain, err = EncodeLine(data)
+		So(err, ShouldBeNil)
+		So(plain, ShouldResemble, data)
+		_, err = Decode(sealed)
+		So(err, ShouldEqual, ErrNoKey)
+		_, err = ReadFile(path)
+		So(err, ShouldNotBeNil)
+
+		SetKeyProvider(func() ([]byte, error) { return nil, errors.New("kms unavailable") })
+		_, err = Encode(data)
+		So(err, ShouldNotBeNil)
+		SetKeyProvider(func() ([]byte, error) { return []byte("short"), nil })
+		_, err = Key()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("key of the env", t, func() {
+		SetKeyProvider(nil)
+		t.Setenv(KeyEnv, base64.StdEncoding.EncodeToString(key))
+		k, err := Key()
+		So(err, ShouldBeNil)
+		So(k, ShouldResemble, key)
+
+		SetKeyProvider(nil)
+		t.Setenv(KeyEnv, "not base64!")
+		_, err = Key()
+		So(err, ShouldNotBeNil)
+
+		SetKeyProvider(nil)
+		t.Setenv(KeyEnv, "")
+		k, err = Key()
+		So(err, ShouldBeNil)
+		So(k, ShouldBeNil)
+	})
+}
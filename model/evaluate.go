@@ -0,0 +1,168 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"gorgonia.org/tensor"
+)
+
+// Evaluation is the validation loss of Train after a batch
+type Evaluation struct {
+	Epoch int `json:"epoch"`
+	// Batch is the batches of the epoch trained
+	Batch int `json:"batch"`
+	// Steps are the solver steps taken
+	Steps int `json:"steps"`
+	// Loss is the data loss of the validation samples, regularization terms
+	// excluded and the targets not smoothed
+	Loss float32 `json:"loss"`
+	// LearnRate is the learning rate of the solver, EvalConfig.OnEvaluation
+	// could set it for the steps after
+	LearnRate float64 `json:"learnRate"`
+}
+
+// EvalConfig configures WithEvaluation, the zero values are the defaults
+type EvalConfig struct {
+	// Every batches trained the validation loss is evaluated, besides the
+	// end of every epoch. It's evaluated at the end of epochs only if 0.
+	Every int
+	// Subset is the validation rows sampled once by Seed and evaluated every
+	// time, so the losses are comparable. All the rows if 0.
+	Subset int
+	Seed   int64
+	// OnEvaluation is called with every Evaluation if not nil, e.g. of a
+	// learning rate scheduler setting Evaluation.LearnRate
+	OnEvaluation func(ev *Evaluation)
+}
+
+// WithEvaluation evaluates the data loss of the validation inputs and
+// targets of the same layout as the training ones, on the Model of newModel
+// of the trained weights. newModel should return a Model of the same
+// architecture and options in its own graph like WithDataParallel. The
+// early stop of Train is on the validation loss of every evaluation instead
+// of the training loss of every epoch, so earlyStop counts the evaluations
+// without an improvement. It gives the timely signal of the long epochs of
+// the big datasets. ListwiseObjective is not supported, since the slates are
+// cut by the subset.
+func WithEvaluation(inputs, targets tensor.Tensor, newModel func() Model, conf EvalConfig) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.EvalInputs = inputs
+		opts.EvalTargets = targets
+		opts.NewEvalModel = newModel
+		opts.Eval = conf
+	}
+}
+
+// evaluator is the forward only Model of the validation samples of Train
+type evaluator struct {
+	m         Model
+	si        *rcmd.SampleInfo
+	objective Objective
+	// x is the validation subset, y its encoded targets
+	x         tensor.Tensor
+	y         []float32
+	yWidth    int
+	rows      int
+	batchSize int
+}
+
+// newEvaluator returns the evaluator of trainOpts of the Model trained m
+func newEvaluator(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int,
+	batchSize int,
+	si *rcmd.SampleInfo,
+	m Model,
+	trainOpts *TrainOpts,
+) (e *evaluator, err error) {
+	conf := trainOpts.Eval
+	if conf.Every < 0 || conf.Subset < 0 {
+		return nil, fmt.Errorf("evaluation every %d batches of subset %d should not be negative", conf.Every, conf.Subset)
+	}
+	if trainOpts.Objective == ListwiseObjective {
+		return nil, fmt.Errorf("evaluation of objective %s not supported", trainOpts.Objective)
+	}
+	inputs, targets := trainOpts.EvalInputs, trainOpts.EvalTargets
+	if inputs == nil || targets == nil || len(inputs.Shape()) == 0 {
+		return nil, fmt.Errorf("no validation inputs and targets")
+	}
+	em := trainOpts.NewEvalModel()
+	if em == m || em.Graph() == m.Graph() {
+		return nil, fmt.Errorf("NewEvalModel shares the graph of the trained model")
+	}
+	rows := inputs.Shape()[0]
+	if err = ValidateShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim,
+		rows, batchSize, si, inputs, targets, em); err != nil {
+		return
+	}
+	if err = checkObjective(trainOpts.Objective, trainOpts.Classes, em); err != nil {
+		return
+	}
+	if conf.Subset > 0 && conf.Subset < rows {
+		subset := rand.New(rand.NewSource(conf.Seed)).Perm(rows)[:conf.Subset]
+		sort.Ints(subset)
+		if inputs, err = subsetRows(inputs, subset); err != nil {
+			return nil, fmt.Errorf("inputs: %v", err)
+		}
+		if targets, err = subsetRows(targets, subset); err != nil {
+			return nil, fmt.Errorf("targets: %v", err)
+		}
+		rows = conf.Subset
+	}
+	// the loss of the targets as given, not smoothed
+	evalOpts := *trainOpts
+	evalOpts.LabelSmoothing = 0
+	if targets, err = objectiveTargets(&evalOpts, targets, batchSize); err != nil {
+		return
+	}
+	y, yWidth, err := rowMajor(targets)
+	if err != nil {
+		return nil, fmt.Errorf("targets: %v", err)
+	}
+	if err = InitForwardOnlyVm(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim,
+		batchSize, em); err != nil {
+		return
+	}
+	return &evaluator{
+		m:         em,
+		si:        si,
+		objective: trainOpts.Objective,
+		x:         inputs,
+		y:         y,
+		yWidth:    yWidth,
+		rows:      rows,
+		batchSize: batchSize,
+	}, nil
+}
+
+// evaluate returns the mean data loss of the validation samples of the
+// weights of trained
+func (e *evaluator) evaluate(trained Model) (loss float32, err error) {
+	if err = copyWeights(e.m.Learnable(), trained.Learnable()); err != nil {
+		return
+	}
+	out, err := Predict(e.m, e.rows, e.batchSize, e.si, e.x)
+	if err != nil {
+		return
+	}
+	var sum float64
+	for _, l := range rowLosses(e.objective, nil, e.y, e.yWidth, out, nil, 0, e.rows) {
+		sum += l
+	}
+	return float32(sum / float64(e.rows)), nil
+}
+
+// subsetRows returns the rows of t in a new tensor
+func subsetRows(t tensor.Tensor, rows []int) (tensor.Tensor, error) {
+	data, width, err := rowMajor(t)
+	if err != nil {
+		return nil, err
+	}
+	backing := make([]float32, 0, len(rows)*width)
+	for _, r := range rows {
+		backing = append(backing, data[r*width:(r+1)*width]...)
+	}
+	shape := append([]int{len(rows)}, t.Shape()[1:]...)
+	return tensor.New(tensor.WithShape(shape...), tensor.WithBacking(backing)), nil
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+	"gorgonia.org/tensor"
+)
+
+// DefaultPredBatchSize is the samples of a forward run of the Fitter model
+const DefaultPredBatchSize = 64
+
+// Fitter is the rcmd.Fitter of the Model of New trained by Train, the model
+// served is the forward only copy loaded by Load from the Marshal of the
+// trained one, e.g.
+//
+//	fitter := &model.Fitter{
+//		New: func(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) model.Model {
+//			return din.NewDinNet(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim)
+//		},
+//		Load:      func(data []byte) (model.Model, error) { return din.NewDinNetFromJson(data) },
+//		BatchSize: 200,
+//		Epochs:    20,
+//	}
+type Fitter struct {
+	// New creates the Model of the feature dims of the samples
+	New func(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) Model
+	// Load is the NewXXXFromJson of the Model
+	Load func(data []byte) (Model, error)
+	// BatchSize and Epochs of Train, EarlyStop epochs of no improvement, 0
+	// never stops early
+	BatchSize, Epochs, EarlyStop int
+	// PredBatchSize is the samples of a forward run, DefaultPredBatchSize
+	// if 0
+	PredBatchSize int
+	// Options of Train, e.g. WithLearningRate
+	Options []TrainOption
+}
+
+// Fit implements rcmd.Fitter
+func (f *Fitter) Fit(trainSample *rcmd.TrainSample) (rcmd.PredictAbstract, error) {
+	if trainSample.Rows != len(trainSample.Y) {
+		return nil, fmt.Errorf("number of examples %d and labels %d do not match", trainSample.Rows, len(trainSample.Y))
+	}
+	si := trainSample.Info
+	var (
+		uProfileDim = si.UserProfileRange[1] - si.UserProfileRange[0]
+		cFeatureDim = si.CtxFeatureRange[1] - si.CtxFeatureRange[0]
+		inputs      = tensor.New(tensor.WithShape(trainSample.Rows, trainSample.XCols), tensor.WithBacking(trainSample.X))
+		labels      = tensor.New(tensor.WithShape(trainSample.Rows, 1), tensor.WithBacking(trainSample.Y))
+	)
+	learner := f.New(uProfileDim, rcmd.UserBehaviorLen, rcmd.ItemEmbDim, rcmd.ItemEmbDim, cFeatureDim)
+	if err := Train(uProfileDim, rcmd.UserBehaviorLen, rcmd.ItemEmbDim, rcmd.ItemEmbDim, cFeatureDim,
+		trainSample.Rows, f.BatchSize, f.Epochs, f.EarlyStop,
+		&si,
+		inputs, labels,
+		learner,
+		f.Options...,
+	); err != nil {
+		return nil, fmt.Errorf("train model: %v", err)
+	}
+	data, err := learner.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("marshal model: %v", err)
+	}
+	p := &fitterPredictor{batchSize: f.PredBatchSize, si: &si}
+	if p.batchSize <= 0 {
+		p.batchSize = DefaultPredBatchSize
+	}
+	if p.m, err = f.Load(data); err != nil {
+		return nil, fmt.Errorf("load model: %v", err)
+	}
+	if err = InitForwardOnlyVm(uProfileDim, rcmd.UserBehaviorLen, rcmd.ItemEmbDim, rcmd.ItemEmbDim, cFeatureDim,
+		p.batchSize, p.m); err != nil {
+		return nil, fmt.Errorf("init forward only vm: %v", err)
+	}
+	if _, ok := p.m.(Attender); ok {
+		return &attentionPredictor{p}, nil
+	}
+	return p, nil
+}
+
+// fitterPredictor is the forward only Model of Fitter, the runs of the vm
+// are serialized
+type fitterPredictor struct {
+	batchSize int
+	si        *rcmd.SampleInfo
+
+	mu sync.Mutex
+	m  Model
+}
+
+func (p *fitterPredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	numPred := X.Shape()[0]
+	p.mu.Lock()
+	y, err := Predict(p.m, numPred, p.batchSize, p.si, X)
+	p.mu.Unlock()
+	if err != nil {
+		log.Errorf("predict model failed: %v", err)
+		return nil
+	}
+	return tensor.NewDense(DT, tensor.Shape{numPred, 1}, tensor.WithBacking(y))
+}
+
+var _ rcmd.BehaviorAttention = &attentionPredictor{}
+
+// attentionPredictor is the fitterPredictor of the Attender Model
+type attentionPredictor struct {
+	*fitterPredictor
+}
+
+// BehaviorAttention implements rcmd.BehaviorAttention
+func (p *attentionPredictor) BehaviorAttention(X tensor.Tensor) (tensor.Tensor, error) {
+	numPred := X.Shape()[0]
+	p.mu.Lock()
+	weights, err := Attention(p.m, numPred, p.batchSize, p.si, X)
+	p.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("attention: %v", err)
+	}
+	return tensor.NewDense(DT, tensor.Shape{numPred, len(weights) / numPred}, tensor.WithBacking(weights)), nil
+}
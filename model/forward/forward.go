@@ -0,0 +1,119 @@
+// Package forward is the forward only inference of the model bundles of
+// model.MarshalBundle, loaded by the model type without the Fitter that
+// trained them, e.g. by the on-device scorer and the embedded mode.
+package forward
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/bst"
+	"github.com/auxten/go-ctr/model/din"
+	"github.com/auxten/go-ctr/model/pnn"
+	"github.com/auxten/go-ctr/model/youtube"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"gorgonia.org/tensor"
+)
+
+// DefaultBatchSize is the samples of a forward run
+const DefaultBatchSize = 64
+
+// loaders are the model types by their NewXXXFromJson
+var loaders = map[string]func([]byte) (model.Model, error){
+	"din":     func(data []byte) (model.Model, error) { return din.NewDinNetFromJson(data) },
+	"youtube": func(data []byte) (model.Model, error) { return youtube.NewYoutubeDnnFromJson(data) },
+	"bst":     func(data []byte) (model.Model, error) { return bst.NewBstNetFromJson(data) },
+	"pnn":     func(data []byte) (model.Model, error) { return pnn.NewPnnNetFromJson(data) },
+}
+
+// ModelTypes are the model types of Load
+func ModelTypes() (types []string) {
+	for t := range loaders {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return
+}
+
+// Model is the forward graph of a bundle, it's safe for concurrent use
+type Model struct {
+	si        *rcmd.SampleInfo
+	manifest  *rcmd.Manifest
+	batchSize int
+
+	mu sync.Mutex
+	m  model.Model
+}
+
+// Load loads the bundle of modelType, batchSize is DefaultBatchSize if 0.
+// The scores are calibrated by the sampling Correction of the bundle
+// manifest if any.
+func Load(modelType string, bundle []byte, batchSize int) (f *Model, err error) {
+	load, ok := loaders[modelType]
+	if !ok {
+		return nil, fmt.Errorf("unknown model type %q, should be one of %v", modelType, ModelTypes())
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	f = &Model{batchSize: batchSize}
+	var data []byte
+	if f.si, data, err = model.UnmarshalBundle(bundle); err != nil {
+		return nil, err
+	}
+	if f.manifest, err = model.BundleManifest(bundle); err != nil {
+		return nil, err
+	}
+	if f.m, err = load(data); err != nil {
+		return nil, fmt.Errorf("load %s model: %v", modelType, err)
+	}
+	if err = model.InitForwardOnlyVm(width(f.si.UserProfileRange), rcmd.UserBehaviorLen, rcmd.ItemEmbDim,
+		rcmd.ItemEmbDim, width(f.si.CtxFeatureRange), batchSize, f.m); err != nil {
+		return nil, fmt.Errorf("init %s model: %v", modelType, err)
+	}
+	return
+}
+
+func width(r [2]int) int {
+	return r[1] - r[0]
+}
+
+// SampleInfo is the layout of the sample vectors of the bundle
+func (f *Model) SampleInfo() *rcmd.SampleInfo {
+	return f.si
+}
+
+// Manifest is the data lineage of the bundle, nil if marshaled without it
+func (f *Model) Manifest() *rcmd.Manifest {
+	return f.manifest
+}
+
+// Predict returns the scores of the rows of the row major sample vectors x,
+// the first output of the models of multiple outputs, calibrated by the
+// Correction of the manifest
+func (f *Model) Predict(rows int, x []float32) (scores []float32, err error) {
+	if rows == 0 {
+		return []float32{}, nil
+	}
+	if len(x) != rows*f.si.Width() {
+		return nil, fmt.Errorf("%d values of %d rows of width %d", len(x), rows, f.si.Width())
+	}
+	inputs := tensor.New(tensor.WithShape(rows, f.si.Width()), tensor.WithBacking(x))
+	f.mu.Lock()
+	y, err := model.Predict(f.m, rows, f.batchSize, f.si, inputs)
+	f.mu.Unlock()
+	if err != nil {
+		return
+	}
+	outputs := len(y) / rows
+	scores = make([]float32, rows)
+	for i := range scores {
+		scores[i] = y[i*outputs]
+	}
+	if f.manifest != nil {
+		f.manifest.Correction.CalibrateScores(scores)
+	}
+	return
+}
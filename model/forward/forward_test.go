@@ -0,0 +1,84 @@
+package forward
+
+import (
+	"testing"
+
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/din"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoad(t *testing.T) {
+	si, err := rcmd.NewSampleInfoBuilder().
+		UserProfile(2).
+		UserBehavior(rcmd.UserBehaviorLen, rcmd.ItemEmbDim).
+		ItemFeature(rcmd.ItemEmbDim).
+		CtxFeature(1).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &rcmd.Manifest{Samples: 10}
+	net := din.NewDinNet(2, rcmd.UserBehaviorLen, rcmd.ItemEmbDim, rcmd.ItemEmbDim, 1)
+	bundle, err := model.MarshalBundleWithManifest(net, si, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	correction := &rcmd.Correction{NegativeRate: 0.1, Factor: 0.1}
+	corrected, err := model.MarshalBundleWithManifest(net, si, &rcmd.Manifest{Correction: correction})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("forward of the bundle", t, func() {
+		So(ModelTypes(), ShouldResemble, []string{"bst", "din", "pnn", "youtube"})
+		m, err := Load("din", bundle, 2)
+		So(err, ShouldBeNil)
+		So(m.SampleInfo(), ShouldResemble, si)
+		So(m.Manifest().Samples, ShouldEqual, 10)
+
+		// the batches of 2 and the last partial one
+		x := make([]float32, 3*si.Width())
+		for i := range x {
+			x[i] = float32(i%7) / 7
+		}
+		y, err := m.Predict(3, x)
+		So(err, ShouldBeNil)
+		So(y, ShouldHaveLength, 3)
+		first, err := m.Predict(1, x[:si.Width()])
+		So(err, ShouldBeNil)
+		So(first[0], ShouldAlmostEqual, y[0], 1e-6)
+
+		y, err = m.Predict(0, nil)
+		So(err, ShouldBeNil)
+		So(y, ShouldBeEmpty)
+		_, err = m.Predict(2, x)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("scores calibrated by the sampling correction", t, func() {
+		m, err := Load("din", bundle, 0)
+		So(err, ShouldBeNil)
+		c, err := Load("din", corrected, 0)
+		So(err, ShouldBeNil)
+		x := make([]float32, 2*si.Width())
+		for i := range x {
+			x[i] = float32(i%5) / 5
+		}
+		y, err := m.Predict(2, x)
+		So(err, ShouldBeNil)
+		calibrated, err := c.Predict(2, x)
+		So(err, ShouldBeNil)
+		for i := range y {
+			So(calibrated[i], ShouldAlmostEqual, correction.Calibrate(float64(y[i])), 1e-6)
+		}
+	})
+
+	Convey("bad bundles", t, func() {
+		_, err := Load("gbdt", bundle, 0)
+		So(err, ShouldNotBeNil)
+		_, err = Load("din", []byte("{}"), 0)
+		So(err, ShouldNotBeNil)
+	})
+}
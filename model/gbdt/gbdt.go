@@ -0,0 +1,194 @@
+// Package gbdt is the gradient boosted decision trees scoring the same
+// sample vectors as the neural models, in pure Go without gorgonia. The
+// trees are trained by Fitter, or trained offline on the ExportSamples and
+// imported from the LightGBM or XGBoost model file by Load. The Model is a
+// rcmd.PredictAbstract, and LeafFitter feeds its leaves into the neural models.
+package gbdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+	"gorgonia.org/tensor"
+)
+
+// the objectives of the Model
+const (
+	// Binary is the log loss of the labels in [0, 1], the scores are the
+	// sigmoid of the raw scores
+	Binary = "binary"
+	// Regression is the squared error, the scores are the raw scores
+	Regression = "regression"
+)
+
+// Node is a split or a leaf of a Tree
+type Node struct {
+	// Feature is the column of the sample vector split by, the samples of
+	// x[Feature] <= Threshold go Left
+	Feature   int     `json:"feature"`
+	Threshold float32 `json:"threshold"`
+	Left      int     `json:"left"`
+	Right     int     `json:"right"`
+	// DefaultLeft sends the missing (NaN) values left
+	DefaultLeft bool `json:"defaultLeft,omitempty"`
+	// MissingZero treats the zeros as missing as well
+	MissingZero bool `json:"missingZero,omitempty"`
+	// Categories is the bitset of the categories going left of the
+	// categorical split, of which x[Feature] is the category. The negative
+	// and missing categories go right.
+	Categories []uint32 `json:"categories,omitempty"`
+	Leaf       bool     `json:"leaf,omitempty"`
+	Value      float32  `json:"value,omitempty"`
+}
+
+// left returns if v goes left of the split
+func (n *Node) left(v float32) bool {
+	if n.Categories != nil {
+		if v != v || v < 0 {
+			return false
+		}
+		c := int(v)
+		return c/32 < len(n.Categories) && n.Categories[c/32]&(1<<uint(c%32)) != 0
+	}
+	if v != v || (n.MissingZero && v == 0) {
+		return n.DefaultLeft
+	}
+	return v <= n.Threshold
+}
+
+// Tree is the nodes of a tree, the root first
+type Tree struct {
+	Nodes []Node `json:"nodes"`
+}
+
+// leaf returns the leaf node of x
+func (t *Tree) leaf(x []float32) int {
+	i := 0
+	for !t.Nodes[i].Leaf {
+		if n := &t.Nodes[i]; n.left(x[n.Feature]) {
+			i = n.Left
+		} else {
+			i = n.Right
+		}
+	}
+	return i
+}
+
+// layout lays out the nodes of a tree depth first from the split root, so
+// the children follow the parent. node returns the Node of a split or leaf
+// index of the model file and the indices of its children if not a leaf.
+func layout(root, maxNodes int, node func(i int) (n Node, left, right int, err error)) (tree Tree, err error) {
+	var add func(i int) (int, error)
+	add = func(i int) (int, error) {
+		if len(tree.Nodes) >= maxNodes {
+			return 0, fmt.Errorf("cyclic tree of over %d nodes", maxNodes)
+		}
+		n, left, right, err := node(i)
+		if err != nil {
+			return 0, err
+		}
+		id := len(tree.Nodes)
+		tree.Nodes = append(tree.Nodes, n)
+		if n.Leaf {
+			return id, nil
+		}
+		if tree.Nodes[id].Left, err = add(left); err != nil {
+			return 0, err
+		}
+		if tree.Nodes[id].Right, err = add(right); err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+	_, err = add(root)
+	return
+}
+
+// Model is the boosted trees, the raw score is BaseScore plus the sum of the
+// leaf values of x
+type Model struct {
+	Objective string  `json:"objective"`
+	BaseScore float64 `json:"baseScore"`
+	// Features is the width of the sample vectors
+	Features int    `json:"features"`
+	Trees    []Tree `json:"trees"`
+}
+
+func (m *Model) validate() error {
+	switch m.Objective {
+	case Binary, Regression:
+	default:
+		return fmt.Errorf("unknown gbdt objective %q", m.Objective)
+	}
+	for t, tree := range m.Trees {
+		if len(tree.Nodes) == 0 {
+			return fmt.Errorf("tree %d has no node", t)
+		}
+		for i, n := range tree.Nodes {
+			if n.Leaf {
+				continue
+			}
+			if n.Feature < 0 || n.Feature >= m.Features {
+				return fmt.Errorf("tree %d node %d feature %d out of %d features", t, i, n.Feature, m.Features)
+			}
+			// the children follow the parent, so there is no cycle
+			if n.Left <= i || n.Right <= i || n.Left >= len(tree.Nodes) || n.Right >= len(tree.Nodes) {
+				return fmt.Errorf("tree %d node %d has invalid children %d and %d", t, i, n.Left, n.Right)
+			}
+		}
+	}
+	return nil
+}
+
+// Raw returns the raw score of the sample vector x
+func (m *Model) Raw(x []float32) float64 {
+	raw := m.BaseScore
+	for t := range m.Trees {
+		tree := &m.Trees[t]
+		raw += float64(tree.Nodes[tree.leaf(x)].Value)
+	}
+	return raw
+}
+
+// Score returns the score of the sample vector x by the Objective
+func (m *Model) Score(x []float32) float32 {
+	raw := m.Raw(x)
+	if m.Objective == Binary {
+		return float32(1 / (1 + math.Exp(-raw)))
+	}
+	return float32(raw)
+}
+
+// Predict implements rcmd.PredictAbstract, X is the sample vectors of
+// Features columns
+func (m *Model) Predict(X tensor.Tensor) tensor.Tensor {
+	rows, cols := X.Shape()[0], X.Shape()[1]
+	data, ok := X.Data().([]float32)
+	if !ok || cols != m.Features {
+		log.Errorf("gbdt input of %d cols != %d features", cols, m.Features)
+		return nil
+	}
+	y := make([]float32, rows)
+	for i := range y {
+		y[i] = m.Score(data[i*cols : (i+1)*cols])
+	}
+	return tensor.New(tensor.WithShape(rows, 1), tensor.WithBacking(y))
+}
+
+func (m *Model) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// NewModelFromJson loads the Model of Marshal
+func NewModelFromJson(data []byte) (m *Model, err error) {
+	m = &Model{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if err = m.validate(); err != nil {
+		return nil, err
+	}
+	return
+}
@@ -0,0 +1,148 @@
+package gbdt
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/utils"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// lgbModel is a LightGBM text model of 2 trees on 3 features
+const lgbModel = `tree
+version=v3
+num_class=1
+num_tree_per_iteration=1
+label_index=0
+max_feature_idx=2
+objective=binary sigmoid:1
+feature_names=Column_0 Column_1 Column_2
+feature_infos=[0:1] [0:1] [0:1]
+tree_sizes=300 200
+
+Tree=0
+num_leaves=3
+num_cat=0
+split_feature=0 2
+split_gain=10 5
+threshold=0.5 0.25
+decision_type=10 0
+left_child=1 -2
+right_child=-1 -3
+leaf_value=0.4 -0.3 0.1
+leaf_weight=1 1 1
+leaf_count=10 10 10
+internal_value=0 0
+internal_weight=0 0
+internal_count=30 20
+is_linear=0
+shrinkage=1
+
+
+Tree=1
+num_leaves=1
+num_cat=0
+split_feature=
+split_gain=
+threshold=
+decision_type=
+left_child=
+right_child=
+leaf_value=-0.05
+leaf_weight=
+leaf_count=
+internal_value=
+internal_weight=
+internal_count=
+is_linear=0
+shrinkage=1
+
+
+end of trees
+
+feature_importances:
+Column_0=1
+Column_2=1
+`
+
+func TestGBDT(t *testing.T) {
+	// the label is of the interaction of 2 features, the third is noise
+	rnd := rand.New(rand.NewSource(1))
+	sample := func(n int) (x, y []float32) {
+		for i := 0; i < n; i++ {
+			a, b, c := rnd.Float32(), rnd.Float32(), rnd.Float32()
+			x = append(x, a, b, c)
+			label := float32(0)
+			if (a > 0.5) != (b > 0.5) {
+				label = 1
+			}
+			y = append(y, label)
+		}
+		return
+	}
+	trainX, trainY := sample(2000)
+	testX, testY := sample(500)
+
+	Convey("trained on the interactions", t, func() {
+		m, err := Train(trainX, trainY, 2000, 3, Options{Trees: 30, MaxDepth: 3})
+		So(err, ShouldBeNil)
+		So(m.Trees, ShouldHaveLength, 30)
+		pred := make([]float32, len(testY))
+		for i := range pred {
+			pred[i] = m.Score(testX[i*3 : i*3+3])
+		}
+		So(utils.RocAuc32(pred, testY), ShouldBeGreaterThan, 0.95)
+
+		data, err := m.Marshal()
+		So(err, ShouldBeNil)
+		loaded, err := NewModelFromJson(data)
+		So(err, ShouldBeNil)
+		So(loaded.Score(testX[:3]), ShouldEqual, m.Score(testX[:3]))
+
+		reg, err := Train(trainX, trainY, 2000, 3, Options{Objective: Regression, Trees: 30, MaxDepth: 3})
+		So(err, ShouldBeNil)
+		So(reg.Score([]float32{0.9, 0.1, 0.5}), ShouldBeGreaterThan, 0.8)
+		So(reg.Score([]float32{0.9, 0.9, 0.5}), ShouldBeLessThan, 0.2)
+	})
+
+	Convey("fitted on the train sample", t, func() {
+		fitter := &Fitter{Options: Options{Trees: 10}}
+		pred, err := fitter.Fit(&rcmd.TrainSample{X: trainX, Y: trainY, Rows: 2000, XCols: 3})
+		So(err, ShouldBeNil)
+		y := pred.Predict(tensor.New(tensor.WithShape(2, 3), tensor.WithBacking([]float32{0.9, 0.1, 0, 0.1, 0.1, 0})))
+		So(y.Shape(), ShouldResemble, tensor.Shape{2, 1})
+		scores := y.Data().([]float32)
+		So(scores[0], ShouldBeGreaterThan, scores[1])
+		So(pred.Predict(tensor.New(tensor.WithShape(1, 2), tensor.WithBacking([]float32{0, 0}))), ShouldBeNil)
+
+		_, err = Train(trainX, trainY, 2000, 3, Options{Bins: 1000})
+		So(err, ShouldNotBeNil)
+		_, err = Train(trainX, trainY, 2000, 3, Options{Objective: "lambdarank"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("imported from lightgbm", t, func() {
+		m, err := ParseLightGBM(strings.NewReader(lgbModel))
+		So(err, ShouldBeNil)
+		So(m.Objective, ShouldEqual, Binary)
+		So(m.Features, ShouldEqual, 3)
+		So(m.Trees, ShouldHaveLength, 2)
+		sigmoid := func(raw float64) float32 { return float32(1 / (1 + math.Exp(-raw))) }
+		So(m.Score([]float32{0.9, 0, 0}), ShouldAlmostEqual, sigmoid(0.4-0.05), 1e-6)
+		So(m.Score([]float32{0.1, 0, 0.1}), ShouldAlmostEqual, sigmoid(-0.3-0.05), 1e-6)
+		So(m.Score([]float32{0.1, 0, 0.9}), ShouldAlmostEqual, sigmoid(0.1-0.05), 1e-6)
+		// the missing value goes left by the decision type
+		So(m.Score([]float32{float32(math.NaN()), 0, 0.1}), ShouldAlmostEqual, sigmoid(-0.3-0.05), 1e-6)
+
+		_, err = ParseLightGBM(strings.NewReader("objective=binary\n"))
+		So(err, ShouldNotBeNil)
+		_, err = ParseLightGBM(strings.NewReader(strings.Replace(lgbModel, "left_child=1 -2", "left_child=1 5", 1)))
+		So(err, ShouldNotBeNil)
+		_, err = ParseLightGBM(strings.NewReader(strings.Replace(lgbModel, "decision_type=10 0", "decision_type=11 0", 1)))
+		So(err, ShouldNotBeNil)
+	})
+}
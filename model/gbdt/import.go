@@ -0,0 +1,84 @@
+package gbdt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+// Load reads the Model of path, detected by the content: the Marshal json,
+// the XGBoost json or the LightGBM text model. The XGBoost text dump has no
+// objective, so it's parsed by ParseXGBoostDump instead.
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("load gbdt model %s: %v", path, err)
+	}
+	return m, nil
+}
+
+// Parse is Load of data
+func Parse(data []byte) (*Model, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var probe struct {
+			Learner json.RawMessage `json:"learner"`
+		}
+		if err := json.Unmarshal(trimmed, &probe); err != nil {
+			return nil, err
+		}
+		if probe.Learner != nil {
+			return ParseXGBoost(bytes.NewReader(trimmed))
+		}
+		return NewModelFromJson(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("booster[")):
+		return nil, fmt.Errorf("xgboost text dump should be parsed by ParseXGBoostDump with the objective")
+	}
+	return ParseLightGBM(bytes.NewReader(trimmed))
+}
+
+// CheckSampleInfo returns an error if the sample vectors of si are not of
+// the Features, e.g. the features changed since the offline training
+func (m *Model) CheckSampleInfo(si *rcmd.SampleInfo) error {
+	if width := si.Width(); width != m.Features {
+		return fmt.Errorf("gbdt model of %d features != sample width %d", m.Features, width)
+	}
+	return nil
+}
+
+// ExportSamples writes the train samples as the csv of the label and the
+// feature columns named f0, f1, ... for the offline training by LightGBM
+// (header=true, label_column=0) or XGBoost (label_column=0)
+func ExportSamples(w io.Writer, sample *rcmd.TrainSample) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("label")
+	for j := 0; j < sample.XCols; j++ {
+		bw.WriteString(",f")
+		bw.WriteString(strconv.Itoa(j))
+	}
+	bw.WriteByte('\n')
+	var buf []byte
+	for i := 0; i < sample.Rows; i++ {
+		buf = strconv.AppendFloat(buf[:0], float64(sample.Y[i]), 'g', -1, 32)
+		for _, v := range sample.X[i*sample.XCols : (i+1)*sample.XCols] {
+			buf = append(buf, ',')
+			buf = strconv.AppendFloat(buf, float64(v), 'g', -1, 32)
+		}
+		buf = append(buf, '\n')
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,182 @@
+package gbdt
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// xgbJson is a XGBoost json model of a tree: f0 < 0.5 ? 0.2 : (f2 < 0.25 ? -0.1 : 0.3)
+const xgbJson = `{
+  "learner": {
+    "attributes": {},
+    "feature_names": [],
+    "gradient_booster": {
+      "model": {
+        "gbtree_model_param": {"num_parallel_tree": "1", "num_trees": "1"},
+        "tree_info": [0],
+        "trees": [{
+          "base_weights": [0, 0.2, 0, -0.1, 0.3],
+          "default_left": [1, 0, 0, 0, 0],
+          "id": 0,
+          "left_children": [1, -1, 3, -1, -1],
+          "right_children": [2, -1, 4, -1, -1],
+          "split_conditions": [0.5, 0.2, 0.25, -0.1, 0.3],
+          "split_indices": [0, 0, 2, 0, 0],
+          "split_type": [0, 0, 0, 0, 0],
+          "tree_param": {"num_feature": "3", "num_nodes": "5"}
+        }]
+      },
+      "name": "gbtree"
+    },
+    "learner_model_param": {"base_score": "5E-1", "num_class": "0", "num_feature": "3", "num_target": "1"},
+    "objective": {"name": "binary:logistic", "reg_loss_param": {"scale_pos_weight": "1"}}
+  },
+  "version": [1, 7, 6]
+}`
+
+// xgbDump is the text dump of xgbJson
+const xgbDump = `booster[0]:
+0:[f0<0.5] yes=1,no=2,missing=1,gain=10,cover=30
+	1:leaf=0.2,cover=10
+	2:[f2<0.25] yes=3,no=4,missing=4,gain=5,cover=20
+		3:leaf=-0.1,cover=10
+		4:leaf=0.3,cover=10
+`
+
+// lgbTree returns a LightGBM text model of the split of feature 1, the
+// leaf values are 1 on the left and -1 on the right
+func lgbTree(decision, threshold, categories string) string {
+	return `tree
+version=v3
+num_class=1
+max_feature_idx=1
+objective=regression
+
+Tree=0
+num_leaves=2
+num_cat=1
+split_feature=1
+threshold=` + threshold + `
+decision_type=` + decision + `
+left_child=-1
+right_child=-2
+leaf_value=1 -1
+` + categories + `
+end of trees
+`
+}
+
+func TestImport(t *testing.T) {
+	sigmoid := func(raw float64) float32 { return float32(1 / (1 + math.Exp(-raw))) }
+	nan := float32(math.NaN())
+
+	Convey("xgboost json and text dump", t, func() {
+		fromJson, err := ParseXGBoost(strings.NewReader(xgbJson))
+		So(err, ShouldBeNil)
+		fromDump, err := ParseXGBoostDump(strings.NewReader(xgbDump), "binary:logistic", 0.5)
+		So(err, ShouldBeNil)
+		for _, m := range []*Model{fromJson, fromDump} {
+			So(m.Objective, ShouldEqual, Binary)
+			So(m.BaseScore, ShouldEqual, 0)
+			So(m.Score([]float32{0.4, 0, 0}), ShouldAlmostEqual, sigmoid(0.2), 1e-6)
+			// the split is x < 0.5
+			So(m.Score([]float32{0.5, 0, 0.25}), ShouldAlmostEqual, sigmoid(0.3), 1e-6)
+			So(m.Score([]float32{0.5, 0, 0.2}), ShouldAlmostEqual, sigmoid(-0.1), 1e-6)
+			So(m.Score([]float32{nan, 0, 0.9}), ShouldAlmostEqual, sigmoid(0.2), 1e-6)
+			So(m.Score([]float32{0.9, 0, nan}), ShouldAlmostEqual, sigmoid(0.3), 1e-6)
+		}
+		So(fromDump.Features, ShouldEqual, 3)
+
+		// the newer versions
+		newer := strings.Replace(xgbJson, `"5E-1"`, `"[5E-1]"`, 1)
+		newer = strings.Replace(newer, `"default_left": [1, 0, 0, 0, 0]`, `"default_left": [true, false, false, false, false]`, 1)
+		m, err := ParseXGBoost(strings.NewReader(newer))
+		So(err, ShouldBeNil)
+		So(m.Score([]float32{nan, 0, 0.9}), ShouldAlmostEqual, sigmoid(0.2), 1e-6)
+
+		m, err = ParseXGBoost(strings.NewReader(strings.Replace(xgbJson, "binary:logistic", "reg:squarederror", 1)))
+		So(err, ShouldBeNil)
+		So(m.Score([]float32{0.4, 0, 0}), ShouldAlmostEqual, 0.7, 1e-6)
+
+		_, err = ParseXGBoost(strings.NewReader(strings.Replace(xgbJson, "binary:logistic", "rank:pairwise", 1)))
+		So(err, ShouldNotBeNil)
+		_, err = ParseXGBoost(strings.NewReader(strings.Replace(xgbJson, `"gbtree"`, `"gblinear"`, 1)))
+		So(err, ShouldNotBeNil)
+		_, err = ParseXGBoost(strings.NewReader(strings.Replace(xgbJson, "[1, -1, 3, -1, -1]", "[1, -1, 0, -1, -1]", 1)))
+		So(err, ShouldNotBeNil)
+		_, err = ParseXGBoostDump(strings.NewReader(strings.Replace(xgbDump, "yes=3", "yes=7", 1)), "binary:logistic", 0.5)
+		So(err, ShouldNotBeNil)
+		_, err = ParseXGBoostDump(strings.NewReader(xgbDump), "binary:logistic", 0)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("lightgbm missing types and categorical splits", t, func() {
+		// default left of the NaN missing type
+		m, err := ParseLightGBM(strings.NewReader(lgbTree("10", "0.5", "")))
+		So(err, ShouldBeNil)
+		So(m.Score([]float32{0, nan}), ShouldEqual, 1)
+		So(m.Score([]float32{0, 0.9}), ShouldEqual, -1)
+
+		// the missing values are zeros of the none missing type
+		m, err = ParseLightGBM(strings.NewReader(lgbTree("2", "-0.5", "")))
+		So(err, ShouldBeNil)
+		So(m.Score([]float32{0, nan}), ShouldEqual, -1)
+
+		// the zeros are missing of the zero missing type
+		m, err = ParseLightGBM(strings.NewReader(lgbTree("6", "-0.5", "")))
+		So(err, ShouldBeNil)
+		So(m.Score([]float32{0, 0}), ShouldEqual, 1)
+		So(m.Score([]float32{0, nan}), ShouldEqual, 1)
+		So(m.Score([]float32{0, 0.1}), ShouldEqual, -1)
+
+		// the categories 1 and 3 of the bitset 0b1010 go left
+		m, err = ParseLightGBM(strings.NewReader(lgbTree("1", "0", "cat_boundaries=0 1\ncat_threshold=10\n")))
+		So(err, ShouldBeNil)
+		for c, score := range map[float32]float32{0: -1, 1: 1, 2: -1, 3: 1, 40: -1, -1: -1, nan: -1} {
+			So(m.Score([]float32{0, c}), ShouldEqual, score)
+		}
+		_, err = ParseLightGBM(strings.NewReader(lgbTree("1", "1", "cat_boundaries=0 1\ncat_threshold=10\n")))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("loaded by the content", t, func() {
+		dir := t.TempDir()
+		trained, err := Train([]float32{0, 1, 0, 1}, []float32{0, 1, 0, 1}, 4, 1, Options{Trees: 2, MinLeafSamples: 1})
+		So(err, ShouldBeNil)
+		data, err := trained.Marshal()
+		So(err, ShouldBeNil)
+		files := map[string]string{
+			"gbdt.json":    string(data),
+			"xgboost.json": xgbJson,
+			"lightgbm.txt": lgbModel,
+		}
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			So(os.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+			m, err := Load(path)
+			So(err, ShouldBeNil)
+			So(m.Trees, ShouldNotBeEmpty)
+		}
+		_, err = Parse([]byte(xgbDump))
+		So(err, ShouldNotBeNil)
+		_, err = Load(filepath.Join(dir, "none.txt"))
+		So(err, ShouldNotBeNil)
+
+		si, err := rcmd.NewSampleInfoBuilder().UserProfile(1).UserBehavior(0, 0).ItemFeature(1).CtxFeature(1).Build()
+		So(err, ShouldBeNil)
+		So(trained.CheckSampleInfo(si), ShouldNotBeNil)
+	})
+
+	Convey("samples exported for the offline training", t, func() {
+		var buf bytes.Buffer
+		So(ExportSamples(&buf, &rcmd.TrainSample{X: []float32{0.5, 1, 2, 3}, Y: []float32{1, 0}, Rows: 2, XCols: 2}), ShouldBeNil)
+		So(buf.String(), ShouldEqual, "label,f0,f1\n1,0.5,1\n0,2,3\n")
+	})
+}
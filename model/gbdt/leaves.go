@@ -0,0 +1,251 @@
+package gbdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+	"gorgonia.org/tensor"
+)
+
+// the encodings of the leaf features appended to the sample vectors
+const (
+	// LeafOneHot is a column of every leaf of the trees, 1 of the leaves
+	// reached and 0 of the others
+	LeafOneHot = "onehot"
+	// LeafIndex is the columns of LeafOneHot fed as the ids of the leaves
+	// reached, the CSR of a non-zero per tree, looked up in the embedding
+	// table of the columns of the rcmd.SparseFitter neural model, e.g.
+	// wide.Fitter. The leaves are never densified in the training.
+	LeafIndex = "index"
+)
+
+// LeafMapping is the ids of the leaves of the Model, numbered tree by tree
+// in the node order
+type LeafMapping struct {
+	// Ids[t][i] is the id of the node i of the tree t, -1 of the splits
+	Ids [][]int `json:"ids"`
+	// Leaves is the number of the leaves of all the trees
+	Leaves int `json:"leaves"`
+}
+
+// LeafMapping returns the leaf ids of the trees
+func (m *Model) LeafMapping() *LeafMapping {
+	mapping := &LeafMapping{Ids: make([][]int, len(m.Trees))}
+	for t := range m.Trees {
+		ids := make([]int, len(m.Trees[t].Nodes))
+		for i, n := range m.Trees[t].Nodes {
+			ids[i] = -1
+			if n.Leaf {
+				ids[i] = mapping.Leaves
+				mapping.Leaves++
+			}
+		}
+		mapping.Ids[t] = ids
+	}
+	return mapping
+}
+
+// Leaves returns the leaf node of x of every tree
+func (m *Model) Leaves(x []float32) []int {
+	leaves := make([]int, len(m.Trees))
+	for t := range m.Trees {
+		leaves[t] = m.Trees[t].leaf(x)
+	}
+	return leaves
+}
+
+// leafWidth is the columns of the leaf features of encoding
+func leafWidth(encoding string, mapping *LeafMapping) (int, error) {
+	switch encoding {
+	case LeafOneHot, LeafIndex:
+		return mapping.Leaves, nil
+	}
+	return 0, fmt.Errorf("unknown leaf encoding %q", encoding)
+}
+
+// LeafPredictor scores the sample vectors appended with the leaf features of
+// Trees by Net, the neural model trained on them by LeafFitter
+type LeafPredictor struct {
+	Trees    *Model
+	Mapping  *LeafMapping
+	Encoding string
+	Net      rcmd.PredictAbstract
+
+	width int
+}
+
+func newLeafPredictor(trees *Model, encoding string, net rcmd.PredictAbstract) (p *LeafPredictor, err error) {
+	p = &LeafPredictor{Trees: trees, Mapping: trees.LeafMapping(), Encoding: encoding, Net: net}
+	if p.width, err = leafWidth(encoding, p.Mapping); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Width is the columns of the leaf features appended
+func (p *LeafPredictor) Width() int {
+	return p.width
+}
+
+// Transform returns the rows of x of Trees.Features columns appended with
+// the leaf features
+func (p *LeafPredictor) Transform(x []float32, rows int) []float32 {
+	var (
+		cols  = p.Trees.Features
+		width = cols + p.width
+		out   = make([]float32, rows*width)
+	)
+	for i := 0; i < rows; i++ {
+		row := x[i*cols : (i+1)*cols]
+		copy(out[i*width:], row)
+		features := out[i*width+cols : (i+1)*width]
+		for t, leaf := range p.Trees.Leaves(row) {
+			features[p.Mapping.Ids[t][leaf]] = 1
+		}
+	}
+	return out
+}
+
+// TransformSparse is the CSR of Transform, the leaves reached are the
+// column ids of the leaf features
+func (p *LeafPredictor) TransformSparse(x []float32, rows int) *rcmd.SparseSample {
+	cols := p.Trees.Features
+	s := rcmd.NewSparseSample(cols + p.width)
+	for i := 0; i < rows; i++ {
+		row := x[i*cols : (i+1)*cols]
+		for j, v := range row {
+			if v != 0 {
+				s.Indices = append(s.Indices, j)
+				s.Values = append(s.Values, v)
+			}
+		}
+		// the ids are numbered tree by tree, so in order
+		for t, leaf := range p.Trees.Leaves(row) {
+			s.Indices = append(s.Indices, cols+p.Mapping.Ids[t][leaf])
+			s.Values = append(s.Values, 1)
+		}
+		s.Indptr = append(s.Indptr, len(s.Indices))
+	}
+	return s
+}
+
+// Predict implements rcmd.PredictAbstract, X is the sample vectors of
+// Trees.Features columns
+func (p *LeafPredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	rows, cols := X.Shape()[0], X.Shape()[1]
+	data, ok := X.Data().([]float32)
+	if !ok || cols != p.Trees.Features {
+		log.Errorf("leaf predictor input of %d cols != %d features", cols, p.Trees.Features)
+		return nil
+	}
+	x := p.Transform(data, rows)
+	return p.Net.Predict(tensor.New(tensor.WithShape(rows, cols+p.width), tensor.WithBacking(x)))
+}
+
+// leafPipeline is the json of LeafPredictor, the leaf mapping is stored to
+// check the trees loaded number the leaves same as the training
+type leafPipeline struct {
+	Encoding string          `json:"encoding"`
+	Trees    *Model          `json:"trees"`
+	Mapping  *LeafMapping    `json:"mapping"`
+	Net      json.RawMessage `json:"net"`
+}
+
+// Marshal serializes the trees, the leaf mapping and the Net, which should
+// have the Marshal method like the model.Model
+func (p *LeafPredictor) Marshal() ([]byte, error) {
+	net, ok := p.Net.(interface{ Marshal() ([]byte, error) })
+	if !ok {
+		return nil, fmt.Errorf("leaf predictor net %T could not be marshaled", p.Net)
+	}
+	netData, err := net.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(leafPipeline{Encoding: p.Encoding, Trees: p.Trees, Mapping: p.Mapping, Net: netData})
+}
+
+// NewLeafPredictorFromJson loads the LeafPredictor of Marshal, loadNet loads
+// the Net json, e.g. by the NewXXXFromJson of the model
+func NewLeafPredictorFromJson(data []byte, loadNet func([]byte) (rcmd.PredictAbstract, error)) (p *LeafPredictor, err error) {
+	var pipeline leafPipeline
+	if err = json.Unmarshal(data, &pipeline); err != nil {
+		return
+	}
+	if pipeline.Trees == nil {
+		return nil, fmt.Errorf("trees not found in leaf predictor")
+	}
+	if err = pipeline.Trees.validate(); err != nil {
+		return
+	}
+	net, err := loadNet(pipeline.Net)
+	if err != nil {
+		return
+	}
+	if p, err = newLeafPredictor(pipeline.Trees, pipeline.Encoding, net); err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(p.Mapping, pipeline.Mapping) {
+		return nil, fmt.Errorf("leaf mapping of the trees differs from the training")
+	}
+	return
+}
+
+// LeafFitter trains the neural model Net on the sample vectors appended with
+// the leaf features of the trees, the classic GBDT feature transformation
+type LeafFitter struct {
+	// Trees is the Model trained or imported in advance, e.g. by Load, nil
+	// trains it by Options on the same samples
+	Trees   *Model
+	Options Options
+	// Encoding is LeafOneHot by default
+	Encoding string
+	Net      rcmd.Fitter
+}
+
+func (f *LeafFitter) Fit(trainSample *rcmd.TrainSample) (rcmd.PredictAbstract, error) {
+	encoding := f.Encoding
+	if encoding == "" {
+		encoding = LeafOneHot
+	}
+	trees := f.Trees
+	if trees == nil {
+		var err error
+		if trees, err = Train(trainSample.X, trainSample.Y, trainSample.Rows, trainSample.XCols, f.Options); err != nil {
+			return nil, err
+		}
+	}
+	if trees.Features != trainSample.XCols {
+		return nil, fmt.Errorf("gbdt model of %d features != sample width %d", trees.Features, trainSample.XCols)
+	}
+	p, err := newLeafPredictor(trees, encoding, nil)
+	if err != nil {
+		return nil, err
+	}
+	sparseNet, sparse := f.Net.(rcmd.SparseFitter)
+	if encoding == LeafIndex && !sparse {
+		return nil, fmt.Errorf("leaf index net %T should be a rcmd.SparseFitter", f.Net)
+	}
+	log.Infof("appending %d %s leaf features of %d trees", p.width, encoding, len(trees.Trees))
+
+	sample := *trainSample
+	sample.XCols += p.width
+	// the leaf features go last as the context features
+	if sample.Info.Width() == trainSample.XCols {
+		sample.Info.CtxFeatureRange[1] += p.width
+	}
+	if encoding == LeafIndex {
+		sample.X, sample.Sparse = nil, p.TransformSparse(trainSample.X, trainSample.Rows)
+		p.Net, err = sparseNet.FitSparse(&sample)
+	} else {
+		sample.X = p.Transform(trainSample.X, trainSample.Rows)
+		p.Net, err = f.Net.Fit(&sample)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
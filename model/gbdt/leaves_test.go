@@ -0,0 +1,148 @@
+package gbdt
+
+import (
+	"math/rand"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/utils"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// recordFitter keeps the train sample of the Fitter
+type recordFitter struct {
+	rcmd.Fitter
+	sample *rcmd.TrainSample
+}
+
+func (f *recordFitter) Fit(sample *rcmd.TrainSample) (rcmd.PredictAbstract, error) {
+	f.sample = sample
+	return f.Fitter.Fit(sample)
+}
+
+// sparseRecordFitter is the recordFitter of the sparse samples, fitted
+// densified
+type sparseRecordFitter struct {
+	recordFitter
+}
+
+func (f *sparseRecordFitter) FitSparse(sample *rcmd.TrainSample) (rcmd.PredictAbstract, error) {
+	f.sample = sample
+	dense := *sample
+	for i := 0; i < sample.Rows; i++ {
+		dense.X = append(dense.X, sample.Sparse.DenseRow(i, nil)...)
+	}
+	return f.Fitter.Fit(&dense)
+}
+
+func TestLeaves(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var x, y []float32
+	for i := 0; i < 1000; i++ {
+		a, b := rnd.Float32(), rnd.Float32()
+		x = append(x, a, b)
+		label := float32(0)
+		if (a > 0.5) != (b > 0.5) {
+			label = 1
+		}
+		y = append(y, label)
+	}
+	info, err := rcmd.NewSampleInfoBuilder().UserProfile(1).ItemFeature(1).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := &rcmd.TrainSample{X: x, Y: y, Rows: 1000, XCols: 2, Info: *info}
+
+	Convey("leaf mapping of the trees", t, func() {
+		m, err := Train(x, y, 1000, 2, Options{Trees: 3, MaxDepth: 2})
+		So(err, ShouldBeNil)
+		mapping := m.LeafMapping()
+		So(mapping.Ids, ShouldHaveLength, 3)
+		seen := make(map[int]bool)
+		for t, tree := range m.Trees {
+			for i, n := range tree.Nodes {
+				if n.Leaf {
+					So(seen[mapping.Ids[t][i]], ShouldBeFalse)
+					seen[mapping.Ids[t][i]] = true
+				} else {
+					So(mapping.Ids[t][i], ShouldEqual, -1)
+				}
+			}
+		}
+		So(seen, ShouldHaveLength, mapping.Leaves)
+		for t, leaf := range m.Leaves(x[:2]) {
+			So(m.Trees[t].Nodes[leaf].Leaf, ShouldBeTrue)
+		}
+	})
+
+	Convey("neural model trained on the one-hot leaves", t, func() {
+		net := &recordFitter{Fitter: &Fitter{Options: Options{Trees: 20, MaxDepth: 2}}}
+		pred, err := (&LeafFitter{Options: Options{Trees: 5, MaxDepth: 2}, Net: net}).Fit(sample)
+		So(err, ShouldBeNil)
+		p := pred.(*LeafPredictor)
+		So(p.Encoding, ShouldEqual, LeafOneHot)
+		So(p.Width(), ShouldEqual, p.Mapping.Leaves)
+		So(net.sample.XCols, ShouldEqual, 2+p.Width())
+		So(net.sample.Info.CtxFeatureRange, ShouldResemble, [2]int{2, 2 + p.Width()})
+		So(net.sample.Info.Validate(net.sample.XCols), ShouldBeNil)
+		// the original columns first, then a leaf of every tree
+		row := net.sample.X[:net.sample.XCols]
+		So(row[:2], ShouldResemble, x[:2])
+		ones := float32(0)
+		for _, v := range row[2:] {
+			ones += v
+		}
+		So(ones, ShouldEqual, 5)
+
+		y := pred.Predict(tensor.New(tensor.WithShape(1000, 2), tensor.WithBacking(x)))
+		So(utils.RocAuc32(y.Data().([]float32), sample.Y), ShouldBeGreaterThan, 0.8)
+		So(pred.Predict(tensor.New(tensor.WithShape(1, 3), tensor.WithBacking([]float32{0, 0, 0}))), ShouldBeNil)
+
+		data, err := p.Marshal()
+		So(err, ShouldBeNil)
+		loadNet := func(data []byte) (rcmd.PredictAbstract, error) { return NewModelFromJson(data) }
+		loaded, err := NewLeafPredictorFromJson(data, loadNet)
+		So(err, ShouldBeNil)
+		So(loaded.Predict(tensor.New(tensor.WithShape(2, 2), tensor.WithBacking(x[:4]))).Data(), ShouldResemble,
+			pred.Predict(tensor.New(tensor.WithShape(2, 2), tensor.WithBacking(x[:4]))).Data())
+
+		// the leaves numbered differently of the trees changed
+		p.Trees.Trees = p.Trees.Trees[1:]
+		data, err = p.Marshal()
+		So(err, ShouldBeNil)
+		_, err = NewLeafPredictorFromJson(data, loadNet)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("leaf indices of the imported trees", t, func() {
+		trees, err := Train(x, y, 1000, 2, Options{Trees: 4, MaxDepth: 2})
+		So(err, ShouldBeNil)
+		net := &sparseRecordFitter{recordFitter{Fitter: &Fitter{Options: Options{Trees: 2}}}}
+		pred, err := (&LeafFitter{Trees: trees, Encoding: LeafIndex, Net: net}).Fit(sample)
+		So(err, ShouldBeNil)
+		p := pred.(*LeafPredictor)
+		So(p.Trees, ShouldEqual, trees)
+		mapping := trees.LeafMapping()
+		So(p.Width(), ShouldEqual, mapping.Leaves)
+		So(net.sample.X, ShouldBeNil)
+		So(net.sample.XCols, ShouldEqual, 2+mapping.Leaves)
+		So(net.sample.Sparse.Cols, ShouldEqual, net.sample.XCols)
+		// the non-zeros of the row, then the leaf id of every tree
+		indices, values := net.sample.Sparse.Row(0)
+		So(indices, ShouldHaveLength, 2+4)
+		for t, leaf := range trees.Leaves(x[:2]) {
+			So(indices[2+t], ShouldEqual, 2+mapping.Ids[t][leaf])
+			So(values[2+t], ShouldEqual, 1)
+		}
+		So(net.sample.Sparse.DenseRow(0, nil), ShouldResemble, p.Transform(x[:2], 1))
+
+		// the leaf ids should be looked up by a sparse net
+		_, err = (&LeafFitter{Trees: trees, Encoding: LeafIndex, Net: &net.recordFitter}).Fit(sample)
+		So(err, ShouldNotBeNil)
+		_, err = (&LeafFitter{Trees: trees, Encoding: "hash", Net: net}).Fit(sample)
+		So(err, ShouldNotBeNil)
+		_, err = (&LeafFitter{Trees: trees, Net: net}).Fit(&rcmd.TrainSample{X: []float32{0}, Y: []float32{0}, Rows: 1, XCols: 1})
+		So(err, ShouldNotBeNil)
+	})
+}
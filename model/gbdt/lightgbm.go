@@ -0,0 +1,197 @@
+package gbdt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// the bits of the LightGBM decision_type
+const (
+	lgbCategorical = 1
+	lgbDefaultLeft = 2
+
+	// the missing types of the bits 2 and 3
+	lgbMissingNone = 0
+	lgbMissingZero = 1
+)
+
+// ParseLightGBM imports the LightGBM text model saved by save_model, of the
+// binary or regression objective. The features are the columns of the
+// sample vectors in order, as the model was trained on the ExportSamples.
+func ParseLightGBM(r io.Reader) (*Model, error) {
+	var (
+		m       = &Model{Objective: Regression}
+		scanner = bufio.NewScanner(r)
+		header  = make(map[string]string)
+		tree    map[string]string
+		trees   []map[string]string
+	)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "end of trees" {
+			break
+		}
+		if strings.HasPrefix(line, "Tree=") {
+			tree = make(map[string]string)
+			trees = append(trees, tree)
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if tree != nil {
+			tree[kv[0]] = kv[1]
+		} else {
+			header[kv[0]] = kv[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if header["version"] == "" {
+		return nil, fmt.Errorf("not a lightgbm text model")
+	}
+	if n := header["num_class"]; n != "" && n != "1" {
+		return nil, fmt.Errorf("lightgbm num_class %s is not supported", n)
+	}
+	maxFeature, err := strconv.Atoi(header["max_feature_idx"])
+	if err != nil {
+		return nil, fmt.Errorf("lightgbm max_feature_idx: %v", err)
+	}
+	m.Features = maxFeature + 1
+
+	// e.g. "binary sigmoid:1" or "regression"
+	sigmoid := 1.0
+	objective := strings.Fields(header["objective"])
+	if len(objective) != 0 {
+		switch objective[0] {
+		case "binary", "cross_entropy", "xentropy":
+			m.Objective = Binary
+		case "regression", "regression_l2", "l2", "mean_squared_error", "mse":
+		default:
+			return nil, fmt.Errorf("lightgbm objective %s is not supported", objective[0])
+		}
+		for _, param := range objective[1:] {
+			if strings.HasPrefix(param, "sigmoid:") {
+				if sigmoid, err = strconv.ParseFloat(strings.TrimPrefix(param, "sigmoid:"), 64); err != nil {
+					return nil, fmt.Errorf("lightgbm objective %s: %v", header["objective"], err)
+				}
+			}
+		}
+	}
+	for i, t := range trees {
+		parsed, err := parseLightGBMTree(t, sigmoid)
+		if err != nil {
+			return nil, fmt.Errorf("lightgbm tree %d: %v", i, err)
+		}
+		m.Trees = append(m.Trees, parsed)
+	}
+	if err = m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseLightGBMTree converts the tree of the split arrays to the nodes, the
+// leaves are scaled by the sigmoid of the binary objective
+func parseLightGBMTree(t map[string]string, sigmoid float64) (tree Tree, err error) {
+	leaves, err := strconv.Atoi(t["num_leaves"])
+	if err != nil {
+		return tree, fmt.Errorf("num_leaves: %v", err)
+	}
+	leafValues, err := parseFloats(t["leaf_value"])
+	if err != nil || len(leafValues) != leaves {
+		return tree, fmt.Errorf("leaf_value of %d leaves: %v", leaves, err)
+	}
+	if leaves == 1 {
+		tree.Nodes = []Node{{Leaf: true, Value: float32(leafValues[0] * sigmoid)}}
+		return
+	}
+	var (
+		splits         = leaves - 1
+		features, errF = parseInts(t["split_feature"])
+		thresholds, eT = parseFloats(t["threshold"])
+		decisions, eD  = parseInts(t["decision_type"])
+		lefts, eL      = parseInts(t["left_child"])
+		rights, eR     = parseInts(t["right_child"])
+		// the categorical splits of the bitsets by the threshold index
+		boundaries, eB = parseInts(t["cat_boundaries"])
+		bitsets, eC    = parseInts(t["cat_threshold"])
+	)
+	for _, e := range []error{errF, eT, eD, eL, eR, eB, eC} {
+		if e != nil {
+			return tree, e
+		}
+	}
+	for _, n := range []int{len(features), len(thresholds), len(decisions), len(lefts), len(rights)} {
+		if n != splits {
+			return tree, fmt.Errorf("%d splits of %d leaves", n, leaves)
+		}
+	}
+
+	// the splits are by index, the leaves by the complement
+	return layout(0, 2*leaves, func(i int) (n Node, left, right int, err error) {
+		if i < 0 {
+			if ^i >= leaves {
+				return n, 0, 0, fmt.Errorf("leaf %d out of %d leaves", ^i, leaves)
+			}
+			return Node{Leaf: true, Value: float32(leafValues[^i] * sigmoid)}, 0, 0, nil
+		}
+		if i >= splits {
+			return n, 0, 0, fmt.Errorf("split %d out of %d splits", i, splits)
+		}
+		decision := decisions[i]
+		n = Node{Feature: features[i], DefaultLeft: decision&lgbDefaultLeft != 0}
+		if decision&lgbCategorical != 0 {
+			c := int(thresholds[i])
+			if c < 0 || c+1 >= len(boundaries) || boundaries[c] > boundaries[c+1] || boundaries[c+1] > len(bitsets) {
+				return n, 0, 0, fmt.Errorf("categorical split %d of invalid bitset %d", i, c)
+			}
+			n.Categories = make([]uint32, 0, boundaries[c+1]-boundaries[c])
+			for _, word := range bitsets[boundaries[c]:boundaries[c+1]] {
+				n.Categories = append(n.Categories, uint32(word))
+			}
+			return n, lefts[i], rights[i], nil
+		}
+		n.Threshold = float32(thresholds[i])
+		switch (decision >> 2) & 3 {
+		case lgbMissingNone:
+			// the missing values are zeros
+			n.DefaultLeft = 0 <= n.Threshold
+		case lgbMissingZero:
+			n.MissingZero = true
+		}
+		return n, lefts[i], rights[i], nil
+	})
+}
+
+func parseFloats(s string) ([]float64, error) {
+	fields := strings.Fields(s)
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func parseInts(s string) ([]int, error) {
+	fields := strings.Fields(s)
+	values := make([]int, len(fields))
+	for i, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
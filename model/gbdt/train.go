@@ -0,0 +1,266 @@
+package gbdt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultTrees        = 100
+	DefaultMaxDepth     = 6
+	DefaultLearningRate = 0.1
+	// DefaultMinLeafSamples is the min samples of a leaf
+	DefaultMinLeafSamples = 20
+	// DefaultBins is the histogram bins of a feature, at most 256
+	DefaultBins = 64
+	// DefaultLambda is the L2 regularization of the leaf values
+	DefaultLambda = 1.0
+
+	minHessian = 1e-6
+)
+
+// Options of Fitter, the zero values are the defaults
+type Options struct {
+	// Objective is Binary by default
+	Objective      string  `json:"objective" yaml:"objective"`
+	Trees          int     `json:"trees" yaml:"trees"`
+	MaxDepth       int     `json:"maxDepth" yaml:"maxDepth"`
+	LearningRate   float64 `json:"learningRate" yaml:"learningRate"`
+	MinLeafSamples int     `json:"minLeafSamples" yaml:"minLeafSamples"`
+	Bins           int     `json:"bins" yaml:"bins"`
+	Lambda         float64 `json:"lambda" yaml:"lambda"`
+}
+
+func (o *Options) defaults() error {
+	if o.Objective == "" {
+		o.Objective = Binary
+	}
+	if o.Objective != Binary && o.Objective != Regression {
+		return fmt.Errorf("unknown gbdt objective %q", o.Objective)
+	}
+	if o.Trees <= 0 {
+		o.Trees = DefaultTrees
+	}
+	if o.MaxDepth <= 0 {
+		o.MaxDepth = DefaultMaxDepth
+	}
+	if o.LearningRate <= 0 {
+		o.LearningRate = DefaultLearningRate
+	}
+	if o.MinLeafSamples <= 0 {
+		o.MinLeafSamples = DefaultMinLeafSamples
+	}
+	if o.Bins <= 0 {
+		o.Bins = DefaultBins
+	}
+	if o.Bins < 2 || o.Bins > 256 {
+		return fmt.Errorf("gbdt bins %d should be in [2, 256]", o.Bins)
+	}
+	if o.Lambda <= 0 {
+		o.Lambda = DefaultLambda
+	}
+	return nil
+}
+
+// Fitter is the rcmd.Fitter training the Model, a drop-in of the neural
+// models on the same sample vectors
+type Fitter struct {
+	Options Options
+}
+
+func (f *Fitter) Fit(trainSample *rcmd.TrainSample) (rcmd.PredictAbstract, error) {
+	return Train(trainSample.X, trainSample.Y, trainSample.Rows, trainSample.XCols, f.Options)
+}
+
+// trainer keeps the binned samples and the gradients of the boosting
+type trainer struct {
+	opts  Options
+	rows  int
+	cols  int
+	cuts  [][]float32
+	bins  []uint8 // rows * cols, the bin of every value
+	grad  []float64
+	hess  []float64
+	nodes []Node
+}
+
+// Train boosts the trees of the rows of x by cols columns and the labels y by
+// the histogram based Newton boosting
+func Train(x, y []float32, rows, cols int, opts Options) (*Model, error) {
+	if err := opts.defaults(); err != nil {
+		return nil, err
+	}
+	if rows <= 0 || cols <= 0 || len(x) < rows*cols || len(y) < rows {
+		return nil, fmt.Errorf("gbdt samples of %d rows by %d cols, %d values and %d labels", rows, cols, len(x), len(y))
+	}
+	t := &trainer{opts: opts, rows: rows, cols: cols}
+	t.binning(x)
+
+	m := &Model{Objective: opts.Objective, Features: cols}
+	var mean float64
+	for _, label := range y[:rows] {
+		mean += float64(label)
+	}
+	mean /= float64(rows)
+	if opts.Objective == Binary {
+		mean = math.Max(1e-6, math.Min(1-1e-6, mean))
+		m.BaseScore = math.Log(mean / (1 - mean))
+	} else {
+		m.BaseScore = mean
+	}
+
+	raw := make([]float64, rows)
+	for i := range raw {
+		raw[i] = m.BaseScore
+	}
+	t.grad = make([]float64, rows)
+	t.hess = make([]float64, rows)
+	indices := make([]int, rows)
+	leaves := make([]int, rows)
+	for k := 0; k < opts.Trees; k++ {
+		for i := 0; i < rows; i++ {
+			if opts.Objective == Binary {
+				p := 1 / (1 + math.Exp(-raw[i]))
+				t.grad[i] = p - float64(y[i])
+				t.hess[i] = math.Max(p*(1-p), minHessian)
+			} else {
+				t.grad[i] = raw[i] - float64(y[i])
+				t.hess[i] = 1
+			}
+			indices[i] = i
+		}
+		t.nodes = nil
+		t.build(indices, 0, leaves)
+		tree := Tree{Nodes: t.nodes}
+		for i := 0; i < rows; i++ {
+			raw[i] += float64(tree.Nodes[leaves[i]].Value)
+		}
+		m.Trees = append(m.Trees, tree)
+	}
+	log.Infof("gbdt trained %d trees of %d samples", len(m.Trees), rows)
+	return m, nil
+}
+
+// binning cuts every feature by the quantiles of its values
+func (t *trainer) binning(x []float32) {
+	t.cuts = make([][]float32, t.cols)
+	t.bins = make([]uint8, t.rows*t.cols)
+	values := make([]float32, t.rows)
+	for j := 0; j < t.cols; j++ {
+		for i := 0; i < t.rows; i++ {
+			values[i] = x[i*t.cols+j]
+		}
+		sort.Slice(values, func(a, b int) bool { return values[a] < values[b] })
+		var cuts []float32
+		for b := 1; b < t.opts.Bins; b++ {
+			v := values[b*(t.rows-1)/t.opts.Bins]
+			if len(cuts) == 0 || v > cuts[len(cuts)-1] {
+				cuts = append(cuts, v)
+			}
+		}
+		// the max value cuts nothing
+		if len(cuts) != 0 && cuts[len(cuts)-1] >= values[t.rows-1] {
+			cuts = cuts[:len(cuts)-1]
+		}
+		t.cuts[j] = cuts
+		for i := 0; i < t.rows; i++ {
+			v := x[i*t.cols+j]
+			t.bins[i*t.cols+j] = uint8(sort.Search(len(cuts), func(b int) bool { return v <= cuts[b] }))
+		}
+	}
+}
+
+type split struct {
+	gain    float64
+	feature int
+	bin     int
+}
+
+// build grows the node of the samples indices and returns its index, the
+// leaf of every sample is set in leaves
+func (t *trainer) build(indices []int, depth int, leaves []int) int {
+	var g, h float64
+	for _, i := range indices {
+		g += t.grad[i]
+		h += t.hess[i]
+	}
+	id := len(t.nodes)
+	t.nodes = append(t.nodes, Node{})
+	best := split{feature: -1}
+	if depth < t.opts.MaxDepth && len(indices) >= 2*t.opts.MinLeafSamples {
+		best = t.bestSplit(indices, g, h)
+	}
+	if best.feature < 0 {
+		t.nodes[id] = Node{Leaf: true, Value: float32(-g / (h + t.opts.Lambda) * t.opts.LearningRate)}
+		for _, i := range indices {
+			leaves[i] = id
+		}
+		return id
+	}
+
+	// partition in place, the left samples first
+	l := 0
+	for r := range indices {
+		if int(t.bins[indices[r]*t.cols+best.feature]) <= best.bin {
+			indices[l], indices[r] = indices[r], indices[l]
+			l++
+		}
+	}
+	left := t.build(indices[:l], depth+1, leaves)
+	right := t.build(indices[l:], depth+1, leaves)
+	t.nodes[id] = Node{
+		Feature:   best.feature,
+		Threshold: t.cuts[best.feature][best.bin],
+		Left:      left,
+		Right:     right,
+	}
+	return id
+}
+
+// bestSplit returns the split of the max gain, feature -1 if none gains
+func (t *trainer) bestSplit(indices []int, g, h float64) split {
+	var (
+		lambda = t.opts.Lambda
+		parent = g * g / (h + lambda)
+		best   = split{feature: -1}
+		gs     = make([]float64, t.opts.Bins)
+		hs     = make([]float64, t.opts.Bins)
+		cnt    = make([]int, t.opts.Bins)
+	)
+	for j := 0; j < t.cols; j++ {
+		bins := len(t.cuts[j]) + 1
+		if bins < 2 {
+			continue
+		}
+		for b := 0; b < bins; b++ {
+			gs[b], hs[b], cnt[b] = 0, 0, 0
+		}
+		for _, i := range indices {
+			b := t.bins[i*t.cols+j]
+			gs[b] += t.grad[i]
+			hs[b] += t.hess[i]
+			cnt[b]++
+		}
+		var gl, hl float64
+		nl := 0
+		for b := 0; b < bins-1; b++ {
+			gl, hl, nl = gl+gs[b], hl+hs[b], nl+cnt[b]
+			if nl < t.opts.MinLeafSamples {
+				continue
+			}
+			if len(indices)-nl < t.opts.MinLeafSamples {
+				break
+			}
+			gr, hr := g-gl, h-hl
+			gain := gl*gl/(hl+lambda) + gr*gr/(hr+lambda) - parent
+			if gain > best.gain {
+				best = split{gain: gain, feature: j, bin: b}
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,247 @@
+package gbdt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// xgbModel is the part of the XGBoost json model evaluated
+type xgbModel struct {
+	Learner struct {
+		GradientBooster struct {
+			Name  string `json:"name"`
+			Model struct {
+				Trees []xgbTree `json:"trees"`
+			} `json:"model"`
+		} `json:"gradient_booster"`
+		LearnerModelParam struct {
+			BaseScore  string `json:"base_score"`
+			NumClass   string `json:"num_class"`
+			NumFeature string `json:"num_feature"`
+		} `json:"learner_model_param"`
+		Objective struct {
+			Name string `json:"name"`
+		} `json:"objective"`
+	} `json:"learner"`
+}
+
+type xgbTree struct {
+	LeftChildren    []int     `json:"left_children"`
+	RightChildren   []int     `json:"right_children"`
+	SplitIndices    []int     `json:"split_indices"`
+	SplitConditions []float64 `json:"split_conditions"`
+	DefaultLeft     xgbFlags  `json:"default_left"`
+	SplitType       []int     `json:"split_type"`
+}
+
+// xgbFlags is the flags of 0 and 1 or of booleans, by the XGBoost version
+type xgbFlags []bool
+
+func (f *xgbFlags) UnmarshalJSON(data []byte) error {
+	var values []interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*f = make(xgbFlags, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case bool:
+			(*f)[i] = v
+		case float64:
+			(*f)[i] = v != 0
+		default:
+			return fmt.Errorf("invalid flag %v", v)
+		}
+	}
+	return nil
+}
+
+// xgbObjective returns the Objective and the raw base score of the XGBoost
+// objective, of which the base_score is of the probabilities if logistic
+func xgbObjective(objective string, baseScore float64) (string, float64, error) {
+	switch objective {
+	case "binary:logistic", "reg:logistic":
+		if baseScore <= 0 || baseScore >= 1 {
+			return "", 0, fmt.Errorf("xgboost base_score %v of %s should be in (0, 1)", baseScore, objective)
+		}
+		return Binary, math.Log(baseScore / (1 - baseScore)), nil
+	case "reg:squarederror", "reg:linear":
+		return Regression, baseScore, nil
+	}
+	return "", 0, fmt.Errorf("xgboost objective %q is not supported", objective)
+}
+
+// xgbThreshold converts the XGBoost split x < cond to x <= the threshold
+func xgbThreshold(cond float64) float32 {
+	return math.Nextafter32(float32(cond), float32(math.Inf(-1)))
+}
+
+// ParseXGBoost imports the XGBoost json model saved by save_model, of the
+// gbtree booster, the binary:logistic or reg:squarederror objective and the
+// numerical splits. The features are the columns of the sample vectors in
+// order, see ExportSamples.
+func ParseXGBoost(r io.Reader) (*Model, error) {
+	var x xgbModel
+	if err := json.NewDecoder(r).Decode(&x); err != nil {
+		return nil, fmt.Errorf("parse xgboost model: %v", err)
+	}
+	l := &x.Learner
+	if name := l.GradientBooster.Name; name != "gbtree" {
+		return nil, fmt.Errorf("xgboost booster %q is not supported", name)
+	}
+	if n := l.LearnerModelParam.NumClass; n != "" && n != "0" && n != "1" {
+		return nil, fmt.Errorf("xgboost num_class %s is not supported", n)
+	}
+	features, err := strconv.Atoi(l.LearnerModelParam.NumFeature)
+	if err != nil {
+		return nil, fmt.Errorf("xgboost num_feature: %v", err)
+	}
+	// e.g. "5E-1" or "[5E-1]" of the newer versions
+	baseScore, err := strconv.ParseFloat(strings.Trim(l.LearnerModelParam.BaseScore, "[]"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("xgboost base_score: %v", err)
+	}
+	m := &Model{Features: features}
+	if m.Objective, m.BaseScore, err = xgbObjective(l.Objective.Name, baseScore); err != nil {
+		return nil, err
+	}
+	for i, t := range l.GradientBooster.Model.Trees {
+		tree, err := parseXGBoostTree(&t)
+		if err != nil {
+			return nil, fmt.Errorf("xgboost tree %d: %v", i, err)
+		}
+		m.Trees = append(m.Trees, tree)
+	}
+	if err = m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseXGBoostTree(t *xgbTree) (Tree, error) {
+	nodes := len(t.LeftChildren)
+	for _, n := range []int{len(t.RightChildren), len(t.SplitIndices), len(t.SplitConditions), len(t.DefaultLeft)} {
+		if n != nodes {
+			return Tree{}, fmt.Errorf("%d arrays of %d nodes", n, nodes)
+		}
+	}
+	if nodes == 0 {
+		return Tree{}, fmt.Errorf("no node")
+	}
+	return layout(0, nodes, func(i int) (n Node, left, right int, err error) {
+		if i < 0 || i >= nodes {
+			return n, 0, 0, fmt.Errorf("node %d out of %d nodes", i, nodes)
+		}
+		// the leaf values are in the split conditions
+		if t.LeftChildren[i] == -1 {
+			return Node{Leaf: true, Value: float32(t.SplitConditions[i])}, 0, 0, nil
+		}
+		if i < len(t.SplitType) && t.SplitType[i] != 0 {
+			return n, 0, 0, fmt.Errorf("categorical split %d is not supported", i)
+		}
+		return Node{
+			Feature:     t.SplitIndices[i],
+			Threshold:   xgbThreshold(t.SplitConditions[i]),
+			DefaultLeft: t.DefaultLeft[i],
+		}, t.LeftChildren[i], t.RightChildren[i], nil
+	})
+}
+
+var (
+	xgbDumpSplit = regexp.MustCompile(`^(\d+):\[f(\d+)<([^\]]+)\] yes=(\d+),no=(\d+),missing=(\d+)`)
+	xgbDumpLeaf  = regexp.MustCompile(`^(\d+):leaf=([^,\s]+)`)
+)
+
+// xgbDumpNode is a node of the text dump by id
+type xgbDumpNode struct {
+	leaf                 bool
+	value                float64
+	feature              int
+	yes, no, missing, id int
+}
+
+// ParseXGBoostDump imports the XGBoost text dump of dump_model, which has
+// neither the objective nor the base score, e.g. "binary:logistic" and 0.5.
+// The features are named "f<column>", so the model should be dumped without
+// the feature names.
+func ParseXGBoostDump(r io.Reader, objective string, baseScore float64) (*Model, error) {
+	m := &Model{}
+	var err error
+	if m.Objective, m.BaseScore, err = xgbObjective(objective, baseScore); err != nil {
+		return nil, err
+	}
+	var (
+		scanner = bufio.NewScanner(r)
+		trees   []map[int]*xgbDumpNode
+	)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "booster[") {
+			trees = append(trees, make(map[int]*xgbDumpNode))
+			continue
+		}
+		if len(trees) == 0 {
+			return nil, fmt.Errorf("xgboost dump line %q out of booster", line)
+		}
+		n := &xgbDumpNode{}
+		if s := xgbDumpSplit.FindStringSubmatch(line); s != nil {
+			n.id, _ = strconv.Atoi(s[1])
+			n.feature, _ = strconv.Atoi(s[2])
+			if n.value, err = strconv.ParseFloat(s[3], 64); err != nil {
+				return nil, fmt.Errorf("xgboost dump line %q: %v", line, err)
+			}
+			n.yes, _ = strconv.Atoi(s[4])
+			n.no, _ = strconv.Atoi(s[5])
+			n.missing, _ = strconv.Atoi(s[6])
+			if n.feature >= m.Features {
+				m.Features = n.feature + 1
+			}
+		} else if s = xgbDumpLeaf.FindStringSubmatch(line); s != nil {
+			n.id, _ = strconv.Atoi(s[1])
+			n.leaf = true
+			if n.value, err = strconv.ParseFloat(s[2], 64); err != nil {
+				return nil, fmt.Errorf("xgboost dump line %q: %v", line, err)
+			}
+		} else {
+			return nil, fmt.Errorf("xgboost dump line %q is not supported", line)
+		}
+		trees[len(trees)-1][n.id] = n
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, nodes := range trees {
+		tree, err := layout(0, len(nodes), func(id int) (node Node, left, right int, err error) {
+			n, ok := nodes[id]
+			if !ok {
+				return node, 0, 0, fmt.Errorf("node %d not found", id)
+			}
+			if n.leaf {
+				return Node{Leaf: true, Value: float32(n.value)}, 0, 0, nil
+			}
+			return Node{
+				Feature:     n.feature,
+				Threshold:   xgbThreshold(n.value),
+				DefaultLeft: n.missing == n.yes,
+			}, n.yes, n.no, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("xgboost tree %d: %v", i, err)
+		}
+		m.Trees = append(m.Trees, tree)
+	}
+	if err = m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,135 @@
+package model_test
+
+import (
+	"encoding/json"
+	"flag"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/bst"
+	"github.com/auxten/go-ctr/model/din"
+	"github.com/auxten/go-ctr/model/pnn"
+	"github.com/auxten/go-ctr/model/youtube"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// updateGolden rewrites the golden outputs of the models, after a change of
+// the outputs on purpose:
+//
+//	go test ./model -run TestGoldenOutputs -update
+var updateGolden = flag.Bool("update", false, "rewrite the golden outputs in testdata/golden")
+
+const (
+	// goldenRows of the inputs are forwarded by goldenBatchSize, so the
+	// rows of a batch are checked apart
+	goldenRows      = 8
+	goldenBatchSize = 4
+	// goldenTolerance is the float32 error of the refactors, e.g. of the
+	// operations reordered
+	goldenTolerance = 1e-5
+	goldenSeed      = 20220301
+)
+
+// goldenOutputs is the file of the outputs of a model forwarded on the
+// inputs of goldenSeed
+type goldenOutputs struct {
+	Rows    int       `json:"rows"`
+	Outputs []float32 `json:"outputs"`
+}
+
+// goldenCases are the models of the golden files by name, of the small test
+// dims
+var goldenCases = []struct {
+	name string
+	new  func() model.Model
+}{
+	{"youtube", func() model.Model {
+		return youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+	}},
+	{"din", func() model.Model {
+		return din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+	}},
+	{"din_latent_cross", func() model.Model {
+		return din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, din.WithLatentCross())
+	}},
+	{"pnn_inner", func() model.Model {
+		return pnn.NewPnnNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			pnn.WithProductType(pnn.InnerProduct), pnn.WithFieldDim(4))
+	}},
+	{"pnn_outer", func() model.Model {
+		return pnn.NewPnnNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			pnn.WithProductType(pnn.OuterProduct), pnn.WithFieldDim(4))
+	}},
+	{"bst", func() model.Model {
+		return bst.NewBstNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			bst.WithHeads(1), bst.WithBlocks(2))
+	}},
+}
+
+// fillGoldenWeights sets the learnable weights of m of the rng, so the
+// outputs don't depend on the initializers of the graph. The weights are
+// larger than the initialized ones, so the outputs of the rows spread off
+// 0.5 and the errors of Fwd are not squashed by the sigmoid.
+func fillGoldenWeights(m model.Model, rng *rand.Rand) {
+	for _, n := range m.Learnable() {
+		scale := 3 / math.Sqrt(float64(n.Shape()[0]))
+		data := n.Value().Data().([]float32)
+		for i := range data {
+			data[i] = float32((2*rng.Float64() - 1) * scale)
+		}
+	}
+}
+
+// forwardGolden returns the outputs of the model forwarded on the inputs of
+// goldenSeed
+func forwardGolden(newModel func() model.Model) (outputs []float32, err error) {
+	rng := rand.New(rand.NewSource(goldenSeed))
+	sampleInfo, _, _ := newSmallSample(0)
+	x := make([]float32, goldenRows*tInputWidth)
+	for i := range x {
+		x[i] = rng.Float32()
+	}
+	m := newModel()
+	fillGoldenWeights(m, rng)
+	if err = model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+		goldenBatchSize, m); err != nil {
+		return
+	}
+	inputs := tensor.New(tensor.WithShape(goldenRows, tInputWidth), tensor.WithBacking(x))
+	return model.Predict(m, goldenRows, goldenBatchSize, sampleInfo, inputs)
+}
+
+// TestGoldenOutputs checks the outputs of the models of the fixed weights
+// and inputs against the golden files, so the refactors of Fwd are verified
+// numerically
+func TestGoldenOutputs(t *testing.T) {
+	for _, c := range goldenCases {
+		c := c
+		path := filepath.Join("testdata", "golden", c.name+".json")
+		Convey("golden outputs of "+c.name, t, func() {
+			outputs, err := forwardGolden(c.new)
+			So(err, ShouldBeNil)
+			So(outputs, ShouldHaveLength, goldenRows)
+			if *updateGolden {
+				data, err := json.MarshalIndent(goldenOutputs{Rows: goldenRows, Outputs: outputs}, "", "  ")
+				So(err, ShouldBeNil)
+				So(os.WriteFile(path, append(data, '\n'), 0644), ShouldBeNil)
+				return
+			}
+			data, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			var golden goldenOutputs
+			So(json.Unmarshal(data, &golden), ShouldBeNil)
+			So(golden.Rows, ShouldEqual, goldenRows)
+			So(golden.Outputs, ShouldHaveLength, len(outputs))
+			for i, y := range outputs {
+				So(y, ShouldAlmostEqual, golden.Outputs[i], goldenTolerance)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+const (
+	// DefaultGradCheckEpsilon is the step of the central differences, large
+	// for the float32 cost
+	DefaultGradCheckEpsilon = 1e-2
+	// DefaultGradCheckTolerance is the max relative error of the gradients
+	DefaultGradCheckTolerance = 5e-2
+	// DefaultGradCheckFloor is the min scale of the relative error, so the
+	// near zero gradients are compared absolutely
+	DefaultGradCheckFloor = 1e-3
+	// DefaultGradCheckSamples is the elements checked of a learnable node
+	DefaultGradCheckSamples = 8
+)
+
+// GradCheckConfig configures CheckGradients, the zero values are the
+// defaults
+type GradCheckConfig struct {
+	Epsilon   float64
+	Tolerance float64
+	Floor     float64
+	// Samples is the random elements checked of every learnable node, all of
+	// the nodes of fewer elements
+	Samples int
+	// Seed of the elements sampled
+	Seed int64
+}
+
+// GradCheck is the gradient of an element of a learnable node
+type GradCheck struct {
+	Node  string `json:"node"`
+	Index int    `json:"index"`
+	// Analytic is of the graph, Numeric of the central differences of the
+	// cost
+	Analytic float64 `json:"analytic"`
+	Numeric  float64 `json:"numeric"`
+	// RelErr is |Analytic - Numeric| / max(|Analytic| + |Numeric|, Floor)
+	RelErr float64 `json:"relErr"`
+}
+
+// GradCheckReport is the gradients checked by CheckGradients
+type GradCheckReport struct {
+	Checked int `json:"checked"`
+	// Failed are the gradients of RelErr over the tolerance
+	Failed []GradCheck `json:"failed,omitempty"`
+	// Worst is the gradient of the max RelErr
+	Worst GradCheck `json:"worst"`
+}
+
+// CheckGradients compares the gradients of the cost of m by the graph with
+// the central differences of the cost, on the first batchSize samples of
+// inputs and targets, to validate the hand-wired graphs of the new layers,
+// e.g. the attention. opts set the objective and the regularizations as of
+// Train. The dropout is left out of the graph so the cost is deterministic,
+// and the elements at the kinks of ReLU could fail by the differences
+// crossing them.
+//
+// m is bound to the graph of the check, a new Model should be trained.
+func CheckGradients(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int,
+	batchSize int,
+	si *rcmd.SampleInfo,
+	inputs, targets tensor.Tensor,
+	m Model,
+	conf GradCheckConfig,
+	opts ...TrainOption,
+) (report *GradCheckReport, err error) {
+	if conf.Epsilon <= 0 {
+		conf.Epsilon = DefaultGradCheckEpsilon
+	}
+	if conf.Tolerance <= 0 {
+		conf.Tolerance = DefaultGradCheckTolerance
+	}
+	if conf.Floor <= 0 {
+		conf.Floor = DefaultGradCheckFloor
+	}
+	if conf.Samples <= 0 {
+		conf.Samples = DefaultGradCheckSamples
+	}
+	var trainOpts TrainOpts
+	for _, opt := range opts {
+		opt(&trainOpts)
+	}
+	if err = ValidateShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim,
+		batchSize, batchSize, si, inputs, targets, m); err != nil {
+		return
+	}
+	if err = checkObjective(trainOpts.Objective, trainOpts.Classes, m); err != nil {
+		return
+	}
+	if targets, err = objectiveTargets(&trainOpts, targets, batchSize); err != nil {
+		return
+	}
+	r, err := newReplica(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim,
+		batchSize, trainOpts.Objective.Outputs(trainOpts.Classes), m, false, &trainOpts)
+	if err != nil {
+		return
+	}
+	if err = r.prepare(si, inputs, targets); err != nil {
+		return
+	}
+	cost := func() (float64, error) {
+		defer r.vm.Reset()
+		// the batch is let by every run, the backward pass could write over
+		// the inputs reshaped
+		if err := r.let(0, batchSize); err != nil {
+			return 0, err
+		}
+		if err := r.vm.RunAll(); err != nil {
+			return 0, err
+		}
+		return float64(r.cost.Value().Data().(float32)), nil
+	}
+
+	// the analytic gradients of the weights unchanged
+	if _, err = cost(); err != nil {
+		return
+	}
+	var (
+		nodes = m.Learnable()
+		grads = make([][]float32, len(nodes))
+	)
+	for i, n := range nodes {
+		var grad G.Value
+		if grad, err = n.Grad(); err != nil {
+			return nil, fmt.Errorf("gradient of %s: %v", n.Name(), err)
+		}
+		grads[i] = append([]float32(nil), grad.Data().([]float32)...)
+	}
+
+	rng := rand.New(rand.NewSource(conf.Seed))
+	report = &GradCheckReport{}
+	for i, n := range nodes {
+		data := n.Value().Data().([]float32)
+		indexes := rng.Perm(len(data))
+		if len(indexes) > conf.Samples {
+			indexes = indexes[:conf.Samples]
+		}
+		for _, j := range indexes {
+			var (
+				w = data[j]
+				// the steps rounded to float32
+				hi, lo      = w + float32(conf.Epsilon), w - float32(conf.Epsilon)
+				plus, minus float64
+			)
+			data[j] = hi
+			if plus, err = cost(); err == nil {
+				data[j] = lo
+				minus, err = cost()
+			}
+			data[j] = w
+			if err != nil {
+				return nil, fmt.Errorf("cost of %s[%d]: %v", n.Name(), j, err)
+			}
+			c := GradCheck{
+				Node:     n.Name(),
+				Index:    j,
+				Analytic: float64(grads[i][j]),
+				Numeric:  (plus - minus) / float64(hi-lo),
+			}
+			c.RelErr = math.Abs(c.Analytic-c.Numeric) / math.Max(math.Abs(c.Analytic)+math.Abs(c.Numeric), conf.Floor)
+			report.Checked++
+			if c.RelErr > conf.Tolerance {
+				report.Failed = append(report.Failed, c)
+			}
+			if report.Checked == 1 || c.RelErr > report.Worst.RelErr {
+				report.Worst = c
+			}
+		}
+	}
+	return
+}
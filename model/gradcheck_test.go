@@ -0,0 +1,75 @@
+package model_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/bst"
+	"github.com/auxten/go-ctr/model/din"
+	"github.com/auxten/go-ctr/model/pnn"
+	"github.com/auxten/go-ctr/model/youtube"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckGradients(t *testing.T) {
+	const batchSize = 4
+	for _, c := range []struct {
+		name string
+		m    func() model.Model
+		conf model.GradCheckConfig
+		opts []model.TrainOption
+	}{
+		{"youtube", func() model.Model {
+			return youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		}, model.GradCheckConfig{}, nil},
+		{"din", func() model.Model {
+			return din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		}, model.GradCheckConfig{}, nil},
+		{"pnn outer", func() model.Model {
+			return pnn.NewPnnNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+				pnn.WithProductType(pnn.OuterProduct), pnn.WithFieldDim(4))
+		}, model.GradCheckConfig{}, nil},
+		// the attention layers of the regression head, the small gradients
+		// behind the ReLU of the feed forward are compared absolutely
+		{"bst", func() model.Model {
+			return bst.NewBstNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+				bst.WithHeads(1), bst.WithBlocks(1))
+		}, model.GradCheckConfig{Floor: 1e-2},
+			[]model.TrainOption{model.WithObjective(model.MSEObjective)}},
+	} {
+		c := c
+		Convey("gradients of "+c.name, t, func() {
+			rand.Seed(42)
+			sampleInfo, inputs, labels := newSmallSample(batchSize)
+			// the weights of the gorgonia initializers are not of the seed
+			m := c.m()
+			fillGoldenWeights(m, rand.New(rand.NewSource(goldenSeed)))
+			report, err := model.CheckGradients(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+				batchSize, sampleInfo, inputs, labels, m, c.conf, c.opts...)
+			So(err, ShouldBeNil)
+			So(report.Checked, ShouldBeGreaterThan, len(m.Learnable()))
+			So(report.Failed, ShouldBeEmpty)
+			So(report.Worst.RelErr, ShouldBeLessThanOrEqualTo, model.DefaultGradCheckTolerance)
+		})
+	}
+
+	Convey("the wrong gradients fail", t, func() {
+		rand.Seed(42)
+		sampleInfo, inputs, labels := newSmallSample(batchSize)
+		// the steps too large for the curvature of the cost
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		fillGoldenWeights(m, rand.New(rand.NewSource(goldenSeed)))
+		report, err := model.CheckGradients(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			batchSize, sampleInfo, inputs, labels, m, model.GradCheckConfig{Epsilon: 50, Tolerance: 1e-3})
+		So(err, ShouldBeNil)
+		So(report.Failed, ShouldNotBeEmpty)
+		So(report.Worst.RelErr, ShouldBeGreaterThan, 1e-3)
+
+		_, err = model.CheckGradients(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim+1,
+			batchSize, sampleInfo, inputs, labels,
+			youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim+1),
+			model.GradCheckConfig{})
+		So(err, ShouldHaveSameTypeAs, &model.ShapeError{})
+	})
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// GraphFormat is the file format of ExportGraph
+type GraphFormat string
+
+const (
+	// GraphDOT is the graphviz dot source
+	GraphDOT GraphFormat = "dot"
+	// GraphSVG is rendered by the graphviz `dot` command, which should be
+	// found in $PATH
+	GraphSVG GraphFormat = "svg"
+)
+
+// ExportGraph writes the expression graph of m to path, every node is
+// annotated with its op and shape. It is typically called after Train or
+// InitForwardOnlyVm to debug the shape mismatches of a new model.
+func ExportGraph(m Model, path string, format GraphFormat) (err error) {
+	dot := m.Graph().ToDot()
+	switch format {
+	case GraphDOT:
+		return os.WriteFile(path, []byte(dot), 0644)
+	case GraphSVG:
+		var dotBin string
+		if dotBin, err = exec.LookPath("dot"); err != nil {
+			return fmt.Errorf("graphviz is needed to render svg: %v", err)
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command(dotBin, "-Tsvg", "-o", path)
+		cmd.Stdin = strings.NewReader(dot)
+		cmd.Stderr = &stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("render svg: %v, %s", err, stderr.String())
+		}
+		return
+	default:
+		return fmt.Errorf("unknown graph format %s", format)
+	}
+}
@@ -0,0 +1,233 @@
+package layers
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/auxten/go-ctr/model"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// maskedScore is added to the masked attention scores before softmax, it is
+// small enough to make exp() of them 0 but keeps away from float32 -Inf.
+const maskedScore = -1e9
+
+// SequenceMask returns 1 for the positions of a zero padded sequence which
+// are not padding, 0 for the padding ones.
+// x.Shape: [batchSize, seqLen, dim]
+// output shape: [batchSize, seqLen]
+func SequenceMask(x *G.Node) (retVal *G.Node, err error) {
+	if x.Dims() != 3 {
+		err = fmt.Errorf("x shape not supported: %v", x.Shape())
+		return
+	}
+	var absSum *G.Node
+	if absSum, err = G.Sum(G.Must(G.Abs(x)), 2); err != nil {
+		return
+	}
+	return G.Gt(absSum, G.NewConstant(float32(0)), true)
+}
+
+// MaskedSoftmax is the softmax along the last axis of x, positions where the
+// mask is 0 get 0 probability. If all the positions of a row are masked, the
+// row will be uniformly distributed rather than NaN.
+// x.Shape: [batchSize, m, seqLen]
+// mask.Shape: [batchSize, seqLen], nil means no mask
+// output shape: [batchSize, m, seqLen]
+func MaskedSoftmax(x, mask *G.Node) (retVal *G.Node, err error) {
+	if x.Dims() != 3 {
+		err = fmt.Errorf("x shape not supported: %v", x.Shape())
+		return
+	}
+	var (
+		batchSize = x.Shape()[0]
+		m         = x.Shape()[1]
+		seqLen    = x.Shape()[2]
+	)
+	if mask != nil {
+		if !mask.Shape().Eq(tensor.Shape{batchSize, seqLen}) {
+			err = fmt.Errorf("mask shape %v mismatch x shape %v", mask.Shape(), x.Shape())
+			return
+		}
+		//[batchSize, 1, seqLen], 0 for kept positions, maskedScore for the masked
+		penalty := G.Must(G.Mul(
+			G.Must(G.Sub(G.NewConstant(float32(1)), G.Must(G.Reshape(mask, tensor.Shape{batchSize, 1, seqLen})))),
+			G.NewConstant(float32(-maskedScore)),
+		))
+		x = G.Must(G.BroadcastSub(x, penalty, nil, []byte{1}))
+	}
+	// subtract the max for numerical stability
+	max := G.Must(G.Reshape(G.Must(G.Max(x, 2)), tensor.Shape{batchSize, m, 1}))
+	exp := G.Must(G.Exp(G.Must(G.BroadcastSub(x, max, nil, []byte{2}))))
+	sum := G.Must(G.Reshape(G.Must(G.Sum(exp, 2)), tensor.Shape{batchSize, m, 1}))
+	return G.BroadcastHadamardDiv(exp, sum, nil, []byte{2})
+}
+
+// ActivationUnit is the attention unit of DIN, user behaviors are weighted
+// by ActivationWeights then mean pooled into one embedding.
+// behaviors.Shape: [batchSize, seqLen, dim]
+// item.Shape: [batchSize, dim]
+// att.Shape: [1, seqLen]
+// output shape: [batchSize, dim]
+func ActivationUnit(behaviors, item, att *G.Node) (retVal *G.Node, err error) {
+	var weights *G.Node
+	if weights, err = ActivationWeights(behaviors, item, att); err != nil {
+		return
+	}
+	return ActivationPooling(behaviors, weights)
+}
+
+// ActivationWeights is the attention weights of ActivationUnit, the sigmoid
+// of the cosine similarity of the user behaviors with the target item scaled
+// by a learnable per position weight.
+// behaviors.Shape: [batchSize, seqLen, dim]
+// item.Shape: [batchSize, dim]
+// att.Shape: [1, seqLen]
+// output shape: [batchSize, seqLen]
+func ActivationWeights(behaviors, item, att *G.Node) (retVal *G.Node, err error) {
+	if behaviors.Dims() != 3 || item.Dims() != 2 {
+		err = fmt.Errorf("behaviors, item shapes not supported: %v, %v", behaviors.Shape(), item.Shape())
+		return
+	}
+	var (
+		batchSize = behaviors.Shape()[0]
+		dim       = behaviors.Shape()[2]
+	)
+	if !item.Shape().Eq(tensor.Shape{batchSize, dim}) {
+		err = fmt.Errorf("item shape %v mismatch behaviors shape %v", item.Shape(), behaviors.Shape())
+		return
+	}
+	var similarity *G.Node
+	if similarity, err = model.CosineSimilarity(behaviors, G.Must(G.Reshape(item, tensor.Shape{batchSize, 1, dim}))); err != nil {
+		return
+	}
+	// weight: [batchSize, seqLen], cosine similarity scaled to [0, 1]
+	weight := G.Must(G.Div(
+		G.Must(G.Add(similarity, G.NewConstant(float32(1.0)))),
+		G.NewConstant(float32(2.0)),
+	))
+	return G.Sigmoid(
+		//[batchSize, seqLen]
+		//	⊙
+		//[:		, seqLen]
+		G.Must(G.BroadcastHadamardProd(weight, att, nil, []byte{0})),
+	)
+}
+
+// ActivationPooling is the mean of the user behaviors weighted by the
+// attention weights of ActivationWeights.
+// behaviors.Shape: [batchSize, seqLen, dim]
+// weights.Shape: [batchSize, seqLen]
+// output shape: [batchSize, dim]
+func ActivationPooling(behaviors, weights *G.Node) (retVal *G.Node, err error) {
+	if behaviors.Dims() != 3 {
+		err = fmt.Errorf("behaviors shape not supported: %v", behaviors.Shape())
+		return
+	}
+	var (
+		batchSize = behaviors.Shape()[0]
+		seqLen    = behaviors.Shape()[1]
+	)
+	if !weights.Shape().Eq(tensor.Shape{batchSize, seqLen}) {
+		err = fmt.Errorf("weights shape %v mismatch behaviors shape %v", weights.Shape(), behaviors.Shape())
+		return
+	}
+	//actOuts.Shape() = [batchSize, seqLen, dim]
+	actOuts := G.Must(G.BroadcastHadamardProd(
+		behaviors,
+		//[batchSize, seqLen, 1]
+		G.Must(G.Reshape(weights, tensor.Shape{batchSize, seqLen, 1})),
+		nil, []byte{2},
+	))
+	return G.Mean(actOuts, 1)
+}
+
+// MultiHeadSelfAttention is the scaled dot-product self attention of the
+// Transformer with `heads` heads.
+// x.Shape: [batchSize, seqLen, dim]
+// mask.Shape: [batchSize, seqLen], nil means no mask
+// wq, wk, wv.Shape: [dim, heads * headDim]
+// wo.Shape: [heads * headDim, dim]
+// output shape: [batchSize, seqLen, dim]
+func MultiHeadSelfAttention(x, mask, wq, wk, wv, wo *G.Node, heads int) (retVal *G.Node, err error) {
+	if x.Dims() != 3 {
+		err = fmt.Errorf("x shape not supported: %v", x.Shape())
+		return
+	}
+	var (
+		batchSize = x.Shape()[0]
+		seqLen    = x.Shape()[1]
+		dim       = x.Shape()[2]
+		hiddenDim = wq.Shape()[1]
+	)
+	if heads <= 0 || hiddenDim%heads != 0 {
+		err = fmt.Errorf("hidden dim %d can not be split into %d heads", hiddenDim, heads)
+		return
+	}
+	headDim := hiddenDim / heads
+	// [batchSize * seqLen, dim]
+	x2d := G.Must(G.Reshape(x, tensor.Shape{batchSize * seqLen, dim}))
+	// project and split heads: [batchSize * heads, seqLen, headDim]
+	splitHeads := func(w *G.Node) *G.Node {
+		proj := G.Must(G.Mul(x2d, w))
+		proj = G.Must(G.Reshape(proj, tensor.Shape{batchSize, seqLen, heads, headDim}))
+		proj = G.Must(G.Transpose(proj, 0, 2, 1, 3))
+		return G.Must(G.Reshape(proj, tensor.Shape{batchSize * heads, seqLen, headDim}))
+	}
+	q, k, v := splitHeads(wq), splitHeads(wk), splitHeads(wv)
+
+	// [batchSize * heads, seqLen, seqLen]
+	scores := G.Must(G.BatchedMatMul(q, k, false, true))
+	scores = G.Must(G.Mul(scores, G.NewConstant(float32(1/math.Sqrt(float64(headDim))))))
+	var headMask *G.Node
+	if mask != nil {
+		// every head shares the mask of its sample
+		// [batchSize, heads, seqLen] -> [batchSize * heads, seqLen]
+		mask3d := G.Must(G.Reshape(mask, tensor.Shape{batchSize, 1, seqLen}))
+		headMasks := make(G.Nodes, heads)
+		for i := range headMasks {
+			headMasks[i] = mask3d
+		}
+		headMask = mask3d
+		if heads > 1 {
+			headMask = G.Must(G.Concat(1, headMasks...))
+		}
+		headMask = G.Must(G.Reshape(headMask, tensor.Shape{batchSize * heads, seqLen}))
+	}
+	var att *G.Node
+	if att, err = MaskedSoftmax(scores, headMask); err != nil {
+		return
+	}
+
+	// [batchSize * heads, seqLen, headDim]
+	out := G.Must(G.BatchedMatMul(att, v))
+	// merge heads: [batchSize * seqLen, heads * headDim]
+	out = G.Must(G.Reshape(out, tensor.Shape{batchSize, heads, seqLen, headDim}))
+	out = G.Must(G.Transpose(out, 0, 2, 1, 3))
+	out = G.Must(G.Reshape(out, tensor.Shape{batchSize * seqLen, hiddenDim}))
+	out = G.Must(G.Mul(out, wo))
+	return G.Reshape(out, tensor.Shape{batchSize, seqLen, dim})
+}
+
+// SinusoidalPositionalEncoding is the fixed positional encoding of the
+// Transformer paper:
+//
+//	PE(pos, 2i) = sin(pos / 10000^(2i/dim))
+//	PE(pos, 2i+1) = cos(pos / 10000^(2i/dim))
+//
+// output shape: [seqLen, dim]
+func SinusoidalPositionalEncoding(seqLen, dim int) tensor.Tensor {
+	backing := make([]float32, seqLen*dim)
+	for pos := 0; pos < seqLen; pos++ {
+		for i := 0; i < dim; i++ {
+			angle := float64(pos) / math.Pow(10000, float64(i-i%2)/float64(dim))
+			if i%2 == 0 {
+				backing[pos*dim+i] = float32(math.Sin(angle))
+			} else {
+				backing[pos*dim+i] = float32(math.Cos(angle))
+			}
+		}
+	}
+	return tensor.New(tensor.WithShape(seqLen, dim), tensor.WithBacking(backing))
+}
@@ -0,0 +1,160 @@
+package layers
+
+import (
+	"math"
+	"testing"
+
+	"github.com/auxten/go-ctr/model"
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+func TestAttention(t *testing.T) {
+	Convey("sequence mask", t, func() {
+		g := G.NewGraph()
+		x := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 3, 2), tensor.WithBacking([]float32{
+			1, 0,
+			0, 0,
+			0, -1,
+
+			0, 0,
+			0, 0,
+			2, 2,
+		})), G.WithName("x"))
+		output, err := SequenceMask(x)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So(output.Value().Data(), ShouldResemble, []float32{1, 0, 1, 0, 0, 1})
+	})
+
+	Convey("masked softmax", t, func() {
+		g := G.NewGraph()
+		x := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 1, 3), tensor.WithBacking([]float32{
+			1, 1, 100,
+			0, 0, 0,
+		})), G.WithName("x"))
+		mask := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 3), tensor.WithBacking([]float32{
+			1, 1, 0,
+			0, 0, 0,
+		})), G.WithName("mask"))
+		output, err := MaskedSoftmax(x, mask)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So([]int(output.Shape()), ShouldResemble, []int{2, 1, 3})
+		out := output.Value().Data().([]float32)
+		So(out[0], ShouldAlmostEqual, 0.5, 1e-6)
+		So(out[1], ShouldAlmostEqual, 0.5, 1e-6)
+		So(out[2], ShouldAlmostEqual, 0, 1e-6)
+		// all masked row is uniform rather than NaN
+		for _, o := range out[3:] {
+			So(o, ShouldAlmostEqual, 1./3, 1e-6)
+		}
+	})
+
+	Convey("activation unit", t, func() {
+		g := G.NewGraph()
+		behaviors := G.NodeFromAny(g, tensor.New(tensor.WithShape(1, 2, 2), tensor.WithBacking([]float32{
+			1, 0,
+			0, 1,
+		})), G.WithName("behaviors"))
+		item := G.NodeFromAny(g, tensor.New(tensor.WithShape(1, 2), tensor.WithBacking([]float32{1, 0})), G.WithName("item"))
+		att := NewWeight(g, "att", 1, 2, G.Zeroes(), nil)
+		output, err := ActivationUnit(behaviors, item, att)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		// zero att weights every behavior by sigmoid(0)
+		So([]int(output.Shape()), ShouldResemble, []int{1, 2})
+		out := output.Value().Data().([]float32)
+		So(out[0], ShouldAlmostEqual, 0.25, 1e-6)
+		So(out[1], ShouldAlmostEqual, 0.25, 1e-6)
+	})
+
+	Convey("activation weights", t, func() {
+		g := G.NewGraph()
+		behaviors := G.NodeFromAny(g, tensor.New(tensor.WithShape(1, 2, 2), tensor.WithBacking([]float32{
+			1, 0,
+			0, 1,
+		})), G.WithName("behaviors"))
+		item := G.NodeFromAny(g, tensor.New(tensor.WithShape(1, 2), tensor.WithBacking([]float32{1, 0})), G.WithName("item"))
+		att := NewWeight(g, "att", 1, 2, G.Ones(), nil)
+		output, err := ActivationWeights(behaviors, item, att)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		// the behavior of the item weighs sigmoid(1), the orthogonal one sigmoid(0.5)
+		So([]int(output.Shape()), ShouldResemble, []int{1, 2})
+		out := output.Value().Data().([]float32)
+		So(out[0], ShouldAlmostEqual, 1/(1+math.Exp(-1)), 1e-6)
+		So(out[1], ShouldAlmostEqual, 1/(1+math.Exp(-0.5)), 1e-6)
+	})
+
+	Convey("multi-head self attention is differentiable", t, func() {
+		var (
+			batchSize, seqLen, dim, heads = 2, 3, 4, 2
+		)
+		g := G.NewGraph()
+		x := G.NodeFromAny(g, tensor.New(tensor.WithShape(batchSize, seqLen, dim), tensor.WithBacking([]float32{
+			1, 0, 1, 0,
+			0, 1, 0, 1,
+			0, 0, 0, 0,
+
+			1, 1, 0, 0,
+			0, 0, 1, 1,
+			1, 0, 0, 1,
+		})), G.WithName("x"))
+		newW := func(name string) *G.Node {
+			return G.NewMatrix(g, model.DT, G.WithShape(dim, dim), G.WithName(name), G.WithInit(G.Gaussian(0, 0.5)))
+		}
+		wq, wk, wv, wo := newW("wq"), newW("wk"), newW("wv"), newW("wo")
+		mask, err := SequenceMask(x)
+		So(err, ShouldBeNil)
+		output, err := MultiHeadSelfAttention(x, mask, wq, wk, wv, wo, heads)
+		So(err, ShouldBeNil)
+		So([]int(output.Shape()), ShouldResemble, []int{batchSize, seqLen, dim})
+		cost := G.Must(G.Mean(G.Must(G.Square(output))))
+		_, err = G.Grad(cost, wq, wk, wv, wo)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g, G.BindDualValues(wq, wk, wv, wo))
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		for _, w := range []*G.Node{wq, wk, wv, wo} {
+			grad, err := w.Grad()
+			So(err, ShouldBeNil)
+			So(grad.Shape(), ShouldResemble, w.Shape())
+		}
+	})
+
+	Convey("multi-head self attention heads mismatch", t, func() {
+		g := G.NewGraph()
+		x := G.NewTensor(g, model.DT, 3, G.WithShape(2, 3, 4), G.WithName("x"))
+		w := G.NewMatrix(g, model.DT, G.WithShape(4, 4), G.WithName("w"))
+		_, err := MultiHeadSelfAttention(x, nil, w, w, w, w, 3)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("sinusoidal positional encoding", t, func() {
+		pe := SinusoidalPositionalEncoding(2, 4)
+		So([]int(pe.Shape()), ShouldResemble, []int{2, 4})
+		data := pe.Data().([]float32)
+		So(data[:4], ShouldResemble, []float32{0, 1, 0, 1})
+		So(data[4], ShouldAlmostEqual, 0.841471, 1e-5)
+	})
+}
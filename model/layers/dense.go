@@ -0,0 +1,60 @@
+package layers
+
+import (
+	"fmt"
+
+	"github.com/auxten/go-ctr/model"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// Activation is the activation function applied on the layer output,
+// G.Sigmoid, G.Rectify, G.Tanh etc. could be used directly.
+type Activation func(x *G.Node) (*G.Node, error)
+
+// NewWeight creates a learnable matrix named `name` in graph g. If backing is
+// nil the matrix is initialized by init, or it takes backing as its value,
+// which is the way the models are restored from json.
+func NewWeight(g *G.ExprGraph, name string, rows, cols int, init G.InitWFn, backing []float32) *G.Node {
+	if backing == nil {
+		return G.NewMatrix(g, model.DT, G.WithShape(rows, cols), G.WithName(name), G.WithInit(init))
+	}
+	return G.NewMatrix(g, model.DT,
+		G.WithShape(rows, cols),
+		G.WithName(name),
+		G.WithValue(tensor.New(tensor.WithShape(rows, cols), tensor.WithBacking(backing))),
+	)
+}
+
+// Dense is the fully connected layer without bias, nil act means linear.
+// x.Shape: [batchSize, inDim]
+// w.Shape: [inDim, outDim]
+// output shape: [batchSize, outDim]
+func Dense(x, w *G.Node, act Activation) (retVal *G.Node, err error) {
+	if x.Dims() != 2 || w.Dims() != 2 || x.Shape()[1] != w.Shape()[0] {
+		err = fmt.Errorf("x, w shapes not supported: %v, %v", x.Shape(), w.Shape())
+		return
+	}
+	if retVal, err = G.Mul(x, w); err != nil {
+		return
+	}
+	return Activate(retVal, act)
+}
+
+// Activate returns act(x), x as is if act is nil, e.g. the output of a model
+// activated by the head of the logits kept for the loss
+func Activate(x *G.Node, act Activation) (*G.Node, error) {
+	if act == nil {
+		return x, nil
+	}
+	return act(x)
+}
+
+// Dropout drops x with probability prob in training mode, x is returned as is
+// in inference mode or if prob is 0.
+func Dropout(x *G.Node, prob float32, training bool) (retVal *G.Node, err error) {
+	if !training || prob == 0 {
+		return x, nil
+	}
+	return G.Dropout(x, float64(prob))
+}
@@ -0,0 +1,50 @@
+package layers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+func TestDense(t *testing.T) {
+	Convey("dense with activation", t, func() {
+		g := G.NewGraph()
+		x := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float32{
+			1, 2,
+			-3, 1,
+		})), G.WithName("x"))
+		w := NewWeight(g, "w", 2, 1, nil, []float32{1, 1})
+		output, err := Dense(x, w, G.Rectify)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So(output.Value().Data(), ShouldResemble, []float32{3, 0})
+	})
+
+	Convey("dense shape mismatch", t, func() {
+		g := G.NewGraph()
+		x := NewWeight(g, "x", 2, 3, G.Zeroes(), nil)
+		w := NewWeight(g, "w", 2, 1, G.Zeroes(), nil)
+		_, err := Dense(x, w, nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("dropout only in training", t, func() {
+		g := G.NewGraph()
+		x := NewWeight(g, "x", 2, 3, G.Ones(), nil)
+		output, err := Dropout(x, 0.5, false)
+		So(err, ShouldBeNil)
+		So(output, ShouldEqual, x)
+		output, err = Dropout(x, 0, true)
+		So(err, ShouldBeNil)
+		So(output, ShouldEqual, x)
+		output, err = Dropout(x, 0.5, true)
+		So(err, ShouldBeNil)
+		So(output, ShouldNotEqual, x)
+	})
+}
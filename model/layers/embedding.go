@@ -0,0 +1,152 @@
+package layers
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	G "gorgonia.org/gorgonia"
+)
+
+// Embedding looks up the rows of the embedding table by ids, the gradient
+// only flows to the rows looked up.
+// table.Shape: [vocabSize, dim]
+// ids.Shape: [n], dtype should be tensor.Int
+// output shape: [n, dim]
+func Embedding(table, ids *G.Node) (retVal *G.Node, err error) {
+	if table.Dims() != 2 || !ids.Shape().IsVectorLike() {
+		err = fmt.Errorf("table, ids shapes not supported: %v, %v", table.Shape(), ids.Shape())
+		return
+	}
+	return G.ByIndices(table, ids, 0)
+}
+
+// EmbeddingMap exports the rows of the embedding table by ids, ids[i] is the
+// id of row i, e.g. the Vocabulary.FeatureNames of the table
+func EmbeddingMap(table *G.Node, ids []string) (embMap map[string][]float32, err error) {
+	rows, dim, data, err := tableData(table)
+	if err != nil {
+		return
+	}
+	if len(ids) != rows {
+		return nil, fmt.Errorf("%d ids of %d rows", len(ids), rows)
+	}
+	embMap = make(map[string][]float32, rows)
+	for i, id := range ids {
+		embMap[id] = append([]float32(nil), data[i*dim:(i+1)*dim]...)
+	}
+	return
+}
+
+// SetEmbeddingRows overwrites the rows of the embedding table in place, e.g.
+// the new items initialized from the content embeddings, the other rows are
+// kept. rows are keyed by the row index.
+func SetEmbeddingRows(table *G.Node, rows map[int][]float32) (err error) {
+	n, dim, data, err := tableData(table)
+	if err != nil {
+		return
+	}
+	for i, row := range rows {
+		if i < 0 || i >= n {
+			return fmt.Errorf("row %d out of [0, %d)", i, n)
+		}
+		if len(row) != dim {
+			return fmt.Errorf("row %d dim %d != %d", i, len(row), dim)
+		}
+	}
+	for i, row := range rows {
+		copy(data[i*dim:(i+1)*dim], row)
+	}
+	return
+}
+
+// EmbeddingTabler is the model of the learned embedding tables, e.g. the
+// wide.Predictor and the bias.BiasedModel, of which the tables are exported
+// by ExportEmbeddings and the rows are overwritten by ImportEmbeddings
+// without retraining. The rows imported are saved by the Marshal of the
+// model.
+type EmbeddingTabler interface {
+	// EmbeddingTables are the names of the tables
+	EmbeddingTables() []string
+	// WithEmbeddingTable calls fn of the table of name, the model is not
+	// used concurrently till fn returns
+	WithEmbeddingTable(name string, fn func(table *G.Node) error) error
+}
+
+// rowIds returns the ids of the rows, the row indexes if ids is nil
+func rowIds(rows int, ids []string) []string {
+	if ids != nil {
+		return ids
+	}
+	ids = make([]string, rows)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+	return ids
+}
+
+// ExportEmbeddings writes the table of name of m by rcmd.WriteEmbeddings,
+// ids[i] is the id of row i, e.g. the rcmd.FeatureColumnNames of the wide
+// model, or the row index if ids is nil
+func ExportEmbeddings(w io.Writer, m EmbeddingTabler, name string, ids []string) error {
+	var embMap map[string][]float32
+	if err := m.WithEmbeddingTable(name, func(table *G.Node) (err error) {
+		embMap, err = EmbeddingMap(table, rowIds(table.Shape()[0], ids))
+		return
+	}); err != nil {
+		return err
+	}
+	return rcmd.WriteEmbeddings(w, embMap)
+}
+
+// ImportEmbeddings overwrites the rows of the table of name of m by the rows
+// of rcmd.ReadEmbeddings of r, e.g. the new items initialized from their
+// content embeddings. The rows are of ids as of ExportEmbeddings, the rows
+// of the ids unknown are refused. The updated row count is returned.
+func ImportEmbeddings(r io.Reader, m EmbeddingTabler, name string, ids []string) (updated int, err error) {
+	err = m.WithEmbeddingTable(name, func(table *G.Node) error {
+		n, dim, _, err := tableData(table)
+		if err != nil {
+			return err
+		}
+		if ids != nil && len(ids) != n {
+			return fmt.Errorf("%d ids of %d rows", len(ids), n)
+		}
+		index := make(map[string]int, n)
+		for i, id := range rowIds(n, ids) {
+			index[id] = i
+		}
+		embMap, err := rcmd.ReadEmbeddings(r, dim)
+		if err != nil {
+			return err
+		}
+		rows := make(map[int][]float32, len(embMap))
+		for id, row := range embMap {
+			i, ok := index[id]
+			if !ok {
+				return fmt.Errorf("row %s not found in embedding table %s", id, name)
+			}
+			rows[i] = row
+		}
+		if err = SetEmbeddingRows(table, rows); err != nil {
+			return err
+		}
+		updated = len(rows)
+		return nil
+	})
+	return
+}
+
+func tableData(table *G.Node) (rows, dim int, data []float32, err error) {
+	if table.Dims() != 2 || table.Value() == nil {
+		err = fmt.Errorf("embedding table %v of shape %v has no value", table.Name(), table.Shape())
+		return
+	}
+	var ok bool
+	if data, ok = table.Value().Data().([]float32); !ok {
+		err = fmt.Errorf("embedding table %v is not float32", table.Name())
+		return
+	}
+	return table.Shape()[0], table.Shape()[1], data, nil
+}
@@ -0,0 +1,54 @@
+package layers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+func TestEmbedding(t *testing.T) {
+	Convey("embedding lookup", t, func() {
+		g := G.NewGraph()
+		table := NewWeight(g, "table", 3, 2, nil, []float32{
+			0, 1,
+			2, 3,
+			4, 5,
+		})
+		ids := G.NodeFromAny(g, tensor.New(tensor.WithShape(3), tensor.WithBacking([]int{2, 0, 2})), G.WithName("ids"))
+		output, err := Embedding(table, ids)
+		So(err, ShouldBeNil)
+		So([]int(output.Shape()), ShouldResemble, []int{3, 2})
+		_, err = G.Grad(G.Must(G.Sum(output)), table)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g, G.BindDualValues(table))
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So(output.Value().Data(), ShouldResemble, []float32{4, 5, 0, 1, 4, 5})
+		grad, err := table.Grad()
+		So(err, ShouldBeNil)
+		So(grad.Data(), ShouldResemble, []float32{1, 1, 0, 0, 2, 2})
+	})
+	Convey("export and overwrite rows", t, func() {
+		g := G.NewGraph()
+		table := NewWeight(g, "table", 3, 2, nil, []float32{
+			0, 1,
+			2, 3,
+			4, 5,
+		})
+		embMap, err := EmbeddingMap(table, []string{"<oov>", "a", "b"})
+		So(err, ShouldBeNil)
+		So(embMap, ShouldResemble, map[string][]float32{"<oov>": {0, 1}, "a": {2, 3}, "b": {4, 5}})
+		_, err = EmbeddingMap(table, []string{"a"})
+		So(err, ShouldNotBeNil)
+
+		So(SetEmbeddingRows(table, map[int][]float32{2: {7, 8}}), ShouldBeNil)
+		So(table.Value().Data(), ShouldResemble, []float32{0, 1, 2, 3, 7, 8})
+		So(SetEmbeddingRows(table, map[int][]float32{3: {7, 8}}), ShouldNotBeNil)
+		So(SetEmbeddingRows(table, map[int][]float32{0: {9, 9}, 1: {7}}), ShouldNotBeNil)
+		So(table.Value().Data(), ShouldResemble, []float32{0, 1, 2, 3, 7, 8})
+	})
+}
@@ -0,0 +1,32 @@
+package layers
+
+import (
+	"fmt"
+
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// LayerNorm normalizes every row of x to zero mean and unit variance.
+// x.Shape: [n, dim]
+// gain, bias.Shape: [dim], nil means no affine transform
+// output shape: [n, dim]
+func LayerNorm(x, gain, bias *G.Node) (retVal *G.Node, err error) {
+	if x.Dims() != 2 {
+		err = fmt.Errorf("x shape not supported: %v", x.Shape())
+		return
+	}
+	n := x.Shape()[0]
+	mean := G.Must(G.Reshape(G.Must(G.Mean(x, 1)), tensor.Shape{n, 1}))
+	centered := G.Must(G.BroadcastSub(x, mean, nil, []byte{1}))
+	variance := G.Must(G.Reshape(G.Must(G.Mean(G.Must(G.Square(centered)), 1)), tensor.Shape{n, 1}))
+	std := G.Must(G.Sqrt(G.Must(G.Add(variance, G.NewConstant(float32(1e-5))))))
+	retVal = G.Must(G.BroadcastHadamardDiv(centered, std, nil, []byte{1}))
+	if gain != nil {
+		retVal = G.Must(G.BroadcastHadamardProd(retVal, gain, nil, []byte{0}))
+	}
+	if bias != nil {
+		retVal = G.Must(G.BroadcastAdd(retVal, bias, nil, []byte{0}))
+	}
+	return
+}
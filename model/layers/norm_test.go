@@ -0,0 +1,30 @@
+package layers
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+func TestNorm(t *testing.T) {
+	Convey("layer norm", t, func() {
+		g := G.NewGraph()
+		x := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float32{1, 3, 5, 5})), G.WithName("x"))
+		gain := G.NodeFromAny(g, tensor.New(tensor.WithShape(2), tensor.WithBacking([]float32{2, 1})), G.WithName("gain"))
+		bias := G.NodeFromAny(g, tensor.New(tensor.WithShape(2), tensor.WithBacking([]float32{0, 1})), G.WithName("bias"))
+		output, err := LayerNorm(x, gain, bias)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		out := output.Value().Data().([]float32)
+		So(out[0], ShouldAlmostEqual, -2, 1e-4)
+		So(out[1], ShouldAlmostEqual, 2, 1e-4)
+		So(out[2], ShouldAlmostEqual, 0, 1e-4)
+		So(out[3], ShouldAlmostEqual, 1, 1e-4)
+	})
+}
@@ -0,0 +1,65 @@
+package layers
+
+import (
+	"fmt"
+
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// BatchOuterProd computes the outer product of x and y row by row, it is the
+// vectorized version of calling G.OuterProd on every sample of the batch.
+// x.Shape: [batchSize, m], y.Shape: [batchSize, n]
+// output shape: [batchSize, m, n]
+func BatchOuterProd(x, y *G.Node) (retVal *G.Node, err error) {
+	if x.Dims() != 2 || y.Dims() != 2 || x.Shape()[0] != y.Shape()[0] {
+		err = fmt.Errorf("x, y shapes not supported: %v, %v", x.Shape(), y.Shape())
+		return
+	}
+	var (
+		batchSize = x.Shape()[0]
+		m         = x.Shape()[1]
+		n         = y.Shape()[1]
+		x3d, y3d  *G.Node
+	)
+	if x3d, err = G.Reshape(x, tensor.Shape{batchSize, m, 1}); err != nil {
+		return
+	}
+	if y3d, err = G.Reshape(y, tensor.Shape{batchSize, 1, n}); err != nil {
+		return
+	}
+	//[batchSize, m, 1] ⊙ [batchSize, 1, n]
+	return G.BroadcastHadamardProd(x3d, y3d, []byte{2}, []byte{1})
+}
+
+// PairwiseInnerProd computes the inner products of every field pair (i < j).
+// fields are all in shape [batchSize, dim]
+// output shape: [batchSize, len(fields) * (len(fields) - 1) / 2]
+func PairwiseInnerProd(fields ...*G.Node) (retVal *G.Node, err error) {
+	if len(fields) < 2 {
+		err = fmt.Errorf("at least 2 fields needed, got %d", len(fields))
+		return
+	}
+	shape := fields[0].Shape()
+	for _, f := range fields {
+		if f.Dims() != 2 || !f.Shape().Eq(shape) {
+			err = fmt.Errorf("field shapes mismatch: %v, %v", shape, f.Shape())
+			return
+		}
+	}
+	var (
+		batchSize = shape[0]
+		products  = make(G.Nodes, 0, len(fields)*(len(fields)-1)/2)
+	)
+	for i := 0; i < len(fields); i++ {
+		for j := i + 1; j < len(fields); j++ {
+			//[batchSize]
+			prod := G.Must(G.Sum(G.Must(G.HadamardProd(fields[i], fields[j])), 1))
+			products = append(products, G.Must(G.Reshape(prod, tensor.Shape{batchSize, 1})))
+		}
+	}
+	if len(products) == 1 {
+		return products[0], nil
+	}
+	return G.Concat(1, products...)
+}
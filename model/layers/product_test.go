@@ -0,0 +1,74 @@
+package layers
+
+import (
+	"testing"
+
+	"github.com/auxten/go-ctr/model"
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+func TestProducts(t *testing.T) {
+	Convey("batch outer product", t, func() {
+		g := G.NewGraph()
+		x := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float32{
+			1, 2,
+			3, 4,
+		})), G.WithName("x"))
+		y := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 3), tensor.WithBacking([]float32{
+			1, 0, -1,
+			2, 1, 0,
+		})), G.WithName("y"))
+		output, err := BatchOuterProd(x, y)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So([]int(output.Shape()), ShouldResemble, []int{2, 2, 3})
+		So(output.Value().Data(), ShouldResemble, []float32{
+			1, 0, -1,
+			2, 0, -2,
+
+			6, 3, 0,
+			8, 4, 0,
+		})
+	})
+
+	Convey("batch outer product shape mismatch", t, func() {
+		g := G.NewGraph()
+		x := G.NewMatrix(g, model.DT, G.WithShape(2, 2), G.WithName("x"))
+		y := G.NewMatrix(g, model.DT, G.WithShape(3, 2), G.WithName("y"))
+		_, err := BatchOuterProd(x, y)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("pairwise inner product", t, func() {
+		g := G.NewGraph()
+		a := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float32{1, 2, 3, 4})), G.WithName("a"))
+		b := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float32{1, 1, 0, 1})), G.WithName("b"))
+		c := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float32{2, 0, 1, -1})), G.WithName("c"))
+		output, err := PairwiseInnerProd(a, b, c)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So([]int(output.Shape()), ShouldResemble, []int{2, 3})
+		// a·b, a·c, b·c
+		So(output.Value().Data(), ShouldResemble, []float32{
+			3, 2, 2,
+			4, -1, -1,
+		})
+	})
+
+	Convey("pairwise inner product needs 2 fields", t, func() {
+		g := G.NewGraph()
+		a := G.NewMatrix(g, model.DT, G.WithShape(2, 2), G.WithName("a"))
+		_, err := PairwiseInnerProd(a)
+		So(err, ShouldNotBeNil)
+	})
+}
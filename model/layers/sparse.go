@@ -0,0 +1,95 @@
+package layers
+
+import (
+	"fmt"
+
+	"github.com/auxten/go-ctr/model"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// SparseInput is the input of the CSR batches of batchSize rows of at most
+// slots non-zeros each. Every row has slots of the ids and values, the slots
+// short of the row non-zeros are padded by id 0 of value 0, so the shapes are
+// fixed for the compiled graph.
+type SparseInput struct {
+	// Ids.Shape: [batchSize * slots], dtype tensor.Int
+	Ids *G.Node
+	// Values.Shape: [batchSize * slots, 1]
+	Values *G.Node
+
+	batchSize, slots int
+	// the backings of Ids and Values reused by every Let
+	ids     []int
+	values  []float32
+	idsT    tensor.Tensor
+	valuesT tensor.Tensor
+}
+
+// NewSparseInput creates the input nodes named by name in graph g, slots is
+// usually the SparseSample.MaxRowNnz of the training samples
+func NewSparseInput(g *G.ExprGraph, name string, batchSize, slots int) *SparseInput {
+	n := batchSize * slots
+	in := &SparseInput{
+		Ids:       G.NewVector(g, tensor.Int, G.WithShape(n), G.WithName(name+"Ids")),
+		Values:    G.NewMatrix(g, model.DT, G.WithShape(n, 1), G.WithName(name+"Values")),
+		batchSize: batchSize,
+		slots:     slots,
+		ids:       make([]int, n),
+		values:    make([]float32, n),
+	}
+	in.idsT = tensor.New(tensor.WithShape(n), tensor.WithBacking(in.ids))
+	in.valuesT = tensor.New(tensor.WithShape(n, 1), tensor.WithBacking(in.values))
+	return in
+}
+
+// EmbeddingSum is the sum of the rows of the embedding table of the ids
+// weighted by the values of every row, which is the dense row times the
+// table without the zeros, and the gradient only flows to the rows of the
+// ids. table.Shape: [cols, dim], output shape: [batchSize, dim]
+func (in *SparseInput) EmbeddingSum(table *G.Node) (retVal *G.Node, err error) {
+	var embs *G.Node
+	if embs, err = Embedding(table, in.Ids); err != nil {
+		return
+	}
+	if embs, err = G.BroadcastHadamardProd(embs, in.Values, nil, []byte{1}); err != nil {
+		return
+	}
+	dim := table.Shape()[1]
+	if embs, err = G.Reshape(embs, tensor.Shape{in.batchSize, in.slots, dim}); err != nil {
+		return
+	}
+	return G.Sum(embs, 1)
+}
+
+// Let sets the rows of batch, the rows short of batchSize are zeros. The
+// backings are reused, so the ids and values are copied in place only.
+func (in *SparseInput) Let(batch *rcmd.SparseSample) error {
+	rows := batch.Rows()
+	if rows > in.batchSize {
+		return fmt.Errorf("sparse batch of %d rows > batch size %d", rows, in.batchSize)
+	}
+	for i := 0; i < in.batchSize; i++ {
+		base := i * in.slots
+		var (
+			indices []int
+			values  []float32
+		)
+		if i < rows {
+			indices, values = batch.Row(i)
+		}
+		if len(indices) > in.slots {
+			return fmt.Errorf("row %d of %d non-zeros > %d slots", i, len(indices), in.slots)
+		}
+		copy(in.ids[base:], indices)
+		copy(in.values[base:], values)
+		for k := len(indices); k < in.slots; k++ {
+			in.ids[base+k], in.values[base+k] = 0, 0
+		}
+	}
+	if err := G.Let(in.Ids, in.idsT); err != nil {
+		return err
+	}
+	return G.Let(in.Values, in.valuesT)
+}
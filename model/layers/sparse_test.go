@@ -0,0 +1,53 @@
+package layers
+
+import (
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+)
+
+func TestSparseInput(t *testing.T) {
+	Convey("embedding sum of the sparse rows", t, func() {
+		g := G.NewGraph()
+		table := NewWeight(g, "table", 4, 2, nil, []float32{
+			0, 1,
+			2, 3,
+			4, 5,
+			6, 7,
+		})
+		in := NewSparseInput(g, "x", 3, 2)
+		output, err := in.EmbeddingSum(table)
+		So(err, ShouldBeNil)
+		So([]int(output.Shape()), ShouldResemble, []int{3, 2})
+		_, err = G.Grad(G.Must(G.Sum(output)), table)
+		So(err, ShouldBeNil)
+		m := G.NewTapeMachine(g, G.BindDualValues(table))
+		defer m.Close()
+
+		// the dense rows [0, 2, 0, 1] and [0.5, 0, 0, 0]
+		batch, err := rcmd.ToSparse([]float32{0, 2, 0, 1, 0.5, 0, 0, 0}, 2, 4)
+		So(err, ShouldBeNil)
+		So(in.Let(batch), ShouldBeNil)
+		So(m.RunAll(), ShouldBeNil)
+		So(output.Value().Data(), ShouldResemble, []float32{10, 13, 0, 0.5, 0, 0})
+		grad, err := table.Grad()
+		So(err, ShouldBeNil)
+		So(grad.Data(), ShouldResemble, []float32{0.5, 0.5, 2, 2, 0, 0, 1, 1})
+		m.Reset()
+
+		// the slots of the last batch are padded
+		So(in.Let(batch.Batch(1, 2)), ShouldBeNil)
+		So(m.RunAll(), ShouldBeNil)
+		So(output.Value().Data(), ShouldResemble, []float32{0, 0.5, 0, 0, 0, 0})
+		m.Reset()
+
+		wide, err := rcmd.ToSparse([]float32{1, 1, 1, 0}, 1, 4)
+		So(err, ShouldBeNil)
+		So(in.Let(wide), ShouldNotBeNil)
+		long, err := rcmd.ToSparse(make([]float32, 16), 4, 4)
+		So(err, ShouldBeNil)
+		So(in.Let(long), ShouldNotBeNil)
+	})
+}
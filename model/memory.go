@@ -0,0 +1,229 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	G "gorgonia.org/gorgonia"
+)
+
+// EpochReport is the cost and resource usage of a training epoch
+type EpochReport struct {
+	Epoch     int     `json:"epoch"`
+	Cost      float32 `json:"cost"`
+	BatchSize int     `json:"batchSize"`
+	// Duration is the wall time of the epoch
+	Duration time.Duration `json:"duration"`
+	// PeakRSS is the peak resident memory of the process in bytes by the
+	// end of the epoch
+	PeakRSS uint64 `json:"peakRSS"`
+	// Allocs and AllocBytes are the heap objects and bytes allocated in the
+	// epoch
+	Allocs     uint64 `json:"allocs"`
+	AllocBytes uint64 `json:"allocBytes"`
+}
+
+// BatchResize is a switch to the smaller batch size by the memory cap, Heap
+// is the heap in use over the cap
+type BatchResize struct {
+	Epoch int    `json:"epoch"`
+	From  int    `json:"from"`
+	To    int    `json:"to"`
+	Heap  uint64 `json:"heap"`
+}
+
+// TrainReport is the report of Train by WithTrainReport
+type TrainReport struct {
+	Epochs []EpochReport `json:"epochs"`
+	// BatchSize is the batch size of the last epoch, smaller than the batch
+	// size of Train if resized by WithMemoryCap
+	BatchSize int           `json:"batchSize"`
+	Resizes   []BatchResize `json:"resizes,omitempty"`
+	// Evaluations are of WithEvaluation
+	Evaluations []Evaluation `json:"evaluations,omitempty"`
+	// SkippedSteps are the solver steps skipped by the overflowed gradients
+	// of MixedPrecision
+	SkippedSteps int `json:"skippedSteps,omitempty"`
+}
+
+// WithTrainReport fills report with the epochs of Train as they end, so the
+// report is of the epochs done even if Train fails
+func WithTrainReport(report *TrainReport) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.Report = report
+	}
+}
+
+// WithMemoryCap caps the heap in use of the go runtime in bytes, measured
+// after a GC so the garbage is not counted. If the heap exceeds the cap after
+// a solver step, the batch size is halved and the epoch in progress is
+// restarted on a new Model of newModel, which should return a Model of the
+// same architecture and options in its own graph like WithDataParallel, the
+// weights and the solver state are carried over. The graph and the VM of the
+// previous batch size are released before the new one is built, and the
+// heap is measured again after a step of the new batch size. Train fails if
+// the cap is exceeded by the batch size of 1.
+// The trained Model passed to Train gets the weights at the end. The
+// checkpoints after the switch record the smaller batch size as
+// Checkpoint.ResizedBatchSize, Train of the same batch size and memory cap
+// resumes by it.
+func WithMemoryCap(bytes uint64, newModel func() Model) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.MemoryCap = bytes
+		opts.NewModel = newModel
+	}
+}
+
+// residentMemory returns the current and the peak resident memory of the
+// process in bytes, of /proc/self/status on linux or the memory obtained by
+// the go runtime elsewhere
+func residentMemory() (rss, peak uint64) {
+	if data, err := os.ReadFile("/proc/self/status"); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if v, ok := statusKB(line, "VmRSS:"); ok {
+				rss = v
+			} else if v, ok = statusKB(line, "VmHWM:"); ok {
+				peak = v
+			}
+		}
+		if rss > 0 {
+			if peak < rss {
+				peak = rss
+			}
+			return
+		}
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.Sys, stats.Sys
+}
+
+// statusKB parses the line of key of /proc/self/status in kB to bytes
+func statusKB(line []byte, key string) (uint64, bool) {
+	if !bytes.HasPrefix(line, []byte(key)) {
+		return 0, false
+	}
+	fields := bytes.Fields(line[len(key):])
+	if len(fields) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(string(fields[0]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v << 10, true
+}
+
+// epochMeter measures an epoch for the EpochReport
+type epochMeter struct {
+	start  time.Time
+	allocs uint64
+	bytes  uint64
+}
+
+func newEpochMeter() *epochMeter {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return &epochMeter{start: time.Now(), allocs: stats.Mallocs, bytes: stats.TotalAlloc}
+}
+
+func (e *epochMeter) report(epoch, batchSize int, cost float32) EpochReport {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	_, peak := residentMemory()
+	return EpochReport{
+		Epoch:      epoch,
+		Cost:       cost,
+		BatchSize:  batchSize,
+		Duration:   time.Since(e.start),
+		PeakRSS:    peak,
+		Allocs:     stats.Mallocs - e.allocs,
+		AllocBytes: stats.TotalAlloc - e.bytes,
+	}
+}
+
+// overMemoryCap returns the heap in use if over the cap of opts. The heap
+// looking over the cap is measured again after a GC, so the steps under the
+// cap run no GC.
+func overMemoryCap(opts *TrainOpts) (heap uint64, over bool) {
+	if opts.MemoryCap == 0 {
+		return
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapInuse <= opts.MemoryCap {
+		return stats.HeapInuse, false
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse, stats.HeapInuse > opts.MemoryCap
+}
+
+// halveBatch returns the batch size halved for the memory cap
+func halveBatch(batchSize int, heap uint64, opts *TrainOpts) (int, error) {
+	if batchSize <= 1 {
+		return 0, fmt.Errorf("heap in use %d over the cap %d by the batch size 1", heap, opts.MemoryCap)
+	}
+	if opts.NewModel == nil {
+		return 0, fmt.Errorf("memory cap without NewModel")
+	}
+	half := batchSize / 2
+	log.Warnf("Heap in use %d over the cap %d, batch size %d switched to %d", heap, opts.MemoryCap, batchSize, half)
+	return half, nil
+}
+
+// weightValues returns the copies of the weights of nodes
+func weightValues(nodes G.Nodes) [][]float32 {
+	values := make([][]float32, len(nodes))
+	for i, n := range nodes {
+		values[i] = append([]float32(nil), n.Value().Data().([]float32)...)
+	}
+	return values
+}
+
+// setWeights copies values of weightValues to the weights of nodes
+func setWeights(nodes G.Nodes, values [][]float32) error {
+	if len(nodes) != len(values) {
+		return fmt.Errorf("%d learnable nodes != %d", len(nodes), len(values))
+	}
+	for i, n := range nodes {
+		if len(values[i]) != n.Shape().TotalSize() {
+			return fmt.Errorf("node %s size %d != %d", n.Name(), n.Shape().TotalSize(), len(values[i]))
+		}
+		copy(n.Value().Data().([]float32), values[i])
+	}
+	return nil
+}
+
+// releaseReplicas closes the VMs of replicas and unbinds them of the Models,
+// so the graphs of the Models dropped are collected
+func releaseReplicas(replicas []*replica) {
+	for _, r := range replicas {
+		if r.vm != nil {
+			_ = r.vm.Close()
+		}
+		r.m.SetVM(nil)
+	}
+}
+
+// copyWeights copies the weights of src to dst of the same shapes
+func copyWeights(dst, src G.Nodes) error {
+	if len(dst) != len(src) {
+		return fmt.Errorf("%d learnable nodes != %d", len(dst), len(src))
+	}
+	for i, n := range dst {
+		if !n.Shape().Eq(src[i].Shape()) {
+			return fmt.Errorf("node %s shape %v != %v", n.Name(), n.Shape(), src[i].Shape())
+		}
+		copy(n.Value().Data().([]float32), src[i].Value().Data().([]float32))
+	}
+	return nil
+}
@@ -22,6 +22,152 @@ type Model interface {
 	Marshal() (data []byte, err error)
 	Vm() G.VM
 	SetVM(vm G.VM)
+	// SetTraining switches the model between training and inference mode.
+	// It must be called before Fwd, dropout is only built into the graph
+	// in training mode.
+	SetTraining(training bool)
+}
+
+// TrainOpts are the optional settings of Train
+type TrainOpts struct {
+	// LearningRate of the Adam solver, DefaultLearningRate if 0
+	LearningRate float64
+	// Regularizations are the weight decay terms added to the cost
+	Regularizations []Regularization
+	// Objective is the training target, default BinaryObjective
+	Objective Objective
+	// Classes of the SoftmaxObjective and OrdinalObjective targets
+	Classes int
+	// Precision of the forward and backward pass, see WithMixedPrecision
+	Precision Precision
+	// Replicas of the data-parallel training, see WithDataParallel
+	Replicas int
+	// NewReplica creates a replica of the trained Model in its own graph
+	NewReplica func() Model
+	// AccumulationSteps are the micro-batches of a solver step, see
+	// WithGradientAccumulation
+	AccumulationSteps int
+	// CheckpointEvery solver steps OnCheckpoint is called, see WithCheckpoint
+	CheckpointEvery int
+	OnCheckpoint    func(cp *Checkpoint) error
+	// Resume is the Checkpoint to resume, see WithResume
+	Resume *Checkpoint
+	// Shuffle the batches by Seed, see WithShuffle
+	Shuffle bool
+	Seed    int64
+	// Slates are the slate ids of every sample of ListwiseObjective
+	Slates []int
+	// LabelSmoothing softens the targets of the classification objectives,
+	// see WithLabelSmoothing
+	LabelSmoothing float32
+	// HardSamples of the highest loss of every epoch are dumped to
+	// HardSamplePath, see WithHardSampleDump
+	HardSamplePath  string
+	HardSamples     int
+	HardSampleNames []string
+	// EvalInputs and EvalTargets are the validation samples evaluated on
+	// the Model of NewEvalModel, see WithEvaluation
+	EvalInputs, EvalTargets tensor.Tensor
+	NewEvalModel            func() Model
+	Eval                    EvalConfig
+	// OnEpochEnd is called with the data loss at the end of every epoch
+	OnEpochEnd func(epoch int, cost float32)
+	// Report is filled by Train, see WithTrainReport
+	Report *TrainReport
+	// MemoryCap is the resident memory cap in bytes, NewModel creates the
+	// Model of the smaller batch size, see WithMemoryCap
+	MemoryCap uint64
+	NewModel  func() Model
+}
+
+// TrainOption sets the optional settings of Train
+type TrainOption func(opts *TrainOpts)
+
+// DefaultLearningRate is the learning rate of the Adam solver of Train
+const DefaultLearningRate = 0.01
+
+// WithLearningRate sets the learning rate of the Adam solver
+func WithLearningRate(lr float64) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.LearningRate = lr
+	}
+}
+
+func (opts *TrainOpts) learningRate() float64 {
+	if opts.LearningRate <= 0 {
+		return DefaultLearningRate
+	}
+	return opts.LearningRate
+}
+
+// WithObjective sets the training target, the regression objectives need
+// the Model to be a HeadSetter
+func WithObjective(o Objective) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.Objective = o
+	}
+}
+
+// WithClasses sets the multi-class objective o of classes, the targets are
+// the class indexes and the Model should have o.Outputs(classes) outputs
+func WithClasses(o Objective, classes int) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.Objective = o
+		opts.Classes = classes
+	}
+}
+
+// WithSlates sets the ListwiseObjective of the samples grouped by slates,
+// e.g. the request or impression id of every sample. The samples of a slate
+// should be adjacent, since the slate softmax is over the samples of a batch
+// only, a slate cut by the batch boundary is trained as two.
+func WithSlates(slates []int) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.Objective = ListwiseObjective
+		opts.Slates = slates
+	}
+}
+
+// WithLabelSmoothing softens the 0/1 targets of the BinaryObjective,
+// SoftmaxObjective and OrdinalObjective by eps in [0, 1) before the loss:
+// y * (1 - eps) + eps / k of the k classes of a target, 2 of the binary and
+// the cumulative ones. It keeps the model from the overconfident scores of
+// the noisy implicit feedback, e.g. an impression without a click as a
+// negative.
+func WithLabelSmoothing(eps float32) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.LabelSmoothing = eps
+	}
+}
+
+// WithMixedPrecision trains by MixedPrecision: the solver steps the float32
+// master weights, the forward and backward pass run the weights and inputs
+// rounded to float16, and the gradients are of the cost scaled by the
+// dynamic loss scale to keep the small ones from the float16 underflow. The
+// steps of the gradients overflowed to inf or nan are skipped and the loss
+// scale is halved, it's doubled after DefaultLossScaleGrowth steps of no
+// overflow. gorgonia has no float16 kernels, so the float16 values are
+// emulated in float32, the activations keep the float32 precision. The loss
+// scale of a resumed Train restarts by DefaultLossScale.
+func WithMixedPrecision() TrainOption {
+	return func(opts *TrainOpts) {
+		opts.Precision = MixedPrecision
+	}
+}
+
+// WithEpochEnd calls fn with the data loss at the end of every epoch, e.g.
+// to report the learning curve to the experiment tracker
+func WithEpochEnd(fn func(epoch int, cost float32)) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.OnEpochEnd = fn
+	}
+}
+
+// WithRegularization adds weight decay terms of parameter groups to the cost
+func WithRegularization(regs ...Regularization) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.Regularizations = append(opts.Regularizations, regs...)
+	}
 }
 
 func Train(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int,
@@ -30,62 +176,94 @@ func Train(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim in
 	inputs, targets tensor.Tensor,
 //testInputs, testTargets tensor.Tensor,
 	m Model,
+	opts ...TrainOption,
 ) (err error) {
-	g := m.Graph()
-	xUserProfile := G.NewMatrix(g, DT, G.WithShape(batchSize, uProfileDim), G.WithName("xUserProfile"))
-	//xUserBehaviors := G.NewTensor(g, DT, 3, G.WithShape(batchSize, uBehaviorSize, uBehaviorDim), G.WithName("xUserBehaviors"))
-	xUserBehaviorMatrix := G.NewMatrix(g, DT, G.WithShape(batchSize, uBehaviorSize*uBehaviorDim), G.WithName("xUserBehaviorMatrix"))
-	xItemFeature := G.NewMatrix(g, DT, G.WithShape(batchSize, iFeatureDim), G.WithName("xItemFeature"))
-	xCtxFeature := G.NewMatrix(g, DT, G.WithShape(batchSize, cFeatureDim), G.WithName("xCtxFeature"))
-	y := G.NewTensor(g, DT, 2, G.WithShape(batchSize, 1), G.WithName("y"))
-	//m := NewDinNet(g, uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim)
-	if err = m.Fwd(xUserProfile, xUserBehaviorMatrix, xItemFeature, xCtxFeature, batchSize, uBehaviorSize, uBehaviorDim); err != nil {
-		log.Fatalf("%+v", err)
+	var trainOpts TrainOpts
+	for _, opt := range opts {
+		opt(&trainOpts)
 	}
-
-	//losses := G.Must(G.HadamardProd(G.Must(G.Neg(G.Must(G.Log(m.out)))), y))
-	//losses := G.Must(G.Square(G.Must(G.Sub(m.Out(), y))))
-	cost := BinaryCrossEntropy32(m.Out(), y)
-	// we want to track costs
-	//var costVal G.Value
-	//G.Read(cost, &costVal)
-	//
-	//var yOut G.Value
-	//G.Read(m.Out(), &yOut)
-
-	if _, err = G.Grad(cost, m.Learnable()...); err != nil {
-		log.Fatal(err)
+	if err = ValidateShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim,
+		numExamples, batchSize, si, inputs, targets, m); err != nil {
+		return
 	}
-
+	if err = checkObjective(trainOpts.Objective, trainOpts.Classes, m); err != nil {
+		return
+	}
+	outputs := trainOpts.Objective.Outputs(trainOpts.Classes)
+	rawTargets := targets
+	if targets, err = objectiveTargets(&trainOpts, targets, batchSize); err != nil {
+		return
+	}
+	var dump *hardSampleDump
+	if trainOpts.HardSamplePath != "" {
+		if dump, err = newTrainDump(&trainOpts, inputs, targets, rawTargets); err != nil {
+			return fmt.Errorf("hard sample dump: %v", err)
+		}
+		defer dump.close()
+	}
+	var (
+		replicas []*replica
+		// the data loss of the trained Model, regularization terms excluded
+		loss    *G.Node
+		acc     *gradAccumulator
+		batches int
+		// trained is the Model trained by the batch size, m or the Model of
+		// NewModel after a switch by the memory cap
+		trained = m
+	)
+	// build the replicas of trained of the batch size
+	build := func() (err error) {
+		if replicas, err = newReplicas(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim,
+			batchSize, outputs, trained, &trainOpts); err != nil {
+			return
+		}
+		for i, r := range replicas {
+			if err = r.prepare(si, inputs, targets); err != nil {
+				return fmt.Errorf("replica %d: %v", i, err)
+			}
+		}
+		loss = replicas[0].loss
+		acc = newGradAccumulator(replicas, trainOpts.AccumulationSteps)
+		batches = numExamples / batchSize
+		if numExamples%batchSize != 0 {
+			batches++
+		}
+		return
+	}
+	if err = build(); err != nil {
+		return
+	}
+	// mp is the float32 master weights of MixedPrecision
+	var mp *mixedPrecision
+	defer func() {
+		if mp != nil {
+			mp.restore(trained.Learnable())
+			if trainOpts.Report != nil {
+				trainOpts.Report.SkippedSteps = mp.skipped
+			}
+		}
+		if trained != m {
+			// the weights of the last switch are of the Model of Train
+			if cErr := copyWeights(m.Learnable(), trained.Learnable()); cErr != nil && err == nil {
+				err = cErr
+			}
+		}
+		if trainOpts.Report != nil {
+			trainOpts.Report.BatchSize = batchSize
+		}
+	}()
 	// debug
-	//ioutil.WriteFile("fullGraph.dot", []byte(g.ToDot()), 0644)
 	// log.Printf("%v", prog)
 	// logger := log.New(os.Stderr, "", 0)
 	// vm := gorgonia.NewTapeMachine(g, gorgonia.BindDualValues(m.Learnable()...), gorgonia.WithLogger(logger), gorgonia.WithWatchlist())
 
-	prog, locMap, err := G.Compile(g)
-	if err != nil {
-		log.Fatal(err)
-	}
-	//log.Printf("%v", prog)
-
-	vm := G.NewTapeMachine(g,
-		G.WithPrecompiled(prog, locMap),
-		G.BindDualValues(m.Learnable()...),
-		//G.TraceExec(),
-		//G.WithInfWatch(),
-		//G.WithNaNWatch(),
-		//G.WithLogger(log.New(os.Stderr, "", 0)),
-		//G.WithWatchlist(m.mlp2),
-	)
-	m.SetVM(vm)
-
 	//solver := G.NewRMSPropSolver(G.WithBatchSize(float32(batchSize)))
 	//solver := G.NewVanillaSolver(G.WithBatchSize(float32(batchSize)), G.WithLearnRate(0.001))
 	//solver := G.NewBarzilaiBorweinSolver(G.WithBatchSize(float32(batchSize)), G.WithLearnRate(0.001))
 	//solver := G.NewAdaGradSolver(G.WithBatchSize(float32(batchSize)), G.WithLearnRate(0.001))
 	//solver := G.NewMomentum(G.WithBatchSize(float32(batchSize)), G.WithLearnRate(0.001))
-	solver := G.NewAdamSolver(G.WithLearnRate(0.01), G.WithBatchSize(float64(batchSize)), G.WithL2Reg(0.0001))
+	//solver := G.NewAdamSolver(G.WithLearnRate(0.01), G.WithBatchSize(float64(batchSize)), G.WithL2Reg(0.0001))
+	solver := newAdamSolver(trainOpts.learningRate(), batchSize, 0.0001)
 	//defer func() {
 	//	vm.Close()
 	//	m.SetVM(nil)
@@ -93,117 +271,343 @@ func Train(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim in
 	// pprof
 	// handlePprof(sigChan, doneChan)
 
-	batches := numExamples / batchSize
-	if numExamples%batchSize != 0 {
-		batches++
-	}
-	log.Printf("Batches %d", batches)
+	log.Printf("Batches %d, replicas %d, accumulation steps %d", batches, len(replicas), acc.steps)
 	bar := pb.New(batches)
 	var (
-		bestCost  float32 = math.MaxFloat32
-		noImprove int
+		bestCost   float32 = math.MaxFloat32
+		noImprove  int
+		steps      int
+		startEpoch int
+		startBatch int
+		shuffle    *shuffleSource
+		// epochRand is the state of shuffle at the start of the epoch
+		epochRand uint64
 	)
-
-	for i := 0; i < epochs; i++ {
-		bar.Prefix(fmt.Sprintf("Epoch %d", i))
-		bar.Set(0)
-		bar.Start()
-		for b := 0; b < batches; b++ {
-			start := b * batchSize
-			end := start + batchSize
-			if start >= numExamples {
-				break
+	if trainOpts.Shuffle {
+		shuffle = &shuffleSource{}
+		shuffle.Seed(trainOpts.Seed)
+	}
+	// stepsAtSwitch are the steps at the last switch of the batch size
+	stepsAtSwitch := -1
+	// switchBatch switches to the batch size to on a new Model of the
+	// weights of trained. The VMs and the graph of trained are released
+	// before the graph of the batch size is built, but the Model of Train.
+	switchBatch := func(to int) (err error) {
+		if trainOpts.NewModel == nil {
+			return fmt.Errorf("batch size %d switched to %d without NewModel", batchSize, to)
+		}
+		next := trainOpts.NewModel()
+		if next == trained || next.Graph() == trained.Graph() {
+			return fmt.Errorf("NewModel shares the graph of the trained model")
+		}
+		if err = checkObjective(trainOpts.Objective, trainOpts.Classes, next); err != nil {
+			return
+		}
+		weights := weightValues(trained.Learnable())
+		releaseReplicas(replicas)
+		replicas, loss, acc = nil, nil, nil
+		trained, batchSize = next, to
+		if err = build(); err != nil {
+			return
+		}
+		if err = setWeights(trained.Learnable(), weights); err != nil {
+			return
+		}
+		syncWeights(replicas)
+		solver.batch = float64(batchSize)
+		stepsAtSwitch = steps
+		return
+	}
+	// trainBatchSize is the batch size of Train, batchSize is smaller after
+	// a switch by the memory cap
+	trainBatchSize := batchSize
+	if cp := trainOpts.Resume; cp != nil {
+		if err = cp.restore(m.Learnable(), solver, numExamples, batchSize); err != nil {
+			return
+		}
+		if cp.ResizedBatchSize != 0 {
+			// the checkpoint after a switch by the memory cap
+			if err = switchBatch(cp.ResizedBatchSize); err != nil {
+				return fmt.Errorf("resume of the resized batch size: %v", err)
 			}
-			if end > numExamples {
-				end = numExamples
+		}
+		if (cp.Shuffle != nil) != trainOpts.Shuffle {
+			return fmt.Errorf("checkpoint shuffled %v resumed by shuffle %v", cp.Shuffle != nil, trainOpts.Shuffle)
+		}
+		if cp.Shuffle != nil {
+			shuffle.state = *cp.Shuffle
+		}
+		syncWeights(replicas)
+		bestCost, noImprove, steps = cp.BestCost, cp.NoImprove, cp.Steps
+		startEpoch, startBatch = cp.Epoch, cp.Batch
+		log.Printf("Resume at epoch %d, batch %d", startEpoch, startBatch)
+		if cp.EarlyStopped {
+			return
+		}
+	}
+	if trainOpts.Precision == MixedPrecision {
+		mp = newMixedPrecision(trained.Learnable())
+		syncWeights(replicas)
+	}
+	checkpoint := func(epoch, batch int, stopped bool) {
+		cp := &Checkpoint{
+			Epoch:        epoch,
+			Batch:        batch,
+			Steps:        steps,
+			NumExamples:  numExamples,
+			BatchSize:    trainBatchSize,
+			BestCost:     bestCost,
+			NoImprove:    noImprove,
+			EarlyStopped: stopped,
+			Solver:       solver.State(),
+			LearnRate:    solver.eta,
+		}
+		if batchSize != trainBatchSize {
+			cp.ResizedBatchSize = batchSize
+		}
+		// the master weights of MixedPrecision
+		if mp != nil {
+			mp.restore(trained.Learnable())
+		}
+		cp.Nodes = newCheckpointNodes(trained.Learnable())
+		if mp != nil {
+			mp.quantize(trained.Learnable())
+		}
+		if shuffle != nil {
+			state := epochRand
+			if batch == 0 {
+				// the next epoch starts by the current state
+				state = shuffle.state
 			}
-
-			var (
-				xUserProfileVal   tensor.Tensor
-				xUserBehaviorsVal tensor.Tensor
-				xItemFeatureVal   tensor.Tensor
-				xCtxFeatureVal    tensor.Tensor
-				yVal              tensor.Tensor
-			)
-
-			if xUserProfileVal, err = inputs.Slice([]tensor.Slice{G.S(start, end), G.S(si.UserProfileRange[0], si.UserProfileRange[1])}...); err != nil {
-				log.Fatalf("Unable to slice xUserProfileVal %v", err)
+			cp.Shuffle = &state
+		}
+		if err = trainOpts.OnCheckpoint(cp); err != nil {
+			log.Errorf("Failed to save checkpoint at epoch %d, batch %d. Error %v", epoch, batch, err)
+		}
+	}
+	step := func(epoch, batch int) {
+		if err = acc.flush(); err != nil {
+			log.Fatalf("Failed to average gradients at epoch %d, batch %d. Error %v", epoch, batch, err)
+		}
+		if mp != nil {
+			var ok bool
+			if ok, err = mp.unscale(trained.Learnable()); err != nil {
+				log.Fatalf("Failed to unscale gradients at epoch %d, batch %d. Error %v", epoch, batch, err)
 			}
-			if xUserProfileVal.Shape()[0] < batchSize {
-				if xUserProfileVal, err = FillTensorRows(batchSize, xUserProfileVal); err != nil {
-					log.Fatalf("Unable to fill sample rows %v", err)
-				}
+			if !ok {
+				return
 			}
-			if err = G.Let(xUserProfile, xUserProfileVal); err != nil {
-				log.Fatalf("Unable to let xUserProfileVal %v", err)
+			mp.restore(trained.Learnable())
+		}
+		if err = solver.Step(G.NodesToValueGrads(trained.Learnable())); err != nil {
+			log.Fatalf("Failed to update nodes with gradients at epoch %d, batch %d. Error %v", epoch, batch, err)
+		}
+		if mp != nil {
+			mp.keep(trained.Learnable())
+		}
+		syncWeights(replicas)
+		steps++
+	}
+	// resize switches to the halved batch size by the memory cap
+	resize := func(epoch int, heap uint64) (err error) {
+		var half int
+		if half, err = halveBatch(batchSize, heap, &trainOpts); err != nil {
+			return
+		}
+		from := batchSize
+		if err = switchBatch(half); err != nil {
+			return
+		}
+		if trainOpts.Report != nil {
+			trainOpts.Report.Resizes = append(trainOpts.Report.Resizes, BatchResize{Epoch: epoch, From: from, To: batchSize, Heap: heap})
+		}
+		return
+	}
+
+	var eval *evaluator
+	if trainOpts.NewEvalModel != nil {
+		if eval, err = newEvaluator(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim,
+			batchSize, si, m, &trainOpts); err != nil {
+			return fmt.Errorf("evaluation: %v", err)
+		}
+	}
+	var (
+		// evaluated is the steps of the last evaluation, sinceEval the
+		// batches trained since
+		evaluated = -1
+		sinceEval int
+	)
+	// evaluate the validation loss of trained, the early stop is on it
+	evaluate := func(epoch, batch int) (stopped bool, err error) {
+		var lossVal float32
+		if lossVal, err = eval.evaluate(trained); err != nil {
+			return false, fmt.Errorf("evaluation at epoch %d, batch %d: %v", epoch, batch, err)
+		}
+		evaluated, sinceEval = steps, 0
+		ev := &Evaluation{Epoch: epoch, Batch: batch, Steps: steps, Loss: lossVal, LearnRate: solver.eta}
+		if trainOpts.Eval.OnEvaluation != nil {
+			trainOpts.Eval.OnEvaluation(ev)
+			if ev.LearnRate > 0 {
+				solver.eta = ev.LearnRate
 			}
+		}
+		if trainOpts.Report != nil {
+			trainOpts.Report.Evaluations = append(trainOpts.Report.Evaluations, *ev)
+		}
+		if lossVal < bestCost {
+			bestCost = lossVal
+			noImprove = 0
+		} else {
+			noImprove++
+		}
+		log.Printf("Epoch %d | batch %d | noImprove %d | validation loss %v", epoch, batch, noImprove, lossVal)
+		return earlyStop != 0 && noImprove >= earlyStop, nil
+	}
 
-			if xUserBehaviorsVal, err = inputs.Slice([]tensor.Slice{G.S(start, end), G.S(si.UserBehaviorRange[0], si.UserBehaviorRange[1])}...); err != nil {
-				log.Fatalf("Unable to slice xUserBehaviorsVal %v", err)
+	var (
+		meter *epochMeter
+		// restart is true if the epoch restarts by the smaller batch size
+		restart bool
+	)
+	for i := startEpoch; i < epochs; i++ {
+		bar.Prefix(fmt.Sprintf("Epoch %d", i))
+		bar.Set(0)
+		bar.Start()
+		if restart {
+			bar.SetTotal(batches)
+			if shuffle != nil {
+				shuffle.state = epochRand
 			}
-			if xUserBehaviorsVal.Shape()[0] < batchSize {
-				if xUserBehaviorsVal, err = FillTensorRows(batchSize, xUserBehaviorsVal); err != nil {
-					log.Fatalf("Unable to fill sample rows %v", err)
+		} else {
+			meter = newEpochMeter()
+		}
+		restart = false
+		if shuffle != nil {
+			epochRand = shuffle.state
+		}
+		if dump != nil {
+			dump.reset()
+		}
+		order := batchOrder(batches, shuffle)
+		// stopped is true if early stopped, by an evaluation in the epoch
+		var stopped bool
+		b := 0
+		if i == startEpoch {
+			b = startBatch
+			bar.Set(b)
+		}
+		for ; b < batches; b += len(replicas) {
+			// one batch of every replica, the trained Model runs the first
+			var shards [][2]int
+			for r := 0; r < len(replicas) && b+r < batches; r++ {
+				start := order[b+r] * batchSize
+				end := start + batchSize
+				if end > numExamples {
+					end = numExamples
 				}
+				shards = append(shards, [2]int{start, end})
 			}
-			if err = G.Let(xUserBehaviorMatrix, xUserBehaviorsVal); err != nil {
-				log.Fatalf("Unable to let xUserBehaviorsVal %v", err)
+			if mp != nil {
+				for _, r := range replicas {
+					if err = G.Let(r.lossScale, float32(mp.scale)); err != nil {
+						return fmt.Errorf("unable to let loss scale: %v", err)
+					}
+				}
 			}
-
-			if xItemFeatureVal, err = inputs.Slice([]tensor.Slice{G.S(start, end), G.S(si.ItemFeatureRange[0], si.ItemFeatureRange[1])}...); err != nil {
-				log.Fatalf("Unable to slice xItemFeatureVal %v", err)
+			if err = runReplicas(replicas, shards); err != nil {
+				log.Fatalf("Failed at epoch  %d, batch %d. Error: %v", i, b, err)
 			}
-			if xItemFeatureVal.Shape()[0] < batchSize {
-				if xItemFeatureVal, err = FillTensorRows(batchSize, xItemFeatureVal); err != nil {
-					log.Fatalf("Unable to fill sample rows %v", err)
+			if dump != nil {
+				for r, shard := range shards {
+					if err = dump.add(replicas[r], shard); err != nil {
+						return fmt.Errorf("hard samples of epoch %d, batch %d: %v", i, b, err)
+					}
 				}
 			}
-			if err = G.Let(xItemFeature, xItemFeatureVal); err != nil {
-				log.Fatalf("Unable to let xItemFeatureVal %v", err)
+			var due bool
+			if due, err = acc.add(len(shards)); err != nil {
+				log.Fatalf("Failed to accumulate gradients at epoch %d, batch %d. Error %v", i, b, err)
 			}
-
-			if xCtxFeatureVal, err = inputs.Slice([]tensor.Slice{G.S(start, end), G.S(si.CtxFeatureRange[0], si.CtxFeatureRange[1])}...); err != nil {
-				log.Fatalf("Unable to slice xCtxFeatureVal %v", err)
+			if due {
+				step(i, b)
 			}
-			if xCtxFeatureVal.Shape()[0] < batchSize {
-				if xCtxFeatureVal, err = FillTensorRows(batchSize, xCtxFeatureVal); err != nil {
-					log.Fatalf("Unable to fill sample rows %v", err)
-				}
+			for _, r := range replicas {
+				r.vm.Reset()
 			}
-			if err = G.Let(xCtxFeature, xCtxFeatureVal); err != nil {
-				log.Fatalf("Unable to let xCtxFeatureVal %v", err)
+			// the heap of the switched batch size is measured after a step
+			if due && steps > stepsAtSwitch {
+				if heap, over := overMemoryCap(&trainOpts); over {
+					if err = resize(i, heap); err != nil {
+						return
+					}
+					restart = true
+					startBatch = 0
+					break
+				}
 			}
-
-			if yVal, err = targets.Slice(G.S(start, end)); err != nil {
-				log.Fatalf("Unable to slice y %v", err)
+			bar.Add(len(shards))
+			next := b + len(shards)
+			sinceEval += len(shards)
+			if due && eval != nil && trainOpts.Eval.Every > 0 && sinceEval >= trainOpts.Eval.Every && next < batches {
+				if stopped, err = evaluate(i, next); err != nil {
+					return
+				}
+				if stopped {
+					break
+				}
 			}
-			if yVal.Shape()[0] < batchSize {
-				if yVal, err = FillTensorRows(batchSize, yVal); err != nil {
-					log.Fatalf("Unable to fill sample rows %v", err)
+			if due && next < batches && trainOpts.CheckpointEvery > 0 && trainOpts.OnCheckpoint != nil &&
+				steps%trainOpts.CheckpointEvery == 0 {
+				checkpoint(i, next, false)
+				if err != nil {
+					return
 				}
 			}
-			if err = G.Let(y, yVal); err != nil {
-				log.Fatalf("Unable to let y %v", err)
+		}
+		if restart {
+			i--
+			continue
+		}
+		// the micro-batches left at the end of the epoch
+		if !stopped && acc.pending() {
+			step(i, batches)
+		}
+		// early stop on the data loss, regularization terms excluded, if not
+		// on the validation loss
+		costVal := loss.Value().Data().(float32)
+		if eval == nil {
+			if costVal < bestCost {
+				bestCost = costVal
+				noImprove = 0
+			} else {
+				noImprove++
 			}
-
-			if err = vm.RunAll(); err != nil {
-				log.Fatalf("Failed at epoch  %d, batch %d. Error: %v", i, b, err)
+			stopped = earlyStop != 0 && noImprove >= earlyStop
+		}
+		log.Printf("Epoch %d | noImprove %d | cost %v", i, noImprove, costVal)
+		if trainOpts.OnEpochEnd != nil {
+			trainOpts.OnEpochEnd(i, costVal)
+		}
+		if trainOpts.Report != nil {
+			trainOpts.Report.Epochs = append(trainOpts.Report.Epochs, meter.report(i, batchSize, costVal))
+		}
+		if dump != nil {
+			if err = dump.flush(i); err != nil {
+				return fmt.Errorf("hard samples of epoch %d: %v", i, err)
 			}
-			if err = solver.Step(G.NodesToValueGrads(m.Learnable())); err != nil {
-				log.Fatalf("Failed to update nodes with gradients at epoch %d, batch %d. Error %v", i, b, err)
+		}
+		if eval != nil && !stopped && evaluated != steps {
+			if stopped, err = evaluate(i, batches); err != nil {
+				return
 			}
-			vm.Reset()
-			bar.Increment()
 		}
-		costVal := cost.Value().Data().(float32)
-		if costVal < bestCost {
-			bestCost = costVal
-			noImprove = 0
-		} else {
-			noImprove++
+		if trainOpts.OnCheckpoint != nil {
+			checkpoint(i+1, 0, stopped)
+			if err != nil {
+				return
+			}
 		}
-		log.Printf("Epoch %d | noImprove %d | cost %v", i, noImprove, costVal)
-		if earlyStop != 0 && noImprove >= earlyStop {
+		if stopped {
 			log.Printf("Early stop at epoch %d", i)
 			break
 		}
@@ -212,6 +616,32 @@ func Train(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim in
 	return
 }
 
+// objectiveTargets returns the targets of the objective of trainOpts, the
+// one-hot or cumulative ones of the classes and the slate ones of the lists,
+// smoothed by trainOpts.LabelSmoothing
+func objectiveTargets(trainOpts *TrainOpts, targets tensor.Tensor, batchSize int) (_ tensor.Tensor, err error) {
+	if trainOpts.Objective.multiClass() {
+		if targets, err = ClassTargets(trainOpts.Objective, trainOpts.Classes, targets); err != nil {
+			return
+		}
+	}
+	if trainOpts.LabelSmoothing != 0 {
+		return SmoothLabels(trainOpts.Objective, trainOpts.Classes, trainOpts.LabelSmoothing, targets)
+	}
+	if trainOpts.Objective == ListwiseObjective {
+		slates := trainOpts.Slates
+		if slates == nil {
+			// the batch is a slate
+			slates = make([]int, targets.Shape()[0])
+			for i := range slates {
+				slates[i] = i / batchSize
+			}
+		}
+		return SlateTargets(targets, slates)
+	}
+	return targets, nil
+}
+
 func InitForwardOnlyVm(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int,
 	batchSize int,
 	m Model,
@@ -221,6 +651,7 @@ func InitForwardOnlyVm(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cF
 	xUserBehaviorMatrix := G.NewMatrix(g, DT, G.WithShape(batchSize, uBehaviorSize*uBehaviorDim), G.WithName("xUserBehaviorMatrix"))
 	xItemFeature := G.NewMatrix(g, DT, G.WithShape(batchSize, iFeatureDim), G.WithName("xItemFeature"))
 	xCtxFeature := G.NewMatrix(g, DT, G.WithShape(batchSize, cFeatureDim), G.WithName("xCtxFeature"))
+	m.SetTraining(false)
 	if err = m.Fwd(xUserProfile, xUserBehaviorMatrix, xItemFeature, xCtxFeature,
 		batchSize, uBehaviorSize, uBehaviorDim); err != nil {
 		return
@@ -239,16 +670,63 @@ func InitForwardOnlyVm(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cF
 	return
 }
 
+// Predict returns the row major outputs of the inputs, of numExamples *
+// outputs length for the model of multiple outputs
 func Predict(m Model, numExamples, batchSize int, si *rcmd.SampleInfo, inputs tensor.Tensor) (y []float32, err error) {
+	outputNode := m.Out()
+	outputs := outputNode.Shape()[1]
+	err = runBatches(m, numExamples, batchSize, si, inputs, func(start, end int) {
+		yVal := outputNode.Value().Data().([]float32)
+		y = append(y, yVal[:(end-start)*outputs]...)
+	})
+	return
+}
+
+// Attender is implemented by the Model of an attention layer of the user
+// behaviors, e.g. DIN. Attention is the attention weights [batchSize,
+// uBehaviorSize] of the last run of the vm.
+type Attender interface {
+	Attention() G.Value
+}
+
+// Attention returns the row major attention weights of the Attender m of the
+// inputs, of numExamples * uBehaviorSize length
+func Attention(m Model, numExamples, batchSize int, si *rcmd.SampleInfo, inputs tensor.Tensor) (weights []float32, err error) {
+	attender, ok := m.(Attender)
+	if !ok {
+		return nil, fmt.Errorf("model %T has no attention", m)
+	}
+	err = runBatches(m, numExamples, batchSize, si, inputs, func(start, end int) {
+		w := attender.Attention().Data().([]float32)
+		width := len(w) / batchSize
+		weights = append(weights, w[:(end-start)*width]...)
+	})
+	return
+}
+
+// runBatches runs the vm of m on the batches of the inputs, read is called
+// with the rows of each batch before the vm is reset
+func runBatches(m Model, numExamples, batchSize int, si *rcmd.SampleInfo, inputs tensor.Tensor, read func(start, end int)) (err error) {
 	//input nodes
 	inputNodes := m.In()
-	xUserProfile := inputNodes[0]
-	xUbMatrix := inputNodes[1]
-	xItemFeature := inputNodes[2]
-	xCtxFeature := inputNodes[3]
-
-	//output node
-	outputNode := m.Out()
+	x, width, err := rowMajor(inputs)
+	if err != nil {
+		log.Errorf("Unable to read inputs %v", err)
+		return err
+	}
+	batch := &preparedBatch{}
+	for i, cols := range [][2]int{si.UserProfileRange, si.UserBehaviorRange, si.ItemFeatureRange, si.CtxFeatureRange} {
+		var feed *batchFeed
+		if feed, err = newBatchFeed(inputNodes[i], inputNodes[i].Name(), x, width, cols); err != nil {
+			log.Errorf("Unable to prepare inputs %v", err)
+			return err
+		}
+		batch.feeds = append(batch.feeds, feed)
+	}
+	if batch.ids, err = newIdFeeds(m, x, width, si.IdRange); err != nil {
+		log.Errorf("Unable to prepare id inputs %v", err)
+		return err
+	}
 
 	//vm
 	vm := m.Vm()
@@ -268,84 +746,17 @@ func Predict(m Model, numExamples, batchSize int, si *rcmd.SampleInfo, inputs te
 			end = numExamples
 		}
 
-		var (
-			xUserProfileVal   tensor.Tensor
-			xUserBehaviorsVal tensor.Tensor
-			xItemFeatureVal   tensor.Tensor
-			xCtxFeatureVal    tensor.Tensor
-		)
-
-		if xUserProfileVal, err = inputs.Slice([]tensor.Slice{G.S(start, end), G.S(si.UserProfileRange[0], si.UserProfileRange[1])}...); err != nil {
-			log.Errorf("Unable to slice xUserProfileVal %v", err)
-			return nil, err
-		}
-		if xUserProfileVal.Shape()[0] < batchSize {
-			if xUserProfileVal, err = FillTensorRows(batchSize, xUserProfileVal); err != nil {
-				log.Errorf("Unable to fill input rows %v", err)
-				return nil, err
-			}
-		}
-		if err = G.Let(xUserProfile, xUserProfileVal); err != nil {
-			log.Errorf("Unable to let xUserProfileVal %v", err)
-			return nil, err
-		}
-
-		if xUserBehaviorsVal, err = inputs.Slice([]tensor.Slice{G.S(start, end), G.S(si.UserBehaviorRange[0], si.UserBehaviorRange[1])}...); err != nil {
-			log.Errorf("Unable to slice xUserBehaviorsVal %v", err)
-			return nil, err
-		}
-		if xUserBehaviorsVal.Shape()[0] < batchSize {
-			if xUserBehaviorsVal, err = FillTensorRows(batchSize, xUserBehaviorsVal); err != nil {
-				log.Errorf("Unable to fill input rows %v", err)
-				return nil, err
-			}
-		}
-		if err = G.Let(xUbMatrix, xUserBehaviorsVal); err != nil {
-			log.Errorf("Unable to let xUserBehaviorsVal %v", err)
-			return nil, err
-		}
-
-		if xItemFeatureVal, err = inputs.Slice([]tensor.Slice{G.S(start, end), G.S(si.ItemFeatureRange[0], si.ItemFeatureRange[1])}...); err != nil {
-			log.Errorf("Unable to slice xItemFeatureVal %v", err)
-			return nil, err
-		}
-		if xItemFeatureVal.Shape()[0] < batchSize {
-			if xItemFeatureVal, err = FillTensorRows(batchSize, xItemFeatureVal); err != nil {
-				log.Errorf("Unable to fill input rows %v", err)
-				return nil, err
-			}
-		}
-		if err = G.Let(xItemFeature, xItemFeatureVal); err != nil {
-			log.Errorf("Unable to let xItemFeatureVal %v", err)
-			return nil, err
-		}
-
-		if xCtxFeatureVal, err = inputs.Slice([]tensor.Slice{G.S(start, end), G.S(si.CtxFeatureRange[0], si.CtxFeatureRange[1])}...); err != nil {
-			log.Errorf("Unable to slice xCtxFeatureVal %v", err)
-			return nil, err
-		}
-		if xCtxFeatureVal.Shape()[0] < batchSize {
-			if xCtxFeatureVal, err = FillTensorRows(batchSize, xCtxFeatureVal); err != nil {
-				log.Errorf("Unable to fill input rows %v", err)
-				return nil, err
-			}
-		}
-		if err = G.Let(xCtxFeature, xCtxFeatureVal); err != nil {
-			log.Errorf("Unable to let xCtxFeatureVal %v", err)
-			return nil, err
+		if err = batch.let(start, end); err != nil {
+			log.Errorf("Unable to let inputs %v", err)
+			return err
 		}
 
 		if err = vm.RunAll(); err != nil {
 			log.Errorf("Failed at batch %d. Error: %v", b, err)
-			return nil, err
+			return err
 		}
 
-		//get y
-		yVal := outputNode.Value().Data().([]float32)
-		for i := 0; i < end-start; i++ {
-			y = append(y, yVal[i])
-		}
-		//y = append(y, yVal...)
+		read(start, end)
 		vm.Reset()
 	}
 	return
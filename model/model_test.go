@@ -1,17 +1,28 @@
 package model_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"math"
 	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/bias"
+	"github.com/auxten/go-ctr/model/bst"
 	"github.com/auxten/go-ctr/model/din"
+	"github.com/auxten/go-ctr/model/layers"
+	"github.com/auxten/go-ctr/model/pnn"
 	"github.com/auxten/go-ctr/model/youtube"
 	rcmd "github.com/auxten/go-ctr/recommend"
 	"github.com/auxten/go-ctr/utils"
 	log "github.com/sirupsen/logrus"
 	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
 	"gorgonia.org/tensor"
 )
 
@@ -145,3 +156,1294 @@ func TestMultiModel(t *testing.T) {
 		So(auc, ShouldBeGreaterThan, 0.5)
 	})
 }
+
+// test dims shared by the small model tests
+const (
+	tUProfileDim   = 5
+	tUBehaviorSize = 3
+	tUBehaviorDim  = 7
+	tIFeatureDim   = 7
+	tCFeatureDim   = 5
+	tInputWidth    = tUProfileDim + tUBehaviorSize*tUBehaviorDim + tIFeatureDim + tCFeatureDim
+)
+
+// newSmallSample returns random inputs and labels of numExamples samples
+func newSmallSample(numExamples int) (sampleInfo *rcmd.SampleInfo, inputs, labels tensor.Tensor) {
+	sampleInfo = &rcmd.SampleInfo{
+		UserProfileRange:  [2]int{0, tUProfileDim},
+		UserBehaviorRange: [2]int{tUProfileDim, tUProfileDim + tUBehaviorSize*tUBehaviorDim},
+		ItemFeatureRange:  [2]int{tUProfileDim + tUBehaviorSize*tUBehaviorDim, tUProfileDim + tUBehaviorSize*tUBehaviorDim + tIFeatureDim},
+		CtxFeatureRange:   [2]int{tUProfileDim + tUBehaviorSize*tUBehaviorDim + tIFeatureDim, tInputWidth},
+	}
+	inputSlice := make([]float32, numExamples*tInputWidth)
+	for i := range inputSlice {
+		inputSlice[i] = rand.Float32()
+	}
+	labelSlice := make([]float32, numExamples)
+	for i := range labelSlice {
+		labelSlice[i] = float32(rand.Intn(2))
+	}
+	inputs = tensor.New(tensor.WithShape(numExamples, tInputWidth), tensor.WithBacking(inputSlice))
+	labels = tensor.New(tensor.WithShape(numExamples, 1), tensor.WithBacking(labelSlice))
+	return
+}
+
+func TestDropoutDisabledAtInference(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 10
+		numExamples = 20
+	)
+	sampleInfo, inputs, _ := newSmallSample(numExamples)
+
+	for name, m := range map[string]model.Model{
+		"Din":         din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim),
+		"Youtube DNN": youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim),
+		"PNN":         pnn.NewPnnNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim),
+		"BST":         bst.NewBstNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, bst.WithHeads(1)),
+	} {
+		Convey(name+" predict is deterministic", t, func() {
+			err := model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, batchSize, m)
+			So(err, ShouldBeNil)
+			pred1, err := model.Predict(m, numExamples, batchSize, sampleInfo, inputs)
+			So(err, ShouldBeNil)
+			pred2, err := model.Predict(m, numExamples, batchSize, sampleInfo, inputs)
+			So(err, ShouldBeNil)
+			So(pred1, ShouldResemble, pred2)
+		})
+	}
+}
+
+func TestDropoutOption(t *testing.T) {
+	batchSize := 10
+	fwdNodes := func(m model.Model, training bool) int {
+		g := m.Graph()
+		xUserProfile := G.NewMatrix(g, model.DT, G.WithShape(batchSize, tUProfileDim), G.WithName("xUserProfile"))
+		xUbMatrix := G.NewMatrix(g, model.DT, G.WithShape(batchSize, tUBehaviorSize*tUBehaviorDim), G.WithName("xUserBehaviorMatrix"))
+		xItemFeature := G.NewMatrix(g, model.DT, G.WithShape(batchSize, tIFeatureDim), G.WithName("xItemFeature"))
+		xCtxFeature := G.NewMatrix(g, model.DT, G.WithShape(batchSize, tCFeatureDim), G.WithName("xCtxFeature"))
+		m.SetTraining(training)
+		So(m.Fwd(xUserProfile, xUbMatrix, xItemFeature, xCtxFeature, batchSize, tUBehaviorSize, tUBehaviorDim), ShouldBeNil)
+		return len(g.AllNodes())
+	}
+
+	Convey("Din zero dropout builds the inference graph", t, func() {
+		evalNodes := fwdNodes(din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim), false)
+		So(fwdNodes(din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim), true),
+			ShouldBeGreaterThan, evalNodes)
+		So(fwdNodes(din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			din.WithDropout(0, 0)), true), ShouldEqual, evalNodes)
+	})
+
+	Convey("Youtube DNN zero dropout builds the inference graph", t, func() {
+		evalNodes := fwdNodes(youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim), false)
+		So(fwdNodes(youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim), true),
+			ShouldBeGreaterThan, evalNodes)
+		So(fwdNodes(youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			youtube.WithDropout(0, 0)), true), ShouldEqual, evalNodes)
+	})
+}
+
+func TestTrainWithRegularization(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 50
+		numExamples = 200
+		mlp0Rows    = tUProfileDim + tUBehaviorDim + tIFeatureDim + tCFeatureDim
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+
+	rowFreq := make([]float32, mlp0Rows)
+	for i := range rowFreq {
+		rowFreq[i] = float32(numExamples)
+	}
+	Convey("Train with L1, L2 and mini-batch aware regularization", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithRegularization(
+				model.Regularization{
+					Nodes:   []string{"mlp0"},
+					L2:      1e-4,
+					RowFreq: rowFreq,
+					BatchRows: func(start, end int) []int {
+						return []int{0, 1, 2}
+					},
+				},
+				model.Regularization{L1: 1e-5, L2: 1e-4},
+			),
+		)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Train with bad regularization config", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithRegularization(model.Regularization{Nodes: []string{"notExist"}, L2: 1e-4}),
+		)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDataParallel(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 20
+		numExamples = 190
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	newDnn := func() model.Model {
+		return youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+	}
+
+	Convey("Train on 3 replicas", t, func() {
+		var replicas []model.Model
+		m := newDnn()
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithDataParallel(3, func() model.Model {
+				r := newDnn()
+				replicas = append(replicas, r)
+				return r
+			}),
+		)
+		So(err, ShouldBeNil)
+		So(replicas, ShouldHaveLength, 2)
+		// the replicas step with the trained Model
+		for _, r := range replicas {
+			for j, n := range r.Learnable() {
+				So(n.Value().Data(), ShouldResemble, m.Learnable()[j].Value().Data())
+				grad, err := n.Grad()
+				So(err, ShouldBeNil)
+				for _, v := range grad.Data().([]float32) {
+					So(v, ShouldEqual, 0)
+				}
+			}
+		}
+
+		data, err := m.Marshal()
+		So(err, ShouldBeNil)
+		pred, err := youtube.NewYoutubeDnnFromJson(data)
+		So(err, ShouldBeNil)
+		err = model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, batchSize, pred)
+		So(err, ShouldBeNil)
+		predictions, err := model.Predict(pred, numExamples, batchSize, sampleInfo, inputs)
+		So(err, ShouldBeNil)
+		So(predictions, ShouldHaveLength, numExamples)
+	})
+
+	Convey("Data parallel without replica factory", t, func() {
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, labels,
+			newDnn(),
+			model.WithDataParallel(2, nil),
+		)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Replica sharing the trained graph", t, func() {
+		m := newDnn()
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithDataParallel(2, func() model.Model { return m }),
+		)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestGradientAccumulation(t *testing.T) {
+	var (
+		batchSize   = 20
+		numExamples = 190
+	)
+	rand.Seed(42)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	newDnn := func() model.Model {
+		return youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			youtube.WithDropout(0, 0))
+	}
+	// the models initialized by the same weights
+	initial := newDnn()
+	newInitial := func() model.Model {
+		m := newDnn()
+		for j, n := range m.Learnable() {
+			copy(n.Value().Data().([]float32), initial.Learnable()[j].Value().Data().([]float32))
+		}
+		return m
+	}
+
+	Convey("Accumulate 3 micro-batches as 3 replicas", t, func() {
+		accumulated := newInitial()
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			accumulated,
+			model.WithGradientAccumulation(3),
+		)
+		So(err, ShouldBeNil)
+
+		parallel := newInitial()
+		err = model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			parallel,
+			model.WithDataParallel(3, newDnn),
+		)
+		So(err, ShouldBeNil)
+		// both step by the mean gradients of the same batches
+		for j, n := range accumulated.Learnable() {
+			want := parallel.Learnable()[j].Value().Data().([]float32)
+			for k, v := range n.Value().Data().([]float32) {
+				So(v, ShouldAlmostEqual, want[k], 1e-5)
+			}
+		}
+	})
+
+	Convey("Accumulate with data parallel", t, func() {
+		m := newInitial()
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithGradientAccumulation(2),
+			model.WithDataParallel(2, newDnn),
+		)
+		So(err, ShouldBeNil)
+		So(m.Learnable()[0].Value().Data(), ShouldNotResemble, initial.Learnable()[0].Value().Data())
+	})
+}
+
+func TestCheckpointResume(t *testing.T) {
+	var (
+		batchSize   = 20
+		numExamples = 190
+		epochs      = 2
+	)
+	rand.Seed(42)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	newDnn := func() model.Model {
+		return youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			youtube.WithDropout(0, 0))
+	}
+	train := func(m model.Model, batchSize int, opts ...model.TrainOption) error {
+		return model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, epochs, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			append(opts, model.WithShuffle(3))...,
+		)
+	}
+
+	full := newDnn()
+	var checkpoints []*model.Checkpoint
+	Convey("Train with checkpoints", t, func() {
+		var costs []float32
+		err := train(full, batchSize, model.WithCheckpoint(4, func(cp *model.Checkpoint) error {
+			checkpoints = append(checkpoints, cp)
+			return nil
+		}), model.WithEpochEnd(func(epoch int, cost float32) {
+			So(epoch, ShouldEqual, len(costs))
+			costs = append(costs, cost)
+		}))
+		So(err, ShouldBeNil)
+		So(costs, ShouldHaveLength, epochs)
+		// 10 batches of an epoch, checkpointed at step 4, 8 and the epoch end
+		So(checkpoints, ShouldHaveLength, 6)
+		So(checkpoints[0].Epoch, ShouldEqual, 0)
+		So(checkpoints[0].Batch, ShouldEqual, 4)
+		So(checkpoints[0].Solver.Iter, ShouldEqual, 4)
+		So(checkpoints[2].Epoch, ShouldEqual, 1)
+		So(checkpoints[2].Batch, ShouldEqual, 0)
+		So(checkpoints[5].Steps, ShouldEqual, 20)
+	})
+
+	Convey("Resume bitwise the same", t, func() {
+		path := filepath.Join(t.TempDir(), "checkpoint.json")
+		for _, i := range []int{0, 2, 4} {
+			So(checkpoints[i].Save(path), ShouldBeNil)
+			cp, err := model.LoadCheckpoint(path)
+			So(err, ShouldBeNil)
+
+			resumed := newDnn()
+			So(train(resumed, batchSize, model.WithResume(cp)), ShouldBeNil)
+			for j, n := range resumed.Learnable() {
+				So(n.Value().Data(), ShouldResemble, full.Learnable()[j].Value().Data())
+			}
+		}
+	})
+
+	Convey("Resume by other batch size", t, func() {
+		err := train(newDnn(), batchSize*2, model.WithResume(checkpoints[0]))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Resume by other model", t, func() {
+		err := train(pnn.NewPnnNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim),
+			batchSize, model.WithResume(checkpoints[0]))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Resume after the batch size resized by the memory cap", t, func() {
+		// the checkpoint of the batch size 20 switched to 10
+		resized := *checkpoints[0]
+		resized.ResizedBatchSize = batchSize / 2
+		var saved []*model.Checkpoint
+		resumed := newDnn()
+		err := train(resumed, batchSize, model.WithResume(&resized),
+			model.WithMemoryCap(1<<62, newDnn),
+			model.WithCheckpoint(4, func(cp *model.Checkpoint) error {
+				saved = append(saved, cp)
+				return nil
+			}),
+		)
+		So(err, ShouldBeNil)
+		So(saved, ShouldNotBeEmpty)
+		for _, cp := range saved {
+			So(cp.BatchSize, ShouldEqual, batchSize)
+			So(cp.ResizedBatchSize, ShouldEqual, batchSize/2)
+		}
+
+		// the same as Train of the batch size 10 resumed
+		small := *checkpoints[0]
+		small.BatchSize = batchSize / 2
+		m := newDnn()
+		So(train(m, batchSize/2, model.WithResume(&small)), ShouldBeNil)
+		for j, n := range resumed.Learnable() {
+			So(n.Value().Data(), ShouldResemble, m.Learnable()[j].Value().Data())
+		}
+
+		So(train(newDnn(), batchSize, model.WithResume(&resized)), ShouldNotBeNil)
+		resized.ResizedBatchSize = batchSize
+		So(train(newDnn(), batchSize, model.WithResume(&resized), model.WithMemoryCap(1<<62, newDnn)), ShouldNotBeNil)
+	})
+}
+
+func TestListwiseObjective(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 20
+		slateSize   = 5
+		numExamples = 600
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	// the item of the max first item feature is clicked in every slate
+	var (
+		x      = inputs.Data().([]float32)
+		y      = labels.Data().([]float32)
+		slates = make([]int, numExamples)
+		col    = sampleInfo.ItemFeatureRange[0]
+	)
+	for i := 0; i < numExamples; i += slateSize {
+		best := i
+		for j := i; j < i+slateSize; j++ {
+			slates[j] = i / slateSize
+			y[j] = 0
+			if x[j*tInputWidth+col] > x[best*tInputWidth+col] {
+				best = j
+			}
+		}
+		y[best] = 1
+	}
+
+	Convey("Train the slate softmax", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 20, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithSlates(slates),
+		)
+		So(err, ShouldBeNil)
+
+		data, err := m.Marshal()
+		So(err, ShouldBeNil)
+		pred, err := youtube.NewYoutubeDnnFromJson(data)
+		So(err, ShouldBeNil)
+		err = model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, batchSize, pred)
+		So(err, ShouldBeNil)
+		scores, err := model.Predict(pred, numExamples, batchSize, sampleInfo, inputs)
+		So(err, ShouldBeNil)
+		// the clicked item is ranked first in most slates, 1/5 by chance
+		var hits int
+		for i := 0; i < numExamples; i += slateSize {
+			top := i
+			for j := i; j < i+slateSize; j++ {
+				if scores[j] > scores[top] {
+					top = j
+				}
+			}
+			if y[top] == 1 {
+				hits++
+			}
+		}
+		So(float64(hits)/float64(numExamples/slateSize), ShouldBeGreaterThan, 0.5)
+	})
+
+	Convey("Train the batches as slates", t, func() {
+		m := din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithObjective(model.ListwiseObjective),
+		)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Slates of other rows", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithSlates(slates[1:]),
+		)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRegressionObjective(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 50
+		numExamples = 200
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	ratings := labels.Clone().(tensor.Tensor)
+	for i, l := range ratings.Data().([]float32) {
+		ratings.Data().([]float32)[i] = 1 + 4*l
+	}
+
+	for _, objective := range []model.Objective{model.MSEObjective, model.HuberObjective} {
+		Convey("Train rating by "+objective.String()+" on the linear head", t, func() {
+			m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+			err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+				numExamples, batchSize, 5, 0,
+				sampleInfo,
+				inputs, ratings,
+				m,
+				model.WithObjective(objective),
+			)
+			So(err, ShouldBeNil)
+
+			// the head is restored from json
+			data, err := m.Marshal()
+			So(err, ShouldBeNil)
+			pred, err := youtube.NewYoutubeDnnFromJson(data)
+			So(err, ShouldBeNil)
+			err = model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, batchSize, pred)
+			So(err, ShouldBeNil)
+			predictions, err := model.Predict(pred, numExamples, batchSize, sampleInfo, inputs)
+			So(err, ShouldBeNil)
+			var max float32
+			for _, p := range predictions {
+				if p > max {
+					max = p
+				}
+			}
+			So(max, ShouldBeGreaterThan, 1)
+			So(utils.RMSE32(predictions, ratings.Data().([]float32)), ShouldBeLessThan, 4)
+		})
+	}
+
+	Convey("Train by unknown objective", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, ratings,
+			m,
+			model.WithObjective(model.Objective(42)),
+		)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMultiClassObjective(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 50
+		numExamples = 200
+		classes     = 3
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	tiers := labels.Clone().(tensor.Tensor)
+	for i, l := range tiers.Data().([]float32) {
+		tiers.Data().([]float32)[i] = float32(i%2) + l
+	}
+
+	for _, objective := range []model.Objective{model.SoftmaxObjective, model.OrdinalObjective} {
+		Convey("Train tiers by "+objective.String(), t, func() {
+			outputs := objective.Outputs(classes)
+			m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+				youtube.WithOutputs(outputs))
+			err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+				numExamples, batchSize, 3, 0,
+				sampleInfo,
+				inputs, tiers,
+				m,
+				model.WithClasses(objective, classes),
+			)
+			So(err, ShouldBeNil)
+
+			data, err := m.Marshal()
+			So(err, ShouldBeNil)
+			pred, err := youtube.NewYoutubeDnnFromJson(data)
+			So(err, ShouldBeNil)
+			err = model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, batchSize, pred)
+			So(err, ShouldBeNil)
+			predictions, err := model.Predict(pred, numExamples, batchSize, sampleInfo, inputs)
+			So(err, ShouldBeNil)
+			So(predictions, ShouldHaveLength, numExamples*outputs)
+			if objective == model.SoftmaxObjective {
+				var sum float32
+				for _, p := range predictions[:outputs] {
+					sum += p
+				}
+				So(sum, ShouldAlmostEqual, 1, 0.0001)
+			}
+			decoded := model.DecodeClasses(objective, classes, predictions)
+			So(decoded, ShouldHaveLength, numExamples)
+			for _, c := range decoded {
+				So(c, ShouldBeBetweenOrEqual, 0, classes-1)
+			}
+		})
+	}
+
+	Convey("Output width mismatch", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, tiers,
+			m,
+			model.WithClasses(model.SoftmaxObjective, classes),
+		)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMixedPrecision(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 50
+		numExamples = 200
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	train := func(opts ...model.TrainOption) (m model.Model, costs []float32) {
+		m = youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			youtube.WithDropout(0, 0))
+		fillGoldenWeights(m, rand.New(rand.NewSource(goldenSeed)))
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			append(opts, model.WithEpochEnd(func(_ int, cost float32) {
+				costs = append(costs, cost)
+			}))...,
+		)
+		So(err, ShouldBeNil)
+		return
+	}
+
+	Convey("Mixed precision trains close to float32", t, func() {
+		full, fullCosts := train()
+		mixed, mixedCosts := train(model.WithMixedPrecision())
+		So(mixedCosts, ShouldHaveLength, 2)
+		for i, cost := range mixedCosts {
+			So(cost, ShouldAlmostEqual, fullCosts[i], 0.05)
+		}
+		// the trained weights are the float32 masters, not the float16 copy
+		var differ, fractional bool
+		for i, n := range mixed.Learnable() {
+			want := full.Learnable()[i].Value().Data().([]float32)
+			for k, v := range n.Value().Data().([]float32) {
+				differ = differ || v != want[k]
+				// float16 holds 11 significant bits
+				if frac, _ := math.Frexp(float64(v)); v != 0 && frac*(1<<11) != math.Trunc(frac*(1<<11)) {
+					fractional = true
+				}
+			}
+		}
+		So(differ, ShouldBeTrue)
+		So(fractional, ShouldBeTrue)
+	})
+}
+
+func TestLabelSmoothing(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 50
+		numExamples = 200
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+
+	Convey("Train the smoothed labels", t, func() {
+		var costs []float32
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithLabelSmoothing(0.1),
+			model.WithEpochEnd(func(_ int, cost float32) {
+				costs = append(costs, cost)
+			}),
+		)
+		So(err, ShouldBeNil)
+		So(costs, ShouldHaveLength, 2)
+		// the entropy of the smoothed labels is the min of the loss
+		for _, cost := range costs {
+			So(cost, ShouldBeGreaterThan, 0.19)
+		}
+		So(labels.Data().([]float32), ShouldContain, float32(1))
+	})
+
+	Convey("Label smoothing of the regression", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		for _, opts := range [][]model.TrainOption{
+			{model.WithObjective(model.MSEObjective), model.WithLabelSmoothing(0.1)},
+			{model.WithLabelSmoothing(-0.1)},
+		} {
+			err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+				numExamples, batchSize, 1, 0,
+				sampleInfo,
+				inputs, labels,
+				m,
+				opts...,
+			)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestHardSampleDump(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 50
+		numExamples = 200
+		n           = 5
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	path := filepath.Join(t.TempDir(), "hard.jsonl")
+	names := rcmd.FeatureColumnNames(sampleInfo, nil, nil)
+
+	Convey("Dump the highest loss samples of every epoch", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithHardSampleDump(path, n, names),
+		)
+		So(err, ShouldBeNil)
+
+		f, err := os.Open(path)
+		So(err, ShouldBeNil)
+		defer f.Close()
+		var (
+			samples []model.HardSample
+			dec     = json.NewDecoder(f)
+		)
+		for dec.More() {
+			var sample model.HardSample
+			So(dec.Decode(&sample), ShouldBeNil)
+			samples = append(samples, sample)
+		}
+		So(samples, ShouldHaveLength, 2*n)
+		for i, sample := range samples {
+			So(sample.Epoch, ShouldEqual, i/n)
+			if i%n != 0 {
+				So(sample.Loss, ShouldBeLessThanOrEqualTo, samples[i-1].Loss)
+			}
+			So(sample.Target, ShouldResemble, []float32{labels.Data().([]float32)[sample.Row]})
+			So(sample.Features, ShouldHaveLength, tInputWidth)
+			So(sample.Features[0].Name, ShouldEqual, names[0])
+			So(sample.Features[tInputWidth-1].Value, ShouldEqual, inputs.Data().([]float32)[(sample.Row+1)*tInputWidth-1])
+			// the binary cross entropy of the prediction, unless of the
+			// sigmoid saturated of the float32
+			if p, y := float64(sample.Prediction[0]), float64(sample.Target[0]); p > 1e-4 && p < 1-1e-4 {
+				So(sample.Loss, ShouldAlmostEqual, -y*math.Log(p)-(1-y)*math.Log(1-p), 1e-3)
+			}
+		}
+	})
+
+	Convey("Dump of no samples", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithHardSampleDump(path, 0, nil),
+		)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestEvaluation(t *testing.T) {
+	rand.Seed(42)
+	var (
+		batchSize   = 50
+		numExamples = 200
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	_, validInputs, validLabels := newSmallSample(100)
+	newDnn := func() model.Model {
+		return youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+	}
+	train := func(m model.Model, epochs, earlyStop int, conf model.EvalConfig, report *model.TrainReport) error {
+		return model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, epochs, earlyStop,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithEvaluation(validInputs, validLabels, newDnn, conf),
+			model.WithTrainReport(report),
+		)
+	}
+
+	Convey("Evaluate every 2 batches of the subset with the learning rate scheduled", t, func() {
+		var report model.TrainReport
+		err := train(newDnn(), 2, 0, model.EvalConfig{
+			Every:  2,
+			Subset: 30,
+			Seed:   7,
+			OnEvaluation: func(ev *model.Evaluation) {
+				ev.LearnRate /= 2
+			},
+		}, &report)
+		So(err, ShouldBeNil)
+		So(report.Evaluations, ShouldHaveLength, 4)
+		learnRate := 0.005
+		for i, ev := range report.Evaluations {
+			So(ev.Epoch, ShouldEqual, i/2)
+			So(ev.Batch, ShouldEqual, 2*(i%2+1))
+			So(ev.Steps, ShouldEqual, 2*(i+1))
+			So(ev.Loss, ShouldBeGreaterThan, 0)
+			So(math.IsInf(float64(ev.Loss), 0) || math.IsNaN(float64(ev.Loss)), ShouldBeFalse)
+			// the learning rate halved by the scheduler
+			So(ev.LearnRate, ShouldAlmostEqual, learnRate)
+			learnRate /= 2
+		}
+	})
+
+	Convey("Early stop in the epoch on the validation loss", t, func() {
+		var report model.TrainReport
+		err := train(newDnn(), 3, 1, model.EvalConfig{
+			Every: 1,
+			OnEvaluation: func(ev *model.Evaluation) {
+				// diverge after the first evaluation
+				ev.LearnRate = 100
+			},
+		}, &report)
+		So(err, ShouldBeNil)
+		So(len(report.Evaluations), ShouldBeLessThan, 12)
+		So(report.Epochs, ShouldHaveLength, 1)
+	})
+
+	Convey("Evaluation of the trained model graph", t, func() {
+		m := newDnn()
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithEvaluation(validInputs, validLabels, func() model.Model { return m }, model.EvalConfig{}),
+		)
+		So(err, ShouldNotBeNil)
+
+		err = train(newDnn(), 1, 0, model.EvalConfig{Every: -1}, &model.TrainReport{})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// trainMarshalPredict trains m on a small sample, reloads it with fromJson
+// and predicts with the reloaded model
+func trainMarshalPredict(t *testing.T, name string, m model.Model, fromJson func([]byte) (model.Model, error)) {
+	rand.Seed(42)
+	var (
+		batchSize   = 50
+		numExamples = 200
+	)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+
+	Convey(name+" train", t, func() {
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+		)
+		So(err, ShouldBeNil)
+	})
+
+	Convey(name+" marshal, new from json and predict", t, func() {
+		data, err := m.Marshal()
+		So(err, ShouldBeNil)
+		pred, err := fromJson(data)
+		So(err, ShouldBeNil)
+		err = model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, batchSize, pred)
+		So(err, ShouldBeNil)
+		predictions, err := model.Predict(pred, numExamples, batchSize, sampleInfo, inputs)
+		So(err, ShouldBeNil)
+		So(predictions, ShouldHaveLength, numExamples)
+	})
+}
+
+func TestFitter(t *testing.T) {
+	rand.Seed(42)
+	const (
+		rows        = 60
+		uProfileDim = 3
+		cFeatureDim = 2
+	)
+	si, err := rcmd.NewSampleInfoBuilder().UserProfile(uProfileDim).UserBehavior(rcmd.UserBehaviorLen, rcmd.ItemEmbDim).
+		ItemFeature(rcmd.ItemEmbDim).CtxFeature(cFeatureDim).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := &rcmd.TrainSample{Rows: rows, XCols: si.Width(), Info: *si}
+	for i := 0; i < rows*si.Width(); i++ {
+		sample.X = append(sample.X, rand.Float32())
+	}
+	for i := 0; i < rows; i++ {
+		sample.Y = append(sample.Y, float32(i%2))
+	}
+
+	Convey("fit and predict by the forward only copy", t, func() {
+		var epochs int
+		fitter := &model.Fitter{
+			New: func(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) model.Model {
+				return din.NewDinNet(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim, din.WithDropout(0.1, 0.1))
+			},
+			Load:          func(data []byte) (model.Model, error) { return din.NewDinNetFromJson(data) },
+			BatchSize:     20,
+			Epochs:        2,
+			PredBatchSize: 16,
+			Options: []model.TrainOption{
+				model.WithLearningRate(0.001),
+				model.WithEpochEnd(func(int, float32) { epochs++ }),
+			},
+		}
+		pred, err := fitter.Fit(sample)
+		So(err, ShouldBeNil)
+		So(epochs, ShouldEqual, 2)
+		y := pred.Predict(tensor.New(tensor.WithShape(rows, si.Width()), tensor.WithBacking(sample.X)))
+		So(y.Shape(), ShouldResemble, tensor.Shape{rows, 1})
+
+		// the attention weights of the DIN behaviors
+		attention, ok := pred.(rcmd.BehaviorAttention)
+		So(ok, ShouldBeTrue)
+		weights, err := attention.BehaviorAttention(tensor.New(tensor.WithShape(rows, si.Width()), tensor.WithBacking(sample.X)))
+		So(err, ShouldBeNil)
+		So(weights.Shape(), ShouldResemble, tensor.Shape{rows, rcmd.UserBehaviorLen})
+		for _, w := range weights.Data().([]float32) {
+			So(w, ShouldBeBetween, 0, 1)
+		}
+		first, err := attention.BehaviorAttention(tensor.New(tensor.WithShape(1, si.Width()), tensor.WithBacking(sample.X[:si.Width()])))
+		So(err, ShouldBeNil)
+		So(first.Data(), ShouldResemble, weights.Data().([]float32)[:rcmd.UserBehaviorLen])
+
+		sample.Y = sample.Y[1:]
+		_, err = fitter.Fit(sample)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestBiasedModel(t *testing.T) {
+	rand.Seed(42)
+	const (
+		batchSize   = 50
+		numExamples = 210
+		userRows    = 5
+		itemRows    = 3
+		width       = tInputWidth + rcmd.BiasColumns
+	)
+	// the features are noise, the users of the odd rows click 90% and the
+	// others 10%, the last batch is filled by the rows of id 0
+	sampleInfo, features, _ := newSmallSample(numExamples)
+	sampleInfo.IdRange = [2]int{tInputWidth, width}
+	var (
+		x = make([]float32, numExamples*width)
+		y = make([]float32, numExamples)
+	)
+	for i := 0; i < numExamples; i++ {
+		row := x[i*width : (i+1)*width]
+		copy(row, features.Data().([]float32)[i*tInputWidth:(i+1)*tInputWidth])
+		user := 1 + i%(userRows-1)
+		row[tInputWidth], row[tInputWidth+1] = float32(user), float32(1+i%(itemRows-1))
+		if rand.Float32() < 0.1 != (user%2 == 1) {
+			y[i] = 1
+		}
+	}
+	inputs := tensor.New(tensor.WithShape(numExamples, width), tensor.WithBacking(x))
+	labels := tensor.New(tensor.WithShape(numExamples, 1), tensor.WithBacking(y))
+	newBiased := func() *bias.BiasedModel {
+		return bias.NewBiasedModel(youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim), userRows, itemRows)
+	}
+
+	Convey("the biases are trained with the model", t, func() {
+		m := newBiased()
+		So(m.Learnable(), ShouldHaveLength, len(m.Inner().Learnable())+2)
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 20, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+		)
+		So(err, ShouldBeNil)
+		user, item := m.Biases()
+		So(user, ShouldHaveLength, userRows)
+		So(item, ShouldHaveLength, itemRows)
+		// the reserved row of the unseen ids keeps 0
+		So(user[0], ShouldEqual, 0)
+		So(item[0], ShouldEqual, 0)
+		So(user[1], ShouldBeGreaterThan, user[2])
+		So(user[3], ShouldBeGreaterThan, user[4])
+
+		data, err := m.Marshal()
+		So(err, ShouldBeNil)
+		pred, err := bias.NewBiasedModelFromJson(data, func(data []byte) (model.Model, error) {
+			return youtube.NewYoutubeDnnFromJson(data)
+		})
+		So(err, ShouldBeNil)
+		So(model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, batchSize, pred), ShouldBeNil)
+		predictions, err := model.Predict(pred, numExamples, batchSize, sampleInfo, inputs)
+		So(err, ShouldBeNil)
+		So(utils.RocAuc32(predictions, y), ShouldBeGreaterThan, 0.8)
+
+		// the unseen user is not biased
+		unseen := append([]float32(nil), x[:width]...)
+		unseen[tInputWidth] = 0
+		yUnseen, err := model.Predict(pred, 1, batchSize, sampleInfo, tensor.New(tensor.WithShape(1, width), tensor.WithBacking(unseen)))
+		So(err, ShouldBeNil)
+		So(yUnseen[0], ShouldBeBetween, predictions[2], predictions[1])
+
+		// the ids out of the tables or of no IdRange
+		unseen[tInputWidth] = userRows
+		_, err = model.Predict(pred, 1, batchSize, sampleInfo, tensor.New(tensor.WithShape(1, width), tensor.WithBacking(unseen)))
+		So(err, ShouldNotBeNil)
+		noIds := *sampleInfo
+		noIds.IdRange = [2]int{}
+		_, err = model.Predict(pred, numExamples, batchSize, &noIds, inputs)
+		So(err, ShouldNotBeNil)
+		So(model.ValidateShapes(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, &noIds, inputs, labels, newBiased()), ShouldNotBeNil)
+
+		_, err = bias.NewBiasedModelFromJson([]byte(`{"inner":{},"userRows":2,"user":[0]}`), nil)
+		So(err, ShouldNotBeNil)
+
+		// the bias rows are exported and imported as the embedding tables
+		So(pred.EmbeddingTables(), ShouldResemble, []string{"user", "item"})
+		var buf bytes.Buffer
+		So(layers.ExportEmbeddings(&buf, pred, "user", nil), ShouldBeNil)
+		So(strings.Count(buf.String(), "\n"), ShouldEqual, userRows)
+		updated, err := layers.ImportEmbeddings(strings.NewReader("1 0.5\n"), pred, "user", nil)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 1)
+		user, _ = pred.Biases()
+		So(user[1], ShouldEqual, 0.5)
+		So(layers.ExportEmbeddings(&buf, pred, "category", nil), ShouldNotBeNil)
+	})
+}
+
+func TestPnn(t *testing.T) {
+	for _, productType := range []pnn.ProductType{pnn.InnerProduct, pnn.OuterProduct} {
+		trainMarshalPredict(t, "PNN "+string(productType),
+			pnn.NewPnnNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+				pnn.WithProductType(productType), pnn.WithFieldDim(4)),
+			func(data []byte) (model.Model, error) {
+				return pnn.NewPnnNetFromJson(data)
+			},
+		)
+	}
+	Convey("PNN dropout is restored from json", t, func() {
+		dropout := func(m *pnn.PnnNet) []float32 {
+			data, err := m.Marshal()
+			So(err, ShouldBeNil)
+			var fields map[string]json.RawMessage
+			So(json.Unmarshal(data, &fields), ShouldBeNil)
+			var d []float32
+			So(json.Unmarshal(fields["dropout"], &d), ShouldBeNil)
+			return d
+		}
+		m := pnn.NewPnnNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, pnn.WithDropout(0, 0.3))
+		data, err := m.Marshal()
+		So(err, ShouldBeNil)
+		loaded, err := pnn.NewPnnNetFromJson(data)
+		So(err, ShouldBeNil)
+		So(dropout(loaded), ShouldResemble, []float32{0, 0.3})
+
+		// the models saved before the dropout get the defaults
+		var fields map[string]json.RawMessage
+		So(json.Unmarshal(data, &fields), ShouldBeNil)
+		delete(fields, "dropout")
+		old, err := json.Marshal(fields)
+		So(err, ShouldBeNil)
+		loaded, err = pnn.NewPnnNetFromJson(old)
+		So(err, ShouldBeNil)
+		So(dropout(loaded), ShouldResemble, dropout(pnn.NewPnnNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)))
+
+		fields["dropout"] = json.RawMessage(`[0.1]`)
+		bad, err := json.Marshal(fields)
+		So(err, ShouldBeNil)
+		_, err = pnn.NewPnnNetFromJson(bad)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestBst(t *testing.T) {
+	trainMarshalPredict(t, "BST",
+		bst.NewBstNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			bst.WithHeads(1), bst.WithBlocks(2)),
+		func(data []byte) (model.Model, error) {
+			return bst.NewBstNetFromJson(data)
+		},
+	)
+}
+
+func TestDinLatentCross(t *testing.T) {
+	m := din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, din.WithLatentCross())
+	trainMarshalPredict(t, "Din latent cross", m,
+		func(data []byte) (model.Model, error) {
+			var weights struct {
+				LatentCross bool      `json:"latentCross"`
+				Lc0         []float32 `json:"lc0"`
+			}
+			if err := json.Unmarshal(data, &weights); err != nil {
+				return nil, err
+			}
+			So(weights.LatentCross, ShouldBeTrue)
+			// the gate weights are trained off the identity
+			So(weights.Lc0, ShouldNotResemble, make([]float32, len(weights.Lc0)))
+			return din.NewDinNetFromJson(data)
+		},
+	)
+}
+
+func TestSummary(t *testing.T) {
+	Convey("summary of youtube dnn", t, func() {
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		s := model.Summary(m)
+		So(s.Layers, ShouldHaveLength, 3)
+		So(s.Params, ShouldEqual, (tUProfileDim+tUBehaviorDim+tIFeatureDim+tCFeatureDim)*200+200*80+80)
+		So(s.FLOPs, ShouldEqual, 0)
+
+		batchSize := 4
+		err := model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, batchSize, m)
+		So(err, ShouldBeNil)
+		s = model.Summary(m)
+		So(s.BatchSize, ShouldEqual, batchSize)
+		// matrix multiplications of the MLP alone cost 2 * params per sample
+		So(s.FLOPs, ShouldBeGreaterThan, 2*s.Params*batchSize)
+		So(s.String(), ShouldContainSubstring, "mlp0")
+		So(s.String(), ShouldContainSubstring, "Total params")
+	})
+}
+
+func TestExportGraph(t *testing.T) {
+	m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+	err := model.InitForwardOnlyVm(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim, 2, m)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	dir := t.TempDir()
+
+	Convey("export dot", t, func() {
+		path := filepath.Join(dir, "youtube.dot")
+		So(model.ExportGraph(m, path, model.GraphDOT), ShouldBeNil)
+		data, err := os.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldStartWith, "digraph")
+		So(string(data), ShouldContainSubstring, "Shape")
+	})
+
+	Convey("export svg", t, func() {
+		path := filepath.Join(dir, "youtube.svg")
+		err := model.ExportGraph(m, path, model.GraphSVG)
+		if _, lookErr := exec.LookPath("dot"); lookErr != nil {
+			So(err, ShouldNotBeNil)
+			return
+		}
+		So(err, ShouldBeNil)
+	})
+
+	Convey("unknown format", t, func() {
+		So(model.ExportGraph(m, filepath.Join(dir, "youtube.png"), "png"), ShouldNotBeNil)
+	})
+}
+
+func TestValidateShapes(t *testing.T) {
+	numExamples := 20
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+
+	Convey("valid shapes", t, func() {
+		err := model.ValidateShapes(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, 10, sampleInfo, inputs, labels,
+			din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim))
+		So(err, ShouldBeNil)
+	})
+
+	Convey("all mismatches are aggregated", t, func() {
+		badInfo := *sampleInfo
+		badInfo.CtxFeatureRange[1]++
+		m := din.NewDinNet(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		err := model.ValidateShapes(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim+1, tCFeatureDim,
+			numExamples+1, 0, &badInfo, inputs, labels, m)
+		So(err, ShouldNotBeNil)
+		shapeErr, ok := err.(*model.ShapeError)
+		So(ok, ShouldBeTrue)
+		// uBehaviorDim != iFeatureDim, batchSize, inputs rows, targets rows,
+		// ItemFeatureRange width, CtxFeatureRange width and inputs width
+		So(shapeErr.Mismatches, ShouldHaveLength, 7)
+	})
+
+	Convey("train returns the shape error", t, func() {
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim+1,
+			numExamples, 10, 1, 0,
+			sampleInfo,
+			inputs, labels,
+			youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim+1),
+		)
+		So(err, ShouldHaveSameTypeAs, &model.ShapeError{})
+	})
+}
+
+func TestBundle(t *testing.T) {
+	Convey("marshal and unmarshal bundle", t, func() {
+		sampleInfo, _, _ := newSmallSample(1)
+		m := youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim)
+		data, err := model.MarshalBundle(m, sampleInfo)
+		So(err, ShouldBeNil)
+		si, modelData, err := model.UnmarshalBundle(data)
+		So(err, ShouldBeNil)
+		So(si, ShouldResemble, sampleInfo)
+		_, err = youtube.NewYoutubeDnnFromJson(modelData)
+		So(err, ShouldBeNil)
+		manifest, err := model.BundleManifest(data)
+		So(err, ShouldBeNil)
+		So(manifest, ShouldBeNil)
+
+		data, err = model.MarshalBundleWithManifest(m, sampleInfo, &rcmd.Manifest{Samples: 3, FeatureHash: "hash"})
+		So(err, ShouldBeNil)
+		manifest, err = model.BundleManifest(data)
+		So(err, ShouldBeNil)
+		So(manifest.Samples, ShouldEqual, 3)
+		So(manifest.FeatureHash, ShouldEqual, "hash")
+
+		badInfo := *sampleInfo
+		badInfo.UserProfileRange[1]++
+		_, err = model.MarshalBundle(m, &badInfo)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestTrainReport(t *testing.T) {
+	var (
+		batchSize   = 20
+		numExamples = 190
+	)
+	rand.Seed(42)
+	sampleInfo, inputs, labels := newSmallSample(numExamples)
+	newDnn := func() model.Model {
+		return youtube.NewYoutubeDnn(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			youtube.WithDropout(0, 0))
+	}
+
+	Convey("Report of the epochs", t, func() {
+		var report model.TrainReport
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			newDnn(),
+			model.WithTrainReport(&report),
+			// the heap under the cap is not resized
+			model.WithMemoryCap(1<<40, newDnn),
+		)
+		So(err, ShouldBeNil)
+		So(report.BatchSize, ShouldEqual, batchSize)
+		So(report.Resizes, ShouldBeEmpty)
+		So(report.Epochs, ShouldHaveLength, 2)
+		for i, e := range report.Epochs {
+			So(e.Epoch, ShouldEqual, i)
+			So(e.BatchSize, ShouldEqual, batchSize)
+			So(e.Duration, ShouldBeGreaterThan, 0)
+			So(e.PeakRSS, ShouldBeGreaterThan, 0)
+			So(e.Allocs, ShouldBeGreaterThan, 0)
+		}
+	})
+
+	Convey("Batch size halved by the memory cap", t, func() {
+		var (
+			report model.TrainReport
+			models []model.Model
+		)
+		m := newDnn()
+		initial := append([]float32(nil), m.Learnable()[0].Value().Data().([]float32)...)
+		// no batch size fits the cap of 1 byte
+		err := model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 2, 0,
+			sampleInfo,
+			inputs, labels,
+			m,
+			model.WithTrainReport(&report),
+			model.WithMemoryCap(1, func() model.Model {
+				r := newDnn()
+				models = append(models, r)
+				return r
+			}),
+		)
+		So(err, ShouldNotBeNil)
+		So(models, ShouldHaveLength, 4)
+		So(report.BatchSize, ShouldEqual, 1)
+		var sizes []int
+		for _, r := range report.Resizes {
+			So(r.Epoch, ShouldEqual, 0)
+			So(r.Heap, ShouldBeGreaterThan, 1)
+			sizes = append(sizes, r.To)
+		}
+		So(sizes, ShouldResemble, []int{10, 5, 2, 1})
+		// the VMs of the batch sizes switched from are released
+		So(m.Vm(), ShouldBeNil)
+		So(models[0].Vm(), ShouldBeNil)
+		// the weights of the last Model are copied back
+		So(m.Learnable()[0].Value().Data(), ShouldResemble, models[3].Learnable()[0].Value().Data())
+		So(m.Learnable()[0].Value().Data(), ShouldNotResemble, initial)
+
+		err = model.Train(tUProfileDim, tUBehaviorSize, tUBehaviorDim, tIFeatureDim, tCFeatureDim,
+			numExamples, batchSize, 1, 0,
+			sampleInfo,
+			inputs, labels,
+			newDnn(),
+			model.WithMemoryCap(1, nil),
+		)
+		So(err, ShouldNotBeNil)
+	})
+}
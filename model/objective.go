@@ -0,0 +1,319 @@
+package model
+
+import (
+	"fmt"
+	"math"
+
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// DefaultHuberDelta is the residual where the Huber loss turns linear
+const DefaultHuberDelta = 1.0
+
+// Objective is the training target of Train
+type Objective int
+
+const (
+	// BinaryObjective is the click like label in [0, 1], trained by the
+	// binary cross entropy on the sigmoid head
+	BinaryObjective Objective = iota
+	// MSEObjective is the real value target like the rating 1-5, trained by
+	// the mean squared error on the linear head
+	MSEObjective
+	// HuberObjective is the MSEObjective robust to the outliers like the long
+	// watch seconds, trained by the Huber loss on the linear head
+	HuberObjective
+	// SoftmaxObjective is the class index target in [0, classes), e.g. the
+	// engagement tiers, trained by the categorical cross entropy on the
+	// softmax head of classes outputs
+	SoftmaxObjective
+	// OrdinalObjective is the ordered class index target, e.g. the rating
+	// buckets. The classes-1 sigmoid outputs are P(class > k) of every k,
+	// trained by the binary cross entropy of the cumulative targets.
+	OrdinalObjective
+	// ListwiseObjective is the click label in [0, 1] of the samples grouped
+	// by slate, e.g. the items shown in an impression, trained by the softmax
+	// cross entropy of the clicked against the shown in the slate. The head
+	// is linear, the scores rank within a list but are not probabilities.
+	// The slates are set by WithSlates, or else every batch is a slate.
+	ListwiseObjective
+)
+
+func (o Objective) String() string {
+	switch o {
+	case BinaryObjective:
+		return "binary"
+	case MSEObjective:
+		return "mse"
+	case HuberObjective:
+		return "huber"
+	case SoftmaxObjective:
+		return "softmax"
+	case OrdinalObjective:
+		return "ordinal"
+	case ListwiseObjective:
+		return "listwise"
+	default:
+		return fmt.Sprintf("Objective(%d)", int(o))
+	}
+}
+
+// Head is the output layer activation for the objective
+func (o Objective) Head() Head {
+	switch o {
+	case MSEObjective, HuberObjective, ListwiseObjective:
+		return LinearHead
+	case SoftmaxObjective:
+		return SoftmaxHead
+	default:
+		return SigmoidHead
+	}
+}
+
+// Outputs is the model output width for the objective of classes
+func (o Objective) Outputs(classes int) int {
+	switch o {
+	case SoftmaxObjective:
+		return classes
+	case OrdinalObjective:
+		return classes - 1
+	default:
+		return 1
+	}
+}
+
+// multiClass is true if the targets are the class indexes
+func (o Objective) multiClass() bool {
+	return o == SoftmaxObjective || o == OrdinalObjective
+}
+
+// Loss is the data loss of the objective
+func (o Objective) Loss(yPred, yTrue *G.Node) *G.Node {
+	switch o {
+	case MSEObjective:
+		return MSE32(yPred, yTrue)
+	case HuberObjective:
+		return Huber32(yPred, yTrue, DefaultHuberDelta)
+	case SoftmaxObjective:
+		return CategoricalCrossEntropy32(yPred, yTrue)
+	case ListwiseObjective:
+		// the batch is a slate
+		batchSize := yPred.Shape()[0]
+		ones := make([]float32, batchSize*batchSize)
+		for i := range ones {
+			ones[i] = 1
+		}
+		return SlateSoftmaxCrossEntropy32(yPred, yTrue,
+			G.NewConstant(tensor.New(tensor.WithShape(batchSize, batchSize), tensor.WithBacking(ones))))
+	default:
+		return BinaryCrossEntropy32(yPred, yTrue)
+	}
+}
+
+// Head is the activation of the model output layer
+type Head int
+
+const (
+	// SigmoidHead outputs the probability in (0, 1), it's the default
+	SigmoidHead Head = iota
+	// LinearHead outputs the real value of the regression
+	LinearHead
+	// SoftmaxHead outputs the class probabilities summed to 1
+	SoftmaxHead
+)
+
+// Activation returns the activation function of the head, nil for linear
+func (h Head) Activation() func(x *G.Node) (*G.Node, error) {
+	switch h {
+	case LinearHead:
+		return nil
+	case SoftmaxHead:
+		return func(x *G.Node) (*G.Node, error) {
+			return G.SoftMax(x)
+		}
+	default:
+		return G.Sigmoid
+	}
+}
+
+// OutputDim is the output width restored from json, 0 of the models saved
+// before the multi-class support is 1
+func OutputDim(outputs int) int {
+	if outputs <= 0 {
+		return 1
+	}
+	return outputs
+}
+
+// HeadSetter is implemented by the Model supporting the heads other than
+// SigmoidHead. Train sets the head of the objective before Fwd.
+type HeadSetter interface {
+	SetHead(h Head)
+}
+
+// LogitsOutputer is implemented by the Model exposing the output before the
+// activation of the head. The sigmoid head objectives are trained by the
+// fused SigmoidBinaryCrossEntropy32 of the logits instead of the
+// BinaryCrossEntropy32 of Out.
+type LogitsOutputer interface {
+	Logits() *G.Node
+}
+
+// modelLoss is the data loss of the objective of the output of m
+func (o Objective) modelLoss(m Model, yTrue *G.Node) *G.Node {
+	if o.Head() == SigmoidHead {
+		if lo, ok := m.(LogitsOutputer); ok && lo.Logits() != nil {
+			return SigmoidBinaryCrossEntropy32(lo.Logits(), yTrue)
+		}
+	}
+	return o.Loss(m.Out(), yTrue)
+}
+
+func checkObjective(o Objective, classes int, m Model) error {
+	switch o {
+	case BinaryObjective, OrdinalObjective:
+	case MSEObjective, HuberObjective, SoftmaxObjective, ListwiseObjective:
+		setter, ok := m.(HeadSetter)
+		if !ok {
+			return fmt.Errorf("model %T has no %s head", m, o)
+		}
+		setter.SetHead(o.Head())
+	default:
+		return fmt.Errorf("unknown objective %s", o)
+	}
+	if o.multiClass() && classes < 2 {
+		return fmt.Errorf("objective %s needs classes >= 2, got %d", o, classes)
+	}
+	return nil
+}
+
+// ClassTargets encodes the class index targets of shape [numExamples, 1] to
+// the targets of the objective outputs: the one-hot of SoftmaxObjective, or
+// the cumulative 1 if class > k of OrdinalObjective.
+func ClassTargets(o Objective, classes int, targets tensor.Tensor) (encoded tensor.Tensor, err error) {
+	var (
+		labels  = targets.Data().([]float32)
+		outputs = o.Outputs(classes)
+		data    = make([]float32, len(labels)*outputs)
+	)
+	for i, l := range labels {
+		class := int(l)
+		if float32(class) != l || class < 0 || class >= classes {
+			return nil, fmt.Errorf("target %v of row %d is not a class in [0, %d)", l, i, classes)
+		}
+		row := data[i*outputs : (i+1)*outputs]
+		if o == SoftmaxObjective {
+			row[class] = 1
+			continue
+		}
+		for k := 0; k < class; k++ {
+			row[k] = 1
+		}
+	}
+	return tensor.New(tensor.WithShape(len(labels), outputs), tensor.WithBacking(data)), nil
+}
+
+// SmoothLabels returns the targets of the objective o smoothed by eps as of
+// WithLabelSmoothing, the multi-class targets are the encoded ones of
+// ClassTargets. targets are not modified.
+func SmoothLabels(o Objective, classes int, eps float32, targets tensor.Tensor) (tensor.Tensor, error) {
+	if eps < 0 || eps >= 1 {
+		return nil, fmt.Errorf("label smoothing %v not in [0, 1)", eps)
+	}
+	k := 2
+	switch o {
+	case BinaryObjective, OrdinalObjective:
+	case SoftmaxObjective:
+		k = classes
+	default:
+		return nil, fmt.Errorf("label smoothing of objective %s not supported", o)
+	}
+	var (
+		labels = targets.Data().([]float32)
+		data   = make([]float32, len(labels))
+		uplift = eps / float32(k)
+	)
+	for i, l := range labels {
+		data[i] = l*(1-eps) + uplift
+	}
+	return tensor.New(tensor.WithShape(targets.Shape().Clone()...), tensor.WithBacking(data)), nil
+}
+
+// DecodeClasses returns the class of every row of the predictions y of the
+// multi-class objective: the argmax of SoftmaxObjective, or the count of
+// P(class > k) > 0.5 of OrdinalObjective.
+func DecodeClasses(o Objective, classes int, y []float32) []int {
+	outputs := o.Outputs(classes)
+	decoded := make([]int, len(y)/outputs)
+	for i := range decoded {
+		row := y[i*outputs : (i+1)*outputs]
+		if o == SoftmaxObjective {
+			best := float32(math.Inf(-1))
+			for k, p := range row {
+				if p > best {
+					best, decoded[i] = p, k
+				}
+			}
+			continue
+		}
+		for _, p := range row {
+			if p > 0.5 {
+				decoded[i]++
+			}
+		}
+	}
+	return decoded
+}
+
+// SlateTargets normalizes the click labels of shape [numExamples, 1] to the
+// target weights of ListwiseObjective: the labels of every slate of slates
+// divided by their sum. The slates without click are all 0.
+func SlateTargets(targets tensor.Tensor, slates []int) (encoded tensor.Tensor, err error) {
+	labels := targets.Data().([]float32)
+	if len(slates) != len(labels) {
+		return nil, fmt.Errorf("slates of %d rows != %d targets", len(slates), len(labels))
+	}
+	sums := make(map[int]float32)
+	for i, l := range labels {
+		if l < 0 || l > 1 || math.IsNaN(float64(l)) {
+			return nil, fmt.Errorf("target %v of row %d is not a click label in [0, 1]", l, i)
+		}
+		sums[slates[i]] += l
+	}
+	data := make([]float32, len(labels))
+	for i, l := range labels {
+		if sum := sums[slates[i]]; sum > 0 {
+			data[i] = l / sum
+		}
+	}
+	return tensor.New(tensor.WithShape(len(labels), 1), tensor.WithBacking(data)), nil
+}
+
+// slateMatrix is the per batch slate input of ListwiseObjective
+type slateMatrix struct {
+	slates  []int
+	node    *G.Node
+	backing []float32
+}
+
+// let sets the slate matrix of the samples [start, end), the filled rows are
+// of their own slates
+func (s *slateMatrix) let(start, end int) error {
+	batchSize := s.node.Shape()[0]
+	for i := range s.backing {
+		s.backing[i] = 0
+	}
+	for i := 0; i < batchSize; i++ {
+		if start+i >= end {
+			s.backing[i*batchSize+i] = 1
+			continue
+		}
+		for j := 0; j < end-start; j++ {
+			if s.slates[start+i] == s.slates[start+j] {
+				s.backing[i*batchSize+j] = 1
+			}
+		}
+	}
+	return G.Let(s.node, tensor.New(tensor.WithShape(batchSize, batchSize), tensor.WithBacking(s.backing)))
+}
@@ -0,0 +1,344 @@
+package pnn
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/layers"
+	log "github.com/sirupsen/logrus"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+const (
+	// magic numbers for pnn paper
+	mlp0_1 = 200
+	mlp1_2 = 80
+
+	// fieldNum is the count of the fields: user profile, user behaviors,
+	// item feature and context feature
+	fieldNum = 4
+
+	defaultFieldDim = 10
+	defaultDropout  = 0.005
+)
+
+// ProductType is the kind of the product layer
+type ProductType string
+
+const (
+	// InnerProduct is the IPNN, inner products of every field pair
+	InnerProduct ProductType = "inner"
+	// OuterProduct is the OPNN, outer product of the field sum
+	OuterProduct ProductType = "outer"
+)
+
+// PnnNet is the Product-based Neural Network. Every field is projected to an
+// embedding of fieldDim, the product layer output is concatenated with the
+// field embeddings before the MLP.
+type PnnNet struct {
+	uProfileDim, uBehaviorSize, uBehaviorDim int
+	iFeatureDim                              int
+	cFeatureDim                              int
+	fieldDim                                 int
+	productType                              ProductType
+
+	g  *G.ExprGraph
+	vm G.VM
+
+	//input nodes
+	xUserProfile, xUbMatrix, xItemFeature, xCtxFeature *G.Node
+
+	emb0, emb1, emb2, emb3 *G.Node    // field embedding weights
+	mlp0, mlp1, mlp2       *G.Node    // weights of MLP layers
+	d0, d1                 float32    // dropout probabilities
+	training               bool       // dropout is only applied in training mode
+	head                   model.Head // output layer activation, see model.HeadSetter
+	outputs                int        // output layer width
+
+	// logits and out are the output before and after the head activation
+	logits, out *G.Node
+}
+
+type pnnModel struct {
+	UProfileDim   int         `json:"uProfileDim"`
+	UBehaviorSize int         `json:"uBehaviorSize"`
+	UBehaviorDim  int         `json:"uBehaviorDim"`
+	IFeatureDim   int         `json:"iFeatureDim"`
+	CFeatureDim   int         `json:"cFeatureDim"`
+	FieldDim      int         `json:"fieldDim"`
+	ProductType   ProductType `json:"productType"`
+	Emb0          []float32   `json:"emb0"`
+	Emb1          []float32   `json:"emb1"`
+	Emb2          []float32   `json:"emb2"`
+	Emb3          []float32   `json:"emb3"`
+	Mlp0          []float32   `json:"mlp0"`
+	Mlp1          []float32   `json:"mlp1"`
+	Mlp2          []float32   `json:"mlp2"`
+	Head          model.Head  `json:"head,omitempty"`
+	Outputs       int         `json:"outputs,omitempty"`
+	// Dropout is d0 and d1, the defaults of the models saved before it
+	Dropout []float32 `json:"dropout,omitempty"`
+}
+
+// Option configures the PnnNet created by NewPnnNet
+type Option func(pnn *PnnNet)
+
+// WithDropout sets the dropout probabilities of the two hidden MLP layers,
+// 0 disables dropout of the layer.
+func WithDropout(d0, d1 float32) Option {
+	return func(pnn *PnnNet) {
+		for _, d := range []float32{d0, d1} {
+			if d < 0 || d >= 1 {
+				log.Fatalf("dropout probability %f out of range [0, 1)", d)
+			}
+		}
+		pnn.d0, pnn.d1 = d0, d1
+	}
+}
+
+// WithProductType sets the product layer kind, default is InnerProduct
+func WithProductType(productType ProductType) Option {
+	return func(pnn *PnnNet) {
+		if productType != InnerProduct && productType != OuterProduct {
+			log.Fatalf("unknown product type %s", productType)
+		}
+		pnn.productType = productType
+	}
+}
+
+// WithFieldDim sets the dim of the field embeddings
+func WithFieldDim(fieldDim int) Option {
+	return func(pnn *PnnNet) {
+		if fieldDim <= 0 {
+			log.Fatalf("field dim %d should be positive", fieldDim)
+		}
+		pnn.fieldDim = fieldDim
+	}
+}
+
+// WithOutputs sets the width of the output layer, e.g. the classes of the
+// model.SoftmaxObjective, default 1.
+func WithOutputs(outputs int) Option {
+	return func(pnn *PnnNet) {
+		if outputs <= 0 {
+			log.Fatalf("outputs %d should be positive", outputs)
+		}
+		pnn.outputs = outputs
+	}
+}
+
+// productDim is the width of the product layer output
+func productDim(productType ProductType, fieldDim int) int {
+	if productType == OuterProduct {
+		return fieldDim * fieldDim
+	}
+	return fieldNum * (fieldNum - 1) / 2
+}
+
+// newNodes creates the learnable nodes, values are used as node values if
+// not nil, or they will be initialized with Gaussian.
+func (pnn *PnnNet) newNodes(values *pnnModel) {
+	var (
+		g        = pnn.g
+		gaussian = G.Gaussian(0, 1.0)
+		mlp0_0   = fieldNum*pnn.fieldDim + productDim(pnn.productType, pnn.fieldDim)
+	)
+	if values == nil {
+		values = &pnnModel{}
+	}
+	pnn.emb0 = layers.NewWeight(g, "emb0", pnn.uProfileDim, pnn.fieldDim, gaussian, values.Emb0)
+	pnn.emb1 = layers.NewWeight(g, "emb1", pnn.uBehaviorDim, pnn.fieldDim, gaussian, values.Emb1)
+	pnn.emb2 = layers.NewWeight(g, "emb2", pnn.iFeatureDim, pnn.fieldDim, gaussian, values.Emb2)
+	pnn.emb3 = layers.NewWeight(g, "emb3", pnn.cFeatureDim, pnn.fieldDim, gaussian, values.Emb3)
+	pnn.mlp0 = layers.NewWeight(g, "mlp0", mlp0_0, mlp0_1, gaussian, values.Mlp0)
+	pnn.mlp1 = layers.NewWeight(g, "mlp1", mlp0_1, mlp1_2, gaussian, values.Mlp1)
+	pnn.mlp2 = layers.NewWeight(g, "mlp2", mlp1_2, pnn.outputs, gaussian, values.Mlp2)
+}
+
+func NewPnnNet(
+	uProfileDim, uBehaviorSize, uBehaviorDim int,
+	iFeatureDim int,
+	cFeatureDim int,
+	opts ...Option,
+) (pnn *PnnNet) {
+	pnn = &PnnNet{
+		uProfileDim:   uProfileDim,
+		uBehaviorSize: uBehaviorSize,
+		uBehaviorDim:  uBehaviorDim,
+		iFeatureDim:   iFeatureDim,
+		cFeatureDim:   cFeatureDim,
+		fieldDim:      defaultFieldDim,
+		productType:   InnerProduct,
+		outputs:       1,
+
+		g:  G.NewGraph(),
+		d0: defaultDropout,
+		d1: defaultDropout,
+	}
+	for _, opt := range opts {
+		opt(pnn)
+	}
+	pnn.newNodes(nil)
+	return
+}
+
+func NewPnnNetFromJson(data []byte) (pnn *PnnNet, err error) {
+	var m pnnModel
+	if err = json.Unmarshal(data, &m); err != nil {
+		return
+	}
+	if m.ProductType != InnerProduct && m.ProductType != OuterProduct {
+		err = fmt.Errorf("unknown product type %s", m.ProductType)
+		return
+	}
+	d0, d1 := float32(defaultDropout), float32(defaultDropout)
+	if m.Dropout != nil {
+		if len(m.Dropout) != 2 {
+			err = fmt.Errorf("%d dropout probabilities, not 2", len(m.Dropout))
+			return
+		}
+		for _, d := range m.Dropout {
+			if d < 0 || d >= 1 {
+				err = fmt.Errorf("dropout probability %f out of range [0, 1)", d)
+				return
+			}
+		}
+		d0, d1 = m.Dropout[0], m.Dropout[1]
+	}
+	pnn = &PnnNet{
+		uProfileDim:   m.UProfileDim,
+		uBehaviorSize: m.UBehaviorSize,
+		uBehaviorDim:  m.UBehaviorDim,
+		iFeatureDim:   m.IFeatureDim,
+		cFeatureDim:   m.CFeatureDim,
+		fieldDim:      m.FieldDim,
+		productType:   m.ProductType,
+		head:          m.Head,
+		outputs:       model.OutputDim(m.Outputs),
+		d0:            d0,
+		d1:            d1,
+		g:             G.NewGraph(),
+	}
+	pnn.newNodes(&m)
+	return
+}
+
+func (pnn *PnnNet) Marshal() (data []byte, err error) {
+	return json.Marshal(pnnModel{
+		UProfileDim:   pnn.uProfileDim,
+		UBehaviorSize: pnn.uBehaviorSize,
+		UBehaviorDim:  pnn.uBehaviorDim,
+		IFeatureDim:   pnn.iFeatureDim,
+		CFeatureDim:   pnn.cFeatureDim,
+		FieldDim:      pnn.fieldDim,
+		ProductType:   pnn.productType,
+		Emb0:          pnn.emb0.Value().Data().([]float32),
+		Emb1:          pnn.emb1.Value().Data().([]float32),
+		Emb2:          pnn.emb2.Value().Data().([]float32),
+		Emb3:          pnn.emb3.Value().Data().([]float32),
+		Mlp0:          pnn.mlp0.Value().Data().([]float32),
+		Mlp1:          pnn.mlp1.Value().Data().([]float32),
+		Mlp2:          pnn.mlp2.Value().Data().([]float32),
+		Head:          pnn.head,
+		Outputs:       pnn.outputs,
+		Dropout:       []float32{pnn.d0, pnn.d1},
+	})
+}
+
+func (pnn *PnnNet) Vm() G.VM {
+	return pnn.vm
+}
+
+func (pnn *PnnNet) SetVM(vm G.VM) {
+	pnn.vm = vm
+}
+
+func (pnn *PnnNet) SetTraining(training bool) {
+	pnn.training = training
+}
+
+// SetHead implements model.HeadSetter
+func (pnn *PnnNet) SetHead(h model.Head) {
+	pnn.head = h
+}
+
+func (pnn *PnnNet) Graph() *G.ExprGraph {
+	return pnn.g
+}
+
+func (pnn *PnnNet) Out() *G.Node {
+	return pnn.out
+}
+
+// Logits implements model.LogitsOutputer
+func (pnn *PnnNet) Logits() *G.Node {
+	return pnn.logits
+}
+
+func (pnn *PnnNet) In() G.Nodes {
+	return G.Nodes{pnn.xUserProfile, pnn.xUbMatrix, pnn.xItemFeature, pnn.xCtxFeature}
+}
+
+func (pnn *PnnNet) Learnable() G.Nodes {
+	return G.Nodes{pnn.emb0, pnn.emb1, pnn.emb2, pnn.emb3, pnn.mlp0, pnn.mlp1, pnn.mlp2}
+}
+
+// Fwd performs the forward pass
+// xUserProfile: [batchSize, userProfileDim]
+// xUbMatrix: [batchSize, uBehaviorSize* uBehaviorDim]
+// xUserBehaviors: [batchSize, uBehaviorSize, uBehaviorDim]
+// xItemFeature: [batchSize, iFeatureDim]
+// xContextFeature: [batchSize, cFeatureDim]
+func (pnn *PnnNet) Fwd(xUserProfile, xUbMatrix, xItemFeature, xCtxFeature *G.Node, batchSize, uBehaviorSize, uBehaviorDim int) (err error) {
+	// user behaviors are avg pooled into one field
+	xUserBehaviors := G.Must(G.Reshape(xUbMatrix, tensor.Shape{batchSize, uBehaviorSize, uBehaviorDim}))
+	xUserBehaviorAvg := G.Must(G.Mean(xUserBehaviors, 1))
+
+	// field embeddings, all in shape [batchSize, fieldDim]
+	fields := G.Nodes{
+		G.Must(layers.Dense(xUserProfile, pnn.emb0, nil)),
+		G.Must(layers.Dense(xUserBehaviorAvg, pnn.emb1, nil)),
+		G.Must(layers.Dense(xItemFeature, pnn.emb2, nil)),
+		G.Must(layers.Dense(xCtxFeature, pnn.emb3, nil)),
+	}
+
+	// product layer
+	var product *G.Node
+	switch pnn.productType {
+	case OuterProduct:
+		// the OPNN paper sums the field embeddings before the outer product
+		// to keep the complexity of D^2 instead of N^2 * D^2
+		fieldSum := fields[0]
+		for _, f := range fields[1:] {
+			fieldSum = G.Must(G.Add(fieldSum, f))
+		}
+		// [batchSize, fieldDim, fieldDim]
+		outer, err := layers.BatchOuterProd(fieldSum, fieldSum)
+		if err != nil {
+			return err
+		}
+		product = G.Must(G.Reshape(outer, tensor.Shape{batchSize, pnn.fieldDim * pnn.fieldDim}))
+	default:
+		// [batchSize, fieldNum * (fieldNum - 1) / 2]
+		if product, err = layers.PairwiseInnerProd(fields...); err != nil {
+			return
+		}
+	}
+
+	// Concat all the field embeddings and the product
+	x := G.Must(G.Concat(1, append(fields, product)...))
+
+	// MLP
+	mlp0Out := G.Must(layers.Dropout(G.Must(layers.Dense(x, pnn.mlp0, G.Sigmoid)), pnn.d0, pnn.training))
+	mlp1Out := G.Must(layers.Dropout(G.Must(layers.Dense(mlp0Out, pnn.mlp1, G.Sigmoid)), pnn.d1, pnn.training))
+	pnn.logits = G.Must(layers.Dense(mlp1Out, pnn.mlp2, nil))
+	pnn.out = G.Must(layers.Activate(pnn.logits, pnn.head.Activation()))
+
+	pnn.xUserProfile = xUserProfile
+	pnn.xItemFeature = xItemFeature
+	pnn.xCtxFeature = xCtxFeature
+	pnn.xUbMatrix = xUbMatrix
+	return
+}
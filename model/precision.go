@@ -0,0 +1,161 @@
+package model
+
+import (
+	"math"
+
+	log "github.com/sirupsen/logrus"
+	G "gorgonia.org/gorgonia"
+)
+
+// Precision is the float precision of the forward and backward pass of Train
+type Precision int
+
+const (
+	// Float32Precision runs everything in DT, it's the default
+	Float32Precision Precision = iota
+	// MixedPrecision keeps the master weights in float32 but runs the
+	// forward and backward pass of the float16 weights, inputs and gradients
+	// with the dynamic loss scaling, see WithMixedPrecision
+	MixedPrecision
+)
+
+func (p Precision) String() string {
+	if p == MixedPrecision {
+		return "mixed"
+	}
+	return "float32"
+}
+
+const (
+	// DefaultLossScale is the initial loss scale of MixedPrecision
+	DefaultLossScale = 1 << 16
+	// DefaultLossScaleGrowth is the steps of no overflow the loss scale is
+	// doubled after
+	DefaultLossScaleGrowth = 2000
+	// maxLossScale keeps the scaled loss finite in float32
+	maxLossScale = 1 << 24
+	// maxHalf is the largest finite float16
+	maxHalf = 65504
+)
+
+// roundHalf rounds f to the nearest float16 value, ties to even, the
+// overflows are the infinities and the underflows the signed zeros. gorgonia
+// and tensor have no float16 dtype, so float16 is emulated in float32 by the
+// values it could hold.
+func roundHalf(f float32) float32 {
+	x := float64(f)
+	if math.IsNaN(x) || math.IsInf(x, 0) || x == 0 {
+		return f
+	}
+	a := math.Abs(x)
+	// the quantum of the subnormals is 2^-24, of the normals 2^(e-11) of
+	// a = m * 2^e, 0.5 <= m < 1
+	quantum := math.Ldexp(1, -24)
+	if _, e := math.Frexp(a); e-1 >= -14 {
+		quantum = math.Ldexp(1, e-11)
+	}
+	r := math.RoundToEven(a/quantum) * quantum
+	if r > maxHalf {
+		r = math.Inf(1)
+	}
+	return float32(math.Copysign(r, x))
+}
+
+// mixedPrecision is the state of MixedPrecision of Train: the float32
+// master weights stepped by the solver, the learnable nodes run the float16
+// copy of them
+type mixedPrecision struct {
+	masters [][]float32
+	// scale is the loss scale, good the steps since the last overflow
+	scale float64
+	good  int
+	// skipped is the steps skipped by the overflowed gradients
+	skipped int
+}
+
+// newMixedPrecision keeps the weights of nodes as the masters and rounds the
+// nodes to float16
+func newMixedPrecision(nodes G.Nodes) *mixedPrecision {
+	mp := &mixedPrecision{scale: DefaultLossScale}
+	for _, n := range nodes {
+		mp.masters = append(mp.masters, append([]float32(nil), n.Value().Data().([]float32)...))
+	}
+	mp.quantize(nodes)
+	return mp
+}
+
+// quantize sets nodes to the float16 copy of the masters
+func (mp *mixedPrecision) quantize(nodes G.Nodes) {
+	for i, n := range nodes {
+		w := n.Value().Data().([]float32)
+		for k, v := range mp.masters[i] {
+			w[k] = roundHalf(v)
+		}
+	}
+}
+
+// restore sets nodes to the masters, e.g. for the solver step or the
+// checkpoint
+func (mp *mixedPrecision) restore(nodes G.Nodes) {
+	for i, n := range nodes {
+		copy(n.Value().Data().([]float32), mp.masters[i])
+	}
+}
+
+// keep keeps the weights of nodes stepped as the masters and rounds the
+// nodes to float16 again
+func (mp *mixedPrecision) keep(nodes G.Nodes) {
+	for i, n := range nodes {
+		copy(mp.masters[i], n.Value().Data().([]float32))
+	}
+	mp.quantize(nodes)
+}
+
+// unscale rounds the scaled gradients of nodes to float16 and divides them
+// by the loss scale. If any overflows to inf or nan, the gradients are
+// zeroed, the loss scale is halved and false is returned to skip the step.
+// The loss scale is doubled after DefaultLossScaleGrowth steps of no
+// overflow.
+func (mp *mixedPrecision) unscale(nodes G.Nodes) (ok bool, err error) {
+	grads := make([][]float32, len(nodes))
+	ok = true
+	for i, n := range nodes {
+		var grad G.Value
+		if grad, err = n.Grad(); err != nil {
+			return
+		}
+		grads[i] = grad.Data().([]float32)
+		for k, g := range grads[i] {
+			h := roundHalf(g)
+			if math.IsInf(float64(h), 0) || math.IsNaN(float64(h)) {
+				ok = false
+			}
+			grads[i][k] = h
+		}
+	}
+	if !ok {
+		for _, g := range grads {
+			for k := range g {
+				g[k] = 0
+			}
+		}
+		mp.skipped++
+		mp.good = 0
+		if mp.scale > 1 {
+			mp.scale /= 2
+		}
+		log.Warnf("mixed precision: gradients overflowed, step skipped and loss scale backed off to %v", mp.scale)
+		return
+	}
+	inv := float32(1 / mp.scale)
+	for _, g := range grads {
+		for k := range g {
+			g[k] *= inv
+		}
+	}
+	if mp.good++; mp.good >= DefaultLossScaleGrowth && mp.scale < maxLossScale {
+		mp.scale *= 2
+		mp.good = 0
+	}
+	return
+}
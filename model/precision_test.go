@@ -0,0 +1,86 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+)
+
+func TestPrecision(t *testing.T) {
+	Convey("mixed precision option", t, func() {
+		So(MixedPrecision.String(), ShouldEqual, "mixed")
+		So(Float32Precision.String(), ShouldEqual, "float32")
+
+		var opts TrainOpts
+		WithMixedPrecision()(&opts)
+		So(opts.Precision, ShouldEqual, MixedPrecision)
+	})
+
+	Convey("round to float16", t, func() {
+		for _, c := range []struct {
+			in, out float32
+		}{
+			{1, 1},
+			{-2.5, -2.5},
+			{maxHalf, maxHalf},
+			// the ties to even
+			{1 + 1.0/2048, 1},
+			{1 + 3.0/2048, 1 + 1.0/512},
+			{65519, maxHalf},
+			{float32(math.Ldexp(1, -24)), float32(math.Ldexp(1, -24))},
+			{float32(math.Ldexp(1, -25)), 0},
+			{float32(math.Ldexp(3, -25)), float32(math.Ldexp(1, -23))},
+			{0.1, 0.0999755859375},
+		} {
+			So(roundHalf(c.in), ShouldEqual, c.out)
+		}
+		So(math.IsInf(float64(roundHalf(65520)), 1), ShouldBeTrue)
+		So(math.IsInf(float64(roundHalf(-1e6)), -1), ShouldBeTrue)
+		So(math.IsNaN(float64(roundHalf(float32(math.NaN())))), ShouldBeTrue)
+	})
+
+	Convey("float32 masters and the dynamic loss scale", t, func() {
+		w, vm := newQuadratic([]float32{0.1, 1 + 1.0/4096}, []float32{1, 1})
+		// the gradients are bound by a run
+		So(vm.RunAll(), ShouldBeNil)
+		nodes := G.Nodes{w}
+		mp := newMixedPrecision(nodes)
+		So(w.Value().Data(), ShouldResemble, []float32{0.0999755859375, 1})
+		So(mp.masters[0], ShouldResemble, []float32{0.1, 1 + 1.0/4096})
+
+		gradOf := func() []float32 {
+			grad, err := w.Grad()
+			So(err, ShouldBeNil)
+			return grad.Data().([]float32)
+		}
+		setGrad := func(grad []float32) {
+			copy(gradOf(), grad)
+		}
+
+		// the scaled gradients are unscaled
+		setGrad([]float32{1024, -512})
+		ok, err := mp.unscale(nodes)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(gradOf(), ShouldResemble, []float32{1024.0 / DefaultLossScale, -512.0 / DefaultLossScale})
+
+		// the overflowed gradients skip the step and back off
+		setGrad([]float32{1e5, 1})
+		ok, err = mp.unscale(nodes)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+		So(gradOf(), ShouldResemble, []float32{0, 0})
+		So(mp.scale, ShouldEqual, DefaultLossScale/2)
+		So(mp.skipped, ShouldEqual, 1)
+
+		// the masters keep the float32 updates
+		w.Value().Data().([]float32)[1] = 1 + 1.0/4096 + 1.0/8192
+		mp.keep(nodes)
+		So(mp.masters[0][1], ShouldEqual, 1+1.0/4096+1.0/8192)
+		So(w.Value().Data().([]float32)[1], ShouldEqual, 1)
+		mp.restore(nodes)
+		So(w.Value().Data().([]float32)[1], ShouldEqual, 1+1.0/4096+1.0/8192)
+	})
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"fmt"
+
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// Regularization is the weight decay config of a group of learnable nodes.
+// The terms are added to the cost node, so they are seen by the gradients
+// of every solver regardless of its own L1/L2 options.
+type Regularization struct {
+	// Nodes are the names of the learnable nodes in the group,
+	// empty Nodes means all the learnable nodes not claimed by other groups,
+	// at most one group has empty Nodes.
+	Nodes []string
+	// L1 adds L1 * sum(|w|) to the cost
+	L1 float32
+	// L2 adds L2 * sum(w^2) to the cost
+	L2 float32
+
+	// RowFreq enables the mini-batch aware regularization described in the
+	// DIN paper for embedding like nodes of shape [rows, dim]. RowFreq[j] is
+	// the occurrence count of row j in the whole training set, the L2 term
+	// of a batch then only covers the rows used by the batch:
+	//	L2 * sum_j(I_j / RowFreq[j] * ||w_j||^2)
+	// where I_j is 1 if any sample of the batch references row j.
+	RowFreq []float32
+	// BatchRows returns the rows referenced by samples [start, end), it is
+	// required if RowFreq is set.
+	BatchRows func(start, end int) []int
+}
+
+// L1Norm32 returns sum(|w|)
+func L1Norm32(w *G.Node) *G.Node {
+	return G.Must(G.Sum(G.Must(G.Abs(w))))
+}
+
+// L2Norm32 returns sum(w^2)
+func L2Norm32(w *G.Node) *G.Node {
+	return G.Must(G.Sum(G.Must(G.Square(w))))
+}
+
+// MiniBatchAwareL2Norm32 returns sum_j(rowWeight_j * ||w_j||^2),
+// w.Shape: [rows, dim], rowWeight.Shape: [rows]
+func MiniBatchAwareL2Norm32(w, rowWeight *G.Node) *G.Node {
+	return G.Must(G.Sum(G.Must(G.HadamardProd(
+		G.Must(G.Sum(G.Must(G.Square(w)), 1)),
+		rowWeight,
+	))))
+}
+
+// mbaRowWeight is the per batch input of a mini-batch aware regularization
+type mbaRowWeight struct {
+	reg     *Regularization
+	node    *G.Node
+	backing []float32
+}
+
+// let sets the row weights for samples [start, end)
+func (m *mbaRowWeight) let(start, end int) error {
+	for i := range m.backing {
+		m.backing[i] = 0
+	}
+	for _, row := range m.reg.BatchRows(start, end) {
+		if row < 0 || row >= len(m.backing) {
+			return fmt.Errorf("batch row %d out of range [0, %d)", row, len(m.backing))
+		}
+		if freq := m.reg.RowFreq[row]; freq > 0 {
+			m.backing[row] = 1 / freq
+		}
+	}
+	return G.Let(m.node, tensor.New(tensor.WithShape(len(m.backing)), tensor.WithBacking(m.backing)))
+}
+
+// regularize adds the regularization terms of regs to cost
+func regularize(cost *G.Node, learnable G.Nodes, regs []Regularization) (retVal *G.Node, mbas []*mbaRowWeight, err error) {
+	var (
+		byName  = make(map[string]*G.Node, len(learnable))
+		claimed = make(map[string]bool, len(learnable))
+		terms   G.Nodes
+	)
+	for _, n := range learnable {
+		byName[n.Name()] = n
+	}
+	groupNodes := make([]G.Nodes, len(regs))
+	defaultGroup := -1
+	for i, reg := range regs {
+		if len(reg.Nodes) == 0 {
+			// the rest would be penalized by every default group
+			if defaultGroup >= 0 {
+				return nil, nil, fmt.Errorf("regularization groups %d and %d both have empty Nodes", defaultGroup, i)
+			}
+			defaultGroup = i
+		}
+		for _, name := range reg.Nodes {
+			n, ok := byName[name]
+			if !ok {
+				return nil, nil, fmt.Errorf("regularization node %s is not learnable", name)
+			}
+			if claimed[name] {
+				return nil, nil, fmt.Errorf("regularization node %s is in more than one group", name)
+			}
+			claimed[name] = true
+			groupNodes[i] = append(groupNodes[i], n)
+		}
+	}
+	for i, reg := range regs {
+		if len(reg.Nodes) != 0 {
+			continue
+		}
+		for _, n := range learnable {
+			if !claimed[n.Name()] {
+				groupNodes[i] = append(groupNodes[i], n)
+			}
+		}
+	}
+
+	for i := range regs {
+		reg := &regs[i]
+		if reg.RowFreq != nil && reg.BatchRows == nil {
+			return nil, nil, fmt.Errorf("regularization group %d has RowFreq but no BatchRows", i)
+		}
+		for _, n := range groupNodes[i] {
+			if reg.L1 != 0 {
+				terms = append(terms, G.Must(G.Mul(L1Norm32(n), G.NewConstant(reg.L1))))
+			}
+			if reg.L2 == 0 {
+				continue
+			}
+			if reg.RowFreq == nil {
+				terms = append(terms, G.Must(G.Mul(L2Norm32(n), G.NewConstant(reg.L2))))
+				continue
+			}
+			if n.Dims() != 2 || n.Shape()[0] != len(reg.RowFreq) {
+				return nil, nil, fmt.Errorf("mini-batch aware node %s shape %v mismatch RowFreq length %d",
+					n.Name(), n.Shape(), len(reg.RowFreq))
+			}
+			mba := &mbaRowWeight{
+				reg:     reg,
+				node:    G.NewVector(n.Graph(), DT, G.WithShape(len(reg.RowFreq)), G.WithName("mbaRowWeight_"+n.Name())),
+				backing: make([]float32, len(reg.RowFreq)),
+			}
+			mbas = append(mbas, mba)
+			terms = append(terms, G.Must(G.Mul(MiniBatchAwareL2Norm32(n, mba.node), G.NewConstant(reg.L2))))
+		}
+	}
+
+	retVal = cost
+	for _, t := range terms {
+		retVal = G.Must(G.Add(retVal, t))
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+func TestRegularization(t *testing.T) {
+	Convey("L1 and L2 norm", t, func() {
+		g := G.NewGraph()
+		w := G.NodeFromAny(g, tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float32{1, -2, 3, -4})), G.WithName("w"))
+		l1 := L1Norm32(w)
+		l2 := L2Norm32(w)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		So(l1.Value().Data(), ShouldAlmostEqual, 10, 1e-6)
+		So(l2.Value().Data(), ShouldAlmostEqual, 30, 1e-6)
+	})
+
+	Convey("Mini-batch aware L2 norm", t, func() {
+		g := G.NewGraph()
+		w := G.NodeFromAny(g, tensor.New(tensor.WithShape(3, 2), tensor.WithBacking([]float32{
+			1, 1,
+			2, 2,
+			3, 3,
+		})), G.WithName("w"))
+		rowWeight := G.NewVector(g, DT, G.WithShape(3), G.WithName("rowWeight"))
+		mba := &mbaRowWeight{
+			reg: &Regularization{
+				RowFreq: []float32{2, 4, 0},
+				BatchRows: func(start, end int) []int {
+					return []int{1, 2}
+				},
+			},
+			node:    rowWeight,
+			backing: make([]float32, 3),
+		}
+		So(mba.let(0, 1), ShouldBeNil)
+		norm := MiniBatchAwareL2Norm32(w, rowWeight)
+		m := G.NewTapeMachine(g)
+		if err := m.RunAll(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer m.Close()
+		// only row 1 is used and has non zero frequency: 8 / 4
+		So(norm.Value().Data(), ShouldAlmostEqual, 2, 1e-6)
+	})
+
+	Convey("Regularization groups", t, func() {
+		g := G.NewGraph()
+		w0 := G.NodeFromAny(g, tensor.New(tensor.WithShape(2), tensor.WithBacking([]float32{1, -1})), G.WithName("w0"))
+		w1 := G.NodeFromAny(g, tensor.New(tensor.WithShape(2), tensor.WithBacking([]float32{2, -2})), G.WithName("w1"))
+		loss := G.NewConstant(float32(1))
+
+		Convey("unknown node", func() {
+			_, _, err := regularize(loss, G.Nodes{w0, w1}, []Regularization{{Nodes: []string{"w2"}, L2: 1}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("node in two groups", func() {
+			_, _, err := regularize(loss, G.Nodes{w0, w1}, []Regularization{
+				{Nodes: []string{"w0"}, L2: 1},
+				{Nodes: []string{"w0"}, L1: 1},
+			})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("more than one default group", func() {
+			_, _, err := regularize(loss, G.Nodes{w0, w1}, []Regularization{
+				{L2: 1},
+				{Nodes: []string{"w0"}, L2: 1},
+				{L1: 1},
+			})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("default group takes the rest", func() {
+			cost, mbas, err := regularize(loss, G.Nodes{w0, w1}, []Regularization{
+				{Nodes: []string{"w0"}, L2: 0.5},
+				{L1: 0.25},
+			})
+			So(err, ShouldBeNil)
+			So(mbas, ShouldBeEmpty)
+			m := G.NewTapeMachine(g)
+			if err := m.RunAll(); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			defer m.Close()
+			// 1 + 0.5 * 2 + 0.25 * 4
+			So(cost.Value().Data(), ShouldAlmostEqual, 3, 1e-6)
+		})
+	})
+}
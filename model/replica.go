@@ -0,0 +1,266 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// replica is a Model with its training graph of a batch
+type replica struct {
+	m Model
+
+	xUserProfile        *G.Node
+	xUserBehaviorMatrix *G.Node
+	xItemFeature        *G.Node
+	xCtxFeature         *G.Node
+	y                   *G.Node
+	// loss is the data loss, cost is loss with the regularization terms
+	loss          *G.Node
+	cost          *G.Node
+	mbaRowWeights []*mbaRowWeight
+	// slate is the slate input of ListwiseObjective by WithSlates
+	slate *slateMatrix
+	// batch is the inputs and targets fed by prepare
+	batch *preparedBatch
+	// out and logits are the outputs of the Model read of the hard sample
+	// dump, the node values are overwritten by the backward pass
+	out, logits G.Value
+	// lossScale scales the cost of the gradients of MixedPrecision
+	lossScale *G.Node
+	vm        G.VM
+}
+
+// newReplica builds the training graph of m with the gradients of the cost,
+// and binds m to the compiled tape machine. The dropout is left out of the
+// graph if not training, e.g. of CheckGradients.
+func newReplica(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int,
+	batchSize, outputs int, m Model, training bool, trainOpts *TrainOpts,
+) (r *replica, err error) {
+	g := m.Graph()
+	r = &replica{m: m}
+	r.xUserProfile = G.NewMatrix(g, DT, G.WithShape(batchSize, uProfileDim), G.WithName("xUserProfile"))
+	//xUserBehaviors := G.NewTensor(g, DT, 3, G.WithShape(batchSize, uBehaviorSize, uBehaviorDim), G.WithName("xUserBehaviors"))
+	r.xUserBehaviorMatrix = G.NewMatrix(g, DT, G.WithShape(batchSize, uBehaviorSize*uBehaviorDim), G.WithName("xUserBehaviorMatrix"))
+	r.xItemFeature = G.NewMatrix(g, DT, G.WithShape(batchSize, iFeatureDim), G.WithName("xItemFeature"))
+	r.xCtxFeature = G.NewMatrix(g, DT, G.WithShape(batchSize, cFeatureDim), G.WithName("xCtxFeature"))
+	r.y = G.NewTensor(g, DT, 2, G.WithShape(batchSize, outputs), G.WithName("y"))
+	m.SetTraining(training)
+	if err = m.Fwd(r.xUserProfile, r.xUserBehaviorMatrix, r.xItemFeature, r.xCtxFeature, batchSize, uBehaviorSize, uBehaviorDim); err != nil {
+		return
+	}
+	if !m.Out().Shape().Eq(r.y.Shape()) {
+		return nil, fmt.Errorf("model output shape %v != %v of objective %s", m.Out().Shape(), r.y.Shape(), trainOpts.Objective)
+	}
+
+	//losses := G.Must(G.HadamardProd(G.Must(G.Neg(G.Must(G.Log(m.out)))), y))
+	//losses := G.Must(G.Square(G.Must(G.Sub(m.Out(), y))))
+	if trainOpts.Objective == ListwiseObjective && trainOpts.Slates != nil {
+		r.slate = &slateMatrix{
+			slates:  trainOpts.Slates,
+			node:    G.NewMatrix(g, DT, G.WithShape(batchSize, batchSize), G.WithName("slate")),
+			backing: make([]float32, batchSize*batchSize),
+		}
+		r.loss = SlateSoftmaxCrossEntropy32(m.Out(), r.y, r.slate.node)
+	} else {
+		r.loss = trainOpts.Objective.modelLoss(m, r.y)
+	}
+	cost, mbaRowWeights, err := regularize(r.loss, m.Learnable(), trainOpts.Regularizations)
+	if err != nil {
+		return
+	}
+	r.cost, r.mbaRowWeights = cost, mbaRowWeights
+	if training && trainOpts.Precision == MixedPrecision {
+		// the gradients are of the scaled cost, unscaled before the step
+		r.lossScale = G.NewScalar(g, DT, G.WithName("lossScale"))
+		if cost, err = G.Mul(cost, r.lossScale); err != nil {
+			return
+		}
+	}
+	if _, err = G.Grad(cost, m.Learnable()...); err != nil {
+		return
+	}
+	if trainOpts.HardSamplePath != "" {
+		G.Read(m.Out(), &r.out)
+		if lo, ok := m.(LogitsOutputer); ok && lo.Logits() != nil {
+			G.Read(lo.Logits(), &r.logits)
+		}
+	}
+
+	// debug
+	//ExportGraph(m, "fullGraph.dot", GraphDOT)
+	prog, locMap, err := G.Compile(g)
+	if err != nil {
+		return
+	}
+	r.vm = G.NewTapeMachine(g,
+		G.WithPrecompiled(prog, locMap),
+		G.BindDualValues(m.Learnable()...),
+		//G.TraceExec(),
+		//G.WithInfWatch(),
+		//G.WithNaNWatch(),
+	)
+	m.SetVM(r.vm)
+	return
+}
+
+// prepare allocates the batch of the inputs and targets, fed by let
+func (r *replica) prepare(si *rcmd.SampleInfo, inputs, targets tensor.Tensor) (err error) {
+	x, xWidth, err := rowMajor(inputs)
+	if err != nil {
+		return fmt.Errorf("inputs: %v", err)
+	}
+	y, yWidth, err := rowMajor(targets)
+	if err != nil {
+		return fmt.Errorf("targets: %v", err)
+	}
+	feeds := []struct {
+		node  *G.Node
+		src   []float32
+		width int
+		cols  [2]int
+		name  string
+	}{
+		{r.xUserProfile, x, xWidth, si.UserProfileRange, "xUserProfileVal"},
+		{r.xUserBehaviorMatrix, x, xWidth, si.UserBehaviorRange, "xUserBehaviorsVal"},
+		{r.xItemFeature, x, xWidth, si.ItemFeatureRange, "xItemFeatureVal"},
+		{r.xCtxFeature, x, xWidth, si.CtxFeatureRange, "xCtxFeatureVal"},
+		{r.y, y, yWidth, [2]int{0, yWidth}, "y"},
+	}
+	r.batch = &preparedBatch{}
+	for _, f := range feeds {
+		var feed *batchFeed
+		if feed, err = newBatchFeed(f.node, f.name, f.src, f.width, f.cols); err != nil {
+			return
+		}
+		// the float16 inputs of MixedPrecision
+		feed.half = r.lossScale != nil && f.node != r.y
+		r.batch.feeds = append(r.batch.feeds, feed)
+	}
+	r.batch.ids, err = newIdFeeds(r.m, x, xWidth, si.IdRange)
+	return
+}
+
+// let feeds the samples [start, end) of the prepared batch, the last batch
+// is filled to batchSize rows
+func (r *replica) let(start, end int) (err error) {
+	if err = r.batch.let(start, end); err != nil {
+		return
+	}
+	if r.slate != nil {
+		if err = r.slate.let(start, end); err != nil {
+			return fmt.Errorf("unable to let slate: %v", err)
+		}
+	}
+	for _, mba := range r.mbaRowWeights {
+		if err = mba.let(start, end); err != nil {
+			return fmt.Errorf("unable to let mini-batch aware row weights: %v", err)
+		}
+	}
+	return
+}
+
+// WithDataParallel trains on k replicas of the Model on k goroutines, every
+// solver step runs k batches at once, one per replica, then steps by the
+// averaged gradients. newReplica should return a new Model of the same
+// architecture and options in its own graph, the weights are copied from the
+// trained Model. The effective batch size is k * batchSize.
+func WithDataParallel(k int, newReplica func() Model) TrainOption {
+	return func(opts *TrainOpts) {
+		opts.Replicas = k
+		opts.NewReplica = newReplica
+	}
+}
+
+// newReplicas builds the replica of m and the other trainOpts.Replicas-1
+// replicas with the weights of m
+func newReplicas(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int,
+	batchSize, outputs int, m Model, trainOpts *TrainOpts,
+) (replicas []*replica, err error) {
+	k := trainOpts.Replicas
+	if k <= 1 {
+		k = 1
+	} else if trainOpts.NewReplica == nil {
+		return nil, fmt.Errorf("data parallel of %d replicas without NewReplica", k)
+	}
+	for i := 0; i < k; i++ {
+		rm := m
+		if i != 0 {
+			if rm = trainOpts.NewReplica(); rm == m || rm.Graph() == m.Graph() {
+				return nil, fmt.Errorf("replica %d shares the graph of the trained model", i)
+			}
+			if err = checkObjective(trainOpts.Objective, trainOpts.Classes, rm); err != nil {
+				return
+			}
+		}
+		var r *replica
+		if r, err = newReplica(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim,
+			batchSize, outputs, rm, true, trainOpts); err != nil {
+			return nil, fmt.Errorf("replica %d: %v", i, err)
+		}
+		replicas = append(replicas, r)
+	}
+	master := m.Learnable()
+	for i, r := range replicas[1:] {
+		nodes := r.m.Learnable()
+		if len(nodes) != len(master) {
+			return nil, fmt.Errorf("replica %d has %d learnable nodes != %d", i+1, len(nodes), len(master))
+		}
+		for j, n := range nodes {
+			if !n.Shape().Eq(master[j].Shape()) {
+				return nil, fmt.Errorf("replica %d node %s shape %v != %v", i+1, n.Name(), n.Shape(), master[j].Shape())
+			}
+		}
+	}
+	syncWeights(replicas)
+	return
+}
+
+// runReplicas runs the batches of the replicas concurrently, the batch of
+// replicas[i] is batches[i]
+func runReplicas(replicas []*replica, batches [][2]int) error {
+	if len(batches) == 1 {
+		return replicas[0].run(batches[0])
+	}
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(batches))
+	)
+	for i := range batches {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = replicas[i].run(batches[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("replica %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *replica) run(batch [2]int) (err error) {
+	if err = r.let(batch[0], batch[1]); err != nil {
+		return
+	}
+	return r.vm.RunAll()
+}
+
+// syncWeights copies the weights of the first replica to the others
+func syncWeights(replicas []*replica) {
+	if len(replicas) <= 1 {
+		return
+	}
+	master := replicas[0].m.Learnable()
+	for _, r := range replicas[1:] {
+		for j, node := range r.m.Learnable() {
+			copy(node.Value().Data().([]float32), master[j].Value().Data().([]float32))
+		}
+	}
+}
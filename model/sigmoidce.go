@@ -0,0 +1,131 @@
+package model
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+
+	"github.com/chewxy/hm"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// SigmoidCrossEntropy is the fused node of the binary cross entropy of
+// sigmoid(logits) and yTrue of the same shape, element wise. It's computed
+// of the logits by the log-sum-exp trick
+//
+//	max(x, 0) - x * y + log(1 + exp(-|x|))
+//
+// so it neither overflows of the large logits nor takes the log of the
+// sigmoid underflowed to 0 or rounded to 1. The gradient of the logits is
+// sigmoid(x) - y, yTrue is not differentiated.
+func SigmoidCrossEntropy(logits, yTrue *G.Node) (retVal *G.Node, err error) {
+	if !logits.Shape().Eq(yTrue.Shape()) {
+		err = fmt.Errorf("logits shape %v mismatch yTrue shape %v", logits.Shape(), yTrue.Shape())
+		return
+	}
+	return G.ApplyOp(sigmoidCEOp{}, logits, yTrue)
+}
+
+// sigmoidCEOp is the element wise op of SigmoidCrossEntropy
+type sigmoidCEOp struct{}
+
+func (op sigmoidCEOp) Arity() int { return 2 }
+
+func (op sigmoidCEOp) Type() hm.Type {
+	a := hm.TypeVariable('a')
+	return hm.NewFnType(a, a, a)
+}
+
+func (op sigmoidCEOp) InferShape(inputs ...G.DimSizer) (tensor.Shape, error) {
+	if len(inputs) != 2 {
+		return nil, fmt.Errorf("sigmoid cross entropy expects 2 inputs, got %d", len(inputs))
+	}
+	s, ok := inputs[0].(tensor.Shape)
+	if !ok {
+		return nil, fmt.Errorf("sigmoid cross entropy expects the shape input, got %T", inputs[0])
+	}
+	return s.Clone(), nil
+}
+
+func (op sigmoidCEOp) Do(inputs ...G.Value) (G.Value, error) {
+	if len(inputs) != 2 {
+		return nil, fmt.Errorf("sigmoid cross entropy expects 2 inputs, got %d", len(inputs))
+	}
+	x, ok := inputs[0].(tensor.Tensor)
+	if !ok {
+		return nil, fmt.Errorf("sigmoid cross entropy expects the tensor logits, got %T", inputs[0])
+	}
+	y, ok := inputs[1].(tensor.Tensor)
+	if !ok {
+		return nil, fmt.Errorf("sigmoid cross entropy expects the tensor targets, got %T", inputs[1])
+	}
+	switch xs := x.Data().(type) {
+	case []float32:
+		ys, ok := y.Data().([]float32)
+		if !ok || len(ys) != len(xs) {
+			return nil, fmt.Errorf("sigmoid cross entropy targets %v mismatch logits %v", y.Shape(), x.Shape())
+		}
+		losses := make([]float32, len(xs))
+		for i := range xs {
+			losses[i] = float32(sigmoidCE(float64(xs[i]), float64(ys[i])))
+		}
+		return tensor.New(tensor.WithShape(x.Shape().Clone()...), tensor.WithBacking(losses)), nil
+	case []float64:
+		ys, ok := y.Data().([]float64)
+		if !ok || len(ys) != len(xs) {
+			return nil, fmt.Errorf("sigmoid cross entropy targets %v mismatch logits %v", y.Shape(), x.Shape())
+		}
+		losses := make([]float64, len(xs))
+		for i := range xs {
+			losses[i] = sigmoidCE(xs[i], ys[i])
+		}
+		return tensor.New(tensor.WithShape(x.Shape().Clone()...), tensor.WithBacking(losses)), nil
+	default:
+		return nil, fmt.Errorf("sigmoid cross entropy of %v not supported", x.Dtype())
+	}
+}
+
+// sigmoidCE is -y * log(sigmoid(x)) - (1 - y) * log(1 - sigmoid(x))
+func sigmoidCE(x, y float64) float64 {
+	return math.Max(x, 0) - x*y + math.Log1p(math.Exp(-math.Abs(x)))
+}
+
+func (op sigmoidCEOp) ReturnsPtr() bool { return false }
+
+func (op sigmoidCEOp) CallsExtern() bool { return false }
+
+func (op sigmoidCEOp) OverwritesInput() int { return -1 }
+
+func (op sigmoidCEOp) WriteHash(h hash.Hash) { fmt.Fprint(h, "SigmoidCrossEntropy") }
+
+func (op sigmoidCEOp) Hashcode() uint32 {
+	h := fnv.New32a()
+	op.WriteHash(h)
+	return h.Sum32()
+}
+
+func (op sigmoidCEOp) String() string { return "SigmoidCrossEntropy" }
+
+func (op sigmoidCEOp) DiffWRT(inputs int) []bool { return []bool{true, false} }
+
+// SymDiff is (sigmoid(x) - y) * grad of the logits
+func (op sigmoidCEOp) SymDiff(inputs G.Nodes, output, grad *G.Node) (G.Nodes, error) {
+	if len(inputs) != 2 {
+		return nil, fmt.Errorf("sigmoid cross entropy expects 2 inputs, got %d", len(inputs))
+	}
+	prob, err := G.Sigmoid(inputs[0])
+	if err != nil {
+		return nil, err
+	}
+	residual, err := G.Sub(prob, inputs[1])
+	if err != nil {
+		return nil, err
+	}
+	dx, err := G.HadamardProd(residual, grad)
+	if err != nil {
+		return nil, err
+	}
+	return G.Nodes{dx, nil}, nil
+}
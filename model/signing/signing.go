@@ -0,0 +1,100 @@
+// Package signing signs the model artifacts by ed25519 at export and
+// verifies them on load, so the edge nodes refuse the tampered or corrupted
+// models pulled over the untrusted networks. The keys and the signatures are
+// kept as the base64 text.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrUnsigned is returned by the verification of no signature
+	ErrUnsigned = errors.New("artifact is not signed")
+	// ErrBadSignature is returned by the verification of a tampered or
+	// corrupted artifact, or of another key
+	ErrBadSignature = errors.New("artifact signature mismatch")
+)
+
+// GenerateKey generates the key pair of signing
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// Sign returns the signature of data
+func Sign(key ed25519.PrivateKey, data []byte) []byte {
+	return ed25519.Sign(key, data)
+}
+
+// Verify checks sig is the signature of data by the private key of key
+func Verify(key ed25519.PublicKey, data, sig []byte) error {
+	if len(sig) == 0 {
+		return ErrUnsigned
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("public key of %d bytes, expected %d", len(key), ed25519.PublicKeySize)
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Encode returns the base64 text of a key or a signature
+func Encode(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Decode returns the key or the signature of the base64 text of size bytes
+func Decode(s string, size int) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("%d bytes decoded, expected %d", len(b), size)
+	}
+	return b, nil
+}
+
+// DecodePublicKey returns the public key of the base64 text
+func DecodePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := Decode(s, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %v", err)
+	}
+	return b, nil
+}
+
+// WriteKeyFile writes key as the base64 text readable by the owner only
+func WriteKeyFile(path string, key []byte) error {
+	return os.WriteFile(path, []byte(Encode(key)+"\n"), 0600)
+}
+
+// ReadPublicKeyFile reads the public key written by WriteKeyFile
+func ReadPublicKeyFile(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePublicKey(string(data))
+}
+
+// ReadPrivateKeyFile reads the private key written by WriteKeyFile
+func ReadPrivateKeyFile(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := Decode(string(data), ed25519.PrivateKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("decode private key %s: %v", path, err)
+	}
+	return b, nil
+}
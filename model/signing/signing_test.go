@@ -0,0 +1,47 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSigning(t *testing.T) {
+	Convey("sign and verify", t, func() {
+		pub, priv, err := GenerateKey()
+		So(err, ShouldBeNil)
+		data := []byte("model bundle")
+		sig := Sign(priv, data)
+		So(Verify(pub, data, sig), ShouldBeNil)
+
+		So(Verify(pub, []byte("model bundlf"), sig), ShouldEqual, ErrBadSignature)
+		So(Verify(pub, data, nil), ShouldEqual, ErrUnsigned)
+		other, _, err := GenerateKey()
+		So(err, ShouldBeNil)
+		So(Verify(other, data, sig), ShouldEqual, ErrBadSignature)
+		So(Verify(pub[:8], data, sig), ShouldNotBeNil)
+	})
+
+	Convey("key files", t, func() {
+		pub, priv, err := GenerateKey()
+		So(err, ShouldBeNil)
+		dir := t.TempDir()
+		So(WriteKeyFile(filepath.Join(dir, "k.pub"), pub), ShouldBeNil)
+		So(WriteKeyFile(filepath.Join(dir, "k.key"), priv), ShouldBeNil)
+		readPub, err := ReadPublicKeyFile(filepath.Join(dir, "k.pub"))
+		So(err, ShouldBeNil)
+		So(readPub, ShouldResemble, pub)
+		readPriv, err := ReadPrivateKeyFile(filepath.Join(dir, "k.key"))
+		So(err, ShouldBeNil)
+		So(readPriv, ShouldResemble, priv)
+
+		_, err = ReadPrivateKeyFile(filepath.Join(dir, "k.pub"))
+		So(err, ShouldNotBeNil)
+		_, err = DecodePublicKey("not base64")
+		So(err, ShouldNotBeNil)
+		_, err = Decode(Encode(pub), ed25519.SignatureSize)
+		So(err, ShouldNotBeNil)
+	})
+}
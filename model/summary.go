@@ -0,0 +1,127 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// LayerSummary is the summary of a learnable node of the model
+type LayerSummary struct {
+	Name   string
+	Shape  tensor.Shape
+	Params int
+}
+
+// ModelSummary is the layers, parameter count and estimated compute cost of a
+// model, it helps to choose the configurations fitting the edge devices.
+type ModelSummary struct {
+	Layers []LayerSummary
+	Params int
+	// BatchSize is the batch size of the forward graph, 0 if Fwd is not called
+	BatchSize int
+	// FLOPs is the estimated floating point operations of one forward pass
+	// of BatchSize samples. Matrix multiplications count 2 * m * k * n,
+	// element-wise ops count one per output element, shape only ops like
+	// Reshape, Transpose, Concat count nothing.
+	FLOPs int
+}
+
+// Summary summarizes m. The FLOPs are estimated on the forward graph, so it
+// should be called after Train or InitForwardOnlyVm, or FLOPs will be 0.
+func Summary(m Model) (s *ModelSummary) {
+	s = &ModelSummary{}
+	for _, n := range m.Learnable() {
+		layer := LayerSummary{
+			Name:   n.Name(),
+			Shape:  n.Shape().Clone(),
+			Params: n.Shape().TotalSize(),
+		}
+		s.Layers = append(s.Layers, layer)
+		s.Params += layer.Params
+	}
+	if m.Out() == nil {
+		return
+	}
+	if in := m.In(); len(in) > 0 && in[0] != nil {
+		s.BatchSize = in[0].Shape()[0]
+	}
+	// walk the forward graph from the output, the backward nodes built by
+	// G.Grad are not reachable from there
+	g := m.Graph()
+	visited := make(map[*G.Node]struct{})
+	var walk func(n *G.Node)
+	walk = func(n *G.Node) {
+		if _, ok := visited[n]; ok {
+			return
+		}
+		visited[n] = struct{}{}
+		children := nodeChildren(g, n)
+		s.FLOPs += nodeFLOPs(n, children)
+		for _, child := range children {
+			walk(child)
+		}
+	}
+	walk(m.Out())
+	return
+}
+
+// nodeChildren returns the operands of n in order
+func nodeChildren(g *G.ExprGraph, n *G.Node) (children G.Nodes) {
+	it := g.From(n.ID())
+	if it == nil {
+		return
+	}
+	for it.Next() {
+		children = append(children, it.Node().(*G.Node))
+	}
+	return
+}
+
+// nodeFLOPs estimates the floating point operations to compute n from its
+// children
+func nodeFLOPs(n *G.Node, children G.Nodes) int {
+	op := n.Op()
+	if op == nil || len(children) == 0 {
+		return 0
+	}
+	outSize := n.Shape().TotalSize()
+	switch strings.TrimPrefix(fmt.Sprintf("%T", op), "*") {
+	case "gorgonia.reshapeOp", "gorgonia.transposeOp", "gorgonia.concatOp", "gorgonia.sliceOp", "gorgonia.sizeOp":
+		return 0
+	case "gorgonia.linAlgBinOp":
+		opStr := op.String()
+		if strings.Contains(opStr, "⊗") {
+			return outSize
+		}
+		// the inner dim of A × B, Aᵀ × B, a ⋅ b
+		a := children[0]
+		aShape := a.Shape()
+		k := aShape[len(aShape)-1]
+		if strings.HasPrefix(opStr, "Aᵀ") && len(aShape) > 1 {
+			k = aShape[len(aShape)-2]
+		}
+		return 2 * outSize * k
+	}
+	return outSize
+}
+
+// String formats s as a table
+func (s *ModelSummary) String() string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Layer\tShape\tParams")
+	for _, l := range s.Layers {
+		fmt.Fprintf(w, "%s\t%v\t%d\n", l.Name, l.Shape, l.Params)
+	}
+	_ = w.Flush()
+	fmt.Fprintf(&sb, "Total params: %d\n", s.Params)
+	if s.BatchSize > 0 {
+		fmt.Fprintf(&sb, "Estimated FLOPs: %d per forward pass of batch %d, %d per sample\n",
+			s.FLOPs, s.BatchSize, s.FLOPs/s.BatchSize)
+	}
+	return sb.String()
+}
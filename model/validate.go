@@ -0,0 +1,127 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"gorgonia.org/tensor"
+)
+
+// ShapeChecker is implemented by the models having constraints on the input
+// dims, e.g. DIN needs uBehaviorDim == iFeatureDim for the attention.
+type ShapeChecker interface {
+	// CheckShapes returns every constraint the dims violate
+	CheckShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int) []error
+}
+
+// ShapeError aggregates all the mismatches found by ValidateShapes
+type ShapeError struct {
+	Mismatches []error
+}
+
+func (e *ShapeError) Error() string {
+	msgs := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		msgs[i] = m.Error()
+	}
+	return fmt.Sprintf("%d shape mismatches: %s", len(e.Mismatches), strings.Join(msgs, "; "))
+}
+
+// ValidateShapes checks the declared dims against each other, the sample
+// info ranges against the input tensor width and the batch settings, before
+// any graph is built. All the mismatches are returned in one *ShapeError, or
+// nil if there is none. m and targets could be nil if not available yet.
+func ValidateShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim int,
+	numExamples, batchSize int,
+	si *rcmd.SampleInfo,
+	inputs, targets tensor.Tensor,
+	m Model,
+) (err error) {
+	var mismatches []error
+	addf := func(format string, a ...interface{}) {
+		mismatches = append(mismatches, fmt.Errorf(format, a...))
+	}
+
+	for _, d := range []struct {
+		name string
+		dim  int
+	}{
+		{"uProfileDim", uProfileDim},
+		{"uBehaviorSize", uBehaviorSize},
+		{"uBehaviorDim", uBehaviorDim},
+		{"iFeatureDim", iFeatureDim},
+		{"cFeatureDim", cFeatureDim},
+	} {
+		if d.dim <= 0 {
+			addf("%s %d should be positive", d.name, d.dim)
+		}
+	}
+	if checker, ok := m.(ShapeChecker); ok {
+		mismatches = append(mismatches, checker.CheckShapes(uProfileDim, uBehaviorSize, uBehaviorDim, iFeatureDim, cFeatureDim)...)
+	}
+
+	if batchSize <= 0 {
+		addf("batchSize %d should be positive", batchSize)
+	}
+	if numExamples <= 0 {
+		addf("numExamples %d should be positive", numExamples)
+	}
+
+	inputWidth := -1
+	if inputs == nil {
+		addf("inputs is nil")
+	} else if inputs.Dims() != 2 {
+		addf("inputs shape %v should be [numExamples, width]", inputs.Shape())
+	} else {
+		inputWidth = inputs.Shape()[1]
+		if inputs.Shape()[0] < numExamples {
+			addf("inputs rows %d < numExamples %d", inputs.Shape()[0], numExamples)
+		}
+	}
+	if targets != nil {
+		if targets.Dims() != 2 || targets.Shape()[1] != 1 {
+			addf("targets shape %v should be [numExamples, 1]", targets.Shape())
+		} else if targets.Shape()[0] < numExamples {
+			addf("targets rows %d < numExamples %d", targets.Shape()[0], numExamples)
+		}
+	}
+
+	if si == nil {
+		addf("sample info is nil")
+	} else {
+		for _, r := range []struct {
+			name  string
+			rng   [2]int
+			width int
+		}{
+			{"UserProfileRange", si.UserProfileRange, uProfileDim},
+			{"UserBehaviorRange", si.UserBehaviorRange, uBehaviorSize * uBehaviorDim},
+			{"ItemFeatureRange", si.ItemFeatureRange, iFeatureDim},
+			{"CtxFeatureRange", si.CtxFeatureRange, cFeatureDim},
+		} {
+			if r.rng[0] < 0 || r.rng[1] < r.rng[0] {
+				addf("%s %v is not a valid [start, end)", r.name, r.rng)
+				continue
+			}
+			if r.rng[1]-r.rng[0] != r.width {
+				addf("%s %v width %d != declared %d", r.name, r.rng, r.rng[1]-r.rng[0], r.width)
+			}
+			if inputWidth >= 0 && r.rng[1] > inputWidth {
+				addf("%s %v exceeds inputs width %d", r.name, r.rng, inputWidth)
+			}
+		}
+		if _, ok := m.(IdInputer); ok {
+			if ids := si.IdRange; ids[0] < 0 || ids[1] <= ids[0] {
+				addf("IdRange %v of the id inputs of %T is empty", ids, m)
+			} else if inputWidth >= 0 && ids[1] > inputWidth {
+				addf("IdRange %v exceeds inputs width %d", ids, inputWidth)
+			}
+		}
+	}
+
+	if len(mismatches) != 0 {
+		err = &ShapeError{Mismatches: mismatches}
+	}
+	return
+}
@@ -0,0 +1,467 @@
+// Package wide is the model of the wide sparse feature spaces, e.g. the
+// one-hot and hashed features. The samples are fed as the CSR ids and values
+// into the embedding sum of the columns (see layers.SparseInput) followed by
+// a hidden layer, so neither the training samples nor the input tensors are
+// densified. The Fitter is a rcmd.SparseFitter.
+//
+// With Options.LatentCross the ctx features, of the SampleInfo.CtxFeatureRange,
+// are not summed with the other columns but gate the hidden layer element
+// wise, h * (1 + ctx W), the Latent Cross of Beutel et al. 2018, which models
+// the time and device context of the interactions better than the
+// concatenation.
+package wide
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/layers"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+const (
+	DefaultDim          = 16
+	DefaultHidden       = 32
+	DefaultEpochs       = 10
+	DefaultBatchSize    = 200
+	DefaultLearningRate = 0.01
+)
+
+// Options of Fitter, the zero values are the defaults
+type Options struct {
+	// Dim is the embedding dim of every column
+	Dim          int     `json:"dim" yaml:"dim"`
+	Hidden       int     `json:"hidden" yaml:"hidden"`
+	Epochs       int     `json:"epochs" yaml:"epochs"`
+	BatchSize    int     `json:"batchSize" yaml:"batchSize"`
+	LearningRate float64 `json:"learningRate" yaml:"learningRate"`
+	// LatentCross gates the hidden layer by the ctx features instead of
+	// summing them, it's ignored of the samples of no ctx features
+	LatentCross bool `json:"latentCross" yaml:"latentCross"`
+}
+
+func (o *Options) defaults() {
+	if o.Dim <= 0 {
+		o.Dim = DefaultDim
+	}
+	if o.Hidden <= 0 {
+		o.Hidden = DefaultHidden
+	}
+	if o.Epochs <= 0 {
+		o.Epochs = DefaultEpochs
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	if o.LearningRate <= 0 {
+		o.LearningRate = DefaultLearningRate
+	}
+}
+
+// WideNet is the embedding sum of the sparse columns, a ReLU hidden layer and
+// the sigmoid output, of the batches of batchSize rows of at most slots
+// non-zeros. The hidden layer is gated by the ctx columns [ctxFrom, ctxTo) if
+// any.
+type WideNet struct {
+	cols, dim, hidden int
+	batchSize, slots  int
+	ctxFrom, ctxTo    int
+
+	g  *G.ExprGraph
+	vm G.VM
+	in *layers.SparseInput
+	// ctx is the dense ctx features of the latent cross, ctxT is its reused
+	// backing
+	ctx  *G.Node
+	ctxT tensor.Tensor
+
+	emb, b0, w1, b1, w2 *G.Node
+	wc                  *G.Node
+	// logits and out are the output before and after the sigmoid
+	logits, out *G.Node
+}
+
+type wideModel struct {
+	Cols   int       `json:"cols"`
+	Dim    int       `json:"dim"`
+	Hidden int       `json:"hidden"`
+	Slots  int       `json:"slots"`
+	Emb    []float32 `json:"emb"`
+	B0     []float32 `json:"b0"`
+	W1     []float32 `json:"w1"`
+	B1     []float32 `json:"b1"`
+	W2     []float32 `json:"w2"`
+	// CtxFrom and CtxTo are the ctx columns of the latent cross of the
+	// weights Wc, none if equal
+	CtxFrom int       `json:"ctxFrom,omitempty"`
+	CtxTo   int       `json:"ctxTo,omitempty"`
+	Wc      []float32 `json:"wc,omitempty"`
+}
+
+func (m *wideModel) ctxWidth() int {
+	return m.CtxTo - m.CtxFrom
+}
+
+// newWideNet builds the graph of m, the weights are initialized if m has none
+func newWideNet(m *wideModel, batchSize int) (n *WideNet, err error) {
+	n = &WideNet{
+		cols: m.Cols, dim: m.Dim, hidden: m.Hidden,
+		batchSize: batchSize, slots: m.Slots,
+		ctxFrom: m.CtxFrom, ctxTo: m.CtxTo,
+		g: G.NewGraph(),
+	}
+	n.in = layers.NewSparseInput(n.g, "x", batchSize, m.Slots)
+	n.emb = layers.NewWeight(n.g, "emb", m.Cols, m.Dim, G.GlorotN(1.0), m.Emb)
+	n.b0 = layers.NewWeight(n.g, "b0", 1, m.Dim, G.Zeroes(), m.B0)
+	n.w1 = layers.NewWeight(n.g, "w1", m.Dim, m.Hidden, G.GlorotN(1.0), m.W1)
+	n.b1 = layers.NewWeight(n.g, "b1", 1, m.Hidden, G.Zeroes(), m.B1)
+	n.w2 = layers.NewWeight(n.g, "w2", m.Hidden, 1, G.GlorotN(1.0), m.W2)
+
+	var sum, h *G.Node
+	if sum, err = n.in.EmbeddingSum(n.emb); err != nil {
+		return
+	}
+	if sum, err = G.BroadcastAdd(sum, n.b0, nil, []byte{0}); err != nil {
+		return
+	}
+	if h, err = G.Rectify(sum); err != nil {
+		return
+	}
+	if h, err = layers.Dense(h, n.w1, nil); err != nil {
+		return
+	}
+	if h, err = G.BroadcastAdd(h, n.b1, nil, []byte{0}); err != nil {
+		return
+	}
+	if h, err = G.Rectify(h); err != nil {
+		return
+	}
+	if width := m.ctxWidth(); width > 0 {
+		// the zero weights start of the identity gate
+		n.ctx = G.NewMatrix(n.g, model.DT, G.WithShape(batchSize, width), G.WithName("ctx"))
+		n.ctxT = tensor.New(tensor.WithShape(batchSize, width), tensor.WithBacking(make([]float32, batchSize*width)))
+		n.wc = layers.NewWeight(n.g, "wc", width, m.Hidden, G.Zeroes(), m.Wc)
+		var gate *G.Node
+		if gate, err = layers.Dense(n.ctx, n.wc, nil); err != nil {
+			return
+		}
+		if gate, err = G.Add(gate, G.NewConstant(float32(1))); err != nil {
+			return
+		}
+		if h, err = G.HadamardProd(h, gate); err != nil {
+			return
+		}
+	}
+	if n.logits, err = layers.Dense(h, n.w2, nil); err != nil {
+		return
+	}
+	n.out, err = G.Sigmoid(n.logits)
+	return
+}
+
+func (n *WideNet) learnables() G.Nodes {
+	nodes := G.Nodes{n.emb, n.b0, n.w1, n.b1, n.w2}
+	if n.wc != nil {
+		nodes = append(nodes, n.wc)
+	}
+	return nodes
+}
+
+// wideInput is the samples split of the ctx columns of the latent cross
+type wideInput struct {
+	sparse *rcmd.SparseSample
+	// ctx is the dense ctx features of the rows, of the width ctxTo-ctxFrom
+	ctx   []float32
+	width int
+}
+
+// splitContext moves the non-zeros of the ctx columns [from, to) of s to the
+// dense ctx rows, s is returned as is if to <= from
+func splitContext(s *rcmd.SparseSample, from, to int) *wideInput {
+	width := to - from
+	if width <= 0 {
+		return &wideInput{sparse: s}
+	}
+	in := &wideInput{sparse: rcmd.NewSparseSample(s.Cols), ctx: make([]float32, s.Rows()*width), width: width}
+	for i := 0; i < s.Rows(); i++ {
+		indices, values := s.Row(i)
+		for k, j := range indices {
+			if j >= from && j < to {
+				in.ctx[i*width+j-from] = values[k]
+				continue
+			}
+			in.sparse.Indices = append(in.sparse.Indices, j)
+			in.sparse.Values = append(in.sparse.Values, values[k])
+		}
+		in.sparse.Indptr = append(in.sparse.Indptr, len(in.sparse.Indices))
+	}
+	return in
+}
+
+// let sets the rows [start, end) of in as the batch of n
+func (n *WideNet) let(in *wideInput, start, end int) error {
+	if err := n.in.Let(in.sparse.Batch(start, end)); err != nil {
+		return err
+	}
+	if n.ctx == nil {
+		return nil
+	}
+	backing := n.ctxT.Data().([]float32)
+	copied := copy(backing, in.ctx[start*in.width:end*in.width])
+	for i := copied; i < len(backing); i++ {
+		backing[i] = 0
+	}
+	return G.Let(n.ctx, n.ctxT)
+}
+
+func (n *WideNet) Marshal() ([]byte, error) {
+	data := func(node *G.Node) []float32 {
+		return node.Value().Data().([]float32)
+	}
+	m := wideModel{
+		Cols: n.cols, Dim: n.dim, Hidden: n.hidden, Slots: n.slots,
+		Emb: data(n.emb), B0: data(n.b0), W1: data(n.w1), B1: data(n.b1), W2: data(n.w2),
+	}
+	if n.wc != nil {
+		m.CtxFrom, m.CtxTo, m.Wc = n.ctxFrom, n.ctxTo, data(n.wc)
+	}
+	return json.Marshal(m)
+}
+
+// Predictor is the rcmd.PredictAbstract of the trained WideNet
+type Predictor struct {
+	mu        sync.Mutex
+	model     *wideModel
+	batchSize int
+	net       *WideNet
+}
+
+// NewPredictorFromJson loads the Predictor of the WideNet.Marshal, predicting
+// the batches of batchSize
+func NewPredictorFromJson(data []byte, batchSize int) (p *Predictor, err error) {
+	m := &wideModel{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return
+	}
+	if m.Cols <= 0 || m.Dim <= 0 || m.Hidden <= 0 || m.Slots <= 0 {
+		return nil, fmt.Errorf("wide model of %d cols, %d dim, %d hidden and %d slots", m.Cols, m.Dim, m.Hidden, m.Slots)
+	}
+	if m.CtxFrom < 0 || m.CtxTo > m.Cols || m.ctxWidth() < 0 {
+		return nil, fmt.Errorf("wide model ctx columns [%d, %d) out of %d cols", m.CtxFrom, m.CtxTo, m.Cols)
+	}
+	for _, w := range []struct {
+		name string
+		data []float32
+		size int
+	}{
+		{"emb", m.Emb, m.Cols * m.Dim},
+		{"b0", m.B0, m.Dim},
+		{"w1", m.W1, m.Dim * m.Hidden},
+		{"b1", m.B1, m.Hidden},
+		{"w2", m.W2, m.Hidden},
+		{"wc", m.Wc, m.ctxWidth() * m.Hidden},
+	} {
+		if len(w.data) != w.size {
+			return nil, fmt.Errorf("wide model %s of %d weights != %d", w.name, len(w.data), w.size)
+		}
+	}
+	p = &Predictor{model: m, batchSize: batchSize}
+	if err = p.build(m.Slots); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// build compiles the forward graph of slots
+func (p *Predictor) build(slots int) (err error) {
+	m := *p.model
+	m.Slots = slots
+	if p.net, err = newWideNet(&m, p.batchSize); err != nil {
+		return
+	}
+	p.net.vm = G.NewTapeMachine(p.net.g)
+	return
+}
+
+// Predict implements rcmd.PredictAbstract, X is the dense sample vectors
+// converted to the CSR batches
+func (p *Predictor) Predict(X tensor.Tensor) tensor.Tensor {
+	rows, cols := X.Shape()[0], X.Shape()[1]
+	data, ok := X.Data().([]float32)
+	if !ok || cols != p.model.Cols {
+		log.Errorf("wide input of %d cols != %d cols", cols, p.model.Cols)
+		return nil
+	}
+	sparse, err := rcmd.ToSparse(data, rows, cols)
+	if err != nil {
+		log.Errorf("wide input: %v", err)
+		return nil
+	}
+	in := splitContext(sparse, p.model.CtxFrom, p.model.CtxTo)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// the rows of more non-zeros than the training need more slots
+	if slots := in.sparse.MaxRowNnz(); slots > p.net.slots {
+		if err = p.build(slots); err != nil {
+			log.Errorf("build wide net of %d slots: %v", slots, err)
+			return nil
+		}
+	}
+	y := make([]float32, 0, rows)
+	for start := 0; start < rows; start += p.batchSize {
+		end := start + p.batchSize
+		if end > rows {
+			end = rows
+		}
+		if err = p.net.let(in, start, end); err != nil {
+			log.Errorf("let wide batch: %v", err)
+			return nil
+		}
+		if err = p.net.vm.RunAll(); err != nil {
+			log.Errorf("predict wide batch: %v", err)
+			return nil
+		}
+		y = append(y, p.net.out.Value().Data().([]float32)[:end-start]...)
+		p.net.vm.Reset()
+	}
+	return tensor.New(tensor.WithShape(rows, 1), tensor.WithBacking(y))
+}
+
+// Marshal is the WideNet.Marshal of p, of the embedding rows imported
+func (p *Predictor) Marshal() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return json.Marshal(p.model)
+}
+
+// EmbeddingTables implements layers.EmbeddingTabler, "emb" is the table of
+// the embeddings of the sample columns
+func (p *Predictor) EmbeddingTables() []string {
+	return []string{"emb"}
+}
+
+// WithEmbeddingTable implements layers.EmbeddingTabler. The table shares
+// the weights of the model, so the rows imported are kept by the nets of
+// more slots and saved by Marshal.
+func (p *Predictor) WithEmbeddingTable(name string, fn func(table *G.Node) error) error {
+	if name != "emb" {
+		return fmt.Errorf("wide model has no embedding table %q", name)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fn(p.net.emb)
+}
+
+// Fitter trains the WideNet on the sparse samples
+type Fitter struct {
+	Options Options
+}
+
+// Fit converts the dense samples to the CSR and fits them, see FitSparse
+func (f *Fitter) Fit(trainSample *rcmd.TrainSample) (rcmd.PredictAbstract, error) {
+	sparse, err := rcmd.ToSparse(trainSample.X, trainSample.Rows, trainSample.XCols)
+	if err != nil {
+		return nil, err
+	}
+	sample := *trainSample
+	sample.X, sample.Sparse = nil, sparse
+	return f.FitSparse(&sample)
+}
+
+// FitSparse implements rcmd.SparseFitter, the binary log loss is minimized
+// by the Adam solver
+func (f *Fitter) FitSparse(trainSample *rcmd.TrainSample) (rcmd.PredictAbstract, error) {
+	opts := f.Options
+	opts.defaults()
+	sparse := trainSample.Sparse
+	if sparse == nil || sparse.Rows() != trainSample.Rows || len(trainSample.Y) != trainSample.Rows {
+		return nil, fmt.Errorf("wide samples of %d rows should be sparse of as many labels", trainSample.Rows)
+	}
+	if trainSample.Rows == 0 {
+		return nil, fmt.Errorf("no wide sample")
+	}
+	m := &wideModel{Cols: sparse.Cols, Dim: opts.Dim, Hidden: opts.Hidden}
+	if opts.LatentCross {
+		m.CtxFrom, m.CtxTo = trainSample.Info.CtxFeatureRange[0], trainSample.Info.CtxFeatureRange[1]
+		if m.ctxWidth() <= 0 || m.CtxFrom < 0 || m.CtxTo > m.Cols {
+			log.Warnf("latent cross of ctx columns [%d, %d) of %d cols ignored", m.CtxFrom, m.CtxTo, m.Cols)
+			m.CtxFrom, m.CtxTo = 0, 0
+		}
+	}
+	in := splitContext(sparse, m.CtxFrom, m.CtxTo)
+	if m.Slots = in.sparse.MaxRowNnz(); m.Slots == 0 {
+		m.Slots = 1
+	}
+	net, err := newWideNet(m, opts.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// the rows padding the last batch are masked out of the loss
+	var (
+		batchSize = opts.BatchSize
+		yBacking  = make([]float32, batchSize)
+		mBacking  = make([]float32, batchSize)
+		yTrue     = G.NewMatrix(net.g, model.DT, G.WithShape(batchSize, 1), G.WithName("y"))
+		mask      = G.NewMatrix(net.g, model.DT, G.WithShape(batchSize, 1), G.WithName("mask"))
+		yT        = tensor.New(tensor.WithShape(batchSize, 1), tensor.WithBacking(yBacking))
+		mT        = tensor.New(tensor.WithShape(batchSize, 1), tensor.WithBacking(mBacking))
+	)
+	losses, err := model.SigmoidCrossEntropy(net.logits, yTrue)
+	if err != nil {
+		return nil, err
+	}
+	loss := G.Must(G.Mean(G.Must(G.HadamardProd(losses, mask))))
+	if _, err = G.Grad(loss, net.learnables()...); err != nil {
+		return nil, err
+	}
+	vm := G.NewTapeMachine(net.g, G.BindDualValues(net.learnables()...))
+	defer vm.Close()
+	solver := G.NewAdamSolver(G.WithLearnRate(opts.LearningRate), G.WithBatchSize(float64(batchSize)))
+
+	rows := trainSample.Rows
+	for epoch := 0; epoch < opts.Epochs; epoch++ {
+		var cost float32
+		for start := 0; start < rows; start += batchSize {
+			end := start + batchSize
+			if end > rows {
+				end = rows
+			}
+			if err = net.let(in, start, end); err != nil {
+				return nil, err
+			}
+			for i := range yBacking {
+				yBacking[i], mBacking[i] = 0, 0
+				if start+i < end {
+					yBacking[i], mBacking[i] = trainSample.Y[start+i], 1
+				}
+			}
+			if err = G.Let(yTrue, yT); err != nil {
+				return nil, err
+			}
+			if err = G.Let(mask, mT); err != nil {
+				return nil, err
+			}
+			if err = vm.RunAll(); err != nil {
+				return nil, fmt.Errorf("wide epoch %d batch %d: %v", epoch, start/batchSize, err)
+			}
+			if err = solver.Step(G.NodesToValueGrads(net.learnables())); err != nil {
+				return nil, err
+			}
+			cost += loss.Value().Data().(float32)
+			vm.Reset()
+		}
+		log.Debugf("wide epoch %d cost %v", epoch, cost)
+	}
+
+	data, err := net.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return NewPredictorFromJson(data, batchSize)
+}
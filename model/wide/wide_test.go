@@ -0,0 +1,153 @@
+package wide
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/auxten/go-ctr/model/layers"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/utils"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+func TestWide(t *testing.T) {
+	// the one-hot of 2 fields of 20 categories, the label is of the parity
+	// of the categories
+	const rows, cols = 600, 40
+	rnd := rand.New(rand.NewSource(1))
+	x := make([]float32, rows*cols)
+	y := make([]float32, rows)
+	for i := 0; i < rows; i++ {
+		a, b := rnd.Intn(20), rnd.Intn(20)
+		x[i*cols+a], x[i*cols+20+b] = 1, 1
+		if a%2 == 0 && b%2 == 0 {
+			y[i] = 1
+		}
+	}
+	sparse, err := rcmd.ToSparse(x, rows, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("fit of the sparse samples", t, func() {
+		f := &Fitter{Options: Options{Epochs: 20, BatchSize: 64, LearningRate: 0.05}}
+		pred, err := f.FitSparse(&rcmd.TrainSample{Sparse: sparse, Y: y, Rows: rows, XCols: cols})
+		So(err, ShouldBeNil)
+		X := tensor.New(tensor.WithShape(rows, cols), tensor.WithBacking(x))
+		out := pred.Predict(X)
+		So(out, ShouldNotBeNil)
+		So(out.Shape()[0], ShouldEqual, rows)
+		So(utils.RocAuc32(out.Data().([]float32), y), ShouldBeGreaterThan, 0.9)
+
+		Convey("marshal and load", func() {
+			data, err := pred.(*Predictor).net.Marshal()
+			So(err, ShouldBeNil)
+			loaded, err := NewPredictorFromJson(data, 100)
+			So(err, ShouldBeNil)
+			So(loaded.Predict(X).Data(), ShouldResemble, out.Data())
+
+			// the rows of more non-zeros than the training
+			dense := make([]float32, cols)
+			for j := 0; j < 5; j++ {
+				dense[j] = 1
+			}
+			So(loaded.Predict(tensor.New(tensor.WithShape(1, cols), tensor.WithBacking(dense))), ShouldNotBeNil)
+
+			_, err = NewPredictorFromJson([]byte(`{"cols":2,"dim":1,"hidden":1,"slots":1}`), 100)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("export and import the embedding rows", func() {
+			p := pred.(*Predictor)
+			So(p.EmbeddingTables(), ShouldResemble, []string{"emb"})
+			names := make([]string, cols)
+			for j := range names {
+				names[j] = fmt.Sprintf("f%d", j)
+			}
+			var buf bytes.Buffer
+			So(layers.ExportEmbeddings(&buf, p, "emb", names), ShouldBeNil)
+			embMap, err := rcmd.ReadEmbeddings(bytes.NewReader(buf.Bytes()), DefaultDim)
+			So(err, ShouldBeNil)
+			So(embMap, ShouldHaveLength, cols)
+
+			// the row of the column 0 is of the column 1
+			buf.Reset()
+			So(rcmd.WriteEmbeddings(&buf, word2vec.EmbeddingMap32{"f0": embMap["f1"]}), ShouldBeNil)
+			updated, err := layers.ImportEmbeddings(&buf, p, "emb", names)
+			So(err, ShouldBeNil)
+			So(updated, ShouldEqual, 1)
+			row := make([]float32, cols)
+			row[0], row[20] = 1, 1
+			imported := p.Predict(tensor.New(tensor.WithShape(1, cols), tensor.WithBacking(row))).Data()
+			row[0], row[1] = 0, 1
+			So(imported, ShouldResemble, p.Predict(tensor.New(tensor.WithShape(1, cols), tensor.WithBacking(row))).Data())
+
+			// the rows imported are saved
+			data, err := p.Marshal()
+			So(err, ShouldBeNil)
+			loaded, err := NewPredictorFromJson(data, 100)
+			So(err, ShouldBeNil)
+			So(loaded.Predict(X).Data(), ShouldResemble, p.Predict(X).Data())
+
+			_, err = layers.ImportEmbeddings(strings.NewReader("f99 "+strings.Repeat("0 ", DefaultDim)+"\n"), p, "emb", names)
+			So(err, ShouldNotBeNil)
+			So(layers.ExportEmbeddings(&buf, p, "user", nil), ShouldNotBeNil)
+		})
+	})
+
+	Convey("latent cross of the ctx features", t, func() {
+		// the category of 20 and the ctx flag at the last column, the label is
+		// the parity of the category flipped by the ctx
+		const cols = 21
+		x := make([]float32, rows*cols)
+		y := make([]float32, rows)
+		for i := 0; i < rows; i++ {
+			a, c := rnd.Intn(20), rnd.Intn(2)
+			x[i*cols+a], x[i*cols+20] = 1, float32(c)
+			if (a%2 == 0) != (c == 1) {
+				y[i] = 1
+			}
+		}
+		sample := &rcmd.TrainSample{X: x, Y: y, Rows: rows, XCols: cols}
+		sample.Info.CtxFeatureRange = [2]int{20, 21}
+		f := &Fitter{Options: Options{Epochs: 30, BatchSize: 64, LearningRate: 0.05, LatentCross: true}}
+		pred, err := f.Fit(sample)
+		So(err, ShouldBeNil)
+		net := pred.(*Predictor).net
+		So(net.wc, ShouldNotBeNil)
+		// the ctx columns are not summed
+		So(net.slots, ShouldEqual, 1)
+		X := tensor.New(tensor.WithShape(rows, cols), tensor.WithBacking(x))
+		out := pred.Predict(X)
+		So(out, ShouldNotBeNil)
+		So(utils.RocAuc32(out.Data().([]float32), y), ShouldBeGreaterThan, 0.9)
+
+		data, err := net.Marshal()
+		So(err, ShouldBeNil)
+		loaded, err := NewPredictorFromJson(data, 100)
+		So(err, ShouldBeNil)
+		So(loaded.Predict(X).Data(), ShouldResemble, out.Data())
+		_, err = NewPredictorFromJson([]byte(`{"cols":2,"dim":1,"hidden":1,"slots":1,"emb":[0,0],"b0":[0],"w1":[0],"b1":[0],"w2":[0],"ctxFrom":1,"ctxTo":3}`), 100)
+		So(err, ShouldNotBeNil)
+
+		// no ctx features to cross
+		sample.Info.CtxFeatureRange = [2]int{21, 21}
+		f.Options.Epochs = 1
+		pred, err = f.Fit(sample)
+		So(err, ShouldBeNil)
+		So(pred.(*Predictor).net.wc, ShouldBeNil)
+	})
+
+	Convey("dense samples are fitted as sparse", t, func() {
+		f := &Fitter{Options: Options{Epochs: 1}}
+		_, err := f.Fit(&rcmd.TrainSample{X: x, Y: y, Rows: rows, XCols: cols})
+		So(err, ShouldBeNil)
+		_, err = f.FitSparse(&rcmd.TrainSample{Y: y, Rows: rows, XCols: cols})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 
 	"github.com/auxten/go-ctr/model"
+	"github.com/auxten/go-ctr/model/layers"
+	log "github.com/sirupsen/logrus"
 	G "gorgonia.org/gorgonia"
 	"gorgonia.org/tensor"
 )
@@ -13,6 +15,8 @@ const (
 	att0_1 = 36
 	mlp0_1 = 200
 	mlp1_2 = 80
+
+	defaultDropout = 0.003
 )
 
 type YoutubeDnn struct {
@@ -27,8 +31,11 @@ type YoutubeDnn struct {
 	xUserProfile, xUbMatrix, xItemFeature, xCtxFeature *G.Node
 	//learnable nodes
 	mlp0, mlp1, mlp2 *G.Node
-	d0, d1           float32 // dropout probabilities
-	out              *G.Node
+	d0, d1           float32    // dropout probabilities
+	training         bool       // dropout is only applied in training mode
+	head             model.Head // output layer activation, see model.HeadSetter
+	outputs          int        // output layer width
+	logits, out      *G.Node    // the output before and after the head activation
 }
 
 func (mlp *YoutubeDnn) In() G.Nodes {
@@ -36,14 +43,16 @@ func (mlp *YoutubeDnn) In() G.Nodes {
 }
 
 type mlpModel struct {
-	UProfileDim   int       `json:"uProfileDim"`
-	UBehaviorSize int       `json:"uBehaviorSize"`
-	UBehaviorDim  int       `json:"uBehaviorDim"`
-	IFeatureDim   int       `json:"iFeatureDim"`
-	CFeatureDim   int       `json:"cFeatureDim"`
-	Mlp0          []float32 `json:"mlp0"`
-	Mlp1          []float32 `json:"mlp1"`
-	Mlp2          []float32 `json:"mlp2"`
+	UProfileDim   int        `json:"uProfileDim"`
+	UBehaviorSize int        `json:"uBehaviorSize"`
+	UBehaviorDim  int        `json:"uBehaviorDim"`
+	IFeatureDim   int        `json:"iFeatureDim"`
+	CFeatureDim   int        `json:"cFeatureDim"`
+	Mlp0          []float32  `json:"mlp0"`
+	Mlp1          []float32  `json:"mlp1"`
+	Mlp2          []float32  `json:"mlp2"`
+	Head          model.Head `json:"head,omitempty"`
+	Outputs       int        `json:"outputs,omitempty"`
 }
 
 func (mlp *YoutubeDnn) Marshal() (data []byte, err error) {
@@ -56,6 +65,8 @@ func (mlp *YoutubeDnn) Marshal() (data []byte, err error) {
 		Mlp0:          mlp.mlp0.Value().Data().([]float32),
 		Mlp1:          mlp.mlp1.Value().Data().([]float32),
 		Mlp2:          mlp.mlp2.Value().Data().([]float32),
+		Head:          mlp.head,
+		Outputs:       mlp.outputs,
 	}
 	return json.Marshal(model)
 }
@@ -73,6 +84,7 @@ func NewYoutubeDnnFromJson(data []byte) (mlp *YoutubeDnn, err error) {
 		iFeatureDim   = m.IFeatureDim
 		cFeatureDim   = m.CFeatureDim
 		mlp0_0        = uProfileDim + uBehaviorDim + iFeatureDim + cFeatureDim
+		outputs       = model.OutputDim(m.Outputs)
 	)
 
 	mlp0 := G.NewMatrix(g, model.DT,
@@ -88,9 +100,9 @@ func NewYoutubeDnnFromJson(data []byte) (mlp *YoutubeDnn, err error) {
 	)
 
 	mlp2 := G.NewMatrix(g, model.DT,
-		G.WithShape(mlp1_2, 1),
+		G.WithShape(mlp1_2, outputs),
 		G.WithName("mlp2"),
-		G.WithValue(tensor.New(tensor.WithShape(mlp1_2, 1), tensor.WithBacking(m.Mlp2))),
+		G.WithValue(tensor.New(tensor.WithShape(mlp1_2, outputs), tensor.WithBacking(m.Mlp2))),
 	)
 
 	mlp = &YoutubeDnn{
@@ -103,6 +115,8 @@ func NewYoutubeDnnFromJson(data []byte) (mlp *YoutubeDnn, err error) {
 		mlp0:          mlp0,
 		mlp1:          mlp1,
 		mlp2:          mlp2,
+		head:          m.Head,
+		outputs:       outputs,
 	}
 
 	return
@@ -116,16 +130,52 @@ func (mlp *YoutubeDnn) SetVM(vm G.VM) {
 	mlp.vm = vm
 }
 
+func (mlp *YoutubeDnn) SetTraining(training bool) {
+	mlp.training = training
+}
+
+// SetHead implements model.HeadSetter
+func (mlp *YoutubeDnn) SetHead(h model.Head) {
+	mlp.head = h
+}
+
+// Option configures the YoutubeDnn created by NewYoutubeDnn
+type Option func(mlp *YoutubeDnn)
+
+// WithDropout sets the dropout probabilities of the two hidden MLP layers,
+// 0 disables dropout of the layer.
+func WithDropout(d0, d1 float32) Option {
+	return func(mlp *YoutubeDnn) {
+		for _, d := range []float32{d0, d1} {
+			if d < 0 || d >= 1 {
+				log.Fatalf("dropout probability %f out of range [0, 1)", d)
+			}
+		}
+		mlp.d0, mlp.d1 = d0, d1
+	}
+}
+
+// WithOutputs sets the width of the output layer, e.g. the classes of the
+// model.SoftmaxObjective, default 1.
+func WithOutputs(outputs int) Option {
+	return func(mlp *YoutubeDnn) {
+		if outputs <= 0 {
+			log.Fatalf("outputs %d should be positive", outputs)
+		}
+		mlp.outputs = outputs
+	}
+}
+
 func NewYoutubeDnn(
 	uProfileDim, uBehaviorSize, uBehaviorDim int,
 	iFeatureDim int,
 	cFeatureDim int,
+	opts ...Option,
 ) (mlp *YoutubeDnn) {
 	g := G.NewGraph()
 	mlp0 := G.NewMatrix(g, G.Float32, G.WithShape(uProfileDim+uBehaviorDim+iFeatureDim+cFeatureDim, mlp0_1), G.WithName("mlp0"), G.WithInit(G.Gaussian(0, 1.0)))
 	mlp1 := G.NewMatrix(g, G.Float32, G.WithShape(mlp0_1, mlp1_2), G.WithName("mlp1"), G.WithInit(G.Gaussian(0, 1.0)))
-	mlp2 := G.NewMatrix(g, G.Float32, G.WithShape(mlp1_2, 1), G.WithName("mlp2"), G.WithInit(G.Gaussian(0, 1.0)))
-	return &YoutubeDnn{
+	mlp = &YoutubeDnn{
 		uProfileDim:   uProfileDim,
 		uBehaviorSize: uBehaviorSize,
 		uBehaviorDim:  uBehaviorDim,
@@ -133,12 +183,18 @@ func NewYoutubeDnn(
 		cFeatureDim:   cFeatureDim,
 
 		g:    g,
-		d0:   0.003,
-		d1:   0.003,
+		d0:   defaultDropout,
+		d1:   defaultDropout,
 		mlp0: mlp0,
 		mlp1: mlp1,
-		mlp2: mlp2,
+
+		outputs: 1,
 	}
+	for _, opt := range opts {
+		opt(mlp)
+	}
+	mlp.mlp2 = G.NewMatrix(g, G.Float32, G.WithShape(mlp1_2, mlp.outputs), G.WithName("mlp2"), G.WithInit(G.Gaussian(0, 1.0)))
+	return
 }
 
 func (mlp *YoutubeDnn) Graph() *G.ExprGraph {
@@ -149,6 +205,11 @@ func (mlp *YoutubeDnn) Out() *G.Node {
 	return mlp.out
 }
 
+// Logits implements model.LogitsOutputer
+func (mlp *YoutubeDnn) Logits() *G.Node {
+	return mlp.logits
+}
+
 func (mlp *YoutubeDnn) Learnable() G.Nodes {
 	return G.Nodes{mlp.mlp0, mlp.mlp1, mlp.mlp2}
 }
@@ -169,12 +230,11 @@ func (mlp *YoutubeDnn) Fwd(xUserProfile, ubMatrix, xItemFeature, xCtxFeature *G.
 	// concat
 	x := G.Must(G.Concat(1, xUserProfile, xUserBehaviorAvg, xItemFeature, xCtxFeature))
 	// mlp
-	mlp0Out := G.Must(G.Sigmoid(G.Must(G.Mul(x, mlp.mlp0))))
-	mlp0Out = G.Must(G.Dropout(mlp0Out, float64(mlp.d0)))
-	mlp1Out := G.Must(G.Sigmoid(G.Must(G.Mul(mlp0Out, mlp.mlp1))))
-	mlp1Out = G.Must(G.Dropout(mlp1Out, float64(mlp.d1)))
+	mlp0Out := G.Must(layers.Dropout(G.Must(layers.Dense(x, mlp.mlp0, G.Sigmoid)), mlp.d0, mlp.training))
+	mlp1Out := G.Must(layers.Dropout(G.Must(layers.Dense(mlp0Out, mlp.mlp1, G.Sigmoid)), mlp.d1, mlp.training))
 
-	mlp.out = G.Must(G.Sigmoid(G.Must(G.Mul(mlp1Out, mlp.mlp2))))
+	mlp.logits = G.Must(layers.Dense(mlp1Out, mlp.mlp2, nil))
+	mlp.out = G.Must(layers.Activate(mlp.logits, mlp.head.Activation()))
 	mlp.xUserProfile = xUserProfile
 	mlp.xItemFeature = xItemFeature
 	mlp.xCtxFeature = xCtxFeature
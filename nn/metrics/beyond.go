@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"math"
+)
+
+// The beyond-accuracy metrics of the recommendation lists, the item ids
+// recommended of every user, e.g. the top K ranked in an evaluation or the
+// rankings logged of the requests. They catch the filter bubble of the
+// models accurate on the popular items only.
+
+// CatalogCoverage returns the share of the catalog of catalogSize items
+// recommended in any of lists, 0 of an empty catalog
+func CatalogCoverage(lists [][]int, catalogSize int) float64 {
+	if catalogSize <= 0 {
+		return 0
+	}
+	seen := make(map[int]struct{})
+	for _, list := range lists {
+		for _, id := range list {
+			seen[id] = struct{}{}
+		}
+	}
+	return math.Min(1, float64(len(seen))/float64(catalogSize))
+}
+
+// IntraListDiversity returns the mean over lists of the mean distance of the
+// item pairs of a list, e.g. 1 of the items of the different categories and
+// 0 of the same. The lists of less than 2 items are skipped, 0 if none is
+// left.
+func IntraListDiversity(lists [][]int, distance func(a, b int) float64) float64 {
+	var (
+		sum   float64
+		count int
+	)
+	for _, list := range lists {
+		if len(list) < 2 {
+			continue
+		}
+		var d float64
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				d += distance(list[i], list[j])
+			}
+		}
+		sum += d / float64(len(list)*(len(list)-1)/2)
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// Novelty returns the mean self-information -log2(p) of the items of lists,
+// of which p is the share of users interacted with the item by counts,
+// smoothed as (count+1)/(users+1) so the items of no interaction are the
+// most novel. The popular items are of low novelty. 0 of no item.
+func Novelty(lists [][]int, counts map[int]int, users int) float64 {
+	var (
+		sum   float64
+		items int
+	)
+	for _, list := range lists {
+		for _, id := range list {
+			sum -= math.Log2(float64(counts[id]+1) / float64(users+1))
+			items++
+		}
+	}
+	if items == 0 {
+		return 0
+	}
+	return sum / float64(items)
+}
+
+// Serendipity returns the mean over lists of the share of a list relevant
+// to the user and unexpected, not in the list of the user of expected, e.g.
+// of a popularity recommender, as of Ge et al. 2010. relevant and expected
+// are of the users of lists by index, the empty lists are skipped, 0 if none
+// is left.
+func Serendipity(lists, expected [][]int, relevant []map[int]struct{}) float64 {
+	var (
+		sum   float64
+		count int
+	)
+	for u, list := range lists {
+		if len(list) == 0 {
+			continue
+		}
+		primitive := make(map[int]struct{})
+		if u < len(expected) {
+			for _, id := range expected[u] {
+				primitive[id] = struct{}{}
+			}
+		}
+		var serendipitous int
+		for _, id := range list {
+			if _, ok := primitive[id]; ok {
+				continue
+			}
+			if u < len(relevant) {
+				if _, ok := relevant[u][id]; ok {
+					serendipitous++
+				}
+			}
+		}
+		sum += float64(serendipitous) / float64(len(list))
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
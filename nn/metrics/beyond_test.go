@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"fmt"
+)
+
+func ExampleCatalogCoverage() {
+	lists := [][]int{{1, 2, 3}, {1, 4}}
+	fmt.Println(CatalogCoverage(lists, 10))
+	// Output:
+	// 0.4
+}
+
+func ExampleIntraListDiversity() {
+	categories := map[int]string{1: "a", 2: "a", 3: "b", 4: "b"}
+	distance := func(a, b int) float64 {
+		if categories[a] == categories[b] {
+			return 0
+		}
+		return 1
+	}
+	fmt.Printf("%.4f\n", IntraListDiversity([][]int{{1, 2, 3}, {1, 4}, {2}}, distance))
+	// Output:
+	// 0.8333
+}
+
+func ExampleNovelty() {
+	// the item 1 is interacted by all the 9 users, the item 4 by none
+	counts := map[int]int{1: 9, 2: 4, 3: 1}
+	fmt.Printf("%.4f\n", Novelty([][]int{{1, 2, 3}, {1, 4}}, counts, 9))
+	fmt.Printf("%.4f\n", Novelty([][]int{{1}}, counts, 9))
+	// Output:
+	// 1.3288
+	// 0.0000
+}
+
+func ExampleSerendipity() {
+	lists := [][]int{{1, 2, 3}, {1, 4}, {}}
+	// the lists of the popularity recommender
+	expected := [][]int{{1, 2}, {1, 2}, {1, 2}}
+	relevant := []map[int]struct{}{{2: {}, 3: {}}, {4: {}}, {1: {}}}
+	fmt.Printf("%.4f\n", Serendipity(lists, expected, relevant))
+	// Output:
+	// 0.4167
+}
@@ -0,0 +1,265 @@
+package edgerecpb
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/auxten/go-ctr/model/signing"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// Version is the wire version of the messages written
+	Version = uint32(WireVersion_WIRE_VERSION)
+	// MaxMessageSize is the max size of a delimited message read
+	MaxMessageSize = 1 << 28
+)
+
+// checkVersion rejects the messages of a newer wire version, the zero
+// version is of the writers before the versioning
+func checkVersion(name string, version uint32) error {
+	if version > Version {
+		return fmt.Errorf("%s of wire version %d, newer than %d", name, version, Version)
+	}
+	return nil
+}
+
+// FromSample returns the message of s
+func FromSample(s rcmd.Sample) *Sample {
+	return &Sample{
+		UserId:    int64(s.UserId),
+		ItemId:    int64(s.ItemId),
+		Label:     s.Label,
+		Timestamp: s.Timestamp,
+		Query:     s.Query,
+	}
+}
+
+// RcmdSample returns the rcmd.Sample of x
+func (x *Sample) RcmdSample() rcmd.Sample {
+	return rcmd.Sample{
+		UserId:    int(x.GetUserId()),
+		ItemId:    int(x.GetItemId()),
+		Label:     x.GetLabel(),
+		Timestamp: x.GetTimestamp(),
+		Query:     x.GetQuery(),
+	}
+}
+
+// FromSamples returns the batch of samples
+func FromSamples(samples []rcmd.Sample) *SampleBatch {
+	batch := &SampleBatch{Version: Version, Samples: make([]*Sample, len(samples))}
+	for i, s := range samples {
+		batch.Samples[i] = FromSample(s)
+	}
+	return batch
+}
+
+// RcmdSamples returns the rcmd.Sample of the batch
+func (x *SampleBatch) RcmdSamples() ([]rcmd.Sample, error) {
+	if err := checkVersion("sample batch", x.GetVersion()); err != nil {
+		return nil, err
+	}
+	samples := make([]rcmd.Sample, len(x.GetSamples()))
+	for i, s := range x.GetSamples() {
+		samples[i] = s.RcmdSample()
+	}
+	return samples, nil
+}
+
+// NewStreamSchema returns the schema of a TrainingData stream of the Sample
+// columns set by the producer, e.g. "user_id"
+func NewStreamSchema(featureHash string, columns ...string) *StreamSchema {
+	return &StreamSchema{Version: Version, Columns: columns, FeatureHash: featureHash}
+}
+
+// CheckColumns rejects the schema of a newer wire version, of a column not
+// of a Sample field or duplicated, or of no column of required
+func (x *StreamSchema) CheckColumns(required ...string) error {
+	if err := checkVersion("stream schema", x.GetVersion()); err != nil {
+		return err
+	}
+	fields := (&Sample{}).ProtoReflect().Descriptor().Fields()
+	set := make(map[string]bool, len(x.GetColumns()))
+	for _, c := range x.GetColumns() {
+		if fields.ByName(protoreflect.Name(c)) == nil {
+			return fmt.Errorf("unknown column %q", c)
+		}
+		if set[c] {
+			return fmt.Errorf("duplicated column %q", c)
+		}
+		set[c] = true
+	}
+	for _, c := range required {
+		if !set[c] {
+			return fmt.Errorf("no %q column", c)
+		}
+	}
+	return nil
+}
+
+func fromRange(r [2]int) *Range {
+	return &Range{Start: int32(r[0]), End: int32(r[1])}
+}
+
+func (x *Range) rcmdRange() [2]int {
+	return [2]int{int(x.GetStart()), int(x.GetEnd())}
+}
+
+// FromSampleInfo returns the schema of si of the feature pipeline
+func FromSampleInfo(si rcmd.SampleInfo, featureHash string, featureFuncs []rcmd.FeatureFuncInfo) *FeatureSchema {
+	schema := &FeatureSchema{
+		Version:      Version,
+		UserProfile:  fromRange(si.UserProfileRange),
+		UserBehavior: fromRange(si.UserBehaviorRange),
+		ItemFeature:  fromRange(si.ItemFeatureRange),
+		CtxFeature:   fromRange(si.CtxFeatureRange),
+		FeatureHash:  featureHash,
+	}
+	for _, f := range featureFuncs {
+		schema.FeatureFuncs = append(schema.FeatureFuncs, &FeatureFunc{Name: f.Name, Width: int32(f.Width), Version: f.Version})
+	}
+	return schema
+}
+
+// FromManifest returns the schema of the model trained of manifest
+func FromManifest(manifest *rcmd.Manifest) *FeatureSchema {
+	return FromSampleInfo(manifest.SampleInfo, manifest.FeatureHash, manifest.FeatureFuncs)
+}
+
+// SampleInfo returns the validated rcmd.SampleInfo of the schema
+func (x *FeatureSchema) SampleInfo() (si *rcmd.SampleInfo, err error) {
+	if err = checkVersion("feature schema", x.GetVersion()); err != nil {
+		return
+	}
+	si = &rcmd.SampleInfo{
+		UserProfileRange:  x.GetUserProfile().rcmdRange(),
+		UserBehaviorRange: x.GetUserBehavior().rcmdRange(),
+		ItemFeatureRange:  x.GetItemFeature().rcmdRange(),
+		CtxFeatureRange:   x.GetCtxFeature().rcmdRange(),
+	}
+	if err = si.Validate(si.Width()); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// RcmdFeatureFuncs returns the rcmd.FeatureFuncInfo of the schema
+func (x *FeatureSchema) RcmdFeatureFuncs() (infos []rcmd.FeatureFuncInfo) {
+	for _, f := range x.GetFeatureFuncs() {
+		infos = append(infos, rcmd.FeatureFuncInfo{Name: f.GetName(), Width: int(f.GetWidth()), Version: f.GetVersion()})
+	}
+	return
+}
+
+// NewModelArtifact returns the artifact of the marshaled model of
+// modelType, the schema is of manifest if not nil, or else si
+func NewModelArtifact(modelType string, model []byte, si *rcmd.SampleInfo, manifest *rcmd.Manifest) (artifact *ModelArtifact, err error) {
+	artifact = &ModelArtifact{Version: Version, ModelType: modelType, Model: model}
+	switch {
+	case manifest != nil:
+		artifact.Schema = FromManifest(manifest)
+		if artifact.Manifest, err = json.Marshal(manifest); err != nil {
+			return nil, err
+		}
+		artifact.TrainedAt = timestamppb.New(manifest.FinishedAt)
+	case si != nil:
+		artifact.Schema = FromSampleInfo(*si, "", nil)
+		artifact.TrainedAt = timestamppb.New(time.Now())
+	default:
+		return nil, fmt.Errorf("model artifact of no sample info")
+	}
+	if _, err = artifact.Schema.SampleInfo(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// RcmdManifest returns the data lineage of the artifact, nil if none
+func (x *ModelArtifact) RcmdManifest() (manifest *rcmd.Manifest, err error) {
+	if err = checkVersion("model artifact", x.GetVersion()); err != nil {
+		return
+	}
+	if len(x.GetManifest()) == 0 {
+		return
+	}
+	manifest = &rcmd.Manifest{}
+	if err = json.Unmarshal(x.GetManifest(), manifest); err != nil {
+		return nil, fmt.Errorf("model artifact manifest: %v", err)
+	}
+	return
+}
+
+// signedBytes is the deterministic marshal of x of no signature
+func (x *ModelArtifact) signedBytes() ([]byte, error) {
+	unsigned := proto.Clone(x).(*ModelArtifact)
+	unsigned.Signature = nil
+	return proto.MarshalOptions{Deterministic: true}.Marshal(unsigned)
+}
+
+// Sign sets the signature of x by key, at the export of the artifact
+func (x *ModelArtifact) Sign(key ed25519.PrivateKey) error {
+	data, err := x.signedBytes()
+	if err != nil {
+		return err
+	}
+	x.Signature = signing.Sign(key, data)
+	return nil
+}
+
+// Verify checks x is signed by the private key of key, signing.ErrUnsigned
+// if not signed, so the tampered or corrupted artifact is refused on load
+func (x *ModelArtifact) Verify(key ed25519.PublicKey) error {
+	if len(x.GetSignature()) == 0 {
+		return signing.ErrUnsigned
+	}
+	data, err := x.signedBytes()
+	if err != nil {
+		return err
+	}
+	return signing.Verify(key, data, x.GetSignature())
+}
+
+// WriteDelimited writes the size of m in varint followed by m, the framing
+// of the files and the streams of the messages
+func WriteDelimited(w io.Writer, m proto.Message) (err error) {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return
+	}
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(data)))
+	if _, err = w.Write(size[:n]); err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
+
+// ReadDelimited reads the message written by WriteDelimited to m, io.EOF
+// if r ends before the message
+func ReadDelimited(r *bufio.Reader, m proto.Message) (err error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	if size > MaxMessageSize {
+		return fmt.Errorf("delimited message of %d bytes, over %d", size, MaxMessageSize)
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,157 @@
+package edgerecpb
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/auxten/go-ctr/model/signing"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSamples(t *testing.T) {
+	Convey("sample batch round trip", t, func() {
+		samples := []rcmd.Sample{
+			{UserId: 1, ItemId: 2, Label: 1, Timestamp: 100},
+			{UserId: 3, ItemId: 1 << 40, Label: 0.5, Timestamp: 200, Query: "star wars"},
+		}
+		data, err := proto.Marshal(FromSamples(samples))
+		So(err, ShouldBeNil)
+		var batch SampleBatch
+		So(proto.Unmarshal(data, &batch), ShouldBeNil)
+		read, err := batch.RcmdSamples()
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, samples)
+
+		batch.Version = Version + 1
+		_, err = batch.RcmdSamples()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("delimited messages", t, func() {
+		var buf bytes.Buffer
+		for i := 0; i < 3; i++ {
+			So(WriteDelimited(&buf, FromSamples([]rcmd.Sample{{UserId: i}})), ShouldBeNil)
+		}
+		r := bufio.NewReader(bytes.NewReader(buf.Bytes()))
+		for i := 0; i < 3; i++ {
+			var batch SampleBatch
+			So(ReadDelimited(r, &batch), ShouldBeNil)
+			So(batch.GetSamples()[0].GetUserId(), ShouldEqual, i)
+		}
+		So(ReadDelimited(r, &SampleBatch{}), ShouldEqual, io.EOF)
+
+		truncated := bufio.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+		for i := 0; i < 2; i++ {
+			So(ReadDelimited(truncated, &SampleBatch{}), ShouldBeNil)
+		}
+		So(ReadDelimited(truncated, &SampleBatch{}), ShouldEqual, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestStreamSchema(t *testing.T) {
+	Convey("stream schema columns", t, func() {
+		So(NewStreamSchema("", "user_id", "item_id", "label", "query").CheckColumns("user_id", "item_id"), ShouldBeNil)
+		for _, schema := range []*StreamSchema{
+			NewStreamSchema("", "user_id", "item_id", "price"),
+			NewStreamSchema("", "user_id", "item_id", "user_id"),
+			NewStreamSchema("", "user_id"),
+			{Version: Version + 1, Columns: []string{"user_id", "item_id"}},
+		} {
+			So(schema.CheckColumns("user_id", "item_id"), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestModelArtifact(t *testing.T) {
+	info, err := rcmd.NewSampleInfoBuilder().UserProfile(2).ItemFeature(3).CtxFeature(1).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("artifact of the manifest", t, func() {
+		manifest := &rcmd.Manifest{
+			FinishedAt:   time.Unix(1700000000, 0).UTC(),
+			Samples:      10,
+			SampleInfo:   *info,
+			FeatureHash:  "abc",
+			FeatureFuncs: []rcmd.FeatureFuncInfo{{Name: "image", Width: 1}, {Name: "genres", Width: 3, Version: "3f2a9c0d1e4b5a67"}},
+		}
+		artifact, err := NewModelArtifact("youtube", []byte(`{"w":1}`), info, manifest)
+		So(err, ShouldBeNil)
+		artifact.Metrics = map[string]float64{"auc": 0.7}
+		data, err := proto.Marshal(artifact)
+		So(err, ShouldBeNil)
+
+		var read ModelArtifact
+		So(proto.Unmarshal(data, &read), ShouldBeNil)
+		So(read.GetModelType(), ShouldEqual, "youtube")
+		So(read.GetModel(), ShouldResemble, []byte(`{"w":1}`))
+		So(read.GetTrainedAt().AsTime(), ShouldEqual, manifest.FinishedAt)
+		So(read.GetMetrics(), ShouldResemble, map[string]float64{"auc": 0.7})
+		si, err := read.GetSchema().SampleInfo()
+		So(err, ShouldBeNil)
+		So(si, ShouldResemble, info)
+		So(read.GetSchema().GetFeatureHash(), ShouldEqual, "abc")
+		So(read.GetSchema().RcmdFeatureFuncs(), ShouldResemble, manifest.FeatureFuncs)
+		m, err := read.RcmdManifest()
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, manifest)
+	})
+
+	Convey("artifact of the sample info", t, func() {
+		artifact, err := NewModelArtifact("din", nil, info, nil)
+		So(err, ShouldBeNil)
+		m, err := artifact.RcmdManifest()
+		So(err, ShouldBeNil)
+		So(m, ShouldBeNil)
+
+		_, err = NewModelArtifact("din", nil, nil, nil)
+		So(err, ShouldNotBeNil)
+		_, err = NewModelArtifact("din", nil, &rcmd.SampleInfo{UserProfileRange: [2]int{1, 0}}, nil)
+		So(err, ShouldNotBeNil)
+
+		artifact.Version = Version + 1
+		_, err = artifact.RcmdManifest()
+		So(err, ShouldNotBeNil)
+		artifact.Schema.Version = Version + 1
+		_, err = artifact.GetSchema().SampleInfo()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestModelArtifactSignature(t *testing.T) {
+	info, err := rcmd.NewSampleInfoBuilder().UserProfile(2).ItemFeature(3).CtxFeature(1).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := signing.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("signed artifact over the wire", t, func() {
+		artifact, err := NewModelArtifact("din", []byte(`{"w":1}`), info, nil)
+		So(err, ShouldBeNil)
+		artifact.Metrics = map[string]float64{"auc": 0.7, "loss": 0.3}
+		So(artifact.Verify(pub), ShouldEqual, signing.ErrUnsigned)
+		So(artifact.Sign(priv), ShouldBeNil)
+		data, err := proto.Marshal(artifact)
+		So(err, ShouldBeNil)
+
+		var read ModelArtifact
+		So(proto.Unmarshal(data, &read), ShouldBeNil)
+		So(read.Verify(pub), ShouldBeNil)
+
+		other, _, err := signing.GenerateKey()
+		So(err, ShouldBeNil)
+		So(read.Verify(other), ShouldEqual, signing.ErrBadSignature)
+
+		read.Model = []byte(`{"w":2}`)
+		So(read.Verify(pub), ShouldEqual, signing.ErrBadSignature)
+	})
+}
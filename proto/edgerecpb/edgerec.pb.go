@@ -0,0 +1,1069 @@
+// edgerec.proto is the canonical wire format of the cross-process exchange
+// of edgeRec: the training samples streamed or written to the files, the
+// feature schema the models are trained with, the model artifacts synced
+// to the serving nodes, and the TrainingData stream of the remote producers.
+//
+// The messages are versioned by the version field of the top-level ones,
+// WIRE_VERSION is bumped on the changes the old readers can't skip. The
+// fields are only added, never renumbered or retyped.
+//
+// Regenerate edgerec.pb.go by `make proto`.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.0
+// 	protoc        (unknown)
+// source: edgerecpb/edgerec.proto
+
+package edgerecpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type WireVersion int32
+
+const (
+	WireVersion_WIRE_VERSION_UNSPECIFIED WireVersion = 0
+	WireVersion_WIRE_VERSION             WireVersion = 1
+)
+
+// Enum value maps for WireVersion.
+var (
+	WireVersion_name = map[int32]string{
+		0: "WIRE_VERSION_UNSPECIFIED",
+		1: "WIRE_VERSION",
+	}
+	WireVersion_value = map[string]int32{
+		"WIRE_VERSION_UNSPECIFIED": 0,
+		"WIRE_VERSION":             1,
+	}
+)
+
+func (x WireVersion) Enum() *WireVersion {
+	p := new(WireVersion)
+	*p = x
+	return p
+}
+
+func (x WireVersion) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (WireVersion) Descriptor() protoreflect.EnumDescriptor {
+	return file_edgerecpb_edgerec_proto_enumTypes[0].Descriptor()
+}
+
+func (WireVersion) Type() protoreflect.EnumType {
+	return &file_edgerecpb_edgerec_proto_enumTypes[0]
+}
+
+func (x WireVersion) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use WireVersion.Descriptor instead.
+func (WireVersion) EnumDescriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{0}
+}
+
+// Sample is a labeled user item interaction, rcmd.Sample
+type Sample struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId int64   `protobuf:"varint,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	ItemId int64   `protobuf:"varint,2,opt,name=item_id,json=itemId,proto3" json:"item_id,omitempty"`
+	Label  float32 `protobuf:"fixed32,3,opt,name=label,proto3" json:"label,omitempty"`
+	// timestamp is the unix seconds of the interaction
+	Timestamp int64 `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// query is the search query of the sample, empty of the feeds
+	Query string `protobuf:"bytes,5,opt,name=query,proto3" json:"query,omitempty"`
+}
+
+func (x *Sample) Reset() {
+	*x = Sample{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_edgerecpb_edgerec_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Sample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sample) ProtoMessage() {}
+
+func (x *Sample) ProtoReflect() protoreflect.Message {
+	mi := &file_edgerecpb_edgerec_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sample.ProtoReflect.Descriptor instead.
+func (*Sample) Descriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Sample) GetUserId() int64 {
+	if x != nil {
+		return x.UserId
+	}
+	return 0
+}
+
+func (x *Sample) GetItemId() int64 {
+	if x != nil {
+		return x.ItemId
+	}
+	return 0
+}
+
+func (x *Sample) GetLabel() float32 {
+	if x != nil {
+		return x.Label
+	}
+	return 0
+}
+
+func (x *Sample) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Sample) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+// SampleBatch is the samples of a stream message or a file chunk
+type SampleBatch struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version uint32    `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Samples []*Sample `protobuf:"bytes,2,rep,name=samples,proto3" json:"samples,omitempty"`
+}
+
+func (x *SampleBatch) Reset() {
+	*x = SampleBatch{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_edgerecpb_edgerec_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SampleBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SampleBatch) ProtoMessage() {}
+
+func (x *SampleBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_edgerecpb_edgerec_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SampleBatch.ProtoReflect.Descriptor instead.
+func (*SampleBatch) Descriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SampleBatch) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *SampleBatch) GetSamples() []*Sample {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
+// StreamSchema is the columns of the samples of a TrainingData stream, the
+// first message of the producer and the reply of the endpoint
+type StreamSchema struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// columns are the names of the Sample fields set by the producer, e.g.
+	// "user_id", the stream is rejected if one is unknown to the endpoint
+	Columns []string `protobuf:"bytes,2,rep,name=columns,proto3" json:"columns,omitempty"`
+	// feature_hash is of the rcmd.Manifest the samples are produced for,
+	// empty if any
+	FeatureHash string `protobuf:"bytes,3,opt,name=feature_hash,json=featureHash,proto3" json:"feature_hash,omitempty"`
+}
+
+func (x *StreamSchema) Reset() {
+	*x = StreamSchema{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_edgerecpb_edgerec_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamSchema) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamSchema) ProtoMessage() {}
+
+func (x *StreamSchema) ProtoReflect() protoreflect.Message {
+	mi := &file_edgerecpb_edgerec_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamSchema.ProtoReflect.Descriptor instead.
+func (*StreamSchema) Descriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StreamSchema) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *StreamSchema) GetColumns() []string {
+	if x != nil {
+		return x.Columns
+	}
+	return nil
+}
+
+func (x *StreamSchema) GetFeatureHash() string {
+	if x != nil {
+		return x.FeatureHash
+	}
+	return ""
+}
+
+// TrainingDataRequest is the message of the producer of a TrainingData
+// stream, the schema of the first one, the batch of the others
+type TrainingDataRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Payload:
+	//	*TrainingDataRequest_Schema
+	//	*TrainingDataRequest_Batch
+	Payload isTrainingDataRequest_Payload `protobuf_oneof:"payload"`
+}
+
+func (x *TrainingDataRequest) Reset() {
+	*x = TrainingDataRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_edgerecpb_edgerec_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TrainingDataRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrainingDataRequest) ProtoMessage() {}
+
+func (x *TrainingDataRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_edgerecpb_edgerec_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrainingDataRequest.ProtoReflect.Descriptor instead.
+func (*TrainingDataRequest) Descriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{3}
+}
+
+func (m *TrainingDataRequest) GetPayload() isTrainingDataRequest_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (x *TrainingDataRequest) GetSchema() *StreamSchema {
+	if x, ok := x.GetPayload().(*TrainingDataRequest_Schema); ok {
+		return x.Schema
+	}
+	return nil
+}
+
+func (x *TrainingDataRequest) GetBatch() *SampleBatch {
+	if x, ok := x.GetPayload().(*TrainingDataRequest_Batch); ok {
+		return x.Batch
+	}
+	return nil
+}
+
+type isTrainingDataRequest_Payload interface {
+	isTrainingDataRequest_Payload()
+}
+
+type TrainingDataRequest_Schema struct {
+	Schema *StreamSchema `protobuf:"bytes,1,opt,name=schema,proto3,oneof"`
+}
+
+type TrainingDataRequest_Batch struct {
+	Batch *SampleBatch `protobuf:"bytes,2,opt,name=batch,proto3,oneof"`
+}
+
+func (*TrainingDataRequest_Schema) isTrainingDataRequest_Payload() {}
+
+func (*TrainingDataRequest_Batch) isTrainingDataRequest_Payload() {}
+
+// TrainingDataResponse is the message of the endpoint of a TrainingData
+// stream, the negotiated schema of the first one, the acks of the batches
+// of the others. credits are the samples granted to the producer to send
+// more.
+type TrainingDataResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Schema   *StreamSchema `protobuf:"bytes,1,opt,name=schema,proto3" json:"schema,omitempty"`
+	Accepted int32         `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Credits  int32         `protobuf:"varint,3,opt,name=credits,proto3" json:"credits,omitempty"`
+}
+
+func (x *TrainingDataResponse) Reset() {
+	*x = TrainingDataResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_edgerecpb_edgerec_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TrainingDataResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrainingDataResponse) ProtoMessage() {}
+
+func (x *TrainingDataResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_edgerecpb_edgerec_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrainingDataResponse.ProtoReflect.Descriptor instead.
+func (*TrainingDataResponse) Descriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TrainingDataResponse) GetSchema() *StreamSchema {
+	if x != nil {
+		return x.Schema
+	}
+	return nil
+}
+
+func (x *TrainingDataResponse) GetAccepted() int32 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *TrainingDataResponse) GetCredits() int32 {
+	if x != nil {
+		return x.Credits
+	}
+	return 0
+}
+
+// Range is the [start, end) of the sample vector
+type Range struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start int32 `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	End   int32 `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+}
+
+func (x *Range) Reset() {
+	*x = Range{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_edgerecpb_edgerec_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Range) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Range) ProtoMessage() {}
+
+func (x *Range) ProtoReflect() protoreflect.Message {
+	mi := &file_edgerecpb_edgerec_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Range.ProtoReflect.Descriptor instead.
+func (*Range) Descriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Range) GetStart() int32 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *Range) GetEnd() int32 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+// FeatureFunc is the custom feature appended to the item feature,
+// rcmd.FeatureFuncInfo
+type FeatureFunc struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Width int32  `protobuf:"varint,2,opt,name=width,proto3" json:"width,omitempty"`
+	// version of the encoding of the func, see rcmd.FeatureFuncInfo
+	Version string `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+}
+
+func (x *FeatureFunc) Reset() {
+	*x = FeatureFunc{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_edgerecpb_edgerec_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FeatureFunc) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FeatureFunc) ProtoMessage() {}
+
+func (x *FeatureFunc) ProtoReflect() protoreflect.Message {
+	mi := &file_edgerecpb_edgerec_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FeatureFunc.ProtoReflect.Descriptor instead.
+func (*FeatureFunc) Descriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FeatureFunc) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *FeatureFunc) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *FeatureFunc) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+// FeatureSchema is the layout of the sample vector, rcmd.SampleInfo, and
+// the feature pipeline the models of feature_hash accept
+type FeatureSchema struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version      uint32         `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	UserProfile  *Range         `protobuf:"bytes,2,opt,name=user_profile,json=userProfile,proto3" json:"user_profile,omitempty"`
+	UserBehavior *Range         `protobuf:"bytes,3,opt,name=user_behavior,json=userBehavior,proto3" json:"user_behavior,omitempty"`
+	ItemFeature  *Range         `protobuf:"bytes,4,opt,name=item_feature,json=itemFeature,proto3" json:"item_feature,omitempty"`
+	CtxFeature   *Range         `protobuf:"bytes,5,opt,name=ctx_feature,json=ctxFeature,proto3" json:"ctx_feature,omitempty"`
+	FeatureHash  string         `protobuf:"bytes,6,opt,name=feature_hash,json=featureHash,proto3" json:"feature_hash,omitempty"`
+	FeatureFuncs []*FeatureFunc `protobuf:"bytes,7,rep,name=feature_funcs,json=featureFuncs,proto3" json:"feature_funcs,omitempty"`
+}
+
+func (x *FeatureSchema) Reset() {
+	*x = FeatureSchema{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_edgerecpb_edgerec_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FeatureSchema) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FeatureSchema) ProtoMessage() {}
+
+func (x *FeatureSchema) ProtoReflect() protoreflect.Message {
+	mi := &file_edgerecpb_edgerec_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FeatureSchema.ProtoReflect.Descriptor instead.
+func (*FeatureSchema) Descriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *FeatureSchema) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *FeatureSchema) GetUserProfile() *Range {
+	if x != nil {
+		return x.UserProfile
+	}
+	return nil
+}
+
+func (x *FeatureSchema) GetUserBehavior() *Range {
+	if x != nil {
+		return x.UserBehavior
+	}
+	return nil
+}
+
+func (x *FeatureSchema) GetItemFeature() *Range {
+	if x != nil {
+		return x.ItemFeature
+	}
+	return nil
+}
+
+func (x *FeatureSchema) GetCtxFeature() *Range {
+	if x != nil {
+		return x.CtxFeature
+	}
+	return nil
+}
+
+func (x *FeatureSchema) GetFeatureHash() string {
+	if x != nil {
+		return x.FeatureHash
+	}
+	return ""
+}
+
+func (x *FeatureSchema) GetFeatureFuncs() []*FeatureFunc {
+	if x != nil {
+		return x.FeatureFuncs
+	}
+	return nil
+}
+
+// ModelArtifact is the trained model of a version, as synced to the
+// serving nodes
+type ModelArtifact struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// model_type is the name of the model, e.g. "youtube" or "din"
+	ModelType string         `protobuf:"bytes,2,opt,name=model_type,json=modelType,proto3" json:"model_type,omitempty"`
+	Schema    *FeatureSchema `protobuf:"bytes,3,opt,name=schema,proto3" json:"schema,omitempty"`
+	// model is the marshaled model, loaded by the NewXXXFromJson of the type
+	Model []byte `protobuf:"bytes,4,opt,name=model,proto3" json:"model,omitempty"`
+	// manifest is the json of the data lineage rcmd.Manifest, empty if none
+	Manifest  []byte                 `protobuf:"bytes,5,opt,name=manifest,proto3" json:"manifest,omitempty"`
+	TrainedAt *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=trained_at,json=trainedAt,proto3" json:"trained_at,omitempty"`
+	Metrics   map[string]float64     `protobuf:"bytes,7,rep,name=metrics,proto3" json:"metrics,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+	// signature is the ed25519 signature of the artifact of no signature, see
+	// ModelArtifact.Sign, empty if not signed
+	Signature []byte `protobuf:"bytes,8,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (x *ModelArtifact) Reset() {
+	*x = ModelArtifact{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_edgerecpb_edgerec_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ModelArtifact) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModelArtifact) ProtoMessage() {}
+
+func (x *ModelArtifact) ProtoReflect() protoreflect.Message {
+	mi := &file_edgerecpb_edgerec_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ModelArtifact.ProtoReflect.Descriptor instead.
+func (*ModelArtifact) Descriptor() ([]byte, []int) {
+	return file_edgerecpb_edgerec_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ModelArtifact) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *ModelArtifact) GetModelType() string {
+	if x != nil {
+		return x.ModelType
+	}
+	return ""
+}
+
+func (x *ModelArtifact) GetSchema() *FeatureSchema {
+	if x != nil {
+		return x.Schema
+	}
+	return nil
+}
+
+func (x *ModelArtifact) GetModel() []byte {
+	if x != nil {
+		return x.Model
+	}
+	return nil
+}
+
+func (x *ModelArtifact) GetManifest() []byte {
+	if x != nil {
+		return x.Manifest
+	}
+	return nil
+}
+
+func (x *ModelArtifact) GetTrainedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.TrainedAt
+	}
+	return nil
+}
+
+func (x *ModelArtifact) GetMetrics() map[string]float64 {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+func (x *ModelArtifact) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+var File_edgerecpb_edgerec_proto protoreflect.FileDescriptor
+
+var file_edgerecpb_edgerec_proto_rawDesc = []byte{
+	0x0a, 0x17, 0x65, 0x64, 0x67, 0x65, 0x72, 0x65, 0x63, 0x70, 0x62, 0x2f, 0x65, 0x64, 0x67, 0x65,
+	0x72, 0x65, 0x63, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0a, 0x65, 0x64, 0x67, 0x65, 0x72,
+	0x65, 0x63, 0x2e, 0x76, 0x31, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x84, 0x01, 0x0a, 0x06, 0x53, 0x61, 0x6d, 0x70, 0x6c,
+	0x65, 0x12, 0x17, 0x0a, 0x07, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x06, 0x75, 0x73, 0x65, 0x72, 0x49, 0x64, 0x12, 0x17, 0x0a, 0x07, 0x69, 0x74,
+	0x65, 0x6d, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x69, 0x74, 0x65,
+	0x6d, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x02, 0x52, 0x05, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x14, 0x0a, 0x05, 0x71, 0x75, 0x65, 0x72, 0x79,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x71, 0x75, 0x65, 0x72, 0x79, 0x22, 0x55, 0x0a,
+	0x0b, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x42, 0x61, 0x74, 0x63, 0x68, 0x12, 0x18, 0x0a, 0x07,
+	0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x76,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x2c, 0x0a, 0x07, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x72, 0x65,
+	0x63, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x52, 0x07, 0x73, 0x61, 0x6d,
+	0x70, 0x6c, 0x65, 0x73, 0x22, 0x65, 0x0a, 0x0c, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x53, 0x63,
+	0x68, 0x65, 0x6d, 0x61, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x18,
+	0x0a, 0x07, 0x63, 0x6f, 0x6c, 0x75, 0x6d, 0x6e, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x07, 0x63, 0x6f, 0x6c, 0x75, 0x6d, 0x6e, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x66, 0x65, 0x61, 0x74,
+	0x75, 0x72, 0x65, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b,
+	0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x48, 0x61, 0x73, 0x68, 0x22, 0x85, 0x01, 0x0a, 0x13,
+	0x54, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x32, 0x0a, 0x06, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x72, 0x65, 0x63, 0x2e, 0x76, 0x31,
+	0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x53, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x48, 0x00, 0x52,
+	0x06, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x12, 0x2f, 0x0a, 0x05, 0x62, 0x61, 0x74, 0x63, 0x68,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x72, 0x65, 0x63,
+	0x2e, 0x76, 0x31, 0x2e, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x42, 0x61, 0x74, 0x63, 0x68, 0x48,
+	0x00, 0x52, 0x05, 0x62, 0x61, 0x74, 0x63, 0x68, 0x42, 0x09, 0x0a, 0x07, 0x70, 0x61, 0x79, 0x6c,
+	0x6f, 0x61, 0x64, 0x22, 0x7e, 0x0a, 0x14, 0x54, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x44,
+	0x61, 0x74, 0x61, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x30, 0x0a, 0x06, 0x73,
+	0x63, 0x68, 0x65, 0x6d, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x65, 0x64,
+	0x67, 0x65, 0x72, 0x65, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x53,
+	0x63, 0x68, 0x65, 0x6d, 0x61, 0x52, 0x06, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x12, 0x1a, 0x0a,
+	0x08, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x08, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x72, 0x65,
+	0x64, 0x69, 0x74, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x63, 0x72, 0x65, 0x64,
+	0x69, 0x74, 0x73, 0x22, 0x2f, 0x0a, 0x05, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x12, 0x14, 0x0a, 0x05,
+	0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x03, 0x65, 0x6e, 0x64, 0x22, 0x51, 0x0a, 0x0b, 0x46, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x46,
+	0x75, 0x6e, 0x63, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x12, 0x18, 0x0a,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0xe2, 0x02, 0x0a, 0x0d, 0x46, 0x65, 0x61, 0x74,
+	0x75, 0x72, 0x65, 0x53, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x12, 0x34, 0x0a, 0x0c, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x70, 0x72, 0x6f, 0x66,
+	0x69, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x65, 0x64, 0x67, 0x65,
+	0x72, 0x65, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x0b, 0x75, 0x73,
+	0x65, 0x72, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x12, 0x36, 0x0a, 0x0d, 0x75, 0x73, 0x65,
+	0x72, 0x5f, 0x62, 0x65, 0x68, 0x61, 0x76, 0x69, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x11, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x72, 0x65, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x61,
+	0x6e, 0x67, 0x65, 0x52, 0x0c, 0x75, 0x73, 0x65, 0x72, 0x42, 0x65, 0x68, 0x61, 0x76, 0x69, 0x6f,
+	0x72, 0x12, 0x34, 0x0a, 0x0c, 0x69, 0x74, 0x65, 0x6d, 0x5f, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72,
+	0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x72, 0x65,
+	0x63, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x0b, 0x69, 0x74, 0x65, 0x6d,
+	0x46, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x12, 0x32, 0x0a, 0x0b, 0x63, 0x74, 0x78, 0x5f, 0x66,
+	0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x65,
+	0x64, 0x67, 0x65, 0x72, 0x65, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52,
+	0x0a, 0x63, 0x74, 0x78, 0x46, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x12, 0x21, 0x0a, 0x0c, 0x66,
+	0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0b, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x48, 0x61, 0x73, 0x68, 0x12, 0x3c,
+	0x0a, 0x0d, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x66, 0x75, 0x6e, 0x63, 0x73, 0x18,
+	0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x72, 0x65, 0x63, 0x2e,
+	0x76, 0x31, 0x2e, 0x46, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x46, 0x75, 0x6e, 0x63, 0x52, 0x0c,
+	0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x46, 0x75, 0x6e, 0x63, 0x73, 0x22, 0x84, 0x03, 0x0a,
+	0x0d, 0x4d, 0x6f, 0x64, 0x65, 0x6c, 0x41, 0x72, 0x74, 0x69, 0x66, 0x61, 0x63, 0x74, 0x12, 0x18,
+	0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1d, 0x0a, 0x0a, 0x6d, 0x6f, 0x64, 0x65,
+	0x6c, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6d, 0x6f,
+	0x64, 0x65, 0x6c, 0x54, 0x79, 0x70, 0x65, 0x12, 0x31, 0x0a, 0x06, 0x73, 0x63, 0x68, 0x65, 0x6d,
+	0x61, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x72, 0x65,
+	0x63, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x53, 0x63, 0x68, 0x65,
+	0x6d, 0x61, 0x52, 0x06, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x12, 0x14, 0x0a, 0x05, 0x6d, 0x6f,
+	0x64, 0x65, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x6d, 0x6f, 0x64, 0x65, 0x6c,
+	0x12, 0x1a, 0x0a, 0x08, 0x6d, 0x61, 0x6e, 0x69, 0x66, 0x65, 0x73, 0x74, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x08, 0x6d, 0x61, 0x6e, 0x69, 0x66, 0x65, 0x73, 0x74, 0x12, 0x39, 0x0a, 0x0a,
+	0x74, 0x72, 0x61, 0x69, 0x6e, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x74, 0x72,
+	0x61, 0x69, 0x6e, 0x65, 0x64, 0x41, 0x74, 0x12, 0x40, 0x0a, 0x07, 0x6d, 0x65, 0x74, 0x72, 0x69,
+	0x63, 0x73, 0x18, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x26, 0x2e, 0x65, 0x64, 0x67, 0x65, 0x72,
+	0x65, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x6f, 0x64, 0x65, 0x6c, 0x41, 0x72, 0x74, 0x69, 0x66,
+	0x61, 0x63, 0x74, 0x2e, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x52, 0x07, 0x6d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x69, 0x67,
+	0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x73, 0x69,
+	0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x1a, 0x3a, 0x0a, 0x0c, 0x4d, 0x65, 0x74, 0x72, 0x69,
+	0x63, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a,
+	0x02, 0x38, 0x01, 0x2a, 0x3d, 0x0a, 0x0b, 0x57, 0x69, 0x72, 0x65, 0x56, 0x65, 0x72, 0x73, 0x69,
+	0x6f, 0x6e, 0x12, 0x1c, 0x0a, 0x18, 0x57, 0x49, 0x52, 0x45, 0x5f, 0x56, 0x45, 0x52, 0x53, 0x49,
+	0x4f, 0x4e, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00,
+	0x12, 0x10, 0x0a, 0x0c, 0x57, 0x49, 0x52, 0x45, 0x5f, 0x56, 0x45, 0x52, 0x53, 0x49, 0x4f, 0x4e,
+	0x10, 0x01, 0x32, 0x5f, 0x0a, 0x06, 0x49, 0x6e, 0x67, 0x65, 0x73, 0x74, 0x12, 0x55, 0x0a, 0x0c,
+	0x54, 0x72, 0x61, 0x69, 0x6e, 0x69, 0x6e, 0x67, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1f, 0x2e, 0x65,
+	0x64, 0x67, 0x65, 0x72, 0x65, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x72, 0x61, 0x69, 0x6e, 0x69,
+	0x6e, 0x67, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x20, 0x2e,
+	0x65, 0x64, 0x67, 0x65, 0x72, 0x65, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x72, 0x61, 0x69, 0x6e,
+	0x69, 0x6e, 0x67, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x28,
+	0x01, 0x30, 0x01, 0x42, 0x2a, 0x5a, 0x28, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f,
+	0x6d, 0x2f, 0x61, 0x75, 0x78, 0x74, 0x65, 0x6e, 0x2f, 0x67, 0x6f, 0x2d, 0x63, 0x74, 0x72, 0x2f,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x65, 0x64, 0x67, 0x65, 0x72, 0x65, 0x63, 0x70, 0x62, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_edgerecpb_edgerec_proto_rawDescOnce sync.Once
+	file_edgerecpb_edgerec_proto_rawDescData = file_edgerecpb_edgerec_proto_rawDesc
+)
+
+func file_edgerecpb_edgerec_proto_rawDescGZIP() []byte {
+	file_edgerecpb_edgerec_proto_rawDescOnce.Do(func() {
+		file_edgerecpb_edgerec_proto_rawDescData = protoimpl.X.CompressGZIP(file_edgerecpb_edgerec_proto_rawDescData)
+	})
+	return file_edgerecpb_edgerec_proto_rawDescData
+}
+
+var file_edgerecpb_edgerec_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_edgerecpb_edgerec_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_edgerecpb_edgerec_proto_goTypes = []interface{}{
+	(WireVersion)(0),              // 0: edgerec.v1.WireVersion
+	(*Sample)(nil),                // 1: edgerec.v1.Sample
+	(*SampleBatch)(nil),           // 2: edgerec.v1.SampleBatch
+	(*StreamSchema)(nil),          // 3: edgerec.v1.StreamSchema
+	(*TrainingDataRequest)(nil),   // 4: edgerec.v1.TrainingDataRequest
+	(*TrainingDataResponse)(nil),  // 5: edgerec.v1.TrainingDataResponse
+	(*Range)(nil),                 // 6: edgerec.v1.Range
+	(*FeatureFunc)(nil),           // 7: edgerec.v1.FeatureFunc
+	(*FeatureSchema)(nil),         // 8: edgerec.v1.FeatureSchema
+	(*ModelArtifact)(nil),         // 9: edgerec.v1.ModelArtifact
+	nil,                           // 10: edgerec.v1.ModelArtifact.MetricsEntry
+	(*timestamppb.Timestamp)(nil), // 11: google.protobuf.Timestamp
+}
+var file_edgerecpb_edgerec_proto_depIdxs = []int32{
+	1,  // 0: edgerec.v1.SampleBatch.samples:type_name -> edgerec.v1.Sample
+	3,  // 1: edgerec.v1.TrainingDataRequest.schema:type_name -> edgerec.v1.StreamSchema
+	2,  // 2: edgerec.v1.TrainingDataRequest.batch:type_name -> edgerec.v1.SampleBatch
+	3,  // 3: edgerec.v1.TrainingDataResponse.schema:type_name -> edgerec.v1.StreamSchema
+	6,  // 4: edgerec.v1.FeatureSchema.user_profile:type_name -> edgerec.v1.Range
+	6,  // 5: edgerec.v1.FeatureSchema.user_behavior:type_name -> edgerec.v1.Range
+	6,  // 6: edgerec.v1.FeatureSchema.item_feature:type_name -> edgerec.v1.Range
+	6,  // 7: edgerec.v1.FeatureSchema.ctx_feature:type_name -> edgerec.v1.Range
+	7,  // 8: edgerec.v1.FeatureSchema.feature_funcs:type_name -> edgerec.v1.FeatureFunc
+	8,  // 9: edgerec.v1.ModelArtifact.schema:type_name -> edgerec.v1.FeatureSchema
+	11, // 10: edgerec.v1.ModelArtifact.trained_at:type_name -> google.protobuf.Timestamp
+	10, // 11: edgerec.v1.ModelArtifact.metrics:type_name -> edgerec.v1.ModelArtifact.MetricsEntry
+	4,  // 12: edgerec.v1.Ingest.TrainingData:input_type -> edgerec.v1.TrainingDataRequest
+	5,  // 13: edgerec.v1.Ingest.TrainingData:output_type -> edgerec.v1.TrainingDataResponse
+	13, // [13:14] is the sub-list for method output_type
+	12, // [12:13] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
+}
+
+func init() { file_edgerecpb_edgerec_proto_init() }
+func file_edgerecpb_edgerec_proto_init() {
+	if File_edgerecpb_edgerec_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_edgerecpb_edgerec_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Sample); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_edgerecpb_edgerec_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SampleBatch); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_edgerecpb_edgerec_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamSchema); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_edgerecpb_edgerec_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TrainingDataRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_edgerecpb_edgerec_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TrainingDataResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_edgerecpb_edgerec_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Range); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_edgerecpb_edgerec_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FeatureFunc); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_edgerecpb_edgerec_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FeatureSchema); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_edgerecpb_edgerec_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ModelArtifact); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_edgerecpb_edgerec_proto_msgTypes[3].OneofWrappers = []interface{}{
+		(*TrainingDataRequest_Schema)(nil),
+		(*TrainingDataRequest_Batch)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_edgerecpb_edgerec_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_edgerecpb_edgerec_proto_goTypes,
+		DependencyIndexes: file_edgerecpb_edgerec_proto_depIdxs,
+		EnumInfos:         file_edgerecpb_edgerec_proto_enumTypes,
+		MessageInfos:      file_edgerecpb_edgerec_proto_msgTypes,
+	}.Build()
+	File_edgerecpb_edgerec_proto = out.File
+	file_edgerecpb_edgerec_proto_rawDesc = nil
+	file_edgerecpb_edgerec_proto_goTypes = nil
+	file_edgerecpb_edgerec_proto_depIdxs = nil
+}
@@ -0,0 +1,320 @@
+package recommend
+
+import (
+	"bufio"
+	"container/list"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	latencyWindow      = 1000
+	p99RefreshInterval = 100
+	shedProbeInterval  = 10
+	cpuRefreshInterval = time.Second
+	// rateLimiterBuckets is the max buckets kept, the least recently used
+	// are evicted first
+	rateLimiterBuckets = 10000
+	// anonymousClient is the bucket shared by the requests of no tenant
+	// resolved
+	anonymousClient = ""
+)
+
+// ApiOption configures the admission control and warm-up of the http api
+type ApiOption func(*apiConfig)
+
+type apiConfig struct {
+	limiter  *RateLimiter
+	shedder  *LoadShedder
+	fallback *FallbackPolicy
+	budget   *StageBudget
+	// prefilter is applied between the recall and the ranking if not nil
+	prefilter *PreFilter
+	// pages keeps the ranked lists of the paginated requests
+	pages *rankingCache
+	// sessions keeps the items seen of the sessions
+	sessions *sessionTracker
+	// feedback keeps the recent explicit feedback of the users
+	feedback *feedbackStore
+	// metadata attaches the item metadata to the items served if not nil
+	metadata *metadataEnricher
+	// explainer explains the items served of RecApiRequest.Explain if not nil
+	explainer *explainer
+	// streaming is served at /api/v1/online/stats if not nil
+	streaming *StreamingMetrics
+	// reRankers rerank the items ranked of every request
+	reRankers []ReRanker
+	// surfaces are selected by RecApiRequest.Surface if not nil
+	surfaces map[string]*Surface
+	// privacy keeps no state of the users if not nil
+	privacy *privacyMode
+	// audit records the decisions of the sampled requests if not nil
+	audit *AuditLog
+	// counterfactual logs the candidates dropped before the scoring if not
+	// nil
+	counterfactual *CounterfactualLog
+	// recorder records the sampled requests of the features resolved if not
+	// nil
+	recorder *RequestRecorder
+	// indexes are the live indexes of the build stats served if not nil
+	indexes map[string]LiveIndex
+	// armOverrides takes the arms forced by the requests
+	armOverrides bool
+	// client identifies the client of the rate limiter, set by the engine
+	client func(c *gin.Context) string
+
+	warmUpSamples []Sample
+	warmUpRounds  int
+}
+
+// WithRateLimit limits every client to rate requests per second with bursts
+// of burst requests. The client is the tenant resolved of StartTenantHttpApi,
+// all the requests of unknown api keys or tenants share one bucket, and the
+// client ip of StartHttpApi. The requests over the limit are rejected with
+// 429.
+func WithRateLimit(rate float64, burst int) ApiOption {
+	return func(c *apiConfig) {
+		c.limiter = NewRateLimiter(rate, burst)
+	}
+}
+
+// WithLoadShedding serves the popularity fallback instead of the full
+// ranking while the p99 latency of the recent requests exceeds maxP99 or the
+// system CPU usage exceeds maxCPU. Zero disables the threshold.
+func WithLoadShedding(maxP99 time.Duration, maxCPU float64) ApiOption {
+	return func(c *apiConfig) {
+		c.shedder = NewLoadShedder(maxP99, maxCPU)
+	}
+}
+
+func newApiConfig(opts []ApiOption) *apiConfig {
+	conf := &apiConfig{fallback: NewFallbackPolicy()}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	return conf
+}
+
+// handlers prepends the rate limiter to h if configured
+func (c *apiConfig) handlers(h gin.HandlerFunc) []gin.HandlerFunc {
+	if c.limiter == nil {
+		return []gin.HandlerFunc{h}
+	}
+	client := c.client
+	if client == nil {
+		client = (*gin.Context).ClientIP
+	}
+	return []gin.HandlerFunc{rateLimitMiddleware(c.limiter, client), h}
+}
+
+// RateLimiter is the token bucket rate limiter per client, at most
+// rateLimiterBuckets clients are kept
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	// order is the buckets by last use, the least recently used first
+	order *list.List
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// elem is the element of the client in RateLimiter.order
+	elem *list.Element
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		order:   list.New(),
+	}
+}
+
+// Allow takes a token of client, false if the client exceeds the limit
+func (l *RateLimiter) Allow(client string) bool {
+	return l.allowAt(client, time.Now())
+}
+
+func (l *RateLimiter) allowAt(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if ok {
+		l.order.MoveToBack(b.elem)
+	} else {
+		// the evicted client gets a full bucket again, as it would after
+		// idling long enough
+		for len(l.buckets) >= rateLimiterBuckets {
+			oldest := l.order.Front()
+			l.order.Remove(oldest)
+			delete(l.buckets, oldest.Value.(string))
+		}
+		b = &tokenBucket{tokens: l.burst, last: now, elem: l.order.PushBack(client)}
+		l.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func rateLimitMiddleware(l *RateLimiter, client func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.Allow(client(c)) {
+			c.AbortWithStatusJSON(429, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// LoadShedder decides whether to shed the load by the p99 latency of the
+// last requests and the system CPU usage
+type LoadShedder struct {
+	MaxP99 time.Duration
+	MaxCPU float64
+	// CPU returns the system CPU usage in [0, 1], default is read from
+	// /proc/stat and is always 0 if not available
+	CPU func() float64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+	observed  int
+	p99       time.Duration
+	cpu       float64
+	cpuAt     time.Time
+	shed      int
+}
+
+func NewLoadShedder(maxP99 time.Duration, maxCPU float64) *LoadShedder {
+	return &LoadShedder{
+		MaxP99:    maxP99,
+		MaxCPU:    maxCPU,
+		CPU:       newProcStatCPU(),
+		latencies: make([]time.Duration, 0, latencyWindow),
+	}
+}
+
+// Observe records the latency of a full ranking request
+func (s *LoadShedder) Observe(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.latencies) < latencyWindow {
+		s.latencies = append(s.latencies, latency)
+	} else {
+		s.latencies[s.next] = latency
+		s.next = (s.next + 1) % latencyWindow
+	}
+	s.observed++
+	if s.observed%p99RefreshInterval == 0 || len(s.latencies) < p99RefreshInterval {
+		s.p99 = percentile(s.latencies, 0.99)
+	}
+}
+
+// P99 is the p99 latency of the recent requests
+func (s *LoadShedder) P99() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.p99
+}
+
+// Shed returns true if the load should be shed. While overloaded, one in
+// shedProbeInterval requests is still fully ranked to refresh the p99.
+func (s *LoadShedder) Shed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.overloaded() {
+		s.shed = 0
+		return false
+	}
+	s.shed++
+	return s.shed%shedProbeInterval != 0
+}
+
+func (s *LoadShedder) overloaded() bool {
+	if s.MaxP99 > 0 && s.p99 > s.MaxP99 {
+		return true
+	}
+	if s.MaxCPU > 0 && s.CPU != nil {
+		if now := time.Now(); now.Sub(s.cpuAt) >= cpuRefreshInterval {
+			s.cpu, s.cpuAt = s.CPU(), now
+		}
+		return s.cpu > s.MaxCPU
+	}
+	return false
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+// newProcStatCPU returns the CPU usage since its last call read from
+// /proc/stat
+func newProcStatCPU() func() float64 {
+	var lastIdle, lastTotal uint64
+	return func() float64 {
+		idle, total, ok := readProcStat()
+		if !ok || total <= lastTotal {
+			return 0
+		}
+		usage := 1 - float64(idle-lastIdle)/float64(total-lastTotal)
+		lastIdle, lastTotal = idle, total
+		return usage
+	}
+}
+
+func readProcStat() (idle, total uint64, ok bool) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return
+	}
+	// cpu user nice system idle iowait irq softirq steal ...
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return
+	}
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		total += v
+		if i == 3 || i == 4 {
+			idle += v
+		}
+	}
+	return idle, total, true
+}
@@ -0,0 +1,109 @@
+package recommend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdmission(t *testing.T) {
+	Convey("rate limiter", t, func() {
+		l := NewRateLimiter(1, 2)
+		now := time.Now()
+		So(l.allowAt("a", now), ShouldBeTrue)
+		So(l.allowAt("a", now), ShouldBeTrue)
+		So(l.allowAt("a", now), ShouldBeFalse)
+		// other clients have their own bucket
+		So(l.allowAt("b", now), ShouldBeTrue)
+		So(l.allowAt("a", now.Add(time.Second)), ShouldBeTrue)
+		So(l.allowAt("a", now.Add(time.Second)), ShouldBeFalse)
+
+		// the least recently used bucket is evicted
+		for i := 0; i < rateLimiterBuckets; i++ {
+			l.allowAt(strconv.Itoa(i), now)
+		}
+		So(l.buckets, ShouldHaveLength, rateLimiterBuckets)
+		So(l.buckets, ShouldNotContainKey, "a")
+		So(l.buckets, ShouldNotContainKey, "b")
+		So(l.order.Front().Value, ShouldEqual, "0")
+	})
+
+	Convey("load shedder", t, func() {
+		s := NewLoadShedder(10*time.Millisecond, 0)
+		for i := 0; i < 200; i++ {
+			s.Observe(time.Millisecond)
+		}
+		So(s.P99(), ShouldEqual, time.Millisecond)
+		So(s.Shed(), ShouldBeFalse)
+		for i := 0; i < 100; i++ {
+			s.Observe(time.Second)
+		}
+		So(s.P99(), ShouldEqual, time.Second)
+		shed := 0
+		for i := 0; i < shedProbeInterval; i++ {
+			if s.Shed() {
+				shed++
+			}
+		}
+		So(shed, ShouldEqual, shedProbeInterval-1)
+
+		cpu := NewLoadShedder(0, 0.8)
+		cpu.CPU = func() float64 { return 0.9 }
+		So(cpu.Shed(), ShouldBeTrue)
+	})
+
+	Convey("admission control of the http api", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &constPredictor{score: 1})), ShouldBeNil)
+		recommend := func(opts ...ApiOption) func() (int, RecApiResponse) {
+			engine := newTenantEngine(r, "/api/v1/recommend", opts...)
+			return func() (int, RecApiResponse) {
+				req := httptest.NewRequest("POST", "/api/v1/recommend",
+					strings.NewReader(`{"userId":1,"itemIdList":[3,4]}`))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				engine.ServeHTTP(w, req)
+				var resp RecApiResponse
+				_ = json.Unmarshal(w.Body.Bytes(), &resp)
+				return w.Code, resp
+			}
+		}
+
+		limited := recommend(WithRateLimit(0.001, 1))
+		code, _ := limited()
+		So(code, ShouldEqual, http.StatusOK)
+		code, _ = limited()
+		So(code, ShouldEqual, http.StatusTooManyRequests)
+
+		// the rotated unknown api keys share the anonymous bucket
+		So(r.Register(NewTenant("keyed", &constPredictor{score: 1}, "secret")), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend", WithRateLimit(0.001, 1))
+		keyed := func(key string) int {
+			req := httptest.NewRequest("POST", "/api/v1/recommend",
+				strings.NewReader(`{"userId":1,"itemIdList":[3,4]}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(ApiKeyHeader, key)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			return w.Code
+		}
+		So(keyed("random1"), ShouldNotEqual, http.StatusTooManyRequests)
+		So(keyed("random2"), ShouldEqual, http.StatusTooManyRequests)
+		So(keyed("secret"), ShouldEqual, http.StatusOK)
+		So(keyed("secret"), ShouldEqual, http.StatusTooManyRequests)
+
+		shed := recommend(WithLoadShedding(0, 0.5), func(c *apiConfig) {
+			c.shedder.CPU = func() float64 { return 1 }
+		})
+		code, resp := shed()
+		So(code, ShouldEqual, http.StatusOK)
+		So(resp.Fallback, ShouldEqual, FallbackLoadShedding)
+		So(resp.ItemScoreList, ShouldResemble, []ItemScore{{ItemId: 3}, {ItemId: 4}})
+	})
+}
@@ -0,0 +1,327 @@
+// Package aggregate is the sliding window user aggregate features over the
+// behavior stream: the count, unique items, average rating and the ratio of
+// every category in each window, e.g. the last 1h, 1d and 7d.
+//
+// At serving time the Aggregator is fed by Add and maintains the windows
+// incrementally. At training time Replay feeds the logged events through the
+// same code, so the training features are exactly the ones served at the
+// sample time. The events out of all the windows of the latest event of a
+// user are dropped, and the least recently added users beyond
+// Spec.MaxUsers are forgotten.
+package aggregate
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+// Event is a user behavior, Timestamp is in seconds
+type Event struct {
+	UserId    int
+	ItemId    int
+	Category  string
+	Rating    float32
+	Timestamp int64
+}
+
+// DefaultMaxUsers is the max users of the Aggregator of zero Spec.MaxUsers
+const DefaultMaxUsers = 100000
+
+// Spec is the layout of the aggregate features, for every window:
+//
+//	count | unique items | average rating | ratio of every category
+type Spec struct {
+	Windows    []time.Duration
+	Categories []string
+	// MaxUsers is the max users kept, the least recently added are evicted
+	// first, DefaultMaxUsers if 0
+	MaxUsers int
+}
+
+// DefaultSpec is the windows of the last 1h, 1d and 7d without category
+var DefaultSpec = Spec{Windows: []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}}
+
+// Width is the length of the feature vector
+func (s Spec) Width() int {
+	return len(s.Windows) * (3 + len(s.Categories))
+}
+
+// FeatureNames are the names of the features, e.g. cnt_1h0m0s
+func (s Spec) FeatureNames() []string {
+	names := make([]string, 0, s.Width())
+	for _, w := range s.Windows {
+		names = append(names, "cnt_"+w.String(), "uniq_"+w.String(), "avg_rating_"+w.String())
+		for _, c := range s.Categories {
+			names = append(names, fmt.Sprintf("ratio_%s_%s", c, w))
+		}
+	}
+	return names
+}
+
+// window is the running aggregates of events[start:end]
+type window struct {
+	span       int64
+	start, end int
+	ratingSum  float64
+	categories map[string]int
+	items      map[int]int
+}
+
+func (w *window) add(e *Event, sign int) {
+	w.ratingSum += float64(sign) * float64(e.Rating)
+	if e.Category != "" {
+		w.categories[e.Category] += sign
+	}
+	w.items[e.ItemId] += sign
+	if w.items[e.ItemId] == 0 {
+		delete(w.items, e.ItemId)
+	}
+}
+
+type userState struct {
+	// events are ordered by timestamp, the ones before all the windows are
+	// trimmed
+	events  []Event
+	windows []window
+	// ts is the timestamp of the last query
+	ts    int64
+	dirty bool
+	// elem is the element of the user in Aggregator.order
+	elem *list.Element
+}
+
+// Aggregator maintains the aggregate features of every user
+type Aggregator struct {
+	spec Spec
+	// span is the longest window in seconds
+	span int64
+
+	mu    sync.Mutex
+	users map[int]*userState
+	// order is the user ids by the last Add, the least recent first
+	order *list.List
+}
+
+func NewAggregator(spec Spec) *Aggregator {
+	if spec.MaxUsers <= 0 {
+		spec.MaxUsers = DefaultMaxUsers
+	}
+	a := &Aggregator{spec: spec, users: make(map[int]*userState), order: list.New()}
+	for _, d := range spec.Windows {
+		if span := int64(d / time.Second); span > a.span {
+			a.span = span
+		}
+	}
+	return a
+}
+
+func (a *Aggregator) Spec() Spec {
+	return a.spec
+}
+
+func (a *Aggregator) newUserState() *userState {
+	u := &userState{windows: make([]window, len(a.spec.Windows))}
+	u.reset(a.spec)
+	return u
+}
+
+func (u *userState) reset(spec Spec) {
+	for i, d := range spec.Windows {
+		u.windows[i] = window{
+			span:       int64(d / time.Second),
+			categories: make(map[string]int),
+			items:      make(map[int]int),
+		}
+	}
+	u.ts = 0
+	u.dirty = false
+}
+
+// Add feeds the event, the events are expected in timestamp order, a late
+// event recomputes the user windows on the next query
+func (a *Aggregator) Add(e Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, ok := a.users[e.UserId]
+	if !ok {
+		for a.order.Len() >= a.spec.MaxUsers {
+			front := a.order.Front()
+			a.order.Remove(front)
+			delete(a.users, front.Value.(int))
+		}
+		u = a.newUserState()
+		u.elem = a.order.PushBack(e.UserId)
+		a.users[e.UserId] = u
+	} else {
+		a.order.MoveToBack(u.elem)
+	}
+	n := len(u.events)
+	if n == 0 || u.events[n-1].Timestamp <= e.Timestamp {
+		u.events = append(u.events, e)
+		a.expire(u)
+		return
+	}
+	i := sort.Search(n, func(i int) bool {
+		return u.events[i].Timestamp > e.Timestamp
+	})
+	u.events = append(u.events, Event{})
+	copy(u.events[i+1:], u.events[i:])
+	u.events[i] = e
+	u.dirty = true
+}
+
+// expire trims the events out of all the windows of the latest event of u,
+// amortized. The windows still of the trimmed events are recomputed on the
+// next query.
+func (a *Aggregator) expire(u *userState) {
+	cutoff := u.events[len(u.events)-1].Timestamp - a.span
+	k := sort.Search(len(u.events), func(i int) bool {
+		return u.events[i].Timestamp >= cutoff
+	})
+	if k == 0 || k*2 < len(u.events) {
+		return
+	}
+	u.events = append(u.events[:0:0], u.events[k:]...)
+	for i := range u.windows {
+		w := &u.windows[i]
+		if w.start < k {
+			u.dirty = true
+			return
+		}
+		w.start -= k
+		w.end -= k
+	}
+}
+
+// Features returns the aggregates of the events of userId in [ts - window,
+// ts) of every window. The queries of a user are expected in timestamp
+// order, an earlier query recomputes from the events not trimmed yet.
+func (a *Aggregator) Features(userId int, ts int64) rcmd.Tensor {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, ok := a.users[userId]
+	if !ok {
+		return make(rcmd.Tensor, a.spec.Width())
+	}
+	if u.dirty || ts < u.ts {
+		u.reset(a.spec)
+	}
+	u.ts = ts
+	a.advance(u, ts)
+	return a.vector(u)
+}
+
+// GetUserFeatureAsOf implements rcmd.AsOfUserFeaturer
+func (a *Aggregator) GetUserFeatureAsOf(_ context.Context, userId int, ts int64) (rcmd.Tensor, error) {
+	return a.Features(userId, ts), nil
+}
+
+// GetUserFeature returns the aggregates of now
+func (a *Aggregator) GetUserFeature(_ context.Context, userId int) (rcmd.Tensor, error) {
+	return a.Features(userId, time.Now().Unix()), nil
+}
+
+func (a *Aggregator) advance(u *userState, ts int64) {
+	minStart := len(u.events)
+	for i := range u.windows {
+		w := &u.windows[i]
+		for w.end < len(u.events) && u.events[w.end].Timestamp < ts {
+			w.add(&u.events[w.end], 1)
+			w.end++
+		}
+		for w.start < w.end && u.events[w.start].Timestamp < ts-w.span {
+			w.add(&u.events[w.start], -1)
+			w.start++
+		}
+		if w.start < minStart {
+			minStart = w.start
+		}
+	}
+	// trim the events out of all the windows, amortized
+	if minStart > 0 && minStart*2 >= len(u.events) {
+		u.events = append(u.events[:0:0], u.events[minStart:]...)
+		for i := range u.windows {
+			u.windows[i].start -= minStart
+			u.windows[i].end -= minStart
+		}
+	}
+}
+
+func (a *Aggregator) vector(u *userState) rcmd.Tensor {
+	vec := make(rcmd.Tensor, 0, a.spec.Width())
+	for i := range u.windows {
+		w := &u.windows[i]
+		cnt := w.end - w.start
+		var avgRating float32
+		if cnt > 0 {
+			avgRating = float32(w.ratingSum / float64(cnt))
+		}
+		vec = append(vec, float32(cnt), float32(len(w.items)), avgRating)
+		for _, c := range a.spec.Categories {
+			var ratio float32
+			if cnt > 0 {
+				ratio = float32(w.categories[c]) / float32(cnt)
+			}
+			vec = append(vec, ratio)
+		}
+	}
+	return vec
+}
+
+// Replay feeds the logged events and samples by timestamp order through a
+// new Aggregator of spec, and returns the features of every sample user at
+// the sample timestamp, just like served at that time.
+func Replay(spec Spec, events []Event, samples []rcmd.Sample) *AsOfTable {
+	sorted := append(events[:0:0], events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+	points := append(samples[:0:0], samples...)
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp < points[j].Timestamp
+	})
+
+	var (
+		agg   = NewAggregator(spec)
+		table = &AsOfTable{width: spec.Width(), features: make(map[asOfKey]rcmd.Tensor)}
+		i     int
+	)
+	for _, s := range points {
+		// the window excludes ts, feed the events before it only to keep the
+		// serving order
+		for ; i < len(sorted) && sorted[i].Timestamp < s.Timestamp; i++ {
+			agg.Add(sorted[i])
+		}
+		key := asOfKey{userId: s.UserId, ts: s.Timestamp}
+		if _, ok := table.features[key]; !ok {
+			table.features[key] = agg.Features(s.UserId, s.Timestamp)
+		}
+	}
+	return table
+}
+
+type asOfKey struct {
+	userId int
+	ts     int64
+}
+
+// AsOfTable is the replayed features of the training samples, it implements
+// rcmd.AsOfUserFeaturer
+type AsOfTable struct {
+	width    int
+	features map[asOfKey]rcmd.Tensor
+}
+
+// GetUserFeatureAsOf returns the replayed features, zeros if not replayed
+func (t *AsOfTable) GetUserFeatureAsOf(_ context.Context, userId int, ts int64) (rcmd.Tensor, error) {
+	if f, ok := t.features[asOfKey{userId: userId, ts: ts}]; ok {
+		return f, nil
+	}
+	return make(rcmd.Tensor, t.width), nil
+}
@@ -0,0 +1,122 @@
+package aggregate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const hour = int64(3600)
+
+func TestAggregator(t *testing.T) {
+	spec := Spec{Windows: []time.Duration{time.Hour, 24 * time.Hour}, Categories: []string{"comedy", "drama"}}
+	events := []Event{
+		{UserId: 1, ItemId: 1, Category: "comedy", Rating: 4, Timestamp: 0},
+		{UserId: 1, ItemId: 2, Category: "drama", Rating: 2, Timestamp: 10 * hour},
+		{UserId: 1, ItemId: 2, Category: "drama", Rating: 3, Timestamp: 10*hour + 60},
+		{UserId: 2, ItemId: 3, Category: "comedy", Rating: 5, Timestamp: 10 * hour},
+		{UserId: 1, ItemId: 3, Category: "comedy", Rating: 5, Timestamp: 30 * hour},
+	}
+
+	Convey("spec layout", t, func() {
+		So(spec.Width(), ShouldEqual, 10)
+		names := spec.FeatureNames()
+		So(names, ShouldHaveLength, 10)
+		So(names[:5], ShouldResemble, []string{"cnt_1h0m0s", "uniq_1h0m0s", "avg_rating_1h0m0s", "ratio_comedy_1h0m0s", "ratio_drama_1h0m0s"})
+	})
+
+	Convey("incremental sliding windows", t, func() {
+		agg := NewAggregator(spec)
+		So(agg.Features(1, 0), ShouldResemble, make(rcmd.Tensor, 10))
+		for _, e := range events {
+			agg.Add(e)
+		}
+		// the window excludes the events at ts
+		So(agg.Features(1, 0), ShouldResemble, make(rcmd.Tensor, 10))
+		So(agg.Features(1, 10*hour+120), ShouldResemble, rcmd.Tensor{
+			2, 1, 2.5, 0, 1,
+			3, 2, 3, 1. / 3, 2. / 3,
+		})
+		// the comedy is out of the 1d window
+		So(agg.Features(1, 25*hour), ShouldResemble, rcmd.Tensor{
+			0, 0, 0, 0, 0,
+			2, 1, 2.5, 0, 1,
+		})
+		// an earlier query recomputes
+		So(agg.Features(1, 10*hour+1), ShouldResemble, rcmd.Tensor{
+			1, 1, 2, 0, 1,
+			2, 2, 3, 0.5, 0.5,
+		})
+		// a late event too
+		agg.Add(Event{UserId: 1, ItemId: 4, Category: "comedy", Rating: 1, Timestamp: 10*hour + 30})
+		f := agg.Features(1, 10*hour+120)
+		So(f[:3], ShouldResemble, rcmd.Tensor{3, 2, 2})
+
+		g, err := agg.GetUserFeatureAsOf(context.Background(), 2, 10*hour+1)
+		So(err, ShouldBeNil)
+		So(g[:5], ShouldResemble, rcmd.Tensor{1, 1, 5, 1, 0})
+	})
+
+	Convey("replay is the same as served", t, func() {
+		samples := []rcmd.Sample{
+			{UserId: 1, Timestamp: 30 * hour},
+			{UserId: 1, Timestamp: 10*hour + 120},
+			{UserId: 2, Timestamp: 10*hour + 1},
+			{UserId: 3, Timestamp: 1},
+		}
+		table := Replay(spec, events, samples)
+		for _, s := range samples {
+			served := NewAggregator(spec)
+			for _, e := range events {
+				if e.Timestamp < s.Timestamp {
+					served.Add(e)
+				}
+			}
+			f, err := table.GetUserFeatureAsOf(context.Background(), s.UserId, s.Timestamp)
+			So(err, ShouldBeNil)
+			So(f, ShouldResemble, served.Features(s.UserId, s.Timestamp))
+		}
+		f, _ := table.GetUserFeatureAsOf(context.Background(), 1, 42)
+		So(f, ShouldResemble, make(rcmd.Tensor, 10))
+	})
+
+	Convey("trim the events out of windows", t, func() {
+		agg := NewAggregator(Spec{Windows: []time.Duration{time.Hour}})
+		for i := int64(0); i < 100; i++ {
+			agg.Add(Event{UserId: 1, ItemId: int(i), Timestamp: i * hour})
+			agg.Features(1, i*hour+1)
+		}
+		So(len(agg.users[1].events), ShouldBeLessThan, 4)
+		So(agg.Features(1, 99*hour+1), ShouldResemble, rcmd.Tensor{1, 1, 0})
+	})
+
+	Convey("trim the events out of windows of the latest event", t, func() {
+		agg := NewAggregator(Spec{Windows: []time.Duration{time.Hour, 2 * time.Hour}})
+		for i := int64(0); i < 100; i++ {
+			agg.Add(Event{UserId: 1, ItemId: int(i), Timestamp: i * hour})
+		}
+		So(len(agg.users[1].events), ShouldBeLessThan, 6)
+		So(agg.Features(1, 99*hour+1), ShouldResemble, rcmd.Tensor{1, 1, 0, 2, 2, 0})
+		// the windows of the trimmed events are recomputed
+		for i := int64(100); i < 110; i++ {
+			agg.Add(Event{UserId: 1, ItemId: int(i), Timestamp: i * hour})
+		}
+		So(agg.Features(1, 109*hour+1), ShouldResemble, rcmd.Tensor{1, 1, 0, 2, 2, 0})
+	})
+
+	Convey("evict the least recently added users", t, func() {
+		agg := NewAggregator(Spec{Windows: []time.Duration{time.Hour}, MaxUsers: 2})
+		agg.Add(Event{UserId: 1, ItemId: 1, Timestamp: 0})
+		agg.Add(Event{UserId: 2, ItemId: 1, Timestamp: 1})
+		agg.Add(Event{UserId: 1, ItemId: 2, Timestamp: 2})
+		agg.Add(Event{UserId: 3, ItemId: 1, Timestamp: 3})
+		So(agg.users, ShouldHaveLength, 2)
+		So(agg.order.Len(), ShouldEqual, 2)
+		So(agg.Features(2, 10), ShouldResemble, rcmd.Tensor{0, 0, 0})
+		So(agg.Features(1, 10), ShouldResemble, rcmd.Tensor{2, 2, 0})
+		So(agg.Features(3, 10), ShouldResemble, rcmd.Tensor{1, 1, 0})
+	})
+}
@@ -0,0 +1,246 @@
+// Package ann is the nearest neighbor search of the item embeddings, e.g. to
+// recall the candidates by the user tower output of a two-tower model. The
+// similarity Metric must be the one the embeddings are trained with: the dot
+// product embeddings carry the popularity in their norms, which a cosine
+// index normalizes away.
+package ann
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/chewxy/math32"
+)
+
+// Metric is the similarity of the vectors, larger is more similar
+type Metric string
+
+const (
+	// Cosine is the dot product of the normalized vectors, the zero vectors
+	// are similar to nothing
+	Cosine Metric = "cosine"
+	// Dot is the dot product of the raw vectors
+	Dot Metric = "dot"
+	// Euclidean is the negative L2 distance
+	Euclidean Metric = "euclidean"
+)
+
+// Validate checks m is a known metric
+func (m Metric) Validate() error {
+	switch m {
+	case Cosine, Dot, Euclidean:
+		return nil
+	}
+	return fmt.Errorf("unknown similarity metric %q", m)
+}
+
+// Similarity is the m similarity of a and b of the same length
+func Similarity(m Metric, a, b []float32) float32 {
+	switch m {
+	case Cosine:
+		na, nb := norm(a), norm(b)
+		if na == 0 || nb == 0 {
+			return 0
+		}
+		return dot(a, b) / na / nb
+	case Euclidean:
+		return -math32.Sqrt(squaredDistance(a, b))
+	}
+	return dot(a, b)
+}
+
+func dot(a, b []float32) (s float32) {
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return
+}
+
+func norm(a []float32) float32 {
+	return math32.Sqrt(dot(a, a))
+}
+
+func squaredDistance(a, b []float32) (s float32) {
+	for i := range a {
+		d := a[i] - b[i]
+		s += d * d
+	}
+	return
+}
+
+// normalized returns a of unit norm, the zero vector as is
+func normalized(a []float32) []float32 {
+	v := make([]float32, len(a))
+	if n := norm(a); n != 0 {
+		for i := range a {
+			v[i] = a[i] / n
+		}
+	}
+	return v
+}
+
+// Neighbor is a search result
+type Neighbor struct {
+	ItemId int     `json:"itemId"`
+	Score  float32 `json:"score"`
+}
+
+// Index searches the k most similar items of a query vector
+type Index interface {
+	// Search returns at most k neighbors of query, the most similar first
+	Search(query []float32, k int) []Neighbor
+	Metric() Metric
+	Dim() int
+	Len() int
+}
+
+// topK keeps the k best neighbors, the worst on top
+type topK struct {
+	k         int
+	neighbors []Neighbor
+}
+
+func less(a, b Neighbor) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	return a.ItemId > b.ItemId
+}
+
+func (t *topK) Len() int           { return len(t.neighbors) }
+func (t *topK) Less(i, j int) bool { return less(t.neighbors[i], t.neighbors[j]) }
+func (t *topK) Swap(i, j int)      { t.neighbors[i], t.neighbors[j] = t.neighbors[j], t.neighbors[i] }
+func (t *topK) Push(x interface{}) { t.neighbors = append(t.neighbors, x.(Neighbor)) }
+func (t *topK) Pop() (x interface{}) {
+	n := len(t.neighbors)
+	x, t.neighbors = t.neighbors[n-1], t.neighbors[:n-1]
+	return
+}
+
+func (t *topK) add(n Neighbor) {
+	if len(t.neighbors) < t.k {
+		heap.Push(t, n)
+	} else if less(t.neighbors[0], n) {
+		t.neighbors[0] = n
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted returns the neighbors of t, the best first
+func (t *topK) sorted() []Neighbor {
+	sort.Slice(t.neighbors, func(i, j int) bool {
+		return less(t.neighbors[j], t.neighbors[i])
+	})
+	return t.neighbors
+}
+
+// FlatIndex is the exact search by comparing the query with every item
+type FlatIndex struct {
+	metric  Metric
+	dim     int
+	ids     []int
+	vectors [][]float32
+}
+
+// NewFlatIndex creates the index of the vectors of ids, the vectors are
+// copied, and normalized if metric is Cosine
+func NewFlatIndex(metric Metric, ids []int, vectors [][]float32) (*FlatIndex, error) {
+	if err := metric.Validate(); err != nil {
+		return nil, err
+	}
+	if len(ids) != len(vectors) {
+		return nil, fmt.Errorf("%d ids of %d vectors", len(ids), len(vectors))
+	}
+	idx := &FlatIndex{
+		metric:  metric,
+		ids:     append([]int(nil), ids...),
+		vectors: make([][]float32, len(vectors)),
+	}
+	for i, v := range vectors {
+		if i == 0 {
+			idx.dim = len(v)
+		} else if len(v) != idx.dim {
+			return nil, fmt.Errorf("vector of item %d dim %d != %d", ids[i], len(v), idx.dim)
+		}
+		if metric == Cosine {
+			idx.vectors[i] = normalized(v)
+		} else {
+			idx.vectors[i] = append([]float32(nil), v...)
+		}
+	}
+	return idx, nil
+}
+
+// FromEmbeddings creates the FlatIndex of the item embeddings keyed by the
+// item ids, e.g. recommend.ItemEmbeddings
+func FromEmbeddings(metric Metric, embeddings word2vec.EmbeddingMap32) (*FlatIndex, error) {
+	ids, vectors, err := embeddingRows(embeddings)
+	if err != nil {
+		return nil, err
+	}
+	return NewFlatIndex(metric, ids, vectors)
+}
+
+// embeddingRows returns the item ids and vectors of embeddings by item id
+func embeddingRows(embeddings word2vec.EmbeddingMap32) (ids []int, vectors [][]float32, err error) {
+	ids = make([]int, 0, len(embeddings))
+	for key := range embeddings {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("item id %q of embedding is not an integer", key)
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	vectors = make([][]float32, len(ids))
+	for i, id := range ids {
+		vectors[i] = embeddings[strconv.Itoa(id)]
+	}
+	return
+}
+
+func (idx *FlatIndex) Metric() Metric {
+	return idx.metric
+}
+
+func (idx *FlatIndex) Dim() int {
+	return idx.dim
+}
+
+func (idx *FlatIndex) Len() int {
+	return len(idx.ids)
+}
+
+// score is the similarity of query prepared by prepare with v of the index
+func (idx *FlatIndex) score(query, v []float32) float32 {
+	if idx.metric == Euclidean {
+		return -math32.Sqrt(squaredDistance(query, v))
+	}
+	// the cosine vectors and query are normalized
+	return dot(query, v)
+}
+
+// prepare returns query normalized for Cosine, nil if the dim mismatches
+func prepare(metric Metric, dim int, query []float32) []float32 {
+	if len(query) != dim {
+		return nil
+	}
+	if metric == Cosine {
+		return normalized(query)
+	}
+	return query
+}
+
+func (idx *FlatIndex) Search(query []float32, k int) []Neighbor {
+	if query = prepare(idx.metric, idx.dim, query); query == nil || k <= 0 {
+		return nil
+	}
+	top := &topK{k: k}
+	for i, v := range idx.vectors {
+		top.add(Neighbor{ItemId: idx.ids[i], Score: idx.score(query, v)})
+	}
+	return top.sorted()
+}
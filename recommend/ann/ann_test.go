@@ -0,0 +1,66 @@
+package ann
+
+import (
+	"testing"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func ids(neighbors []Neighbor) []int {
+	list := make([]int, len(neighbors))
+	for i, n := range neighbors {
+		list[i] = n.ItemId
+	}
+	return list
+}
+
+func TestFlatIndex(t *testing.T) {
+	// item 1 is popular: the same direction as 2 but of a larger norm
+	embeddings := word2vec.EmbeddingMap32{
+		"1": {4, 4},
+		"2": {1, 1},
+		"3": {1, 0},
+		"4": {0, 0},
+	}
+	query := []float32{1, 0.9}
+
+	Convey("the metrics", t, func() {
+		cosine, err := FromEmbeddings(Cosine, embeddings)
+		So(err, ShouldBeNil)
+		top := cosine.Search(query, 3)
+		So(ids(top), ShouldResemble, []int{1, 2, 3})
+		So(top[0].Score, ShouldAlmostEqual, top[1].Score, 1e-6)
+		So(top[0].Score, ShouldAlmostEqual, Similarity(Cosine, query, embeddings["1"]), 1e-6)
+
+		dot, err := FromEmbeddings(Dot, embeddings)
+		So(err, ShouldBeNil)
+		top = dot.Search(query, 2)
+		So(ids(top), ShouldResemble, []int{1, 2})
+		So(top[0].Score, ShouldAlmostEqual, 7.6, 1e-5)
+
+		euclidean, err := FromEmbeddings(Euclidean, embeddings)
+		So(err, ShouldBeNil)
+		top = euclidean.Search(query, 10)
+		So(ids(top), ShouldResemble, []int{2, 3, 4, 1})
+		So(top[0].Score, ShouldAlmostEqual, -0.1, 1e-5)
+
+		// the zero vector and the zero query are similar to nothing by cosine
+		So(Similarity(Cosine, query, embeddings["4"]), ShouldEqual, 0)
+		So(cosine.Search([]float32{0, 0}, 1)[0].Score, ShouldEqual, 0)
+	})
+
+	Convey("invalid input", t, func() {
+		_, err := FromEmbeddings("manhattan", embeddings)
+		So(err, ShouldNotBeNil)
+		_, err = NewFlatIndex(Dot, []int{1, 2}, [][]float32{{1}, {1, 2}})
+		So(err, ShouldNotBeNil)
+		_, err = FromEmbeddings(Dot, word2vec.EmbeddingMap32{"a": {1}})
+		So(err, ShouldNotBeNil)
+
+		idx, err := NewFlatIndex(Dot, []int{1}, [][]float32{{1, 2}})
+		So(err, ShouldBeNil)
+		So(idx.Search([]float32{1}, 1), ShouldBeNil)
+		So(idx.Search([]float32{1, 1}, 0), ShouldBeNil)
+	})
+}
@@ -0,0 +1,418 @@
+package ann
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/chewxy/math32"
+)
+
+const (
+	// DefaultSubspaces is the product quantization subspaces of a vector
+	DefaultSubspaces = 8
+	// DefaultCodebookSize is the centroids of a subspace codebook, a code
+	// is a byte so it's at most 256
+	DefaultCodebookSize = 256
+	// DefaultProbes is the inverted lists searched of a query
+	DefaultProbes = 8
+	// DefaultTrainSize is the max vectors the quantizers are trained on
+	DefaultTrainSize = 65536
+	// DefaultIterations is the k-means iterations of the quantizers
+	DefaultIterations = 20
+	// progressEvery is the items encoded between the progress reports
+	progressEvery = 4096
+)
+
+// IVFPQOptions configures the IVFPQIndex, the zero values are the defaults
+type IVFPQOptions struct {
+	// Lists is the coarse clusters of the inverted file, sqrt of the items
+	// by default
+	Lists int `json:"lists" yaml:"lists"`
+	// Probes is the lists searched of a query, more is slower but recalls
+	// more
+	Probes int `json:"probes" yaml:"probes"`
+	// Subspaces is the sub vectors a vector is split into, the code of a
+	// vector is Subspaces bytes. It's at most the dim.
+	Subspaces int `json:"subspaces" yaml:"subspaces"`
+	// CodebookSize is the centroids of every subspace, at most 256
+	CodebookSize int `json:"codebook_size" yaml:"codebook_size"`
+	// Rerank > 0 keeps the raw vectors to re-score the top Rerank
+	// candidates by the exact similarity, which costs the memory of a
+	// FlatIndex but recalls much more
+	Rerank     int   `json:"rerank" yaml:"rerank"`
+	TrainSize  int   `json:"train_size" yaml:"train_size"`
+	Iterations int   `json:"iterations" yaml:"iterations"`
+	Seed       int64 `json:"seed" yaml:"seed"`
+	// Progress reports the share done of the build if not nil
+	Progress func(done float64) `json:"-" yaml:"-"`
+}
+
+// IVFPQIndex is the compressed approximate index of the large catalogs: the
+// items are clustered into the inverted lists by a coarse quantizer, and the
+// residuals to the list centroids are product quantized into a byte per
+// subspace. A query searches the Probes most similar lists by the
+// asymmetric distances of its raw vector to the codes.
+type IVFPQIndex struct {
+	metric Metric
+	dim    int
+	opts   IVFPQOptions
+	// bounds of the subspaces, subspace m is [bounds[m], bounds[m+1])
+	bounds    []int
+	coarse    [][]float32
+	codebooks [][][]float32
+	// the items ordered by list, list l is [starts[l], starts[l+1])
+	starts []int
+	ids    []int
+	// codes is the Subspaces codes of every item
+	codes []uint8
+	// vectors is the raw vectors if reranked
+	vectors [][]float32
+}
+
+// NewIVFPQIndex trains the quantizers of the vectors of ids by opts and
+// encodes the vectors, the vectors are normalized if metric is Cosine
+func NewIVFPQIndex(metric Metric, ids []int, vectors [][]float32, opts IVFPQOptions) (*IVFPQIndex, error) {
+	// the validation and the normalization of the flat index
+	flat, err := NewFlatIndex(metric, ids, vectors)
+	if err != nil {
+		return nil, err
+	}
+	if flat.Len() == 0 {
+		return nil, fmt.Errorf("no vector to index")
+	}
+	dim := flat.dim
+	if opts.Lists <= 0 {
+		opts.Lists = int(math.Sqrt(float64(flat.Len())))
+	}
+	if opts.Lists > flat.Len() {
+		opts.Lists = flat.Len()
+	}
+	if opts.Probes <= 0 {
+		opts.Probes = DefaultProbes
+	}
+	if opts.Probes > opts.Lists {
+		opts.Probes = opts.Lists
+	}
+	if opts.Subspaces <= 0 {
+		opts.Subspaces = DefaultSubspaces
+	}
+	if opts.Subspaces > dim {
+		opts.Subspaces = dim
+	}
+	if opts.CodebookSize <= 0 {
+		opts.CodebookSize = DefaultCodebookSize
+	}
+	if opts.CodebookSize > 256 {
+		return nil, fmt.Errorf("codebook size %d > 256", opts.CodebookSize)
+	}
+	if opts.TrainSize <= 0 {
+		opts.TrainSize = DefaultTrainSize
+	}
+	if opts.Iterations <= 0 {
+		opts.Iterations = DefaultIterations
+	}
+
+	idx := &IVFPQIndex{
+		metric: metric,
+		dim:    dim,
+		opts:   opts,
+		bounds: make([]int, opts.Subspaces+1),
+		starts: make([]int, opts.Lists+1),
+	}
+	for m := range idx.bounds {
+		idx.bounds[m] = m * dim / opts.Subspaces
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(float64) {}
+	}
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	train := sample(rnd, flat.vectors, opts.TrainSize)
+	idx.coarse = kmeans(rnd, train, opts.Lists, opts.Iterations)
+	progress(0.3)
+
+	// the subspace codebooks are shared by the lists, trained on the
+	// residuals to the list centroids
+	residuals := make([][]float32, len(train))
+	for i, v := range train {
+		residuals[i] = sub(v, idx.coarse[nearest(idx.coarse, v)])
+	}
+	idx.codebooks = make([][][]float32, opts.Subspaces)
+	for m := range idx.codebooks {
+		lo, hi := idx.bounds[m], idx.bounds[m+1]
+		subs := make([][]float32, len(residuals))
+		for i, r := range residuals {
+			subs[i] = r[lo:hi]
+		}
+		idx.codebooks[m] = kmeans(rnd, subs, opts.CodebookSize, opts.Iterations)
+		progress(0.3 + 0.3*float64(m+1)/float64(opts.Subspaces))
+	}
+
+	assignments := make([]int, flat.Len())
+	for i, v := range flat.vectors {
+		assignments[i] = nearest(idx.coarse, v)
+		idx.starts[assignments[i]+1]++
+	}
+	for l := 0; l < opts.Lists; l++ {
+		idx.starts[l+1] += idx.starts[l]
+	}
+	var (
+		M    = opts.Subspaces
+		next = append([]int(nil), idx.starts[:opts.Lists]...)
+	)
+	idx.ids = make([]int, flat.Len())
+	idx.codes = make([]uint8, flat.Len()*M)
+	if opts.Rerank > 0 {
+		idx.vectors = make([][]float32, flat.Len())
+	}
+	for i, v := range flat.vectors {
+		l := assignments[i]
+		pos := next[l]
+		next[l]++
+		idx.ids[pos] = flat.ids[i]
+		r := sub(v, idx.coarse[l])
+		for m, codebook := range idx.codebooks {
+			idx.codes[pos*M+m] = uint8(nearest(codebook, r[idx.bounds[m]:idx.bounds[m+1]]))
+		}
+		if opts.Rerank > 0 {
+			idx.vectors[pos] = v
+		}
+		if i%progressEvery == 0 {
+			progress(0.6 + 0.4*float64(i)/float64(len(flat.vectors)))
+		}
+	}
+	progress(1)
+	return idx, nil
+}
+
+func (idx *IVFPQIndex) Metric() Metric {
+	return idx.metric
+}
+
+func (idx *IVFPQIndex) Dim() int {
+	return idx.dim
+}
+
+func (idx *IVFPQIndex) Len() int {
+	return len(idx.ids)
+}
+
+// Options returns the options of idx with the defaults filled
+func (idx *IVFPQIndex) Options() IVFPQOptions {
+	return idx.opts
+}
+
+// Search is approximate, with the asymmetric distance scores unless
+// reranked by the exact similarity
+func (idx *IVFPQIndex) Search(query []float32, k int) []Neighbor {
+	if query = prepare(idx.metric, idx.dim, query); query == nil || k <= 0 {
+		return nil
+	}
+	// the lists of the most similar centroids
+	probes := &topK{k: idx.opts.Probes}
+	for l, c := range idx.coarse {
+		probes.add(Neighbor{ItemId: l, Score: idx.coarseScore(query, c)})
+	}
+
+	candidates := k
+	if idx.opts.Rerank > candidates {
+		candidates = idx.opts.Rerank
+	}
+	var (
+		M     = len(idx.codebooks)
+		table = make([][]float32, M)
+		// the candidates are by their positions in idx.ids
+		top       = &topK{k: candidates}
+		dotTabled bool
+	)
+	for m, codebook := range idx.codebooks {
+		table[m] = make([]float32, len(codebook))
+	}
+	for _, probe := range probes.neighbors {
+		l := probe.ItemId
+		start, end := idx.starts[l], idx.starts[l+1]
+		if start == end {
+			continue
+		}
+		var base float32
+		if idx.metric == Euclidean {
+			// -||q - c - r||^2 of the residual of the query to the list
+			r := sub(query, idx.coarse[l])
+			for m, codebook := range idx.codebooks {
+				qm := r[idx.bounds[m]:idx.bounds[m+1]]
+				for j, cj := range codebook {
+					table[m][j] = -squaredDistance(qm, cj)
+				}
+			}
+		} else {
+			// q.c + q.r, the table of q.r is the same of all the lists
+			base = probe.Score
+			if !dotTabled {
+				for m, codebook := range idx.codebooks {
+					qm := query[idx.bounds[m]:idx.bounds[m+1]]
+					for j, cj := range codebook {
+						table[m][j] = dot(qm, cj)
+					}
+				}
+				dotTabled = true
+			}
+		}
+		for pos := start; pos < end; pos++ {
+			s := base
+			for m, code := range idx.codes[pos*M : (pos+1)*M] {
+				s += table[m][code]
+			}
+			top.add(Neighbor{ItemId: pos, Score: s})
+		}
+	}
+
+	result := top.sorted()
+	if idx.opts.Rerank > 0 {
+		exact := &topK{k: k}
+		for _, n := range result {
+			exact.add(Neighbor{ItemId: idx.ids[n.ItemId], Score: idx.exactScore(query, idx.vectors[n.ItemId])})
+		}
+		return exact.sorted()
+	}
+	if len(result) > k {
+		result = result[:k]
+	}
+	for i := range result {
+		result[i].ItemId = idx.ids[result[i].ItemId]
+		if idx.metric == Euclidean {
+			result[i].Score = -math32.Sqrt(-result[i].Score)
+		}
+	}
+	return result
+}
+
+func (idx *IVFPQIndex) coarseScore(query, c []float32) float32 {
+	if idx.metric == Euclidean {
+		return -squaredDistance(query, c)
+	}
+	return dot(query, c)
+}
+
+func (idx *IVFPQIndex) exactScore(query, v []float32) float32 {
+	if idx.metric == Euclidean {
+		return -math32.Sqrt(squaredDistance(query, v))
+	}
+	return dot(query, v)
+}
+
+// MemoryBytes is the approximate memory of the index data
+func (idx *IVFPQIndex) MemoryBytes() int {
+	bytes := 4 * idx.dim * len(idx.coarse)
+	for _, codebook := range idx.codebooks {
+		for _, c := range codebook {
+			bytes += 4 * len(c)
+		}
+	}
+	return bytes + 8*len(idx.ids) + len(idx.codes) + 4*idx.dim*len(idx.vectors) + 8*len(idx.starts)
+}
+
+// MemoryBytes is the approximate memory of the index data
+func (idx *FlatIndex) MemoryBytes() int {
+	return 8*len(idx.ids) + 4*idx.dim*len(idx.vectors)
+}
+
+// RecallAt is the mean share of the exact k nearest neighbors of the queries
+// found by approx, to measure the recall traded for the memory
+func RecallAt(exact, approx Index, queries [][]float32, k int) float64 {
+	var found, total int
+	for _, q := range queries {
+		truth := make(map[int]struct{}, k)
+		for _, n := range exact.Search(q, k) {
+			truth[n.ItemId] = struct{}{}
+		}
+		for _, n := range approx.Search(q, k) {
+			if _, ok := truth[n.ItemId]; ok {
+				found++
+			}
+		}
+		total += len(truth)
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(found) / float64(total)
+}
+
+func sub(a, b []float32) []float32 {
+	d := make([]float32, len(a))
+	for i := range a {
+		d[i] = a[i] - b[i]
+	}
+	return d
+}
+
+// sample returns at most n of vectors picked at random
+func sample(rnd *rand.Rand, vectors [][]float32, n int) [][]float32 {
+	if len(vectors) <= n {
+		return vectors
+	}
+	picked := make([][]float32, n)
+	for i, j := range rnd.Perm(len(vectors))[:n] {
+		picked[i] = vectors[j]
+	}
+	return picked
+}
+
+func nearest(centroids [][]float32, v []float32) (best int) {
+	bestDist := float32(math.MaxFloat32)
+	for i, c := range centroids {
+		if d := squaredDistance(v, c); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return
+}
+
+// kmeans clusters data into at most k centroids by iterations of Lloyd's
+// algorithm, initialized by k distinct random points. An empty cluster keeps
+// its centroid.
+func kmeans(rnd *rand.Rand, data [][]float32, k, iterations int) [][]float32 {
+	if k > len(data) {
+		k = len(data)
+	}
+	dim := len(data[0])
+	centroids := make([][]float32, k)
+	for i, j := range rnd.Perm(len(data))[:k] {
+		centroids[i] = append([]float32(nil), data[j]...)
+	}
+	assignments := make([]int, len(data))
+	sums := make([][]float64, k)
+	counts := make([]int, k)
+	for it := 0; it < iterations; it++ {
+		changed := it == 0
+		for i, v := range data {
+			if c := nearest(centroids, v); c != assignments[i] {
+				assignments[i], changed = c, true
+			}
+		}
+		if !changed {
+			break
+		}
+		for c := range sums {
+			sums[c] = make([]float64, dim)
+			counts[c] = 0
+		}
+		for i, v := range data {
+			c := assignments[i]
+			counts[c]++
+			for j, x := range v {
+				sums[c][j] += float64(x)
+			}
+		}
+		for c := range centroids {
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range centroids[c] {
+				centroids[c][j] = float32(sums[c][j] / float64(counts[c]))
+			}
+		}
+	}
+	return centroids
+}
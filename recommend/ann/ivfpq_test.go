@@ -0,0 +1,82 @@
+package ann
+
+import (
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// clustered returns n vectors of dim around clusters random centers
+func clustered(rnd *rand.Rand, n, dim, clusters int) [][]float32 {
+	centers := make([][]float32, clusters)
+	for i := range centers {
+		centers[i] = make([]float32, dim)
+		for j := range centers[i] {
+			centers[i][j] = float32(rnd.NormFloat64() * 3)
+		}
+	}
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		c := centers[rnd.Intn(clusters)]
+		vectors[i] = make([]float32, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = c[j] + float32(rnd.NormFloat64())
+		}
+	}
+	return vectors
+}
+
+func TestIVFPQIndex(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	vectors := clustered(rnd, 3000, 16, 20)
+	ids := make([]int, len(vectors))
+	for i := range ids {
+		ids[i] = i + 1
+	}
+	queries := clustered(rnd, 50, 16, 20)
+
+	for _, metric := range []Metric{Euclidean, Dot, Cosine} {
+		Convey("compressed index by "+string(metric), t, func() {
+			exact, err := NewFlatIndex(metric, ids, vectors)
+			So(err, ShouldBeNil)
+			opts := IVFPQOptions{Lists: 30, Probes: 10, Subspaces: 8, CodebookSize: 64, Seed: 1}
+			compressed, err := NewIVFPQIndex(metric, ids, vectors, opts)
+			So(err, ShouldBeNil)
+			So(compressed.Len(), ShouldEqual, len(ids))
+			opts.Rerank = 100
+			reranked, err := NewIVFPQIndex(metric, ids, vectors, opts)
+			So(err, ShouldBeNil)
+
+			// the codes are 8 bytes of a 64 bytes vector
+			So(compressed.MemoryBytes(), ShouldBeLessThan, exact.MemoryBytes()/2)
+			So(reranked.MemoryBytes(), ShouldBeGreaterThan, exact.MemoryBytes())
+
+			recall := RecallAt(exact, compressed, queries, 10)
+			rerankRecall := RecallAt(exact, reranked, queries, 10)
+			So(recall, ShouldBeGreaterThan, 0.6)
+			So(rerankRecall, ShouldBeGreaterThan, 0.9)
+			So(rerankRecall, ShouldBeGreaterThanOrEqualTo, recall)
+
+			top := reranked.Search(queries[0], 5)
+			So(top, ShouldHaveLength, 5)
+			So(top[0].Score, ShouldAlmostEqual, Similarity(metric, queries[0], vectors[top[0].ItemId-1]), 1e-4)
+			So(reranked.Search(queries[0][:3], 5), ShouldBeNil)
+		})
+	}
+
+	Convey("options", t, func() {
+		idx, err := NewIVFPQIndex(Dot, ids[:10], vectors[:10], IVFPQOptions{Subspaces: 100})
+		So(err, ShouldBeNil)
+		opts := idx.Options()
+		So(opts.Lists, ShouldEqual, 3)
+		So(opts.Probes, ShouldEqual, 3)
+		So(opts.Subspaces, ShouldEqual, 16)
+		So(idx.Search(vectors[0], 20), ShouldHaveLength, 10)
+
+		_, err = NewIVFPQIndex(Dot, ids, vectors, IVFPQOptions{CodebookSize: 1000})
+		So(err, ShouldNotBeNil)
+		_, err = NewIVFPQIndex(Dot, nil, nil, IVFPQOptions{})
+		So(err, ShouldNotBeNil)
+	})
+}
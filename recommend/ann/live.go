@@ -0,0 +1,199 @@
+package ann
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrBuilding is returned by a rebuild while the last one is in progress
+var ErrBuilding = fmt.Errorf("index is building")
+
+// BuildStats is the metrics of the background index builds
+type BuildStats struct {
+	Building bool `json:"building"`
+	// Progress is the share done of the build in progress, in [0, 1]
+	Progress float64 `json:"progress"`
+	// LastBuild is the time the serving index was swapped in, the zero
+	// time if never
+	LastBuild time.Time `json:"lastBuild"`
+	// LastBuildAge is the seconds since LastBuild, -1 if never built
+	LastBuildAge float64 `json:"lastBuildAge"`
+	// LastDuration is the seconds the last successful build took
+	LastDuration float64 `json:"lastDuration"`
+	Builds       int64   `json:"builds"`
+	Failures     int64   `json:"failures"`
+	LastError    string  `json:"lastError,omitempty"`
+	// Size is the items of the serving index
+	Size int `json:"size"`
+}
+
+// BuildTracker tracks the builds of an index rebuilt in the background, one
+// build at a time
+type BuildTracker struct {
+	building int32
+	// progress is the float64 bits of the progress
+	progress uint64
+
+	mu    sync.Mutex
+	stats BuildStats
+	start time.Time
+}
+
+// Start marks a build started, false if one is in progress. progress reports
+// the share done of the build.
+func (t *BuildTracker) Start() (progress func(done float64), ok bool) {
+	if !atomic.CompareAndSwapInt32(&t.building, 0, 1) {
+		return nil, false
+	}
+	atomic.StoreUint64(&t.progress, 0)
+	t.mu.Lock()
+	t.start = time.Now()
+	t.mu.Unlock()
+	return func(done float64) {
+		atomic.StoreUint64(&t.progress, math.Float64bits(math.Max(0, math.Min(1, done))))
+	}, true
+}
+
+// Done marks the build started by Start finished, the index of size items
+// is swapped in if err is nil
+func (t *BuildTracker) Done(err error, size int) {
+	t.mu.Lock()
+	now := time.Now()
+	if err != nil {
+		t.stats.Failures++
+		t.stats.LastError = err.Error()
+	} else {
+		t.stats.Builds++
+		t.stats.LastError = ""
+		t.stats.LastBuild = now
+		t.stats.LastDuration = now.Sub(t.start).Seconds()
+		t.stats.Size = size
+	}
+	t.mu.Unlock()
+	atomic.StoreInt32(&t.building, 0)
+}
+
+// Stats returns the build metrics
+func (t *BuildTracker) Stats() BuildStats {
+	t.mu.Lock()
+	stats := t.stats
+	t.mu.Unlock()
+	stats.Building = atomic.LoadInt32(&t.building) == 1
+	if stats.Building {
+		stats.Progress = math.Float64frombits(atomic.LoadUint64(&t.progress))
+	}
+	stats.LastBuildAge = -1
+	if !stats.LastBuild.IsZero() {
+		stats.LastBuildAge = time.Since(stats.LastBuild).Seconds()
+	}
+	return stats
+}
+
+// Builder builds an index of the latest embeddings, reporting the share done
+// by progress
+type Builder func(ctx context.Context, progress func(done float64)) (Index, error)
+
+// LiveIndex is the Index rebuilt in the background, the searches are served
+// by the last built index until the new one is swapped in atomically
+type LiveIndex struct {
+	BuildTracker
+	build Builder
+
+	mu    sync.RWMutex
+	index Index
+}
+
+// NewLiveIndex creates the LiveIndex of build, it serves no result until
+// the first Rebuild
+func NewLiveIndex(build Builder) *LiveIndex {
+	return &LiveIndex{build: build}
+}
+
+// Rebuild builds the index and swaps it in, ErrBuilding if a build is in
+// progress. On error the serving index is kept.
+func (l *LiveIndex) Rebuild(ctx context.Context) (err error) {
+	progress, ok := l.Start()
+	if !ok {
+		return ErrBuilding
+	}
+	var index Index
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("build index panic: %v", r)
+		}
+		size := 0
+		if err == nil {
+			size = index.Len()
+		}
+		l.Done(err, size)
+	}()
+	if index, err = l.build(ctx, progress); err != nil {
+		return
+	}
+	if index == nil {
+		return fmt.Errorf("built no index")
+	}
+	l.mu.Lock()
+	l.index = index
+	l.mu.Unlock()
+	return
+}
+
+// RebuildEvery rebuilds the index every interval until ctx is done
+func (l *LiveIndex) RebuildEvery(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := l.Rebuild(ctx); err != nil {
+					log.Errorf("rebuild ann index error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Index returns the serving index, nil if never built
+func (l *LiveIndex) Index() Index {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.index
+}
+
+func (l *LiveIndex) Search(query []float32, k int) []Neighbor {
+	if index := l.Index(); index != nil {
+		return index.Search(query, k)
+	}
+	return nil
+}
+
+func (l *LiveIndex) Metric() Metric {
+	if index := l.Index(); index != nil {
+		return index.Metric()
+	}
+	return ""
+}
+
+func (l *LiveIndex) Dim() int {
+	if index := l.Index(); index != nil {
+		return index.Dim()
+	}
+	return 0
+}
+
+func (l *LiveIndex) Len() int {
+	if index := l.Index(); index != nil {
+		return index.Len()
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package ann
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLiveIndex(t *testing.T) {
+	ctx := context.Background()
+	Convey("rebuild in the background and swap", t, func() {
+		var (
+			version = 0
+			started = make(chan struct{})
+			release = make(chan error)
+		)
+		live := NewLiveIndex(func(ctx context.Context, progress func(float64)) (Index, error) {
+			version++
+			started <- struct{}{}
+			progress(0.5)
+			if err := <-release; err != nil {
+				return nil, err
+			}
+			ids := make([]int, version)
+			vectors := make([][]float32, version)
+			for i := range ids {
+				ids[i], vectors[i] = i+1, []float32{float32(i + 1)}
+			}
+			return NewFlatIndex(Dot, ids, vectors)
+		})
+		So(live.Search([]float32{1}, 1), ShouldBeNil)
+		So(live.Stats().LastBuildAge, ShouldEqual, -1)
+
+		rebuild := func() chan error {
+			done := make(chan error, 1)
+			go func() { done <- live.Rebuild(ctx) }()
+			<-started
+			return done
+		}
+		done := rebuild()
+		release <- nil
+		So(<-done, ShouldBeNil)
+		So(live.Len(), ShouldEqual, 1)
+
+		// the old index serves during the build
+		done = rebuild()
+		stats := live.Stats()
+		So(stats.Building, ShouldBeTrue)
+		So(stats.Progress, ShouldEqual, 0.5)
+		So(live.Rebuild(ctx), ShouldEqual, ErrBuilding)
+		So(live.Search([]float32{1}, 5), ShouldHaveLength, 1)
+		release <- nil
+		So(<-done, ShouldBeNil)
+		So(live.Search([]float32{1}, 5), ShouldHaveLength, 2)
+
+		// the failed build keeps the index
+		done = rebuild()
+		release <- fmt.Errorf("embeddings unavailable")
+		So(<-done, ShouldNotBeNil)
+		So(live.Len(), ShouldEqual, 2)
+
+		stats = live.Stats()
+		So(stats.Building, ShouldBeFalse)
+		So(stats.Builds, ShouldEqual, 2)
+		So(stats.Failures, ShouldEqual, 1)
+		So(stats.LastError, ShouldEqual, "embeddings unavailable")
+		So(stats.Size, ShouldEqual, 2)
+		So(stats.LastBuildAge, ShouldBeBetween, 0, time.Minute.Seconds())
+	})
+}
@@ -1,20 +1,78 @@
 package recommend
 
 import (
+	"context"
 	"embed"
 	"github.com/gin-gonic/gin"
 	"io/fs"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type RecApiRequest struct {
 	UserId     int   `json:"userId"`
 	ItemIdList []int `json:"itemIdList"`
+	// Breakdown returns the feature group contributions along with the scores
+	Breakdown bool `json:"breakdown"`
+	// PageSize > 0 returns the first page of the ranked items, the next
+	// pages are by Cursor, see WithPagination
+	PageSize int `json:"pageSize"`
+	// Cursor is the RecApiResponse.NextCursor of the previous page
+	Cursor string `json:"cursor"`
+	// SessionId and Surface select the session and its SessionPolicy, see
+	// WithSessionExclusion. Surface also selects the Surface served, see
+	// WithSurfaces. SessionId is of SessionIdHeader if empty.
+	SessionId string `json:"sessionId"`
+	Surface   string `json:"surface"`
+	// SeenItemIds is the items seen in the session reported by the client
+	SeenItemIds []int `json:"seenItemIds"`
+	// Explain returns the explanations of the items, see WithExplanations
+	Explain bool `json:"explain"`
+	// DeviceProfile is the user profile of the device, the features of the
+	// user are assembled of it instead of the server, see WithPrivacyMode
+	DeviceProfile *DeviceProfile `json:"deviceProfile"`
+	// Query is the search query of which ItemIdList are the results, the
+	// FeatureFuncs get it by QueryFromContext, see RegisterQueryFeatures
+	Query string `json:"query"`
+	// DeviceClass, Locale and ArmOverrides take precedence over the
+	// DeviceClassHeader, LocaleHeader and ArmOverrideHeader, see
+	// RequestContext
+	DeviceClass  string            `json:"deviceClass"`
+	Locale       string            `json:"locale"`
+	ArmOverrides map[string]string `json:"armOverrides"`
 }
 
 type RecApiResponse struct {
 	ItemScoreList []ItemScore `json:"itemScoreList"`
+	// Fallback is the reason if the items are not ranked by the model
+	Fallback string `json:"fallback,omitempty"`
+	// Partial is true if only part of the items are ranked in the budget,
+	// see WithStageBudget
+	Partial bool `json:"partial,omitempty"`
+	// Arm is the model arm of the user if the Predictor is a UserRouter, it
+	// should be logged with the feedback
+	Arm string `json:"arm,omitempty"`
+	// Filtered is the candidates removed by reason, by the PreFilter, as
+	// seen in the session or by the user feedback, see WithPreFilter,
+	// WithSessionExclusion and WithFeedback
+	Filtered map[string]int `json:"filtered,omitempty"`
+	// RequestId is the id of the ranked list of a paginated request, also
+	// the id of the audit record of the audited request and the
+	// counterfactual records of the logged one, see WithAuditLog and
+	// WithCounterfactualLog
+	RequestId string `json:"requestId,omitempty"`
+	// NextCursor fetches the next page, empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func (r *RecApiResponse) addFiltered(filtered map[string]int) {
+	for reason, n := range filtered {
+		if r.Filtered == nil {
+			r.Filtered = make(map[string]int)
+		}
+		r.Filtered[reason] += n
+	}
 }
 
 // StartHttpApi starts the http api for recommendation
@@ -24,8 +82,26 @@ type RecApiResponse struct {
 //	  --request POST \
 //	  --data '{"userId":107,"itemIdList":[1,2,39]}' \
 //	  http://localhost:8080/api/v1/recommend
-func StartHttpApi(predict Predictor, path string, addr string, efs *embed.FS) (err error) {
-	engine := gin.Default()
+//
+// opts enable the admission control, e.g. WithRateLimit and WithLoadShedding,
+// and the warm-up by WithWarmUp. The probes are served at /healthz and
+// /readyz, see addHealthRoutes. If the ranking fails the fallback ranking is
+// served with RecApiResponse.Fallback set, the fallback rate is served at
+// /api/v1/fallback/stats. The online learning metrics are served at
+// /api/v1/online/stats by WithStreamingMetrics. The candidates are filtered
+// before the ranking by WithPreFilter, the ranked items are reranked by
+// WithReRankers and served by pages by WithPagination. The items seen in the
+// session are excluded by WithSessionExclusion. The user feedback api is
+// served by WithFeedback. The item metadata is attached to the items served
+// by WithItemMetadata, and the explanations by WithExplanations. The user
+// profiles are kept on the devices by WithPrivacyMode. The decisions of the
+// requests are recorded by WithAuditLog, and the requests to replay by
+// WithRequestRecording. The frontend of efs is not served if efs is nil.
+func StartHttpApi(predict Predictor, path string, addr string, efs *embed.FS, opts ...ApiOption) (err error) {
+	var (
+		engine = gin.Default()
+		conf   = newApiConfig(opts)
+	)
 	engine.GET("/service/useritems", func(c *gin.Context) {
 		querys := c.Request.URL.Query()
 
@@ -103,51 +179,461 @@ func StartHttpApi(predict Predictor, path string, addr string, efs *embed.FS) (e
 		return
 	})
 
-	engine.Any(path, func(c *gin.Context) {
+	// Debug the features and score of a user item pair by:
+	//
+	//	curl "http://localhost:8080/api/v1/debug/feature?userId=107&itemId=39"
+	engine.GET("/api/v1/debug/feature", conf.handlers(debugFeatureHandler(single(predict)))...)
+	engine.Any(path, conf.handlers(recommendHandler(single(predict), conf))...)
+	addFeedbackRoutes(engine, single(predict), conf)
+	addAuditRoutes(engine, single(predict), conf)
+	// Query the data lineage of the serving model by:
+	//
+	//	curl "http://localhost:8080/api/v1/model/lineage"
+	engine.GET("/api/v1/model/lineage", lineageHandler(single(predict)))
+	engine.GET("/api/v1/fallback/stats", func(c *gin.Context) {
+		c.JSON(200, conf.fallback.Stats())
+	})
+	addOnlineRoutes(engine, conf)
+	addHealthRoutes(engine, newHealth(conf, func() []servedPredictor {
+		return []servedPredictor{{ctx: context.Background(), predictor: predict}}
+	}))
+	if efs != nil {
+		var assetsFs, rootFs fs.FS
+		assetsFs, err = fs.Sub(efs, "frontend/website/assets")
+		if err != nil {
+			panic(err)
+		}
+		rootFs, err = fs.Sub(efs, "frontend/website")
+		if err != nil {
+			panic(err)
+		}
+
+		engine.StaticFileFS("/favicon.ico", "favicon.ico", http.FS(rootFs))
+		engine.StaticFS("/assets", http.FS(assetsFs))
+		engine.Any("/", func(c *gin.Context) {
+			c.FileFromFS("", http.FS(rootFs))
+		})
+		engine.GET("index.html", func(c *gin.Context) {
+			file, _ := efs.ReadFile("frontend/website/index.html")
+			c.Data(http.StatusOK, "text/html", file)
+		})
+	}
+
+	return engine.Run(addr)
+}
+
+// StartTenantHttpApi starts the recommendation and feature debug http api
+// serving all the tenants, the tenant is selected by ApiKeyHeader or
+// TenantHeader:
+//
+//	curl --header "Content-Type: application/json" \
+//	  --header "X-Tenant: movielens" \
+//	  --request POST \
+//	  --data '{"userId":107,"itemIdList":[1,2,39]}' \
+//	  http://localhost:8080/api/v1/recommend
+//
+// The tenant stats are served at /api/v1/tenants/stats. See StartHttpApi for
+// opts.
+func StartTenantHttpApi(tenants *TenantRegistry, path string, addr string, opts ...ApiOption) (err error) {
+	return newTenantEngine(tenants, path, opts...).Run(addr)
+}
+
+func newTenantEngine(tenants *TenantRegistry, path string, opts ...ApiOption) *gin.Engine {
+	var (
+		engine = gin.Default()
+		conf   = newApiConfig(opts)
+	)
+	resolve := func(c *gin.Context) (context.Context, Predictor, error) {
+		t, err := tenants.Resolve(c.Request)
+		if err != nil {
+			return nil, nil, err
+		}
+		return WithTenant(c, t), t.Predictor, nil
+	}
+	// the buckets of the rate limiter are of the tenants, not of the raw
+	// api keys of the clients
+	conf.client = func(c *gin.Context) string {
+		t, err := tenants.Resolve(c.Request)
+		if err != nil {
+			return anonymousClient
+		}
+		return t.Name
+	}
+	engine.GET("/api/v1/debug/feature", conf.handlers(debugFeatureHandler(resolve))...)
+	engine.GET("/api/v1/tenants/stats", func(c *gin.Context) {
+		c.JSON(200, tenants.Stats())
+	})
+	engine.Any(path, conf.handlers(recommendHandler(resolve, conf))...)
+	addFeedbackRoutes(engine, resolve, conf)
+	addAuditRoutes(engine, resolve, conf)
+	engine.GET("/api/v1/model/lineage", lineageHandler(resolve))
+	engine.GET("/api/v1/fallback/stats", func(c *gin.Context) {
+		c.JSON(200, conf.fallback.Stats())
+	})
+	addOnlineRoutes(engine, conf)
+	addHealthRoutes(engine, newHealth(conf, func() (predictors []servedPredictor) {
+		for _, t := range tenants.Tenants() {
+			predictors = append(predictors, servedPredictor{
+				name:      t.Name,
+				ctx:       WithTenant(context.Background(), t),
+				predictor: t.Predictor,
+			})
+		}
+		return
+	}))
+	return engine
+}
+
+// predictorResolver returns the ctx and Predictor serving the request
+type predictorResolver func(c *gin.Context) (context.Context, Predictor, error)
+
+func single(predict Predictor) predictorResolver {
+	return func(c *gin.Context) (context.Context, Predictor, error) {
+		return c, predict, nil
+	}
+}
+
+// addFeedbackRoutes serves the feedback api, or the device events api in the
+// privacy mode, if configured
+func addFeedbackRoutes(engine *gin.Engine, resolve predictorResolver, conf *apiConfig) {
+	addPrivacyRoutes(engine, conf)
+	if conf.feedback == nil || conf.privacy != nil {
+		return
+	}
+	engine.POST("/api/v1/feedback", conf.handlers(feedbackHandler(resolve, conf.feedback))...)
+}
+
+// addOnlineRoutes serves the streaming metrics, the filter stats, the
+// metadata cache stats and the index build stats if configured
+func addOnlineRoutes(engine *gin.Engine, conf *apiConfig) {
+	if conf.indexes != nil {
+		// Query the progress and the last build age of the live indexes by:
+		//
+		//	curl "http://localhost:8080/api/v1/index/stats"
+		engine.GET("/api/v1/index/stats", func(c *gin.Context) {
+			c.JSON(200, conf.indexStats())
+		})
+	}
+	if conf.metadata != nil {
+		// Query the hit rate of the item metadata cache by:
+		//
+		//	curl "http://localhost:8080/api/v1/metadata/stats"
+		engine.GET("/api/v1/metadata/stats", func(c *gin.Context) {
+			c.JSON(200, conf.metadata.Stats())
+		})
+	}
+	if conf.prefilter != nil {
+		// Query the filtered candidates by reason of the empty results by:
+		//
+		//	curl "http://localhost:8080/api/v1/filter/stats"
+		engine.GET("/api/v1/filter/stats", func(c *gin.Context) {
+			c.JSON(200, conf.prefilter.Stats())
+		})
+	}
+	if conf.streaming == nil {
+		return
+	}
+	// Query the progressive validation metrics of the online model by:
+	//
+	//	curl "http://localhost:8080/api/v1/online/stats"
+	engine.GET("/api/v1/online/stats", func(c *gin.Context) {
+		c.JSON(200, conf.streaming.Stats())
+	})
+}
+
+func recordTenant(ctx context.Context, err error) {
+	if t := TenantFromContext(ctx); t != nil {
+		t.record(err)
+	}
+}
+
+func lineageHandler(resolve predictorResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, predict, err := resolve(c)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		var manifest *Manifest
+		if provider, ok := predict.(ManifestProvider); ok {
+			manifest = provider.Manifest()
+		}
+		if manifest == nil {
+			c.JSON(404, gin.H{"error": "no lineage of the serving model"})
+			return
+		}
+		c.JSON(200, manifest)
+	}
+}
+
+func debugFeatureHandler(resolve predictorResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			sampleKey = Sample{Timestamp: time.Now().Unix()}
+			err       error
+		)
+		ctx, predict, err := resolve(c)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		if sampleKey.UserId, err = strconv.Atoi(c.Query("userId")); err != nil {
+			c.JSON(400, gin.H{"error": "invalid userId"})
+			return
+		}
+		if sampleKey.ItemId, err = strconv.Atoi(c.Query("itemId")); err != nil {
+			c.JSON(400, gin.H{"error": "invalid itemId"})
+			return
+		}
+		if ts := c.Query("timestamp"); ts != "" {
+			if sampleKey.Timestamp, err = strconv.ParseInt(ts, 10, 64); err != nil {
+				c.JSON(400, gin.H{"error": "invalid timestamp"})
+				return
+			}
+		}
+		if router, ok := predict.(UserRouter); ok {
+			predict, _ = router.RouteUser(ctx, sampleKey.UserId)
+		}
+		result, err := DebugFeature(ctx, predict, sampleKey)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, result)
+	}
+}
+
+func recommendHandler(resolve predictorResolver, conf *apiConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		// bind request to RecApiRequest
 		var (
 			req RecApiRequest
 		)
+		ctx, predict, err := resolve(c)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
 		if err := c.ShouldBind(&req); err != nil {
 			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
-		if len(req.ItemIdList) == 0 {
-			// todo: some default recall algorithm
-			c.JSON(400, gin.H{"error": "itemIdList is empty"})
+		rc, err := ParseRequestContext(c.Request.Header)
+		if err == nil {
+			err = rc.merge(&req)
+		}
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
-		} else {
-			resp := RecApiResponse{}
-			// get features in request from gin Context
-			scores, err := Rank(c, predict, req.UserId, req.ItemIdList)
+		}
+		if !conf.armOverrides {
+			rc.ArmOverrides = nil
+		}
+		ctx = WithRequestContext(ctx, rc)
+		if conf.privacy != nil {
+			if req.DeviceProfile == nil {
+				c.JSON(400, gin.H{"error": "deviceProfile is required in privacy mode"})
+				return
+			}
+			if req.Cursor != "" || req.PageSize > 0 {
+				c.JSON(400, gin.H{"error": "pagination is not allowed in privacy mode"})
+				return
+			}
+		}
+		if req.DeviceProfile != nil {
+			ctx = WithDeviceProfile(ctx, req.DeviceProfile)
+		}
+		if req.Query != "" {
+			ctx = WithQuery(ctx, req.Query)
+		}
+		if req.Cursor != "" || req.PageSize > 0 {
+			if conf.pages == nil {
+				c.JSON(400, gin.H{"error": "pagination is not enabled"})
+				return
+			}
+		}
+		if req.Explain && conf.explainer == nil {
+			c.JSON(400, gin.H{"error": "explanations are not enabled"})
+			return
+		}
+		surface, err := conf.surface(req.Surface)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		// base recalls the candidates of the surface model not a Recaller
+		base := predict
+		if surface != nil && surface.Predictor != nil {
+			predict = surface.Predictor
+		}
+		var policy SessionPolicy
+		if conf.sessions != nil && req.SessionId != "" {
+			policy = conf.sessions.policy(req.Surface)
+			if conf.privacy != nil {
+				// only the items seen reported by the device are excluded
+				policy.Track = false
+				req.SessionId, rc.SessionId = "", ""
+			}
+		}
+		// serve writes resp with the item metadata and the explanations, and
+		// tracks the items returned in the session
+		serve := func(resp RecApiResponse) {
+			if req.Explain {
+				resp.ItemScoreList = conf.explainer.explain(ctx, predict, req.UserId, resp.ItemScoreList, conf.metadata)
+			}
+			if conf.metadata != nil {
+				resp.ItemScoreList = conf.metadata.enrich(ctx, resp.ItemScoreList)
+			}
+			if policy.Track {
+				conf.sessions.track(ctx, req.SessionId, resp.ItemScoreList)
+			}
+			c.JSON(200, resp)
+		}
+		if req.Cursor != "" {
+			resp, err := conf.pages.next(ctx, req.UserId, req.Cursor, req.PageSize)
 			if err != nil {
+				c.JSON(404, gin.H{"error": err.Error()})
+				return
+			}
+			recordTenant(ctx, nil)
+			conf.audit.recordPage(ctx, &req, resp)
+			serve(resp)
+			return
+		}
+		ctx, trail := conf.audit.startAudit(ctx)
+		ctx, unscored := conf.counterfactual.startTrail(ctx)
+		var recording *recordingTrail
+		if conf.privacy == nil {
+			ctx, recording = conf.recorder.startRecording(ctx)
+		}
+		var candidates []int
+		// reply serves resp, the first page of it if paginated, and records
+		// it if audited or recorded and the candidates unscored if logged
+		reply := func(resp RecApiResponse) {
+			var err error
+			if resp.ItemScoreList, err = conf.reRank(ctx, req.UserId, resp.ItemScoreList); err != nil {
 				c.JSON(500, gin.H{"error": err.Error()})
 				return
 			}
-			resp.ItemScoreList = scores
-			c.JSON(200, resp)
+			if surface != nil {
+				if resp.ItemScoreList, err = surface.finish(ctx, req.UserId, resp.ItemScoreList); err != nil {
+					c.JSON(500, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			ranking := resp
+			if req.PageSize > 0 {
+				if resp, err = conf.pages.first(ctx, req.UserId, req.PageSize, resp); err != nil {
+					c.JSON(500, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			if resp.RequestId == "" && (trail != nil || recording != nil || unscored.hasUnscored()) {
+				if resp.RequestId, err = newRequestId(); err != nil {
+					c.JSON(500, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			if unscored != nil {
+				conf.counterfactual.record(ctx, unscored, &req, predict, candidates, resp)
+			}
+			ranking.RequestId = resp.RequestId
+			if recording != nil {
+				conf.recorder.record(ctx, recording, &req, rc, predict, candidates, ranking)
+			}
+			if trail != nil {
+				if req.PageSize > 0 {
+					// the pages are of the sorted list
+					ranking.ItemScoreList = append([]ItemScore(nil), ranking.ItemScoreList...)
+					sortItemScores(ranking.ItemScoreList)
+				}
+				conf.audit.record(ctx, trail, &req, predict, len(candidates), ranking, len(resp.ItemScoreList))
+			}
+			serve(resp)
+		}
+		resp := RecApiResponse{}
+		if router, ok := predict.(UserRouter); ok {
+			predict, resp.Arm = router.RouteUser(ctx, req.UserId)
+		}
+		if len(req.ItemIdList) == 0 {
+			var b StageBudget
+			if conf.budget != nil {
+				b = *conf.budget
+			}
+			recaller, ok := predict.(Recaller)
+			if !ok {
+				recaller, ok = base.(Recaller)
+			}
+			if surface != nil && len(surface.Recallers) != 0 {
+				req.ItemIdList, err = surface.recall(ctx, req.UserId, b)
+			} else if ok {
+				req.ItemIdList, err = RecallWithBudget(ctx, recaller, req.UserId, b)
+			} else {
+				c.JSON(400, gin.H{"error": "itemIdList is empty"})
+				return
+			}
+			if err != nil {
+				recordTenant(ctx, err)
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		candidates = req.ItemIdList
+		if policy.Exclude {
+			var excluded int
+			if req.ItemIdList, excluded = conf.sessions.exclude(ctx, req.SessionId, req.SeenItemIds, req.ItemIdList); excluded > 0 {
+				resp.Filtered = map[string]int{ReasonSeenInSession: excluded}
+			}
+		}
+		if conf.feedback != nil && conf.privacy == nil {
+			ctx = conf.feedback.withFeedback(ctx)
+			var filtered map[string]int
+			if req.ItemIdList, filtered, err = conf.feedback.filter(ctx, predict, req.UserId, req.ItemIdList); err != nil {
+				recordTenant(ctx, err)
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			resp.addFiltered(filtered)
+		}
+		if conf.prefilter != nil {
+			var filtered map[string]int
+			if req.ItemIdList, filtered, err = conf.prefilter.Filter(ctx, predict, req.UserId, req.ItemIdList); err != nil {
+				recordTenant(ctx, err)
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			resp.addFiltered(filtered)
+		}
+		if len(req.ItemIdList) == 0 && len(resp.Filtered) != 0 {
+			resp.ItemScoreList = []ItemScore{}
+			recordTenant(ctx, nil)
+			reply(resp)
 			return
 		}
-	})
-	var assetsFs, rootFs fs.FS
-	assetsFs, err = fs.Sub(efs, "frontend/website/assets")
-	if err != nil {
-		panic(err)
-	}
-	rootFs, err = fs.Sub(efs, "frontend/website")
-	if err != nil {
-		panic(err)
+		if conf.shedder != nil && conf.shedder.Shed() {
+			resp.ItemScoreList = conf.fallback.Shed(ctx, predict, req.UserId, req.ItemIdList)
+			resp.Fallback = FallbackLoadShedding
+			recordTenant(ctx, nil)
+			reply(resp)
+			return
+		}
+		// get features in request from gin Context
+		var rank rankFunc = Rank
+		if req.Breakdown {
+			rank = RankWithBreakdown
+		} else if conf.budget != nil {
+			rank = func(ctx context.Context, recSys Predictor, userId int, itemIds []int) (itemScores []ItemScore, err error) {
+				itemScores, resp.Partial, err = RankWithBudget(ctx, recSys, userId, itemIds, *conf.budget)
+				return
+			}
+		}
+		start := time.Now()
+		scores, fallback, err := conf.fallback.Rank(ctx, rank, predict, req.UserId, req.ItemIdList)
+		if conf.shedder != nil {
+			conf.shedder.Observe(time.Since(start))
+		}
+		recordTenant(ctx, err)
+		resp.ItemScoreList, resp.Fallback = scores, fallback
+		reply(resp)
 	}
-
-	engine.StaticFileFS("/favicon.ico", "favicon.ico", http.FS(rootFs))
-	engine.StaticFS("/assets", http.FS(assetsFs))
-	engine.Any("/", func(c *gin.Context) {
-		c.FileFromFS("", http.FS(rootFs))
-	})
-	engine.GET("index.html", func(c *gin.Context) {
-		file, _ := efs.ReadFile("frontend/website/index.html")
-		c.Data(http.StatusOK, "text/html", file)
-	})
-
-	return engine.Run(addr)
 }
@@ -0,0 +1,31 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// AsOfUserFeaturer could be implemented by the feature provider whose user
+// features aggregate the events, e.g. click counts. GetUserFeatureAsOf returns
+// the features computed from the events strictly before ts, so the training
+// samples see the values of their own time instead of the current ones.
+// GetSample uses it for the training samples, the serving keeps using
+// GetUserFeature for the current values.
+type AsOfUserFeaturer interface {
+	GetUserFeatureAsOf(ctx context.Context, userId int, ts int64) (Tensor, error)
+}
+
+// AsOfItemFeaturer is the AsOfUserFeaturer of the item features, e.g. the
+// average rating of the item
+type AsOfItemFeaturer interface {
+	GetItemFeatureAsOf(ctx context.Context, itemId int, ts int64) (Tensor, error)
+}
+
+// featureKey is the cache key of the features of id, as of ts if asOf
+func featureKey(id int, ts int64, asOf bool) string {
+	if !asOf {
+		return strconv.Itoa(id)
+	}
+	return fmt.Sprintf("%d@%d", id, ts)
+}
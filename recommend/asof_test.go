@@ -0,0 +1,64 @@
+package recommend
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// clickRecSys has the user feature of the clicks before ts, all the samples
+// are the clicks of user 1
+type clickRecSys struct {
+	fakeProvider
+	clicks []int64
+}
+
+func (r *clickRecSys) SampleGenerator(context.Context) (<-chan Sample, error) {
+	ch := make(chan Sample, len(r.clicks))
+	for _, ts := range r.clicks {
+		ch <- Sample{UserId: 1, ItemId: 1, Label: 1, Timestamp: ts}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (r *clickRecSys) GetUserFeature(ctx context.Context, userId int) (Tensor, error) {
+	return r.GetUserFeatureAsOf(ctx, userId, 1<<62)
+}
+
+func (r *clickRecSys) GetUserFeatureAsOf(_ context.Context, _ int, ts int64) (Tensor, error) {
+	var cnt float32
+	for _, click := range r.clicks {
+		if click < ts {
+			cnt++
+		}
+	}
+	return Tensor{cnt}, nil
+}
+
+func TestAsOfFeature(t *testing.T) {
+	userCache, itemCache := UserFeatureCache, ItemFeatureCache
+	defer func() {
+		UserFeatureCache, ItemFeatureCache = userCache, itemCache
+	}()
+
+	Convey("training features as of the sample timestamp", t, func() {
+		UserFeatureCache, ItemFeatureCache = nil, nil
+		recSys := &clickRecSys{clicks: []int64{100, 200, 300}}
+		sample, err := GetSample(recSys, context.Background())
+		So(err, ShouldBeNil)
+		So(sample.Rows, ShouldEqual, 3)
+		counts := make(map[float32]bool)
+		for i := 0; i < sample.Rows; i++ {
+			counts[sample.X[i*sample.XCols+sample.Info.UserProfileRange[0]]] = true
+		}
+		So(counts, ShouldResemble, map[float32]bool{0: true, 1: true, 2: true})
+
+		// the current value is served, not the cached as of ones
+		vec, _, _, err := NewFeatureAssembler(recSys, UserFeatureCache, ItemFeatureCache).
+			Assemble(context.Background(), &Sample{UserId: 1, ItemId: 1, Timestamp: 150})
+		So(err, ShouldBeNil)
+		So(vec[0], ShouldEqual, 3)
+	})
+}
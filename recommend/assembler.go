@@ -0,0 +1,239 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/auxten/go-ctr/recommend/encoding"
+	"github.com/auxten/go-ctr/utils"
+	"github.com/karlseguin/ccache/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// FeatureRecord is the raw features of a sample before being assembled
+type FeatureRecord struct {
+	UserFeature   Tensor
+	UserBehaviors []float32 // ItemEmbDim * UserBehaviorLen item embeddings
+	ItemEmb       []float32 // ItemEmbDim
+	ItemFeature   Tensor    // non embedding item feature is treated as ctx feature
+	// Custom is the features of the registered FeatureFuncs, appended to the
+	// ctx feature
+	Custom Tensor
+}
+
+// Vector concatenates the record in SampleInfo order:
+//
+//	user profile | user behaviors | item embedding | item (ctx) feature | custom (ctx) feature
+func (r *FeatureRecord) Vector() []float32 {
+	return utils.ConcatSlice32(r.UserFeature, r.UserBehaviors, r.ItemEmb, r.ItemFeature, r.Custom)
+}
+
+// FeatureAssembler produces the sample vectors for both the training sample
+// builder and the serving scorer, so the two never skew.
+type FeatureAssembler struct {
+	provider         BasicFeatureProvider
+	userFeatureCache *ccache.Cache
+	itemFeatureCache *ccache.Cache
+	itemEmbeddingMap word2vec.EmbeddingMap32
+	// pointInTime gets the user behaviors before the sample timestamp
+	pointInTime bool
+	// asOf gets the features of AsOfUserFeaturer and AsOfItemFeaturer as of
+	// the sample timestamp
+	asOf bool
+	// normalizer of the served model, nil of the training
+	normalizer *Normalizer
+	// featureFuncs of the custom features, or the error of looking up the
+	// funcs of the served model
+	featureFuncs    []featureFunc
+	featureFuncsErr error
+	// biases appends the bias table rows of the sample ids, nil of none
+	biases *BiasBuckets
+}
+
+// NewFeatureAssembler creates the FeatureAssembler with the item embeddings
+// trained by Train. The caches could be nil to always query the provider.
+func NewFeatureAssembler(provider BasicFeatureProvider, userFeatureCache, itemFeatureCache *ccache.Cache) *FeatureAssembler {
+	return &FeatureAssembler{
+		provider:         provider,
+		userFeatureCache: userFeatureCache,
+		itemFeatureCache: itemFeatureCache,
+		itemEmbeddingMap: ItemEmbeddings(context.Background()),
+		featureFuncs:     registeredFeatureFuncs(),
+	}
+}
+
+// SampleInfo returns the layout of the vectors assembled for the user and
+// ctx features of the widths returned by Assemble
+func (a *FeatureAssembler) SampleInfo(userFeatureWidth, itemFeatureWidth int) (*SampleInfo, error) {
+	return NewSampleInfoBuilder().
+		UserProfile(userFeatureWidth).
+		UserBehavior(UserBehaviorLen, ItemEmbDim).
+		ItemFeature(ItemEmbDim).
+		CtxFeature(itemFeatureWidth).
+		Ids(a.idWidth()).
+		Build()
+}
+
+func (a *FeatureAssembler) idWidth() int {
+	if a.biases == nil {
+		return 0
+	}
+	return BiasColumns
+}
+
+// Assemble returns the sample vector of sampleKey, itemFeatureWidth is of
+// the item features and the custom features of the FeatureFuncs. The bias
+// table rows of the ids, if any, are the last columns.
+func (a *FeatureAssembler) Assemble(ctx context.Context, sampleKey *Sample) (vec []float32, userFeatureWidth int, itemFeatureWidth int, err error) {
+	var record *FeatureRecord
+	if record, err = a.Record(ctx, sampleKey); err != nil {
+		return
+	}
+	return a.vector(sampleKey, record)
+}
+
+// vector is the sample vector of the record of sampleKey, see Assemble
+func (a *FeatureAssembler) vector(sampleKey *Sample, record *FeatureRecord) (vec []float32, userFeatureWidth int, itemFeatureWidth int, err error) {
+	vec = record.Vector()
+	if a.biases != nil {
+		vec = append(vec, a.biases.columns(sampleKey)...)
+	}
+	if a.normalizer != nil {
+		if err = a.normalizer.Apply(vec); err != nil {
+			return
+		}
+	}
+	return vec, len(record.UserFeature), len(record.ItemFeature) + len(record.Custom), nil
+}
+
+type featureScopeKey struct{}
+
+// WithFeatureScope returns the ctx of which the cached features are keyed by
+// scope, so the models of different features, e.g. the members of an
+// ensemble, could share the feature caches of the process or tenant
+func WithFeatureScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, featureScopeKey{}, scope)
+}
+
+// scopedKey is the cache key of the features in the scope of ctx
+func scopedKey(ctx context.Context, key string) string {
+	if scope, _ := ctx.Value(featureScopeKey{}).(string); scope != "" {
+		return scope + "/" + key
+	}
+	return key
+}
+
+func fetchFeature(cache *ccache.Cache, key string, fetch func() (Tensor, error)) (feature Tensor, err error) {
+	if cache == nil {
+		return fetch()
+	}
+	item, err := cache.Fetch(key, time.Hour*24, func() (ci interface{}, err error) {
+		ci, err = fetch()
+		return
+	})
+	if err != nil {
+		return
+	}
+	return item.Value().(Tensor), nil
+}
+
+// Record fetches the raw features of sampleKey, or returns the recorded
+// ones of the request replayed, see Replay
+func (a *FeatureAssembler) Record(ctx context.Context, sampleKey *Sample) (record *FeatureRecord, err error) {
+	var (
+		zeroItemEmb       [ItemEmbDim]float32
+		zeroUserBehaviors [ItemEmbDim * UserBehaviorLen]float32
+	)
+	if record = replayedRecord(ctx, sampleKey); record != nil {
+		return
+	}
+	defer func() {
+		if err == nil {
+			recordFeatures(ctx, sampleKey, record)
+		}
+	}()
+	if a.featureFuncsErr != nil {
+		return nil, a.featureFuncsErr
+	}
+	record = &FeatureRecord{}
+	// the profile of the device is neither fetched nor cached
+	device := deviceProfile(ctx)
+	if device != nil {
+		record.UserFeature = device.UserFeature
+	} else {
+		userAsOf, userAsOfOk := a.provider.(AsOfUserFeaturer)
+		userAsOfOk = userAsOfOk && a.asOf
+		record.UserFeature, err = fetchFeature(a.userFeatureCache,
+			scopedKey(ctx, featureKey(sampleKey.UserId, sampleKey.Timestamp, userAsOfOk)), func() (Tensor, error) {
+				if userAsOfOk {
+					return userAsOf.GetUserFeatureAsOf(ctx, sampleKey.UserId, sampleKey.Timestamp)
+				}
+				return a.provider.GetUserFeature(ctx, sampleKey.UserId)
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	itemAsOf, itemAsOfOk := a.provider.(AsOfItemFeaturer)
+	itemAsOfOk = itemAsOfOk && a.asOf
+	record.ItemFeature, err = fetchFeature(a.itemFeatureCache,
+		scopedKey(ctx, featureKey(sampleKey.ItemId, sampleKey.Timestamp, itemAsOfOk)), func() (Tensor, error) {
+			if itemAsOfOk {
+				return itemAsOf.GetItemFeatureAsOf(ctx, sampleKey.ItemId, sampleKey.Timestamp)
+			}
+			return a.provider.GetItemFeature(ctx, sampleKey.ItemId)
+		})
+	if err != nil {
+		return nil, err
+	}
+	// the query of the sample is of the training, the serving ones are of the
+	// request ctx
+	funcCtx := ctx
+	if sampleKey.Query != "" {
+		funcCtx = WithQuery(ctx, sampleKey.Query)
+	}
+	if record.Custom, err = customFeatures(funcCtx, a.featureFuncs, sampleKey.UserId, sampleKey.ItemId); err != nil {
+		return nil, err
+	}
+
+	// if ItemEmbedding interface is implemented, use item embedding,
+	// 	else use zero embedding.
+	var ok bool
+	record.ItemEmb = zeroItemEmb[:]
+	record.UserBehaviors = zeroUserBehaviors[:]
+	if len(a.itemEmbeddingMap) != 0 {
+		if record.ItemEmb, ok = a.itemEmbeddingMap.Get(strconv.Itoa(sampleKey.ItemId)); !ok {
+			record.ItemEmb = zeroItemEmb[:]
+			log.Debugf("item embedding not found: %d, using zeros", sampleKey.ItemId)
+		}
+		// if ItemEmbedding and UserBehavior interface are both implemented,
+		// use itemSeq embeddings got from GetUserBehavior as user behavior,
+		//	else use zero embedding. The behaviors of the device profile are
+		//	used as is.
+		recSysUb, ok := a.provider.(UserBehavior)
+		if device != nil || ok {
+			var itemSeq []int
+			maxTs := sampleKey.Timestamp
+			if a.pointInTime && maxTs > 0 {
+				maxTs--
+			}
+			if device != nil {
+				itemSeq = device.Behaviors
+			} else {
+				itemSeq, err = recSysUb.GetUserBehavior(ctx, sampleKey.UserId, UserBehaviorLen, -1, maxTs)
+				if err != nil {
+					return nil, fmt.Errorf("get user behavior error: %v", err)
+				}
+				itemSeq = feedbackBehavior(ctx, sampleKey.UserId, itemSeq)
+			}
+			//query items embedding, fill them into user behavior
+			record.UserBehaviors = encoding.BehaviorTensor(itemSeq, func(itemId int) ([]float32, bool) {
+				return a.itemEmbeddingMap.Get(strconv.Itoa(itemId))
+			})
+		}
+	}
+	return
+}
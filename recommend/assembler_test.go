@@ -0,0 +1,75 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/karlseguin/ccache/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeProvider struct{}
+
+func (p *fakeProvider) GetUserFeature(_ context.Context, userId int) (Tensor, error) {
+	if userId < 0 {
+		return nil, fmt.Errorf("user %d not found", userId)
+	}
+	return Tensor{float32(userId), 1}, nil
+}
+
+func (p *fakeProvider) GetItemFeature(_ context.Context, itemId int) (Tensor, error) {
+	return Tensor{float32(itemId)}, nil
+}
+
+func (p *fakeProvider) GetUserBehavior(_ context.Context, _ int, _ int64, _ int64, _ int64) ([]int, error) {
+	return []int{2, 3}, nil
+}
+
+func TestFeatureAssembler(t *testing.T) {
+	emb := func(v float32) []float32 {
+		e := make([]float32, ItemEmbDim)
+		for i := range e {
+			e[i] = v
+		}
+		return e
+	}
+	itemEmbeddingMap = word2vec.EmbeddingMap32{"1": emb(1), "2": emb(2)}
+	defer func() { itemEmbeddingMap = nil }()
+
+	Convey("assemble in sample info order", t, func() {
+		a := NewFeatureAssembler(&fakeProvider{}, nil, nil)
+		vec, uWidth, iWidth, err := a.Assemble(context.Background(), &Sample{UserId: 7, ItemId: 1})
+		So(err, ShouldBeNil)
+		So(uWidth, ShouldEqual, 2)
+		So(iWidth, ShouldEqual, 1)
+		si, err := a.SampleInfo(uWidth, iWidth)
+		So(err, ShouldBeNil)
+		So(vec, ShouldHaveLength, si.Width())
+		So(vec[si.UserProfileRange[0]:si.UserProfileRange[1]], ShouldResemble, []float32{7, 1})
+		behaviors := vec[si.UserBehaviorRange[0]:si.UserBehaviorRange[1]]
+		// item 2 has embedding, item 3 does not
+		So(behaviors[:ItemEmbDim], ShouldResemble, emb(2))
+		So(behaviors[ItemEmbDim:], ShouldResemble, make([]float32, ItemEmbDim*(UserBehaviorLen-1)))
+		So(vec[si.ItemFeatureRange[0]:si.ItemFeatureRange[1]], ShouldResemble, emb(1))
+		So(vec[si.CtxFeatureRange[0]:si.CtxFeatureRange[1]], ShouldResemble, []float32{1})
+	})
+
+	Convey("cached and uncached are the same", t, func() {
+		cached := NewFeatureAssembler(&fakeProvider{}, ccache.New(ccache.Configure()), ccache.New(ccache.Configure()))
+		uncached := NewFeatureAssembler(&fakeProvider{}, nil, nil)
+		for i := 0; i < 2; i++ {
+			v1, _, _, err := cached.Assemble(context.Background(), &Sample{UserId: 3, ItemId: 2})
+			So(err, ShouldBeNil)
+			v2, _, _, err := uncached.Assemble(context.Background(), &Sample{UserId: 3, ItemId: 2})
+			So(err, ShouldBeNil)
+			So(v1, ShouldResemble, v2)
+		}
+	})
+
+	Convey("provider error", t, func() {
+		_, _, _, err := NewFeatureAssembler(&fakeProvider{}, nil, nil).Assemble(context.Background(), &Sample{UserId: -1})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,308 @@
+package recommend
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultAuditSampleRate is the share of the requests audited, all of them
+const DefaultAuditSampleRate = 1.0
+
+// AuditRecord is the decisions of a recommendation request, to explain why
+// an item was or wasn't shown
+type AuditRecord struct {
+	RequestId string    `json:"requestId"`
+	Time      time.Time `json:"time"`
+	Tenant    string    `json:"tenant,omitempty"`
+	// UserId is 0 of the requests of the DeviceProfile, e.g. in the privacy
+	// mode, see WithPrivacyMode
+	UserId  int    `json:"userId"`
+	Surface string `json:"surface,omitempty"`
+	Query   string `json:"query,omitempty"`
+	Arm     string `json:"arm,omitempty"`
+	// ModelVersion is of the Predictor if it's a ModelVersioner
+	ModelVersion string `json:"modelVersion,omitempty"`
+	// Candidates is the items recalled or requested, before the filters
+	Candidates int `json:"candidates"`
+	// Filtered is the candidates removed by reason, see RecApiResponse
+	Filtered map[string]int `json:"filtered,omitempty"`
+	// RuleHits is the rules matched by the rerank, see RecordRuleHit
+	RuleHits []RuleHit `json:"ruleHits,omitempty"`
+	Fallback string    `json:"fallback,omitempty"`
+	Partial  bool      `json:"partial,omitempty"`
+	// Ranking is the final ranked items, all the pages of a paginated
+	// request
+	Ranking []ItemScore `json:"ranking"`
+	// Served is the leading items of Ranking served, the first page of a
+	// paginated request. 0 is all of them, e.g. of the records before it.
+	Served int `json:"served,omitempty"`
+	// Offset is of the page records of the next pages of a paginated
+	// request, the page of Ranking is at Offset of the ranking of the first
+	// record of RequestId
+	Offset int `json:"offset,omitempty"`
+	// SampleRate is the share of the requests audited, a record stands for
+	// 1 / SampleRate requests
+	SampleRate float64 `json:"sampleRate,omitempty"`
+}
+
+// RuleHit is a rerank rule matched by an item
+type RuleHit struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	ItemId int    `json:"itemId"`
+}
+
+// auditTrail collects the rule hits of an audited request, the rerankers
+// could run concurrently, e.g. of the ensembles
+type auditTrail struct {
+	mu   sync.Mutex
+	hits []RuleHit
+}
+
+type auditTrailKey struct{}
+
+// RecordRuleHit records the rule of action matched by itemId in the audit
+// record of the request of ctx, it's a no-op if the request is not audited.
+// It's called by the ReRankers applying the business rules, e.g. package
+// rules.
+func RecordRuleHit(ctx context.Context, rule, action string, itemId int) {
+	trail, ok := ctx.Value(auditTrailKey{}).(*auditTrail)
+	if !ok {
+		return
+	}
+	trail.mu.Lock()
+	trail.hits = append(trail.hits, RuleHit{Rule: rule, Action: action, ItemId: itemId})
+	trail.mu.Unlock()
+}
+
+func (t *auditTrail) ruleHits() []RuleHit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]RuleHit(nil), t.hits...)
+}
+
+// WithAuditLog records the decisions of the sampled requests in l, the
+// RecApiResponse.RequestId of them is queryable at /api/v1/audit:
+//
+//	curl "http://localhost:8080/api/v1/audit?requestId=3f2a9c0d1e4b5a67"
+//
+// The records of a tenant are only served to the tenant.
+func WithAuditLog(l *AuditLog) ApiOption {
+	return func(c *apiConfig) {
+		c.audit = l
+	}
+}
+
+// AuditLog is the append only file of the AuditRecord json lines, indexed by
+// the request id in memory
+type AuditLog struct {
+	sampleRate float64
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	offsets map[string]int64
+}
+
+// NewAuditLog opens the audit log of path, appended to if it exists, and
+// audits sampleRate of the requests. Zero sampleRate means the default.
+func NewAuditLog(path string, sampleRate float64) (l *AuditLog, err error) {
+	if sampleRate <= 0 {
+		sampleRate = DefaultAuditSampleRate
+	}
+	l = &AuditLog{sampleRate: sampleRate, offsets: make(map[string]int64)}
+	if l.file, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(l.file)
+	for {
+		line, er := reader.ReadBytes('\n')
+		if er == io.EOF {
+			// the last line could be torn by the crash, it's skipped
+			break
+		}
+		if er != nil {
+			l.file.Close()
+			return nil, er
+		}
+		var record struct {
+			RequestId string `json:"requestId"`
+			Offset    int    `json:"offset"`
+		}
+		if json.Unmarshal(line, &record) == nil && record.RequestId != "" && record.Offset == 0 {
+			l.offsets[record.RequestId] = l.size
+		}
+		l.size += int64(len(line))
+	}
+	return
+}
+
+// sampled tells whether a request is audited
+func (l *AuditLog) sampled() bool {
+	return l.sampleRate >= 1 || mrand.Float64() < l.sampleRate
+}
+
+// Append writes r to the log
+func (l *AuditLog) Append(r *AuditRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err = l.file.Write(line); err != nil {
+		return err
+	}
+	if r.Offset == 0 {
+		l.offsets[r.RequestId] = l.size
+	}
+	l.size += int64(len(line))
+	return nil
+}
+
+// Get returns the record of requestId, nil if not found. It's the record of
+// the first page of a paginated request.
+func (l *AuditLog) Get(requestId string) (r *AuditRecord, err error) {
+	l.mu.Lock()
+	offset, ok := l.offsets[requestId]
+	l.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	line, err := bufio.NewReader(io.NewSectionReader(l.file, offset, 1<<62)).ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read audit record %s: %v", requestId, err)
+	}
+	r = &AuditRecord{}
+	if err = json.Unmarshal(line, r); err != nil {
+		return nil, fmt.Errorf("read audit record %s: %v", requestId, err)
+	}
+	return
+}
+
+func (l *AuditLog) Close() error {
+	return l.file.Close()
+}
+
+// startAudit returns the ctx of the audit trail of the request if sampled
+func (l *AuditLog) startAudit(ctx context.Context) (context.Context, *auditTrail) {
+	if l == nil || !l.sampled() {
+		return ctx, nil
+	}
+	trail := &auditTrail{}
+	return context.WithValue(ctx, auditTrailKey{}, trail), trail
+}
+
+func newRequestId() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// addAuditRoutes serves the audit records if configured
+func addAuditRoutes(engine *gin.Engine, resolve predictorResolver, conf *apiConfig) {
+	if conf.audit == nil {
+		return
+	}
+	engine.GET("/api/v1/audit", func(c *gin.Context) {
+		ctx, _, err := resolve(c)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		r, err := conf.audit.Get(c.Query("requestId"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if r == nil || r.Tenant != tenantName(ctx) {
+			c.JSON(404, gin.H{"error": "audit record not found"})
+			return
+		}
+		c.JSON(200, r)
+	})
+}
+
+// record appends the audit record of the ranked resp of req served by
+// predict, of which the leading served items are served. The failure is
+// logged and the request is still served.
+func (l *AuditLog) record(ctx context.Context, trail *auditTrail, req *RecApiRequest, predict Predictor, candidates int, resp RecApiResponse, served int) {
+	r := &AuditRecord{
+		RequestId:  resp.RequestId,
+		Time:       time.Now(),
+		Tenant:     tenantName(ctx),
+		UserId:     req.UserId,
+		Surface:    req.Surface,
+		Query:      req.Query,
+		Arm:        resp.Arm,
+		Candidates: candidates,
+		Filtered:   resp.Filtered,
+		RuleHits:   trail.ruleHits(),
+		Fallback:   resp.Fallback,
+		Partial:    resp.Partial,
+		Ranking:    resp.ItemScoreList,
+		Served:     served,
+		SampleRate: l.sampleRate,
+	}
+	if req.DeviceProfile != nil {
+		r.UserId = 0
+	}
+	if versioner, ok := predict.(ModelVersioner); ok {
+		r.ModelVersion = versioner.ModelVersion()
+	}
+	if err := l.Append(r); err != nil {
+		log.Errorf("append audit record %s error: %v", r.RequestId, err)
+	}
+}
+
+// recordPage appends the page record of the page resp of the cursor of req,
+// if the first page of it is audited, see AuditRecord.Offset
+func (l *AuditLog) recordPage(ctx context.Context, req *RecApiRequest, resp RecApiResponse) {
+	if l == nil {
+		return
+	}
+	_, offset, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return
+	}
+	first, err := l.Get(resp.RequestId)
+	if err != nil || first == nil || first.Tenant != tenantName(ctx) {
+		if err != nil {
+			log.Errorf("append audit page record %s error: %v", resp.RequestId, err)
+		}
+		return
+	}
+	r := &AuditRecord{
+		RequestId:    resp.RequestId,
+		Time:         time.Now(),
+		Tenant:       first.Tenant,
+		UserId:       first.UserId,
+		Surface:      first.Surface,
+		Query:        first.Query,
+		Arm:          first.Arm,
+		ModelVersion: first.ModelVersion,
+		Fallback:     first.Fallback,
+		Ranking:      resp.ItemScoreList,
+		Served:       len(resp.ItemScoreList),
+		Offset:       offset,
+		SampleRate:   first.SampleRate,
+	}
+	if err = l.Append(r); err != nil {
+		log.Errorf("append audit page record %s error: %v", r.RequestId, err)
+	}
+}
@@ -0,0 +1,149 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// auditedPredictor blocks the item 2 by the rule "no-2"
+type auditedPredictor struct {
+	sumPredictor
+}
+
+func (p *auditedPredictor) ModelVersion() string {
+	return "v3"
+}
+
+func (p *auditedPredictor) ReRank(ctx context.Context, _ int, itemScores []ItemScore) (result []ItemScore, err error) {
+	for _, is := range itemScores {
+		if is.ItemId == 2 {
+			RecordRuleHit(ctx, "no-2", "block", is.ItemId)
+			continue
+		}
+		result = append(result, is)
+	}
+	return
+}
+
+func TestAuditLog(t *testing.T) {
+	Convey("audit records of the requests", t, func() {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		l, err := NewAuditLog(path, 0)
+		So(err, ShouldBeNil)
+		defer l.Close()
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &auditedPredictor{})), ShouldBeNil)
+		So(r.Register(NewTenant("other", &sumPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend",
+			WithAuditLog(l),
+			WithPagination(0, 0),
+			WithSessionExclusion(SessionConfig{Default: SessionPolicy{Exclude: true}}),
+		)
+		post := func(body string) (resp RecApiResponse) {
+			req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, 200)
+			So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+			return
+		}
+		get := func(requestId, tenant string) (code int, record AuditRecord) {
+			req := httptest.NewRequest("GET", "/api/v1/audit?requestId="+requestId, nil)
+			if tenant != "" {
+				req.Header.Set(TenantHeader, tenant)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			_ = json.Unmarshal(w.Body.Bytes(), &record)
+			return w.Code, record
+		}
+
+		resp := post(`{"userId":1,"sessionId":"s","seenItemIds":[3],"itemIdList":[1,2,3,4]}`)
+		So(resp.RequestId, ShouldNotBeEmpty)
+		So(resp.ItemScoreList, ShouldHaveLength, 2)
+		code, record := get(resp.RequestId, "")
+		So(code, ShouldEqual, 200)
+		So(record.UserId, ShouldEqual, 1)
+		So(record.ModelVersion, ShouldEqual, "v3")
+		So(record.Candidates, ShouldEqual, 4)
+		So(record.Filtered, ShouldResemble, map[string]int{ReasonSeenInSession: 1})
+		So(record.RuleHits, ShouldResemble, []RuleHit{{Rule: "no-2", Action: "block", ItemId: 2}})
+		So(record.Ranking, ShouldResemble, resp.ItemScoreList)
+
+		// the full ranking of the paginated request
+		resp = post(`{"userId":1,"itemIdList":[1,3,4],"pageSize":1}`)
+		So(resp.ItemScoreList, ShouldHaveLength, 1)
+		code, record = get(resp.RequestId, "")
+		So(code, ShouldEqual, 200)
+		So(record.Ranking, ShouldHaveLength, 3)
+		So(record.Ranking[0], ShouldResemble, resp.ItemScoreList[0])
+		So(record.Served, ShouldEqual, 1)
+		So(record.SampleRate, ShouldEqual, 1)
+
+		// the page records of the next pages served
+		next := post(`{"userId":1,"cursor":"` + resp.NextCursor + `"}`)
+		So(next.ItemScoreList, ShouldHaveLength, 1)
+		data, err := os.ReadFile(path)
+		So(err, ShouldBeNil)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		var pageRecord AuditRecord
+		So(json.Unmarshal([]byte(lines[len(lines)-1]), &pageRecord), ShouldBeNil)
+		So(pageRecord.RequestId, ShouldEqual, resp.RequestId)
+		So(pageRecord.Offset, ShouldEqual, 1)
+		So(pageRecord.Served, ShouldEqual, 1)
+		So(pageRecord.UserId, ShouldEqual, 1)
+		So(pageRecord.Ranking, ShouldResemble, next.ItemScoreList)
+		code, record = get(resp.RequestId, "")
+		So(code, ShouldEqual, 200)
+		So(record.Ranking, ShouldHaveLength, 3)
+
+		// the records of a tenant are not served to the others
+		code, _ = get(resp.RequestId, "other")
+		So(code, ShouldEqual, 404)
+		code, _ = get("missing", "")
+		So(code, ShouldEqual, 404)
+
+		Convey("the log is reopened by the request ids", func() {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			So(err, ShouldBeNil)
+			_, err = f.WriteString(`{"requestId":"torn`)
+			So(err, ShouldBeNil)
+			So(f.Close(), ShouldBeNil)
+
+			reopened, err := NewAuditLog(path, 0)
+			So(err, ShouldBeNil)
+			defer reopened.Close()
+			read, err := reopened.Get(resp.RequestId)
+			So(err, ShouldBeNil)
+			So(read.Ranking, ShouldHaveLength, 3)
+			read, err = reopened.Get("torn")
+			So(err, ShouldBeNil)
+			So(read, ShouldBeNil)
+		})
+	})
+
+	Convey("unsampled requests are not audited", t, func() {
+		l, err := NewAuditLog(filepath.Join(t.TempDir(), "audit.log"), 1e-12)
+		So(err, ShouldBeNil)
+		defer l.Close()
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &sumPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend", WithAuditLog(l))
+		req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(`{"userId":1,"itemIdList":[1,2]}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		var resp RecApiResponse
+		So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+		So(resp.RequestId, ShouldBeEmpty)
+		So(resp.ItemScoreList, ShouldHaveLength, 2)
+	})
+}
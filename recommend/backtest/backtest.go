@@ -0,0 +1,231 @@
+// Package backtest replays the historical interactions day by day: the model
+// is retrained, or updated, as of every day and evaluated on the
+// interactions of the next day, so the metric trajectories catch the models
+// only looking good on a single random split.
+package backtest
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/retrain"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultStep is the evaluation window of a backtest step
+const DefaultStep = 24 * time.Hour
+
+// TrainFunc trains the model of the samples before asOf, current is the
+// model of the previous step to be updated if Config.Incremental, or nil
+type TrainFunc func(ctx context.Context, asOf time.Time, current rcmd.Predictor) (rcmd.Predictor, error)
+
+// RecSysTrainer returns the TrainFunc of rcmd.Train of recSys by fitter, the
+// samples at or after asOf are dropped by rcmd.WithTrainUntil. The user
+// behaviors and the item embeddings of recSys should be as of the samples
+// too, e.g. by SampleOptions.PointInTime.
+func RecSysTrainer(recSys rcmd.RecSys, fitter rcmd.Fitter) TrainFunc {
+	return func(ctx context.Context, asOf time.Time, _ rcmd.Predictor) (rcmd.Predictor, error) {
+		return rcmd.Train(rcmd.WithTrainUntil(ctx, asOf.Unix()), recSys, fitter)
+	}
+}
+
+// Config configures a backtest, the zero values are the defaults
+type Config struct {
+	// From and To are the time range of the evaluations, the steps are of
+	// [From, From+Step), [From+Step, From+2*Step) ... till To
+	From, To time.Time
+	// Step is DefaultStep if 0
+	Step time.Duration
+	// Incremental passes the model of the previous step to Train
+	Incremental bool
+	Train       TrainFunc
+	// Eval returns the EvalFunc of the samples of a step, retrain.EvalAUC
+	// if nil, then the steps of a single label are skipped
+	Eval func(samples []rcmd.Sample) retrain.EvalFunc
+}
+
+// Step is the evaluation of the model trained as of AsOf on the samples of
+// [AsOf, AsOf+Step)
+type Step struct {
+	AsOf      time.Time          `json:"asOf"`
+	Samples   int                `json:"samples"`
+	Positives int                `json:"positives"`
+	Metrics   map[string]float64 `json:"metrics,omitempty"`
+	// Skipped is the reason if the step is not evaluated, e.g. of no samples
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Result is the steps of a backtest in time order
+type Result struct {
+	Steps []Step `json:"steps"`
+}
+
+// Run backtests conf on samples, the historical interactions of their
+// Timestamp in unix seconds, e.g. of Samples. The steps of no samples are
+// skipped, the model is not retrained for them.
+func Run(ctx context.Context, conf Config, samples []rcmd.Sample) (result *Result, err error) {
+	if conf.Step <= 0 {
+		conf.Step = DefaultStep
+	}
+	auc := conf.Eval == nil
+	if auc {
+		conf.Eval = retrain.EvalAUC
+	}
+	if conf.Train == nil {
+		return nil, fmt.Errorf("backtest of no train func")
+	}
+	if !conf.From.Before(conf.To) {
+		return nil, fmt.Errorf("backtest from %v not before to %v", conf.From, conf.To)
+	}
+	sorted := append([]rcmd.Sample(nil), samples...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	result = &Result{}
+	var current rcmd.Predictor
+	for asOf := conf.From; asOf.Before(conf.To); asOf = asOf.Add(conf.Step) {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		end := asOf.Add(conf.Step)
+		if end.After(conf.To) {
+			end = conf.To
+		}
+		var (
+			window = between(sorted, asOf.Unix(), end.Unix())
+			step   = Step{AsOf: asOf, Samples: len(window)}
+		)
+		for _, s := range window {
+			if s.Label > 0.5 {
+				step.Positives++
+			}
+		}
+		switch {
+		case step.Samples == 0:
+			step.Skipped = "no samples"
+		case auc && (step.Positives == 0 || step.Positives == step.Samples):
+			step.Skipped = "single label"
+		}
+		if step.Skipped == "" {
+			var trained rcmd.Predictor
+			if !conf.Incremental {
+				current = nil
+			}
+			if trained, err = conf.Train(ctx, asOf, current); err != nil {
+				return nil, fmt.Errorf("train as of %v: %v", asOf, err)
+			}
+			current = trained
+			if step.Metrics, err = conf.Eval(window)(ctx, trained); err != nil {
+				return nil, fmt.Errorf("evaluate as of %v: %v", asOf, err)
+			}
+			log.Infof("backtest as of %s: %d samples, metrics: %v", asOf.Format(time.RFC3339), step.Samples, step.Metrics)
+		}
+		result.Steps = append(result.Steps, step)
+	}
+	return
+}
+
+// Samples returns all the samples of trainer, after its PreTrain if it's a
+// rcmd.PreTrainer
+func Samples(ctx context.Context, trainer rcmd.Trainer) (samples []rcmd.Sample, err error) {
+	if preTrainer, ok := trainer.(rcmd.PreTrainer); ok {
+		if err = preTrainer.PreTrain(ctx); err != nil {
+			return
+		}
+	}
+	ch, err := trainer.SampleGenerator(ctx)
+	if err != nil {
+		return
+	}
+	for s := range ch {
+		samples = append(samples, s)
+	}
+	return
+}
+
+// between returns the samples of sorted in [from, to)
+func between(sorted []rcmd.Sample, from, to int64) []rcmd.Sample {
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i].Timestamp >= from })
+	j := sort.Search(len(sorted), func(i int) bool { return sorted[i].Timestamp >= to })
+	return sorted[i:j]
+}
+
+// Metrics returns the names of the metrics evaluated, sorted
+func (r *Result) Metrics() []string {
+	seen := make(map[string]struct{})
+	for _, s := range r.Steps {
+		for m := range s.Metrics {
+			seen[m] = struct{}{}
+		}
+	}
+	metrics := make([]string, 0, len(seen))
+	for m := range seen {
+		metrics = append(metrics, m)
+	}
+	sort.Strings(metrics)
+	return metrics
+}
+
+// Summary is the distribution of a metric over the steps evaluated, a model
+// of a high Std or a low Min is not stable over time
+type Summary struct {
+	Steps int     `json:"steps"`
+	Mean  float64 `json:"mean"`
+	Std   float64 `json:"std"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// Summaries returns the Summary of every metric
+func (r *Result) Summaries() map[string]Summary {
+	summaries := make(map[string]Summary)
+	for _, m := range r.Metrics() {
+		var values []float64
+		for _, s := range r.Steps {
+			if v, ok := s.Metrics[m]; ok {
+				values = append(values, v)
+			}
+		}
+		sum := Summary{Steps: len(values), Min: math.Inf(1), Max: math.Inf(-1)}
+		for _, v := range values {
+			sum.Mean += v
+			sum.Min = math.Min(sum.Min, v)
+			sum.Max = math.Max(sum.Max, v)
+		}
+		sum.Mean /= float64(len(values))
+		for _, v := range values {
+			sum.Std += (v - sum.Mean) * (v - sum.Mean)
+		}
+		sum.Std = math.Sqrt(sum.Std / float64(len(values)))
+		summaries[m] = sum
+	}
+	return summaries
+}
+
+// WriteCSV writes the steps as the csv of the columns asOf, samples,
+// positives, skipped and the metrics
+func (r *Result) WriteCSV(w io.Writer) error {
+	metrics := r.Metrics()
+	rows := [][]string{append([]string{"asOf", "samples", "positives", "skipped"}, metrics...)}
+	for _, s := range r.Steps {
+		row := []string{s.AsOf.Format(time.RFC3339), strconv.Itoa(s.Samples), strconv.Itoa(s.Positives), s.Skipped}
+		for _, m := range metrics {
+			v, ok := s.Metrics[m]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		rows = append(rows, row)
+	}
+	return csv.NewWriter(w).WriteAll(rows)
+}
@@ -0,0 +1,155 @@
+package backtest
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/retrain"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// fakePredictor scores every sample by its item id times weight
+type fakePredictor struct {
+	weight float32
+}
+
+func (p *fakePredictor) GetUserFeature(context.Context, int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{0}, nil
+}
+
+func (p *fakePredictor) GetItemFeature(_ context.Context, itemId int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{float32(itemId)}, nil
+}
+
+func (p *fakePredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	rows, cols := X.Shape()[0], X.Shape()[1]
+	data := X.Data().([]float32)
+	y := make([]float32, rows)
+	for i := range y {
+		y[i] = data[i*cols+cols-1] * p.weight
+	}
+	return tensor.New(tensor.WithShape(rows, 1), tensor.WithBacking(y))
+}
+
+// daySamples are the samples of the day of day, the item 2 is clicked and
+// the item 1 is not
+func daySamples(day time.Time) []rcmd.Sample {
+	ts := day.Add(time.Hour).Unix()
+	return []rcmd.Sample{
+		{UserId: 1, ItemId: 1, Label: 0, Timestamp: ts},
+		{UserId: 1, ItemId: 2, Label: 1, Timestamp: ts},
+		{UserId: 2, ItemId: 1, Label: 0, Timestamp: ts + 1},
+		{UserId: 2, ItemId: 2, Label: 1, Timestamp: ts + 1},
+	}
+}
+
+func TestRun(t *testing.T) {
+	var (
+		day0 = time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+		day1 = day0.AddDate(0, 0, 1)
+		day3 = day0.AddDate(0, 0, 3)
+		day4 = day0.AddDate(0, 0, 4)
+	)
+	// no samples on day2, the model trained as of day3 is broken
+	var samples []rcmd.Sample
+	for _, day := range []time.Time{day3, day1, day0} {
+		samples = append(samples, daySamples(day)...)
+	}
+	samples = append(samples, rcmd.Sample{UserId: 3, ItemId: 3, Label: 1, Timestamp: day4.Unix()})
+
+	Convey("backtest day by day", t, func() {
+		var (
+			asOfs    []time.Time
+			currents []rcmd.Predictor
+		)
+		conf := Config{
+			From:        day0,
+			To:          day4,
+			Incremental: true,
+			Train: func(_ context.Context, asOf time.Time, current rcmd.Predictor) (rcmd.Predictor, error) {
+				asOfs, currents = append(asOfs, asOf), append(currents, current)
+				if asOf.Equal(day3) {
+					return &fakePredictor{weight: -1}, nil
+				}
+				return &fakePredictor{weight: 1}, nil
+			},
+		}
+		result, err := Run(context.Background(), conf, samples)
+		So(err, ShouldBeNil)
+		So(result.Steps, ShouldHaveLength, 4)
+		So(asOfs, ShouldResemble, []time.Time{day0, day1, day3})
+		So(currents[0], ShouldBeNil)
+		So(currents[1], ShouldNotBeNil)
+
+		So(result.Steps[0].Samples, ShouldEqual, 4)
+		So(result.Steps[0].Positives, ShouldEqual, 2)
+		So(result.Steps[0].Metrics, ShouldResemble, map[string]float64{"auc": 1})
+		So(result.Steps[2].Skipped, ShouldEqual, "no samples")
+		So(result.Steps[2].Metrics, ShouldBeNil)
+		So(result.Steps[3].Metrics["auc"], ShouldEqual, 0)
+
+		So(result.Metrics(), ShouldResemble, []string{"auc"})
+		sum := result.Summaries()["auc"]
+		So(sum.Steps, ShouldEqual, 3)
+		So(sum.Min, ShouldEqual, 0)
+		So(sum.Max, ShouldEqual, 1)
+		So(sum.Mean, ShouldAlmostEqual, 2.0/3, 1e-9)
+		So(sum.Std, ShouldBeGreaterThan, 0)
+
+		var buf bytes.Buffer
+		So(result.WriteCSV(&buf), ShouldBeNil)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		So(lines, ShouldHaveLength, 5)
+		So(lines[0], ShouldEqual, "asOf,samples,positives,skipped,auc")
+		So(lines[1], ShouldEqual, "2022-03-01T00:00:00Z,4,2,,1")
+		So(lines[3], ShouldEqual, "2022-03-03T00:00:00Z,0,0,no samples,")
+
+		for _, name := range []string{"backtest.png", "backtest.svg"} {
+			path := filepath.Join(t.TempDir(), name)
+			So(result.Plot(path), ShouldBeNil)
+			info, err := os.Stat(path)
+			So(err, ShouldBeNil)
+			So(info.Size(), ShouldBeGreaterThan, 0)
+		}
+		So(result.Plot(filepath.Join(t.TempDir(), "x.png"), "rmse"), ShouldNotBeNil)
+
+		// the full retrains of no current model
+		conf.Incremental = false
+		currents = nil
+		_, err = Run(context.Background(), conf, samples)
+		So(err, ShouldBeNil)
+		So(currents, ShouldResemble, []rcmd.Predictor{nil, nil, nil})
+	})
+
+	Convey("steps of a single label", t, func() {
+		single := []rcmd.Sample{{UserId: 1, ItemId: 2, Label: 1, Timestamp: day0.Unix()}}
+		train := func(context.Context, time.Time, rcmd.Predictor) (rcmd.Predictor, error) {
+			return &fakePredictor{weight: 1}, nil
+		}
+		result, err := Run(context.Background(), Config{From: day0, To: day1, Train: train}, single)
+		So(err, ShouldBeNil)
+		So(result.Steps[0].Skipped, ShouldEqual, "single label")
+
+		// evaluated by the custom Eval of no such skip
+		result, err = Run(context.Background(), Config{From: day0, To: day1, Train: train, Eval: retrain.EvalRegression}, single)
+		So(err, ShouldBeNil)
+		So(result.Steps[0].Metrics["rmse"], ShouldEqual, 1)
+	})
+
+	Convey("bad configs", t, func() {
+		_, err := Run(context.Background(), Config{From: day0, To: day1}, samples)
+		So(err, ShouldNotBeNil)
+		train := func(context.Context, time.Time, rcmd.Predictor) (rcmd.Predictor, error) {
+			return &fakePredictor{weight: 1}, nil
+		}
+		_, err = Run(context.Background(), Config{From: day1, To: day0, Train: train}, samples)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,146 @@
+// Command edgerec-backtest backtests the model of the config on the
+// MovieLens demo data day by day, and writes the metric trajectories:
+//
+//	go run ./recommend/backtest/cmd/edgerec-backtest -config edgerec.yaml \
+//	  -days 14 -csv backtest.csv -plot backtest.png
+//
+// With -recall the recall channels, the popular items and the item CF of
+// the interactions before every day, are evaluated instead of the model by
+// the recall@N of the positives of the day, per channel and overall:
+//
+//	go run ./recommend/backtest/cmd/edgerec-backtest -config edgerec.yaml \
+//	  -recall -cutoffs 50,100,500 -csv recall.csv
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auxten/go-ctr/config"
+	"github.com/auxten/go-ctr/example/movielens"
+	"github.com/auxten/go-ctr/model/mlp"
+	nn "github.com/auxten/go-ctr/nn/neural_network"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/backtest"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	configFlag  = flag.String("config", "", "yaml config file, see package config")
+	fromFlag    = flag.String("from", "", "the first day evaluated as 2006-01-02, the -days before the last sample day if empty")
+	toFlag      = flag.String("to", "", "the day after the last day evaluated as 2006-01-02, the day after the last sample if empty")
+	daysFlag    = flag.Int("days", 7, "the days evaluated if -from is empty")
+	stepFlag    = flag.Duration("step", backtest.DefaultStep, "the evaluation window of a step")
+	csvFlag     = flag.String("csv", "", "the csv file of the steps")
+	plotFlag    = flag.String("plot", "", "the .png or .svg file of the metric trajectories")
+	recallFlag  = flag.Bool("recall", false, "evaluate the recall channels instead of the model")
+	cutoffsFlag = flag.String("cutoffs", "", "the comma separated N of the recall@N, the defaults of package backtest if empty")
+)
+
+func parseDay(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return t
+}
+
+func parseCutoffs(s string) (cutoffs []int) {
+	if s == "" {
+		return backtest.DefaultRecallCutoffs
+	}
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			log.Fatalf("invalid cutoff %q: %v", f, err)
+		}
+		cutoffs = append(cutoffs, n)
+	}
+	return
+}
+
+func main() {
+	flag.Parse()
+	cfg, err := config.Load(*configFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var (
+		ctx    = context.Background()
+		recSys = &movielens.MovielensRec{
+			DataPath:  cfg.Dsn,
+			SampleCnt: cfg.Training.SampleCnt,
+		}
+	)
+	samples, err := backtest.Samples(ctx, recSys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(samples) == 0 {
+		log.Fatal("no samples to backtest")
+	}
+	var maxTs int64
+	for _, s := range samples {
+		if s.Timestamp > maxTs {
+			maxTs = s.Timestamp
+		}
+	}
+	conf := backtest.Config{Step: *stepFlag}
+	if *toFlag != "" {
+		conf.To = parseDay(*toFlag)
+	} else {
+		conf.To = time.Unix(maxTs, 0).UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	}
+	if *fromFlag != "" {
+		conf.From = parseDay(*fromFlag)
+	} else {
+		conf.From = conf.To.AddDate(0, 0, -*daysFlag)
+	}
+	conf.Train = func(ctx context.Context, asOf time.Time, current rcmd.Predictor) (rcmd.Predictor, error) {
+		fitter := nn.NewMLPClassifier([]int{100}, "relu", "adam", 1e-5)
+		fitter.MaxIter = cfg.Training.Epochs
+		return backtest.RecSysTrainer(recSys, &mlp.SimpleMlpFitWrap{Model: fitter})(ctx, asOf, current)
+	}
+
+	var result *backtest.Result
+	if *recallFlag {
+		recallConf := backtest.RecallConfig{From: conf.From, To: conf.To, Step: conf.Step, Cutoffs: parseCutoffs(*cutoffsFlag)}
+		k := 0
+		for _, n := range recallConf.Cutoffs {
+			if n > k {
+				k = n
+			}
+		}
+		recallConf.Channels = recallChannels(samples, k)
+		result, err = backtest.RunRecall(ctx, recallConf, samples)
+	} else {
+		result, err = backtest.Run(ctx, conf, samples)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	summaries, _ := json.MarshalIndent(result.Summaries(), "", "  ")
+	log.Infof("backtest of %d steps: %s", len(result.Steps), summaries)
+	if *csvFlag != "" {
+		f, err := os.Create(*csvFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = result.WriteCSV(f); err != nil {
+			log.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *plotFlag != "" {
+		if err = result.Plot(*plotFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
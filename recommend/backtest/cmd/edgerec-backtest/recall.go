@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/backtest"
+	"github.com/auxten/go-ctr/recommend/cf"
+)
+
+// recallChannels returns the channels of the positive samples before asOf:
+// the most popular items not seen by the user and the item CF of the user
+// history, both recalling k items
+func recallChannels(samples []rcmd.Sample, k int) backtest.ChannelsFunc {
+	sorted := append([]rcmd.Sample(nil), samples...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+	return func(ctx context.Context, asOf time.Time) ([]backtest.Channel, error) {
+		var (
+			counts    = make(map[int]int)
+			behaviors = make(map[int][]int)
+		)
+		for _, s := range sorted {
+			if s.Timestamp >= asOf.Unix() {
+				break
+			}
+			if s.Label > 0.5 {
+				counts[s.ItemId]++
+				behaviors[s.UserId] = append(behaviors[s.UserId], s.ItemId)
+			}
+		}
+		// the latest first
+		for _, seq := range behaviors {
+			for i, j := 0, len(seq)-1; i < j; i, j = i+1, j-1 {
+				seq[i], seq[j] = seq[j], seq[i]
+			}
+		}
+		popular := make([]int, 0, len(counts))
+		for id := range counts {
+			popular = append(popular, id)
+		}
+		sort.Slice(popular, func(i, j int) bool {
+			if counts[popular[i]] != counts[popular[j]] {
+				return counts[popular[i]] > counts[popular[j]]
+			}
+			return popular[i] < popular[j]
+		})
+		itemCF, err := cf.TrainItemCF(behaviors, cf.Options{})
+		if err != nil {
+			return nil, err
+		}
+		return []backtest.Channel{
+			{Name: "popular", Recaller: backtest.RecallerFunc(func(_ context.Context, userId int) ([]int, error) {
+				seen := make(map[int]struct{}, len(behaviors[userId]))
+				for _, id := range behaviors[userId] {
+					seen[id] = struct{}{}
+				}
+				ids := make([]int, 0, k)
+				for _, id := range popular {
+					if len(ids) >= k {
+						break
+					}
+					if _, ok := seen[id]; !ok {
+						ids = append(ids, id)
+					}
+				}
+				return ids, nil
+			})},
+			{Name: "itemcf", Recaller: backtest.RecallerFunc(func(_ context.Context, userId int) ([]int, error) {
+				neighbors, err := itemCF.Recall(behaviors[userId], k)
+				if err != nil {
+					return nil, err
+				}
+				ids := make([]int, len(neighbors))
+				for i, n := range neighbors {
+					ids[i] = n.ItemId
+				}
+				return ids, nil
+			})},
+		}, nil
+	}
+}
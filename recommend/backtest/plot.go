@@ -0,0 +1,63 @@
+package backtest
+
+import (
+	"fmt"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// Plot saves the trajectories of metrics over the steps to path, the format
+// is of the extension of path, e.g. .png or .svg. All the metrics are plotted
+// if none is given, the skipped steps are the gaps of the lines.
+func (r *Result) Plot(path string, metrics ...string) (err error) {
+	if len(metrics) == 0 {
+		metrics = r.Metrics()
+	}
+	if len(metrics) == 0 {
+		return fmt.Errorf("no metric evaluated to plot")
+	}
+	p := plot.New()
+	p.Title.Text = "backtest"
+	p.X.Label.Text = "as of"
+	p.X.Tick.Marker = plot.TimeTicks{Format: "2006-01-02"}
+	p.Legend.Top = true
+	for i, m := range metrics {
+		// a line of every run of the consecutive steps evaluated
+		var (
+			runs [][]plotter.XY
+			run  []plotter.XY
+		)
+		for _, s := range r.Steps {
+			v, ok := s.Metrics[m]
+			if !ok {
+				if len(run) != 0 {
+					runs, run = append(runs, run), nil
+				}
+				continue
+			}
+			run = append(run, plotter.XY{X: float64(s.AsOf.Unix()), Y: v})
+		}
+		if len(run) != 0 {
+			runs = append(runs, run)
+		}
+		if len(runs) == 0 {
+			return fmt.Errorf("metric %s not evaluated", m)
+		}
+		for j, run := range runs {
+			line, points, err := plotter.NewLinePoints(plotter.XYs(run))
+			if err != nil {
+				return err
+			}
+			line.Color, points.Color = plotutil.Color(i), plotutil.Color(i)
+			points.Shape = plotutil.Shape(i)
+			p.Add(line, points)
+			if j == 0 {
+				p.Legend.Add(m, line, points)
+			}
+		}
+	}
+	return p.Save(8*vg.Inch, 4*vg.Inch, path)
+}
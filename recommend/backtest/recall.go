@@ -0,0 +1,236 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+// OverallChannel is the metric prefix of the merged candidates of all the
+// channels
+const OverallChannel = "overall"
+
+// DefaultRecallCutoffs are the N of the recall@N evaluated by RunRecall
+var DefaultRecallCutoffs = []int{50, 100, 500}
+
+// Channel is a recall channel evaluated by RunRecall
+type Channel struct {
+	Name     string
+	Recaller rcmd.Recaller
+}
+
+// RecallerFunc is the rcmd.Recaller of a func
+type RecallerFunc func(ctx context.Context, userId int) ([]int, error)
+
+// Recall implements rcmd.Recaller
+func (f RecallerFunc) Recall(ctx context.Context, userId int) ([]int, error) {
+	return f(ctx, userId)
+}
+
+// ChannelsFunc returns the recall channels of the data before asOf, e.g. the
+// item CF of the interactions before asOf, so the positives of the step are
+// not leaked into the candidates
+type ChannelsFunc func(ctx context.Context, asOf time.Time) ([]Channel, error)
+
+// RecallConfig configures a recall backtest, the zero values are the
+// defaults
+type RecallConfig struct {
+	// From, To and Step are the steps as of Config
+	From, To time.Time
+	Step     time.Duration
+	Channels ChannelsFunc
+	// Cutoffs are the N of the recall@N, the top N candidates of every
+	// channel, DefaultRecallCutoffs if empty
+	Cutoffs []int
+}
+
+// RunRecall backtests the recall stage on samples, as Run does the ranker.
+// The positives of a step are the distinct items of the positive samples of
+// every user in the step, the channels of the step are recalled for the
+// users of any positive. The metrics of a step are:
+//
+//   - "<channel>/recall@N": the share of the positives in the top N
+//     candidates of the channel
+//   - "<channel>/candidates": the mean candidates of the channel per user
+//
+// and the "overall/recall@N" of the union of the top N of every channel.
+// Step.Positives is the positives evaluated, the steps of none are skipped.
+// A failed recall of a user is of no candidates, e.g. of a user unknown to
+// the channel.
+func RunRecall(ctx context.Context, conf RecallConfig, samples []rcmd.Sample) (result *Result, err error) {
+	if conf.Step <= 0 {
+		conf.Step = DefaultStep
+	}
+	if len(conf.Cutoffs) == 0 {
+		conf.Cutoffs = DefaultRecallCutoffs
+	}
+	for _, n := range conf.Cutoffs {
+		if n <= 0 {
+			return nil, fmt.Errorf("recall cutoff %d not positive", n)
+		}
+	}
+	if conf.Channels == nil {
+		return nil, fmt.Errorf("recall backtest of no channels func")
+	}
+	if !conf.From.Before(conf.To) {
+		return nil, fmt.Errorf("backtest from %v not before to %v", conf.From, conf.To)
+	}
+	sorted := append([]rcmd.Sample(nil), samples...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	result = &Result{}
+	for asOf := conf.From; asOf.Before(conf.To); asOf = asOf.Add(conf.Step) {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		end := asOf.Add(conf.Step)
+		if end.After(conf.To) {
+			end = conf.To
+		}
+		var (
+			window    = between(sorted, asOf.Unix(), end.Unix())
+			step      = Step{AsOf: asOf, Samples: len(window)}
+			positives = positiveItems(window)
+		)
+		for _, items := range positives {
+			step.Positives += len(items)
+		}
+		if step.Positives == 0 {
+			step.Skipped = "no positives"
+			result.Steps = append(result.Steps, step)
+			continue
+		}
+		var channels []Channel
+		if channels, err = conf.Channels(ctx, asOf); err != nil {
+			return nil, fmt.Errorf("recall channels as of %v: %v", asOf, err)
+		}
+		if err = checkChannels(channels); err != nil {
+			return nil, fmt.Errorf("recall channels as of %v: %v", asOf, err)
+		}
+		if step.Metrics, err = evalRecall(ctx, channels, conf.Cutoffs, positives, step.Positives); err != nil {
+			return nil, fmt.Errorf("evaluate recall as of %v: %v", asOf, err)
+		}
+		log.Infof("recall backtest as of %s: %d positives, metrics: %v", asOf.Format(time.RFC3339), step.Positives, step.Metrics)
+		result.Steps = append(result.Steps, step)
+	}
+	return
+}
+
+// checkChannels checks the channels have distinct names other than
+// OverallChannel
+func checkChannels(channels []Channel) error {
+	if len(channels) == 0 {
+		return fmt.Errorf("no recall channel")
+	}
+	names := make(map[string]struct{}, len(channels))
+	for _, c := range channels {
+		if c.Name == "" || c.Name == OverallChannel {
+			return fmt.Errorf("invalid recall channel name %q", c.Name)
+		}
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf("duplicate recall channel %s", c.Name)
+		}
+		names[c.Name] = struct{}{}
+	}
+	return nil
+}
+
+// positiveItems returns the distinct items of the positive samples by user
+func positiveItems(samples []rcmd.Sample) map[int]map[int]struct{} {
+	positives := make(map[int]map[int]struct{})
+	for _, s := range samples {
+		if s.Label <= 0.5 {
+			continue
+		}
+		items, ok := positives[s.UserId]
+		if !ok {
+			items = make(map[int]struct{})
+			positives[s.UserId] = items
+		}
+		items[s.ItemId] = struct{}{}
+	}
+	return positives
+}
+
+// evalRecall returns the recall metrics of the channels on the positives by
+// user, total is the positives of all the users
+func evalRecall(ctx context.Context, channels []Channel, cutoffs []int, positives map[int]map[int]struct{}, total int,
+) (metrics map[string]float64, err error) {
+	users := make([]int, 0, len(positives))
+	for userId := range positives {
+		users = append(users, userId)
+	}
+	sort.Ints(users)
+	var (
+		hits        = make(map[string]int)
+		candidates  = make(map[string]int)
+		overallHits = make([]int, len(cutoffs))
+	)
+	for _, userId := range users {
+		items := positives[userId]
+		// overall is the positives recalled by any channel of every cutoff
+		overall := make([]map[int]struct{}, len(cutoffs))
+		for i := range overall {
+			overall[i] = make(map[int]struct{})
+		}
+		for _, c := range channels {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			ids, er := c.Recaller.Recall(ctx, userId)
+			if er != nil {
+				log.Debugf("recall channel %s of user %d error: %v", c.Name, userId, er)
+				ids = nil
+			}
+			ids = distinct(ids)
+			candidates[c.Name] += len(ids)
+			for i, n := range cutoffs {
+				top := ids
+				if len(top) > n {
+					top = top[:n]
+				}
+				for _, id := range top {
+					if _, ok := items[id]; ok {
+						hits[c.Name+"/recall@"+strconv.Itoa(n)]++
+						overall[i][id] = struct{}{}
+					}
+				}
+			}
+		}
+		for i := range cutoffs {
+			overallHits[i] += len(overall[i])
+		}
+	}
+	metrics = make(map[string]float64)
+	for _, c := range channels {
+		for _, n := range cutoffs {
+			name := c.Name + "/recall@" + strconv.Itoa(n)
+			metrics[name] = float64(hits[name]) / float64(total)
+		}
+		metrics[c.Name+"/candidates"] = float64(candidates[c.Name]) / float64(len(users))
+	}
+	for i, n := range cutoffs {
+		metrics[OverallChannel+"/recall@"+strconv.Itoa(n)] = float64(overallHits[i]) / float64(total)
+	}
+	return
+}
+
+// distinct returns the first occurrences of ids in order
+func distinct(ids []int) []int {
+	seen := make(map[int]struct{}, len(ids))
+	result := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,108 @@
+package backtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRunRecall(t *testing.T) {
+	var (
+		day0 = time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+		day1 = day0.AddDate(0, 0, 1)
+		day2 = day0.AddDate(0, 0, 2)
+		day3 = day0.AddDate(0, 0, 3)
+	)
+	ts := day0.Add(time.Hour).Unix()
+	samples := []rcmd.Sample{
+		// the positives of user 1 are the items 1, 2 and 3, of user 2 the
+		// item 4, dup positives are counted once
+		{UserId: 1, ItemId: 1, Label: 1, Timestamp: ts},
+		{UserId: 1, ItemId: 2, Label: 1, Timestamp: ts},
+		{UserId: 1, ItemId: 2, Label: 1, Timestamp: ts + 1},
+		{UserId: 1, ItemId: 3, Label: 1, Timestamp: ts + 2},
+		{UserId: 1, ItemId: 5, Label: 0, Timestamp: ts + 3},
+		{UserId: 2, ItemId: 4, Label: 1, Timestamp: ts + 4},
+		// no positive on day1, one on day2
+		{UserId: 1, ItemId: 1, Label: 0, Timestamp: day1.Unix()},
+		{UserId: 2, ItemId: 9, Label: 1, Timestamp: day2.Unix()},
+	}
+	channels := func(_ context.Context, asOf time.Time) ([]Channel, error) {
+		return []Channel{
+			{Name: "popular", Recaller: RecallerFunc(func(context.Context, int) ([]int, error) {
+				return []int{1, 1, 4, 7}, nil
+			})},
+			{Name: "cf", Recaller: RecallerFunc(func(_ context.Context, userId int) ([]int, error) {
+				if userId == 2 {
+					return nil, fmt.Errorf("unknown user %d", userId)
+				}
+				return []int{3, 8, 2}, nil
+			})},
+		}, nil
+	}
+
+	Convey("recall@N of the channels day by day", t, func() {
+		var asOfs []time.Time
+		result, err := RunRecall(context.Background(), RecallConfig{
+			From: day0,
+			To:   day3,
+			Channels: func(ctx context.Context, asOf time.Time) ([]Channel, error) {
+				asOfs = append(asOfs, asOf)
+				return channels(ctx, asOf)
+			},
+			Cutoffs: []int{1, 3},
+		}, samples)
+		So(err, ShouldBeNil)
+		So(result.Steps, ShouldHaveLength, 3)
+		So(asOfs, ShouldResemble, []time.Time{day0, day2})
+
+		step := result.Steps[0]
+		So(step.Samples, ShouldEqual, 6)
+		So(step.Positives, ShouldEqual, 4)
+		So(step.Metrics, ShouldResemble, map[string]float64{
+			// the item 1 of user 1 in the top 1, and the item 4 of user 2 in the top 3
+			"popular/recall@1":   1. / 4,
+			"popular/recall@3":   2. / 4,
+			"popular/candidates": 3,
+			"cf/recall@1":        1. / 4,
+			"cf/recall@3":        2. / 4,
+			"cf/candidates":      1.5,
+			"overall/recall@1":   2. / 4,
+			"overall/recall@3":   1,
+		})
+		So(result.Steps[1].Skipped, ShouldEqual, "no positives")
+		So(result.Steps[2].Metrics["overall/recall@3"], ShouldEqual, 0)
+
+		var buf bytes.Buffer
+		So(result.WriteCSV(&buf), ShouldBeNil)
+		So(strings.SplitN(buf.String(), "\n", 2)[0], ShouldEqual,
+			"asOf,samples,positives,skipped,cf/candidates,cf/recall@1,cf/recall@3,"+
+				"overall/recall@1,overall/recall@3,popular/candidates,popular/recall@1,popular/recall@3")
+		So(result.Summaries()["overall/recall@3"].Steps, ShouldEqual, 2)
+	})
+
+	Convey("bad recall configs", t, func() {
+		_, err := RunRecall(context.Background(), RecallConfig{From: day0, To: day1}, samples)
+		So(err, ShouldNotBeNil)
+		_, err = RunRecall(context.Background(), RecallConfig{From: day1, To: day0, Channels: channels}, samples)
+		So(err, ShouldNotBeNil)
+		_, err = RunRecall(context.Background(), RecallConfig{From: day0, To: day1, Channels: channels, Cutoffs: []int{0}}, samples)
+		So(err, ShouldNotBeNil)
+		for _, bad := range [][]Channel{
+			nil,
+			{{Name: OverallChannel}},
+			{{Name: "cf"}, {Name: "cf"}},
+		} {
+			bad := bad
+			_, err = RunRecall(context.Background(), RecallConfig{From: day0, To: day1,
+				Channels: func(context.Context, time.Time) ([]Channel, error) { return bad, nil }}, samples)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package recommend
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// seenBitsPerId and seenHashes are of the false positive rate about
+	// seenFalsePositive of the seenSet
+	seenBitsPerId     = 10
+	seenHashes        = 7
+	seenFalsePositive = 0.01
+	// DefaultBiasBuckets is the size of the hashed bias tables
+	DefaultBiasBuckets = 1 << 16
+	// BiasColumns is the width of SampleInfo.IdRange of the biases, the
+	// table rows of the user then the item
+	BiasColumns = 2
+)
+
+// BiasConfig configures the bias terms of SampleOptions.Biases, at least one
+// of Users and Items is set. The zero values of the others are the defaults.
+type BiasConfig struct {
+	Users bool `json:"users" yaml:"users"`
+	Items bool `json:"items" yaml:"items"`
+	// UserBuckets and ItemBuckets are the sizes of the hashed tables,
+	// DefaultBiasBuckets if 0
+	UserBuckets int `json:"userBuckets" yaml:"userBuckets"`
+	ItemBuckets int `json:"itemBuckets" yaml:"itemBuckets"`
+}
+
+// BiasBuckets maps the users and items to the rows of the bias tables, the
+// embeddings of size 1 added to the logit of the model and trained with it,
+// e.g. by the model/bias package. The ids seen in the training are hashed to
+// the rows [1, buckets], so the table size is bounded, and the row 0 is
+// reserved of bias 0 for the ids unseen, which are not biased by the seen
+// ids of their bucket. It's recorded in the Manifest, the serving assembler
+// appends the rows of the sample ids as the training.
+//
+// The ids seen are shipped as the bloom filters UserSeen and ItemSeen, not
+// the ids themselves, so the Manifest served by the lineage api leaks no
+// ids and is of the size bounded by the seen ids. An unseen id is taken as
+// seen of the false positive rate about seenFalsePositive.
+type BiasBuckets struct {
+	// UserBuckets and ItemBuckets are 0 of no user or item biases
+	UserBuckets int `json:"userBuckets,omitempty"`
+	ItemBuckets int `json:"itemBuckets,omitempty"`
+	// UserSeen and ItemSeen are the ids seen in the training, set at the
+	// end of the training
+	UserSeen *seenSet `json:"userSeen,omitempty"`
+	ItemSeen *seenSet `json:"itemSeen,omitempty"`
+	// UserSamples and ItemSamples are the training samples of every id seen,
+	// for the inspection of the biases by WriteCSV of the training process,
+	// they are not in the Manifest
+	UserSamples map[int]int `json:"-"`
+	ItemSamples map[int]int `json:"-"`
+
+	// training counts the ids of the samples assembled as seen
+	training bool
+	mu       sync.RWMutex
+}
+
+// newBiasBuckets returns the BiasBuckets of conf counting the training ids
+func newBiasBuckets(conf BiasConfig) (b *BiasBuckets, err error) {
+	if !conf.Users && !conf.Items {
+		return nil, fmt.Errorf("bias of neither the users nor the items")
+	}
+	b = &BiasBuckets{training: true}
+	if conf.Users {
+		if b.UserBuckets = conf.UserBuckets; b.UserBuckets <= 0 {
+			b.UserBuckets = DefaultBiasBuckets
+		}
+		b.UserSamples = make(map[int]int)
+	}
+	if conf.Items {
+		if b.ItemBuckets = conf.ItemBuckets; b.ItemBuckets <= 0 {
+			b.ItemBuckets = DefaultBiasBuckets
+		}
+		b.ItemSamples = make(map[int]int)
+	}
+	return
+}
+
+// seal ends the training, the ids counted are kept as the seenSets
+func (b *BiasBuckets) seal() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.training = false
+	if b.UserBuckets != 0 {
+		b.UserSeen = newSeenSet(b.UserSamples)
+	}
+	if b.ItemBuckets != 0 {
+		b.ItemSeen = newSeenSet(b.ItemSamples)
+	}
+}
+
+// seenSet is the bloom filter of the ids seen in the training
+type seenSet struct {
+	Bits   []byte `json:"bits"`
+	Hashes int    `json:"hashes"`
+}
+
+func newSeenSet(ids map[int]int) *seenSet {
+	bits := len(ids) * seenBitsPerId
+	if bits < 8 {
+		bits = 8
+	}
+	s := &seenSet{Bits: make([]byte, (bits+7)/8), Hashes: seenHashes}
+	for id := range ids {
+		s.each(id, func(bit uint64) bool {
+			s.Bits[bit/8] |= 1 << (bit % 8)
+			return true
+		})
+	}
+	return s
+}
+
+// has is false if id is not seen, and true of the ids seen or the false
+// positives
+func (s *seenSet) has(id int) bool {
+	if s == nil || len(s.Bits) == 0 {
+		return false
+	}
+	has := true
+	s.each(id, func(bit uint64) bool {
+		has = s.Bits[bit/8]&(1<<(bit%8)) != 0
+		return has
+	})
+	return has
+}
+
+// each calls f of the bits of id by the double hashing, until f is false
+func (s *seenSet) each(id int, f func(bit uint64) bool) {
+	var buf [9]byte
+	// the seen prefix makes the hash independent of the bucket of id
+	buf[0] = 's'
+	binary.LittleEndian.PutUint64(buf[1:], uint64(id))
+	h := fnv.New64a()
+	_, _ = h.Write(buf[:])
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(s.Bits)) * 8
+	for i := uint64(0); i < uint64(s.Hashes); i++ {
+		if !f((h1 + i*h2) % m) {
+			return
+		}
+	}
+}
+
+// biasBucket is the bucket of id in the table of n buckets
+func biasBucket(id, n int) int {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(id))
+	h := fnv.New64a()
+	_, _ = h.Write(buf[:])
+	return int(h.Sum64() % uint64(n))
+}
+
+// UserRows is the rows of the user bias table, 0 without the user biases
+func (b *BiasBuckets) UserRows() int {
+	return tableRows(b.UserBuckets)
+}
+
+// ItemRows is the rows of the item bias table, 0 without the item biases
+func (b *BiasBuckets) ItemRows() int {
+	return tableRows(b.ItemBuckets)
+}
+
+func tableRows(buckets int) int {
+	if buckets == 0 {
+		return 0
+	}
+	return buckets + 1
+}
+
+// UserRow is the bias table row of userId, 0 if unseen
+func (b *BiasBuckets) UserRow(userId int) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.seenRow(userId, b.UserBuckets, b.UserSamples, b.UserSeen)
+}
+
+// ItemRow is the bias table row of itemId, 0 if unseen
+func (b *BiasBuckets) ItemRow(itemId int) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.seenRow(itemId, b.ItemBuckets, b.ItemSamples, b.ItemSeen)
+}
+
+// seenRow is the row of id seen by samples during the training, then by
+// seen, b.mu should be held
+func (b *BiasBuckets) seenRow(id, buckets int, samples map[int]int, seen *seenSet) int {
+	if buckets == 0 {
+		return 0
+	}
+	if b.training {
+		if _, ok := samples[id]; !ok {
+			return 0
+		}
+	} else if !seen.has(id) {
+		return 0
+	}
+	return 1 + biasBucket(id, buckets)
+}
+
+// columns are the SampleInfo.IdRange columns of sampleKey, the ids are
+// counted as seen in the training
+func (b *BiasBuckets) columns(sampleKey *Sample) []float32 {
+	if b.training {
+		b.mu.Lock()
+		if b.UserBuckets != 0 {
+			b.UserSamples[sampleKey.UserId]++
+		}
+		if b.ItemBuckets != 0 {
+			b.ItemSamples[sampleKey.ItemId]++
+		}
+		b.mu.Unlock()
+	}
+	return []float32{float32(b.UserRow(sampleKey.UserId)), float32(b.ItemRow(sampleKey.ItemId))}
+}
+
+// WriteCSV writes the biases of the ids seen in the training for the
+// inspection, the columns are kind, id, samples and bias, of the users then
+// the items by id. It's of the BiasBuckets of the training, the Manifest
+// loaded has no ids. userBiases and itemBiases are the trained tables of
+// UserRows and ItemRows, e.g. of bias.BiasedModel.Biases.
+func (b *BiasBuckets) WriteCSV(w io.Writer, userBiases, itemBiases []float32) error {
+	rows := [][]string{{"kind", "id", "samples", "bias"}}
+	for _, kind := range []struct {
+		name    string
+		samples map[int]int
+		rows    int
+		biases  []float32
+		row     func(int) int
+	}{
+		{"user", b.UserSamples, b.UserRows(), userBiases, b.UserRow},
+		{"item", b.ItemSamples, b.ItemRows(), itemBiases, b.ItemRow},
+	} {
+		if len(kind.biases) != kind.rows {
+			return fmt.Errorf("%d %s biases of %d rows", len(kind.biases), kind.name, kind.rows)
+		}
+		ids := make([]int, 0, len(kind.samples))
+		for id := range kind.samples {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			rows = append(rows, []string{kind.name, strconv.Itoa(id), strconv.Itoa(kind.samples[id]),
+				strconv.FormatFloat(float64(kind.biases[kind.row(id)]), 'g', -1, 32)})
+		}
+	}
+	return csv.NewWriter(w).WriteAll(rows)
+}
@@ -0,0 +1,129 @@
+package recommend
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// biasedRecSys is the lineageRecSys of the user and item biases and the
+// standard normalization
+type biasedRecSys struct {
+	lineageRecSys
+}
+
+func (r *biasedRecSys) SampleOptions() SampleOptions {
+	return SampleOptions{
+		Normalize: NormalizeStandard,
+		Biases:    &BiasConfig{Users: true, Items: true, UserBuckets: 8, ItemBuckets: 8},
+	}
+}
+
+func TestBiasBuckets(t *testing.T) {
+	Convey("bias table rows", t, func() {
+		_, err := newBiasBuckets(BiasConfig{})
+		So(err, ShouldNotBeNil)
+
+		b, err := newBiasBuckets(BiasConfig{Users: true, UserBuckets: 8})
+		So(err, ShouldBeNil)
+		So(b.UserRows(), ShouldEqual, 9)
+		So(b.ItemRows(), ShouldEqual, 0)
+		cols := b.columns(&Sample{UserId: 3, ItemId: 4})
+		So(cols, ShouldResemble, []float32{float32(1 + biasBucket(3, 8)), 0})
+		So(b.UserSamples, ShouldResemble, map[int]int{3: 1})
+
+		// the ids unseen in the training are of the reserved row 0
+		b.seal()
+		So(b.UserSeen.has(3), ShouldBeTrue)
+		So(b.columns(&Sample{UserId: 5, ItemId: 4}), ShouldResemble, []float32{0, 0})
+		So(b.UserSamples, ShouldResemble, map[int]int{3: 1})
+		So(b.UserRow(3), ShouldEqual, cols[0])
+
+		items, err := newBiasBuckets(BiasConfig{Items: true})
+		So(err, ShouldBeNil)
+		So(items.ItemRows(), ShouldEqual, DefaultBiasBuckets+1)
+		So(items.UserRow(3), ShouldEqual, 0)
+	})
+
+	Convey("seen set", t, func() {
+		ids := make(map[int]int)
+		for i := 0; i < 1000; i++ {
+			ids[i] = 1
+		}
+		s := newSeenSet(ids)
+		So(len(s.Bits), ShouldEqual, 1000*seenBitsPerId/8)
+		positives := 0
+		for i := 0; i < 1000; i++ {
+			So(s.has(i), ShouldBeTrue)
+			if s.has(1000 + i) {
+				positives++
+			}
+		}
+		So(positives, ShouldBeLessThan, 1000*seenFalsePositive*3)
+		So(newSeenSet(nil).has(0), ShouldBeFalse)
+	})
+
+	Convey("the rows of the training and serving vectors", t, func() {
+		userCache, itemCache := UserFeatureCache, ItemFeatureCache
+		defer func() {
+			UserFeatureCache, ItemFeatureCache = userCache, itemCache
+		}()
+		ctx := context.Background()
+		recSys, p := &biasedRecSys{}, &recordPredictor{}
+		m, err := Train(ctx, recSys, p)
+		So(err, ShouldBeNil)
+		// the trained Predictor is not wrapped
+		So(m, ShouldImplement, (*UserBehavior)(nil))
+		manifest := m.(ManifestProvider).Manifest()
+		b := manifest.Biases
+		So(b, ShouldNotBeNil)
+		So(b.UserSamples, ShouldResemble, map[int]int{0: 1, 1: 1, 2: 1, 3: 1})
+		cols := manifest.SampleInfo.Width()
+		So(manifest.SampleInfo.IdRange, ShouldResemble, [2]int{cols - BiasColumns, cols})
+
+		// the rows are not normalized
+		for i := 0; i < 4; i++ {
+			So(p.fitted[(i+1)*cols-2:(i+1)*cols], ShouldResemble, []float32{float32(b.UserRow(i)), float32(b.ItemRow(i))})
+			So(p.fitted[(i+1)*cols-2], ShouldBeGreaterThan, 0)
+		}
+		_, err = BatchPredict(ctx, m, []Sample{{UserId: 2, ItemId: 1}})
+		So(err, ShouldBeNil)
+		So(p.predicted, ShouldHaveLength, cols)
+		So(p.predicted[cols-2:], ShouldResemble, []float32{float32(b.UserRow(2)), float32(b.ItemRow(1))})
+		_, err = BatchPredict(ctx, m, []Sample{{UserId: 9, ItemId: 1}})
+		So(err, ShouldBeNil)
+		So(p.predicted[cols-2:], ShouldResemble, []float32{0, float32(b.ItemRow(1))})
+
+		_, err = getSample(recSys, ctx, true)
+		So(err, ShouldNotBeNil)
+
+		Convey("export the biases", func() {
+			user, item := make([]float32, b.UserRows()), make([]float32, b.ItemRows())
+			for i := range user {
+				user[i] = float32(i)
+			}
+			var buf bytes.Buffer
+			So(b.WriteCSV(&buf, user, item), ShouldBeNil)
+			rows, err := csv.NewReader(&buf).ReadAll()
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 9)
+			So(rows[0], ShouldResemble, []string{"kind", "id", "samples", "bias"})
+			So(rows[3][:3], ShouldResemble, []string{"user", "2", "1"})
+			So(rows[3][3], ShouldEqual, strconv.Itoa(b.UserRow(2)))
+			So(rows[8][:3], ShouldResemble, []string{"item", "3", "1"})
+			So(b.WriteCSV(&buf, user[1:], item), ShouldNotBeNil)
+
+			data, err := json.Marshal(b)
+			So(err, ShouldBeNil)
+			var loaded BiasBuckets
+			So(json.Unmarshal(data, &loaded), ShouldBeNil)
+			So(loaded.UserRow(2), ShouldEqual, b.UserRow(2))
+			So(loaded.ItemRow(9), ShouldEqual, 0)
+		})
+	})
+}
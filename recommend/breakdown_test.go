@@ -0,0 +1,31 @@
+package recommend
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBreakdown(t *testing.T) {
+	Convey("contributions of a linear model are the group sums", t, func() {
+		scores, contributions, err := Breakdown(context.Background(), &sumPredictor{}, []Sample{
+			{UserId: 3, ItemId: 5},
+			{UserId: 4, ItemId: 1},
+		})
+		So(err, ShouldBeNil)
+		So(scores, ShouldResemble, []float32{9, 6})
+		So(contributions, ShouldResemble, []Contributions{
+			{UserProfile: 4, CtxFeature: 5},
+			{UserProfile: 5, CtxFeature: 1},
+		})
+	})
+
+	Convey("rank with breakdown", t, func() {
+		itemScores, err := RankWithBreakdown(context.Background(), &sumPredictor{}, 3, []int{5})
+		So(err, ShouldBeNil)
+		So(itemScores, ShouldHaveLength, 1)
+		So(itemScores[0].Score, ShouldEqual, 9)
+		So(*itemScores[0].Contributions, ShouldResemble, Contributions{UserProfile: 4, CtxFeature: 5})
+	})
+}
@@ -0,0 +1,169 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorgonia.org/tensor"
+)
+
+const (
+	// scoringChunk is the rows scored at once by RankWithBudget, the scoring
+	// deadline is checked between the chunks
+	scoringChunk = 32
+)
+
+// Recaller is implemented by the Predictor able to recall the candidate
+// items of a user, it's used if the request has no itemIdList.
+type Recaller interface {
+	Recall(ctx context.Context, userId int) ([]int, error)
+}
+
+// StageBudget is the time budget of every stage of a ranking request, zero
+// means no limit for the stage. Every stage is also bounded by the deadline
+// of the request context.
+type StageBudget struct {
+	Recall       time.Duration `json:"recall" yaml:"recall"`
+	FeatureFetch time.Duration `json:"feature_fetch" yaml:"feature_fetch"`
+	Scoring      time.Duration `json:"scoring" yaml:"scoring"`
+	ReRank       time.Duration `json:"rerank" yaml:"rerank"`
+	// MaxCandidates is the recalled candidates kept for the scoring, the
+	// first of the recall order, the others are dropped as DropRecall. All
+	// are kept if 0.
+	MaxCandidates int `json:"max_candidates" yaml:"max_candidates"`
+}
+
+// WithStageBudget ranks the requests by RankWithBudget with b, the partial
+// results are marked by RecApiResponse.Partial
+func WithStageBudget(b StageBudget) ApiOption {
+	return func(c *apiConfig) {
+		c.budget = &b
+	}
+}
+
+func stageContext(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, budget)
+}
+
+// RecallWithBudget recalls the candidates of userId within b.Recall, at most
+// b.MaxCandidates of them
+func RecallWithBudget(ctx context.Context, recaller Recaller, userId int, b StageBudget) (itemIds []int, err error) {
+	stageCtx, cancel := stageContext(ctx, b.Recall)
+	defer cancel()
+	if itemIds, err = recaller.Recall(stageCtx, userId); err != nil {
+		return nil, fmt.Errorf("recall error: %v", err)
+	}
+	return truncateCandidates(ctx, itemIds, b.MaxCandidates), nil
+}
+
+// truncateCandidates keeps the first max of itemIds, the others are recorded
+// unscored of DropRecall. All are kept if max is 0.
+func truncateCandidates(ctx context.Context, itemIds []int, max int) []int {
+	if max <= 0 || len(itemIds) <= max {
+		return itemIds
+	}
+	recordUnscoredItems(ctx, DropRecall, ReasonMaxCandidates, itemIds[max:])
+	return itemIds[:max]
+}
+
+// RankWithBudget is Rank with the time budget of every stage. If the feature
+// fetch or the scoring runs out of budget, the items scored so far are
+// returned with partial true, in the order of itemIds. It's an error if no
+// item is scored in budget, or the rerank runs out of budget.
+func RankWithBudget(ctx context.Context, recSys Predictor, userId int, itemIds []int, b StageBudget,
+) (itemScores []ItemScore, partial bool, err error) {
+	if _, ok := recSys.(SampleScorer); ok {
+		itemScores, err = Rank(ctx, recSys, userId, itemIds)
+		return
+	}
+	ctx = context.WithValue(ctx, StageKey, PredictStage)
+	if preRanker, ok := recSys.(PreRanker); ok {
+		if err = preRanker.PreRank(ctx); err != nil {
+			return
+		}
+	}
+
+	// feature fetch
+	var (
+		now       = time.Now().Unix()
+		assembler = servingAssembler(ctx, recSys)
+		xData     []float32
+		xWidth    int
+		fetched   []int
+	)
+	featureCtx, cancel := stageContext(ctx, b.FeatureFetch)
+	for i, itemId := range itemIds {
+		if featureCtx.Err() != nil {
+			partial = true
+			recordUnscoredItems(ctx, DropFeatureFetch, ReasonBudget, itemIds[i:])
+			break
+		}
+		vec, _, _, er := assembler.Assemble(featureCtx, &Sample{UserId: userId, ItemId: itemId, Timestamp: now})
+		if er != nil {
+			if featureCtx.Err() != nil {
+				partial = true
+				recordUnscoredItems(ctx, DropFeatureFetch, ReasonBudget, itemIds[i:])
+				break
+			}
+			log.Debugf("get sample vector of item %d error: %v", itemId, er)
+			RecordUnscored(ctx, DropFeatureFetch, ReasonFeatureError, itemId)
+			continue
+		}
+		if xWidth == 0 {
+			xWidth = len(vec)
+		} else if len(vec) != xWidth {
+			cancel()
+			return nil, false, fmt.Errorf("x slice length %d != x col %d", len(vec), xWidth)
+		}
+		xData = append(xData, vec...)
+		fetched = append(fetched, itemId)
+	}
+	cancel()
+	if len(fetched) == 0 {
+		err = fmt.Errorf("no item feature fetched in budget %v", b.FeatureFetch)
+		return
+	}
+
+	// scoring by chunks
+	scoringCtx, cancel := stageContext(ctx, b.Scoring)
+	defer cancel()
+	itemScores = make([]ItemScore, 0, len(fetched))
+	for start := 0; start < len(fetched); start += scoringChunk {
+		if scoringCtx.Err() != nil {
+			partial = true
+			recordUnscoredItems(ctx, DropScoring, ReasonBudget, fetched[start:])
+			break
+		}
+		end := start + scoringChunk
+		if end > len(fetched) {
+			end = len(fetched)
+		}
+		xDense := tensor.NewDense(tensor.Float32, tensor.Shape{end - start, xWidth},
+			tensor.WithBacking(xData[start*xWidth:end*xWidth]))
+		y := recSys.Predict(xDense)
+		for i := start; i < end; i++ {
+			var score interface{}
+			if score, err = y.At(i-start, 0); err != nil {
+				return nil, false, err
+			}
+			itemScores = append(itemScores, ItemScore{ItemId: fetched[i], Score: score.(float32)})
+		}
+	}
+	if len(itemScores) == 0 {
+		err = fmt.Errorf("no item scored in budget %v", b.Scoring)
+		return
+	}
+
+	// rerank
+	reRankCtx, cancelReRank := stageContext(ctx, b.ReRank)
+	defer cancelReRank()
+	if itemScores, err = reRank(reRankCtx, recSys, userId, itemScores); err != nil {
+		return nil, false, fmt.Errorf("rerank error: %v", err)
+	}
+	return
+}
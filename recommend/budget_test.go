@@ -0,0 +1,86 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// slowPredictor sleeps on every item feature fetch and every scoring, and
+// recalls items 1 to 3
+type slowPredictor struct {
+	constPredictor
+	fetchDelay, scoreDelay time.Duration
+}
+
+func (p *slowPredictor) GetItemFeature(ctx context.Context, itemId int) (Tensor, error) {
+	time.Sleep(p.fetchDelay)
+	return p.constPredictor.GetItemFeature(ctx, itemId)
+}
+
+func (p *slowPredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	time.Sleep(p.scoreDelay)
+	return p.constPredictor.Predict(X)
+}
+
+func (p *slowPredictor) Recall(context.Context, int) ([]int, error) {
+	return []int{1, 2, 3}, nil
+}
+
+func TestRankWithBudget(t *testing.T) {
+	itemIds := make([]int, 100)
+	for i := range itemIds {
+		itemIds[i] = i
+	}
+	ctx := context.Background()
+
+	Convey("in budget", t, func() {
+		itemScores, partial, err := RankWithBudget(ctx, &slowPredictor{}, 1, itemIds, StageBudget{Scoring: time.Second})
+		So(err, ShouldBeNil)
+		So(partial, ShouldBeFalse)
+		So(itemScores, ShouldHaveLength, len(itemIds))
+	})
+
+	Convey("scoring out of budget returns the scored items", t, func() {
+		p := &slowPredictor{scoreDelay: 20 * time.Millisecond}
+		itemScores, partial, err := RankWithBudget(ctx, p, 1, itemIds, StageBudget{Scoring: 30 * time.Millisecond})
+		So(err, ShouldBeNil)
+		So(partial, ShouldBeTrue)
+		So(len(itemScores), ShouldBeLessThan, len(itemIds))
+		So(len(itemScores)%scoringChunk, ShouldEqual, 0)
+		So(itemScores[0].ItemId, ShouldEqual, 0)
+	})
+
+	Convey("feature fetch out of budget", t, func() {
+		p := &slowPredictor{fetchDelay: 5 * time.Millisecond}
+		itemScores, partial, err := RankWithBudget(ctx, p, 1, itemIds, StageBudget{FeatureFetch: 20 * time.Millisecond})
+		So(err, ShouldBeNil)
+		So(partial, ShouldBeTrue)
+		So(len(itemScores), ShouldBeBetween, 0, len(itemIds))
+
+		expired, cancel := context.WithCancel(ctx)
+		cancel()
+		_, _, err = RankWithBudget(expired, p, 1, itemIds, StageBudget{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("recall and partial response of the http api", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &slowPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend", WithStageBudget(StageBudget{Recall: time.Second}))
+		req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(`{"userId":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		var resp RecApiResponse
+		So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+		So(resp.Partial, ShouldBeFalse)
+		So(resp.ItemScoreList, ShouldHaveLength, 3)
+	})
+}
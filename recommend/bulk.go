@@ -0,0 +1,327 @@
+package recommend
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// bulkCheckpointInterval is the users written between the checkpoints
+	bulkCheckpointInterval = 100
+)
+
+// UserLister is implemented by the Predictor able to list all the users, it's
+// used by BulkRecommend if no user is given.
+type UserLister interface {
+	ListUsers(ctx context.Context) ([]int, error)
+}
+
+// BulkWriter writes the top-K items of the users, Flush makes the written
+// results durable before the checkpoint.
+type BulkWriter interface {
+	Write(userId int, itemScores []ItemScore) error
+	Flush() error
+}
+
+// BulkOptions are the settings of BulkRecommend
+type BulkOptions struct {
+	// TopK is the items kept for every user, 0 keeps all
+	TopK int
+	// Workers is the users ranked in parallel, default 1
+	Workers int
+	// Candidates are ranked for every user if the Predictor is not a Recaller
+	Candidates []int
+	// CheckpointPath is the file of the done user ids, the users in it are
+	// skipped to resume an interrupted run. Empty disables the checkpoint.
+	CheckpointPath string
+}
+
+// BulkStats is the result of BulkRecommend
+type BulkStats struct {
+	Done    int `json:"done"`
+	Skipped int `json:"skipped"` // done by the resumed run
+	Failed  int `json:"failed"`
+}
+
+type bulkResult struct {
+	userId     int
+	itemScores []ItemScore
+}
+
+// BulkRecommend ranks offline the top-K items of the userIds, or of all the
+// users listed by UserLister if userIds is nil, and writes them to w. The
+// results are written at least once: on resume, the users done after the
+// last checkpoint are written again. The users failed to rank are logged
+// and not checkpointed, so they are retried on resume.
+func BulkRecommend(ctx context.Context, recSys Predictor, userIds []int, w BulkWriter, opts BulkOptions,
+) (stats BulkStats, err error) {
+	if userIds == nil {
+		lister, ok := recSys.(UserLister)
+		if !ok {
+			return stats, fmt.Errorf("no user given and the predictor is not a UserLister")
+		}
+		if userIds, err = lister.ListUsers(ctx); err != nil {
+			return stats, fmt.Errorf("list users error: %v", err)
+		}
+	}
+	recaller, isRecaller := recSys.(Recaller)
+	if !isRecaller && len(opts.Candidates) == 0 {
+		return stats, fmt.Errorf("no candidates given and the predictor is not a Recaller")
+	}
+
+	var checkpoint *bulkCheckpoint
+	if opts.CheckpointPath != "" {
+		if checkpoint, err = openBulkCheckpoint(opts.CheckpointPath); err != nil {
+			return
+		}
+		defer checkpoint.Close()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		workers  = opts.Workers
+		userCh   = make(chan int)
+		resultCh = make(chan bulkResult)
+		failedCh = make(chan struct{})
+		wg       sync.WaitGroup
+	)
+	if workers <= 0 {
+		workers = 1
+	}
+	go func() {
+		defer close(userCh)
+		for _, userId := range userIds {
+			if checkpoint != nil && checkpoint.done(userId) {
+				stats.Skipped++
+				continue
+			}
+			select {
+			case userCh <- userId:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userId := range userCh {
+				itemScores, er := bulkRank(ctx, recSys, recaller, userId, opts)
+				if er != nil {
+					log.Errorf("bulk recommend user %d error: %v", userId, er)
+					select {
+					case failedCh <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+					continue
+				}
+				select {
+				case resultCh <- bulkResult{userId: userId, itemScores: itemScores}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	var pending []int
+	commit := func() error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if checkpoint != nil {
+			if err := checkpoint.add(pending); err != nil {
+				return err
+			}
+		}
+		pending = pending[:0]
+		return nil
+	}
+	for {
+		select {
+		case <-failedCh:
+			stats.Failed++
+			continue
+		case r, ok := <-resultCh:
+			if !ok {
+				err = commit()
+				return
+			}
+			if err = w.Write(r.userId, r.itemScores); err != nil {
+				return
+			}
+			stats.Done++
+			pending = append(pending, r.userId)
+			if len(pending) >= bulkCheckpointInterval {
+				if err = commit(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func bulkRank(ctx context.Context, recSys Predictor, recaller Recaller, userId int, opts BulkOptions,
+) (itemScores []ItemScore, err error) {
+	itemIds := opts.Candidates
+	if recaller != nil {
+		if itemIds, err = recaller.Recall(ctx, userId); err != nil {
+			return
+		}
+	}
+	if len(itemIds) == 0 {
+		return
+	}
+	if itemScores, err = Rank(ctx, recSys, userId, itemIds); err != nil {
+		return
+	}
+	sort.SliceStable(itemScores, func(i, j int) bool {
+		return itemScores[i].Score > itemScores[j].Score
+	})
+	if opts.TopK > 0 && len(itemScores) > opts.TopK {
+		itemScores = itemScores[:opts.TopK]
+	}
+	return
+}
+
+// bulkCheckpoint is the append only file of the done user ids
+type bulkCheckpoint struct {
+	file  *os.File
+	users map[int]struct{}
+}
+
+func openBulkCheckpoint(path string) (c *bulkCheckpoint, err error) {
+	c = &bulkCheckpoint{users: make(map[int]struct{})}
+	if c.file, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(c.file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		userId, er := strconv.Atoi(line)
+		if er != nil {
+			// the last line could be torn by the crash
+			log.Warnf("bad checkpoint line %q of %s", line, path)
+			continue
+		}
+		c.users[userId] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		c.file.Close()
+		return nil, err
+	}
+	return
+}
+
+func (c *bulkCheckpoint) done(userId int) bool {
+	_, ok := c.users[userId]
+	return ok
+}
+
+func (c *bulkCheckpoint) add(userIds []int) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	for _, userId := range userIds {
+		sb.WriteString(strconv.Itoa(userId))
+		sb.WriteByte('\n')
+	}
+	if _, err := c.file.WriteString(sb.String()); err != nil {
+		return err
+	}
+	return c.file.Sync()
+}
+
+func (c *bulkCheckpoint) Close() error {
+	return c.file.Close()
+}
+
+// CSVBulkWriter writes a row of userId,rank,itemId,score for every item, the
+// rank starts from 1. No header is written so the resumed run could append.
+type CSVBulkWriter struct {
+	w *csv.Writer
+}
+
+func NewCSVBulkWriter(w io.Writer) *CSVBulkWriter {
+	return &CSVBulkWriter{w: csv.NewWriter(w)}
+}
+
+func (c *CSVBulkWriter) Write(userId int, itemScores []ItemScore) error {
+	for i, is := range itemScores {
+		if err := c.w.Write([]string{
+			strconv.Itoa(userId),
+			strconv.Itoa(i + 1),
+			strconv.Itoa(is.ItemId),
+			strconv.FormatFloat(float64(is.Score), 'g', -1, 32),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CSVBulkWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// DBBulkWriter inserts the items into the table of the columns:
+//
+//	user_id INTEGER, rank INTEGER, item_id INTEGER, score REAL
+type DBBulkWriter struct {
+	DB    *sql.DB
+	Table string
+}
+
+func (d *DBBulkWriter) Write(userId int, itemScores []ItemScore) (err error) {
+	if len(itemScores) == 0 {
+		return
+	}
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (user_id, rank, item_id, score) VALUES (?, ?, ?, ?)", d.Table))
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	for i, is := range itemScores {
+		if _, err = stmt.Exec(userId, i+1, is.ItemId, is.Score); err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
+
+// Flush is a no-op, every Write is committed
+func (d *DBBulkWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,58 @@
+package recommend
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBulkRecommend(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("bulk recommend to csv with checkpoint", t, func() {
+		var (
+			buf        bytes.Buffer
+			checkpoint = filepath.Join(t.TempDir(), "bulk.checkpoint")
+			opts       = BulkOptions{TopK: 2, Workers: 3, Candidates: []int{1, 2, 3}, CheckpointPath: checkpoint}
+		)
+		// user -1 fails on the user feature
+		stats, err := BulkRecommend(ctx, &sumPredictor{}, []int{1, 2, -1}, NewCSVBulkWriter(&buf), opts)
+		So(err, ShouldBeNil)
+		So(stats, ShouldResemble, BulkStats{Done: 2, Failed: 1})
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		So(lines, ShouldHaveLength, 4)
+		So(lines, ShouldContain, "1,1,3,5")
+		So(lines, ShouldContain, "1,2,2,4")
+
+		// resume skips the done users and retries the failed
+		buf.Reset()
+		stats, err = BulkRecommend(ctx, &sumPredictor{}, []int{1, 2, -1, 3}, NewCSVBulkWriter(&buf), opts)
+		So(err, ShouldBeNil)
+		So(stats, ShouldResemble, BulkStats{Done: 1, Skipped: 2, Failed: 1})
+		So(strings.TrimSpace(buf.String()), ShouldEqual, "3,1,3,7\n3,2,2,6")
+	})
+
+	Convey("bulk recommend to db", t, func() {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "bulk.db"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+		_, err = db.Exec("CREATE TABLE rec (user_id INTEGER, rank INTEGER, item_id INTEGER, score REAL)")
+		So(err, ShouldBeNil)
+		stats, err := BulkRecommend(ctx, &sumPredictor{}, []int{1, 2}, &DBBulkWriter{DB: db, Table: "rec"},
+			BulkOptions{TopK: 1, Candidates: []int{1, 2, 3}})
+		So(err, ShouldBeNil)
+		So(stats.Done, ShouldEqual, 2)
+		var itemId int
+		So(db.QueryRow("SELECT item_id FROM rec WHERE user_id = 2 AND rank = 1").Scan(&itemId), ShouldBeNil)
+		So(itemId, ShouldEqual, 3)
+
+		_, err = BulkRecommend(ctx, &sumPredictor{}, nil, &DBBulkWriter{DB: db, Table: "rec"}, BulkOptions{})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,89 @@
+package recommend
+
+import (
+	"gorgonia.org/tensor"
+)
+
+// Correction is the prior shift of the downsampled training samples: the
+// odds of the positive label of the sampled set are the original odds
+// divided by Factor. The model trained on the sampled set over predicts by
+// the shift, its scores are calibrated back by Calibrate. It's recorded in
+// the Manifest of the RecSys of SamplingReporter and applied to the scores
+// of the model wherever the Manifest is loaded.
+type Correction struct {
+	// NegativeRate is the share of the negatives kept, 1 of no negative
+	// downsampling
+	NegativeRate float64 `json:"negativeRate"`
+	// PositiveRate and SampledPositiveRate are the shares of the positives
+	// before and after the sampling
+	PositiveRate        float64 `json:"positiveRate"`
+	SampledPositiveRate float64 `json:"sampledPositiveRate"`
+	// Factor is the odds ratio of PositiveRate to SampledPositiveRate, it's
+	// NegativeRate of the negative downsampling only. 1 if either rate is 0
+	// or 1. The zero Correction is no correction.
+	Factor float64 `json:"factor"`
+}
+
+// SamplingReporter could be implemented by the RecSys of the downsampled
+// SampleGenerator, see package sampling. SamplingCorrection is called by
+// Train after the samples are drained, nil of no correction.
+type SamplingReporter interface {
+	SamplingCorrection() *Correction
+}
+
+func odds(p float64) float64 {
+	return p / (1 - p)
+}
+
+func (c Correction) factor() float64 {
+	if c.Factor <= 0 {
+		return 1
+	}
+	return c.Factor
+}
+
+// Calibrate maps the probability q of the model trained on the sampled set to
+// the probability of the original data
+func (c Correction) Calibrate(q float64) float64 {
+	if q <= 0 || q >= 1 {
+		return q
+	}
+	o := odds(q) * c.factor()
+	return o / (1 + o)
+}
+
+// Bias is the inverse of Calibrate, it maps the probability of the original
+// data to the one of the sampled set
+func (c Correction) Bias(p float64) float64 {
+	if p <= 0 || p >= 1 {
+		return p
+	}
+	o := odds(p) / c.factor()
+	return o / (1 + o)
+}
+
+// CalibrateScores calibrates scores in place, c of nil or of Factor 1 keeps
+// them
+func (c *Correction) CalibrateScores(scores []float32) {
+	if c == nil || c.factor() == 1 {
+		return
+	}
+	for i, q := range scores {
+		scores[i] = float32(c.Calibrate(float64(q)))
+	}
+}
+
+// calibrate returns y of the scores calibrated by c, y itself if there's no
+// correction or y is not of float32
+func (c *Correction) calibrate(y tensor.Tensor) tensor.Tensor {
+	if c == nil || c.factor() == 1 || y == nil {
+		return y
+	}
+	scores, ok := y.Data().([]float32)
+	if !ok {
+		return y
+	}
+	calibrated := append([]float32(nil), scores...)
+	c.CalibrateScores(calibrated)
+	return tensor.New(tensor.WithShape(y.Shape().Clone()...), tensor.WithBacking(calibrated))
+}
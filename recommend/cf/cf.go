@@ -0,0 +1,206 @@
+// Package cf is the item-item collaborative filtering of the user behaviors.
+// An item is the binary vector of the users interacted with it, the item
+// similarity is by the ann.Metric of the vectors, computed from the
+// co-occurrence counts.
+package cf
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/auxten/go-ctr/recommend/ann"
+)
+
+const (
+	// DefaultNeighbors is the similar items kept of every item
+	DefaultNeighbors = 100
+	// DefaultMaxUserItems is the max recent items of a user counted, the
+	// co-occurrences of a user grow quadratically with the items
+	DefaultMaxUserItems = 200
+)
+
+// Options of TrainItemCF, the zero values are the defaults
+type Options struct {
+	// Metric is ann.Cosine by default
+	Metric       ann.Metric `json:"metric" yaml:"metric"`
+	Neighbors    int        `json:"neighbors" yaml:"neighbors"`
+	MaxUserItems int        `json:"max_user_items" yaml:"max_user_items"`
+	// Progress reports the share done of TrainItemCF if not nil
+	Progress func(done float64) `json:"-" yaml:"-"`
+}
+
+// ItemCF is the similar items of every item
+type ItemCF struct {
+	metric    ann.Metric
+	neighbors map[int][]ann.Neighbor
+}
+
+// Stats is the co-occurrence statistics of the user behaviors
+type Stats struct {
+	// Counts is the users of every item
+	Counts map[int]int
+	// CoCounts is the users of every item pair, keyed by the smaller item
+	CoCounts map[int]map[int]int
+}
+
+// CountCoOccurrence counts the items and the item pairs of the users, the
+// behaviors are the item sequences of the users, the latest first. Only the
+// first maxUserItems distinct items of a user are counted, all if <= 0.
+func CountCoOccurrence(behaviors map[int][]int, maxUserItems int) *Stats {
+	stats := &Stats{Counts: make(map[int]int), CoCounts: make(map[int]map[int]int)}
+	for _, seq := range behaviors {
+		seen := make(map[int]struct{}, len(seq))
+		items := make([]int, 0, len(seq))
+		for _, id := range seq {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			if maxUserItems > 0 && len(items) >= maxUserItems {
+				break
+			}
+			seen[id] = struct{}{}
+			items = append(items, id)
+		}
+		for i, a := range items {
+			stats.Counts[a]++
+			for _, b := range items[i+1:] {
+				stats.add(a, b)
+			}
+		}
+	}
+	return stats
+}
+
+func (s *Stats) add(a, b int) {
+	if a > b {
+		a, b = b, a
+	}
+	co, ok := s.CoCounts[a]
+	if !ok {
+		co = make(map[int]int)
+		s.CoCounts[a] = co
+	}
+	co[b]++
+}
+
+// similarity is the metric of the binary user vectors of the items counted na
+// and nb with co users in common
+func similarity(metric ann.Metric, na, nb, co int) float32 {
+	switch metric {
+	case ann.Cosine:
+		return float32(float64(co) / math.Sqrt(float64(na)*float64(nb)))
+	case ann.Euclidean:
+		return -float32(math.Sqrt(float64(na + nb - 2*co)))
+	}
+	return float32(co)
+}
+
+// TrainItemCF computes the similar items of the behaviors by opts. Only the
+// co-occurring items are similar, also by Euclidean of which the distance of
+// disjoint items is defined but meaningless for the recall.
+func TrainItemCF(behaviors map[int][]int, opts Options) (*ItemCF, error) {
+	if opts.MaxUserItems <= 0 {
+		opts.MaxUserItems = DefaultMaxUserItems
+	}
+	stats := CountCoOccurrence(behaviors, opts.MaxUserItems)
+	if opts.Progress != nil {
+		opts.Progress(0.5)
+	}
+	return NewItemCF(stats, opts)
+}
+
+// NewItemCF computes the similar items of the co-occurrence stats by opts
+func NewItemCF(stats *Stats, opts Options) (*ItemCF, error) {
+	if opts.Metric == "" {
+		opts.Metric = ann.Cosine
+	}
+	if err := opts.Metric.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Neighbors <= 0 {
+		opts.Neighbors = DefaultNeighbors
+	}
+	neighbors := make(map[int][]ann.Neighbor)
+	for a, co := range stats.CoCounts {
+		for b, n := range co {
+			s := similarity(opts.Metric, stats.Counts[a], stats.Counts[b], n)
+			neighbors[a] = append(neighbors[a], ann.Neighbor{ItemId: b, Score: s})
+			neighbors[b] = append(neighbors[b], ann.Neighbor{ItemId: a, Score: s})
+		}
+	}
+	for id, list := range neighbors {
+		sortNeighbors(list)
+		if len(list) > opts.Neighbors {
+			list = list[:opts.Neighbors]
+		}
+		neighbors[id] = list
+	}
+	if opts.Progress != nil {
+		opts.Progress(1)
+	}
+	return &ItemCF{metric: opts.Metric, neighbors: neighbors}, nil
+}
+
+func sortNeighbors(list []ann.Neighbor) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score > list[j].Score
+		}
+		return list[i].ItemId < list[j].ItemId
+	})
+}
+
+func (c *ItemCF) Metric() ann.Metric {
+	return c.metric
+}
+
+// Len is the items having similar items
+func (c *ItemCF) Len() int {
+	return len(c.neighbors)
+}
+
+// Similar returns at most k similar items of itemId, the most similar first
+func (c *ItemCF) Similar(itemId, k int) []ann.Neighbor {
+	list := c.neighbors[itemId]
+	if k < len(list) {
+		list = list[:k]
+	}
+	return append([]ann.Neighbor(nil), list...)
+}
+
+// Recall returns at most k items similar to the history items, scored by
+// the sum of the similarities to them. The history items are excluded. The
+// Euclidean scores are negative, an item similar to more history items would
+// score lower, so they are summed as 1 / (1 + distance).
+func (c *ItemCF) Recall(history []int, k int) ([]ann.Neighbor, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("recall k %d <= 0", k)
+	}
+	seen := make(map[int]struct{}, len(history))
+	for _, id := range history {
+		seen[id] = struct{}{}
+	}
+	scores := make(map[int]float32)
+	for id := range seen {
+		for _, n := range c.neighbors[id] {
+			if _, ok := seen[n.ItemId]; ok {
+				continue
+			}
+			s := n.Score
+			if c.metric == ann.Euclidean {
+				s = 1 / (1 - s)
+			}
+			scores[n.ItemId] += s
+		}
+	}
+	list := make([]ann.Neighbor, 0, len(scores))
+	for id, s := range scores {
+		list = append(list, ann.Neighbor{ItemId: id, Score: s})
+	}
+	sortNeighbors(list)
+	if len(list) > k {
+		list = list[:k]
+	}
+	return list, nil
+}
@@ -0,0 +1,80 @@
+package cf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/auxten/go-ctr/recommend/ann"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestItemCF(t *testing.T) {
+	// 1 is bought by everyone, 2 and 3 always together
+	behaviors := map[int][]int{
+		1: {1, 2, 3},
+		2: {1, 2, 3, 2},
+		3: {1, 4},
+		4: {1, 4},
+		5: {1, 5},
+	}
+
+	Convey("co-occurrence", t, func() {
+		stats := CountCoOccurrence(behaviors, 0)
+		So(stats.Counts, ShouldResemble, map[int]int{1: 5, 2: 2, 3: 2, 4: 2, 5: 1})
+		So(stats.CoCounts[2][3], ShouldEqual, 2)
+		So(stats.CoCounts[1][4], ShouldEqual, 2)
+
+		limited := CountCoOccurrence(behaviors, 1)
+		So(limited.CoCounts, ShouldBeEmpty)
+	})
+
+	Convey("the metrics", t, func() {
+		cosine, err := TrainItemCF(behaviors, Options{})
+		So(err, ShouldBeNil)
+		So(cosine.Metric(), ShouldEqual, ann.Cosine)
+		similar := cosine.Similar(2, 2)
+		So(similar[0], ShouldResemble, ann.Neighbor{ItemId: 3, Score: 1})
+		So(similar[1].ItemId, ShouldEqual, 1)
+
+		// the popular item 1 leads by dot
+		dot, err := TrainItemCF(behaviors, Options{Metric: ann.Dot})
+		So(err, ShouldBeNil)
+		So(dot.Similar(2, 2), ShouldResemble, []ann.Neighbor{{ItemId: 1, Score: 2}, {ItemId: 3, Score: 2}})
+
+		euclidean, err := TrainItemCF(behaviors, Options{Metric: ann.Euclidean})
+		So(err, ShouldBeNil)
+		So(euclidean.Similar(2, 1), ShouldResemble, []ann.Neighbor{{ItemId: 3, Score: 0}})
+
+		_, err = TrainItemCF(behaviors, Options{Metric: "jaccard"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("recall of the history", t, func() {
+		for _, metric := range []ann.Metric{ann.Cosine, ann.Dot, ann.Euclidean} {
+			c, err := TrainItemCF(behaviors, Options{Metric: metric, Neighbors: 10})
+			So(err, ShouldBeNil)
+			recalled, err := c.Recall([]int{2, 4}, 10)
+			So(err, ShouldBeNil)
+			So(recalled[0].ItemId, ShouldBeIn, 1, 3)
+			for _, n := range recalled {
+				So(n.ItemId, ShouldNotBeIn, 2, 4)
+				So(n.Score, ShouldBeGreaterThan, 0)
+			}
+			_, err = c.Recall(nil, 0)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("rebuilt in the background", t, func() {
+		live := NewLiveItemCF(func(_ context.Context, progress func(float64)) (*ItemCF, error) {
+			return TrainItemCF(behaviors, Options{Progress: progress})
+		})
+		_, err := live.Recall([]int{2}, 1)
+		So(err, ShouldNotBeNil)
+		So(live.Rebuild(context.Background()), ShouldBeNil)
+		So(live.Similar(2, 1)[0].ItemId, ShouldEqual, 3)
+		stats := live.Stats()
+		So(stats.Builds, ShouldEqual, 1)
+		So(stats.Size, ShouldEqual, 5)
+	})
+}
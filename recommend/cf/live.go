@@ -0,0 +1,104 @@
+package cf
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/auxten/go-ctr/recommend/ann"
+	log "github.com/sirupsen/logrus"
+)
+
+// Builder builds the ItemCF of the latest co-occurrence stats, reporting the
+// share done by progress
+type Builder func(ctx context.Context, progress func(done float64)) (*ItemCF, error)
+
+// LiveItemCF is the ItemCF rebuilt in the background, the requests are
+// served by the last built one until the new one is swapped in atomically.
+// The build metrics are by Stats.
+type LiveItemCF struct {
+	ann.BuildTracker
+	build Builder
+
+	mu sync.RWMutex
+	cf *ItemCF
+}
+
+// NewLiveItemCF creates the LiveItemCF of build, it serves no result until
+// the first Rebuild
+func NewLiveItemCF(build Builder) *LiveItemCF {
+	return &LiveItemCF{build: build}
+}
+
+// Rebuild builds the ItemCF and swaps it in, ann.ErrBuilding if a build is in
+// progress. On error the serving ItemCF is kept.
+func (l *LiveItemCF) Rebuild(ctx context.Context) (err error) {
+	progress, ok := l.Start()
+	if !ok {
+		return ann.ErrBuilding
+	}
+	var cf *ItemCF
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("build item cf panic: %v", r)
+		}
+		size := 0
+		if err == nil {
+			size = cf.Len()
+		}
+		l.Done(err, size)
+	}()
+	if cf, err = l.build(ctx, progress); err != nil {
+		return
+	}
+	if cf == nil {
+		return fmt.Errorf("built no item cf")
+	}
+	l.mu.Lock()
+	l.cf = cf
+	l.mu.Unlock()
+	return
+}
+
+// RebuildEvery rebuilds the ItemCF every interval until ctx is done
+func (l *LiveItemCF) RebuildEvery(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := l.Rebuild(ctx); err != nil {
+					log.Errorf("rebuild item cf error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// ItemCF returns the serving ItemCF, nil if never built
+func (l *LiveItemCF) ItemCF() *ItemCF {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.cf
+}
+
+// Similar is ItemCF.Similar of the serving ItemCF
+func (l *LiveItemCF) Similar(itemId, k int) []ann.Neighbor {
+	if cf := l.ItemCF(); cf != nil {
+		return cf.Similar(itemId, k)
+	}
+	return nil
+}
+
+// Recall is ItemCF.Recall of the serving ItemCF
+func (l *LiveItemCF) Recall(history []int, k int) ([]ann.Neighbor, error) {
+	cf := l.ItemCF()
+	if cf == nil {
+		return nil, fmt.Errorf("item cf is not built")
+	}
+	return cf.Recall(history, k)
+}
@@ -0,0 +1,171 @@
+package recommend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/auxten/go-ctr/model/encryption"
+	"github.com/auxten/go-ctr/utils/npy"
+)
+
+// ContentEmbeddings is the dense vectors of the item contents by item id,
+// computed elsewhere, e.g. the CLIP or ResNet image embeddings. The vectors
+// are imported by ReadContentCSV or ReadContentNPY, and appended to the item
+// features by RegisterContentEmbeddings.
+type ContentEmbeddings struct {
+	Dim     int
+	vectors map[int][]float32
+}
+
+// Get returns the vector of itemId
+func (c *ContentEmbeddings) Get(itemId int) (vec []float32, ok bool) {
+	vec, ok = c.vectors[itemId]
+	return
+}
+
+// Len returns the number of the items of vectors
+func (c *ContentEmbeddings) Len() int {
+	return len(c.vectors)
+}
+
+func (c *ContentEmbeddings) add(itemId int, vec []float32) error {
+	if c.Dim == 0 {
+		if len(vec) == 0 {
+			return fmt.Errorf("content embedding of item %d is empty", itemId)
+		}
+		c.Dim = len(vec)
+	}
+	if len(vec) != c.Dim {
+		return fmt.Errorf("content embedding of item %d dim %d != %d", itemId, len(vec), c.Dim)
+	}
+	if _, ok := c.vectors[itemId]; ok {
+		return fmt.Errorf("content embedding of item %d duplicated", itemId)
+	}
+	c.vectors[itemId] = vec
+	return nil
+}
+
+// ReadContentCSV reads the "item_id,v0,v1,..." rows, the first row is
+// skipped as the header if its item id is not an integer
+func ReadContentCSV(r io.Reader) (c *ContentEmbeddings, err error) {
+	c = &ContentEmbeddings{vectors: make(map[int][]float32)}
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	for line := 1; ; line++ {
+		var record []string
+		if record, err = reader.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("read content csv: %v", err)
+		}
+		itemId, er := strconv.Atoi(strings.TrimSpace(record[0]))
+		if er != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("content csv line %d item id %q: %v", line, record[0], er)
+		}
+		vec := make([]float32, len(record)-1)
+		for i, field := range record[1:] {
+			v, er := strconv.ParseFloat(strings.TrimSpace(field), 32)
+			if er != nil {
+				return nil, fmt.Errorf("content csv line %d column %d: %v", line, i+1, er)
+			}
+			vec[i] = float32(v)
+		}
+		if err = c.add(itemId, vec); err != nil {
+			return nil, err
+		}
+	}
+	if c.Len() == 0 {
+		return nil, fmt.Errorf("content csv of no item")
+	}
+	return c, nil
+}
+
+// ReadContentNPY reads the 2-D float32 or float64 array of the numpy .npy
+// format, column 0 of every row is the item id and the rest is the vector.
+// The item ids should be exact in the float type, i.e. below 2^24 of
+// float32.
+func ReadContentNPY(r io.Reader) (c *ContentEmbeddings, err error) {
+	t, err := npy.Read(bufio.NewReader(r))
+	if err != nil {
+		return
+	}
+	if t.Dims() != 2 || t.Shape()[0] == 0 || t.Shape()[1] < 2 {
+		return nil, fmt.Errorf("content npy of shape %v, not (items, 1 + dim)", t.Shape())
+	}
+	var values []float64
+	switch data := t.Data().(type) {
+	case []float32:
+		values = make([]float64, len(data))
+		for i, v := range data {
+			values[i] = float64(v)
+		}
+	case []float64:
+		values = data
+	default:
+		return nil, fmt.Errorf("content npy of %v, not float32 or float64", t.Dtype())
+	}
+	rows, cols := t.Shape()[0], t.Shape()[1]
+	c = &ContentEmbeddings{vectors: make(map[int][]float32, rows)}
+	for i := 0; i < rows; i++ {
+		row := values[i*cols : (i+1)*cols]
+		if row[0] != math.Trunc(row[0]) {
+			return nil, fmt.Errorf("content npy row %d item id %v not an integer", i, row[0])
+		}
+		vec := make([]float32, cols-1)
+		for j, v := range row[1:] {
+			vec[j] = float32(v)
+		}
+		if err = c.add(int(row[0]), vec); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// LoadContentEmbeddings reads the .csv or .npy file of path, decrypted of
+// the storage key if encrypted, see package encryption
+func LoadContentEmbeddings(path string) (c *ContentEmbeddings, err error) {
+	data, err := encryption.ReadFile(path)
+	if err != nil {
+		return
+	}
+	f := bytes.NewReader(data)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		return ReadContentCSV(f)
+	case ".npy":
+		return ReadContentNPY(f)
+	default:
+		return nil, fmt.Errorf("content embedding file %s of %q not .csv or .npy", path, ext)
+	}
+}
+
+// RegisterContentEmbeddings registers c as the FeatureFunc of name and
+// width c.Dim, so the vectors are appended to the item features of the
+// training samples and the serving vectors, and the dim is recorded in the
+// FeatureFuncs of the Manifest. The items of no vector get zeros.
+func RegisterContentEmbeddings(name string, c *ContentEmbeddings) error {
+	if c == nil || c.Dim == 0 {
+		return fmt.Errorf("content embeddings %s of no dim", name)
+	}
+	return RegisterFeatureFunc(name, c.Dim, func(_ context.Context, _, itemId int) []float64 {
+		features := make([]float64, c.Dim)
+		if vec, ok := c.Get(itemId); ok {
+			for i, v := range vec {
+				features[i] = float64(v)
+			}
+		}
+		return features
+	})
+}
@@ -0,0 +1,131 @@
+package recommend
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/auxten/go-ctr/utils/npy"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// npyBytes encodes rows in the .npy format of float32 or float64
+func npyBytes(dt tensor.Dtype, rows [][]float64) []byte {
+	var values []float64
+	for _, row := range rows {
+		values = append(values, row...)
+	}
+	x := tensor.New(tensor.WithShape(len(rows), len(rows[0])), tensor.WithBacking(values))
+	if dt == tensor.Float32 {
+		f32 := make([]float32, len(values))
+		for i, v := range values {
+			f32[i] = float32(v)
+		}
+		x = tensor.New(tensor.WithShape(len(rows), len(rows[0])), tensor.WithBacking(f32))
+	}
+	var buf bytes.Buffer
+	_ = npy.Write(&buf, x)
+	return buf.Bytes()
+}
+
+func TestContentEmbeddings(t *testing.T) {
+	Convey("read csv", t, func() {
+		c, err := ReadContentCSV(strings.NewReader("item_id,v0,v1\n1,0.5,1\n2, -1 ,2\n"))
+		So(err, ShouldBeNil)
+		So(c.Dim, ShouldEqual, 2)
+		So(c.Len(), ShouldEqual, 2)
+		vec, ok := c.Get(2)
+		So(ok, ShouldBeTrue)
+		So(vec, ShouldResemble, []float32{-1, 2})
+		_, ok = c.Get(3)
+		So(ok, ShouldBeFalse)
+
+		_, err = ReadContentCSV(strings.NewReader("1,0.5\n1,1\n"))
+		So(err, ShouldNotBeNil)
+		_, err = ReadContentCSV(strings.NewReader("1,0.5\nx,1\n"))
+		So(err, ShouldNotBeNil)
+		_, err = ReadContentCSV(strings.NewReader("1,0.5\n2,y\n"))
+		So(err, ShouldNotBeNil)
+		_, err = ReadContentCSV(strings.NewReader("1,0.5\n2,1,2\n"))
+		So(err, ShouldNotBeNil)
+		_, err = ReadContentCSV(strings.NewReader("item_id,v0\n"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("read npy", t, func() {
+		rows := [][]float64{{7, 0.25, -1, 3}, {9, 1, 2, 4}}
+		for _, dt := range []tensor.Dtype{tensor.Float32, tensor.Float64} {
+			c, err := ReadContentNPY(bytes.NewReader(npyBytes(dt, rows)))
+			So(err, ShouldBeNil)
+			So(c.Dim, ShouldEqual, 3)
+			vec, ok := c.Get(7)
+			So(ok, ShouldBeTrue)
+			So(vec, ShouldResemble, []float32{0.25, -1, 3})
+		}
+		data := npyBytes(tensor.Float32, rows)
+		_, err := ReadContentNPY(bytes.NewReader(data[:len(data)-1]))
+		So(err, ShouldNotBeNil)
+		_, err = ReadContentNPY(bytes.NewReader(npyBytes(tensor.Float32, [][]float64{{1.5, 1}})))
+		So(err, ShouldNotBeNil)
+		_, err = ReadContentNPY(bytes.NewReader(npyBytes(tensor.Float32, [][]float64{{1}, {2}})))
+		So(err, ShouldNotBeNil)
+		var ints bytes.Buffer
+		So(npy.Write(&ints, tensor.New(tensor.WithShape(1, 2), tensor.WithBacking([]int32{1, 2}))), ShouldBeNil)
+		_, err = ReadContentNPY(&ints)
+		So(err, ShouldNotBeNil)
+		_, err = ReadContentNPY(strings.NewReader("1,0.5\n"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("load by the file extension", t, func() {
+		dir := t.TempDir()
+		csvPath, npyPath := filepath.Join(dir, "images.csv"), filepath.Join(dir, "images.npy")
+		So(os.WriteFile(csvPath, []byte("1,0.5\n"), 0644), ShouldBeNil)
+		So(os.WriteFile(npyPath, npyBytes(tensor.Float32, [][]float64{{1, 0.5}}), 0644), ShouldBeNil)
+		for _, path := range []string{csvPath, npyPath} {
+			c, err := LoadContentEmbeddings(path)
+			So(err, ShouldBeNil)
+			So(c.Len(), ShouldEqual, 1)
+		}
+		_, err := LoadContentEmbeddings(filepath.Join(dir, "images.txt"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestContentFeature(t *testing.T) {
+	userCache, itemCache := UserFeatureCache, ItemFeatureCache
+	defer func() {
+		UserFeatureCache, ItemFeatureCache = userCache, itemCache
+	}()
+
+	Convey("the content embeddings appended to the item features", t, func() {
+		// the items of lineageRecSys are 0 to 3, item 3 has no vector
+		c, err := ReadContentCSV(strings.NewReader("0,1,0\n1,0,1\n2,1,1\n"))
+		So(err, ShouldBeNil)
+		So(RegisterContentEmbeddings("image", nil), ShouldNotBeNil)
+		So(RegisterContentEmbeddings("image", c), ShouldBeNil)
+		defer UnregisterFeatureFunc("image")
+
+		fitter := &sampleFitter{}
+		m, err := Train(context.Background(), &lineageRecSys{}, fitter)
+		So(err, ShouldBeNil)
+		sample := fitter.sample
+		expected := map[float32][]float32{0: {1, 0}, 1: {0, 1}, 2: {1, 1}, 3: {0, 0}}
+		for i := 0; i < sample.Rows; i++ {
+			row := sample.X[i*sample.XCols : (i+1)*sample.XCols]
+			// the item feature of fakeProvider is the item id
+			So(row[sample.XCols-2:], ShouldResemble, expected[row[sample.XCols-3]])
+		}
+		manifest := m.(ManifestProvider).Manifest()
+		So(manifest.FeatureFuncs, ShouldResemble, []FeatureFuncInfo{{Name: "image", Width: 2}})
+
+		result, err := DebugFeature(context.Background(), m, Sample{UserId: 1, ItemId: 2})
+		So(err, ShouldBeNil)
+		columns := result.Columns
+		So(columns[len(columns)-2:], ShouldResemble, []FeatureColumn{{Name: "image_0", Value: 1}, {Name: "image_1", Value: 1}})
+	})
+}
@@ -0,0 +1,275 @@
+package recommend
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	mrand "math/rand"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCounterfactualSampleRate is the share of the unscored candidates
+// logged
+const DefaultCounterfactualSampleRate = 0.1
+
+// the pipeline stages dropping the candidates before the scoring, see
+// RecordUnscored
+const (
+	// DropRecall is of the candidates truncated by a Recaller, e.g. over
+	// StageBudget.MaxCandidates
+	DropRecall = "recall"
+	// DropSession is of the items seen in the session
+	DropSession = "session"
+	// DropFeedback is of the items hidden or blocked by the user feedback
+	DropFeedback = "feedback"
+	// DropPreFilter is of the items ineligible by the PreFilter
+	DropPreFilter = "prefilter"
+	// DropFallback is of the items ranked by the fallback instead of the
+	// model, the reason is the RecApiResponse.Fallback
+	DropFallback = "fallback"
+	// DropFeatureFetch is of the items of no features fetched
+	DropFeatureFetch = "feature_fetch"
+	// DropScoring is of the items fetched but not scored in budget
+	DropScoring = "scoring"
+)
+
+// the reasons of the candidates dropped by RecallWithBudget and
+// RankWithBudget
+const (
+	ReasonMaxCandidates = "max_candidates"
+	ReasonBudget        = "budget"
+	ReasonFeatureError  = "feature_error"
+)
+
+// CounterfactualRecord is a sampled candidate of a request dropped before
+// the scoring, for the off-policy evaluation of the rankings of all the
+// candidates and the analysis of the recall quality. They are joined with
+// the AuditRecord and the tracked events of the request by RequestId.
+type CounterfactualRecord struct {
+	RequestId string    `json:"requestId"`
+	Time      time.Time `json:"time"`
+	Tenant    string    `json:"tenant,omitempty"`
+	// UserId is 0 of the requests of the DeviceProfile, as AuditRecord
+	UserId  int    `json:"userId"`
+	Surface string `json:"surface,omitempty"`
+	Query   string `json:"query,omitempty"`
+	Arm     string `json:"arm,omitempty"`
+	// ModelVersion is of the Predictor if it's a ModelVersioner
+	ModelVersion string `json:"modelVersion,omitempty"`
+	ItemId       int    `json:"itemId"`
+	// Position is the index of the item in the candidates recalled or
+	// requested, -1 if it's not one of them, e.g. truncated by the Recaller
+	Position int `json:"position"`
+	// Candidates is the items recalled or requested, before the filters
+	Candidates int    `json:"candidates"`
+	Stage      string `json:"stage"`
+	Reason     string `json:"reason,omitempty"`
+	// Propensity is the probability of the candidate being logged, the
+	// dropped candidates are estimated by the records weighted by
+	// 1/Propensity
+	Propensity float64 `json:"propensity"`
+}
+
+// unscored is a candidate dropped of a request
+type unscored struct {
+	itemId        int
+	stage, reason string
+}
+
+// counterfactualTrail collects the sampled candidates dropped of a request,
+// the stages could run concurrently, e.g. of the ensembles
+type counterfactualTrail struct {
+	rate float64
+
+	mu      sync.Mutex
+	dropped map[int]struct{}
+	sampled []unscored
+}
+
+type counterfactualTrailKey struct{}
+
+// RecordUnscored records the candidate itemId dropped before the scoring by
+// the stage of reason, e.g. DropRecall of the candidates truncated by a
+// Recaller, in the counterfactual log of the request of ctx. Only the first
+// drop of an item is taken and a sample of them is logged, it's a no-op if
+// the request is not logged. It's called by the pipeline stages, see
+// WithCounterfactualLog.
+func RecordUnscored(ctx context.Context, stage, reason string, itemId int) {
+	trail, ok := ctx.Value(counterfactualTrailKey{}).(*counterfactualTrail)
+	if !ok {
+		return
+	}
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+	if _, ok = trail.dropped[itemId]; ok {
+		return
+	}
+	trail.dropped[itemId] = struct{}{}
+	if trail.rate >= 1 || mrand.Float64() < trail.rate {
+		trail.sampled = append(trail.sampled, unscored{itemId: itemId, stage: stage, reason: reason})
+	}
+}
+
+// recordUnscoredItems is RecordUnscored of itemIds
+func recordUnscoredItems(ctx context.Context, stage, reason string, itemIds []int) {
+	if _, ok := ctx.Value(counterfactualTrailKey{}).(*counterfactualTrail); !ok {
+		return
+	}
+	for _, itemId := range itemIds {
+		RecordUnscored(ctx, stage, reason, itemId)
+	}
+}
+
+func (t *counterfactualTrail) unscored() []unscored {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]unscored(nil), t.sampled...)
+}
+
+// hasUnscored tells whether any candidate of the request is logged
+func (t *counterfactualTrail) hasUnscored() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.sampled) != 0
+}
+
+// WithCounterfactualLog logs a sample of the candidates of the requests
+// dropped before the scoring to l, by the StageBudget of the recall and the
+// scoring, the session exclusion, the feedback filter, the PreFilter and the
+// fallbacks, and by the custom stages calling RecordUnscored. The
+// RecApiResponse.RequestId is set of the requests logged.
+func WithCounterfactualLog(l *CounterfactualLog) ApiOption {
+	return func(c *apiConfig) {
+		c.counterfactual = l
+	}
+}
+
+// CounterfactualLog is the append only file of the CounterfactualRecord
+// json lines
+type CounterfactualLog struct {
+	sampleRate float64
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewCounterfactualLog opens the counterfactual log of path, appended to if
+// it exists, and logs sampleRate of the unscored candidates, every one is
+// sampled independently. Zero sampleRate means the default.
+func NewCounterfactualLog(path string, sampleRate float64) (l *CounterfactualLog, err error) {
+	if sampleRate <= 0 {
+		sampleRate = DefaultCounterfactualSampleRate
+	}
+	l = &CounterfactualLog{sampleRate: sampleRate}
+	if l.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Append writes records to the log
+func (l *CounterfactualLog) Append(records []*CounterfactualRecord) error {
+	var lines []byte
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.file.Write(lines)
+	return err
+}
+
+func (l *CounterfactualLog) Close() error {
+	return l.file.Close()
+}
+
+// ReadCounterfactualRecords reads the records of a counterfactual log, the
+// torn last line of a crash is skipped
+func ReadCounterfactualRecords(r io.Reader) (records []CounterfactualRecord, err error) {
+	reader := bufio.NewReader(r)
+	for {
+		line, er := reader.ReadBytes('\n')
+		if er == io.EOF {
+			return
+		}
+		if er != nil {
+			return nil, er
+		}
+		var record CounterfactualRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// startTrail returns the ctx of the counterfactual trail of the request
+func (l *CounterfactualLog) startTrail(ctx context.Context) (context.Context, *counterfactualTrail) {
+	if l == nil {
+		return ctx, nil
+	}
+	trail := &counterfactualTrail{rate: l.sampleRate, dropped: make(map[int]struct{})}
+	return context.WithValue(ctx, counterfactualTrailKey{}, trail), trail
+}
+
+// record logs the sampled candidates of trail, candidates are the ones
+// recalled or requested
+func (l *CounterfactualLog) record(ctx context.Context, trail *counterfactualTrail, req *RecApiRequest,
+	predict Predictor, candidates []int, resp RecApiResponse,
+) {
+	sampled := trail.unscored()
+	if len(sampled) == 0 {
+		return
+	}
+	positions := make(map[int]int, len(candidates))
+	for i, itemId := range candidates {
+		if _, ok := positions[itemId]; !ok {
+			positions[itemId] = i
+		}
+	}
+	base := CounterfactualRecord{
+		RequestId:  resp.RequestId,
+		Time:       time.Now(),
+		Tenant:     tenantName(ctx),
+		UserId:     req.UserId,
+		Surface:    req.Surface,
+		Query:      req.Query,
+		Arm:        resp.Arm,
+		Candidates: len(candidates),
+		Propensity: trail.rate,
+	}
+	if req.DeviceProfile != nil {
+		base.UserId = 0
+	}
+	if versioner, ok := predict.(ModelVersioner); ok {
+		base.ModelVersion = versioner.ModelVersion()
+	}
+	if base.Propensity > 1 {
+		base.Propensity = 1
+	}
+	records := make([]*CounterfactualRecord, len(sampled))
+	for i, u := range sampled {
+		r := base
+		r.ItemId, r.Stage, r.Reason = u.itemId, u.stage, u.reason
+		r.Position = -1
+		if position, ok := positions[u.itemId]; ok {
+			r.Position = position
+		}
+		records[i] = &r
+	}
+	if err := l.Append(records); err != nil {
+		log.Errorf("append counterfactual records of %s error: %v", resp.RequestId, err)
+	}
+}
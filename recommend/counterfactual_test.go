@@ -0,0 +1,145 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCounterfactualLog(t *testing.T) {
+	Convey("trail of the unscored candidates", t, func() {
+		ctx := context.Background()
+		// no-op of the requests not logged
+		RecordUnscored(ctx, DropRecall, "top_k", 1)
+		var l *CounterfactualLog
+		_, trail := l.startTrail(ctx)
+		So(trail, ShouldBeNil)
+		So(trail.hasUnscored(), ShouldBeFalse)
+
+		l = &CounterfactualLog{sampleRate: 1}
+		ctx, trail = l.startTrail(ctx)
+		So(trail.hasUnscored(), ShouldBeFalse)
+		RecordUnscored(ctx, DropRecall, "top_k", 1)
+		// only the first drop of an item is taken
+		RecordUnscored(ctx, DropPreFilter, "out_of_stock", 1)
+		NewFallbackPolicy().Shed(ctx, &constPredictor{}, 1, []int{1, 2})
+		So(trail.unscored(), ShouldResemble, []unscored{
+			{itemId: 1, stage: DropRecall, reason: "top_k"},
+			{itemId: 2, stage: DropFallback, reason: FallbackLoadShedding},
+		})
+
+		// the candidates out of the scoring budget
+		itemIds := make([]int, 100)
+		for i := range itemIds {
+			itemIds[i] = i
+		}
+		ctx, trail = l.startTrail(context.Background())
+		itemScores, partial, err := RankWithBudget(ctx, &slowPredictor{scoreDelay: 20 * time.Millisecond}, 1, itemIds,
+			StageBudget{Scoring: 30 * time.Millisecond})
+		So(err, ShouldBeNil)
+		So(partial, ShouldBeTrue)
+		dropped := trail.unscored()
+		So(dropped, ShouldHaveLength, len(itemIds)-len(itemScores))
+		So(dropped[0], ShouldResemble, unscored{itemId: len(itemScores), stage: DropScoring, reason: ReasonBudget})
+
+		// the sampled candidates
+		l = &CounterfactualLog{sampleRate: 0.5}
+		ctx, trail = l.startTrail(context.Background())
+		for _, itemId := range itemIds {
+			RecordUnscored(ctx, DropRecall, "top_k", itemId)
+		}
+		So(len(trail.unscored()), ShouldBeBetween, 20, 80)
+	})
+
+	Convey("counterfactual records of the http api", t, func() {
+		path := filepath.Join(t.TempDir(), "counterfactual.log")
+		l, err := NewCounterfactualLog(path, 1)
+		So(err, ShouldBeNil)
+		defer l.Close()
+		p := &attributePredictor{
+			items: map[int]map[string]string{
+				1: {}, 2: {"stock": "0"}, 3: {"regions": "us"}, 4: {}, 5: {}, 6: {},
+			},
+			user: map[string]string{"region": "cn"},
+		}
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, p)), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend",
+			WithCounterfactualLog(l),
+			WithPreFilter(NewPreFilter(InStock("stock"), RegionAllowed("regions", "region"))),
+			WithSessionExclusion(SessionConfig{Default: SessionPolicy{Exclude: true}}),
+		)
+		post := func(body string) (resp RecApiResponse) {
+			req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, 200)
+			So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+			return
+		}
+
+		resp := post(`{"userId":1,"sessionId":"s","seenItemIds":[1]}`)
+		So(resp.ItemScoreList, ShouldHaveLength, 3)
+		So(resp.RequestId, ShouldNotBeEmpty)
+		// no candidate dropped, no record
+		So(post(`{"userId":2,"itemIdList":[4,5]}`).RequestId, ShouldBeEmpty)
+
+		file, err := os.Open(path)
+		So(err, ShouldBeNil)
+		defer file.Close()
+		records, err := ReadCounterfactualRecords(file)
+		So(err, ShouldBeNil)
+		So(records, ShouldHaveLength, 3)
+		for i, record := range records {
+			So(record.RequestId, ShouldEqual, resp.RequestId)
+			So(record.UserId, ShouldEqual, 1)
+			So(record.Candidates, ShouldEqual, 6)
+			So(record.Propensity, ShouldEqual, 1)
+			So(record.ItemId, ShouldEqual, i+1)
+			So(record.Position, ShouldEqual, i)
+		}
+		So([]string{records[0].Stage, records[0].Reason}, ShouldResemble, []string{DropSession, ReasonSeenInSession})
+		So([]string{records[1].Stage, records[1].Reason}, ShouldResemble, []string{DropPreFilter, "out_of_stock"})
+		So([]string{records[2].Stage, records[2].Reason}, ShouldResemble, []string{DropPreFilter, "region_not_allowed"})
+	})
+
+	Convey("candidates truncated by the recall", t, func() {
+		path := filepath.Join(t.TempDir(), "counterfactual.log")
+		l, err := NewCounterfactualLog(path, 1)
+		So(err, ShouldBeNil)
+		defer l.Close()
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &slowPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend",
+			WithCounterfactualLog(l),
+			WithStageBudget(StageBudget{MaxCandidates: 2}),
+		)
+		req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(`{"userId":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		So(w.Code, ShouldEqual, 200)
+		var resp RecApiResponse
+		So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+		So(resp.ItemScoreList, ShouldHaveLength, 2)
+
+		file, err := os.Open(path)
+		So(err, ShouldBeNil)
+		defer file.Close()
+		records, err := ReadCounterfactualRecords(file)
+		So(err, ShouldBeNil)
+		So(records, ShouldHaveLength, 1)
+		So(records[0].ItemId, ShouldEqual, 3)
+		So(records[0].Position, ShouldEqual, -1)
+		So(records[0].Candidates, ShouldEqual, 2)
+		So([]string{records[0].Stage, records[0].Reason}, ShouldResemble, []string{DropRecall, ReasonMaxCandidates})
+	})
+}
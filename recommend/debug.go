@@ -0,0 +1,94 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+)
+
+// FeatureNamer could be implemented by the BasicFeatureProvider to give the
+// user and item feature columns human-readable names in the debug api.
+type FeatureNamer interface {
+	UserFeatureNames() []string
+	ItemFeatureNames() []string
+}
+
+type FeatureColumn struct {
+	Name  string  `json:"name"`
+	Value float32 `json:"value"`
+}
+
+type FeatureDebugResult struct {
+	UserId     int             `json:"userId"`
+	ItemId     int             `json:"itemId"`
+	Timestamp  int64           `json:"timestamp"`
+	SampleInfo SampleInfo      `json:"sampleInfo"`
+	Columns    []FeatureColumn `json:"columns"`
+	Score      float32         `json:"score"`
+	// Contributions of the feature groups to the score, see Breakdown
+	Contributions Contributions `json:"contributions"`
+}
+
+// FeatureColumnNames returns the names of the sample vector columns in
+// SampleInfo order. names are used for the user and item feature columns if
+// their length match, or the columns are named by the index.
+func FeatureColumnNames(si *SampleInfo, userFeatureNames, itemFeatureNames []string) []string {
+	names := make([]string, 0, si.Width())
+	field := func(prefix string, rng [2]int, given []string) {
+		if len(given) == rng[1]-rng[0] {
+			names = append(names, given...)
+			return
+		}
+		for i := 0; i < rng[1]-rng[0]; i++ {
+			names = append(names, fmt.Sprintf("%s_%d", prefix, i))
+		}
+	}
+	field("user", si.UserProfileRange, userFeatureNames)
+	ubWidth := si.UserBehaviorRange[1] - si.UserBehaviorRange[0]
+	for i := 0; i < ubWidth; i++ {
+		names = append(names, fmt.Sprintf("behavior_%d_emb_%d", i/ItemEmbDim, i%ItemEmbDim))
+	}
+	field("item_emb", si.ItemFeatureRange, nil)
+	// non embedding item feature is the ctx feature
+	field("item", si.CtxFeatureRange, itemFeatureNames)
+	field("bias_row", si.IdRange, []string{"user_bias_row", "item_bias_row"})
+	return names
+}
+
+// DebugFeature returns the assembled feature vector of sampleKey with the
+// column names, and the score predicted by recSys.
+func DebugFeature(ctx context.Context, recSys Predictor, sampleKey Sample) (result *FeatureDebugResult, err error) {
+	assembler := servingAssembler(ctx, recSys)
+	vec, uWidth, iWidth, err := assembler.Assemble(ctx, &sampleKey)
+	if err != nil {
+		return
+	}
+	si, err := assembler.SampleInfo(uWidth, iWidth)
+	if err != nil {
+		return
+	}
+	var userNames, itemNames []string
+	if namer, ok := recSys.(FeatureNamer); ok {
+		userNames, itemNames = namer.UserFeatureNames(), namer.ItemFeatureNames()
+	}
+	itemNames = ctxFeatureNames(itemNames, iWidth, featureFuncInfos(assembler.featureFuncs))
+	names := FeatureColumnNames(si, userNames, itemNames)
+
+	scores, contributions, err := Breakdown(ctx, recSys, []Sample{sampleKey})
+	if err != nil {
+		return
+	}
+
+	result = &FeatureDebugResult{
+		UserId:        sampleKey.UserId,
+		ItemId:        sampleKey.ItemId,
+		Timestamp:     sampleKey.Timestamp,
+		SampleInfo:    *si,
+		Columns:       make([]FeatureColumn, len(vec)),
+		Score:         scores[0],
+		Contributions: contributions[0],
+	}
+	for i, v := range vec {
+		result.Columns[i] = FeatureColumn{Name: names[i], Value: v}
+	}
+	return
+}
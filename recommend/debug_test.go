@@ -0,0 +1,47 @@
+package recommend
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// sumPredictor scores every sample by the sum of its features
+type sumPredictor struct {
+	fakeProvider
+}
+
+func (p *sumPredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	rows, cols := X.Shape()[0], X.Shape()[1]
+	data := X.Data().([]float32)
+	y := make([]float32, rows)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			y[i] += data[i*cols+j]
+		}
+	}
+	return tensor.New(tensor.WithShape(rows, 1), tensor.WithBacking(y))
+}
+
+func (p *sumPredictor) UserFeatureNames() []string {
+	return []string{"id", "bias"}
+}
+
+func (p *sumPredictor) ItemFeatureNames() []string {
+	return nil
+}
+
+func TestDebugFeature(t *testing.T) {
+	Convey("debug feature", t, func() {
+		result, err := DebugFeature(context.Background(), &sumPredictor{}, Sample{UserId: 3, ItemId: 5})
+		So(err, ShouldBeNil)
+		So(result.Columns, ShouldHaveLength, result.SampleInfo.Width())
+		So(result.Columns[0], ShouldResemble, FeatureColumn{Name: "id", Value: 3})
+		So(result.Columns[1], ShouldResemble, FeatureColumn{Name: "bias", Value: 1})
+		So(result.Columns[2].Name, ShouldEqual, "behavior_0_emb_0")
+		So(result.Columns[len(result.Columns)-1], ShouldResemble, FeatureColumn{Name: "item_0", Value: 5})
+		So(result.Score, ShouldEqual, 9)
+	})
+}
@@ -0,0 +1,284 @@
+// Package drift monitors the live score and feature distributions against
+// the training time baselines. The distributions are binned by the baseline
+// quantiles and compared by the PSI (population stability index) and the KL
+// divergence, a drift over the Thresholds raises the alert, e.g. to trigger
+// the retrain Scheduler.
+package drift
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultBins is the quantile bins of a Baseline
+	DefaultBins = 10
+	// DefaultWindow is the live events compared with the baselines at once
+	DefaultWindow = 10000
+	// smoothing is added to every bin proportion to keep PSI and KL finite
+	smoothing = 1e-4
+)
+
+// Baseline is the distribution of a value at training time. Edges are the
+// upper bounds of the bins but the last, which is open.
+type Baseline struct {
+	Name        string    `json:"name"`
+	Edges       []float32 `json:"edges"`
+	Proportions []float64 `json:"proportions"`
+}
+
+// NewBaseline bins values by their bins quantiles, the NaN values are
+// ignored. The bins of the repeated quantiles are merged.
+func NewBaseline(name string, values []float32, bins int) *Baseline {
+	if bins <= 0 {
+		bins = DefaultBins
+	}
+	sorted := make([]float32, 0, len(values))
+	for _, v := range values {
+		if !math.IsNaN(float64(v)) {
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	b := &Baseline{Name: name}
+	if len(sorted) != 0 {
+		for i := 1; i < bins; i++ {
+			edge := sorted[(len(sorted)-1)*i/bins]
+			if n := len(b.Edges); n == 0 || edge > b.Edges[n-1] {
+				b.Edges = append(b.Edges, edge)
+			}
+		}
+	}
+	b.Proportions = proportions(b.counts(sorted))
+	return b
+}
+
+// bin returns the index of the bin of v
+func (b *Baseline) bin(v float32) int {
+	return sort.Search(len(b.Edges), func(i int) bool { return v <= b.Edges[i] })
+}
+
+func (b *Baseline) counts(values []float32) []int {
+	counts := make([]int, len(b.Edges)+1)
+	for _, v := range values {
+		if !math.IsNaN(float64(v)) {
+			counts[b.bin(v)]++
+		}
+	}
+	return counts
+}
+
+func observed(counts []int) bool {
+	for _, c := range counts {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func proportions(counts []int) []float64 {
+	var total int
+	for _, c := range counts {
+		total += c
+	}
+	p := make([]float64, len(counts))
+	for i, c := range counts {
+		if total != 0 {
+			p[i] = float64(c) / float64(total)
+		}
+	}
+	return p
+}
+
+// PSI is the population stability index of the actual proportions against
+// the expected: sum((a - e) * ln(a / e)). Below 0.1 is usually stable, above
+// 0.25 is a significant shift.
+func PSI(expected, actual []float64) (psi float64) {
+	for i := range expected {
+		e, a := expected[i]+smoothing, actual[i]+smoothing
+		psi += (a - e) * math.Log(a/e)
+	}
+	return
+}
+
+// KL is the Kullback-Leibler divergence of the actual proportions from the
+// expected: sum(a * ln(a / e))
+func KL(expected, actual []float64) (kl float64) {
+	for i := range expected {
+		e, a := expected[i]+smoothing, actual[i]+smoothing
+		kl += a * math.Log(a/e)
+	}
+	return
+}
+
+// Baselines are the training time baselines of the score and the features
+type Baselines struct {
+	Score    *Baseline   `json:"score"`
+	Features []*Baseline `json:"features"`
+}
+
+// FitBaselines builds the baselines of every feature column of sample and of
+// the model scores on it. names are the column names, see
+// rcmd.FeatureColumnNames, or nil for "col_i".
+func FitBaselines(sample *rcmd.TrainSample, scores []float32, names []string, bins int) *Baselines {
+	b := &Baselines{
+		Score:    NewBaseline("score", scores, bins),
+		Features: make([]*Baseline, sample.XCols),
+	}
+	column := make([]float32, sample.Rows)
+	for j := 0; j < sample.XCols; j++ {
+		for i := range column {
+			column[i] = sample.X[i*sample.XCols+j]
+		}
+		name := fmt.Sprintf("col_%d", j)
+		if j < len(names) {
+			name = names[j]
+		}
+		b.Features[j] = NewBaseline(name, column, bins)
+	}
+	return b
+}
+
+// Thresholds are the max drift allowed, zero disables the check
+type Thresholds struct {
+	MaxScorePSI   float64 `json:"maxScorePSI" yaml:"maxScorePSI"`
+	MaxScoreKL    float64 `json:"maxScoreKL" yaml:"maxScoreKL"`
+	MaxFeaturePSI float64 `json:"maxFeaturePSI" yaml:"maxFeaturePSI"`
+}
+
+// DefaultThresholds alert on the significant shift of the score or any
+// feature
+func DefaultThresholds() Thresholds {
+	return Thresholds{MaxScorePSI: 0.25, MaxFeaturePSI: 0.25}
+}
+
+// Drift is the drift of a value
+type Drift struct {
+	Name string  `json:"name"`
+	PSI  float64 `json:"psi"`
+	KL   float64 `json:"kl"`
+}
+
+// Report is the drift of a window of the live events
+type Report struct {
+	Events   int     `json:"events"`
+	Score    Drift   `json:"score"`
+	Features []Drift `json:"features"`
+	// Violations are the drifts over the Thresholds
+	Violations []string `json:"violations,omitempty"`
+}
+
+// Drifted is true if any drift is over the Thresholds
+func (r *Report) Drifted() bool {
+	return len(r.Violations) != 0
+}
+
+// Monitor compares every Window live events with the Baselines, Alert is
+// called with the Report if drifted.
+type Monitor struct {
+	Baselines  *Baselines
+	Thresholds Thresholds
+	// Window is the live events of a Report, DefaultWindow if 0
+	Window int
+	// Alert is called on drift, e.g. to log, notify or trigger the retrain
+	// Scheduler. Without it the drift is logged.
+	Alert func(report Report)
+
+	mu            sync.Mutex
+	events        int
+	scoreCounts   []int
+	featureCounts [][]int
+	last          *Report
+}
+
+// Observe records the feature vector x and the score of a live event, x may
+// be nil to monitor the score only
+func (m *Monitor) Observe(x []float32, score float32) {
+	m.mu.Lock()
+	if m.scoreCounts == nil {
+		m.reset()
+	}
+	if !math.IsNaN(float64(score)) {
+		m.scoreCounts[m.Baselines.Score.bin(score)]++
+	}
+	for j, v := range x {
+		if j < len(m.featureCounts) && !math.IsNaN(float64(v)) {
+			m.featureCounts[j][m.Baselines.Features[j].bin(v)]++
+		}
+	}
+	m.events++
+	var report *Report
+	if m.events >= m.window() {
+		report = m.report()
+		m.reset()
+	}
+	m.mu.Unlock()
+
+	if report != nil && report.Drifted() {
+		if m.Alert != nil {
+			m.Alert(*report)
+		} else {
+			log.Warnf("drift detected on %d events: %v", report.Events, report.Violations)
+		}
+	}
+}
+
+// Last returns the report of the last full window, nil if none
+func (m *Monitor) Last() *Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+func (m *Monitor) window() int {
+	if m.Window <= 0 {
+		return DefaultWindow
+	}
+	return m.Window
+}
+
+func (m *Monitor) reset() {
+	m.events = 0
+	m.scoreCounts = make([]int, len(m.Baselines.Score.Proportions))
+	m.featureCounts = make([][]int, len(m.Baselines.Features))
+	for j, b := range m.Baselines.Features {
+		m.featureCounts[j] = make([]int, len(b.Proportions))
+	}
+}
+
+func (m *Monitor) report() *Report {
+	var (
+		t      = m.Thresholds
+		report = &Report{Events: m.events}
+		score  = m.Baselines.Score
+		actual = proportions(m.scoreCounts)
+	)
+	report.Score = Drift{Name: score.Name, PSI: PSI(score.Proportions, actual), KL: KL(score.Proportions, actual)}
+	if t.MaxScorePSI > 0 && report.Score.PSI > t.MaxScorePSI {
+		report.Violations = append(report.Violations, fmt.Sprintf("score psi %.4f > %v", report.Score.PSI, t.MaxScorePSI))
+	}
+	if t.MaxScoreKL > 0 && report.Score.KL > t.MaxScoreKL {
+		report.Violations = append(report.Violations, fmt.Sprintf("score kl %.4f > %v", report.Score.KL, t.MaxScoreKL))
+	}
+	report.Features = make([]Drift, len(m.featureCounts))
+	for j, b := range m.Baselines.Features {
+		report.Features[j].Name = b.Name
+		if !observed(m.featureCounts[j]) {
+			continue
+		}
+		actual = proportions(m.featureCounts[j])
+		d := Drift{Name: b.Name, PSI: PSI(b.Proportions, actual), KL: KL(b.Proportions, actual)}
+		report.Features[j] = d
+		if t.MaxFeaturePSI > 0 && d.PSI > t.MaxFeaturePSI {
+			report.Violations = append(report.Violations, fmt.Sprintf("%s psi %.4f > %v", d.Name, d.PSI, t.MaxFeaturePSI))
+		}
+	}
+	m.last = report
+	return report
+}
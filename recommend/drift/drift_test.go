@@ -0,0 +1,84 @@
+package drift
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDrift(t *testing.T) {
+	Convey("quantile baseline", t, func() {
+		values := make([]float32, 100)
+		for i := range values {
+			values[i] = float32(i)
+		}
+		b := NewBaseline("x", values, 4)
+		So(b.Edges, ShouldResemble, []float32{24, 49, 74})
+		So(b.Proportions, ShouldResemble, []float64{0.25, 0.25, 0.25, 0.25})
+		So(b.bin(-1), ShouldEqual, 0)
+		So(b.bin(49), ShouldEqual, 1)
+		So(b.bin(1000), ShouldEqual, 3)
+
+		// the repeated quantiles are merged
+		b = NewBaseline("binary", []float32{0, 0, 0, 1, float32(math.NaN())}, 4)
+		So(b.Edges, ShouldResemble, []float32{0})
+		So(b.Proportions, ShouldResemble, []float64{0.75, 0.25})
+	})
+
+	Convey("psi and kl", t, func() {
+		p := []float64{0.25, 0.25, 0.25, 0.25}
+		So(PSI(p, p), ShouldEqual, 0)
+		So(KL(p, p), ShouldEqual, 0)
+		q := []float64{0.1, 0.2, 0.3, 0.4}
+		So(PSI(p, q), ShouldAlmostEqual, 0.2282, 1e-3)
+		So(KL(p, q), ShouldAlmostEqual, 0.1064, 1e-3)
+		So(math.IsInf(PSI(p, []float64{1, 0, 0, 0}), 0), ShouldBeFalse)
+	})
+
+	Convey("monitor alerts on drift", t, func() {
+		rnd := rand.New(rand.NewSource(42))
+		sample := &rcmd.TrainSample{Rows: 1000, XCols: 2, X: make([]float32, 2000)}
+		scores := make([]float32, 1000)
+		for i := 0; i < 1000; i++ {
+			sample.X[i*2], sample.X[i*2+1] = rnd.Float32(), rnd.Float32()
+			scores[i] = rnd.Float32()
+		}
+		baselines := FitBaselines(sample, scores, []string{"age"}, 0)
+		So(baselines.Features[0].Name, ShouldEqual, "age")
+		So(baselines.Features[1].Name, ShouldEqual, "col_1")
+
+		var alerts []Report
+		m := &Monitor{
+			Baselines:  baselines,
+			Thresholds: DefaultThresholds(),
+			Window:     500,
+			Alert:      func(r Report) { alerts = append(alerts, r) },
+		}
+		for i := 0; i < 500; i++ {
+			m.Observe([]float32{rnd.Float32(), rnd.Float32()}, rnd.Float32())
+		}
+		So(alerts, ShouldBeEmpty)
+		So(m.Last().Events, ShouldEqual, 500)
+		So(m.Last().Score.PSI, ShouldBeLessThan, 0.1)
+
+		// the second feature and the score shift to the upper half
+		for i := 0; i < 500; i++ {
+			m.Observe([]float32{rnd.Float32(), 0.5 + rnd.Float32()/2}, 0.5+rnd.Float32()/2)
+		}
+		So(alerts, ShouldHaveLength, 1)
+		So(alerts[0].Violations, ShouldHaveLength, 2)
+		So(alerts[0].Violations[0], ShouldStartWith, "score psi")
+		So(alerts[0].Violations[1], ShouldStartWith, "col_1 psi")
+		So(alerts[0].Features[0].PSI, ShouldBeLessThan, 0.1)
+
+		// the score only
+		m.Window = 10
+		for i := 0; i < 10; i++ {
+			m.Observe(nil, rnd.Float32())
+		}
+		So(m.Last().Features[1], ShouldResemble, Drift{Name: "col_1"})
+	})
+}
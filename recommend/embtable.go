@@ -0,0 +1,132 @@
+package recommend
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/auxten/go-ctr/feature/embedding/emb"
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+)
+
+// embeddingMu guards the swaps of the item embedding maps by
+// UpdateItemEmbeddings, the maps are never modified in place
+var embeddingMu sync.RWMutex
+
+// ItemEmbeddingsProvider is the Predictor of its own item embeddings, e.g.
+// the one of Train of the ItemEmbedding RecSys. The tenants serve the item
+// embeddings of their Predictors.
+type ItemEmbeddingsProvider interface {
+	// ItemEmbeddings must not be modified
+	ItemEmbeddings() word2vec.EmbeddingMap32
+}
+
+// WriteEmbeddings writes the embedding table in the text format of one
+// "id v1 v2 ..." line per row ordered by id, which is read by ReadEmbeddings
+// and the emb package.
+func WriteEmbeddings(w io.Writer, embMap word2vec.EmbeddingMap32) (err error) {
+	ids := make([]string, 0, len(embMap))
+	for id := range embMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	bw := bufio.NewWriter(w)
+	for _, id := range ids {
+		if _, err = bw.WriteString(id); err != nil {
+			return
+		}
+		for _, v := range embMap[id] {
+			if err = bw.WriteByte(' '); err != nil {
+				return
+			}
+			if _, err = bw.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32)); err != nil {
+				return
+			}
+		}
+		if err = bw.WriteByte('\n'); err != nil {
+			return
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadEmbeddings reads the embedding table written by WriteEmbeddings, all
+// the rows should be of dim
+func ReadEmbeddings(r io.Reader, dim int) (embMap word2vec.EmbeddingMap32, err error) {
+	embs, err := emb.Load(r)
+	if err != nil {
+		return nil, fmt.Errorf("read embeddings: %v", err)
+	}
+	embMap = make(word2vec.EmbeddingMap32, len(embs))
+	for _, e := range embs {
+		if e.Dim != dim {
+			return nil, fmt.Errorf("embedding of %s dim %d != %d", e.Word, e.Dim, dim)
+		}
+		vec := make([]float32, dim)
+		for i, v := range e.Vector {
+			vec[i] = float32(v)
+		}
+		embMap[e.Word] = vec
+	}
+	return
+}
+
+// ItemEmbeddings returns the item embeddings served in ctx, of the tenant of
+// ctx if any or else trained by the last Train. It must not be modified.
+func ItemEmbeddings(ctx context.Context) word2vec.EmbeddingMap32 {
+	embeddingMu.RLock()
+	defer embeddingMu.RUnlock()
+	if t := TenantFromContext(ctx); t != nil {
+		return t.itemEmbeddings()
+	}
+	return itemEmbeddingMap
+}
+
+// ExportItemEmbeddings writes the item embeddings served in ctx by
+// WriteEmbeddings, the ids are the item ids
+func ExportItemEmbeddings(ctx context.Context, w io.Writer) error {
+	return WriteEmbeddings(w, ItemEmbeddings(ctx))
+}
+
+// UpdateItemEmbeddings imports rows into the item embeddings served in ctx
+// without retraining, e.g. the new items initialized from their content
+// embeddings. The existing items are overwritten only if overwrite. The
+// updated item count is returned. The cached item features are not
+// invalidated, the items already served keep the old embeddings until
+// evicted.
+func UpdateItemEmbeddings(ctx context.Context, rows word2vec.EmbeddingMap32, overwrite bool) (updated int, err error) {
+	for id, vec := range rows {
+		if len(vec) != ItemEmbDim {
+			return 0, fmt.Errorf("embedding of item %s dim %d != %d", id, len(vec), ItemEmbDim)
+		}
+	}
+	embeddingMu.Lock()
+	defer embeddingMu.Unlock()
+	t := TenantFromContext(ctx)
+	current := itemEmbeddingMap
+	if t != nil {
+		current = t.itemEmbeddings()
+	}
+	// copy on write, the assemblers in flight keep reading the old map
+	next := make(word2vec.EmbeddingMap32, len(current)+len(rows))
+	for id, vec := range current {
+		next[id] = vec
+	}
+	for id, vec := range rows {
+		if _, ok := next[id]; ok && !overwrite {
+			continue
+		}
+		next[id] = append([]float32(nil), vec...)
+		updated++
+	}
+	if t != nil {
+		t.itemEmbeddingMap = next
+	} else {
+		itemEmbeddingMap = next
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package recommend
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// embeddingPredictor is the Predictor of its own item embeddings
+type embeddingPredictor struct {
+	constPredictor
+	embeddings word2vec.EmbeddingMap32
+}
+
+func (p *embeddingPredictor) ItemEmbeddings() word2vec.EmbeddingMap32 {
+	return p.embeddings
+}
+
+func TestEmbeddingTable(t *testing.T) {
+	emb := func(v float32) []float32 {
+		vec := make([]float32, ItemEmbDim)
+		vec[0] = v
+		return vec
+	}
+
+	Convey("export and import the embedding table", t, func() {
+		var buf bytes.Buffer
+		So(WriteEmbeddings(&buf, word2vec.EmbeddingMap32{"2": {-0.5, 1e-3}, "10": {0.25, 3}}), ShouldBeNil)
+		So(buf.String(), ShouldEqual, "10 0.25 3\n2 -0.5 0.001\n")
+		embMap, err := ReadEmbeddings(bytes.NewReader(buf.Bytes()), 2)
+		So(err, ShouldBeNil)
+		So(embMap, ShouldResemble, word2vec.EmbeddingMap32{"2": {-0.5, 1e-3}, "10": {0.25, 3}})
+		_, err = ReadEmbeddings(bytes.NewReader(buf.Bytes()), 3)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("update the item embeddings without retraining", t, func() {
+		itemEmbeddingMap = word2vec.EmbeddingMap32{"1": emb(1), "2": emb(2)}
+		defer func() { itemEmbeddingMap = nil }()
+		var (
+			ctx       = context.Background()
+			tenant    = NewTenant("t", &embeddingPredictor{embeddings: word2vec.EmbeddingMap32{"1": emb(1), "2": emb(-2)}})
+			tenantCtx = WithTenant(ctx, tenant)
+			trained   = ItemEmbeddings(ctx)
+		)
+		updated, err := UpdateItemEmbeddings(ctx, word2vec.EmbeddingMap32{"2": emb(20), "3": emb(3)}, false)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 1)
+		So(ItemEmbeddings(ctx)["2"], ShouldResemble, emb(2))
+		So(ItemEmbeddings(ctx)["3"], ShouldResemble, emb(3))
+		// the old map is never modified
+		So(trained, ShouldHaveLength, 2)
+
+		updated, err = UpdateItemEmbeddings(tenantCtx, word2vec.EmbeddingMap32{"2": emb(20)}, true)
+		So(err, ShouldBeNil)
+		So(updated, ShouldEqual, 1)
+		So(ItemEmbeddings(tenantCtx)["2"], ShouldResemble, emb(20))
+		So(ItemEmbeddings(tenantCtx)["1"], ShouldResemble, emb(1))
+		So(ItemEmbeddings(ctx)["2"], ShouldResemble, emb(2))
+		// the tenant of no item embeddings of its own shares none
+		So(ItemEmbeddings(WithTenant(ctx, NewTenant("u", &constPredictor{}))), ShouldBeEmpty)
+
+		record, err := servingAssembler(tenantCtx, &fakeProvider{}).Record(tenantCtx, &Sample{UserId: 1, ItemId: 2})
+		So(err, ShouldBeNil)
+		So(record.ItemEmb, ShouldResemble, emb(20))
+
+		_, err = UpdateItemEmbeddings(ctx, word2vec.EmbeddingMap32{"4": {1}}, true)
+		So(err, ShouldNotBeNil)
+
+		var buf bytes.Buffer
+		So(ExportItemEmbeddings(tenantCtx, &buf), ShouldBeNil)
+		embMap, err := ReadEmbeddings(&buf, ItemEmbDim)
+		So(err, ShouldBeNil)
+		So(embMap, ShouldHaveLength, 2)
+	})
+}
@@ -0,0 +1,60 @@
+// Package encoding is the feature encoding shared by the serving assembler
+// and the client SDK, so the features encoded on the devices are exactly the
+// ones of the server. It depends on the standard library only, to be built
+// by gomobile.
+package encoding
+
+import "math"
+
+const (
+	// ItemEmbDim is the dim of the item embeddings
+	ItemEmbDim = 16
+	// UserBehaviorLen is the max items of the user behavior sequence
+	UserBehaviorLen = 10
+)
+
+// BehaviorTensor encodes the first UserBehaviorLen items of itemSeq by their
+// embeddings of emb, the items of no embedding and the empty tail are zeros
+func BehaviorTensor(itemSeq []int, emb func(itemId int) ([]float32, bool)) []float32 {
+	t := make([]float32, ItemEmbDim*UserBehaviorLen)
+	for i, itemId := range itemSeq {
+		if i >= UserBehaviorLen {
+			break
+		}
+		if e, ok := emb(itemId); ok {
+			copy(t[i*ItemEmbDim:], e)
+		}
+	}
+	return t
+}
+
+// PrependBehaviors returns the behavior sequence of the recent items, the
+// latest first, prepended to itemSeq without duplicates
+func PrependBehaviors(recent []int, itemSeq []int) []int {
+	if len(recent) == 0 {
+		return itemSeq
+	}
+	seen := make(map[int]struct{}, len(recent))
+	seq := make([]int, 0, len(recent)+len(itemSeq))
+	for _, id := range recent {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			seq = append(seq, id)
+		}
+	}
+	for _, id := range itemSeq {
+		if _, ok := seen[id]; !ok {
+			seq = append(seq, id)
+		}
+	}
+	return seq
+}
+
+// CountFeature is the log event count and the smoothed positive rate, the
+// user and item features of the edgerec quickstart
+func CountFeature(events, positives int) []float32 {
+	return []float32{
+		float32(math.Log1p(float64(events))),
+		float32(positives+1) / float32(events+2),
+	}
+}
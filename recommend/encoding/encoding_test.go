@@ -0,0 +1,45 @@
+package encoding
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncoding(t *testing.T) {
+	Convey("behavior tensor", t, func() {
+		emb := func(itemId int) ([]float32, bool) {
+			if itemId%2 != 0 {
+				return nil, false
+			}
+			e := make([]float32, ItemEmbDim)
+			for i := range e {
+				e[i] = float32(itemId)
+			}
+			return e, true
+		}
+		seq := make([]int, UserBehaviorLen+1)
+		for i := range seq {
+			seq[i] = i + 1
+		}
+		tensor := BehaviorTensor(seq, emb)
+		So(tensor, ShouldHaveLength, ItemEmbDim*UserBehaviorLen)
+		So(tensor[:ItemEmbDim], ShouldResemble, make([]float32, ItemEmbDim))
+		e, _ := emb(2)
+		So(tensor[ItemEmbDim:2*ItemEmbDim], ShouldResemble, e)
+		So(BehaviorTensor(nil, emb), ShouldResemble, make([]float32, ItemEmbDim*UserBehaviorLen))
+	})
+
+	Convey("prepend behaviors", t, func() {
+		So(PrependBehaviors(nil, []int{1, 2}), ShouldResemble, []int{1, 2})
+		So(PrependBehaviors([]int{3, 2}, []int{1, 2}), ShouldResemble, []int{3, 2, 1})
+	})
+
+	Convey("count feature", t, func() {
+		So(CountFeature(0, 0), ShouldResemble, []float32{0, 0.5})
+		f := CountFeature(3, 1)
+		So(f[0], ShouldAlmostEqual, math.Log(4), 1e-6)
+		So(f[1], ShouldEqual, float32(0.4))
+	})
+}
@@ -0,0 +1,319 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/auxten/go-ctr/recommend/ann"
+	"github.com/chewxy/math32"
+	log "github.com/sirupsen/logrus"
+	"gorgonia.org/tensor"
+)
+
+// the explanation kinds, also the ExplainConfig.Sources
+const (
+	// ExplainAttention is by the behavior item of the highest attention
+	// weight for the item
+	ExplainAttention = "attention"
+	// ExplainSimilar is by the behavior item most similar to the item by
+	// ExplainConfig.Similar, e.g. the item-item CF
+	ExplainSimilar = "similar"
+	// ExplainCategory is by the category of the item matching the categories
+	// of the behavior items
+	ExplainCategory = "category"
+
+	// DefaultExplainNeighbors is the similar items of an item searched for
+	// the behavior items
+	DefaultExplainNeighbors = 100
+)
+
+// DefaultExplainTemplates is the text of the explanations by kind, %s is the
+// title of the behavior item by WithItemMetadata or its id, or the category
+var DefaultExplainTemplates = map[string]string{
+	ExplainAttention: "Because you watched %s",
+	ExplainSimilar:   "Similar to %s",
+	ExplainCategory:  "Because you like %s",
+}
+
+// Explanation is a human-readable reason of a recommended item
+type Explanation struct {
+	Kind string `json:"kind"`
+	// ItemId is the behavior item of the ExplainAttention and ExplainSimilar
+	ItemId int `json:"itemId,omitempty"`
+	// Category is the matched category of ExplainCategory
+	Category string `json:"category,omitempty"`
+	// Weight is the attention weight, the similarity or the share of the
+	// behavior items in the category
+	Weight float32 `json:"weight"`
+	Text   string  `json:"text"`
+}
+
+// AttentionWeighter is implemented by the Predictor of an attention model,
+// e.g. DIN, returning the attention weights of the behavior items itemSeq for
+// itemId. Without it the weights are the softmax of the dot products of the
+// item embeddings.
+type AttentionWeighter interface {
+	AttentionWeights(ctx context.Context, userId int, itemSeq []int, itemId int) ([]float32, error)
+}
+
+// BehaviorAttention could be implemented by the PredictAbstract of an
+// attention model, e.g. the DIN of model.Fitter, returning the attention
+// weights [rows, UserBehaviorLen] of the user behaviors of the sample vectors
+// X. The Predictor of Train implements AttentionWeighter by it.
+type BehaviorAttention interface {
+	BehaviorAttention(X tensor.Tensor) (tensor.Tensor, error)
+}
+
+// SimilarItems is the similar items of an item, the most similar first, e.g.
+// cf.ItemCF and cf.LiveItemCF
+type SimilarItems interface {
+	Similar(itemId, k int) []ann.Neighbor
+}
+
+// ExplainConfig configures the explanations, the zero values are the
+// defaults
+type ExplainConfig struct {
+	// Sources is the explanation kinds tried in order, all by default
+	Sources []string `json:"sources" yaml:"sources"`
+	// MaxPerItem is the max explanations of an item, 1 by default
+	MaxPerItem int `json:"max_per_item" yaml:"max_per_item"`
+	// CategoryKey is the item attribute of the category, ExplainCategory
+	// needs the Predictor to be an ItemAttributer
+	CategoryKey string `json:"category_key" yaml:"category_key"`
+	// Templates overrides DefaultExplainTemplates by kind
+	Templates map[string]string `json:"templates" yaml:"templates"`
+	// Similar is the similar items of ExplainSimilar, which is skipped if nil
+	Similar SimilarItems `json:"-" yaml:"-"`
+}
+
+// WithExplanations attaches ItemScore.Explanations to the items served of
+// the requests with RecApiRequest.Explain by conf. The explanations are by
+// the recent behavior of the user, the items of the users without behavior
+// are not explained.
+func WithExplanations(conf ExplainConfig) ApiOption {
+	return func(c *apiConfig) {
+		c.explainer = newExplainer(conf)
+	}
+}
+
+type explainer struct {
+	conf      ExplainConfig
+	templates map[string]string
+}
+
+func newExplainer(conf ExplainConfig) *explainer {
+	if len(conf.Sources) == 0 {
+		conf.Sources = []string{ExplainAttention, ExplainSimilar, ExplainCategory}
+	}
+	if conf.MaxPerItem <= 0 {
+		conf.MaxPerItem = 1
+	}
+	templates := make(map[string]string, len(DefaultExplainTemplates))
+	for kind, t := range DefaultExplainTemplates {
+		templates[kind] = t
+	}
+	for kind, t := range conf.Templates {
+		templates[kind] = t
+	}
+	return &explainer{conf: conf, templates: templates}
+}
+
+// explainRequest is the behavior of the user explained by
+type explainRequest struct {
+	ctx     context.Context
+	recSys  Predictor
+	userId  int
+	itemSeq []int
+	// categories is the behavior items by category, loaded on demand
+	categories map[string]int
+	err        error
+}
+
+// explain returns a copy of itemScores with the explanations attached, the
+// titles of the behavior items are by metadata if not nil
+func (e *explainer) explain(ctx context.Context, recSys Predictor, userId int, itemScores []ItemScore, metadata *metadataEnricher) []ItemScore {
+	ub, ok := recSys.(UserBehavior)
+	if !ok || len(itemScores) == 0 {
+		return itemScores
+	}
+	itemSeq, err := ub.GetUserBehavior(ctx, userId, UserBehaviorLen, -1, -1)
+	if err != nil {
+		log.Errorf("explain user %d behavior error: %v", userId, err)
+		return itemScores
+	}
+	itemSeq = feedbackBehavior(ctx, userId, itemSeq)
+	if len(itemSeq) > UserBehaviorLen {
+		itemSeq = itemSeq[:UserBehaviorLen]
+	}
+	if len(itemSeq) == 0 {
+		return itemScores
+	}
+	r := &explainRequest{ctx: ctx, recSys: recSys, userId: userId, itemSeq: itemSeq}
+	explained := make([]ItemScore, len(itemScores))
+	referred := make(map[int]struct{})
+	for i, is := range itemScores {
+		explained[i] = is
+		for _, kind := range e.conf.Sources {
+			if len(explained[i].Explanations) >= e.conf.MaxPerItem {
+				break
+			}
+			var (
+				ex Explanation
+				ok bool
+			)
+			switch kind {
+			case ExplainAttention:
+				ex, ok = e.attention(r, is.ItemId)
+			case ExplainSimilar:
+				ex, ok = e.similar(r, is.ItemId)
+			case ExplainCategory:
+				ex, ok = e.category(r, is.ItemId)
+			}
+			if !ok {
+				continue
+			}
+			ex.Kind = kind
+			if ex.ItemId != 0 {
+				referred[ex.ItemId] = struct{}{}
+			}
+			explained[i].Explanations = append(explained[i].Explanations, ex)
+		}
+	}
+	if r.err != nil {
+		log.Errorf("explain user %d error: %v", userId, r.err)
+	}
+
+	titles := make(map[int]string, len(referred))
+	if metadata != nil && len(referred) != 0 {
+		refs := make([]ItemScore, 0, len(referred))
+		for id := range referred {
+			refs = append(refs, ItemScore{ItemId: id})
+		}
+		for _, ref := range metadata.enrich(ctx, refs) {
+			if ref.Metadata != nil && ref.Metadata.Title != "" {
+				titles[ref.ItemId] = ref.Metadata.Title
+			}
+		}
+	}
+	for i := range explained {
+		for j := range explained[i].Explanations {
+			ex := &explained[i].Explanations[j]
+			arg := ex.Category
+			if ex.Kind != ExplainCategory {
+				if arg = titles[ex.ItemId]; arg == "" {
+					arg = "item " + strconv.Itoa(ex.ItemId)
+				}
+			}
+			ex.Text = fmt.Sprintf(e.templates[ex.Kind], arg)
+		}
+	}
+	return explained
+}
+
+// attention explains itemId by the behavior item of the highest weight
+func (e *explainer) attention(r *explainRequest, itemId int) (ex Explanation, ok bool) {
+	var weights []float32
+	if weighter, isWeighter := r.recSys.(AttentionWeighter); isWeighter {
+		var err error
+		if weights, err = weighter.AttentionWeights(r.ctx, r.userId, r.itemSeq, itemId); err != nil {
+			r.err = err
+			return
+		}
+		if len(weights) != len(r.itemSeq) {
+			r.err = fmt.Errorf("attention weights %d != behavior items %d", len(weights), len(r.itemSeq))
+			return
+		}
+	} else if weights = embeddingAttention(ItemEmbeddings(r.ctx), r.itemSeq, itemId); weights == nil {
+		return
+	}
+	for i, id := range r.itemSeq {
+		if id != itemId && weights[i] > 0 && weights[i] > ex.Weight {
+			ex.ItemId, ex.Weight, ok = id, weights[i], true
+		}
+	}
+	return
+}
+
+// embeddingAttention is the softmax of the dot products of the embeddings of
+// itemSeq and itemId, the items without embedding weigh 0. nil if itemId has
+// no embedding.
+func embeddingAttention(embeddings map[string][]float32, itemSeq []int, itemId int) []float32 {
+	item, ok := embeddings[strconv.Itoa(itemId)]
+	if !ok {
+		return nil
+	}
+	var (
+		weights = make([]float32, len(itemSeq))
+		found   = make([]bool, len(itemSeq))
+		top     = math32.Inf(-1)
+		sum     float32
+	)
+	for i, id := range itemSeq {
+		emb, ok := embeddings[strconv.Itoa(id)]
+		if !ok || len(emb) != len(item) {
+			continue
+		}
+		for j := range emb {
+			weights[i] += emb[j] * item[j]
+		}
+		found[i] = true
+		if weights[i] > top {
+			top = weights[i]
+		}
+	}
+	for i := range weights {
+		if !found[i] {
+			continue
+		}
+		weights[i] = math32.Exp(weights[i] - top)
+		sum += weights[i]
+	}
+	for i := range weights {
+		if found[i] {
+			weights[i] /= sum
+		}
+	}
+	return weights
+}
+
+// similar explains itemId by the most similar behavior item
+func (e *explainer) similar(r *explainRequest, itemId int) (ex Explanation, ok bool) {
+	if e.conf.Similar == nil {
+		return
+	}
+	seen := make(map[int]struct{}, len(r.itemSeq))
+	for _, id := range r.itemSeq {
+		seen[id] = struct{}{}
+	}
+	for _, n := range e.conf.Similar.Similar(itemId, DefaultExplainNeighbors) {
+		if _, in := seen[n.ItemId]; in && n.ItemId != itemId {
+			return Explanation{ItemId: n.ItemId, Weight: n.Score}, true
+		}
+	}
+	return
+}
+
+// category explains itemId by its category if any behavior item is of it
+func (e *explainer) category(r *explainRequest, itemId int) (ex Explanation, ok bool) {
+	if e.conf.CategoryKey == "" {
+		return
+	}
+	if _, isAttributer := r.recSys.(ItemAttributer); !isAttributer {
+		return
+	}
+	if r.categories == nil {
+		r.categories = make(map[string]int)
+		for _, id := range r.itemSeq {
+			category, err := itemCategory(r.ctx, r.recSys, e.conf.CategoryKey, id)
+			if err == nil {
+				r.categories[category]++
+			}
+		}
+	}
+	category, err := itemCategory(r.ctx, r.recSys, e.conf.CategoryKey, itemId)
+	if err != nil || r.categories[category] == 0 {
+		return
+	}
+	return Explanation{Category: category, Weight: float32(r.categories[category]) / float32(len(r.itemSeq))}, true
+}
@@ -0,0 +1,184 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/auxten/go-ctr/recommend/ann"
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// attentionPredictor weighs the behavior items 2 and 3 by 0.2 and 0.8
+type attentionPredictor struct {
+	feedbackPredictor
+}
+
+func (p *attentionPredictor) AttentionWeights(_ context.Context, _ int, itemSeq []int, _ int) ([]float32, error) {
+	return []float32{0.2, 0.8}, nil
+}
+
+// behaviorAttention weighs the behavior positions by 0.1, 0.2, ... and keeps
+// the sample vectors
+type behaviorAttention struct {
+	X []tensor.Tensor
+}
+
+func (a *behaviorAttention) BehaviorAttention(X tensor.Tensor) (tensor.Tensor, error) {
+	a.X = append(a.X, X)
+	weights := make([]float32, UserBehaviorLen)
+	for i := range weights {
+		weights[i] = float32(i+1) / 10
+	}
+	return tensor.New(tensor.WithShape(1, UserBehaviorLen), tensor.WithBacking(weights)), nil
+}
+
+type similarItems map[int][]ann.Neighbor
+
+func (s similarItems) Similar(itemId, k int) []ann.Neighbor {
+	return s[itemId]
+}
+
+func TestExplanations(t *testing.T) {
+	post := func(engine *gin.Engine, body string) (code int, resp RecApiResponse) {
+		req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	// explained returns the explanations by item
+	explained := func(resp RecApiResponse) map[int][]Explanation {
+		m := make(map[int][]Explanation)
+		for _, is := range resp.ItemScoreList {
+			m[is.ItemId] = is.Explanations
+		}
+		return m
+	}
+	// the behavior of the users is items 2 and 3, see fakeProvider
+	similar := similarItems{1: {{ItemId: 7, Score: 0.9}, {ItemId: 3, Score: 0.5}}}
+
+	Convey("explained by the attention, similar items and category", t, func() {
+		tenant := NewTenant(DefaultTenant, &feedbackPredictor{})
+		r := NewTenantRegistry()
+		So(r.Register(tenant), ShouldBeNil)
+		unit := func(i int) []float32 {
+			v := make([]float32, ItemEmbDim)
+			v[i] = 4
+			return v
+		}
+		_, err := UpdateItemEmbeddings(WithTenant(context.Background(), tenant), word2vec.EmbeddingMap32{
+			"2": unit(0), "3": unit(1), "4": unit(0), "5": unit(1),
+		}, true)
+		So(err, ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend",
+			WithExplanations(ExplainConfig{CategoryKey: "category", Similar: similar}))
+
+		code, resp := post(engine, `{"userId":1,"itemIdList":[1,4,5,6]}`)
+		So(code, ShouldEqual, 200)
+		So(explained(resp)[1], ShouldBeNil)
+
+		code, resp = post(engine, `{"userId":1,"itemIdList":[1,4,5,6],"explain":true}`)
+		So(code, ShouldEqual, 200)
+		ex := explained(resp)
+		So(ex[4], ShouldHaveLength, 1)
+		So(ex[4][0].Kind, ShouldEqual, ExplainAttention)
+		So(ex[4][0].ItemId, ShouldEqual, 2)
+		So(ex[4][0].Text, ShouldEqual, "Because you watched item 2")
+		So(ex[5][0].ItemId, ShouldEqual, 3)
+		So(ex[1], ShouldResemble, []Explanation{{Kind: ExplainSimilar, ItemId: 3, Weight: 0.5, Text: "Similar to item 3"}})
+		// item 6 of no embedding is of the category of item 2
+		So(ex[6], ShouldResemble, []Explanation{{Kind: ExplainCategory, Category: "sports", Weight: 0.5, Text: "Because you like sports"}})
+	})
+
+	Convey("attention of the model with the titles", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &attentionPredictor{})), ShouldBeNil)
+		titles := ItemMetadataFunc(func(_ context.Context, itemIds []int) (map[int]*ItemMetadata, error) {
+			metadata := make(map[int]*ItemMetadata)
+			for _, id := range itemIds {
+				metadata[id] = &ItemMetadata{Title: fmt.Sprintf("Movie %d", id)}
+			}
+			return metadata, nil
+		})
+		engine := newTenantEngine(r, "/api/v1/recommend",
+			WithItemMetadata(titles, MetadataConfig{}),
+			WithExplanations(ExplainConfig{
+				CategoryKey: "category",
+				MaxPerItem:  2,
+				Templates:   map[string]string{ExplainCategory: "More %s"},
+			}))
+
+		_, resp := post(engine, `{"userId":1,"itemIdList":[5],"explain":true}`)
+		So(resp.ItemScoreList, ShouldHaveLength, 1)
+		So(resp.ItemScoreList[0].Metadata.Title, ShouldEqual, "Movie 5")
+		ex := resp.ItemScoreList[0].Explanations
+		So(ex, ShouldHaveLength, 2)
+		So(ex[0].Text, ShouldEqual, "Because you watched Movie 3")
+		So(ex[0].Weight, ShouldEqual, float32(0.8))
+		So(ex[1].Text, ShouldEqual, "More news")
+	})
+
+	Convey("attention of the trained attention model", t, func() {
+		p := &feedbackPredictor{}
+		tenant := NewTenant(DefaultTenant, p)
+		r := NewTenantRegistry()
+		So(r.Register(tenant), ShouldBeNil)
+		ctx := WithTenant(context.Background(), tenant)
+		emb := make([]float32, ItemEmbDim)
+		emb[0] = 1
+		// item 3 of the behaviors has no embedding
+		_, err := UpdateItemEmbeddings(ctx, word2vec.EmbeddingMap32{"2": emb, "5": emb}, true)
+		So(err, ShouldBeNil)
+		attention := &behaviorAttention{}
+		var m Predictor = &attentionModel{
+			behaviorModel: &behaviorModel{
+				trainedModel: &trainedModel{UserFeaturer: p, ItemFeaturer: p, PredictAbstract: p},
+				UserBehavior: p,
+			},
+			attention: attention,
+		}
+		weighter, ok := m.(AttentionWeighter)
+		So(ok, ShouldBeTrue)
+		weights, err := weighter.AttentionWeights(ctx, 1, []int{3, 2}, 5)
+		So(err, ShouldBeNil)
+		So(weights, ShouldResemble, []float32{0, 0.2})
+		So(attention.X, ShouldHaveLength, 1)
+		// the behaviors of the vector are of itemSeq
+		vec := attention.X[0].Data().([]float32)
+		assembler := servingAssembler(ctx, p)
+		_, uWidth, iWidth, err := assembler.Assemble(ctx, &Sample{UserId: 1, ItemId: 5})
+		So(err, ShouldBeNil)
+		si, err := assembler.SampleInfo(uWidth, iWidth)
+		So(err, ShouldBeNil)
+		So(vec, ShouldHaveLength, si.Width())
+		behaviors := vec[si.UserBehaviorRange[0]:si.UserBehaviorRange[1]]
+		So(behaviors[:ItemEmbDim], ShouldResemble, make([]float32, ItemEmbDim))
+		So(behaviors[ItemEmbDim:2*ItemEmbDim], ShouldResemble, emb)
+		So(vec[si.ItemFeatureRange[0]:si.ItemFeatureRange[1]], ShouldResemble, emb)
+	})
+
+	Convey("explanations not enabled", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &feedbackPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend")
+		code, _ := post(engine, `{"userId":1,"itemIdList":[1],"explain":true}`)
+		So(code, ShouldEqual, 400)
+	})
+
+	Convey("attention of the embeddings", t, func() {
+		embeddings := map[string][]float32{"1": {1, 0}, "2": {1, 0}, "3": {0, 1}}
+		weights := embeddingAttention(embeddings, []int{2, 3, 4}, 1)
+		So(weights[0], ShouldBeGreaterThan, weights[1])
+		So(weights[0]+weights[1], ShouldAlmostEqual, 1, 1e-6)
+		So(weights[2], ShouldEqual, 0)
+		So(embeddingAttention(embeddings, []int{2}, 9), ShouldBeNil)
+	})
+}
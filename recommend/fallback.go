@@ -0,0 +1,123 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FallbackLoadShedding is set to RecApiResponse.Fallback when the full
+	// ranking is skipped by the LoadShedder
+	FallbackLoadShedding = "load_shedding"
+	// FallbackScorerError is set to RecApiResponse.Fallback when the full
+	// ranking failed, e.g. on a shape mismatch or a store timeout
+	FallbackScorerError = "scorer_error"
+)
+
+// PopularityRanker is implemented by the Predictor able to rank the items
+// by popularity, which is served instead of the full ranking on fallback.
+// Without it the items are kept in the requested (recall) order.
+type PopularityRanker interface {
+	PopularityRank(ctx context.Context, userId int, itemIds []int) ([]ItemScore, error)
+}
+
+type rankFunc func(ctx context.Context, recSys Predictor, userId int, itemIds []int) ([]ItemScore, error)
+
+// FallbackStats is the fallback metrics of the ranking requests
+type FallbackStats struct {
+	Requests int64 `json:"requests"`
+	// Fallbacks is the fallback count by reason
+	Fallbacks map[string]int64 `json:"fallbacks"`
+	// Rate is the ratio of the requests served by fallback
+	Rate float64 `json:"rate"`
+}
+
+// FallbackPolicy serves the popularity or recall ordered items instead of
+// an error when the full ranking fails, and counts the fallbacks
+type FallbackPolicy struct {
+	mu        sync.Mutex
+	requests  int64
+	fallbacks map[string]int64
+}
+
+func NewFallbackPolicy() *FallbackPolicy {
+	return &FallbackPolicy{fallbacks: make(map[string]int64)}
+}
+
+// Rank ranks itemIds by rank, on error or panic the fallback ranking is
+// returned with the reason FallbackScorerError, and cause is the error of
+// rank. The items are always returned.
+func (p *FallbackPolicy) Rank(ctx context.Context, rank rankFunc, recSys Predictor, userId int, itemIds []int,
+) (itemScores []ItemScore, fallback string, cause error) {
+	if itemScores, cause = safeRank(ctx, rank, recSys, userId, itemIds); cause == nil {
+		p.record("")
+		return
+	}
+	log.Warnf("rank user %d fallback: %v", userId, cause)
+	p.record(FallbackScorerError)
+	return fallbackRank(ctx, recSys, userId, itemIds, FallbackScorerError), FallbackScorerError, cause
+}
+
+// Shed returns the fallback ranking for the load shedding
+func (p *FallbackPolicy) Shed(ctx context.Context, recSys Predictor, userId int, itemIds []int) []ItemScore {
+	p.record(FallbackLoadShedding)
+	return fallbackRank(ctx, recSys, userId, itemIds, FallbackLoadShedding)
+}
+
+func (p *FallbackPolicy) record(fallback string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests++
+	if fallback != "" {
+		p.fallbacks[fallback]++
+	}
+}
+
+// Stats returns the fallback metrics since created
+func (p *FallbackPolicy) Stats() (stats FallbackStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats.Requests = p.requests
+	stats.Fallbacks = make(map[string]int64, len(p.fallbacks))
+	var fallbacks int64
+	for reason, cnt := range p.fallbacks {
+		stats.Fallbacks[reason] = cnt
+		fallbacks += cnt
+	}
+	if p.requests != 0 {
+		stats.Rate = float64(fallbacks) / float64(p.requests)
+	}
+	return
+}
+
+func safeRank(ctx context.Context, rank rankFunc, recSys Predictor, userId int, itemIds []int,
+) (itemScores []ItemScore, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			itemScores, err = nil, fmt.Errorf("rank panic: %v", r)
+		}
+	}()
+	return rank(ctx, recSys, userId, itemIds)
+}
+
+// fallbackRank ranks by PopularityRanker if implemented and succeeded, else
+// keeps the recall order with zero scores. The items are unscored by the
+// model of the fallback reason, see RecordUnscored.
+func fallbackRank(ctx context.Context, recSys Predictor, userId int, itemIds []int, fallback string) []ItemScore {
+	recordUnscoredItems(ctx, DropFallback, fallback, itemIds)
+	if ranker, ok := recSys.(PopularityRanker); ok {
+		itemScores, err := ranker.PopularityRank(ctx, userId, itemIds)
+		if err == nil {
+			return itemScores
+		}
+		log.Warnf("popularity rank user %d error: %v", userId, err)
+	}
+	itemScores := make([]ItemScore, len(itemIds))
+	for i, itemId := range itemIds {
+		itemScores[i] = ItemScore{ItemId: itemId}
+	}
+	return itemScores
+}
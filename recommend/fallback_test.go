@@ -0,0 +1,86 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// panicPredictor panics on scoring like a model fed the wrong shape, and
+// ranks by item id as the popularity
+type panicPredictor struct {
+	fakeProvider
+}
+
+func (p *panicPredictor) Predict(tensor.Tensor) tensor.Tensor {
+	panic("shape mismatch")
+}
+
+func (p *panicPredictor) PopularityRank(_ context.Context, _ int, itemIds []int) ([]ItemScore, error) {
+	itemScores := make([]ItemScore, len(itemIds))
+	for i, itemId := range itemIds {
+		itemScores[i] = ItemScore{ItemId: itemId, Score: float32(itemId)}
+	}
+	return itemScores, nil
+}
+
+func TestFallback(t *testing.T) {
+	Convey("fallback policy", t, func() {
+		var (
+			ctx = context.Background()
+			p   = NewFallbackPolicy()
+		)
+		itemScores, fallback, err := p.Rank(ctx, Rank, &constPredictor{score: 1}, 1, []int{3, 4})
+		So(err, ShouldBeNil)
+		So(fallback, ShouldEqual, "")
+		So(itemScores, ShouldResemble, []ItemScore{{ItemId: 3, Score: 1}, {ItemId: 4, Score: 1}})
+
+		// store error falls back to the recall order
+		itemScores, fallback, err = p.Rank(ctx, Rank, &constPredictor{score: 1}, -1, []int{3, 4})
+		So(err, ShouldNotBeNil)
+		So(fallback, ShouldEqual, FallbackScorerError)
+		So(itemScores, ShouldResemble, []ItemScore{{ItemId: 3}, {ItemId: 4}})
+
+		// panic falls back to the popularity
+		itemScores, fallback, err = p.Rank(ctx, Rank, &panicPredictor{}, 1, []int{3, 4})
+		So(err.Error(), ShouldContainSubstring, "shape mismatch")
+		So(fallback, ShouldEqual, FallbackScorerError)
+		So(itemScores, ShouldResemble, []ItemScore{{ItemId: 3, Score: 3}, {ItemId: 4, Score: 4}})
+
+		p.Shed(ctx, &panicPredictor{}, 1, []int{3})
+		So(p.Stats(), ShouldResemble, FallbackStats{
+			Requests:  4,
+			Fallbacks: map[string]int64{FallbackScorerError: 2, FallbackLoadShedding: 1},
+			Rate:      0.75,
+		})
+	})
+
+	Convey("fallback response of the http api", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &panicPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend")
+		req := httptest.NewRequest("POST", "/api/v1/recommend",
+			strings.NewReader(`{"userId":1,"itemIdList":[3,4]}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		var resp RecApiResponse
+		So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+		So(resp.Fallback, ShouldEqual, FallbackScorerError)
+		So(resp.ItemScoreList, ShouldHaveLength, 2)
+		So(r.Stats()[0].Errors, ShouldEqual, 1)
+
+		w = httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/fallback/stats", nil))
+		var stats FallbackStats
+		So(json.Unmarshal(w.Body.Bytes(), &stats), ShouldBeNil)
+		So(stats.Rate, ShouldEqual, 1)
+	})
+}
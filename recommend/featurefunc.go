@@ -0,0 +1,184 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FeatureFunc computes the domain-specific features of the sample of userId
+// and itemId, ctx is of the TrainStage or the PredictStage by StageKey. The
+// returned features should be of the width registered.
+type FeatureFunc func(ctx context.Context, userId, itemId int) []float64
+
+// FeatureFuncInfo is the name and width of a registered FeatureFunc
+type FeatureFuncInfo struct {
+	Name  string `json:"name"`
+	Width int    `json:"width"`
+	// Version of the encoding of the func, e.g. the hash of the vocabulary of
+	// a MultiValueFeature. The serving func must be of the trained version if
+	// not empty.
+	Version string `json:"version,omitempty"`
+}
+
+type featureFunc struct {
+	FeatureFuncInfo
+	fn FeatureFunc
+}
+
+var featureFuncs struct {
+	sync.RWMutex
+	funcs []featureFunc
+}
+
+// RegisterFeatureFunc registers fn of name and width for all the RecSys of
+// the process. The features of the registered funcs are appended to the ctx
+// feature of the training samples in the order registered. The Predictor
+// returned by Train records the funcs it's trained of, and the serving
+// vectors are assembled of them by name, so the funcs registered after Train
+// only take effect in the next Train.
+func RegisterFeatureFunc(name string, width int, fn FeatureFunc) error {
+	return registerFeatureFunc(FeatureFuncInfo{Name: name, Width: width}, fn)
+}
+
+func registerFeatureFunc(info FeatureFuncInfo, fn FeatureFunc) error {
+	name, width := info.Name, info.Width
+	if name == "" {
+		return fmt.Errorf("feature func name is empty")
+	}
+	if width <= 0 {
+		return fmt.Errorf("feature func %s width %d <= 0", name, width)
+	}
+	if fn == nil {
+		return fmt.Errorf("feature func %s is nil", name)
+	}
+	featureFuncs.Lock()
+	defer featureFuncs.Unlock()
+	for _, f := range featureFuncs.funcs {
+		if f.Name == name {
+			return fmt.Errorf("feature func %s already registered", name)
+		}
+	}
+	featureFuncs.funcs = append(featureFuncs.funcs, featureFunc{FeatureFuncInfo: info, fn: fn})
+	return nil
+}
+
+// UnregisterFeatureFunc removes the FeatureFunc of name if registered
+func UnregisterFeatureFunc(name string) {
+	featureFuncs.Lock()
+	defer featureFuncs.Unlock()
+	for i, f := range featureFuncs.funcs {
+		if f.Name == name {
+			featureFuncs.funcs = append(featureFuncs.funcs[:i:i], featureFuncs.funcs[i+1:]...)
+			return
+		}
+	}
+}
+
+// RegisteredFeatureFuncs returns the FeatureFuncs registered in order
+func RegisteredFeatureFuncs() []FeatureFuncInfo {
+	featureFuncs.RLock()
+	defer featureFuncs.RUnlock()
+	return featureFuncInfos(featureFuncs.funcs)
+}
+
+func featureFuncInfos(funcs []featureFunc) (infos []FeatureFuncInfo) {
+	for _, f := range funcs {
+		infos = append(infos, f.FeatureFuncInfo)
+	}
+	return
+}
+
+// registeredFeatureFuncs is the snapshot of the registered funcs
+func registeredFeatureFuncs() []featureFunc {
+	featureFuncs.RLock()
+	defer featureFuncs.RUnlock()
+	return append([]featureFunc(nil), featureFuncs.funcs...)
+}
+
+// lookupFeatureFuncs returns the registered funcs of infos, the width of
+// every func should be the same as registered
+func lookupFeatureFuncs(infos []FeatureFuncInfo) ([]featureFunc, error) {
+	featureFuncs.RLock()
+	defer featureFuncs.RUnlock()
+	funcs := make([]featureFunc, 0, len(infos))
+	for _, info := range infos {
+		var found bool
+		for _, f := range featureFuncs.funcs {
+			if f.Name != info.Name {
+				continue
+			}
+			if f.Width != info.Width {
+				return nil, fmt.Errorf("feature func %s width %d != trained %d", f.Name, f.Width, info.Width)
+			}
+			if info.Version != "" && f.Version != info.Version {
+				return nil, fmt.Errorf("feature func %s version %s != trained %s", f.Name, f.Version, info.Version)
+			}
+			funcs = append(funcs, f)
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("feature func %s of the model not registered", info.Name)
+		}
+	}
+	return funcs, nil
+}
+
+// customFeatures concatenates the features of funcs for userId and itemId
+func customFeatures(ctx context.Context, funcs []featureFunc, userId, itemId int) (Tensor, error) {
+	if len(funcs) == 0 {
+		return nil, nil
+	}
+	var width int
+	for _, f := range funcs {
+		width += f.Width
+	}
+	features := make(Tensor, 0, width)
+	for _, f := range funcs {
+		values := f.fn(ctx, userId, itemId)
+		if len(values) != f.Width {
+			return nil, fmt.Errorf("feature func %s of user %d item %d returned %d values != width %d",
+				f.Name, userId, itemId, len(values), f.Width)
+		}
+		for _, v := range values {
+			features = append(features, float32(v))
+		}
+	}
+	return features, nil
+}
+
+// featureFuncColumnNames names the columns of funcs, `name` of width 1 or
+// `name_i`
+func featureFuncColumnNames(funcs []FeatureFuncInfo) (names []string) {
+	for _, f := range funcs {
+		if f.Width == 1 {
+			names = append(names, f.Name)
+			continue
+		}
+		for i := 0; i < f.Width; i++ {
+			names = append(names, fmt.Sprintf("%s_%d", f.Name, i))
+		}
+	}
+	return
+}
+
+// ctxFeatureNames names the ctx feature columns of ctxWidth for
+// FeatureColumnNames, the item feature columns by itemNames or the index,
+// then the columns of funcs
+func ctxFeatureNames(itemNames []string, ctxWidth int, funcs []FeatureFuncInfo) []string {
+	custom := featureFuncColumnNames(funcs)
+	if len(custom) == 0 || len(custom) > ctxWidth {
+		return itemNames
+	}
+	itemWidth := ctxWidth - len(custom)
+	names := make([]string, 0, ctxWidth)
+	if len(itemNames) == itemWidth {
+		names = append(names, itemNames...)
+	} else {
+		for i := 0; i < itemWidth; i++ {
+			names = append(names, fmt.Sprintf("item_%d", i))
+		}
+	}
+	return append(names, custom...)
+}
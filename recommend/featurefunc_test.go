@@ -0,0 +1,88 @@
+package recommend
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sampleFitter keeps the TrainSample fitted
+type sampleFitter struct {
+	sample *TrainSample
+}
+
+func (f *sampleFitter) Fit(sample *TrainSample) (PredictAbstract, error) {
+	f.sample = sample
+	return &sumPredictor{}, nil
+}
+
+func TestFeatureFunc(t *testing.T) {
+	userCache, itemCache := UserFeatureCache, ItemFeatureCache
+	defer func() {
+		UserFeatureCache, ItemFeatureCache = userCache, itemCache
+	}()
+
+	// the user item sum and whether of the training
+	pair := func(ctx context.Context, userId, itemId int) []float64 {
+		var training float64
+		if stage, ok := ctx.Value(StageKey).(Stage); ok && stage == TrainStage {
+			training = 1
+		}
+		return []float64{float64(userId + itemId), training}
+	}
+
+	Convey("register", t, func() {
+		So(RegisterFeatureFunc("", 1, pair), ShouldNotBeNil)
+		So(RegisterFeatureFunc("pair", 0, pair), ShouldNotBeNil)
+		So(RegisterFeatureFunc("pair", 2, nil), ShouldNotBeNil)
+		So(RegisterFeatureFunc("pair", 2, pair), ShouldBeNil)
+		So(RegisterFeatureFunc("pair", 2, pair), ShouldNotBeNil)
+		So(RegisteredFeatureFuncs(), ShouldResemble, []FeatureFuncInfo{{Name: "pair", Width: 2}})
+		UnregisterFeatureFunc("pair")
+		So(RegisteredFeatureFuncs(), ShouldBeEmpty)
+	})
+
+	Convey("training and serving of the feature funcs", t, func() {
+		So(RegisterFeatureFunc("pair", 2, pair), ShouldBeNil)
+		defer UnregisterFeatureFunc("pair")
+
+		fitter := &sampleFitter{}
+		m, err := Train(context.Background(), &lineageRecSys{}, fitter)
+		So(err, ShouldBeNil)
+		sample := fitter.sample
+		// the item feature of width 1 and the pair
+		So(sample.Info.CtxFeatureRange[1]-sample.Info.CtxFeatureRange[0], ShouldEqual, 3)
+		for i := 0; i < sample.Rows; i++ {
+			row := sample.X[i*sample.XCols : (i+1)*sample.XCols]
+			So(row[sample.XCols-1], ShouldEqual, 1)
+			So(row[sample.XCols-2], ShouldEqual, row[0]+row[sample.XCols-3])
+		}
+		manifest := m.(ManifestProvider).Manifest()
+		So(manifest.FeatureFuncs, ShouldResemble, []FeatureFuncInfo{{Name: "pair", Width: 2}})
+		So(manifest.FeatureHash, ShouldNotEqual, FeatureHash(manifest.SampleInfo, &lineageRecSys{}))
+
+		result, err := DebugFeature(context.Background(), m, Sample{UserId: 3, ItemId: 5})
+		So(err, ShouldBeNil)
+		columns := result.Columns
+		So(columns[len(columns)-3], ShouldResemble, FeatureColumn{Name: "item_0", Value: 5})
+		So(columns[len(columns)-2], ShouldResemble, FeatureColumn{Name: "pair_0", Value: 8})
+		So(columns[len(columns)-1], ShouldResemble, FeatureColumn{Name: "pair_1", Value: 0})
+
+		// served by the funcs of the model
+		So(RegisterFeatureFunc("other", 1, pair), ShouldBeNil)
+		defer UnregisterFeatureFunc("other")
+		result, err = DebugFeature(context.Background(), m, Sample{UserId: 3, ItemId: 5})
+		So(err, ShouldBeNil)
+		So(result.Columns, ShouldHaveLength, len(columns))
+
+		UnregisterFeatureFunc("pair")
+		_, err = DebugFeature(context.Background(), m, Sample{UserId: 3, ItemId: 5})
+		So(err, ShouldNotBeNil)
+		So(RegisterFeatureFunc("pair", 3, func(context.Context, int, int) []float64 {
+			return []float64{1, 2, 3}
+		}), ShouldBeNil)
+		_, err = DebugFeature(context.Background(), m, Sample{UserId: 3, ItemId: 5})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,410 @@
+package recommend
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/auxten/go-ctr/recommend/encoding"
+	"github.com/gin-gonic/gin"
+)
+
+// FeedbackAction is the explicit feedback of a user on an item
+type FeedbackAction string
+
+const (
+	// Like adds the item to the head of the user behavior sequence
+	Like FeedbackAction = "like"
+	// Dislike and Hide remove the item from the later results of the user
+	Dislike FeedbackAction = "dislike"
+	Hide    FeedbackAction = "hide"
+	// BlockCategory removes the items of Feedback.Category from the later
+	// results of the user, the category of the item if empty
+	BlockCategory FeedbackAction = "block_category"
+)
+
+const (
+	// ReasonHidden counts the candidates disliked or hidden by the user in
+	// RecApiResponse.Filtered
+	ReasonHidden = "hidden"
+	// ReasonBlockedCategory counts the candidates of the categories blocked
+	// by the user
+	ReasonBlockedCategory = "blocked_category"
+
+	// DefaultFeedbackTTL is the time the feedback of a user is applied
+	// after, the retrained model is expected to have learned it by then
+	DefaultFeedbackTTL = 24 * time.Hour
+	// DefaultFeedbackUsers is the max users of which the feedback is kept
+	DefaultFeedbackUsers = 100000
+	// DefaultFeedbackItems is the max liked or hidden items kept of a user
+	DefaultFeedbackItems = 200
+)
+
+// Feedback is the request of the feedback api
+type Feedback struct {
+	UserId   int            `json:"userId"`
+	ItemId   int            `json:"itemId"`
+	Action   FeedbackAction `json:"action"`
+	Category string         `json:"category"`
+	// Timestamp is the unix seconds of the feedback, now if 0
+	Timestamp int64 `json:"timestamp"`
+}
+
+// FeedbackRecorder is implemented by the Predictor storing the explicit
+// feedback durably, e.g. for the retraining
+type FeedbackRecorder interface {
+	RecordFeedback(ctx context.Context, feedback Feedback) error
+}
+
+// FeedbackObserver is implemented by the Predictor watching the online
+// metrics of the feedback, e.g. the canary arms of retrain.Registry. The
+// feedback api observes the score of the item by the Predictor serving the
+// user, of UserRouter if implemented, and the label 1 of Like or 0 of
+// Dislike and Hide.
+type FeedbackObserver interface {
+	Observe(userId int, score, label float32)
+}
+
+// FeedbackConfig configures the feedback applied to the ranking immediately,
+// independent of the retraining. The zero values are the defaults.
+type FeedbackConfig struct {
+	// CategoryKey is the item attribute of the category by ItemAttributer,
+	// required by BlockCategory
+	CategoryKey string `json:"category_key" yaml:"category_key"`
+	// TTL is the time the feedback is applied after
+	TTL time.Duration `json:"ttl" yaml:"ttl"`
+	// MaxUsers is the max users of which the feedback is kept, the least
+	// recent are forgotten first
+	MaxUsers int `json:"max_users" yaml:"max_users"`
+	// MaxItems is the max liked and hidden items kept of a user
+	MaxItems int `json:"max_items" yaml:"max_items"`
+}
+
+// WithFeedback serves the feedback api at /api/v1/feedback by conf:
+//
+//	curl --header "Content-Type: application/json" \
+//	  --request POST \
+//	  --data '{"userId":107,"itemId":39,"action":"hide"}' \
+//	  http://localhost:8080/api/v1/feedback
+//
+// The liked items are prepended to the user behavior sequence of the later
+// rankings of the user, the hidden items and the blocked categories are
+// filtered out of the candidates. The feedback is also passed to the
+// FeedbackRecorder and FeedbackObserver of the Predictor if implemented.
+func WithFeedback(conf FeedbackConfig) ApiOption {
+	return func(c *apiConfig) {
+		c.feedback = newFeedbackStore(conf)
+	}
+}
+
+type userFeedback struct {
+	// liked is the liked items, the latest first
+	liked      []int
+	hidden     map[int]time.Time
+	categories map[string]time.Time
+	last       time.Time
+	// elem is the element of the user in feedbackStore.order
+	elem *list.Element
+}
+
+// feedbackStore keeps the recent feedback of the users by tenant
+type feedbackStore struct {
+	conf FeedbackConfig
+	now  func() time.Time
+
+	mu    sync.Mutex
+	users map[string]*userFeedback
+	// order is the user keys by the last feedback, the least recent first,
+	// which is also the expiry order as every user is kept for the same TTL
+	order *list.List
+}
+
+func newFeedbackStore(conf FeedbackConfig) *feedbackStore {
+	if conf.TTL <= 0 {
+		conf.TTL = DefaultFeedbackTTL
+	}
+	if conf.MaxUsers <= 0 {
+		conf.MaxUsers = DefaultFeedbackUsers
+	}
+	if conf.MaxItems <= 0 {
+		conf.MaxItems = DefaultFeedbackItems
+	}
+	return &feedbackStore{
+		conf:  conf,
+		now:   time.Now,
+		users: make(map[string]*userFeedback),
+		order: list.New(),
+	}
+}
+
+func feedbackUserKey(ctx context.Context, userId int) string {
+	return fmt.Sprintf("%s\x00%d", tenantName(ctx), userId)
+}
+
+// get returns the live feedback of key, nil if none. s.mu must be held.
+func (s *feedbackStore) get(key string, now time.Time) *userFeedback {
+	u, ok := s.users[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(u.last) > s.conf.TTL {
+		s.remove(key, u)
+		return nil
+	}
+	return u
+}
+
+// record applies f, the category of BlockCategory should be resolved
+func (s *feedbackStore) record(ctx context.Context, f Feedback) {
+	now := s.now()
+	key := feedbackUserKey(ctx, f.UserId)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.get(key, now)
+	if u == nil {
+		if len(s.users) >= s.conf.MaxUsers {
+			s.prune(now)
+		}
+		u = &userFeedback{
+			hidden:     make(map[int]time.Time),
+			categories: make(map[string]time.Time),
+			elem:       s.order.PushBack(key),
+		}
+		s.users[key] = u
+	} else {
+		s.order.MoveToBack(u.elem)
+	}
+	u.last = now
+	switch f.Action {
+	case Like:
+		liked := []int{f.ItemId}
+		for _, id := range u.liked {
+			if id != f.ItemId && len(liked) < s.conf.MaxItems {
+				liked = append(liked, id)
+			}
+		}
+		u.liked = liked
+		delete(u.hidden, f.ItemId)
+	case Dislike, Hide:
+		u.hidden[f.ItemId] = now
+		for i, id := range u.liked {
+			if id == f.ItemId {
+				u.liked = append(u.liked[:i:i], u.liked[i+1:]...)
+				break
+			}
+		}
+		if len(u.hidden) > s.conf.MaxItems {
+			var (
+				oldest int
+				at     time.Time
+				found  bool
+			)
+			for id, t := range u.hidden {
+				if !found || t.Before(at) {
+					oldest, at, found = id, t, true
+				}
+			}
+			delete(u.hidden, oldest)
+		}
+	case BlockCategory:
+		u.categories[f.Category] = now
+		if len(u.categories) > s.conf.MaxItems {
+			var (
+				oldest string
+				at     time.Time
+			)
+			for c, t := range u.categories {
+				if oldest == "" || t.Before(at) {
+					oldest, at = c, t
+				}
+			}
+			delete(u.categories, oldest)
+		}
+	}
+}
+
+// prune removes the expired users from the least recent, and the least
+// recent if still full. s.mu must be held.
+func (s *feedbackStore) prune(now time.Time) {
+	for e := s.order.Front(); e != nil; e = s.order.Front() {
+		key := e.Value.(string)
+		u := s.users[key]
+		if now.Sub(u.last) <= s.conf.TTL && len(s.users) < s.conf.MaxUsers {
+			return
+		}
+		s.remove(key, u)
+	}
+}
+
+// remove removes the feedback of key, s.mu must be held
+func (s *feedbackStore) remove(key string, u *userFeedback) {
+	s.order.Remove(u.elem)
+	delete(s.users, key)
+}
+
+// snapshot returns a copy of the feedback of userId, nil if none
+func (s *feedbackStore) snapshot(ctx context.Context, userId int) *userFeedback {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.get(feedbackUserKey(ctx, userId), s.now())
+	if u == nil {
+		return nil
+	}
+	snap := &userFeedback{
+		liked:      append([]int(nil), u.liked...),
+		hidden:     make(map[int]time.Time, len(u.hidden)),
+		categories: make(map[string]time.Time, len(u.categories)),
+		last:       u.last,
+	}
+	for id, t := range u.hidden {
+		snap.hidden[id] = t
+	}
+	for c, t := range u.categories {
+		snap.categories[c] = t
+	}
+	return snap
+}
+
+// filter removes the hidden items and the items of the blocked categories of
+// userId from itemIds, filtered is the count by reason
+func (s *feedbackStore) filter(ctx context.Context, recSys Predictor, userId int, itemIds []int) (
+	kept []int, filtered map[string]int, err error,
+) {
+	u := s.snapshot(ctx, userId)
+	if u == nil || len(u.hidden)+len(u.categories) == 0 {
+		return itemIds, nil, nil
+	}
+	var attributer ItemAttributer
+	if len(u.categories) != 0 && s.conf.CategoryKey != "" {
+		attributer, _ = recSys.(ItemAttributer)
+	}
+	kept = make([]int, 0, len(itemIds))
+	filtered = make(map[string]int)
+	for _, itemId := range itemIds {
+		if _, ok := u.hidden[itemId]; ok {
+			filtered[ReasonHidden]++
+			RecordUnscored(ctx, DropFeedback, ReasonHidden, itemId)
+			continue
+		}
+		if attributer != nil {
+			var attributes map[string]string
+			if attributes, err = attributer.GetItemAttributes(ctx, itemId); err != nil {
+				return nil, nil, fmt.Errorf("get item %d attributes error: %v", itemId, err)
+			}
+			if _, ok := u.categories[attributes[s.conf.CategoryKey]]; ok {
+				filtered[ReasonBlockedCategory]++
+				RecordUnscored(ctx, DropFeedback, ReasonBlockedCategory, itemId)
+				continue
+			}
+		}
+		kept = append(kept, itemId)
+	}
+	return
+}
+
+type feedbackKey struct{}
+
+// withFeedback returns the ctx of which the rankings see the liked items in
+// the user behavior
+func (s *feedbackStore) withFeedback(ctx context.Context) context.Context {
+	return context.WithValue(ctx, feedbackKey{}, s)
+}
+
+// feedbackBehavior prepends the items liked by userId in ctx to itemSeq, the
+// latest first as of UserBehavior
+func feedbackBehavior(ctx context.Context, userId int, itemSeq []int) []int {
+	s, _ := ctx.Value(feedbackKey{}).(*feedbackStore)
+	if s == nil {
+		return itemSeq
+	}
+	s.mu.Lock()
+	var recent []int
+	if u := s.get(feedbackUserKey(ctx, userId), s.now()); u != nil {
+		recent = append(recent, u.liked...)
+	}
+	s.mu.Unlock()
+	return encoding.PrependBehaviors(recent, itemSeq)
+}
+
+func feedbackHandler(resolve predictorResolver, store *feedbackStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var f Feedback
+		ctx, predict, err := resolve(c)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		if err = c.ShouldBind(&f); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		switch f.Action {
+		case Like, Dislike, Hide:
+		case BlockCategory:
+			if f.Category == "" {
+				if f.Category, err = itemCategory(ctx, predict, store.conf.CategoryKey, f.ItemId); err != nil {
+					c.JSON(400, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		default:
+			c.JSON(400, gin.H{"error": fmt.Sprintf("unknown feedback action %q", f.Action)})
+			return
+		}
+		if f.Timestamp == 0 {
+			f.Timestamp = time.Now().Unix()
+		}
+		if recorder, ok := predict.(FeedbackRecorder); ok {
+			if err = recorder.RecordFeedback(ctx, f); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if observer, ok := predict.(FeedbackObserver); ok && f.Action != BlockCategory {
+			if err = observeFeedback(ctx, predict, observer, f); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		store.record(ctx, f)
+		c.JSON(200, f)
+	}
+}
+
+// observeFeedback observes the score of the feedback item by the Predictor
+// serving the user and the label of the action
+func observeFeedback(ctx context.Context, predict Predictor, observer FeedbackObserver, f Feedback) error {
+	if router, ok := predict.(UserRouter); ok {
+		predict, _ = router.RouteUser(ctx, f.UserId)
+	}
+	y, err := BatchPredict(ctx, predict, []Sample{{UserId: f.UserId, ItemId: f.ItemId}})
+	if err != nil {
+		return fmt.Errorf("feedback score error: %v", err)
+	}
+	if y == nil {
+		return fmt.Errorf("feedback score no prediction")
+	}
+	var label float32
+	if f.Action == Like {
+		label = 1
+	}
+	observer.Observe(f.UserId, y.Data().([]float32)[0], label)
+	return nil
+}
+
+func itemCategory(ctx context.Context, recSys Predictor, categoryKey string, itemId int) (string, error) {
+	attributer, ok := recSys.(ItemAttributer)
+	if !ok || categoryKey == "" {
+		return "", fmt.Errorf("category of item %d is unknown", itemId)
+	}
+	attributes, err := attributer.GetItemAttributes(ctx, itemId)
+	if err != nil {
+		return "", fmt.Errorf("get item %d attributes error: %v", itemId, err)
+	}
+	if attributes[categoryKey] == "" {
+		return "", fmt.Errorf("item %d has no %s", itemId, categoryKey)
+	}
+	return attributes[categoryKey], nil
+}
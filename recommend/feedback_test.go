@@ -0,0 +1,134 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// feedbackPredictor records the feedback, the items of odd id are "news"
+type feedbackPredictor struct {
+	countPredictor
+	feedbacks []Feedback
+	// observed are the user, score and label of the FeedbackObserver
+	observed [][3]float32
+}
+
+func (p *feedbackPredictor) Observe(userId int, score, label float32) {
+	p.observed = append(p.observed, [3]float32{float32(userId), score, label})
+}
+
+func (p *feedbackPredictor) GetItemAttributes(_ context.Context, itemId int) (map[string]string, error) {
+	if itemId%2 == 1 {
+		return map[string]string{"category": "news"}, nil
+	}
+	return map[string]string{"category": "sports"}, nil
+}
+
+func (p *feedbackPredictor) RecordFeedback(_ context.Context, feedback Feedback) error {
+	p.feedbacks = append(p.feedbacks, feedback)
+	return nil
+}
+
+func TestFeedback(t *testing.T) {
+	Convey("feedback applied to the next request", t, func() {
+		p := &feedbackPredictor{}
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, p)), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend", WithFeedback(FeedbackConfig{CategoryKey: "category"}))
+		post := func(path, body string) (code int, data []byte) {
+			req := httptest.NewRequest("POST", path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			return w.Code, w.Body.Bytes()
+		}
+		recommend := func() (resp RecApiResponse) {
+			code, data := post("/api/v1/recommend", `{"userId":1}`)
+			So(code, ShouldEqual, 200)
+			So(json.Unmarshal(data, &resp), ShouldBeNil)
+			return
+		}
+
+		So(recommend().ItemScoreList, ShouldHaveLength, 5)
+		code, _ := post("/api/v1/feedback", `{"userId":1,"itemId":4,"action":"hide"}`)
+		So(code, ShouldEqual, 200)
+		resp := recommend()
+		So(resp.ItemScoreList, ShouldHaveLength, 4)
+		So(resp.Filtered, ShouldResemble, map[string]int{ReasonHidden: 1})
+
+		code, _ = post("/api/v1/feedback", `{"userId":1,"itemId":3,"action":"block_category"}`)
+		So(code, ShouldEqual, 200)
+		resp = recommend()
+		So(resp.ItemScoreList, ShouldHaveLength, 1)
+		So(resp.ItemScoreList[0].ItemId, ShouldEqual, 2)
+		So(resp.Filtered, ShouldResemble, map[string]int{ReasonHidden: 1, ReasonBlockedCategory: 3})
+
+		So(p.feedbacks, ShouldHaveLength, 2)
+		So(p.feedbacks[1].Category, ShouldEqual, "news")
+		So(p.feedbacks[1].Timestamp, ShouldBeGreaterThan, 0)
+		// the hide is observed of the label 0, the blocked category is not
+		So(p.observed, ShouldHaveLength, 1)
+		So(p.observed[0][0], ShouldEqual, 1)
+		So(p.observed[0][2], ShouldEqual, 0)
+		code, _ = post("/api/v1/feedback", `{"userId":1,"itemId":2,"action":"like"}`)
+		So(code, ShouldEqual, 200)
+		So(p.observed, ShouldHaveLength, 2)
+		So(p.observed[1][2], ShouldEqual, 1)
+
+		code, _ = post("/api/v1/feedback", `{"userId":1,"itemId":3,"action":"share"}`)
+		So(code, ShouldEqual, 400)
+	})
+
+	Convey("liked items lead the user behavior", t, func() {
+		ctx := context.Background()
+		now := time.Now()
+		s := newFeedbackStore(FeedbackConfig{TTL: time.Hour, MaxItems: 2})
+		s.now = func() time.Time { return now }
+		for _, id := range []int{1, 2, 3} {
+			s.record(ctx, Feedback{UserId: 1, ItemId: id, Action: Like})
+		}
+		s.record(ctx, Feedback{UserId: 1, ItemId: 2, Action: Like})
+		ctx = s.withFeedback(ctx)
+		So(feedbackBehavior(ctx, 1, []int{9, 3}), ShouldResemble, []int{2, 3, 9})
+		So(feedbackBehavior(ctx, 2, []int{9}), ShouldResemble, []int{9})
+
+		s.record(ctx, Feedback{UserId: 1, ItemId: 2, Action: Dislike})
+		So(feedbackBehavior(ctx, 1, nil), ShouldResemble, []int{3})
+
+		now = now.Add(2 * time.Hour)
+		So(feedbackBehavior(ctx, 1, nil), ShouldBeEmpty)
+	})
+
+	Convey("expiry and eviction of the users", t, func() {
+		ctx := context.Background()
+		now := time.Now()
+		s := newFeedbackStore(FeedbackConfig{TTL: time.Minute, MaxUsers: 2})
+		s.now = func() time.Time { return now }
+		s.record(ctx, Feedback{UserId: 1, ItemId: 1, Action: Hide})
+		now = now.Add(time.Second)
+		s.record(ctx, Feedback{UserId: 2, ItemId: 1, Action: Hide})
+		// user 1 is the most recent again, user 2 is evicted
+		s.record(ctx, Feedback{UserId: 1, ItemId: 2, Action: Hide})
+		s.record(ctx, Feedback{UserId: 3, ItemId: 1, Action: Hide})
+		So(s.snapshot(ctx, 2), ShouldBeNil)
+		So(s.snapshot(ctx, 1).hidden, ShouldHaveLength, 2)
+		So(s.snapshot(ctx, 3), ShouldNotBeNil)
+		So(s.order.Len(), ShouldEqual, 2)
+
+		// the expired users are evicted before the least recent alive
+		now = now.Add(2 * time.Minute)
+		s.record(ctx, Feedback{UserId: 4, ItemId: 1, Action: Hide})
+		So(s.users, ShouldHaveLength, 1)
+		s.record(ctx, Feedback{UserId: 5, ItemId: 1, Action: Hide})
+		So(s.users, ShouldHaveLength, 2)
+		So(s.order.Len(), ShouldEqual, 2)
+		So(s.snapshot(ctx, 4), ShouldNotBeNil)
+		So(s.snapshot(ctx, 5), ShouldNotBeNil)
+	})
+}
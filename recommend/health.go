@@ -0,0 +1,170 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	healthCheckTimeout = 3 * time.Second
+)
+
+// ModelVersioner is implemented by the Predictor knowing its model version,
+// which is reported by /healthz and /readyz
+type ModelVersioner interface {
+	ModelVersion() string
+}
+
+// HealthChecker is implemented by the Predictor depending on stores, e.g.
+// the feature DB, it returns the error if any store is not connected.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// WarmUpper is implemented by the Predictor needing extra warm-up before
+// serving, e.g. loading the hot items into the caches
+type WarmUpper interface {
+	WarmUp(ctx context.Context) error
+}
+
+// HealthStatus is the health of a served Predictor, Name is the tenant name
+// if multi-tenant
+type HealthStatus struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+	Ready   bool   `json:"ready"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthReport is the response of /healthz and /readyz
+type HealthReport struct {
+	Ready    bool `json:"ready"`
+	WarmedUp bool `json:"warmedUp"`
+	// WarmUpError is set if the warm-up failed, the api is never ready then
+	WarmUpError string         `json:"warmUpError,omitempty"`
+	Predictors  []HealthStatus `json:"predictors"`
+}
+
+// WithWarmUp scores every round sampleKeys before the api is ready, to run
+// the model once and prime the feature caches. The sample keys should exist
+// in the stores. Without it the api is ready once the stores are connected.
+func WithWarmUp(sampleKeys []Sample, rounds int) ApiOption {
+	return func(c *apiConfig) {
+		c.warmUpSamples = sampleKeys
+		c.warmUpRounds = rounds
+	}
+}
+
+// WarmUp calls WarmUpper if recSys implements it, then scores rounds times
+// the sampleKeys by BatchPredict
+func WarmUp(ctx context.Context, recSys Predictor, sampleKeys []Sample, rounds int) (err error) {
+	if warmUpper, ok := recSys.(WarmUpper); ok {
+		if err = warmUpper.WarmUp(ctx); err != nil {
+			return fmt.Errorf("warm up error: %v", err)
+		}
+	}
+	if len(sampleKeys) == 0 {
+		return
+	}
+	for i := 0; i < rounds; i++ {
+		if _, err = BatchPredict(ctx, recSys, sampleKeys); err != nil {
+			return fmt.Errorf("warm up round %d error: %v", i, err)
+		}
+	}
+	return
+}
+
+// servedPredictor is a Predictor served by the api with the ctx to call it
+type servedPredictor struct {
+	name      string
+	ctx       context.Context
+	predictor Predictor
+}
+
+// health tracks the warm-up and reports the health of the served predictors
+type health struct {
+	predictors func() []servedPredictor
+
+	mu        sync.RWMutex
+	warmedUp  bool
+	warmUpErr error
+}
+
+// newHealth starts warming up the predictors by conf in background
+func newHealth(conf *apiConfig, predictors func() []servedPredictor) *health {
+	h := &health{predictors: predictors}
+	go func() {
+		var err error
+		start := time.Now()
+		for _, p := range predictors() {
+			if err = WarmUp(p.ctx, p.predictor, conf.warmUpSamples, conf.warmUpRounds); err != nil {
+				err = fmt.Errorf("%s%v", tenantPrefix(p.name), err)
+				log.Errorf("%v", err)
+				break
+			}
+		}
+		if err == nil {
+			log.Infof("warmed up in %v", time.Since(start))
+		}
+		h.mu.Lock()
+		h.warmedUp, h.warmUpErr = err == nil, err
+		h.mu.Unlock()
+	}()
+	return h
+}
+
+func tenantPrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf("tenant %s: ", name)
+}
+
+func (h *health) report(ctx context.Context) (report HealthReport) {
+	h.mu.RLock()
+	report.WarmedUp = h.warmedUp
+	if h.warmUpErr != nil {
+		report.WarmUpError = h.warmUpErr.Error()
+	}
+	h.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	report.Ready = report.WarmedUp
+	for _, p := range h.predictors() {
+		status := HealthStatus{Name: p.name, Ready: true}
+		if versioner, ok := p.predictor.(ModelVersioner); ok {
+			status.Version = versioner.ModelVersion()
+		}
+		if checker, ok := p.predictor.(HealthChecker); ok {
+			if err := checker.HealthCheck(ctx); err != nil {
+				status.Ready, status.Error = false, err.Error()
+			}
+		}
+		report.Ready = report.Ready && status.Ready
+		report.Predictors = append(report.Predictors, status)
+	}
+	return
+}
+
+// addHealthRoutes adds /healthz, which is always 200 while the process is
+// up, and /readyz, which is 503 until warmed up and all the stores are
+// connected. Both report the HealthReport.
+func addHealthRoutes(engine *gin.Engine, h *health) {
+	engine.GET("/healthz", func(c *gin.Context) {
+		c.JSON(200, h.report(c))
+	})
+	engine.GET("/readyz", func(c *gin.Context) {
+		report := h.report(c)
+		if !report.Ready {
+			c.JSON(503, report)
+			return
+		}
+		c.JSON(200, report)
+	})
+}
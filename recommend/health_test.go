@@ -0,0 +1,84 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// healthPredictor reports its version and store connectivity, and counts
+// the warm-ups
+type healthPredictor struct {
+	constPredictor
+	storeErr error
+	warmUps  int32
+}
+
+func (p *healthPredictor) ModelVersion() string {
+	return "v1"
+}
+
+func (p *healthPredictor) HealthCheck(context.Context) error {
+	return p.storeErr
+}
+
+func (p *healthPredictor) WarmUp(context.Context) error {
+	atomic.AddInt32(&p.warmUps, 1)
+	return nil
+}
+
+func TestHealth(t *testing.T) {
+	Convey("warm up", t, func() {
+		p := &healthPredictor{}
+		So(WarmUp(context.Background(), p, []Sample{{UserId: 1, ItemId: 2}}, 3), ShouldBeNil)
+		So(atomic.LoadInt32(&p.warmUps), ShouldEqual, 1)
+		So(WarmUp(context.Background(), p, []Sample{{UserId: -1, ItemId: 2}}, 3), ShouldNotBeNil)
+	})
+
+	Convey("health and readiness probes", t, func() {
+		var (
+			r    = NewTenantRegistry()
+			good = &healthPredictor{}
+			bad  = &healthPredictor{storeErr: fmt.Errorf("db is gone")}
+		)
+		So(r.Register(NewTenant("good", good)), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend", WithWarmUp([]Sample{{UserId: 1, ItemId: 2}}, 2))
+		probe := func(path string) (int, HealthReport) {
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			var report HealthReport
+			_ = json.Unmarshal(w.Body.Bytes(), &report)
+			return w.Code, report
+		}
+
+		var (
+			code   int
+			report HealthReport
+		)
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if code, report = probe("/readyz"); code == http.StatusOK {
+				break
+			}
+		}
+		So(code, ShouldEqual, http.StatusOK)
+		So(report.WarmedUp, ShouldBeTrue)
+		So(report.Predictors, ShouldResemble, []HealthStatus{{Name: "good", Version: "v1", Ready: true}})
+		So(atomic.LoadInt32(&good.warmUps), ShouldEqual, 1)
+
+		So(r.Register(NewTenant("bad", bad)), ShouldBeNil)
+		code, report = probe("/readyz")
+		So(code, ShouldEqual, http.StatusServiceUnavailable)
+		So(report.Predictors[0], ShouldResemble, HealthStatus{Name: "bad", Version: "v1", Error: "db is gone"})
+		// liveness is not affected by the stores
+		code, report = probe("/healthz")
+		So(code, ShouldEqual, http.StatusOK)
+		So(report.Ready, ShouldBeFalse)
+	})
+}
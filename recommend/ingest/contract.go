@@ -0,0 +1,349 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+// the fields of Contract.Required
+const (
+	FieldUserId    = "userId"
+	FieldItemId    = "itemId"
+	FieldTimestamp = "timestamp"
+)
+
+// the reasons of the quarantined samples
+const (
+	ReasonMissingField    = "missing_field"
+	ReasonBadLabel        = "bad_label"
+	ReasonFutureTimestamp = "future_timestamp"
+	ReasonStaleTimestamp  = "stale_timestamp"
+	ReasonOutOfOrder      = "out_of_order"
+	ReasonUnknownItem     = "unknown_item"
+)
+
+const (
+	DefaultContractInterval = time.Minute
+	DefaultMaxQuarantined   = 10000
+)
+
+// DefaultRequired are the fields of which the zero value is invalid
+var DefaultRequired = []string{FieldUserId, FieldItemId, FieldTimestamp}
+
+// Catalog returns the known ones of itemIds, e.g. of the item catalog
+// table, for the referential integrity of the samples
+type Catalog func(ctx context.Context, itemIds []int) (known map[int]bool, err error)
+
+// KnownItems is the Catalog of the fixed item ids
+func KnownItems(itemIds ...int) Catalog {
+	known := make(map[int]bool, len(itemIds))
+	for _, id := range itemIds {
+		known[id] = true
+	}
+	return func(context.Context, []int) (map[int]bool, error) {
+		return known, nil
+	}
+}
+
+// Contract is the declared shape of the samples streamed, the zero values are
+// the defaults. The labels must be in [0, 1].
+type Contract struct {
+	// Required fields of the samples, DefaultRequired if nil
+	Required []string
+	// MaxFuture and MaxAge bound the timestamps around the check time, 0
+	// disables them
+	MaxFuture time.Duration
+	MaxAge    time.Duration
+	// MaxReorder bounds the timestamps of a user going back from the latest
+	// one checked, the late events beyond it are out of order. 0 disables it.
+	MaxReorder time.Duration
+	// Catalog of the item ids, nil disables the referential integrity check
+	Catalog Catalog
+}
+
+// QuarantinedSample is a sample violating the Contract
+type QuarantinedSample struct {
+	Sample rcmd.Sample `json:"sample"`
+	Reason string      `json:"reason"`
+	Detail string      `json:"detail,omitempty"`
+	Time   time.Time   `json:"time"`
+}
+
+// ContractReport is the result of a ContractGuard check
+type ContractReport struct {
+	Time        time.Time      `json:"time"`
+	Checked     int            `json:"checked"`
+	Passed      int            `json:"passed"`
+	Quarantined int            `json:"quarantined"`
+	Reasons     map[string]int `json:"reasons,omitempty"`
+	// Health is the share of the samples passed, 1 of no sample checked
+	Health float64 `json:"health"`
+}
+
+// ContractGuard is the Sink validating the samples against the Contract
+// before they reach the Sink behind it, so the bad events are quarantined
+// instead of poisoning the behavior store. Put buffers the samples, they are
+// checked by the periodic job of Run, or by Check. The samples passed are
+// put to Sink in the order streamed, the quarantined ones are appended to the
+// QuarantinePath json lines and kept in memory for Quarantined.
+type ContractGuard struct {
+	Contract Contract
+	Sink     Sink
+	// Interval of the checks of Run, DefaultContractInterval if 0
+	Interval time.Duration
+	// QuarantinePath is the file of the quarantined samples, empty keeps them
+	// in memory only
+	QuarantinePath string
+	// MaxQuarantined kept in memory, DefaultMaxQuarantined if 0
+	MaxQuarantined int
+
+	mu          sync.Mutex
+	pending     []rcmd.Sample
+	quarantined []QuarantinedSample
+	reports     []ContractReport
+
+	// checkMu serializes the checks, latest is the latest timestamp checked of
+	// every user
+	checkMu sync.Mutex
+	latest  map[int]int64
+	now     func() time.Time
+}
+
+func (g *ContractGuard) Put(_ context.Context, samples []rcmd.Sample) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pending = append(g.pending, samples...)
+	return nil
+}
+
+// Pending is the number of the samples not checked yet
+func (g *ContractGuard) Pending() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.pending)
+}
+
+// Run checks the pending samples every Interval until ctx is done, the
+// failed checks are logged and retried on the next tick
+func (g *ContractGuard) Run(ctx context.Context) {
+	interval := g.Interval
+	if interval <= 0 {
+		interval = DefaultContractInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := g.Check(ctx); err != nil {
+				log.Errorf("contract check: %v", err)
+			}
+		}
+	}
+}
+
+// Check validates the pending samples, puts the passed ones to Sink and
+// quarantines the others. The samples are pending again if the Catalog or
+// Sink fails.
+func (g *ContractGuard) Check(ctx context.Context) (report ContractReport, err error) {
+	g.checkMu.Lock()
+	defer g.checkMu.Unlock()
+	g.mu.Lock()
+	samples := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+	defer func() {
+		if err != nil {
+			g.mu.Lock()
+			g.pending = append(samples, g.pending...)
+			g.mu.Unlock()
+		}
+	}()
+
+	now := time.Now()
+	if g.now != nil {
+		now = g.now()
+	}
+	report = ContractReport{Time: now, Checked: len(samples), Health: 1}
+	var known map[int]bool
+	if g.Contract.Catalog != nil && len(samples) > 0 {
+		if known, err = g.Contract.Catalog(ctx, itemIds(samples)); err != nil {
+			return report, fmt.Errorf("contract catalog: %v", err)
+		}
+	}
+	if g.latest == nil {
+		g.latest = make(map[int]int64)
+	}
+	var (
+		passed      []rcmd.Sample
+		quarantined []QuarantinedSample
+		latest      = make(map[int]int64)
+	)
+	for _, s := range samples {
+		reason, detail := g.Contract.violation(s, now, known, g.latest, latest)
+		if reason != "" {
+			quarantined = append(quarantined, QuarantinedSample{Sample: s, Reason: reason, Detail: detail, Time: now})
+			continue
+		}
+		if s.Timestamp > latest[s.UserId] {
+			latest[s.UserId] = s.Timestamp
+		}
+		passed = append(passed, s)
+	}
+	if len(passed) > 0 && g.Sink != nil {
+		if err = g.Sink.Put(ctx, passed); err != nil {
+			return report, err
+		}
+	}
+	for user, ts := range latest {
+		if ts > g.latest[user] {
+			g.latest[user] = ts
+		}
+	}
+	if err = g.quarantine(quarantined); err != nil {
+		// the passed samples are put already
+		log.Errorf("quarantine %d samples: %v", len(quarantined), err)
+		err = nil
+	}
+
+	report.Passed, report.Quarantined = len(passed), len(quarantined)
+	if len(quarantined) > 0 {
+		report.Reasons = make(map[string]int)
+		for _, q := range quarantined {
+			report.Reasons[q.Reason]++
+		}
+		log.Warnf("contract check quarantined %d of %d samples: %v", len(quarantined), len(samples), report.Reasons)
+	}
+	if report.Checked > 0 {
+		report.Health = float64(report.Passed) / float64(report.Checked)
+	}
+	g.mu.Lock()
+	g.reports = append(g.reports, report)
+	if len(g.reports) > 100 {
+		g.reports = g.reports[len(g.reports)-100:]
+	}
+	g.mu.Unlock()
+	return
+}
+
+// violation returns the reason and the detail of the sample violating c, or
+// empty. The timestamps of the users are bounded by the ones of the previous
+// checks and of the samples passed in the check.
+func (c *Contract) violation(s rcmd.Sample, now time.Time, known map[int]bool, previous, latest map[int]int64) (reason, detail string) {
+	required := c.Required
+	if required == nil {
+		required = DefaultRequired
+	}
+	for _, field := range required {
+		var missing bool
+		switch field {
+		case FieldUserId:
+			missing = s.UserId == 0
+		case FieldItemId:
+			missing = s.ItemId == 0
+		case FieldTimestamp:
+			missing = s.Timestamp == 0
+		}
+		if missing {
+			return ReasonMissingField, field
+		}
+	}
+	if math.IsNaN(float64(s.Label)) || s.Label < 0 || s.Label > 1 {
+		return ReasonBadLabel, fmt.Sprintf("label %v not in [0, 1]", s.Label)
+	}
+	ts := time.Unix(s.Timestamp, 0)
+	if c.MaxFuture > 0 && ts.After(now.Add(c.MaxFuture)) {
+		return ReasonFutureTimestamp, fmt.Sprintf("%v ahead of %v", ts.Sub(now), now.Format(time.RFC3339))
+	}
+	if c.MaxAge > 0 && ts.Before(now.Add(-c.MaxAge)) {
+		return ReasonStaleTimestamp, fmt.Sprintf("%v before %v", now.Sub(ts), now.Format(time.RFC3339))
+	}
+	if c.MaxReorder > 0 {
+		last := previous[s.UserId]
+		if latest[s.UserId] > last {
+			last = latest[s.UserId]
+		}
+		if last > 0 && s.Timestamp < last-int64(c.MaxReorder/time.Second) {
+			return ReasonOutOfOrder, fmt.Sprintf("%ds before the latest of the user", last-s.Timestamp)
+		}
+	}
+	if known != nil && !known[s.ItemId] {
+		return ReasonUnknownItem, fmt.Sprintf("item %d not in the catalog", s.ItemId)
+	}
+	return
+}
+
+func itemIds(samples []rcmd.Sample) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, s := range samples {
+		if !seen[s.ItemId] {
+			seen[s.ItemId] = true
+			ids = append(ids, s.ItemId)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (g *ContractGuard) quarantine(quarantined []QuarantinedSample) (err error) {
+	if len(quarantined) == 0 {
+		return
+	}
+	g.mu.Lock()
+	max := g.MaxQuarantined
+	if max <= 0 {
+		max = DefaultMaxQuarantined
+	}
+	g.quarantined = append(g.quarantined, quarantined...)
+	if len(g.quarantined) > max {
+		g.quarantined = g.quarantined[len(g.quarantined)-max:]
+	}
+	g.mu.Unlock()
+	if g.QuarantinePath == "" {
+		return
+	}
+	var buf []byte
+	for i := range quarantined {
+		line, err := json.Marshal(&quarantined[i])
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	f, err := os.OpenFile(g.QuarantinePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	if _, err = f.Write(buf); err != nil {
+		f.Close()
+		return
+	}
+	return f.Close()
+}
+
+// Quarantined returns the latest quarantined samples kept in memory, at most
+// MaxQuarantined
+func (g *ContractGuard) Quarantined() []QuarantinedSample {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]QuarantinedSample(nil), g.quarantined...)
+}
+
+// Reports returns the reports of the latest 100 checks, the latest last
+func (g *ContractGuard) Reports() []ContractReport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]ContractReport(nil), g.reports...)
+}
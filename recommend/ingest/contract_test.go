@@ -0,0 +1,134 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// failingSink fails the Put of err
+type failingSink struct {
+	err error
+}
+
+func (s *failingSink) Put(context.Context, []rcmd.Sample) error {
+	return s.err
+}
+
+func TestContractGuard(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+
+	Convey("bad samples are quarantined", t, func() {
+		var (
+			dataset Dataset
+			path    = filepath.Join(t.TempDir(), "quarantine.jsonl")
+			guard   = &ContractGuard{
+				Contract: Contract{
+					MaxFuture:  time.Hour,
+					MaxAge:     24 * time.Hour,
+					MaxReorder: time.Minute,
+					Catalog:    KnownItems(1, 2, 3),
+				},
+				Sink:           &dataset,
+				QuarantinePath: path,
+				now:            func() time.Time { return now },
+			}
+			ts = now.Unix()
+		)
+		So(guard.Put(context.Background(), []rcmd.Sample{
+			{UserId: 1, ItemId: 1, Label: 1, Timestamp: ts},
+			{UserId: 1, ItemId: 2, Label: 0, Timestamp: ts - 30},
+			{UserId: 1, ItemId: 3, Label: 1, Timestamp: ts - 3600},
+			{UserId: 0, ItemId: 1, Label: 1, Timestamp: ts},
+			{UserId: 2, ItemId: 1, Label: 5, Timestamp: ts},
+			{UserId: 2, ItemId: 1, Label: 1, Timestamp: ts + 7200},
+			{UserId: 2, ItemId: 1, Label: 1, Timestamp: ts - 3*86400},
+			{UserId: 2, ItemId: 9, Label: 1, Timestamp: ts},
+		}), ShouldBeNil)
+		So(guard.Pending(), ShouldEqual, 8)
+
+		report, err := guard.Check(context.Background())
+		So(err, ShouldBeNil)
+		So(guard.Pending(), ShouldEqual, 0)
+		So(report.Checked, ShouldEqual, 8)
+		So(report.Passed, ShouldEqual, 2)
+		So(report.Quarantined, ShouldEqual, 6)
+		So(report.Health, ShouldEqual, 0.25)
+		So(report.Reasons, ShouldResemble, map[string]int{
+			ReasonOutOfOrder:      1,
+			ReasonMissingField:    1,
+			ReasonBadLabel:        1,
+			ReasonFutureTimestamp: 1,
+			ReasonStaleTimestamp:  1,
+			ReasonUnknownItem:     1,
+		})
+		So(dataset.Take(), ShouldResemble, []rcmd.Sample{
+			{UserId: 1, ItemId: 1, Label: 1, Timestamp: ts},
+			{UserId: 1, ItemId: 2, Label: 0, Timestamp: ts - 30},
+		})
+
+		quarantined := guard.Quarantined()
+		So(quarantined, ShouldHaveLength, 6)
+		So(quarantined[1].Reason, ShouldEqual, ReasonMissingField)
+		So(quarantined[1].Detail, ShouldEqual, FieldUserId)
+		f, err := os.Open(path)
+		So(err, ShouldBeNil)
+		defer f.Close()
+		var lines int
+		for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+			var q QuarantinedSample
+			So(json.Unmarshal(scanner.Bytes(), &q), ShouldBeNil)
+			So(q.Reason, ShouldEqual, quarantined[lines].Reason)
+		}
+		So(lines, ShouldEqual, 6)
+
+		// the user timestamps are bounded by the previous checks
+		So(guard.Put(context.Background(), []rcmd.Sample{{UserId: 1, ItemId: 1, Timestamp: ts - 120}}), ShouldBeNil)
+		report, err = guard.Check(context.Background())
+		So(err, ShouldBeNil)
+		So(report.Reasons, ShouldResemble, map[string]int{ReasonOutOfOrder: 1})
+		So(guard.Reports(), ShouldHaveLength, 2)
+
+		// the empty check is healthy
+		report, err = guard.Check(context.Background())
+		So(err, ShouldBeNil)
+		So(report.Health, ShouldEqual, 1)
+	})
+
+	Convey("the samples are pending again of the failed sink", t, func() {
+		sink := &failingSink{err: errors.New("sink down")}
+		guard := &ContractGuard{Sink: sink, Contract: Contract{Required: []string{FieldItemId}}}
+		So(guard.Put(context.Background(), []rcmd.Sample{{ItemId: 1}, {ItemId: 2}}), ShouldBeNil)
+		_, err := guard.Check(context.Background())
+		So(err, ShouldNotBeNil)
+		So(guard.Pending(), ShouldEqual, 2)
+
+		sink.err = nil
+		report, err := guard.Check(context.Background())
+		So(err, ShouldBeNil)
+		So(report.Passed, ShouldEqual, 2)
+		So(guard.Pending(), ShouldEqual, 0)
+	})
+
+	Convey("periodic checks of Run", t, func() {
+		var dataset Dataset
+		guard := &ContractGuard{Sink: &dataset, Interval: 10 * time.Millisecond}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go guard.Run(ctx)
+		So(guard.Put(ctx, []rcmd.Sample{{UserId: 1, ItemId: 1, Timestamp: time.Now().Unix()}}), ShouldBeNil)
+		deadline := time.Now().Add(5 * time.Second)
+		for dataset.Len() == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		So(dataset.Len(), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,135 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/flight"
+	"github.com/apache/arrow/go/arrow/ipc"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FlightEndpoint is the Arrow Flight service of DoPut. The record batches
+// put by the clients, e.g. pyarrow.flight.FlightClient.do_put, are kept as
+// the samples until Reset. Every DoPut is all or nothing: the samples of a
+// stream are kept only if all its batches are valid. The result of DoPut is
+// the samples kept in decimal as the app_metadata.
+type FlightEndpoint struct {
+	// Columns of the samples, DefaultColumns if zero
+	Columns Columns
+
+	mu      sync.RWMutex
+	samples []rcmd.Sample
+	server  flight.Server
+}
+
+// NewFlightEndpoint returns the FlightEndpoint of the sample columns
+func NewFlightEndpoint(cols Columns) *FlightEndpoint {
+	return &FlightEndpoint{Columns: cols}
+}
+
+// Listen binds the endpoint to addr, e.g. ":8815" or "localhost:0", it's
+// served by Serve
+func (e *FlightEndpoint) Listen(addr string) (err error) {
+	e.server = flight.NewFlightServer(nil)
+	e.server.RegisterFlightService(&flight.FlightServiceService{DoPut: e.doPut})
+	if err = e.server.Init(addr); err != nil {
+		return fmt.Errorf("flight endpoint listen %s: %v", addr, err)
+	}
+	return
+}
+
+// Addr is the address bound by Listen
+func (e *FlightEndpoint) Addr() net.Addr {
+	return e.server.Addr()
+}
+
+// Serve blocks serving the DoPut until Shutdown
+func (e *FlightEndpoint) Serve() error {
+	if e.server == nil {
+		return fmt.Errorf("flight endpoint serve before listen")
+	}
+	return e.server.Serve()
+}
+
+// Shutdown stops the endpoint after the DoPut in progress
+func (e *FlightEndpoint) Shutdown() {
+	if e.server != nil {
+		e.server.Shutdown()
+	}
+}
+
+func (e *FlightEndpoint) doPut(stream flight.FlightService_DoPutServer) error {
+	reader, err := ipc.NewFlightDataReader(stream)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "read flight data: %v", err)
+	}
+	defer reader.Release()
+	var samples []rcmd.Sample
+	for reader.Next() {
+		if samples, err = RecordSamples(samples, reader.Record(), e.Columns); err != nil {
+			return status.Errorf(codes.InvalidArgument, "record batch: %v", err)
+		}
+	}
+	if err = reader.Err(); err != nil {
+		return status.Errorf(codes.InvalidArgument, "read flight data: %v", err)
+	}
+	e.mu.Lock()
+	e.samples = append(e.samples, samples...)
+	e.mu.Unlock()
+	log.Debugf("flight endpoint samples put: %d", len(samples))
+	return stream.Send(&flight.PutResult{AppMetadata: []byte(strconv.Itoa(len(samples)))})
+}
+
+// Append keeps the samples of rec, like the batches put
+func (e *FlightEndpoint) Append(rec array.Record) error {
+	samples, err := RecordSamples(nil, rec, e.Columns)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.samples = append(e.samples, samples...)
+	e.mu.Unlock()
+	return nil
+}
+
+// Len is the number of the samples kept
+func (e *FlightEndpoint) Len() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return len(e.samples)
+}
+
+// Reset drops the samples kept, e.g. after Train
+func (e *FlightEndpoint) Reset() {
+	e.mu.Lock()
+	e.samples = nil
+	e.mu.Unlock()
+}
+
+// SampleGenerator implements rcmd.Trainer of the samples kept by the call,
+// the samples put after are of the next call
+func (e *FlightEndpoint) SampleGenerator(ctx context.Context) (<-chan rcmd.Sample, error) {
+	e.mu.RLock()
+	samples := e.samples[:len(e.samples):len(e.samples)]
+	e.mu.RUnlock()
+	ch := make(chan rcmd.Sample, 10000)
+	go func() {
+		defer close(ch)
+		for _, s := range samples {
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,268 @@
+// Package ingest feeds the training samples of the Apache Arrow record
+// batches to the sample builder of rcmd.Train, so the feature pipelines of
+// Spark or Polars hand off the training data without parsing. The batches
+// are read from the Arrow IPC files by File, or put to the Flight endpoint
+// by FlightEndpoint. Both are the rcmd.Trainer of the samples:
+//
+//	type recSys struct {
+//		features    // the rcmd.BasicFeatureProvider
+//		ingest.File // the SampleGenerator
+//	}
+//	model, err := rcmd.Train(ctx, &recSys{File: ingest.File{Path: "samples.arrow"}}, fitter)
+//
+// The edgerecpb samples of the remote producers are streamed by the
+// TrainingData grpc RPC of StreamEndpoint, to the online trainer by
+// OnlineTrainer, or to the Dataset of the next scheduled retrain. The
+// ContractGuard in front of them quarantines the samples violating the
+// declared Contract.
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/arrio"
+	"github.com/apache/arrow/go/arrow/ipc"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+// Columns are the names of the sample columns of the record batches. The
+// ids are of the integer columns, the label of the float, integer or bool
+// column, and the timestamp of the integer or timestamp column. Empty
+// Timestamp or the batches of no Timestamp column get the timestamp 0.
+type Columns struct {
+	UserId    string
+	ItemId    string
+	Label     string
+	Timestamp string
+}
+
+// DefaultColumns are the json names of rcmd.Sample
+var DefaultColumns = Columns{UserId: "userId", ItemId: "itemId", Label: "label", Timestamp: "timestamp"}
+
+func (c Columns) orDefault() Columns {
+	if c == (Columns{}) {
+		return DefaultColumns
+	}
+	return c
+}
+
+// column returns the column of name of rec, or nil if optional and missing
+func column(rec array.Record, name string, optional bool) (array.Interface, error) {
+	indices := rec.Schema().FieldIndices(name)
+	switch {
+	case len(indices) == 1:
+		return rec.Column(indices[0]), nil
+	case len(indices) == 0 && (optional || name == ""):
+		return nil, nil
+	case len(indices) == 0:
+		return nil, fmt.Errorf("record batch of no %q column", name)
+	default:
+		return nil, fmt.Errorf("record batch of %d %q columns", len(indices), name)
+	}
+}
+
+// intAt returns the integer values of arr, the timestamps are converted to
+// the unix seconds
+func intAt(arr array.Interface) (func(i int) int64, error) {
+	switch a := arr.(type) {
+	case *array.Int8:
+		return func(i int) int64 { return int64(a.Value(i)) }, nil
+	case *array.Int16:
+		return func(i int) int64 { return int64(a.Value(i)) }, nil
+	case *array.Int32:
+		return func(i int) int64 { return int64(a.Value(i)) }, nil
+	case *array.Int64:
+		return func(i int) int64 { return a.Value(i) }, nil
+	case *array.Uint8:
+		return func(i int) int64 { return int64(a.Value(i)) }, nil
+	case *array.Uint16:
+		return func(i int) int64 { return int64(a.Value(i)) }, nil
+	case *array.Uint32:
+		return func(i int) int64 { return int64(a.Value(i)) }, nil
+	case *array.Uint64:
+		return func(i int) int64 { return int64(a.Value(i)) }, nil
+	case *array.Timestamp:
+		perSecond := map[arrow.TimeUnit]int64{
+			arrow.Second: 1, arrow.Millisecond: 1e3, arrow.Microsecond: 1e6, arrow.Nanosecond: 1e9,
+		}[a.DataType().(*arrow.TimestampType).Unit]
+		return func(i int) int64 { return int64(a.Value(i)) / perSecond }, nil
+	default:
+		return nil, fmt.Errorf("column of %s, not an integer", arr.DataType())
+	}
+}
+
+// floatAt returns the float values of arr, of the float, integer or bool
+// column
+func floatAt(arr array.Interface) (func(i int) float32, error) {
+	switch a := arr.(type) {
+	case *array.Float32:
+		return a.Value, nil
+	case *array.Float64:
+		return func(i int) float32 { return float32(a.Value(i)) }, nil
+	case *array.Boolean:
+		return func(i int) float32 {
+			if a.Value(i) {
+				return 1
+			}
+			return 0
+		}, nil
+	}
+	ints, err := intAt(arr)
+	if err != nil {
+		return nil, fmt.Errorf("column of %s, not a float, integer or bool", arr.DataType())
+	}
+	return func(i int) float32 { return float32(ints(i)) }, nil
+}
+
+// RecordSamples appends the samples of the rows of rec by cols to samples,
+// DefaultColumns if cols is zero. The rows of null sample columns are
+// errors.
+func RecordSamples(samples []rcmd.Sample, rec array.Record, cols Columns) ([]rcmd.Sample, error) {
+	cols = cols.orDefault()
+	var (
+		arrs  [4]array.Interface
+		err   error
+		names = [4]string{cols.UserId, cols.ItemId, cols.Label, cols.Timestamp}
+	)
+	for i, name := range names {
+		if arrs[i], err = column(rec, name, i == 3); err != nil {
+			return samples, err
+		}
+	}
+	userId, err := intAt(arrs[0])
+	if err != nil {
+		return samples, fmt.Errorf("%q %v", cols.UserId, err)
+	}
+	itemId, err := intAt(arrs[1])
+	if err != nil {
+		return samples, fmt.Errorf("%q %v", cols.ItemId, err)
+	}
+	label, err := floatAt(arrs[2])
+	if err != nil {
+		return samples, fmt.Errorf("%q %v", cols.Label, err)
+	}
+	timestamp := func(int) int64 { return 0 }
+	if arrs[3] != nil {
+		if timestamp, err = intAt(arrs[3]); err != nil {
+			return samples, fmt.Errorf("%q %v", cols.Timestamp, err)
+		}
+	}
+	for i, arr := range arrs {
+		if arr != nil && arr.NullN() != 0 {
+			return samples, fmt.Errorf("%q column of %d nulls", names[i], arr.NullN())
+		}
+	}
+	for i := 0; i < int(rec.NumRows()); i++ {
+		samples = append(samples, rcmd.Sample{
+			UserId:    int(userId(i)),
+			ItemId:    int(itemId(i)),
+			Label:     label(i),
+			Timestamp: timestamp(i),
+		})
+	}
+	return samples, nil
+}
+
+// sendSamples sends the samples of the batches of r to ch until ctx is done,
+// it returns the samples sent
+func sendSamples(ctx context.Context, r arrio.Reader, cols Columns, ch chan<- rcmd.Sample) (n int, err error) {
+	var samples []rcmd.Sample
+	for {
+		var rec array.Record
+		if rec, err = r.Read(); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return
+		}
+		if samples, err = RecordSamples(samples[:0], rec, cols); err != nil {
+			return
+		}
+		for _, s := range samples {
+			select {
+			case ch <- s:
+				n++
+			case <-ctx.Done():
+				return n, ctx.Err()
+			}
+		}
+	}
+}
+
+// arrowFileMagic starts the Arrow IPC file format, the stream format has no
+// magic
+var arrowFileMagic = []byte("ARROW1")
+
+// File is the Arrow IPC file of the samples, in the file format (.arrow,
+// .feather v2) or the stream format (.arrows), e.g. of pyarrow or
+// DataFrame.write_ipc of Polars
+type File struct {
+	Path string
+	// Columns of the samples, DefaultColumns if zero
+	Columns Columns
+}
+
+// SampleGenerator implements rcmd.Trainer, the file is read in every call.
+// The errors after the file opened are logged and end the samples.
+func (f *File) SampleGenerator(ctx context.Context) (<-chan rcmd.Sample, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(arrowFileMagic))
+	if _, err = io.ReadFull(file, magic); err != nil && err != io.ErrUnexpectedEOF {
+		_ = file.Close()
+		return nil, fmt.Errorf("read arrow file %s: %v", f.Path, err)
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	var reader interface {
+		arrio.Reader
+		Release()
+	}
+	if bytes.Equal(magic, arrowFileMagic) {
+		var fr *ipc.FileReader
+		if fr, err = ipc.NewFileReader(file); err == nil {
+			reader = &fileReader{fr}
+		}
+	} else {
+		reader, err = ipc.NewReader(file)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("read arrow file %s: %v", f.Path, err)
+	}
+
+	ch := make(chan rcmd.Sample, 10000)
+	go func() {
+		defer func() {
+			reader.Release()
+			_ = file.Close()
+			close(ch)
+		}()
+		n, err := sendSamples(ctx, reader, f.Columns, ch)
+		if err != nil {
+			log.Errorf("read arrow file %s error after %d samples: %v", f.Path, n, err)
+			return
+		}
+		log.Debugf("arrow file %s samples: %d", f.Path, n)
+	}()
+	return ch, nil
+}
+
+// fileReader releases the ipc.FileReader by Close
+type fileReader struct {
+	*ipc.FileReader
+}
+
+func (r *fileReader) Release() {
+	_ = r.Close()
+}
@@ -0,0 +1,237 @@
+package ingest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/flight"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"gorgonia.org/tensor"
+)
+
+var sampleSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "userId", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "itemId", Type: arrow.PrimitiveTypes.Int32},
+	{Name: "label", Type: arrow.FixedWidthTypes.Boolean},
+	{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Millisecond}},
+}, nil)
+
+// sampleRecord is the batch of n samples from user 0, the labels of the
+// odd users are 1
+func sampleRecord(first, n int) array.Record {
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), sampleSchema)
+	defer b.Release()
+	for i := first; i < first+n; i++ {
+		b.Field(0).(*array.Int64Builder).Append(int64(i))
+		b.Field(1).(*array.Int32Builder).Append(int32(i * 10))
+		b.Field(2).(*array.BooleanBuilder).Append(i%2 == 1)
+		b.Field(3).(*array.TimestampBuilder).Append(arrow.Timestamp(i * 1000))
+	}
+	return b.NewRecord()
+}
+
+func collect(ch <-chan rcmd.Sample) (samples []rcmd.Sample) {
+	for s := range ch {
+		samples = append(samples, s)
+	}
+	return
+}
+
+func TestRecordSamples(t *testing.T) {
+	Convey("samples of the record batch", t, func() {
+		rec := sampleRecord(0, 3)
+		defer rec.Release()
+		samples, err := RecordSamples(nil, rec, Columns{})
+		So(err, ShouldBeNil)
+		So(samples, ShouldResemble, []rcmd.Sample{
+			{UserId: 0, ItemId: 0, Label: 0, Timestamp: 0},
+			{UserId: 1, ItemId: 10, Label: 1, Timestamp: 1},
+			{UserId: 2, ItemId: 20, Label: 0, Timestamp: 2},
+		})
+
+		// no timestamp column
+		samples, err = RecordSamples(nil, rec, Columns{UserId: "userId", ItemId: "itemId", Label: "label"})
+		So(err, ShouldBeNil)
+		So(samples, ShouldHaveLength, 3)
+		So(samples[2].Timestamp, ShouldEqual, 0)
+
+		_, err = RecordSamples(nil, rec, Columns{UserId: "user", ItemId: "itemId", Label: "label"})
+		So(err, ShouldNotBeNil)
+		// the bool is not an id
+		_, err = RecordSamples(nil, rec, Columns{UserId: "label", ItemId: "itemId", Label: "label"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("nulls", t, func() {
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+			{Name: "userId", Type: arrow.PrimitiveTypes.Uint32},
+			{Name: "itemId", Type: arrow.PrimitiveTypes.Uint32},
+			{Name: "label", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		}, nil))
+		defer b.Release()
+		b.Field(0).(*array.Uint32Builder).AppendValues([]uint32{1, 2}, nil)
+		b.Field(1).(*array.Uint32Builder).AppendValues([]uint32{3, 4}, nil)
+		b.Field(2).(*array.Float64Builder).AppendValues([]float64{0.5, 0}, []bool{true, false})
+		rec := b.NewRecord()
+		defer rec.Release()
+		_, err := RecordSamples(nil, rec, Columns{})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path string, stream bool) {
+		f, err := os.Create(path)
+		So(err, ShouldBeNil)
+		defer f.Close()
+		var w interface {
+			Write(array.Record) error
+			Close() error
+		}
+		if stream {
+			w = ipc.NewWriter(f, ipc.WithSchema(sampleSchema))
+		} else {
+			w, err = ipc.NewFileWriter(f, ipc.WithSchema(sampleSchema))
+			So(err, ShouldBeNil)
+		}
+		for i := 0; i < 3; i++ {
+			rec := sampleRecord(i*4, 4)
+			So(w.Write(rec), ShouldBeNil)
+			rec.Release()
+		}
+		So(w.Close(), ShouldBeNil)
+	}
+
+	Convey("the file and stream formats", t, func() {
+		for _, name := range []string{"samples.arrow", "samples.arrows"} {
+			path := filepath.Join(dir, name)
+			write(path, filepath.Ext(name) == ".arrows")
+			ch, err := (&File{Path: path}).SampleGenerator(context.Background())
+			So(err, ShouldBeNil)
+			samples := collect(ch)
+			So(samples, ShouldHaveLength, 12)
+			So(samples[11], ShouldResemble, rcmd.Sample{UserId: 11, ItemId: 110, Label: 1, Timestamp: 11})
+		}
+		_, err := (&File{Path: filepath.Join(dir, "missing.arrow")}).SampleGenerator(context.Background())
+		So(err, ShouldNotBeNil)
+		So(os.WriteFile(filepath.Join(dir, "samples.csv"), []byte("userId,itemId\n"), 0644), ShouldBeNil)
+		_, err = (&File{Path: filepath.Join(dir, "samples.csv")}).SampleGenerator(context.Background())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("train of the file samples", t, func() {
+		path := filepath.Join(dir, "train.arrow")
+		write(path, false)
+		userCache, itemCache := rcmd.UserFeatureCache, rcmd.ItemFeatureCache
+		defer func() {
+			rcmd.UserFeatureCache, rcmd.ItemFeatureCache = userCache, itemCache
+		}()
+		fitter := &rowsFitter{}
+		_, err := rcmd.Train(context.Background(), &fileRecSys{File: File{Path: path}}, fitter)
+		So(err, ShouldBeNil)
+		So(fitter.rows, ShouldEqual, 12)
+	})
+}
+
+// fileRecSys is the RecSys of the samples of File
+type fileRecSys struct {
+	File
+}
+
+func (r *fileRecSys) GetUserFeature(_ context.Context, userId int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{float32(userId)}, nil
+}
+
+func (r *fileRecSys) GetItemFeature(_ context.Context, itemId int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{float32(itemId)}, nil
+}
+
+// rowsFitter keeps the rows fitted
+type rowsFitter struct {
+	rows int
+}
+
+func (f *rowsFitter) Fit(sample *rcmd.TrainSample) (rcmd.PredictAbstract, error) {
+	f.rows = sample.Rows
+	return f, nil
+}
+
+func (f *rowsFitter) Predict(x tensor.Tensor) tensor.Tensor {
+	return tensor.New(tensor.WithShape(x.Shape()[0], 1), tensor.Of(tensor.Float32))
+}
+
+func TestFlightEndpoint(t *testing.T) {
+	Convey("do put", t, func() {
+		endpoint := NewFlightEndpoint(Columns{})
+		So(endpoint.Serve(), ShouldNotBeNil)
+		So(endpoint.Listen("localhost:0"), ShouldBeNil)
+		go func() {
+			_ = endpoint.Serve()
+		}()
+		defer endpoint.Shutdown()
+
+		client, err := flight.NewFlightClient(endpoint.Addr().String(), nil, grpc.WithInsecure())
+		So(err, ShouldBeNil)
+		defer client.Close()
+		put := func(records ...array.Record) (int, error) {
+			stream, err := client.DoPut(context.Background())
+			if err != nil {
+				return 0, err
+			}
+			w := ipc.NewFlightDataWriter(stream, ipc.WithSchema(records[0].Schema()))
+			for _, rec := range records {
+				if err = w.Write(rec); err != nil {
+					return 0, err
+				}
+			}
+			if err = w.Close(); err != nil {
+				return 0, err
+			}
+			if err = stream.CloseSend(); err != nil {
+				return 0, err
+			}
+			result, err := stream.Recv()
+			if err != nil {
+				return 0, err
+			}
+			return strconv.Atoi(string(result.AppMetadata))
+		}
+
+		n, err := put(sampleRecord(0, 5), sampleRecord(5, 5))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 10)
+		So(endpoint.Len(), ShouldEqual, 10)
+
+		// the stream of an invalid batch is dropped
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+			{Name: "userId", Type: arrow.PrimitiveTypes.Int64},
+		}, nil))
+		b.Field(0).(*array.Int64Builder).Append(1)
+		invalid := b.NewRecord()
+		b.Release()
+		_, err = put(invalid)
+		So(err, ShouldNotBeNil)
+		So(endpoint.Len(), ShouldEqual, 10)
+
+		rec := sampleRecord(10, 2)
+		So(endpoint.Append(rec), ShouldBeNil)
+		So(endpoint.Append(invalid), ShouldNotBeNil)
+		ch, err := endpoint.SampleGenerator(context.Background())
+		So(err, ShouldBeNil)
+		samples := collect(ch)
+		So(samples, ShouldHaveLength, 12)
+		So(samples[9].UserId, ShouldEqual, 9)
+		endpoint.Reset()
+		So(endpoint.Len(), ShouldEqual, 0)
+	})
+}
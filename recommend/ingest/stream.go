@@ -0,0 +1,375 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/auxten/go-ctr/proto/edgerecpb"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultWindow is the samples a producer of StreamEndpoint may send
+	// ahead of the acks
+	DefaultWindow = 1000
+	// TrainingDataMethod is the full method of the TrainingData RPC of the
+	// edgerecpb Ingest service
+	TrainingDataMethod = "/edgerec.v1.Ingest/TrainingData"
+)
+
+// requiredColumns are the Sample fields of a TrainingData stream, the
+// others are optional
+var requiredColumns = []string{"user_id", "item_id", "label"}
+
+// Sink takes the samples streamed, Put blocks until the samples are taken,
+// which holds the acks of the stream back
+type Sink interface {
+	Put(ctx context.Context, samples []rcmd.Sample) error
+}
+
+// OnlineTrainer is the Sink of rcmd.OnlineLearn, the Predictor must be an
+// rcmd.OnlineUpdater. The updates of the streams are serialized.
+type OnlineTrainer struct {
+	Predictor rcmd.Predictor
+	// Metrics observes the samples before the update if not nil
+	Metrics *rcmd.StreamingMetrics
+
+	mu sync.Mutex
+}
+
+func (o *OnlineTrainer) Put(ctx context.Context, samples []rcmd.Sample) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return rcmd.OnlineLearn(ctx, o.Predictor, samples, o.Metrics)
+}
+
+// Dataset is the Sink accumulating the samples for the next scheduled
+// retrain, it's the rcmd.Trainer of the samples accumulated. Put blocks
+// while the samples kept are MaxSamples, until they are taken.
+type Dataset struct {
+	// MaxSamples kept, no limit if 0
+	MaxSamples int
+
+	mu      sync.Mutex
+	samples []rcmd.Sample
+	taken   chan struct{}
+}
+
+func (d *Dataset) Put(ctx context.Context, samples []rcmd.Sample) error {
+	for {
+		d.mu.Lock()
+		// a batch over MaxSamples is kept if the dataset is empty
+		if d.MaxSamples <= 0 || len(d.samples) == 0 || len(d.samples)+len(samples) <= d.MaxSamples {
+			d.samples = append(d.samples, samples...)
+			d.mu.Unlock()
+			return nil
+		}
+		if d.taken == nil {
+			d.taken = make(chan struct{})
+		}
+		taken := d.taken
+		d.mu.Unlock()
+		select {
+		case <-taken:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Len is the number of the samples kept
+func (d *Dataset) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.samples)
+}
+
+// Take returns and drops the samples kept
+func (d *Dataset) Take() []rcmd.Sample {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	samples := d.samples
+	d.samples = nil
+	if d.taken != nil {
+		close(d.taken)
+		d.taken = nil
+	}
+	return samples
+}
+
+// SampleGenerator implements rcmd.Trainer, the samples kept are taken by the
+// call, so every retrain is of the samples streamed since the last one
+func (d *Dataset) SampleGenerator(ctx context.Context) (<-chan rcmd.Sample, error) {
+	samples := d.Take()
+	ch := make(chan rcmd.Sample, 10000)
+	go func() {
+		defer close(ch)
+		for _, s := range samples {
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// StreamEndpoint is the grpc service of the TrainingData streaming RPC, the
+// remote producers stream the edgerecpb samples to Sink. The schema is
+// negotiated by the first messages: the producer names the Sample columns
+// it sets, the endpoint replies the schema accepted, or FailedPrecondition
+// if a column is unknown, a required one is missing or the FeatureHash
+// differs. The producer then sends at most the credited samples ahead of
+// the acks, and every ack is sent after Sink.Put, so a slow Sink holds the
+// producers back.
+type StreamEndpoint struct {
+	Sink Sink
+	// Window is the samples credited to a stream, DefaultWindow if 0
+	Window int
+	// FeatureHash is of the rcmd.Manifest of the serving model, the streams
+	// of another FeatureHash are rejected, any if empty
+	FeatureHash string
+
+	server *grpc.Server
+	lis    net.Listener
+}
+
+// NewStreamEndpoint returns the StreamEndpoint of the samples to sink
+func NewStreamEndpoint(sink Sink) *StreamEndpoint {
+	return &StreamEndpoint{Sink: sink}
+}
+
+// Register registers the TrainingData RPC of e to s, Listen does it to a
+// new server
+func (e *StreamEndpoint) Register(s *grpc.Server) {
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "edgerec.v1.Ingest",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName: "TrainingData",
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				return e.trainingData(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		}},
+		Metadata: "edgerecpb/edgerec.proto",
+	}, e)
+}
+
+// Listen binds the endpoint to addr, e.g. ":8816" or "localhost:0", it's
+// served by Serve
+func (e *StreamEndpoint) Listen(addr string) (err error) {
+	if e.lis, err = net.Listen("tcp", addr); err != nil {
+		return fmt.Errorf("stream endpoint listen %s: %v", addr, err)
+	}
+	e.server = grpc.NewServer()
+	e.Register(e.server)
+	return
+}
+
+// Addr is the address bound by Listen
+func (e *StreamEndpoint) Addr() net.Addr {
+	return e.lis.Addr()
+}
+
+// Serve blocks serving the TrainingData until Shutdown
+func (e *StreamEndpoint) Serve() error {
+	if e.server == nil {
+		return fmt.Errorf("stream endpoint serve before listen")
+	}
+	return e.server.Serve(e.lis)
+}
+
+// Shutdown stops the endpoint after the streams in progress
+func (e *StreamEndpoint) Shutdown() {
+	if e.server != nil {
+		e.server.GracefulStop()
+	}
+}
+
+func (e *StreamEndpoint) window() int {
+	if e.Window <= 0 {
+		return DefaultWindow
+	}
+	return e.Window
+}
+
+// negotiate returns the response of the schema accepted
+func (e *StreamEndpoint) negotiate(schema *edgerecpb.StreamSchema) (*edgerecpb.TrainingDataResponse, error) {
+	if e.FeatureHash != "" && schema.GetFeatureHash() != "" && schema.GetFeatureHash() != e.FeatureHash {
+		return nil, fmt.Errorf("feature hash %s, expected %s", schema.GetFeatureHash(), e.FeatureHash)
+	}
+	if err := schema.CheckColumns(requiredColumns...); err != nil {
+		return nil, err
+	}
+	return &edgerecpb.TrainingDataResponse{
+		Schema:  edgerecpb.NewStreamSchema(e.FeatureHash, schema.GetColumns()...),
+		Credits: int32(e.window()),
+	}, nil
+}
+
+// batchSamples returns the samples of batch, the samples of the fields
+// unknown to the endpoint are rejected rather than losing the values
+func batchSamples(batch *edgerecpb.SampleBatch) ([]rcmd.Sample, error) {
+	for i, s := range batch.GetSamples() {
+		if len(s.ProtoReflect().GetUnknown()) > 0 {
+			return nil, fmt.Errorf("sample %d of unknown fields", i)
+		}
+	}
+	return batch.RcmdSamples()
+}
+
+func (e *StreamEndpoint) trainingData(stream grpc.ServerStream) (err error) {
+	req := &edgerecpb.TrainingDataRequest{}
+	if err = stream.RecvMsg(req); err != nil {
+		return
+	}
+	if req.GetSchema() == nil {
+		return status.Errorf(codes.InvalidArgument, "first message of no schema")
+	}
+	resp, err := e.negotiate(req.GetSchema())
+	if err != nil {
+		return status.Errorf(codes.FailedPrecondition, "schema negotiation: %v", err)
+	}
+	if err = stream.SendMsg(resp); err != nil {
+		return
+	}
+	var (
+		credits  = int(resp.GetCredits())
+		accepted int
+	)
+	defer func() {
+		log.Debugf("training data stream samples accepted: %d", accepted)
+	}()
+	for {
+		req = &edgerecpb.TrainingDataRequest{}
+		if err = stream.RecvMsg(req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return
+		}
+		batch := req.GetBatch()
+		if batch == nil {
+			return status.Errorf(codes.InvalidArgument, "message of no sample batch")
+		}
+		n := len(batch.GetSamples())
+		if n > credits {
+			return status.Errorf(codes.ResourceExhausted, "%d samples over the %d credits", n, credits)
+		}
+		credits -= n
+		samples, er := batchSamples(batch)
+		if er != nil {
+			return status.Errorf(codes.InvalidArgument, "%v", er)
+		}
+		if er = e.Sink.Put(stream.Context(), samples); er != nil {
+			return status.Errorf(codes.Unavailable, "put samples: %v", er)
+		}
+		accepted += n
+		credits += n
+		if err = stream.SendMsg(&edgerecpb.TrainingDataResponse{Accepted: int32(n), Credits: int32(n)}); err != nil {
+			return
+		}
+	}
+}
+
+// TrainingDataClient is the producer of a TrainingData stream, Send blocks
+// while the samples sent ahead of the acks are the credits of the endpoint
+type TrainingDataClient struct {
+	stream   grpc.ClientStream
+	schema   *edgerecpb.StreamSchema
+	credits  int
+	accepted int
+}
+
+// NewTrainingDataClient opens the TrainingData stream of cc and negotiates
+// schema, the error of the rejected schema is FailedPrecondition
+func NewTrainingDataClient(ctx context.Context, cc *grpc.ClientConn, schema *edgerecpb.StreamSchema) (c *TrainingDataClient, err error) {
+	stream, err := cc.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    "TrainingData",
+		ServerStreams: true,
+		ClientStreams: true,
+	}, TrainingDataMethod)
+	if err != nil {
+		return
+	}
+	req := &edgerecpb.TrainingDataRequest{Payload: &edgerecpb.TrainingDataRequest_Schema{Schema: schema}}
+	if err = stream.SendMsg(req); err != nil {
+		return
+	}
+	resp := &edgerecpb.TrainingDataResponse{}
+	if err = stream.RecvMsg(resp); err != nil {
+		return
+	}
+	if resp.GetSchema() == nil {
+		return nil, fmt.Errorf("training data stream of no negotiated schema")
+	}
+	return &TrainingDataClient{
+		stream:  stream,
+		schema:  resp.GetSchema(),
+		credits: int(resp.GetCredits()),
+	}, nil
+}
+
+// Schema is the negotiated schema of the endpoint
+func (c *TrainingDataClient) Schema() *edgerecpb.StreamSchema {
+	return c.schema
+}
+
+// Send sends the samples in the batches of the credits, only the columns
+// of the negotiated schema are taken by the endpoint
+func (c *TrainingDataClient) Send(samples []rcmd.Sample) (err error) {
+	for len(samples) > 0 {
+		for c.credits <= 0 {
+			if err = c.recv(); err != nil {
+				return
+			}
+		}
+		n := len(samples)
+		if n > c.credits {
+			n = c.credits
+		}
+		req := &edgerecpb.TrainingDataRequest{
+			Payload: &edgerecpb.TrainingDataRequest_Batch{Batch: edgerecpb.FromSamples(samples[:n])},
+		}
+		if err = c.stream.SendMsg(req); err != nil {
+			return
+		}
+		c.credits -= n
+		samples = samples[n:]
+	}
+	return
+}
+
+func (c *TrainingDataClient) recv() (err error) {
+	resp := &edgerecpb.TrainingDataResponse{}
+	if err = c.stream.RecvMsg(resp); err != nil {
+		return
+	}
+	c.credits += int(resp.GetCredits())
+	c.accepted += int(resp.GetAccepted())
+	return
+}
+
+// CloseAndRecv ends the stream after all the samples are acked, it returns
+// the samples accepted
+func (c *TrainingDataClient) CloseAndRecv() (accepted int, err error) {
+	if err = c.stream.CloseSend(); err != nil {
+		return
+	}
+	for {
+		if err = c.recv(); err == io.EOF {
+			return c.accepted, nil
+		} else if err != nil {
+			return c.accepted, err
+		}
+	}
+}
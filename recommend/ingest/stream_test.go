@@ -0,0 +1,181 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/auxten/go-ctr/proto/edgerecpb"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"gorgonia.org/tensor"
+)
+
+// onlineRecSys is the rcmd.OnlineUpdater keeping the samples updated
+type onlineRecSys struct {
+	fileRecSys
+	updated []rcmd.Sample
+}
+
+func (r *onlineRecSys) Predict(x tensor.Tensor) tensor.Tensor {
+	return tensor.New(tensor.WithShape(x.Shape()[0], 1), tensor.Of(tensor.Float32))
+}
+
+func (r *onlineRecSys) Update(_ context.Context, samples []rcmd.Sample) error {
+	r.updated = append(r.updated, samples...)
+	return nil
+}
+
+func startStreamEndpoint(e *StreamEndpoint) (*grpc.ClientConn, func()) {
+	So(e.Serve(), ShouldNotBeNil)
+	So(e.Listen("localhost:0"), ShouldBeNil)
+	go func() {
+		_ = e.Serve()
+	}()
+	cc, err := grpc.Dial(e.Addr().String(), grpc.WithInsecure())
+	So(err, ShouldBeNil)
+	return cc, func() {
+		_ = cc.Close()
+		e.Shutdown()
+	}
+}
+
+func TestStreamEndpoint(t *testing.T) {
+	ctx := context.Background()
+	schema := edgerecpb.NewStreamSchema("", "item_id", "user_id", "label", "timestamp")
+
+	Convey("schema negotiation", t, func() {
+		e := NewStreamEndpoint(&Dataset{})
+		e.FeatureHash = "abc"
+		cc, stop := startStreamEndpoint(e)
+		defer stop()
+
+		c, err := NewTrainingDataClient(ctx, cc, schema)
+		So(err, ShouldBeNil)
+		So(c.Schema().GetColumns(), ShouldResemble, schema.GetColumns())
+		So(c.Schema().GetFeatureHash(), ShouldEqual, "abc")
+		_, err = c.CloseAndRecv()
+		So(err, ShouldBeNil)
+
+		for _, s := range []*edgerecpb.StreamSchema{
+			edgerecpb.NewStreamSchema("", "user_id", "label"),
+			edgerecpb.NewStreamSchema("", "user_id", "item_id", "label", "user_id"),
+			// the values of an unknown column are not dropped
+			edgerecpb.NewStreamSchema("", "user_id", "item_id", "label", "price"),
+			edgerecpb.NewStreamSchema("def", "user_id", "item_id", "label"),
+		} {
+			_, err = NewTrainingDataClient(ctx, cc, s)
+			So(status.Code(err), ShouldEqual, codes.FailedPrecondition)
+		}
+	})
+
+	Convey("samples to the dataset of the retrain", t, func() {
+		dataset := &Dataset{}
+		e := NewStreamEndpoint(dataset)
+		e.Window = 3
+		cc, stop := startStreamEndpoint(e)
+		defer stop()
+
+		c, err := NewTrainingDataClient(ctx, cc, schema)
+		So(err, ShouldBeNil)
+		var samples []rcmd.Sample
+		for i := 0; i < 10; i++ {
+			samples = append(samples, rcmd.Sample{UserId: i, ItemId: i * 10, Label: float32(i % 2), Timestamp: 9})
+		}
+		So(c.Send(samples), ShouldBeNil)
+		n, err := c.CloseAndRecv()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 10)
+		So(dataset.Len(), ShouldEqual, 10)
+
+		ch, err := dataset.SampleGenerator(ctx)
+		So(err, ShouldBeNil)
+		So(collect(ch), ShouldResemble, samples)
+		// taken by the retrain
+		So(dataset.Len(), ShouldEqual, 0)
+
+		// the sample of a field unknown to the endpoint
+		c, err = NewTrainingDataClient(ctx, cc, schema)
+		So(err, ShouldBeNil)
+		sample := edgerecpb.FromSample(rcmd.Sample{UserId: 1, ItemId: 2})
+		sample.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 99, protowire.VarintType), 1))
+		batch := &edgerecpb.SampleBatch{Version: edgerecpb.Version, Samples: []*edgerecpb.Sample{sample}}
+		So(c.stream.SendMsg(&edgerecpb.TrainingDataRequest{Payload: &edgerecpb.TrainingDataRequest_Batch{Batch: batch}}), ShouldBeNil)
+		_, err = c.CloseAndRecv()
+		So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		So(dataset.Len(), ShouldEqual, 0)
+	})
+
+	Convey("backpressure of the full dataset", t, func() {
+		dataset := &Dataset{MaxSamples: 4}
+		e := NewStreamEndpoint(dataset)
+		e.Window = 2
+		cc, stop := startStreamEndpoint(e)
+		defer stop()
+
+		c, err := NewTrainingDataClient(ctx, cc, edgerecpb.NewStreamSchema("", "user_id", "item_id", "label"))
+		So(err, ShouldBeNil)
+		sent := make(chan error, 1)
+		go func() {
+			samples := make([]rcmd.Sample, 8)
+			for i := range samples {
+				samples[i] = rcmd.Sample{UserId: i, ItemId: 1, Label: 1}
+			}
+			if err := c.Send(samples); err != nil {
+				sent <- err
+				return
+			}
+			_, err := c.CloseAndRecv()
+			sent <- err
+		}()
+		for dataset.Len() < 4 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		select {
+		case err = <-sent:
+			t.Fatalf("send not held back by the full dataset: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		So(dataset.Len(), ShouldEqual, 4)
+		taken := dataset.Take()
+		So(<-sent, ShouldBeNil)
+		So(len(taken)+dataset.Len(), ShouldEqual, 8)
+	})
+
+	Convey("samples to the online trainer", t, func() {
+		recSys := &onlineRecSys{}
+		cc, stop := startStreamEndpoint(NewStreamEndpoint(&OnlineTrainer{Predictor: recSys}))
+		defer stop()
+
+		c, err := NewTrainingDataClient(ctx, cc, schema)
+		So(err, ShouldBeNil)
+		samples := []rcmd.Sample{
+			{UserId: 1, ItemId: 2, Label: 1, Timestamp: 100},
+			{UserId: 3, ItemId: 4, Label: 0, Timestamp: 200},
+		}
+		So(c.Send(samples), ShouldBeNil)
+		n, err := c.CloseAndRecv()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+		So(recSys.updated, ShouldResemble, samples)
+
+		// not an OnlineUpdater
+		cc2, stop2 := startStreamEndpoint(NewStreamEndpoint(&OnlineTrainer{Predictor: &fileRecSysPredictor{}}))
+		defer stop2()
+		c, err = NewTrainingDataClient(ctx, cc2, schema)
+		So(err, ShouldBeNil)
+		So(c.Send(samples[:1]), ShouldBeNil)
+		_, err = c.CloseAndRecv()
+		So(status.Code(err), ShouldEqual, codes.Unavailable)
+	})
+}
+
+// fileRecSysPredictor is the rcmd.Predictor not learning online
+type fileRecSysPredictor struct {
+	fileRecSys
+	rowsFitter
+}
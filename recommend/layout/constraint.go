@@ -0,0 +1,255 @@
+package layout
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+// Constraint bounds the items of the slate having the Attribute of Value,
+// e.g. at least one of category X is
+//
+//	{Attribute: "category", Value: "X", Min: 1}
+//
+// Empty Value bounds the items of every value by Max, e.g. no more than two
+// of the same seller is
+//
+//	{Attribute: "seller", Max: 2}
+type Constraint struct {
+	Attribute string `json:"attribute" yaml:"attribute"`
+	Value     string `json:"value" yaml:"value"`
+	// Min items of the slate, 0 is no lower bound
+	Min int `json:"min" yaml:"min"`
+	// Max items of the slate, 0 is no upper bound
+	Max int `json:"max" yaml:"max"`
+}
+
+// Validate checks the constraint is well-formed
+func (c *Constraint) Validate() error {
+	switch {
+	case c.Attribute == "":
+		return fmt.Errorf("constraint of empty attribute")
+	case c.Min < 0 || c.Max < 0:
+		return fmt.Errorf("constraint %s of negative bounds [%d, %d]", c, c.Min, c.Max)
+	case c.Max > 0 && c.Min > c.Max:
+		return fmt.Errorf("constraint %s of min %d over max %d", c, c.Min, c.Max)
+	case c.Min > 0 && c.Value == "":
+		return fmt.Errorf("constraint %s of min but no value", c)
+	case c.Min == 0 && c.Max == 0:
+		return fmt.Errorf("constraint %s of no bound", c)
+	}
+	return nil
+}
+
+func (c Constraint) String() string {
+	if c.Value == "" {
+		return c.Attribute
+	}
+	return c.Attribute + "=" + c.Value
+}
+
+// key is the value counted by c of the attributes, false if not counted
+func (c *Constraint) key(attributes map[string]string) (string, bool) {
+	v, ok := attributes[c.Attribute]
+	if !ok || (c.Value != "" && v != c.Value) {
+		return "", false
+	}
+	return v, true
+}
+
+// SlateComposer selects the top K of the scored candidates subject to the
+// Constraints. It's greedy by score with the slots reserved for the unmet
+// Min constraints, then the unmet ones are repaired by swapping the lowest
+// items out, which is usually the optimum of the few constraints of a slate
+// without solving the integer program.
+type SlateComposer struct {
+	K           int
+	Constraints []Constraint
+}
+
+// NewSlateComposer returns the validated SlateComposer
+func NewSlateComposer(k int, constraints ...Constraint) (*SlateComposer, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("slate size %d should be positive", k)
+	}
+	for i := range constraints {
+		if err := constraints[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &SlateComposer{K: k, Constraints: constraints}, nil
+}
+
+// slate is the items selected and their counts by the constraints
+type slate struct {
+	constraints []Constraint
+	items       []int
+	counts      []map[string]int
+}
+
+// notCounted is the key of the item not counted by a constraint, it's never
+// an attribute value
+const notCounted = "\x00"
+
+// keys are the counted values of the constraints of the attributes
+func (s *slate) keys(attributes map[string]string) []string {
+	keys := make([]string, len(s.constraints))
+	for i := range s.constraints {
+		if k, ok := s.constraints[i].key(attributes); ok {
+			keys[i] = k
+		} else {
+			keys[i] = notCounted
+		}
+	}
+	return keys
+}
+
+func counted(key string) bool {
+	return key != notCounted
+}
+
+func (s *slate) count(keys []string, delta int) {
+	for c, k := range keys {
+		if counted(k) {
+			s.counts[c][k] += delta
+		}
+	}
+}
+
+func (s *slate) add(i int, keys []string) {
+	s.count(keys, 1)
+	s.items = append(s.items, i)
+}
+
+// fits is true if the item of keys breaks no Max
+func (s *slate) fits(keys []string) bool {
+	for c, k := range keys {
+		if counted(k) && s.constraints[c].Max > 0 && s.counts[c][k] >= s.constraints[c].Max {
+			return false
+		}
+	}
+	return true
+}
+
+// deficit is the items missing of the Min constraints, less the ones of keys
+func (s *slate) deficit(keys []string) (d int) {
+	for c := range s.constraints {
+		missing := s.constraints[c].Min - s.counts[c][s.constraints[c].Value]
+		if keys != nil && counted(keys[c]) {
+			missing--
+		}
+		if missing > 0 {
+			d += missing
+		}
+	}
+	return
+}
+
+// Compose returns the slate of candidates by the item attributes of
+// attributer, in the score order, and the Min constraints unmet if the
+// candidates are not enough.
+func (sc *SlateComposer) Compose(ctx context.Context, attributer rcmd.ItemAttributer, candidates []rcmd.ItemScore) (
+	result []rcmd.ItemScore, unmet []Constraint, err error,
+) {
+	sorted := make([]rcmd.ItemScore, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Score > sorted[j].Score
+	})
+	s := &slate{constraints: sc.Constraints, counts: make([]map[string]int, len(sc.Constraints))}
+	for c := range s.counts {
+		s.counts[c] = make(map[string]int)
+	}
+	keys := make([][]string, len(sorted))
+	for i, is := range sorted {
+		var attributes map[string]string
+		if len(sc.Constraints) != 0 {
+			if attributes, err = attributer.GetItemAttributes(ctx, is.ItemId); err != nil {
+				return nil, nil, err
+			}
+		}
+		keys[i] = s.keys(attributes)
+	}
+
+	used := make([]bool, len(sorted))
+	// greedy, the items not reducing the deficit are skipped if the rest of
+	// the slots are reserved for it
+	for i := range sorted {
+		if len(s.items) == sc.K {
+			break
+		}
+		if !s.fits(keys[i]) {
+			continue
+		}
+		if s.deficit(keys[i]) > sc.K-len(s.items)-1 && s.deficit(keys[i]) == s.deficit(nil) {
+			continue
+		}
+		s.add(i, keys[i])
+		used[i] = true
+	}
+	sc.repair(s, keys, used)
+	// fill the slots reserved for the deficit not repaired
+	for i := range sorted {
+		if len(s.items) == sc.K {
+			break
+		}
+		if !used[i] && s.fits(keys[i]) {
+			s.add(i, keys[i])
+			used[i] = true
+		}
+	}
+
+	sort.Ints(s.items)
+	result = make([]rcmd.ItemScore, len(s.items))
+	for j, i := range s.items {
+		result[j] = sorted[i]
+	}
+	for c := range sc.Constraints {
+		if s.counts[c][sc.Constraints[c].Value] < sc.Constraints[c].Min {
+			unmet = append(unmet, sc.Constraints[c])
+		}
+	}
+	return
+}
+
+// repair swaps the unused candidates reducing the deficit in, for the lowest
+// items of the slate not needed by the Min constraints
+func (sc *SlateComposer) repair(s *slate, keys [][]string, used []bool) {
+	for s.deficit(nil) > 0 {
+		swapped := false
+		for i := range keys {
+			if used[i] || s.deficit(keys[i]) == s.deficit(nil) {
+				continue
+			}
+			if len(s.items) < sc.K && s.fits(keys[i]) {
+				s.add(i, keys[i])
+				used[i], swapped = true, true
+				break
+			}
+			// the lowest first
+			for j := len(s.items) - 1; j >= 0 && !swapped; j-- {
+				out := s.items[j]
+				before := s.deficit(nil)
+				s.count(keys[out], -1)
+				if s.fits(keys[i]) && s.deficit(keys[i]) < before {
+					s.items = append(s.items[:j], s.items[j+1:]...)
+					used[out] = false
+					s.add(i, keys[i])
+					used[i], swapped = true, true
+				} else {
+					s.count(keys[out], 1)
+				}
+			}
+			if swapped {
+				break
+			}
+		}
+		if !swapped {
+			return
+		}
+		// the items in the score order
+		sort.Ints(s.items)
+	}
+}
@@ -0,0 +1,123 @@
+package layout
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeCatalog map[int]map[string]string
+
+func (c fakeCatalog) GetItemAttributes(_ context.Context, itemId int) (map[string]string, error) {
+	attributes, ok := c[itemId]
+	if !ok {
+		return nil, fmt.Errorf("item %d not found", itemId)
+	}
+	return attributes, nil
+}
+
+func scored(ids ...int) []rcmd.ItemScore {
+	list := make([]rcmd.ItemScore, len(ids))
+	for i, id := range ids {
+		// the scores descend by the order of ids
+		list[i] = rcmd.ItemScore{ItemId: id, Score: float32(len(ids) - i)}
+	}
+	return list
+}
+
+func TestSlateComposer(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		catalog = fakeCatalog{
+			1: {"category": "movie", "seller": "a", "sponsored": "true"},
+			2: {"category": "movie", "seller": "a", "sponsored": "true"},
+			3: {"category": "movie", "seller": "a"},
+			4: {"category": "movie", "seller": "b"},
+			5: {"category": "book", "seller": "b"},
+			6: {"category": "music", "seller": "c"},
+			7: {"category": "book", "seller": "c"},
+		}
+		atLeastOneBook = Constraint{Attribute: "category", Value: "book", Min: 1}
+		twoPerSeller   = Constraint{Attribute: "seller", Max: 2}
+		oneSponsored   = Constraint{Attribute: "sponsored", Value: "true", Max: 1}
+	)
+
+	Convey("greedy with the reserved slots", t, func() {
+		sc, err := NewSlateComposer(4, atLeastOneBook, twoPerSeller, oneSponsored)
+		So(err, ShouldBeNil)
+		slate, unmet, err := sc.Compose(ctx, catalog, scored(1, 2, 3, 4, 5, 6, 7))
+		So(err, ShouldBeNil)
+		So(unmet, ShouldBeEmpty)
+		// 2 is the second sponsored, the last slot is reserved for the book
+		So(ids(slate), ShouldResemble, []int{1, 3, 4, 5})
+
+		sc, err = NewSlateComposer(5, twoPerSeller, oneSponsored)
+		So(err, ShouldBeNil)
+		slate, _, err = sc.Compose(ctx, catalog, scored(1, 2, 3, 4, 5, 6, 7))
+		So(err, ShouldBeNil)
+		So(ids(slate), ShouldResemble, []int{1, 3, 4, 5, 6})
+
+		// the last slot is reserved for the book
+		sc, err = NewSlateComposer(3, atLeastOneBook)
+		So(err, ShouldBeNil)
+		slate, _, err = sc.Compose(ctx, catalog, scored(1, 2, 3, 4, 7))
+		So(err, ShouldBeNil)
+		So(ids(slate), ShouldResemble, []int{1, 2, 7})
+	})
+
+	Convey("repair by swapping the lowest out", t, func() {
+		// the books are only of seller b, with the movie of b before them
+		sc, err := NewSlateComposer(3,
+			Constraint{Attribute: "category", Value: "book", Min: 1},
+			Constraint{Attribute: "seller", Value: "b", Max: 1},
+		)
+		So(err, ShouldBeNil)
+		slate, unmet, err := sc.Compose(ctx, catalog, scored(4, 1, 2, 5))
+		So(err, ShouldBeNil)
+		So(unmet, ShouldBeEmpty)
+		So(ids(slate), ShouldResemble, []int{1, 2, 5})
+	})
+
+	Convey("unmet constraints of the candidates not enough", t, func() {
+		sc, err := NewSlateComposer(3, atLeastOneBook, Constraint{Attribute: "category", Value: "music", Min: 1})
+		So(err, ShouldBeNil)
+		slate, unmet, err := sc.Compose(ctx, catalog, scored(1, 2, 3, 5))
+		So(err, ShouldBeNil)
+		So(ids(slate), ShouldResemble, []int{1, 2, 5})
+		So(unmet, ShouldResemble, []Constraint{{Attribute: "category", Value: "music", Min: 1}})
+
+		slate, _, err = sc.Compose(ctx, catalog, scored(1))
+		So(err, ShouldBeNil)
+		So(ids(slate), ShouldResemble, []int{1})
+
+		_, _, err = sc.Compose(ctx, catalog, scored(1, 100))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("no constraint is the top k", t, func() {
+		sc, err := NewSlateComposer(2)
+		So(err, ShouldBeNil)
+		slate, unmet, err := sc.Compose(ctx, nil, []rcmd.ItemScore{{ItemId: 1, Score: 0.1}, {ItemId: 2, Score: 0.5}, {ItemId: 3, Score: 0.3}})
+		So(err, ShouldBeNil)
+		So(unmet, ShouldBeEmpty)
+		So(ids(slate), ShouldResemble, []int{2, 3})
+	})
+
+	Convey("invalid constraints", t, func() {
+		_, err := NewSlateComposer(0)
+		So(err, ShouldNotBeNil)
+		for _, c := range []Constraint{
+			{Value: "book", Min: 1},
+			{Attribute: "category", Value: "book", Min: -1},
+			{Attribute: "category", Value: "book", Min: 2, Max: 1},
+			{Attribute: "category", Min: 1},
+			{Attribute: "category", Value: "book"},
+		} {
+			_, err = NewSlateComposer(3, c)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,326 @@
+// Package layout composes the recommendation slates of the slot templates,
+// e.g. how many trending vs personalized slots, and chooses the template per
+// user segment by a multi-armed bandit of the observed engagement. The
+// bandit is updated online and persisted to a JSON file.
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/auxten/go-ctr/model/encryption"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+// Template is a slate layout, Slots is the source of every position, e.g.
+// ["personalized", "trending", "personalized"]
+type Template struct {
+	Name  string   `json:"name" yaml:"name"`
+	Slots []string `json:"slots" yaml:"slots"`
+}
+
+// Validate checks the template is well-formed
+func (t *Template) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("template name is empty")
+	}
+	if len(t.Slots) == 0 {
+		return fmt.Errorf("template %s has no slot", t.Name)
+	}
+	for i, s := range t.Slots {
+		if s == "" {
+			return fmt.Errorf("template %s slot %d has no source", t.Name, i)
+		}
+	}
+	return nil
+}
+
+// Compose fills the slots of t in order by the items of the sources, the
+// items already placed are skipped. A slot of an exhausted or missing source
+// is filled by the other sources in the slot order of t, so the slate is
+// short only if all the sources are exhausted.
+func Compose(t Template, sources map[string][]rcmd.ItemScore) []rcmd.ItemScore {
+	var (
+		next   = make(map[string]int, len(sources))
+		placed = make(map[int]struct{}, len(t.Slots))
+		slate  = make([]rcmd.ItemScore, 0, len(t.Slots))
+	)
+	take := func(source string) bool {
+		items := sources[source]
+		for next[source] < len(items) {
+			is := items[next[source]]
+			next[source]++
+			if _, ok := placed[is.ItemId]; ok {
+				continue
+			}
+			placed[is.ItemId] = struct{}{}
+			slate = append(slate, is)
+			return true
+		}
+		return false
+	}
+	for _, source := range t.Slots {
+		if take(source) {
+			continue
+		}
+		for _, other := range t.Slots {
+			if other != source && take(other) {
+				break
+			}
+		}
+	}
+	return slate
+}
+
+// Experiment is the experiment name of the templates forced by
+// rcmd.ArmOverride, e.g. the header "X-Arm-Override: layout=trending"
+const Experiment = "layout"
+
+// ArmStats is the observed slates of a template in a segment
+type ArmStats struct {
+	Impressions int64 `json:"impressions"`
+	Engagements int64 `json:"engagements"`
+}
+
+// Rate is the posterior mean of the engagement rate
+func (a ArmStats) Rate() float64 {
+	return float64(a.Engagements+1) / float64(a.Impressions+2)
+}
+
+// Bandit chooses the template per segment by Thompson sampling of the
+// Beta(1+engagements, 1+impressions-engagements) posteriors of the
+// engagement rates
+type Bandit struct {
+	templates []Template
+
+	mu   sync.Mutex
+	rnd  *rand.Rand
+	arms map[string]map[string]*ArmStats
+}
+
+// NewBandit creates the Bandit of templates, the sampling is by seed
+func NewBandit(templates []Template, seed int64) (*Bandit, error) {
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("no template")
+	}
+	names := make(map[string]struct{}, len(templates))
+	for i := range templates {
+		if err := templates[i].Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := names[templates[i].Name]; ok {
+			return nil, fmt.Errorf("duplicated template %s", templates[i].Name)
+		}
+		names[templates[i].Name] = struct{}{}
+	}
+	return &Bandit{
+		templates: templates,
+		rnd:       rand.New(rand.NewSource(seed)),
+		arms:      make(map[string]map[string]*ArmStats),
+	}, nil
+}
+
+// Templates returns the templates of b
+func (b *Bandit) Templates() []Template {
+	return b.templates
+}
+
+// Choose samples the template of segment. The template forced by
+// rcmd.ArmOverride of Experiment in ctx takes precedence if it's one of b.
+func (b *Bandit) Choose(ctx context.Context, segment string) Template {
+	if forced, ok := rcmd.ArmOverride(ctx, Experiment); ok {
+		for _, t := range b.templates {
+			if t.Name == forced {
+				return t
+			}
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var (
+		best     int
+		bestDraw = -1.0
+		arms     = b.arms[segment]
+	)
+	for i, t := range b.templates {
+		var a ArmStats
+		if arms != nil && arms[t.Name] != nil {
+			a = *arms[t.Name]
+		}
+		draw := sampleBeta(b.rnd, float64(1+a.Engagements), float64(1+a.Impressions-a.Engagements))
+		if draw > bestDraw {
+			best, bestDraw = i, draw
+		}
+	}
+	return b.templates[best]
+}
+
+// Compose chooses the template of segment and composes its slate of sources
+func (b *Bandit) Compose(ctx context.Context, segment string, sources map[string][]rcmd.ItemScore) (Template, []rcmd.ItemScore) {
+	t := b.Choose(ctx, segment)
+	return t, Compose(t, sources)
+}
+
+// Observe records a slate of template served in segment, engaged if the user
+// engaged with any of its items
+func (b *Bandit) Observe(segment, template string, engaged bool) error {
+	if !b.hasTemplate(template) {
+		return fmt.Errorf("unknown template %s", template)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a := b.arm(segment, template)
+	a.Impressions++
+	if engaged {
+		a.Engagements++
+	}
+	return nil
+}
+
+func (b *Bandit) hasTemplate(name string) bool {
+	for _, t := range b.templates {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// arm returns the stats of template in segment, b.mu must be held
+func (b *Bandit) arm(segment, template string) *ArmStats {
+	arms, ok := b.arms[segment]
+	if !ok {
+		arms = make(map[string]*ArmStats)
+		b.arms[segment] = arms
+	}
+	a, ok := arms[template]
+	if !ok {
+		a = &ArmStats{}
+		arms[template] = a
+	}
+	return a
+}
+
+// Stats returns the stats by segment and template
+func (b *Bandit) Stats() map[string]map[string]ArmStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make(map[string]map[string]ArmStats, len(b.arms))
+	for segment, arms := range b.arms {
+		stats[segment] = make(map[string]ArmStats, len(arms))
+		for t, a := range arms {
+			stats[segment][t] = *a
+		}
+	}
+	return stats
+}
+
+// Save writes the stats to path as JSON, the file is replaced atomically
+// and encrypted of the storage key, see package encryption
+func (b *Bandit) Save(path string) (err error) {
+	data, err := json.Marshal(b.Stats())
+	if err != nil {
+		return
+	}
+	if data, err = encryption.Encode(data); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load restores the stats saved to path, the stats of the templates no
+// longer in b are dropped
+func (b *Bandit) Load(path string) (err error) {
+	data, err := encryption.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var stats map[string]map[string]ArmStats
+	if err = json.Unmarshal(data, &stats); err != nil {
+		return fmt.Errorf("load bandit %s: %v", path, err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.arms = make(map[string]map[string]*ArmStats, len(stats))
+	for segment, arms := range stats {
+		for t, a := range arms {
+			if !b.hasTemplate(t) {
+				continue
+			}
+			if a.Impressions < 0 || a.Engagements < 0 || a.Engagements > a.Impressions {
+				return fmt.Errorf("bandit %s: invalid stats %+v of %s %s", path, a, segment, t)
+			}
+			*b.arm(segment, t) = a
+		}
+	}
+	return nil
+}
+
+// SaveEvery saves to path every interval and when ctx is done
+func (b *Bandit) SaveEvery(ctx context.Context, path string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := b.Save(path); err != nil {
+					log.Errorf("save layout bandit error: %v", err)
+				}
+				return
+			case <-ticker.C:
+				if err := b.Save(path); err != nil {
+					log.Errorf("save layout bandit error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// sampleBeta draws Beta(alpha, beta) by two Gamma draws
+func sampleBeta(rnd *rand.Rand, alpha, beta float64) float64 {
+	x := sampleGamma(rnd, alpha)
+	y := sampleGamma(rnd, beta)
+	return x / (x + y)
+}
+
+// sampleGamma draws Gamma(shape, 1) by Marsaglia and Tsang's method
+func sampleGamma(rnd *rand.Rand, shape float64) float64 {
+	if shape < 1 {
+		return sampleGamma(rnd, shape+1) * math.Pow(rnd.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rnd.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rnd.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package layout
+
+import (
+	"context"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func items(ids ...int) []rcmd.ItemScore {
+	list := make([]rcmd.ItemScore, len(ids))
+	for i, id := range ids {
+		list[i] = rcmd.ItemScore{ItemId: id}
+	}
+	return list
+}
+
+func ids(slate []rcmd.ItemScore) []int {
+	list := make([]int, len(slate))
+	for i, is := range slate {
+		list[i] = is.ItemId
+	}
+	return list
+}
+
+func TestCompose(t *testing.T) {
+	Convey("compose the slots", t, func() {
+		tmpl := Template{Name: "mixed", Slots: []string{"personalized", "trending", "personalized", "trending"}}
+		slate := Compose(tmpl, map[string][]rcmd.ItemScore{
+			"personalized": items(1, 2, 3),
+			"trending":     items(2, 9),
+		})
+		So(ids(slate), ShouldResemble, []int{1, 2, 3, 9})
+
+		// trending exhausted after 9, filled by personalized
+		slate = Compose(tmpl, map[string][]rcmd.ItemScore{
+			"personalized": items(1, 2, 3, 4),
+			"trending":     items(9),
+		})
+		So(ids(slate), ShouldResemble, []int{1, 9, 2, 3})
+		So(Compose(tmpl, nil), ShouldBeEmpty)
+
+		So((&Template{Name: "empty"}).Validate(), ShouldNotBeNil)
+	})
+}
+
+func TestBandit(t *testing.T) {
+	templates := []Template{
+		{Name: "personalized", Slots: []string{"personalized", "personalized"}},
+		{Name: "trending", Slots: []string{"trending", "personalized"}},
+	}
+	ctx := context.Background()
+	Convey("converge to the engaging template per segment", t, func() {
+		b, err := NewBandit(templates, 1)
+		So(err, ShouldBeNil)
+		rates := map[string]map[string]float64{
+			"new":    {"personalized": 0.05, "trending": 0.3},
+			"active": {"personalized": 0.4, "trending": 0.1},
+		}
+		rnd := rand.New(rand.NewSource(2))
+		chosen := map[string]map[string]int{"new": {}, "active": {}}
+		for i := 0; i < 2000; i++ {
+			for segment, rate := range rates {
+				tmpl := b.Choose(ctx, segment)
+				So(b.Observe(segment, tmpl.Name, rnd.Float64() < rate[tmpl.Name]), ShouldBeNil)
+				if i >= 1000 {
+					chosen[segment][tmpl.Name]++
+				}
+			}
+		}
+		So(chosen["new"]["trending"], ShouldBeGreaterThan, 900)
+		So(chosen["active"]["personalized"], ShouldBeGreaterThan, 900)
+		So(b.Observe("new", "unknown", true), ShouldNotBeNil)
+
+		Convey("persisted", func() {
+			path := filepath.Join(t.TempDir(), "bandit.json")
+			So(b.Save(path), ShouldBeNil)
+			loaded, err := NewBandit(templates[:1], 1)
+			So(err, ShouldBeNil)
+			So(loaded.Load(path), ShouldBeNil)
+			stats := loaded.Stats()
+			So(stats["active"]["personalized"], ShouldResemble, b.Stats()["active"]["personalized"])
+			_, ok := stats["active"]["trending"]
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("template forced by the arm override", t, func() {
+		b, err := NewBandit(templates, 1)
+		So(err, ShouldBeNil)
+		for i := 0; i < 100; i++ {
+			So(b.Observe("new", "personalized", true), ShouldBeNil)
+			So(b.Observe("new", "trending", false), ShouldBeNil)
+		}
+		So(b.Choose(ctx, "new").Name, ShouldEqual, "personalized")
+		forced := rcmd.WithRequestContext(ctx, &rcmd.RequestContext{
+			ArmOverrides: map[string]string{Experiment: "trending"},
+		})
+		tmpl, slate := b.Compose(forced, "new", map[string][]rcmd.ItemScore{
+			"personalized": items(1),
+			"trending":     items(9),
+		})
+		So(tmpl.Name, ShouldEqual, "trending")
+		So(ids(slate), ShouldResemble, []int{9, 1})
+		// an unknown template is sampled
+		unknown := rcmd.WithRequestContext(ctx, &rcmd.RequestContext{
+			ArmOverrides: map[string]string{Experiment: "unknown"},
+		})
+		So(b.Choose(unknown, "new").Name, ShouldEqual, "personalized")
+	})
+
+	Convey("invalid templates", t, func() {
+		_, err := NewBandit(nil, 1)
+		So(err, ShouldNotBeNil)
+		_, err = NewBandit([]Template{templates[0], templates[0]}, 1)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,142 @@
+package recommend
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"runtime/debug"
+	"time"
+)
+
+// CodeVersion is recorded in the Manifest, the default is the vcs revision
+// of the binary. Set it by -ldflags or at startup if built without vcs info.
+var CodeVersion = buildVersion()
+
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if revision == "" {
+		return info.Main.Version
+	}
+	if modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// DataSource is a source the training data is read from
+type DataSource struct {
+	Name         string `json:"name"`
+	Query        string `json:"query,omitempty"`
+	Rows         int    `json:"rows"`
+	MinTimestamp int64  `json:"minTimestamp,omitempty"`
+	MaxTimestamp int64  `json:"maxTimestamp,omitempty"`
+}
+
+// LineageReporter could be implemented by the RecSys to record the queries
+// and the row counts of its sources in the Manifest
+type LineageReporter interface {
+	DataSources(ctx context.Context) ([]DataSource, error)
+}
+
+// Manifest is the lineage of a trained model: which data, features and code
+// produced it
+type Manifest struct {
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	Sources    []DataSource `json:"sources,omitempty"`
+	// Samples is the training sample rows, Positives of them have label > 0.5
+	Samples      int        `json:"samples"`
+	Positives    int        `json:"positives"`
+	MinTimestamp int64      `json:"minTimestamp"`
+	MaxTimestamp int64      `json:"maxTimestamp"`
+	SampleInfo   SampleInfo `json:"sampleInfo"`
+	FeatureHash  string     `json:"featureHash"`
+	CodeVersion  string     `json:"codeVersion"`
+	// Quality is the profile of the training sample
+	Quality *QualityReport `json:"quality,omitempty"`
+	// Normalizer of the sample vectors, applied by the serving assembler
+	Normalizer *Normalizer `json:"normalizer,omitempty"`
+	// FeatureFuncs of the custom features, the serving vectors are assembled
+	// of the registered funcs of the names
+	FeatureFuncs []FeatureFuncInfo `json:"featureFuncs,omitempty"`
+	// Biases maps the ids to the bias table rows of the model, appended by
+	// the serving assembler
+	Biases *BiasBuckets `json:"biases,omitempty"`
+	// Correction of the downsampled samples, the scores of the model are
+	// calibrated by it
+	Correction *Correction `json:"correction,omitempty"`
+}
+
+// ManifestProvider is implemented by the Predictor returned by Train
+type ManifestProvider interface {
+	Manifest() *Manifest
+}
+
+// FeatureHash identifies the feature pipeline: the sample layout, the item
+// embedding settings, the feature names if provider is a FeatureNamer and
+// the FeatureFuncs. Models of the same hash accept the same input.
+func FeatureHash(si SampleInfo, provider BasicFeatureProvider, featureFuncs ...FeatureFuncInfo) string {
+	pipeline := struct {
+		SampleInfo       SampleInfo
+		ItemEmbDim       int
+		ItemEmbWindow    int
+		UserBehaviorLen  int
+		ItemEmbedding    bool
+		UserFeatureNames []string
+		ItemFeatureNames []string
+		FeatureFuncs     []FeatureFuncInfo `json:",omitempty"`
+	}{
+		SampleInfo:      si,
+		ItemEmbDim:      ItemEmbDim,
+		ItemEmbWindow:   ItemEmbWindow,
+		UserBehaviorLen: UserBehaviorLen,
+		FeatureFuncs:    featureFuncs,
+	}
+	_, pipeline.ItemEmbedding = provider.(ItemEmbedding)
+	if namer, ok := provider.(FeatureNamer); ok {
+		pipeline.UserFeatureNames = namer.UserFeatureNames()
+		pipeline.ItemFeatureNames = namer.ItemFeatureNames()
+	}
+	data, _ := json.Marshal(pipeline)
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newManifest records the lineage of the trainSample of recSys
+func newManifest(ctx context.Context, recSys RecSys, startedAt time.Time, trainSample *TrainSample) (m *Manifest, err error) {
+	m = &Manifest{
+		StartedAt:    startedAt,
+		Samples:      trainSample.Rows,
+		MinTimestamp: trainSample.MinTimestamp,
+		MaxTimestamp: trainSample.MaxTimestamp,
+		SampleInfo:   trainSample.Info,
+		FeatureHash:  FeatureHash(trainSample.Info, recSys, trainSample.FeatureFuncs...),
+		CodeVersion:  CodeVersion,
+		FeatureFuncs: trainSample.FeatureFuncs,
+		Biases:       trainSample.Biases,
+	}
+	for _, y := range trainSample.Y {
+		if y > 0.5 {
+			m.Positives++
+		}
+	}
+	if reporter, ok := recSys.(LineageReporter); ok {
+		if m.Sources, err = reporter.DataSources(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
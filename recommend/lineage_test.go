@@ -0,0 +1,107 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// lineageRecSys generates 4 samples of 2 positives, and reports its source
+type lineageRecSys struct {
+	fakeProvider
+}
+
+func (r *lineageRecSys) SampleGenerator(context.Context) (<-chan Sample, error) {
+	ch := make(chan Sample, 4)
+	for i := 0; i < 4; i++ {
+		ch <- Sample{UserId: i, ItemId: i, Label: float32(i % 2), Timestamp: int64(100 + i)}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (r *lineageRecSys) DataSources(context.Context) ([]DataSource, error) {
+	return []DataSource{{Name: "ratings", Query: "SELECT * FROM ratings", Rows: 4}}, nil
+}
+
+// sampledRecSys is the lineageRecSys of the downsampled samples
+type sampledRecSys struct {
+	lineageRecSys
+	correction *Correction
+}
+
+func (r *sampledRecSys) SamplingCorrection() *Correction {
+	return r.correction
+}
+
+type sumFitter struct{}
+
+func (f *sumFitter) Fit(*TrainSample) (PredictAbstract, error) {
+	return &sumPredictor{}, nil
+}
+
+func TestLineage(t *testing.T) {
+	userCache, itemCache := UserFeatureCache, ItemFeatureCache
+	defer func() {
+		UserFeatureCache, ItemFeatureCache = userCache, itemCache
+	}()
+
+	Convey("manifest of the trained model", t, func() {
+		m, err := Train(context.Background(), &lineageRecSys{}, &sumFitter{})
+		So(err, ShouldBeNil)
+		// the serving vectors get the behaviors of the RecSys
+		So(m, ShouldImplement, (*UserBehavior)(nil))
+		manifest := m.(ManifestProvider).Manifest()
+		So(manifest.Samples, ShouldEqual, 4)
+		So(manifest.Positives, ShouldEqual, 2)
+		So(manifest.MinTimestamp, ShouldEqual, 100)
+		So(manifest.MaxTimestamp, ShouldEqual, 103)
+		So(manifest.Sources, ShouldHaveLength, 1)
+		So(manifest.CodeVersion, ShouldEqual, CodeVersion)
+		So(manifest.FinishedAt.Before(manifest.StartedAt), ShouldBeFalse)
+		So(manifest.FeatureHash, ShouldEqual, FeatureHash(manifest.SampleInfo, &lineageRecSys{}))
+		So(manifest.FeatureHash, ShouldNotEqual, FeatureHash(manifest.SampleInfo, &sumPredictor{}))
+
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant("trained", m)), ShouldBeNil)
+		So(r.Register(NewTenant("untracked", &constPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend")
+		lineage := func(tenant string) (int, *Manifest) {
+			req := httptest.NewRequest("GET", "/api/v1/model/lineage", nil)
+			req.Header.Set(TenantHeader, tenant)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			var manifest Manifest
+			_ = json.Unmarshal(w.Body.Bytes(), &manifest)
+			return w.Code, &manifest
+		}
+		code, served := lineage("trained")
+		So(code, ShouldEqual, http.StatusOK)
+		So(served.Sources[0].Name, ShouldEqual, "ratings")
+		So(served.FeatureHash, ShouldEqual, manifest.FeatureHash)
+		code, _ = lineage("untracked")
+		So(code, ShouldEqual, http.StatusNotFound)
+	})
+	Convey("sampling correction of the trained model", t, func() {
+		recSys := &sampledRecSys{correction: &Correction{NegativeRate: 0.5, Factor: 0.5}}
+		m, err := Train(context.Background(), recSys, &sumFitter{})
+		So(err, ShouldBeNil)
+		So(m.(ManifestProvider).Manifest().Correction, ShouldResemble, recSys.correction)
+		X := tensor.New(tensor.WithShape(1, 2), tensor.WithBacking([]float32{0.25, 0.25}))
+		y := m.Predict(X).Data().([]float32)
+		So(y[0], ShouldAlmostEqual, recSys.correction.Calibrate(0.5), 1e-6)
+		So(y[0], ShouldAlmostEqual, 1.0/3, 1e-6)
+
+		// no correction of the RecSys not downsampled
+		m, err = Train(context.Background(), &lineageRecSys{}, &sumFitter{})
+		So(err, ShouldBeNil)
+		So(m.(ManifestProvider).Manifest().Correction, ShouldBeNil)
+		y = m.Predict(X).Data().([]float32)
+		So(y[0], ShouldAlmostEqual, 0.5, 1e-6)
+	})
+}
@@ -0,0 +1,181 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/auxten/go-ctr/recommend/ann"
+	"github.com/auxten/go-ctr/recommend/cf"
+)
+
+// DefaultRecallNeighbors is the items recalled by a HistoryRecaller of K 0
+const DefaultRecallNeighbors = 200
+
+// LiveIndex is the index rebuilt in the background while serving, swapped
+// in atomically, e.g. ann.LiveIndex and cf.LiveItemCF
+type LiveIndex interface {
+	Rebuild(ctx context.Context) error
+	RebuildEvery(ctx context.Context, interval time.Duration)
+	Stats() ann.BuildStats
+}
+
+var (
+	_ LiveIndex = &ann.LiveIndex{}
+	_ LiveIndex = &cf.LiveItemCF{}
+)
+
+// WithLiveIndexes serves the build stats of the indexes by name at
+// /api/v1/index/stats. The indexes are rebuilt by their owner, e.g. by
+// RebuildEvery.
+func WithLiveIndexes(indexes map[string]LiveIndex) ApiOption {
+	return func(c *apiConfig) {
+		if c.indexes == nil {
+			c.indexes = make(map[string]LiveIndex, len(indexes))
+		}
+		for name, index := range indexes {
+			c.indexes[name] = index
+		}
+	}
+}
+
+// indexStats returns the build stats of the indexes of WithLiveIndexes
+func (c *apiConfig) indexStats() map[string]ann.BuildStats {
+	stats := make(map[string]ann.BuildStats, len(c.indexes))
+	for name, index := range c.indexes {
+		stats[name] = index.Stats()
+	}
+	return stats
+}
+
+// EmbeddingIndexBuilder returns the ann.Builder of the ann.FlatIndex of
+// metric of the item embeddings served in ctx, the ones updated by
+// UpdateItemEmbeddings included
+func EmbeddingIndexBuilder(metric ann.Metric) ann.Builder {
+	return func(ctx context.Context, progress func(done float64)) (ann.Index, error) {
+		index, err := ann.FromEmbeddings(metric, ItemEmbeddings(ctx))
+		if err != nil {
+			return nil, err
+		}
+		progress(1)
+		return index, nil
+	}
+}
+
+// ItemCFBuilder returns the cf.Builder of the behaviors of the positive
+// samples of recSys, the latest first, by opts
+func ItemCFBuilder(recSys Trainer, opts cf.Options) cf.Builder {
+	return func(ctx context.Context, progress func(done float64)) (*cf.ItemCF, error) {
+		ch, err := recSys.SampleGenerator(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("item cf samples: %v", err)
+		}
+		var positives []Sample
+		for s := range ch {
+			if s.Label > 0.5 {
+				positives = append(positives, s)
+			}
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		sort.SliceStable(positives, func(i, j int) bool {
+			return positives[i].Timestamp > positives[j].Timestamp
+		})
+		behaviors := make(map[int][]int)
+		for _, s := range positives {
+			behaviors[s.UserId] = append(behaviors[s.UserId], s.ItemId)
+		}
+		opts.Progress = progress
+		return cf.TrainItemCF(behaviors, opts)
+	}
+}
+
+// NeighborRecall recalls at most k items near the history items, the
+// history items excluded, e.g. cf.ItemCF, cf.LiveItemCF and EmbeddingRecall
+type NeighborRecall interface {
+	Recall(history []int, k int) ([]ann.Neighbor, error)
+}
+
+// EmbeddingRecall recalls the items of Index nearest to the mean of the item
+// embeddings of the history, e.g. of the ann.LiveIndex of
+// EmbeddingIndexBuilder. The embeddings are the ones served without tenant.
+type EmbeddingRecall struct {
+	Index ann.Index
+}
+
+func (r *EmbeddingRecall) Recall(history []int, k int) ([]ann.Neighbor, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("recall k %d <= 0", k)
+	}
+	var (
+		embeddings = ItemEmbeddings(context.Background())
+		seen       = make(map[int]struct{}, len(history))
+		query      []float32
+		found      int
+	)
+	for _, id := range history {
+		seen[id] = struct{}{}
+		vec, ok := embeddings[strconv.Itoa(id)]
+		if !ok {
+			continue
+		}
+		if query == nil {
+			query = make([]float32, len(vec))
+		}
+		for i, v := range vec {
+			query[i] += v
+		}
+		found++
+	}
+	if found == 0 {
+		return nil, nil
+	}
+	for i := range query {
+		query[i] /= float32(found)
+	}
+	neighbors := r.Index.Search(query, k+len(seen))
+	list := make([]ann.Neighbor, 0, k)
+	for _, n := range neighbors {
+		if _, ok := seen[n.ItemId]; ok {
+			continue
+		}
+		if list = append(list, n); len(list) >= k {
+			break
+		}
+	}
+	return list, nil
+}
+
+// HistoryRecaller is the Recaller of the K items of Neighbors near the
+// latest UserBehaviorLen behaviors of the user, e.g. a recall channel of a
+// Surface
+type HistoryRecaller struct {
+	Neighbors NeighborRecall
+	Behavior  UserBehavior
+	// K is DefaultRecallNeighbors if 0
+	K int
+}
+
+func (r *HistoryRecaller) Recall(ctx context.Context, userId int) ([]int, error) {
+	ctx = context.WithValue(ctx, StageKey, PredictStage)
+	itemSeq, err := r.Behavior.GetUserBehavior(ctx, userId, UserBehaviorLen, -1, -1)
+	if err != nil {
+		return nil, fmt.Errorf("user %d behavior: %v", userId, err)
+	}
+	k := r.K
+	if k <= 0 {
+		k = DefaultRecallNeighbors
+	}
+	neighbors, err := r.Neighbors.Recall(feedbackBehavior(ctx, userId, itemSeq), k)
+	if err != nil {
+		return nil, err
+	}
+	itemIds := make([]int, len(neighbors))
+	for i, n := range neighbors {
+		itemIds[i] = n.ItemId
+	}
+	return itemIds, nil
+}
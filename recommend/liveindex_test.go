@@ -0,0 +1,83 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/auxten/go-ctr/recommend/ann"
+	"github.com/auxten/go-ctr/recommend/cf"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sampleTrainer generates its samples
+type sampleTrainer []Sample
+
+func (t sampleTrainer) SampleGenerator(context.Context) (<-chan Sample, error) {
+	ch := make(chan Sample, len(t))
+	for _, s := range t {
+		ch <- s
+	}
+	close(ch)
+	return ch, nil
+}
+
+func TestLiveIndexes(t *testing.T) {
+	ctx := context.Background()
+	emb := func(x, y float32) []float32 {
+		e := make([]float32, ItemEmbDim)
+		e[0], e[1] = x, y
+		return e
+	}
+	itemEmbeddingMap = word2vec.EmbeddingMap32{
+		"1": emb(1, 1), "2": emb(1, 0), "3": emb(0, 1), "4": emb(-1, -1),
+	}
+	defer func() { itemEmbeddingMap = nil }()
+
+	var (
+		itemCF = cf.NewLiveItemCF(ItemCFBuilder(sampleTrainer{
+			{UserId: 1, ItemId: 2, Label: 1, Timestamp: 1},
+			{UserId: 1, ItemId: 1, Label: 1, Timestamp: 2},
+			{UserId: 2, ItemId: 3, Label: 1, Timestamp: 1},
+			{UserId: 2, ItemId: 1, Label: 1, Timestamp: 2},
+			{UserId: 2, ItemId: 4, Label: 0, Timestamp: 3},
+		}, cf.Options{}))
+		embIndex = ann.NewLiveIndex(EmbeddingIndexBuilder(ann.Cosine))
+		indexes  = map[string]LiveIndex{"itemcf": itemCF, "embedding": embIndex}
+	)
+	for name, index := range indexes {
+		if err := index.Rebuild(ctx); err != nil {
+			t.Fatalf("build %s: %v", name, err)
+		}
+	}
+
+	Convey("recall of the live indexes by the user behavior", t, func() {
+		// the behaviors of fakeProvider are 2 and 3
+		r := &HistoryRecaller{Neighbors: itemCF, Behavior: &fakeProvider{}}
+		itemIds, err := r.Recall(ctx, 1)
+		So(err, ShouldBeNil)
+		So(itemIds, ShouldResemble, []int{1})
+
+		r = &HistoryRecaller{Neighbors: &EmbeddingRecall{Index: embIndex}, Behavior: &fakeProvider{}, K: 1}
+		itemIds, err = r.Recall(ctx, 1)
+		So(err, ShouldBeNil)
+		So(itemIds, ShouldResemble, []int{1})
+	})
+
+	Convey("build stats served", t, func() {
+		engine := newTenantEngine(NewTenantRegistry(), "/api/v1/recommend", WithLiveIndexes(indexes))
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/index/stats", nil))
+		So(w.Code, ShouldEqual, 200)
+		var stats map[string]ann.BuildStats
+		So(json.Unmarshal(w.Body.Bytes(), &stats), ShouldBeNil)
+		So(stats["embedding"].Builds, ShouldEqual, 1)
+		So(stats["embedding"].Size, ShouldEqual, 4)
+		So(stats["itemcf"].Builds, ShouldEqual, 1)
+		// the negative item 4 is not of the item cf
+		So(stats["itemcf"].Size, ShouldEqual, 3)
+		So(stats["itemcf"].LastBuildAge, ShouldBeGreaterThanOrEqualTo, 0)
+	})
+}
@@ -0,0 +1,216 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultLongTailPercentile is the popularity percentile below which the
+	// items are of the long tail
+	DefaultLongTailPercentile = 0.8
+	// DefaultMaxCTRLoss is the max relative loss of the expected CTR by the
+	// boost
+	DefaultMaxCTRLoss = 0.05
+	// DefaultLongTailK is the top items of the expected CTR
+	DefaultLongTailK = 10
+	// upliftSearchSteps is the bisections of the uplift capped by the
+	// guardrail
+	upliftSearchSteps = 10
+)
+
+// LongTailConfig configures LongTailBoost, the zero values are the defaults
+// except Uplift
+type LongTailConfig struct {
+	// Percentile in (0, 1) of the catalog popularity, the items less popular
+	// are of the long tail, DefaultLongTailPercentile if 0
+	Percentile float64 `json:"percentile" yaml:"percentile"`
+	// Uplift multiplies the scores of the long tail items by 1 + Uplift
+	Uplift float64 `json:"uplift" yaml:"uplift"`
+	// MaxCTRLoss is the guardrail of the relative loss of the expected CTR
+	// of the top K, the mean of the model scores, by the boost. The uplift
+	// of a request is lowered till the loss is within it,
+	// DefaultMaxCTRLoss if 0.
+	MaxCTRLoss float64 `json:"maxCTRLoss" yaml:"maxCTRLoss"`
+	// K is DefaultLongTailK if 0
+	K int `json:"k" yaml:"k"`
+}
+
+// LongTailStats is the long tail exposure of the boosted requests
+type LongTailStats struct {
+	Requests int64 `json:"requests"`
+	// Capped is the requests of which the uplift is lowered by the guardrail
+	Capped int64 `json:"capped"`
+	// Served and LongTail are the items of the top K and the long tail ones
+	// of them, after the boost
+	Served   int64 `json:"served"`
+	LongTail int64 `json:"longTail"`
+	// Share is LongTail / Served
+	Share float64 `json:"share"`
+}
+
+// LongTailBoost is the ReRanker growing the exposure of the long tail items
+// on purpose, e.g. as a Surface.ReRanker. The scores of the items below the
+// popularity percentile are uplifted, as long as the expected CTR of the top
+// K estimated by the model scores drops within the guardrail. The order of
+// the items is kept, the scores are changed.
+type LongTailBoost struct {
+	conf LongTailConfig
+
+	mu         sync.RWMutex
+	popularity map[int]float64
+	threshold  float64
+
+	statsMu sync.Mutex
+	stats   LongTailStats
+}
+
+// NewLongTailBoost returns the LongTailBoost of conf, of the popularity of
+// the catalog items, e.g. the positive counts. The items of no popularity
+// are 0.
+func NewLongTailBoost(popularity map[int]float64, conf LongTailConfig) (*LongTailBoost, error) {
+	if conf.Percentile == 0 {
+		conf.Percentile = DefaultLongTailPercentile
+	}
+	if conf.MaxCTRLoss == 0 {
+		conf.MaxCTRLoss = DefaultMaxCTRLoss
+	}
+	if conf.K <= 0 {
+		conf.K = DefaultLongTailK
+	}
+	if conf.Percentile <= 0 || conf.Percentile >= 1 {
+		return nil, fmt.Errorf("long tail percentile %v not in (0, 1)", conf.Percentile)
+	}
+	if conf.Uplift <= 0 {
+		return nil, fmt.Errorf("long tail uplift %v not positive", conf.Uplift)
+	}
+	if conf.MaxCTRLoss < 0 || conf.MaxCTRLoss >= 1 {
+		return nil, fmt.Errorf("max ctr loss %v not in [0, 1)", conf.MaxCTRLoss)
+	}
+	b := &LongTailBoost{conf: conf}
+	b.SetPopularity(popularity)
+	return b, nil
+}
+
+// SetPopularity replaces the popularity of the catalog, e.g. of the counts
+// refreshed daily, and the long tail threshold of it
+func (b *LongTailBoost) SetPopularity(popularity map[int]float64) {
+	values := make([]float64, 0, len(popularity))
+	for _, v := range popularity {
+		values = append(values, v)
+	}
+	sort.Float64s(values)
+	var threshold float64
+	if len(values) != 0 {
+		threshold = values[int(b.conf.Percentile*float64(len(values)-1))]
+	}
+	b.mu.Lock()
+	b.popularity, b.threshold = popularity, threshold
+	b.mu.Unlock()
+}
+
+// LongTail tells whether itemId is of the long tail
+func (b *LongTailBoost) LongTail(itemId int) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.popularity[itemId] < b.threshold
+}
+
+// ReRank implements ReRanker
+func (b *LongTailBoost) ReRank(_ context.Context, _ int, itemScores []ItemScore) ([]ItemScore, error) {
+	if len(itemScores) == 0 {
+		return itemScores, nil
+	}
+	tail := make([]bool, len(itemScores))
+	for i, is := range itemScores {
+		tail[i] = b.LongTail(is.ItemId)
+	}
+	base := b.expectedCTR(itemScores, tail, 0)
+	uplift, capped := b.conf.Uplift, false
+	if base > 0 && 1-b.expectedCTR(itemScores, tail, uplift)/base > b.conf.MaxCTRLoss {
+		// the largest uplift within the guardrail, the loss grows with it
+		capped = true
+		lo, hi := 0., uplift
+		for i := 0; i < upliftSearchSteps; i++ {
+			mid := (lo + hi) / 2
+			if 1-b.expectedCTR(itemScores, tail, mid)/base > b.conf.MaxCTRLoss {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+		uplift = lo
+	}
+	result := make([]ItemScore, len(itemScores))
+	for i, is := range itemScores {
+		if tail[i] {
+			is.Score *= float32(1 + uplift)
+		}
+		result[i] = is
+	}
+	b.record(result, tail, capped)
+	return result, nil
+}
+
+// expectedCTR returns the mean model score of the top K items as ranked by
+// the scores of the long tail ones uplifted
+func (b *LongTailBoost) expectedCTR(itemScores []ItemScore, tail []bool, uplift float64) float64 {
+	order := b.topK(itemScores, tail, uplift)
+	var sum float64
+	for _, i := range order {
+		sum += float64(itemScores[i].Score)
+	}
+	return sum / float64(len(order))
+}
+
+// topK returns the indexes of the top K items of the uplifted scores
+func (b *LongTailBoost) topK(itemScores []ItemScore, tail []bool, uplift float64) []int {
+	boosted := func(i int) float64 {
+		if tail[i] {
+			return float64(itemScores[i].Score) * (1 + uplift)
+		}
+		return float64(itemScores[i].Score)
+	}
+	order := make([]int, len(itemScores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return boosted(order[i]) > boosted(order[j])
+	})
+	if len(order) > b.conf.K {
+		order = order[:b.conf.K]
+	}
+	return order
+}
+
+func (b *LongTailBoost) record(result []ItemScore, tail []bool, capped bool) {
+	order := b.topK(result, make([]bool, len(result)), 0)
+	var longTail int64
+	for _, i := range order {
+		if tail[i] {
+			longTail++
+		}
+	}
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	b.stats.Requests++
+	if capped {
+		b.stats.Capped++
+	}
+	b.stats.Served += int64(len(order))
+	b.stats.LongTail += longTail
+}
+
+// Stats returns the long tail exposure since created
+func (b *LongTailBoost) Stats() LongTailStats {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	stats := b.stats
+	if stats.Served != 0 {
+		stats.Share = float64(stats.LongTail) / float64(stats.Served)
+	}
+	return stats
+}
@@ -0,0 +1,68 @@
+package recommend
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLongTailBoost(t *testing.T) {
+	// the items 4, 5 and the unknown 6 are below the threshold 10 of the
+	// median popularity
+	popularity := map[int]float64{1: 100, 2: 50, 3: 10, 4: 1, 5: 0}
+	itemScores := []ItemScore{
+		{ItemId: 1, Score: 0.5},
+		{ItemId: 2, Score: 0.4},
+		{ItemId: 3, Score: 0.3},
+		{ItemId: 4, Score: 0.29},
+		{ItemId: 5, Score: 0.1},
+	}
+	ctx := context.Background()
+
+	Convey("boost the long tail items", t, func() {
+		for _, conf := range []LongTailConfig{
+			{Uplift: 0},
+			{Uplift: 1, Percentile: 1},
+			{Uplift: 1, MaxCTRLoss: 1},
+		} {
+			_, err := NewLongTailBoost(popularity, conf)
+			So(err, ShouldNotBeNil)
+		}
+		b, err := NewLongTailBoost(popularity, LongTailConfig{Percentile: 0.5, Uplift: 1, MaxCTRLoss: 0.5, K: 2})
+		So(err, ShouldBeNil)
+		So(b.LongTail(3), ShouldBeFalse)
+		So(b.LongTail(4), ShouldBeTrue)
+		So(b.LongTail(6), ShouldBeTrue)
+
+		result, err := b.ReRank(ctx, 1, itemScores)
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, len(itemScores))
+		So(result[0], ShouldResemble, itemScores[0])
+		So(result[3].Score, ShouldAlmostEqual, 0.58, 1e-6)
+		So(result[4].Score, ShouldAlmostEqual, 0.2, 1e-6)
+		So(itemScores[3].Score, ShouldEqual, float32(0.29))
+
+		// the top K of the surface
+		served, err := (&Surface{Name: "home", ReRanker: b, K: 2}).finish(ctx, 1, itemScores)
+		So(err, ShouldBeNil)
+		So([]int{served[0].ItemId, served[1].ItemId}, ShouldResemble, []int{4, 1})
+		So(b.Stats(), ShouldResemble, LongTailStats{Requests: 2, Served: 4, LongTail: 2, Share: 0.5})
+	})
+
+	Convey("uplift capped by the expected ctr loss", t, func() {
+		b, err := NewLongTailBoost(popularity, LongTailConfig{Percentile: 0.5, Uplift: 1, K: 2})
+		So(err, ShouldBeNil)
+		// the item 4 in the top 2 loses 12% of the expected ctr, so it's
+		// uplifted just below the item 2
+		result, err := b.ReRank(ctx, 1, itemScores)
+		So(err, ShouldBeNil)
+		So(result[3].Score, ShouldBeBetween, 0.39, 0.4)
+		So(b.Stats(), ShouldResemble, LongTailStats{Requests: 1, Capped: 1, Served: 2})
+
+		// the popularity refreshed
+		b.SetPopularity(map[int]float64{1: 0, 2: 1, 3: 2, 4: 3, 5: 4})
+		So(b.LongTail(1), ShouldBeTrue)
+		So(b.LongTail(4), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,152 @@
+package recommend
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/karlseguin/ccache/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMetadataTTL is the time the metadata of an item is cached
+	DefaultMetadataTTL = 10 * time.Minute
+	// DefaultMetadataItems is the max items of which the metadata is cached
+	DefaultMetadataItems = 100000
+)
+
+// ItemMetadata is the display data of an item attached to the response, so
+// the clients need no second round trip
+type ItemMetadata struct {
+	Title    string  `json:"title,omitempty"`
+	ImageUrl string  `json:"imageUrl,omitempty"`
+	Price    float64 `json:"price,omitempty"`
+	// Extra is the other display attributes
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// ItemMetadataSource fetches the metadata of a batch of items, e.g. from the
+// catalog service or database. The items missing in the result are served
+// without metadata. The tenant of the request is in ctx, see
+// TenantFromContext.
+type ItemMetadataSource interface {
+	GetItemsMetadata(ctx context.Context, itemIds []int) (map[int]*ItemMetadata, error)
+}
+
+// ItemMetadataFunc adapts a function to ItemMetadataSource
+type ItemMetadataFunc func(ctx context.Context, itemIds []int) (map[int]*ItemMetadata, error)
+
+func (f ItemMetadataFunc) GetItemsMetadata(ctx context.Context, itemIds []int) (map[int]*ItemMetadata, error) {
+	return f(ctx, itemIds)
+}
+
+// MetadataConfig configures the cache of the item metadata, the zero values
+// are the defaults
+type MetadataConfig struct {
+	// TTL is the time the metadata of an item is cached, the items missing
+	// in the source are cached as well
+	TTL time.Duration `json:"ttl" yaml:"ttl"`
+	// MaxItems is the max items cached, of all the tenants
+	MaxItems int `json:"max_items" yaml:"max_items"`
+}
+
+// MetadataStats is the cache metrics of the item metadata
+type MetadataStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Errors is the failed batch lookups, the items of which are served
+	// without metadata
+	Errors int64 `json:"errors"`
+}
+
+// WithItemMetadata attaches ItemScore.Metadata fetched from source to the
+// items served. The items of a page missing in the cache are fetched in one
+// batch. If the source fails the items are served without the metadata.
+func WithItemMetadata(source ItemMetadataSource, conf MetadataConfig) ApiOption {
+	return func(c *apiConfig) {
+		c.metadata = newMetadataEnricher(source, conf)
+	}
+}
+
+// metadataEnricher caches the item metadata by tenant and item id
+type metadataEnricher struct {
+	source ItemMetadataSource
+	ttl    time.Duration
+	cache  *ccache.Cache
+
+	hits   int64
+	misses int64
+	errors int64
+}
+
+func newMetadataEnricher(source ItemMetadataSource, conf MetadataConfig) *metadataEnricher {
+	if conf.TTL <= 0 {
+		conf.TTL = DefaultMetadataTTL
+	}
+	if conf.MaxItems <= 0 {
+		conf.MaxItems = DefaultMetadataItems
+	}
+	prune := conf.MaxItems / 100
+	if prune < 1 {
+		prune = 1
+	}
+	return &metadataEnricher{
+		source: source,
+		ttl:    conf.TTL,
+		cache:  ccache.New(ccache.Configure().MaxSize(int64(conf.MaxItems)).ItemsToPrune(uint32(prune))),
+	}
+}
+
+func metadataKey(tenant string, itemId int) string {
+	return tenant + ":" + strconv.Itoa(itemId)
+}
+
+// enrich returns a copy of itemScores with the metadata attached, the items
+// of a cached ranking are shared by the pages so they are not modified
+func (m *metadataEnricher) enrich(ctx context.Context, itemScores []ItemScore) []ItemScore {
+	if len(itemScores) == 0 {
+		return itemScores
+	}
+	tenant := tenantName(ctx)
+	enriched := make([]ItemScore, len(itemScores))
+	var misses []int
+	for i, is := range itemScores {
+		enriched[i] = is
+		item := m.cache.Get(metadataKey(tenant, is.ItemId))
+		if item == nil || item.Expired() {
+			misses = append(misses, is.ItemId)
+			continue
+		}
+		enriched[i].Metadata = item.Value().(*ItemMetadata)
+	}
+	atomic.AddInt64(&m.hits, int64(len(itemScores)-len(misses)))
+	if len(misses) == 0 {
+		return enriched
+	}
+	atomic.AddInt64(&m.misses, int64(len(misses)))
+	fetched, err := m.source.GetItemsMetadata(ctx, misses)
+	if err != nil {
+		atomic.AddInt64(&m.errors, 1)
+		log.Errorf("get items metadata error: %v", err)
+		return enriched
+	}
+	for _, id := range misses {
+		m.cache.Set(metadataKey(tenant, id), fetched[id], m.ttl)
+	}
+	for i := range enriched {
+		if enriched[i].Metadata == nil {
+			enriched[i].Metadata = fetched[enriched[i].ItemId]
+		}
+	}
+	return enriched
+}
+
+func (m *metadataEnricher) Stats() MetadataStats {
+	return MetadataStats{
+		Hits:   atomic.LoadInt64(&m.hits),
+		Misses: atomic.LoadInt64(&m.misses),
+		Errors: atomic.LoadInt64(&m.errors),
+	}
+}
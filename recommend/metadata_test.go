@@ -0,0 +1,103 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestItemMetadata(t *testing.T) {
+	post := func(engine *gin.Engine, body string) (code int, resp RecApiResponse) {
+		req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	var (
+		batches [][]int
+		fail    bool
+	)
+	// item 3 is missing in the catalog
+	source := ItemMetadataFunc(func(_ context.Context, itemIds []int) (map[int]*ItemMetadata, error) {
+		if fail {
+			return nil, fmt.Errorf("catalog is down")
+		}
+		batches = append(batches, itemIds)
+		metadata := make(map[int]*ItemMetadata)
+		for _, id := range itemIds {
+			if id != 3 {
+				metadata[id] = &ItemMetadata{Title: fmt.Sprintf("item %d", id), Price: float64(id)}
+			}
+		}
+		return metadata, nil
+	})
+
+	Convey("metadata of the items served", t, func() {
+		batches, fail = nil, false
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &countPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend",
+			WithItemMetadata(source, MetadataConfig{TTL: time.Minute}),
+			WithPagination(time.Minute, 0))
+
+		code, resp := post(engine, `{"userId":1,"itemIdList":[1,2,3]}`)
+		So(code, ShouldEqual, 200)
+		So(resp.ItemScoreList, ShouldHaveLength, 3)
+		for _, is := range resp.ItemScoreList {
+			if is.ItemId == 3 {
+				So(is.Metadata, ShouldBeNil)
+				continue
+			}
+			So(is.Metadata.Title, ShouldEqual, fmt.Sprintf("item %d", is.ItemId))
+			So(is.Metadata.Price, ShouldEqual, is.ItemId)
+		}
+		So(batches, ShouldHaveLength, 1)
+		So(batches[0], ShouldHaveLength, 3)
+
+		// only the misses are fetched, the missing item is cached as well
+		_, resp = post(engine, `{"userId":1,"itemIdList":[1,3,4]}`)
+		So(resp.ItemScoreList, ShouldHaveLength, 3)
+		So(batches, ShouldHaveLength, 2)
+		So(batches[1], ShouldResemble, []int{4})
+
+		// the pages of the cached ranking
+		_, resp = post(engine, `{"userId":1,"pageSize":2}`)
+		So(resp.ItemScoreList, ShouldHaveLength, 2)
+		So(resp.ItemScoreList[0].Metadata, ShouldNotBeNil)
+		_, resp = post(engine, `{"userId":1,"cursor":"`+resp.NextCursor+`"}`)
+		So(resp.ItemScoreList, ShouldHaveLength, 2)
+		So(resp.ItemScoreList[1].Metadata, ShouldNotBeNil)
+
+		req := httptest.NewRequest("GET", "/api/v1/metadata/stats", nil)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		var stats MetadataStats
+		So(json.Unmarshal(w.Body.Bytes(), &stats), ShouldBeNil)
+		So(stats.Misses, ShouldEqual, 4)
+		So(stats.Hits, ShouldEqual, 6)
+	})
+
+	Convey("served without metadata on the source error", t, func() {
+		batches, fail = nil, true
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &countPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend", WithItemMetadata(source, MetadataConfig{}))
+
+		code, resp := post(engine, `{"userId":1,"itemIdList":[1,2]}`)
+		So(code, ShouldEqual, 200)
+		So(resp.ItemScoreList, ShouldHaveLength, 2)
+		for _, is := range resp.ItemScoreList {
+			So(is.Metadata, ShouldBeNil)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package recommend
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the encodings of the MultiValueFeature
+const (
+	// EncodingMultiHot is the 1 of the column of every value in the
+	// Vocabulary
+	EncodingMultiHot = "multihot"
+	// EncodingMeanPool is the mean of the Embeddings of the values
+	EncodingMeanPool = "mean"
+)
+
+// MultiValueFeature is the feature of a set of categories of every item or
+// user, e.g. the genres of the movies or the interests of the users:
+//
+//	err := rcmd.RegisterMultiValueFeature(rcmd.MultiValueFeature{
+//		Name:       "genres",
+//		Vocabulary: []string{"Action", "Comedy", "Drama"},
+//		Values: func(ctx context.Context, itemId int) ([]string, error) {
+//			return strings.Split(movies[itemId].Genres, "|"), nil
+//		},
+//	})
+//
+// It's registered as the FeatureFunc of the encoded values, so the columns
+// are appended to the ctx feature of the training samples and the serving
+// vectors the same for all the models. The version of the encoding, the
+// hash of the Vocabulary or the Embeddings, is recorded in the Manifest and
+// the serving feature must be of the same one.
+type MultiValueFeature struct {
+	Name string
+	// OfUser is the feature of the users, Values are of the user ids. It's of
+	// the items by default.
+	OfUser bool
+	// Values returns the categories of the item or user id, the duplicated
+	// ones count once. The error is logged and the values are empty.
+	Values func(ctx context.Context, id int) ([]string, error)
+	// Encoding is EncodingMultiHot by default
+	Encoding string
+	// Vocabulary of the categories of EncodingMultiHot in the column order,
+	// the values not in it are ignored
+	Vocabulary []string
+	// Embeddings of the categories of EncodingMeanPool, all of the same dim,
+	// the values of no embedding are ignored
+	Embeddings map[string][]float32
+}
+
+// multiValueEncoder encodes the values of a MultiValueFeature
+type multiValueEncoder struct {
+	width   int
+	columns map[string]int
+	embs    map[string][]float32
+}
+
+func (e *multiValueEncoder) encode(values []string) []float64 {
+	features := make([]float64, e.width)
+	seen := make(map[string]bool, len(values))
+	var pooled int
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		if e.columns != nil {
+			if col, ok := e.columns[v]; ok {
+				features[col] = 1
+			}
+			continue
+		}
+		if emb, ok := e.embs[v]; ok {
+			for i, x := range emb {
+				features[i] += float64(x)
+			}
+			pooled++
+		}
+	}
+	if pooled > 1 {
+		for i := range features {
+			features[i] /= float64(pooled)
+		}
+	}
+	return features
+}
+
+// encoder validates f and returns its encoder and the version of the
+// encoding
+func (f *MultiValueFeature) encoder() (e *multiValueEncoder, version string, err error) {
+	encoding := f.Encoding
+	if encoding == "" {
+		encoding = EncodingMultiHot
+	}
+	var spec interface{}
+	e = &multiValueEncoder{}
+	switch encoding {
+	case EncodingMultiHot:
+		if len(f.Vocabulary) == 0 {
+			return nil, "", fmt.Errorf("multi-value feature %s of no vocabulary", f.Name)
+		}
+		e.width, e.columns = len(f.Vocabulary), make(map[string]int, len(f.Vocabulary))
+		for i, v := range f.Vocabulary {
+			if _, ok := e.columns[v]; ok {
+				return nil, "", fmt.Errorf("multi-value feature %s vocabulary duplicated %q", f.Name, v)
+			}
+			e.columns[v] = i
+		}
+		spec = f.Vocabulary
+	case EncodingMeanPool:
+		if len(f.Embeddings) == 0 {
+			return nil, "", fmt.Errorf("multi-value feature %s of no embeddings", f.Name)
+		}
+		values := make([]string, 0, len(f.Embeddings))
+		for v, emb := range f.Embeddings {
+			if e.width == 0 {
+				e.width = len(emb)
+			}
+			if len(emb) == 0 || len(emb) != e.width {
+				return nil, "", fmt.Errorf("multi-value feature %s embedding of %q dim %d != %d", f.Name, v, len(emb), e.width)
+			}
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		rows := make([][]interface{}, 0, len(values))
+		e.embs = make(map[string][]float32, len(values))
+		for _, v := range values {
+			e.embs[v] = append([]float32(nil), f.Embeddings[v]...)
+			rows = append(rows, []interface{}{v, e.embs[v]})
+		}
+		spec = rows
+	default:
+		return nil, "", fmt.Errorf("multi-value feature %s encoding %q not %s or %s", f.Name, encoding, EncodingMultiHot, EncodingMeanPool)
+	}
+	data, err := json.Marshal(struct {
+		Encoding string
+		OfUser   bool
+		Spec     interface{}
+	}{encoding, f.OfUser, spec})
+	if err != nil {
+		return
+	}
+	sum := sha1.Sum(data)
+	return e, hex.EncodeToString(sum[:8]), nil
+}
+
+// RegisterMultiValueFeature registers f as the FeatureFunc of f.Name, of the
+// width of the Vocabulary or the embedding dim
+func RegisterMultiValueFeature(f MultiValueFeature) error {
+	if f.Values == nil {
+		return fmt.Errorf("multi-value feature %s of no values func", f.Name)
+	}
+	e, version, err := f.encoder()
+	if err != nil {
+		return err
+	}
+	return registerFeatureFunc(FeatureFuncInfo{Name: f.Name, Width: e.width, Version: version},
+		func(ctx context.Context, userId, itemId int) []float64 {
+			id := itemId
+			if f.OfUser {
+				id = userId
+			}
+			values, err := f.Values(ctx, id)
+			if err != nil {
+				log.Errorf("multi-value feature %s of %d: %v", f.Name, id, err)
+			}
+			return e.encode(values)
+		})
+}
@@ -0,0 +1,93 @@
+package recommend
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMultiValueFeature(t *testing.T) {
+	userCache, itemCache := UserFeatureCache, ItemFeatureCache
+	defer func() {
+		UserFeatureCache, ItemFeatureCache = userCache, itemCache
+	}()
+
+	genres := map[int][]string{
+		0: {"Comedy"},
+		1: {"Action", "Drama", "Action"},
+		3: {"Drama", "Western"},
+	}
+	itemGenres := func(_ context.Context, itemId int) ([]string, error) {
+		return genres[itemId], nil
+	}
+	interests := MultiValueFeature{
+		Name:     "interests",
+		OfUser:   true,
+		Encoding: EncodingMeanPool,
+		Embeddings: map[string][]float32{
+			"music":  {1, 0},
+			"sports": {0, 1},
+		},
+		Values: func(_ context.Context, userId int) ([]string, error) {
+			if userId%2 == 0 {
+				return []string{"music"}, nil
+			}
+			return []string{"music", "sports", "cooking"}, nil
+		},
+	}
+
+	Convey("bad declarations", t, func() {
+		So(RegisterMultiValueFeature(MultiValueFeature{Name: "genres", Vocabulary: []string{"Action"}}), ShouldNotBeNil)
+		So(RegisterMultiValueFeature(MultiValueFeature{Name: "genres", Values: itemGenres}), ShouldNotBeNil)
+		So(RegisterMultiValueFeature(MultiValueFeature{Name: "genres", Values: itemGenres, Vocabulary: []string{"a", "a"}}), ShouldNotBeNil)
+		So(RegisterMultiValueFeature(MultiValueFeature{Name: "genres", Values: itemGenres, Encoding: "sum", Vocabulary: []string{"a"}}), ShouldNotBeNil)
+		So(RegisterMultiValueFeature(MultiValueFeature{Name: "genres", Values: itemGenres, Encoding: EncodingMeanPool,
+			Embeddings: map[string][]float32{"a": {1}, "b": {1, 2}}}), ShouldNotBeNil)
+		So(RegisteredFeatureFuncs(), ShouldBeEmpty)
+	})
+
+	Convey("multi-hot and mean pooled features of training and serving", t, func() {
+		So(RegisterMultiValueFeature(MultiValueFeature{
+			Name:       "genres",
+			Vocabulary: []string{"Action", "Comedy", "Drama"},
+			Values:     itemGenres,
+		}), ShouldBeNil)
+		defer UnregisterFeatureFunc("genres")
+		So(RegisterMultiValueFeature(interests), ShouldBeNil)
+		defer UnregisterFeatureFunc("interests")
+		funcs := RegisteredFeatureFuncs()
+		So(funcs, ShouldHaveLength, 2)
+		So(funcs[0].Width, ShouldEqual, 3)
+		So(funcs[0].Version, ShouldNotBeEmpty)
+		So(funcs[1].Width, ShouldEqual, 2)
+
+		fitter := &sampleFitter{}
+		m, err := Train(context.Background(), &lineageRecSys{}, fitter)
+		So(err, ShouldBeNil)
+		sample := fitter.sample
+		So(sample.Info.CtxFeatureRange[1]-sample.Info.CtxFeatureRange[0], ShouldEqual, 6)
+		// the sample of user 1 and item 1
+		row := sample.X[sample.XCols : 2*sample.XCols]
+		So(row[sample.XCols-5:], ShouldResemble, []float32{1, 0, 1, 0.5, 0.5})
+		So(m.(ManifestProvider).Manifest().FeatureFuncs, ShouldResemble, funcs)
+
+		result, err := DebugFeature(context.Background(), m, Sample{UserId: 2, ItemId: 3})
+		So(err, ShouldBeNil)
+		columns := result.Columns[len(result.Columns)-5:]
+		So(columns[0], ShouldResemble, FeatureColumn{Name: "genres_0", Value: 0})
+		So(columns[2], ShouldResemble, FeatureColumn{Name: "genres_2", Value: 1})
+		So(columns[3], ShouldResemble, FeatureColumn{Name: "interests_0", Value: 1})
+		So(columns[4], ShouldResemble, FeatureColumn{Name: "interests_1", Value: 0})
+
+		// the serving vocabulary of the same width but another version
+		UnregisterFeatureFunc("genres")
+		So(RegisterMultiValueFeature(MultiValueFeature{
+			Name:       "genres",
+			Vocabulary: []string{"Comedy", "Action", "Drama"},
+			Values:     itemGenres,
+		}), ShouldBeNil)
+		_, err = DebugFeature(context.Background(), m, Sample{UserId: 2, ItemId: 3})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,112 @@
+package recommend
+
+import (
+	"fmt"
+	"math"
+)
+
+// the methods of SampleOptions.Normalize
+const (
+	// NormalizeStandard is the (x - mean) / std of every column
+	NormalizeStandard = "standard"
+	// NormalizeMinMax is the (x - min) / (max - min) of every column
+	NormalizeMinMax = "minmax"
+)
+
+// Normalizer scales the columns of the sample vectors by the statistics of
+// the training samples: x' = (x - Offsets[j]) * Scales[j]. It's fit by Train
+// and recorded in the Manifest, and the serving assembler applies it to the
+// vectors of the Predictor of the Manifest, so the train and serve never
+// normalize differently.
+type Normalizer struct {
+	Method  string    `json:"method"`
+	Offsets []float32 `json:"offsets"`
+	Scales  []float32 `json:"scales"`
+}
+
+// FitNormalizer fits the Normalizer of method on the columns of sample, the
+// constant columns are shifted to 0 but not scaled and the NaNs are ignored.
+// The columns of SampleInfo.IdRange are kept as is.
+func FitNormalizer(sample *TrainSample, method string) (n *Normalizer, err error) {
+	if method != NormalizeStandard && method != NormalizeMinMax {
+		return nil, fmt.Errorf("unknown normalize method %q", method)
+	}
+	cols := sample.XCols
+	n = &Normalizer{Method: method, Offsets: make([]float32, cols), Scales: make([]float32, cols)}
+	var (
+		count, sum, sumSq = make([]float64, cols), make([]float64, cols), make([]float64, cols)
+		lo, hi            = make([]float64, cols), make([]float64, cols)
+		buf               []float32
+	)
+	for j := range lo {
+		lo[j], hi[j] = math.Inf(1), math.Inf(-1)
+	}
+	for i := 0; i < sample.Rows; i++ {
+		buf = sample.Row(i, buf)
+		for j, x := range buf {
+			v := float64(x)
+			if math.IsNaN(v) {
+				continue
+			}
+			count[j]++
+			sum[j] += v
+			sumSq[j] += v * v
+			lo[j] = math.Min(lo[j], v)
+			hi[j] = math.Max(hi[j], v)
+		}
+	}
+	ids := sample.Info.IdRange
+	for j := 0; j < cols; j++ {
+		// the id columns are the table rows, not scaled
+		if count[j] == 0 || j >= ids[0] && j < ids[1] {
+			n.Scales[j] = 1
+			continue
+		}
+		offset, spread := lo[j], hi[j]-lo[j]
+		if method == NormalizeStandard {
+			offset = sum[j] / count[j]
+			spread = math.Sqrt(math.Max(sumSq[j]/count[j]-offset*offset, 0))
+		}
+		n.Offsets[j], n.Scales[j] = float32(offset), 1
+		if spread > 1e-12 {
+			n.Scales[j] = float32(1 / spread)
+		}
+	}
+	return
+}
+
+// Apply normalizes vec in place
+func (n *Normalizer) Apply(vec []float32) error {
+	if len(vec) != len(n.Scales) {
+		return fmt.Errorf("normalizer of %d columns != vector width %d", len(n.Scales), len(vec))
+	}
+	for j, v := range vec {
+		vec[j] = (v - n.Offsets[j]) * n.Scales[j]
+	}
+	return nil
+}
+
+// ApplySample normalizes the samples in place, the Sparse samples are not
+// supported since the shifted zeros are not sparse
+func (n *Normalizer) ApplySample(sample *TrainSample) error {
+	if sample.Sparse != nil {
+		return fmt.Errorf("normalizer of the sparse samples is not supported")
+	}
+	if sample.XCols != len(n.Scales) {
+		return fmt.Errorf("normalizer of %d columns != sample width %d", len(n.Scales), sample.XCols)
+	}
+	for i := 0; i < sample.Rows; i++ {
+		_ = n.Apply(sample.X[i*sample.XCols : (i+1)*sample.XCols])
+	}
+	return nil
+}
+
+// servingNormalizer is the Normalizer of the Manifest of provider, or nil
+func servingNormalizer(provider BasicFeatureProvider) *Normalizer {
+	if mp, ok := provider.(ManifestProvider); ok {
+		if manifest := mp.Manifest(); manifest != nil {
+			return manifest.Normalizer
+		}
+	}
+	return nil
+}
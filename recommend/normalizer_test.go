@@ -0,0 +1,91 @@
+package recommend
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// normalizedRecSys is the lineageRecSys of the standard normalization
+type normalizedRecSys struct {
+	lineageRecSys
+}
+
+func (r *normalizedRecSys) SampleOptions() SampleOptions {
+	return SampleOptions{Normalize: NormalizeStandard}
+}
+
+// recordPredictor keeps the input of the fit and the last prediction
+type recordPredictor struct {
+	fitted, predicted []float32
+}
+
+func (p *recordPredictor) Fit(sample *TrainSample) (PredictAbstract, error) {
+	p.fitted = append([]float32(nil), sample.X...)
+	return p, nil
+}
+
+func (p *recordPredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	p.predicted = append([]float32(nil), X.Data().([]float32)...)
+	return tensor.New(tensor.WithShape(X.Shape()[0], 1), tensor.WithBacking(make([]float32, X.Shape()[0])))
+}
+
+func TestNormalizer(t *testing.T) {
+	nan := float32(math.NaN())
+	sample := &TrainSample{X: []float32{1, 5, 0, 3, 5, nan, 5, 5, 4}, Rows: 3, XCols: 3}
+
+	Convey("fit of the columns", t, func() {
+		n, err := FitNormalizer(sample, NormalizeStandard)
+		So(err, ShouldBeNil)
+		So(n.Offsets, ShouldResemble, []float32{3, 5, 2})
+		So(n.Scales[0], ShouldAlmostEqual, 1/math.Sqrt(8.0/3), 1e-6)
+		// of the constant column
+		So(n.Scales[1], ShouldEqual, 1)
+		So(n.Scales[2], ShouldAlmostEqual, 0.5, 1e-6)
+
+		n, err = FitNormalizer(sample, NormalizeMinMax)
+		So(err, ShouldBeNil)
+		So(n.Offsets, ShouldResemble, []float32{1, 5, 0})
+		So(n.Scales, ShouldResemble, []float32{0.25, 1, 0.25})
+		vec := []float32{3, 6, 2}
+		So(n.Apply(vec), ShouldBeNil)
+		So(vec, ShouldResemble, []float32{0.5, 1, 0.5})
+		So(n.Apply([]float32{1}), ShouldNotBeNil)
+		So(n.ApplySample(&TrainSample{X: []float32{1}, Rows: 1, XCols: 1}), ShouldNotBeNil)
+
+		_, err = FitNormalizer(sample, "log")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("normalized the same in the training and serving", t, func() {
+		userCache, itemCache := UserFeatureCache, ItemFeatureCache
+		defer func() {
+			UserFeatureCache, ItemFeatureCache = userCache, itemCache
+		}()
+		recSys, p := &normalizedRecSys{}, &recordPredictor{}
+		m, err := Train(context.Background(), recSys, p)
+		So(err, ShouldBeNil)
+		n := m.(ManifestProvider).Manifest().Normalizer
+		So(n, ShouldNotBeNil)
+		So(n.Method, ShouldEqual, NormalizeStandard)
+
+		// the user id column is standardized
+		cols := len(n.Scales)
+		var sum float32
+		for i := 0; i < 4; i++ {
+			sum += p.fitted[i*cols]
+		}
+		So(sum, ShouldAlmostEqual, 0, 1e-6)
+
+		_, err = BatchPredict(context.Background(), m, []Sample{{UserId: 2, ItemId: 1}})
+		So(err, ShouldBeNil)
+		raw, _, _, err := NewFeatureAssembler(recSys, nil, nil).Assemble(context.Background(), &Sample{UserId: 2, ItemId: 1})
+		So(err, ShouldBeNil)
+		So(raw[0], ShouldEqual, 2)
+		So(n.Apply(raw), ShouldBeNil)
+		So(p.predicted, ShouldResemble, raw)
+	})
+}
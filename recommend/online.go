@@ -0,0 +1,147 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/auxten/go-ctr/utils"
+)
+
+const (
+	// DefaultStreamingWindow is the recent events kept by StreamingMetrics
+	DefaultStreamingWindow = 10000
+	// logLossEps clips the scores of the log loss
+	logLossEps = 1e-7
+)
+
+// OnlineUpdater is implemented by the Predictor learning online, Update
+// trains the model incrementally on the fresh labeled samples
+type OnlineUpdater interface {
+	Update(ctx context.Context, samples []Sample) error
+}
+
+// StreamingStats is the progressive validation metrics of the recent events
+type StreamingStats struct {
+	// Events is the count of all the observed events
+	Events int64 `json:"events"`
+	// Window is the recent events the metrics are computed on
+	Window       int     `json:"window"`
+	AUC          float64 `json:"auc"`
+	LogLoss      float64 `json:"logLoss"`
+	PositiveRate float64 `json:"positiveRate"`
+	MeanScore    float64 `json:"meanScore"`
+}
+
+// StreamingMetrics computes the AUC and log loss over the sliding window of
+// the recent events, every event is scored before the model learns it, so
+// the metrics are the progressive validation of the online model.
+type StreamingMetrics struct {
+	mu     sync.Mutex
+	scores []float32
+	labels []float32
+	next   int
+	full   bool
+	events int64
+}
+
+// NewStreamingMetrics keeps the window recent events, DefaultStreamingWindow
+// if window <= 0
+func NewStreamingMetrics(window int) *StreamingMetrics {
+	if window <= 0 {
+		window = DefaultStreamingWindow
+	}
+	return &StreamingMetrics{
+		scores: make([]float32, window),
+		labels: make([]float32, window),
+	}
+}
+
+// Observe records the score predicted before the update and the realized
+// label of an event
+func (m *StreamingMetrics) Observe(score, label float32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scores[m.next], m.labels[m.next] = score, label
+	m.next++
+	if m.next == len(m.scores) {
+		m.next, m.full = 0, true
+	}
+	m.events++
+}
+
+// Stats returns the metrics of the current window, the AUC is 0 until both
+// the positive and the negative events are observed
+func (m *StreamingMetrics) Stats() (stats StreamingStats) {
+	m.mu.Lock()
+	n := m.next
+	if m.full {
+		n = len(m.scores)
+	}
+	var (
+		scores = append([]float32(nil), m.scores[:n]...)
+		labels = append([]float32(nil), m.labels[:n]...)
+	)
+	stats.Events = m.events
+	m.mu.Unlock()
+
+	stats.Window = n
+	if n == 0 {
+		return
+	}
+	var positives int
+	for i, s := range scores {
+		p := math.Min(math.Max(float64(s), logLossEps), 1-logLossEps)
+		if labels[i] > 0.5 {
+			positives++
+			stats.LogLoss -= math.Log(p)
+		} else {
+			stats.LogLoss -= math.Log(1 - p)
+		}
+		stats.MeanScore += float64(s)
+	}
+	stats.LogLoss /= float64(n)
+	stats.MeanScore /= float64(n)
+	stats.PositiveRate = float64(positives) / float64(n)
+	if positives > 0 && positives < n {
+		stats.AUC = float64(utils.RocAuc32(scores, labels))
+	}
+	return
+}
+
+// OnlineLearn is a step of the online learning: the labeled samples are
+// scored by the model, observed in metrics if not nil, and then learned by
+// the OnlineUpdater. The scores come before the update, so the metrics are
+// never computed on the events already learned.
+func OnlineLearn(ctx context.Context, recSys Predictor, samples []Sample, metrics *StreamingMetrics) (err error) {
+	updater, ok := recSys.(OnlineUpdater)
+	if !ok {
+		return fmt.Errorf("predictor %T is not an OnlineUpdater", recSys)
+	}
+	if len(samples) == 0 {
+		return
+	}
+	if metrics != nil {
+		y, er := BatchPredict(ctx, recSys, samples)
+		if er != nil {
+			return fmt.Errorf("progressive validation predict error: %v", er)
+		}
+		if y == nil {
+			return fmt.Errorf("progressive validation no prediction")
+		}
+		scores := y.Data().([]float32)
+		for i := range samples {
+			metrics.Observe(scores[i], samples[i].Label)
+		}
+	}
+	return updater.Update(ctx, samples)
+}
+
+// WithStreamingMetrics serves the stats of metrics at /api/v1/online/stats,
+// metrics is usually fed by OnlineLearn
+func WithStreamingMetrics(metrics *StreamingMetrics) ApiOption {
+	return func(c *apiConfig) {
+		c.streaming = metrics
+	}
+}
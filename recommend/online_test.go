@@ -0,0 +1,80 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// onlinePredictor scores every sample by the positive rate learned so far
+type onlinePredictor struct {
+	fakeProvider
+	score float32
+}
+
+func (p *onlinePredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	y := make([]float32, X.Shape()[0])
+	for i := range y {
+		y[i] = p.score
+	}
+	return tensor.New(tensor.WithShape(len(y), 1), tensor.WithBacking(y))
+}
+
+func (p *onlinePredictor) Update(_ context.Context, samples []Sample) error {
+	var positives float32
+	for _, s := range samples {
+		positives += s.Label
+	}
+	p.score = positives / float32(len(samples))
+	return nil
+}
+
+func TestStreamingMetrics(t *testing.T) {
+	Convey("sliding window metrics", t, func() {
+		m := NewStreamingMetrics(4)
+		So(m.Stats(), ShouldResemble, StreamingStats{})
+		m.Observe(0.9, 0)
+		for _, e := range [][2]float32{{0.8, 1}, {0.2, 0}, {0.7, 1}, {0.4, 0}} {
+			m.Observe(e[0], e[1])
+		}
+		stats := m.Stats()
+		So(stats.Events, ShouldEqual, 5)
+		So(stats.Window, ShouldEqual, 4)
+		// the 0.9 negative is out of the window
+		So(stats.AUC, ShouldEqual, 1)
+		So(stats.PositiveRate, ShouldEqual, 0.5)
+		So(stats.MeanScore, ShouldAlmostEqual, 0.525, 1e-6)
+		logLoss := -(math.Log(0.8) + math.Log(0.8) + math.Log(0.7) + math.Log(0.6)) / 4
+		So(stats.LogLoss, ShouldAlmostEqual, logLoss, 1e-6)
+	})
+
+	Convey("progressive validation of online learning", t, func() {
+		var (
+			ctx = context.Background()
+			p   = &onlinePredictor{}
+			m   = NewStreamingMetrics(0)
+		)
+		batch := []Sample{{UserId: 1, ItemId: 1, Label: 1}, {UserId: 1, ItemId: 2, Label: 1}}
+		So(OnlineLearn(ctx, p, batch, m), ShouldBeNil)
+		So(p.score, ShouldEqual, 1)
+		// scored 0 before the update
+		So(m.Stats().MeanScore, ShouldEqual, 0)
+		So(OnlineLearn(ctx, p, batch, m), ShouldBeNil)
+		So(m.Stats().MeanScore, ShouldEqual, 0.5)
+		So(m.Stats().Events, ShouldEqual, 4)
+
+		So(OnlineLearn(ctx, &constPredictor{}, batch, m), ShouldNotBeNil)
+
+		engine := newTenantEngine(NewTenantRegistry(), "/api/v1/recommend", WithStreamingMetrics(m))
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/online/stats", nil))
+		var stats StreamingStats
+		So(json.Unmarshal(w.Body.Bytes(), &stats), ShouldBeNil)
+		So(stats, ShouldResemble, m.Stats())
+	})
+}
@@ -0,0 +1,196 @@
+package recommend
+
+import (
+	"container/list"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPageTTL is the time a ranked list is kept for the next pages
+	DefaultPageTTL = 10 * time.Minute
+	// DefaultPageLists is the max ranked lists kept
+	DefaultPageLists = 10000
+)
+
+// WithPagination serves the requests of RecApiRequest.PageSize by pages. The
+// ranked list is cached for ttl under RecApiResponse.RequestId, the next
+// pages are fetched by RecApiRequest.Cursor without re-scoring, so the pages
+// have no duplicates or reorderings. At most maxLists lists are kept, the
+// oldest are evicted first. Zero ttl or maxLists means the defaults.
+func WithPagination(ttl time.Duration, maxLists int) ApiOption {
+	return func(c *apiConfig) {
+		c.pages = newRankingCache(ttl, maxLists)
+	}
+}
+
+// rankedList is the full response of a paginated request
+type rankedList struct {
+	tenant   string
+	userId   int
+	pageSize int
+	resp     RecApiResponse
+	expires  time.Time
+	// elem is the element of the list in rankingCache.order
+	elem *list.Element
+}
+
+// rankingCache keeps the ranked lists by request id for the next pages
+type rankingCache struct {
+	ttl      time.Duration
+	maxLists int
+	now      func() time.Time
+
+	mu    sync.Mutex
+	lists map[string]*rankedList
+	// order is the request ids by expiry, the oldest first, as every list
+	// is kept for the same ttl
+	order *list.List
+}
+
+func newRankingCache(ttl time.Duration, maxLists int) *rankingCache {
+	if ttl <= 0 {
+		ttl = DefaultPageTTL
+	}
+	if maxLists <= 0 {
+		maxLists = DefaultPageLists
+	}
+	return &rankingCache{
+		ttl:      ttl,
+		maxLists: maxLists,
+		now:      time.Now,
+		lists:    make(map[string]*rankedList),
+		order:    list.New(),
+	}
+}
+
+// sortItemScores orders itemScores by score descending, the ties by item id,
+// so the order is the same for the same scores
+func sortItemScores(itemScores []ItemScore) {
+	sort.SliceStable(itemScores, func(i, j int) bool {
+		if itemScores[i].Score != itemScores[j].Score {
+			return itemScores[i].Score > itemScores[j].Score
+		}
+		return itemScores[i].ItemId < itemScores[j].ItemId
+	})
+}
+
+func tenantName(ctx context.Context) string {
+	if t := TenantFromContext(ctx); t != nil {
+		return t.Name
+	}
+	return ""
+}
+
+func encodeCursor(requestId string, offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(requestId + ":" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (requestId string, offset int, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid cursor")
+	}
+	i := strings.LastIndexByte(string(data), ':')
+	if i <= 0 {
+		return "", 0, fmt.Errorf("invalid cursor")
+	}
+	if offset, err = strconv.Atoi(string(data[i+1:])); err != nil || offset < 0 {
+		return "", 0, fmt.Errorf("invalid cursor")
+	}
+	return string(data[:i]), offset, nil
+}
+
+// page returns resp of the items from offset, the NextCursor is set if more
+// items follow
+func page(requestId string, resp RecApiResponse, offset, pageSize int) RecApiResponse {
+	items := resp.ItemScoreList
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := offset + pageSize
+	if end > len(items) {
+		end = len(items)
+	}
+	resp.RequestId = requestId
+	resp.ItemScoreList = items[offset:end]
+	if end < len(items) {
+		resp.NextCursor = encodeCursor(requestId, end)
+	}
+	return resp
+}
+
+// first caches the full resp of userId and returns its first page
+func (c *rankingCache) first(ctx context.Context, userId, pageSize int, resp RecApiResponse) (RecApiResponse, error) {
+	requestId, err := newRequestId()
+	if err != nil {
+		return RecApiResponse{}, err
+	}
+	items := append([]ItemScore(nil), resp.ItemScoreList...)
+	sortItemScores(items)
+	resp.ItemScoreList = items
+
+	now := c.now()
+	c.mu.Lock()
+	if len(c.lists) >= c.maxLists {
+		c.evict(now)
+	}
+	c.lists[requestId] = &rankedList{
+		tenant:   tenantName(ctx),
+		userId:   userId,
+		pageSize: pageSize,
+		resp:     resp,
+		expires:  now.Add(c.ttl),
+		elem:     c.order.PushBack(requestId),
+	}
+	c.mu.Unlock()
+	return page(requestId, resp, 0, pageSize), nil
+}
+
+// next returns the page of cursor, pageSize <= 0 is the page size of the
+// first page. The cursor must be of the same tenant and userId.
+func (c *rankingCache) next(ctx context.Context, userId int, cursor string, pageSize int) (RecApiResponse, error) {
+	requestId, offset, err := decodeCursor(cursor)
+	if err != nil {
+		return RecApiResponse{}, err
+	}
+	c.mu.Lock()
+	ranked, ok := c.lists[requestId]
+	if ok && !c.now().Before(ranked.expires) {
+		c.remove(requestId, ranked)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok || ranked.tenant != tenantName(ctx) || ranked.userId != userId {
+		return RecApiResponse{}, fmt.Errorf("cursor expired or not found")
+	}
+	if pageSize <= 0 {
+		pageSize = ranked.pageSize
+	}
+	return page(requestId, ranked.resp, offset, pageSize), nil
+}
+
+// evict removes the expired lists from the oldest, and the oldest if still
+// full. c.mu must be held.
+func (c *rankingCache) evict(now time.Time) {
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		requestId := e.Value.(string)
+		ranked := c.lists[requestId]
+		if now.Before(ranked.expires) && len(c.lists) < c.maxLists {
+			return
+		}
+		c.remove(requestId, ranked)
+	}
+}
+
+// remove removes the list of requestId, c.mu must be held
+func (c *rankingCache) remove(requestId string, ranked *rankedList) {
+	c.order.Remove(ranked.elem)
+	delete(c.lists, requestId)
+}
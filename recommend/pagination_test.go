@@ -0,0 +1,117 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// countPredictor counts the scorings and recalls items 5 to 1
+type countPredictor struct {
+	constPredictor
+	predicts int32
+}
+
+func (p *countPredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	atomic.AddInt32(&p.predicts, 1)
+	return p.constPredictor.Predict(X)
+}
+
+func (p *countPredictor) Recall(context.Context, int) ([]int, error) {
+	return []int{5, 4, 3, 2, 1}, nil
+}
+
+func TestPagination(t *testing.T) {
+	post := func(engine *gin.Engine, body string) (code int, resp RecApiResponse) {
+		req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	Convey("stable order", t, func() {
+		itemScores := []ItemScore{{ItemId: 3, Score: 0.5}, {ItemId: 1, Score: 0.5}, {ItemId: 2, Score: 0.9}}
+		sortItemScores(itemScores)
+		So(itemScores, ShouldResemble, []ItemScore{{ItemId: 2, Score: 0.9}, {ItemId: 1, Score: 0.5}, {ItemId: 3, Score: 0.5}})
+	})
+
+	Convey("pages of the cached ranking", t, func() {
+		p := &countPredictor{}
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, p)), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend", WithPagination(time.Minute, 0))
+
+		code, resp := post(engine, `{"userId":1,"pageSize":2}`)
+		So(code, ShouldEqual, 200)
+		So(resp.RequestId, ShouldNotBeEmpty)
+		So(resp.NextCursor, ShouldNotBeEmpty)
+		items := append([]ItemScore(nil), resp.ItemScoreList...)
+		for resp.NextCursor != "" {
+			code, resp = post(engine, `{"userId":1,"cursor":"`+resp.NextCursor+`"}`)
+			So(code, ShouldEqual, 200)
+			items = append(items, resp.ItemScoreList...)
+		}
+		So(p.predicts, ShouldEqual, 1)
+		var ids []int
+		for _, is := range items {
+			ids = append(ids, is.ItemId)
+		}
+		So(ids, ShouldResemble, []int{1, 2, 3, 4, 5})
+
+		Convey("the cursor of another user or expired is rejected", func() {
+			_, first := post(engine, `{"userId":1,"pageSize":2}`)
+			code, _ := post(engine, `{"userId":2,"cursor":"`+first.NextCursor+`"}`)
+			So(code, ShouldEqual, 404)
+			code, _ = post(engine, `{"userId":1,"cursor":"bad"}`)
+			So(code, ShouldEqual, 404)
+
+			code, _ = post(newTenantEngine(r, "/api/v1/recommend"), `{"userId":1,"pageSize":2}`)
+			So(code, ShouldEqual, 400)
+		})
+	})
+
+	Convey("expiry and eviction", t, func() {
+		ctx := context.Background()
+		now := time.Now()
+		c := newRankingCache(time.Minute, 2)
+		c.now = func() time.Time { return now }
+		resp := RecApiResponse{ItemScoreList: []ItemScore{{ItemId: 1}, {ItemId: 2}}}
+		a, _ := c.first(ctx, 1, 1, resp)
+		now = now.Add(time.Second)
+		b, _ := c.first(ctx, 1, 1, resp)
+		_, _ = c.first(ctx, 1, 1, resp)
+		_, err := c.next(ctx, 1, a.NextCursor, 0)
+		So(err, ShouldNotBeNil)
+		next, err := c.next(ctx, 1, b.NextCursor, 0)
+		So(err, ShouldBeNil)
+		So(next.ItemScoreList, ShouldResemble, []ItemScore{{ItemId: 2}})
+		So(next.NextCursor, ShouldBeEmpty)
+
+		now = now.Add(time.Minute)
+		_, err = c.next(ctx, 1, b.NextCursor, 0)
+		So(err, ShouldNotBeNil)
+		So(c.lists, ShouldHaveLength, 1)
+		So(c.order.Len(), ShouldEqual, 1)
+
+		// the expired lists are evicted before the oldest alive
+		d, _ := c.first(ctx, 1, 1, resp)
+		now = now.Add(time.Second)
+		e, _ := c.first(ctx, 1, 1, resp)
+		So(c.lists, ShouldHaveLength, 2)
+		So(c.order.Len(), ShouldEqual, 2)
+		_, err = c.next(ctx, 1, d.NextCursor, 0)
+		So(err, ShouldBeNil)
+		_, err = c.next(ctx, 1, e.NextCursor, 0)
+		So(err, ShouldBeNil)
+	})
+}
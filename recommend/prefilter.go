@@ -0,0 +1,258 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ReasonAttributeError counts the items of which the attributes failed
+	// to load, they are filtered out
+	ReasonAttributeError = "attribute_error"
+)
+
+// ItemAttributer is implemented by the Predictor serving the item attributes
+// of the feature store, e.g. the stock, the regions and the age rating, which
+// are checked by the predicates of PreFilter.
+type ItemAttributer interface {
+	GetItemAttributes(ctx context.Context, itemId int) (map[string]string, error)
+}
+
+// UserAttributer is implemented by the Predictor serving the user attributes
+// the items are checked against, e.g. the region and the age
+type UserAttributer interface {
+	GetUserAttributes(ctx context.Context, userId int) (map[string]string, error)
+}
+
+// FilterUser is the user the candidates are filtered for
+type FilterUser struct {
+	UserId int
+	// Attributes is by UserAttributer, nil if the Predictor is not one
+	Attributes map[string]string
+	// Now is the request time, e.g. of the publish window
+	Now time.Time
+}
+
+// Predicate is an eligibility check of the candidate items
+type Predicate interface {
+	// Reason names the items failing the predicate in the FilterStats
+	Reason() string
+	// Eligible checks the item of attributes for user
+	Eligible(user *FilterUser, attributes map[string]string) bool
+}
+
+type predicateFunc struct {
+	reason   string
+	eligible func(user *FilterUser, attributes map[string]string) bool
+}
+
+func (p *predicateFunc) Reason() string {
+	return p.reason
+}
+
+func (p *predicateFunc) Eligible(user *FilterUser, attributes map[string]string) bool {
+	return p.eligible(user, attributes)
+}
+
+// PredicateFunc is the Predicate of reason checked by eligible
+func PredicateFunc(reason string, eligible func(user *FilterUser, attributes map[string]string) bool) Predicate {
+	return &predicateFunc{reason: reason, eligible: eligible}
+}
+
+// InStock keeps the items of which the integer attribute stockKey is
+// positive. The items without the attribute are not stock tracked and kept.
+func InStock(stockKey string) Predicate {
+	return PredicateFunc("out_of_stock", func(_ *FilterUser, attributes map[string]string) bool {
+		v, ok := attributes[stockKey]
+		if !ok {
+			return true
+		}
+		stock, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return err == nil && stock > 0
+	})
+}
+
+// RegionAllowed keeps the items of which the comma separated attribute
+// regionsKey has the user attribute userRegionKey. The items without the
+// attribute are allowed everywhere, the users of unknown region are allowed
+// those items only.
+func RegionAllowed(regionsKey, userRegionKey string) Predicate {
+	return PredicateFunc("region_not_allowed", func(user *FilterUser, attributes map[string]string) bool {
+		regions := strings.TrimSpace(attributes[regionsKey])
+		if regions == "" {
+			return true
+		}
+		region := strings.TrimSpace(user.Attributes[userRegionKey])
+		if region == "" {
+			return false
+		}
+		for _, r := range strings.Split(regions, ",") {
+			if strings.EqualFold(strings.TrimSpace(r), region) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// AgeRating keeps the items of which the minimum age attribute ratingKey is
+// not above the user attribute userAgeKey. The items without the attribute
+// are for all ages, the users of unknown age are allowed those items only.
+func AgeRating(ratingKey, userAgeKey string) Predicate {
+	return PredicateFunc("age_rating", func(user *FilterUser, attributes map[string]string) bool {
+		v, ok := attributes[ratingKey]
+		if !ok {
+			return true
+		}
+		rating, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return false
+		}
+		if rating <= 0 {
+			return true
+		}
+		age, err := strconv.Atoi(strings.TrimSpace(user.Attributes[userAgeKey]))
+		return err == nil && age >= rating
+	})
+}
+
+// PublishWindow keeps the items published at the request time, the
+// attributes startKey and endKey are the unix seconds of the window
+// [start, end). A missing bound is open.
+func PublishWindow(startKey, endKey string) Predicate {
+	return PredicateFunc("not_published", func(user *FilterUser, attributes map[string]string) bool {
+		now := user.Now.Unix()
+		if v, ok := attributes[startKey]; ok {
+			start, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil || now < start {
+				return false
+			}
+		}
+		if v, ok := attributes[endKey]; ok {
+			end, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil || now >= end {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// FilterStats is the counters of PreFilter to debug the empty results
+type FilterStats struct {
+	Requests int64 `json:"requests"`
+	// Candidates and Kept are the items before and after the filtering
+	Candidates int64 `json:"candidates"`
+	Kept       int64 `json:"kept"`
+	// Empty is the requests of which all the candidates are filtered out
+	Empty int64 `json:"empty"`
+	// Reasons is the filtered items by the reason of the first failed
+	// predicate
+	Reasons map[string]int64 `json:"reasons"`
+}
+
+// PreFilter is the stage between the recall and the ranking removing the
+// ineligible candidates, e.g. out of stock or not allowed in the user region.
+// The item attributes are by the ItemAttributer of the Predictor.
+type PreFilter struct {
+	predicates []Predicate
+
+	mu    sync.Mutex
+	stats FilterStats
+}
+
+func NewPreFilter(predicates ...Predicate) *PreFilter {
+	return &PreFilter{
+		predicates: predicates,
+		stats:      FilterStats{Reasons: make(map[string]int64)},
+	}
+}
+
+// WithPreFilter filters the candidates of every request by f before the
+// ranking, the stats are served at /api/v1/filter/stats
+func WithPreFilter(f *PreFilter) ApiOption {
+	return func(c *apiConfig) {
+		c.prefilter = f
+	}
+}
+
+// Filter returns the eligible itemIds of userId in order, and the filtered
+// items count by reason. The items of which the attributes failed to load are
+// filtered as ReasonAttributeError, err is returned only if recSys serves no
+// attributes or the ctx is done.
+func (f *PreFilter) Filter(ctx context.Context, recSys Predictor, userId int, itemIds []int) (
+	kept []int, filtered map[string]int, err error,
+) {
+	if len(f.predicates) == 0 {
+		return itemIds, nil, nil
+	}
+	attributer, ok := recSys.(ItemAttributer)
+	if !ok {
+		return nil, nil, fmt.Errorf("predictor %T is not an ItemAttributer", recSys)
+	}
+	user := &FilterUser{UserId: userId, Now: time.Now()}
+	if ua, ok := recSys.(UserAttributer); ok {
+		if user.Attributes, err = ua.GetUserAttributes(ctx, userId); err != nil {
+			return nil, nil, fmt.Errorf("get user %d attributes error: %v", userId, err)
+		}
+	}
+	kept = make([]int, 0, len(itemIds))
+	filtered = make(map[string]int)
+	for _, itemId := range itemIds {
+		if err = ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if reason := f.check(ctx, attributer, user, itemId); reason != "" {
+			filtered[reason]++
+			RecordUnscored(ctx, DropPreFilter, reason, itemId)
+			continue
+		}
+		kept = append(kept, itemId)
+	}
+	f.record(len(itemIds), kept, filtered)
+	return kept, filtered, nil
+}
+
+// check returns the reason itemId is filtered, empty if eligible
+func (f *PreFilter) check(ctx context.Context, attributer ItemAttributer, user *FilterUser, itemId int) string {
+	attributes, err := attributer.GetItemAttributes(ctx, itemId)
+	if err != nil {
+		return ReasonAttributeError
+	}
+	for _, p := range f.predicates {
+		if !p.Eligible(user, attributes) {
+			return p.Reason()
+		}
+	}
+	return ""
+}
+
+func (f *PreFilter) record(candidates int, kept []int, filtered map[string]int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats.Requests++
+	f.stats.Candidates += int64(candidates)
+	f.stats.Kept += int64(len(kept))
+	if len(kept) == 0 && candidates > 0 {
+		f.stats.Empty++
+	}
+	for reason, n := range filtered {
+		f.stats.Reasons[reason] += int64(n)
+	}
+}
+
+// Stats returns a copy of the counters
+func (f *PreFilter) Stats() FilterStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := f.stats
+	stats.Reasons = make(map[string]int64, len(f.stats.Reasons))
+	for reason, n := range f.stats.Reasons {
+		stats.Reasons[reason] = n
+	}
+	return stats
+}
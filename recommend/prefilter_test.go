@@ -0,0 +1,115 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// attributePredictor serves the item attributes of items, the missing items
+// fail to load
+type attributePredictor struct {
+	constPredictor
+	items map[int]map[string]string
+	user  map[string]string
+}
+
+func (p *attributePredictor) GetItemAttributes(_ context.Context, itemId int) (map[string]string, error) {
+	attributes, ok := p.items[itemId]
+	if !ok {
+		return nil, fmt.Errorf("item %d not found", itemId)
+	}
+	return attributes, nil
+}
+
+func (p *attributePredictor) GetUserAttributes(context.Context, int) (map[string]string, error) {
+	return p.user, nil
+}
+
+func (p *attributePredictor) Recall(context.Context, int) ([]int, error) {
+	return []int{1, 2, 3, 4, 5, 6}, nil
+}
+
+func TestPreFilter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Unix()
+	p := &attributePredictor{
+		items: map[int]map[string]string{
+			1: {},
+			2: {"stock": "0"},
+			3: {"regions": "us, ca"},
+			4: {"age": "18"},
+			5: {"publishEnd": strconv.FormatInt(now-1, 10)},
+			6: {"stock": "3", "regions": "CN", "age": "12", "publishStart": strconv.FormatInt(now-1, 10)},
+		},
+		user: map[string]string{"region": "cn", "age": "16"},
+	}
+	f := NewPreFilter(
+		InStock("stock"),
+		RegionAllowed("regions", "region"),
+		AgeRating("age", "age"),
+		PublishWindow("publishStart", "publishEnd"),
+	)
+
+	Convey("filter the ineligible candidates", t, func() {
+		kept, filtered, err := f.Filter(ctx, p, 1, []int{1, 2, 3, 4, 5, 6, 7})
+		So(err, ShouldBeNil)
+		So(kept, ShouldResemble, []int{1, 6})
+		So(filtered, ShouldResemble, map[string]int{
+			"out_of_stock":       1,
+			"region_not_allowed": 1,
+			"age_rating":         1,
+			"not_published":      1,
+			ReasonAttributeError: 1,
+		})
+
+		stats := f.Stats()
+		So(stats.Requests, ShouldEqual, 1)
+		So(stats.Candidates, ShouldEqual, 7)
+		So(stats.Kept, ShouldEqual, 2)
+		So(stats.Reasons["out_of_stock"], ShouldEqual, 1)
+
+		_, _, err = f.Filter(ctx, &constPredictor{}, 1, []int{1})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("the unknown users are allowed the unrestricted items only", t, func() {
+		unknown := &attributePredictor{items: p.items}
+		kept, _, err := NewPreFilter(RegionAllowed("regions", "region"), AgeRating("age", "age")).
+			Filter(ctx, unknown, 1, []int{1, 3, 4})
+		So(err, ShouldBeNil)
+		So(kept, ShouldResemble, []int{1})
+	})
+
+	Convey("filter reasons of the empty result in the http api", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, p)), ShouldBeNil)
+		empty := NewPreFilter(InStock("stock"), PredicateFunc("blocked", func(_ *FilterUser, attributes map[string]string) bool {
+			return attributes["stock"] == ""
+		}))
+		engine := newTenantEngine(r, "/api/v1/recommend", WithPreFilter(empty))
+		req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(`{"userId":1,"itemIdList":[2,6]}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		So(w.Code, ShouldEqual, 200)
+		var resp RecApiResponse
+		So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+		So(resp.ItemScoreList, ShouldBeEmpty)
+		So(resp.Filtered, ShouldResemble, map[string]int{"out_of_stock": 1, "blocked": 1})
+
+		w = httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/filter/stats", nil))
+		var stats FilterStats
+		So(json.Unmarshal(w.Body.Bytes(), &stats), ShouldBeNil)
+		So(stats.Empty, ShouldEqual, 1)
+		So(stats.Reasons["blocked"], ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,252 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMinCohort is the min events of a cohort released for training
+const DefaultMinCohort = 10
+
+// DeviceProfile is the user profile kept on the device by the client SDK and
+// sent with every request in the privacy mode, see WithPrivacyMode
+type DeviceProfile struct {
+	// UserFeature is the user profile of the width of the model
+	UserFeature Tensor `json:"userFeature"`
+	// Behaviors is the recent items of the user, the latest first
+	Behaviors []int `json:"behaviors"`
+}
+
+type deviceProfileKey struct{}
+
+// WithDeviceProfile returns the ctx of which the features of the user are
+// assembled of profile instead of the provider and the feature caches
+func WithDeviceProfile(ctx context.Context, profile *DeviceProfile) context.Context {
+	return context.WithValue(ctx, deviceProfileKey{}, profile)
+}
+
+func deviceProfile(ctx context.Context) *DeviceProfile {
+	p, _ := ctx.Value(deviceProfileKey{}).(*DeviceProfile)
+	return p
+}
+
+// WithPrivacyMode keeps the user profiles on the devices only: the requests
+// without RecApiRequest.DeviceProfile are rejected with 400, and the server
+// keeps no state of the users. So the feedback api is not served, the items
+// seen are only the RecApiRequest.SeenItemIds of the device and paginated
+// requests are rejected. If cohorts is not nil, the anonymous events of the
+// devices are aggregated at /api/v1/privacy/events for the training:
+//
+//	curl --header "Content-Type: application/json" \
+//	  --request POST \
+//	  --data '{"itemId":39,"clicked":true,"profile":{"userFeature":[0.1,0.2]}}' \
+//	  http://localhost:8080/api/v1/privacy/events
+func WithPrivacyMode(cohorts *CohortAggregator) ApiOption {
+	return func(c *apiConfig) {
+		c.privacy = &privacyMode{cohorts: cohorts}
+	}
+}
+
+type privacyMode struct {
+	cohorts *CohortAggregator
+}
+
+// addPrivacyRoutes serves the device events api if configured
+func addPrivacyRoutes(engine *gin.Engine, conf *apiConfig) {
+	if conf.privacy == nil || conf.privacy.cohorts == nil {
+		return
+	}
+	engine.POST("/api/v1/privacy/events", conf.handlers(func(c *gin.Context) {
+		var e DeviceEvent
+		if err := c.ShouldBind(&e); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := conf.privacy.cohorts.Add(e); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"itemId": e.ItemId})
+	})...)
+}
+
+// DeviceEvent is an impression reported by a device without the user id,
+// Profile is the DeviceProfile at the impression
+type DeviceEvent struct {
+	ItemId  int           `json:"itemId"`
+	Clicked bool          `json:"clicked"`
+	Profile DeviceProfile `json:"profile"`
+}
+
+// Cohort is the aggregate of the events of an item of the same label, the
+// mean profile of its users and their most frequent behaviors
+type Cohort struct {
+	ItemId      int    `json:"itemId"`
+	Clicked     bool   `json:"clicked"`
+	Count       int    `json:"count"`
+	UserFeature Tensor `json:"userFeature"`
+	Behaviors   []int  `json:"behaviors"`
+}
+
+type cohortKey struct {
+	itemId  int
+	clicked bool
+}
+
+type cohortSum struct {
+	count     int
+	feature   []float64
+	behaviors map[int]int
+}
+
+// CohortAggregator aggregates the device events into the cohorts of the item
+// and the label, no event nor user is kept. Only the cohorts of MinCount
+// events are released, so no cohort identifies a user, and they are trained
+// as the users of the aggregated profiles, see CohortRecSys.
+type CohortAggregator struct {
+	MinCount int
+
+	mu      sync.Mutex
+	width   int
+	cohorts map[cohortKey]*cohortSum
+}
+
+// NewCohortAggregator creates the CohortAggregator of the min events of a
+// cohort, DefaultMinCohort if 0
+func NewCohortAggregator(minCount int) *CohortAggregator {
+	if minCount <= 0 {
+		minCount = DefaultMinCohort
+	}
+	return &CohortAggregator{MinCount: minCount, width: -1, cohorts: make(map[cohortKey]*cohortSum)}
+}
+
+// Add aggregates e, the profiles of all the events should be of the same width
+func (a *CohortAggregator) Add(e DeviceEvent) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.width < 0 {
+		a.width = len(e.Profile.UserFeature)
+	} else if len(e.Profile.UserFeature) != a.width {
+		return fmt.Errorf("device profile of width %d, expected %d", len(e.Profile.UserFeature), a.width)
+	}
+	key := cohortKey{itemId: e.ItemId, clicked: e.Clicked}
+	s := a.cohorts[key]
+	if s == nil {
+		s = &cohortSum{feature: make([]float64, a.width), behaviors: make(map[int]int)}
+		a.cohorts[key] = s
+	}
+	s.count++
+	for i, v := range e.Profile.UserFeature {
+		s.feature[i] += float64(v)
+	}
+	for i, id := range e.Profile.Behaviors {
+		if i >= UserBehaviorLen {
+			break
+		}
+		s.behaviors[id]++
+	}
+	return nil
+}
+
+// Cohorts returns the released cohorts by item id, the non-clicked first
+func (a *CohortAggregator) Cohorts() (cohorts []Cohort) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, s := range a.cohorts {
+		if s.count < a.MinCount {
+			continue
+		}
+		c := Cohort{ItemId: key.itemId, Clicked: key.clicked, Count: s.count, UserFeature: make(Tensor, len(s.feature))}
+		for i, v := range s.feature {
+			c.UserFeature[i] = float32(v / float64(s.count))
+		}
+		for id := range s.behaviors {
+			c.Behaviors = append(c.Behaviors, id)
+		}
+		sort.Slice(c.Behaviors, func(i, j int) bool {
+			ci, cj := s.behaviors[c.Behaviors[i]], s.behaviors[c.Behaviors[j]]
+			if ci != cj {
+				return ci > cj
+			}
+			return c.Behaviors[i] < c.Behaviors[j]
+		})
+		if len(c.Behaviors) > UserBehaviorLen {
+			c.Behaviors = c.Behaviors[:UserBehaviorLen]
+		}
+		cohorts = append(cohorts, c)
+	}
+	sort.Slice(cohorts, func(i, j int) bool {
+		if cohorts[i].ItemId != cohorts[j].ItemId {
+			return cohorts[i].ItemId < cohorts[j].ItemId
+		}
+		return !cohorts[i].Clicked && cohorts[j].Clicked
+	})
+	return
+}
+
+// CohortRecSys is the RecSys of the released cohorts as the users, the user
+// id of a cohort is its index, so the model is trained of the aggregated
+// signals only. The item features are of Items.
+type CohortRecSys struct {
+	Items   ItemFeaturer
+	Cohorts []Cohort
+}
+
+// CohortRecSys returns the CohortRecSys of the cohorts released now
+func (a *CohortAggregator) CohortRecSys(items ItemFeaturer) *CohortRecSys {
+	return &CohortRecSys{Items: items, Cohorts: a.Cohorts()}
+}
+
+func (r *CohortRecSys) cohort(userId int) (*Cohort, error) {
+	if userId < 0 || userId >= len(r.Cohorts) {
+		return nil, fmt.Errorf("cohort %d not found", userId)
+	}
+	return &r.Cohorts[userId], nil
+}
+
+func (r *CohortRecSys) GetUserFeature(_ context.Context, userId int) (Tensor, error) {
+	c, err := r.cohort(userId)
+	if err != nil {
+		return nil, err
+	}
+	return c.UserFeature, nil
+}
+
+func (r *CohortRecSys) GetItemFeature(ctx context.Context, itemId int) (Tensor, error) {
+	return r.Items.GetItemFeature(ctx, itemId)
+}
+
+func (r *CohortRecSys) GetUserBehavior(_ context.Context, userId int, maxLen int64, _ int64, _ int64) ([]int, error) {
+	c, err := r.cohort(userId)
+	if err != nil {
+		return nil, err
+	}
+	if maxLen >= 0 && int64(len(c.Behaviors)) > maxLen {
+		return c.Behaviors[:maxLen], nil
+	}
+	return c.Behaviors, nil
+}
+
+// SampleGenerator yields a sample of every cohort labeled by Clicked
+func (r *CohortRecSys) SampleGenerator(ctx context.Context) (<-chan Sample, error) {
+	ch := make(chan Sample)
+	go func() {
+		defer close(ch)
+		for i, c := range r.Cohorts {
+			s := Sample{UserId: i, ItemId: c.ItemId}
+			if c.Clicked {
+				s.Label = 1
+			}
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,128 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/karlseguin/ccache/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeviceProfile(t *testing.T) {
+	emb := make([]float32, ItemEmbDim)
+	for i := range emb {
+		emb[i] = 1
+	}
+	itemEmbeddingMap = word2vec.EmbeddingMap32{"1": emb}
+	defer func() { itemEmbeddingMap = nil }()
+
+	Convey("features of the device profile", t, func() {
+		userCache := ccache.New(ccache.Configure())
+		a := NewFeatureAssembler(&fakeProvider{}, userCache, nil)
+		ctx := WithDeviceProfile(context.Background(), &DeviceProfile{UserFeature: Tensor{5, 6, 7}, Behaviors: []int{1}})
+		vec, uWidth, _, err := a.Assemble(ctx, &Sample{UserId: 7, ItemId: 2})
+		So(err, ShouldBeNil)
+		So(uWidth, ShouldEqual, 3)
+		So(vec[:3], ShouldResemble, []float32{5, 6, 7})
+		// the behaviors of the device, not of the provider
+		So(vec[3:3+ItemEmbDim], ShouldResemble, emb)
+		So(userCache.ItemCount(), ShouldEqual, 0)
+
+		vec, uWidth, _, err = a.Assemble(context.Background(), &Sample{UserId: 7, ItemId: 2})
+		So(err, ShouldBeNil)
+		So(uWidth, ShouldEqual, 2)
+		So(vec[:2], ShouldResemble, []float32{7, 1})
+		So(userCache.ItemCount(), ShouldEqual, 1)
+	})
+}
+
+func TestPrivacyMode(t *testing.T) {
+	Convey("stateless serving of the device profiles", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &sumPredictor{})), ShouldBeNil)
+		cohorts := NewCohortAggregator(2)
+		engine := newTenantEngine(r, "/api/v1/recommend",
+			WithPrivacyMode(cohorts),
+			WithFeedback(FeedbackConfig{}),
+			WithSessionExclusion(SessionConfig{Default: SessionPolicy{Exclude: true, Track: true}}),
+		)
+		post := func(path, body string) (code int, resp RecApiResponse) {
+			req := httptest.NewRequest("POST", path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			return w.Code, resp
+		}
+
+		code, _ := post("/api/v1/recommend", `{"userId":1,"itemIdList":[1,2]}`)
+		So(code, ShouldEqual, 400)
+		code, _ = post("/api/v1/recommend", `{"deviceProfile":{"userFeature":[10]},"itemIdList":[1,2],"pageSize":1}`)
+		So(code, ShouldEqual, 400)
+
+		code, resp := post("/api/v1/recommend",
+			`{"sessionId":"s","seenItemIds":[3],"deviceProfile":{"userFeature":[10]},"itemIdList":[1,2,3]}`)
+		So(code, ShouldEqual, 200)
+		So(resp.ItemScoreList, ShouldHaveLength, 2)
+		for _, is := range resp.ItemScoreList {
+			So(is.Score, ShouldEqual, 10+is.ItemId)
+		}
+		So(resp.Filtered, ShouldResemble, map[string]int{ReasonSeenInSession: 1})
+		// the items served are not tracked in the session
+		_, resp = post("/api/v1/recommend", `{"sessionId":"s","deviceProfile":{"userFeature":[10]},"itemIdList":[1,2]}`)
+		So(resp.ItemScoreList, ShouldHaveLength, 2)
+
+		code, _ = post("/api/v1/feedback", `{"userId":1,"itemId":1,"action":"hide"}`)
+		So(code, ShouldEqual, 404)
+
+		for _, body := range []string{
+			`{"itemId":1,"clicked":true,"profile":{"userFeature":[1,2],"behaviors":[5,6]}}`,
+			`{"itemId":1,"clicked":true,"profile":{"userFeature":[3,4],"behaviors":[6]}}`,
+			`{"itemId":1,"profile":{"userFeature":[3,4]}}`,
+		} {
+			code, _ = post("/api/v1/privacy/events", body)
+			So(code, ShouldEqual, 200)
+		}
+		code, _ = post("/api/v1/privacy/events", `{"itemId":1,"profile":{"userFeature":[3]}}`)
+		So(code, ShouldEqual, 400)
+		// the cohort of a single event is not released
+		So(cohorts.Cohorts(), ShouldResemble, []Cohort{
+			{ItemId: 1, Clicked: true, Count: 2, UserFeature: Tensor{2, 3}, Behaviors: []int{6, 5}},
+		})
+	})
+}
+
+func TestCohortRecSys(t *testing.T) {
+	Convey("training of the cohorts", t, func() {
+		cohorts := NewCohortAggregator(0)
+		So(cohorts.MinCount, ShouldEqual, DefaultMinCohort)
+		cohorts.MinCount = 1
+		So(cohorts.Add(DeviceEvent{ItemId: 2, Profile: DeviceProfile{UserFeature: Tensor{1}}}), ShouldBeNil)
+		So(cohorts.Add(DeviceEvent{ItemId: 2, Clicked: true, Profile: DeviceProfile{UserFeature: Tensor{3}, Behaviors: []int{4, 5}}}), ShouldBeNil)
+
+		recSys := cohorts.CohortRecSys(&fakeProvider{})
+		ch, err := recSys.SampleGenerator(context.Background())
+		So(err, ShouldBeNil)
+		var samples []Sample
+		for s := range ch {
+			samples = append(samples, s)
+		}
+		So(samples, ShouldResemble, []Sample{{UserId: 0, ItemId: 2}, {UserId: 1, ItemId: 2, Label: 1}})
+
+		f, err := recSys.GetUserFeature(context.Background(), 1)
+		So(err, ShouldBeNil)
+		So(f, ShouldResemble, Tensor{3})
+		seq, err := recSys.GetUserBehavior(context.Background(), 1, 1, -1, -1)
+		So(err, ShouldBeNil)
+		So(seq, ShouldResemble, []int{4})
+		f, err = recSys.GetItemFeature(context.Background(), 2)
+		So(err, ShouldBeNil)
+		So(f, ShouldResemble, Tensor{2})
+		_, err = recSys.GetUserFeature(context.Background(), 2)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,207 @@
+package recommend
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxCardinality is the max distinct values counted per feature
+	maxCardinality = 1000
+)
+
+// QualityRules are the violations checked on the training sample, zero
+// disables the rule
+type QualityRules struct {
+	// MinPositiveRate and MaxPositiveRate bound the label balance
+	MinPositiveRate float64 `json:"minPositiveRate" yaml:"minPositiveRate"`
+	MaxPositiveRate float64 `json:"maxPositiveRate" yaml:"maxPositiveRate"`
+	// MaxNullRate is the max NaN or Inf rate of every feature
+	MaxNullRate float64 `json:"maxNullRate" yaml:"maxNullRate"`
+	// MaxLabelCorrelation is the max absolute Pearson correlation between a
+	// feature and the label, above it the feature probably leaks the label
+	MaxLabelCorrelation float64 `json:"maxLabelCorrelation" yaml:"maxLabelCorrelation"`
+	// Abort fails Train on any violation
+	Abort bool `json:"abort" yaml:"abort"`
+}
+
+// DefaultQualityRules only reports the features perfectly correlated with
+// the label, without aborting
+func DefaultQualityRules() *QualityRules {
+	return &QualityRules{MaxLabelCorrelation: 0.99}
+}
+
+// QualityChecker could be implemented by the RecSys to set the QualityRules
+// checked by Train
+type QualityChecker interface {
+	QualityRules() *QualityRules
+}
+
+// FeatureProfile is the statistics of a feature column
+type FeatureProfile struct {
+	Name     string  `json:"name"`
+	NullRate float64 `json:"nullRate"`
+	// Cardinality is the distinct values, capped at 1000
+	Cardinality      int     `json:"cardinality"`
+	Min              float32 `json:"min"`
+	Max              float32 `json:"max"`
+	Mean             float64 `json:"mean"`
+	LabelCorrelation float64 `json:"labelCorrelation"`
+}
+
+// QualityReport is the data profile of the training sample
+type QualityReport struct {
+	Rows         int              `json:"rows"`
+	Positives    int              `json:"positives"`
+	PositiveRate float64          `json:"positiveRate"`
+	Features     []FeatureProfile `json:"features"`
+	Violations   []string         `json:"violations,omitempty"`
+}
+
+// Err returns the violations as an error, nil if there is none
+func (r *QualityReport) Err() error {
+	if len(r.Violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d data quality violations: %s", len(r.Violations), strings.Join(r.Violations, "; "))
+}
+
+type featureStats struct {
+	nulls, n          int
+	sum, sumSq, sumXY float64
+	// the label stats of the non-null rows
+	ySum, ySumSq float64
+	min, max     float32
+	distinct     map[float32]struct{}
+}
+
+// ProfileSample profiles every column of sample, names are the column names
+// (see FeatureColumnNames) or nil for "col_i", and checks the rules if not
+// nil.
+func ProfileSample(sample *TrainSample, names []string, rules *QualityRules) (report *QualityReport) {
+	var (
+		rows, cols = sample.Rows, sample.XCols
+		stats      = make([]featureStats, cols)
+		buf        []float32
+	)
+	report = &QualityReport{Rows: rows}
+	for j := range stats {
+		stats[j].min, stats[j].max = float32(math.Inf(1)), float32(math.Inf(-1))
+		stats[j].distinct = make(map[float32]struct{})
+	}
+	for i := 0; i < rows; i++ {
+		y := float64(sample.Y[i])
+		if y > 0.5 {
+			report.Positives++
+		}
+		buf = sample.Row(i, buf)
+		for j, v := range buf {
+			s := &stats[j]
+			if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+				s.nulls++
+				continue
+			}
+			s.n++
+			x := float64(v)
+			s.sum += x
+			s.sumSq += x * x
+			s.sumXY += x * y
+			s.ySum += y
+			s.ySumSq += y * y
+			if v < s.min {
+				s.min = v
+			}
+			if v > s.max {
+				s.max = v
+			}
+			if len(s.distinct) < maxCardinality {
+				s.distinct[v] = struct{}{}
+			}
+		}
+	}
+	if rows == 0 {
+		return
+	}
+	report.PositiveRate = float64(report.Positives) / float64(rows)
+
+	report.Features = make([]FeatureProfile, cols)
+	for j := range stats {
+		s := &stats[j]
+		p := &report.Features[j]
+		p.Name = fmt.Sprintf("col_%d", j)
+		if j < len(names) {
+			p.Name = names[j]
+		}
+		p.NullRate = float64(s.nulls) / float64(rows)
+		p.Cardinality = len(s.distinct)
+		if s.n == 0 {
+			continue
+		}
+		p.Min, p.Max = s.min, s.max
+		p.Mean = s.sum / float64(s.n)
+		var (
+			n     = float64(s.n)
+			yMean = s.ySum / n
+			cov   = s.sumXY/n - p.Mean*yMean
+			xVar  = s.sumSq/n - p.Mean*p.Mean
+			yVar  = s.ySumSq/n - yMean*yMean
+		)
+		if xVar > 1e-12 && yVar > 1e-12 {
+			p.LabelCorrelation = cov / math.Sqrt(xVar*yVar)
+		}
+	}
+	if rules != nil {
+		report.Violations = checkQuality(report, rules)
+	}
+	return
+}
+
+func checkQuality(report *QualityReport, rules *QualityRules) (violations []string) {
+	if rules.MinPositiveRate > 0 && report.PositiveRate < rules.MinPositiveRate {
+		violations = append(violations, fmt.Sprintf("positive rate %.4f < %v", report.PositiveRate, rules.MinPositiveRate))
+	}
+	if rules.MaxPositiveRate > 0 && report.PositiveRate > rules.MaxPositiveRate {
+		violations = append(violations, fmt.Sprintf("positive rate %.4f > %v", report.PositiveRate, rules.MaxPositiveRate))
+	}
+	for _, f := range report.Features {
+		if rules.MaxNullRate > 0 && f.NullRate > rules.MaxNullRate {
+			violations = append(violations, fmt.Sprintf("%s null rate %.4f > %v", f.Name, f.NullRate, rules.MaxNullRate))
+		}
+		if rules.MaxLabelCorrelation > 0 && math.Abs(f.LabelCorrelation) > rules.MaxLabelCorrelation {
+			violations = append(violations, fmt.Sprintf("%s label correlation %.4f, probably leaks the label",
+				f.Name, f.LabelCorrelation))
+		}
+	}
+	return
+}
+
+// checkSampleQuality profiles trainSample of recSys by its QualityRules, or
+// the DefaultQualityRules. The report is logged as JSON, it's an error if
+// there is any violation and the rules abort.
+func checkSampleQuality(recSys RecSys, trainSample *TrainSample) (report *QualityReport, err error) {
+	rules := DefaultQualityRules()
+	if checker, ok := recSys.(QualityChecker); ok {
+		rules = checker.QualityRules()
+	}
+	var userNames, itemNames []string
+	if namer, ok := recSys.(FeatureNamer); ok {
+		userNames, itemNames = namer.UserFeatureNames(), namer.ItemFeatureNames()
+	}
+	ctxRange := trainSample.Info.CtxFeatureRange
+	itemNames = ctxFeatureNames(itemNames, ctxRange[1]-ctxRange[0], trainSample.FeatureFuncs)
+	report = ProfileSample(trainSample, FeatureColumnNames(&trainSample.Info, userNames, itemNames), rules)
+	if data, er := json.Marshal(report); er == nil {
+		log.Infof("data quality report: %s", data)
+	}
+	for _, v := range report.Violations {
+		log.Warnf("data quality violation: %s", v)
+	}
+	if rules != nil && rules.Abort {
+		err = report.Err()
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package recommend
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// leakyRecSys generates the samples of user id equal to the label
+type leakyRecSys struct {
+	lineageRecSys
+	rules *QualityRules
+}
+
+func (r *leakyRecSys) SampleGenerator(context.Context) (<-chan Sample, error) {
+	ch := make(chan Sample, 4)
+	for i := 0; i < 4; i++ {
+		ch <- Sample{UserId: i % 2, ItemId: i, Label: float32(i % 2), Timestamp: int64(100 + i)}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (r *leakyRecSys) QualityRules() *QualityRules {
+	return r.rules
+}
+
+func TestQuality(t *testing.T) {
+	userCache, itemCache := UserFeatureCache, ItemFeatureCache
+	defer func() {
+		UserFeatureCache, ItemFeatureCache = userCache, itemCache
+	}()
+
+	Convey("profile sample", t, func() {
+		nan := float32(math.NaN())
+		sample := &TrainSample{
+			// label, constant, null
+			X: []float32{
+				0, 1, 2,
+				1, 1, nan,
+				0, 1, 3,
+				1, 1, nan,
+			},
+			Y:     []float32{0, 1, 0, 1},
+			Rows:  4,
+			XCols: 3,
+		}
+		report := ProfileSample(sample, []string{"leak", "const"}, &QualityRules{
+			MinPositiveRate:     0.6,
+			MaxNullRate:         0.2,
+			MaxLabelCorrelation: 0.99,
+		})
+		So(report.Rows, ShouldEqual, 4)
+		So(report.Positives, ShouldEqual, 2)
+		So(report.PositiveRate, ShouldEqual, 0.5)
+		So(report.Features, ShouldHaveLength, 3)
+
+		leak, constant, null := report.Features[0], report.Features[1], report.Features[2]
+		So(leak.Name, ShouldEqual, "leak")
+		So(leak.LabelCorrelation, ShouldAlmostEqual, 1, 1e-9)
+		So(leak.Cardinality, ShouldEqual, 2)
+		So(constant.LabelCorrelation, ShouldEqual, 0)
+		So(constant.Cardinality, ShouldEqual, 1)
+		So(null.Name, ShouldEqual, "col_2")
+		So(null.NullRate, ShouldEqual, 0.5)
+		So(null.Min, ShouldEqual, 2)
+		So(null.Max, ShouldEqual, 3)
+		So(null.Mean, ShouldEqual, 2.5)
+
+		So(report.Violations, ShouldHaveLength, 3)
+		So(report.Err(), ShouldNotBeNil)
+		So(ProfileSample(sample, nil, nil).Err(), ShouldBeNil)
+	})
+
+	Convey("train with leaky sample", t, func() {
+		m, err := Train(context.Background(), &leakyRecSys{rules: DefaultQualityRules()}, &sumFitter{})
+		So(err, ShouldBeNil)
+		quality := m.(ManifestProvider).Manifest().Quality
+		So(quality.Violations, ShouldNotBeEmpty)
+		So(quality.Violations[0], ShouldContainSubstring, "leaks the label")
+
+		_, err = Train(context.Background(), &leakyRecSys{rules: &QualityRules{MaxLabelCorrelation: 0.99, Abort: true}},
+			&sumFitter{})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,202 @@
+package recommend
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/auxten/go-ctr/feature"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultQueryBuckets is the width of the hashed n-grams of the query of
+	// the default QueryFeatures.Hasher
+	DefaultQueryBuckets = 256
+	// queryMatchWidth is the width of the query item match features
+	queryMatchWidth = 4
+)
+
+type queryKey struct{}
+
+// WithQuery returns the ctx of the search query of the request, the
+// FeatureFuncs get it by QueryFromContext. The http api sets the
+// RecApiRequest.Query, the training samples of a Query set their own.
+func WithQuery(ctx context.Context, query string) context.Context {
+	return context.WithValue(ctx, queryKey{}, query)
+}
+
+// QueryFromContext returns the query of ctx, empty of the feeds
+func QueryFromContext(ctx context.Context) string {
+	query, _ := ctx.Value(queryKey{}).(string)
+	return query
+}
+
+// QueryFeatures are the ctx features of the search query and its match of
+// the items, so the same model ranks the search results and the feeds: the
+// query features of the feeds are zeros. The features are:
+//
+//	has query | query vector | coverage | jaccard | phrase | cosine
+//
+// The query vector is the hashed n-grams of Hasher or the embedding of
+// Embed. The match features of ItemText are the share of the query tokens
+// in the item text, the jaccard of the token sets, whether the query tokens
+// are a phrase of the item text and the cosine of the hashed n-grams.
+type QueryFeatures struct {
+	Name string
+	// Hasher of the query text, the 1 and 2-grams of DefaultQueryBuckets
+	// without the feature.DefaultStopwords, L2 normalized, if nil
+	Hasher *feature.NGramHasher
+	// Embed returns the query embedding of Dim instead of the hashed n-grams,
+	// e.g. of a sentence encoder. The error is logged and the query vector is
+	// zeros.
+	Embed func(ctx context.Context, query string) ([]float32, error)
+	Dim   int
+	// ItemText returns the text of the item matched, e.g. the title, nil
+	// disables the match features. The error is logged and the match
+	// features are zeros.
+	ItemText func(ctx context.Context, itemId int) (string, error)
+}
+
+func defaultQueryHasher() *feature.NGramHasher {
+	return &feature.NGramHasher{
+		Tokenizer:  feature.Tokenizer{Stopwords: feature.DefaultStopwords},
+		MaxN:       2,
+		Buckets:    DefaultQueryBuckets,
+		Normalizer: &feature.SampleNormalizerL2{},
+	}
+}
+
+// version is the hash of the featurization of q
+func (q *QueryFeatures) version(hasher *feature.NGramHasher) string {
+	stopwords := make([]string, 0, len(hasher.Tokenizer.Stopwords))
+	for w := range hasher.Tokenizer.Stopwords {
+		stopwords = append(stopwords, w)
+	}
+	sort.Strings(stopwords)
+	data, _ := json.Marshal(struct {
+		MinN, MaxN, Buckets int
+		Binary, KeepCase    bool
+		Stopwords           []string
+		Normalizer          string
+		Embed               bool
+		Dim                 int
+		Match               bool
+	}{hasher.MinN, hasher.MaxN, hasher.NumFeatures(), hasher.Binary, hasher.Tokenizer.KeepCase, stopwords,
+		fmt.Sprintf("%T", hasher.Normalizer), q.Embed != nil, q.Dim, q.ItemText != nil})
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// RegisterQueryFeatures registers q as the FeatureFunc of q.Name
+func RegisterQueryFeatures(q QueryFeatures) error {
+	if q.Embed != nil && q.Dim <= 0 {
+		return fmt.Errorf("query features %s embedding dim %d <= 0", q.Name, q.Dim)
+	}
+	hasher := q.Hasher
+	if hasher == nil {
+		hasher = defaultQueryHasher()
+	}
+	queryWidth := hasher.NumFeatures()
+	if q.Embed != nil {
+		queryWidth = q.Dim
+	}
+	width := 1 + queryWidth
+	if q.ItemText != nil {
+		width += queryMatchWidth
+	}
+	return registerFeatureFunc(FeatureFuncInfo{Name: q.Name, Width: width, Version: q.version(hasher)},
+		func(ctx context.Context, _, itemId int) []float64 {
+			features := make([]float64, width)
+			query := QueryFromContext(ctx)
+			if query == "" {
+				return features
+			}
+			features[0] = 1
+			vec := features[1 : 1+queryWidth]
+			var queryHashed []float64
+			if q.Embed != nil {
+				emb, err := q.Embed(ctx, query)
+				if err == nil && len(emb) != q.Dim {
+					err = fmt.Errorf("embedding dim %d != %d", len(emb), q.Dim)
+				}
+				if err != nil {
+					log.Errorf("query features %s of %q: %v", q.Name, query, err)
+				} else {
+					for i, v := range emb {
+						vec[i] = float64(v)
+					}
+				}
+			} else {
+				hasher.TransformInplace(vec, query)
+				queryHashed = vec
+			}
+			if q.ItemText == nil {
+				return features
+			}
+			text, err := q.ItemText(ctx, itemId)
+			if err != nil {
+				log.Errorf("query features %s text of item %d: %v", q.Name, itemId, err)
+				return features
+			}
+			if queryHashed == nil {
+				queryHashed = hasher.Transform(query)
+			}
+			queryMatch(features[1+queryWidth:], hasher, query, text, queryHashed)
+			return features
+		})
+}
+
+// queryMatch sets the coverage, jaccard, phrase and cosine of the query and
+// the item text to match
+func queryMatch(match []float64, hasher *feature.NGramHasher, query, text string, queryHashed []float64) {
+	queryTokens := hasher.Tokenizer.Tokenize(query)
+	textTokens := hasher.Tokenizer.Tokenize(text)
+	if len(queryTokens) == 0 || len(textTokens) == 0 {
+		return
+	}
+	textSet := make(map[string]bool, len(textTokens))
+	for _, t := range textTokens {
+		textSet[t] = true
+	}
+	querySet := make(map[string]bool, len(queryTokens))
+	var covered int
+	for _, t := range queryTokens {
+		if querySet[t] {
+			continue
+		}
+		querySet[t] = true
+		if textSet[t] {
+			covered++
+		}
+	}
+	match[0] = float64(covered) / float64(len(querySet))
+	match[1] = float64(covered) / float64(len(querySet)+len(textSet)-covered)
+	for i := 0; i+len(queryTokens) <= len(textTokens); i++ {
+		phrase := true
+		for j, t := range queryTokens {
+			if textTokens[i+j] != t {
+				phrase = false
+				break
+			}
+		}
+		if phrase {
+			match[2] = 1
+			break
+		}
+	}
+	textHashed := hasher.Transform(text)
+	var dot, qNorm, tNorm float64
+	for i := range queryHashed {
+		dot += queryHashed[i] * textHashed[i]
+		qNorm += queryHashed[i] * queryHashed[i]
+		tNorm += textHashed[i] * textHashed[i]
+	}
+	if qNorm > 0 && tNorm > 0 {
+		match[3] = dot / math.Sqrt(qNorm*tNorm)
+	}
+}
@@ -0,0 +1,144 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/auxten/go-ctr/feature"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQueryFeatures(t *testing.T) {
+	userCache, itemCache := UserFeatureCache, ItemFeatureCache
+	defer func() {
+		UserFeatureCache, ItemFeatureCache = userCache, itemCache
+	}()
+
+	titles := map[int]string{
+		0: "Star Wars",
+		1: "The Empire Strikes Back: a Star Wars story",
+		2: "Toy Story",
+	}
+	itemText := func(_ context.Context, itemId int) (string, error) {
+		title, ok := titles[itemId]
+		if !ok {
+			return "", fmt.Errorf("no title of %d", itemId)
+		}
+		return title, nil
+	}
+	queryFunc := func(name string) featureFunc {
+		for _, f := range registeredFeatureFuncs() {
+			if f.Name == name {
+				return f
+			}
+		}
+		return featureFunc{}
+	}
+
+	Convey("query context", t, func() {
+		ctx := context.Background()
+		So(QueryFromContext(ctx), ShouldBeEmpty)
+		So(QueryFromContext(WithQuery(ctx, "star wars")), ShouldEqual, "star wars")
+		So(RegisterQueryFeatures(QueryFeatures{Name: "query", Embed: func(context.Context, string) ([]float32, error) {
+			return nil, nil
+		}}), ShouldNotBeNil)
+		So(RegisteredFeatureFuncs(), ShouldBeEmpty)
+	})
+
+	Convey("hashed query and match features", t, func() {
+		So(RegisterQueryFeatures(QueryFeatures{
+			Name: "query",
+			Hasher: &feature.NGramHasher{
+				MaxN:       2,
+				Buckets:    16,
+				Normalizer: &feature.SampleNormalizerL2{},
+			},
+			ItemText: itemText,
+		}), ShouldBeNil)
+		defer UnregisterFeatureFunc("query")
+		f := queryFunc("query")
+		So(f.Width, ShouldEqual, 1+16+queryMatchWidth)
+		So(f.Version, ShouldNotBeEmpty)
+
+		ctx := WithQuery(context.Background(), "star wars")
+		// the feeds of no query
+		So(f.fn(context.Background(), 1, 0), ShouldResemble, make([]float64, f.Width))
+
+		exact := f.fn(ctx, 1, 0)
+		So(exact[0], ShouldEqual, 1)
+		So(exact[1:17], ShouldResemble, (&feature.NGramHasher{MaxN: 2, Buckets: 16,
+			Normalizer: &feature.SampleNormalizerL2{}}).Transform("star wars"))
+		match := exact[17:]
+		So(match[0], ShouldEqual, 1)
+		So(match[1], ShouldEqual, 1)
+		So(match[2], ShouldEqual, 1)
+		So(match[3], ShouldAlmostEqual, 1, 1e-9)
+
+		// the phrase in a longer title
+		partial := f.fn(ctx, 1, 1)[17:]
+		So(partial[0], ShouldEqual, 1)
+		So(partial[1], ShouldEqual, 2./8)
+		So(partial[2], ShouldEqual, 1)
+		So(partial[3], ShouldBeBetween, 0, 1)
+
+		// the words not in the order of the title
+		reversed := f.fn(WithQuery(context.Background(), "wars star"), 1, 1)[17:]
+		So(reversed[0], ShouldEqual, 1)
+		So(reversed[2], ShouldEqual, 0)
+
+		other := f.fn(WithQuery(context.Background(), "star trek"), 1, 2)[17:]
+		So(other[:3], ShouldResemble, []float64{0, 0, 0})
+
+		// the query features of the item of no text
+		unknown := f.fn(ctx, 1, 3)
+		So(unknown[0], ShouldEqual, 1)
+		So(unknown[17:], ShouldResemble, make([]float64, queryMatchWidth))
+	})
+
+	Convey("embedded query", t, func() {
+		So(RegisterQueryFeatures(QueryFeatures{
+			Name: "query",
+			Embed: func(_ context.Context, query string) ([]float32, error) {
+				if query == "bad" {
+					return []float32{1}, nil
+				}
+				return []float32{0.6, 0.8}, nil
+			},
+			Dim: 2,
+		}), ShouldBeNil)
+		defer UnregisterFeatureFunc("query")
+		f := queryFunc("query")
+		So(f.Width, ShouldEqual, 3)
+		So(f.fn(WithQuery(context.Background(), "star wars"), 1, 0), ShouldResemble, []float64{1, float64(float32(0.6)), float64(float32(0.8))})
+		So(f.fn(WithQuery(context.Background(), "bad"), 1, 0), ShouldResemble, []float64{1, 0, 0})
+	})
+
+	Convey("query features of training and serving", t, func() {
+		So(RegisterQueryFeatures(QueryFeatures{Name: "query", ItemText: itemText}), ShouldBeNil)
+		defer UnregisterFeatureFunc("query")
+		width := 1 + DefaultQueryBuckets + queryMatchWidth
+
+		fitter := &sampleFitter{}
+		m, err := Train(context.Background(), &lineageRecSys{}, fitter)
+		So(err, ShouldBeNil)
+		sample := fitter.sample
+		So(sample.Info.CtxFeatureRange[1]-sample.Info.CtxFeatureRange[0], ShouldEqual, 1+width)
+		// the feeds samples of no query
+		row := sample.X[:sample.XCols]
+		So(row[sample.XCols-width:], ShouldResemble, make([]float32, width))
+
+		result, err := DebugFeature(context.Background(), m, Sample{UserId: 1, ItemId: 0, Query: "Star Wars"})
+		So(err, ShouldBeNil)
+		columns := result.Columns[len(result.Columns)-width:]
+		So(columns[0], ShouldResemble, FeatureColumn{Name: "query_0", Value: 1})
+		So(columns[width-4].Value, ShouldEqual, 1)
+		So(columns[width-2].Value, ShouldEqual, 1)
+
+		// the query of the request ctx
+		result, err = DebugFeature(WithQuery(context.Background(), "toy story"), m, Sample{UserId: 1, ItemId: 2})
+		So(err, ShouldBeNil)
+		So(result.Columns[len(result.Columns)-width].Value, ShouldEqual, 1)
+		So(result.Columns[len(result.Columns)-2].Value, ShouldEqual, 1)
+	})
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/auxten/go-ctr/feature/embedding"
 	"github.com/auxten/go-ctr/feature/embedding/model"
 	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
-	"github.com/auxten/go-ctr/utils"
+	"github.com/auxten/go-ctr/recommend/encoding"
 	"github.com/karlseguin/ccache/v2"
 	log "github.com/sirupsen/logrus"
 	"gorgonia.org/tensor"
@@ -19,9 +19,9 @@ import (
 const (
 	SampleAssembler       = 16
 	StageKey              = "stage"
-	ItemEmbDim            = 16
+	ItemEmbDim            = encoding.ItemEmbDim
 	ItemEmbWindow         = 5
-	UserBehaviorLen       = 10
+	UserBehaviorLen       = encoding.UserBehaviorLen
 	userFeatureCacheSize  = 200000
 	itemFeatureCacheSize  = 2000000
 	userBehaviorCacheSize = userFeatureCacheSize * UserBehaviorLen
@@ -58,15 +58,28 @@ type TrainSample struct {
 	Y     []float32
 	Rows  int
 	XCols int
+	// MinTimestamp and MaxTimestamp are the time range of the samples
+	MinTimestamp int64
+	MaxTimestamp int64
+	// Dropped is the samples dropped by the SampleOptions
+	Dropped DroppedSamples
+	// Sparse is the CSR sample vectors of GetSparseSample, X is nil then
+	Sparse *SparseSample
+	// FeatureFuncs of the custom features at the end of the ctx feature
+	FeatureFuncs []FeatureFuncInfo
+	// Biases maps the ids to the rows of SampleInfo.IdRange of the bias
+	// tables of the model, nil of no SampleOptions.Biases
+	Biases *BiasBuckets
 
 	Info SampleInfo
 }
 
 type sampleVec struct {
-	vec    []float32
-	label  float32
-	iWidth int
-	uWidth int
+	vec       []float32
+	label     float32
+	timestamp int64
+	iWidth    int
+	uWidth    int
 }
 
 type RecSys interface {
@@ -129,11 +142,16 @@ type ItemEmbedding interface {
 	ItemSeqGenerator(context.Context) (<-chan string, error)
 }
 
+// SampleInfo is the layout of the sample vector, use SampleInfoBuilder to
+// build one from the feature widths.
 type SampleInfo struct {
-	UserProfileRange  [2]int // [start, end)
-	UserBehaviorRange [2]int // [start, end)
-	ItemFeatureRange  [2]int // [start, end)
-	CtxFeatureRange   [2]int // [start, end)
+	UserProfileRange  [2]int `json:"userProfileRange"`  // [start, end)
+	UserBehaviorRange [2]int `json:"userBehaviorRange"` // [start, end)
+	ItemFeatureRange  [2]int `json:"itemFeatureRange"`  // [start, end)
+	CtxFeatureRange   [2]int `json:"ctxFeatureRange"`   // [start, end)
+	// IdRange is the columns of the bias table rows of the user and item,
+	// see SampleOptions.Biases, empty if none
+	IdRange [2]int `json:"idRange"` // [start, end)
 }
 
 type UserItemOverview struct {
@@ -177,6 +195,27 @@ type PreRanker interface {
 	PreRank(context.Context) error
 }
 
+// ReRanker is called after rank with the scores, it can be used to adjust
+// or filter the items by business rules, see package rules.
+type ReRanker interface {
+	ReRank(ctx context.Context, userId int, itemScores []ItemScore) ([]ItemScore, error)
+}
+
+// SampleScorer is implemented by the Predictor scoring the samples itself
+// instead of by Predict of the assembled vectors, e.g. an ensemble of the
+// models of different feature layouts. BatchPredict and so Rank score by it,
+// RankWithBreakdown and RankWithBudget fall back to Rank.
+type SampleScorer interface {
+	ScoreSamples(ctx context.Context, sampleKeys []Sample) ([]float32, error)
+}
+
+// UserRouter is implemented by the Predictor serving several model versions,
+// e.g. the canary rollout. The requests of a user are routed to the Predictor
+// of the arm assigned to the user, the arm is returned in RecApiResponse.Arm.
+type UserRouter interface {
+	RouteUser(ctx context.Context, userId int) (predictor Predictor, arm string)
+}
+
 type PreTrainer interface {
 	PreTrain(context.Context) error
 }
@@ -184,6 +223,12 @@ type PreTrainer interface {
 type ItemScore struct {
 	ItemId int     `json:"itemId"`
 	Score  float32 `json:"score"`
+	// Contributions is only set by RankWithBreakdown
+	Contributions *Contributions `json:"contributions,omitempty"`
+	// Metadata is only set by the http api WithItemMetadata
+	Metadata *ItemMetadata `json:"metadata,omitempty"`
+	// Explanations is only set by the http api WithExplanations
+	Explanations []Explanation `json:"explanations,omitempty"`
 }
 
 type Sample struct {
@@ -191,10 +236,94 @@ type Sample struct {
 	ItemId    int     `json:"itemId"`
 	Label     float32 `json:"label"`
 	Timestamp int64   `json:"timestamp"`
+	// Query is the search query of the sample, empty of the feeds, see
+	// WithQuery
+	Query string `json:"query,omitempty"`
+}
+
+// trainedModel is the Predictor returned by Train
+type trainedModel struct {
+	UserFeaturer
+	ItemFeaturer
+	PredictAbstract
+	manifest *Manifest
+	// itemEmbeddingMap is of the ItemEmbedding RecSys
+	itemEmbeddingMap word2vec.EmbeddingMap32
+}
+
+func (m *trainedModel) Manifest() *Manifest {
+	return m.manifest
+}
+
+// Predict is the Predict of the model calibrated by the Correction of the
+// Manifest
+func (m *trainedModel) Predict(X tensor.Tensor) tensor.Tensor {
+	y := m.PredictAbstract.Predict(X)
+	if m.manifest == nil {
+		return y
+	}
+	return m.manifest.Correction.calibrate(y)
+}
+
+// ItemEmbeddings implements ItemEmbeddingsProvider
+func (m *trainedModel) ItemEmbeddings() word2vec.EmbeddingMap32 {
+	return m.itemEmbeddingMap
+}
+
+// behaviorModel is the trainedModel of the RecSys of UserBehavior, so the
+// serving vectors get the user behaviors the same as the training samples
+type behaviorModel struct {
+	*trainedModel
+	UserBehavior
+}
+
+// attentionModel is the behaviorModel of the BehaviorAttention model
+type attentionModel struct {
+	*behaviorModel
+	attention BehaviorAttention
+}
+
+// AttentionWeights implements AttentionWeighter by the BehaviorAttention of
+// the serving sample vector of userId and itemId with the user behaviors of
+// itemSeq. The items without embedding weigh 0.
+func (m *attentionModel) AttentionWeights(ctx context.Context, userId int, itemSeq []int, itemId int) ([]float32, error) {
+	sampleKey := &Sample{UserId: userId, ItemId: itemId}
+	assembler := servingAssembler(ctx, m)
+	record, err := assembler.Record(ctx, sampleKey)
+	if err != nil {
+		return nil, fmt.Errorf("get sample record error: %v", err)
+	}
+	embedded := make([]bool, len(itemSeq))
+	record.UserBehaviors = encoding.BehaviorTensor(itemSeq, func(itemId int) ([]float32, bool) {
+		return assembler.itemEmbeddingMap.Get(strconv.Itoa(itemId))
+	})
+	for i, id := range itemSeq {
+		_, embedded[i] = assembler.itemEmbeddingMap.Get(strconv.Itoa(id))
+	}
+	vec, _, _, err := assembler.vector(sampleKey, record)
+	if err != nil {
+		return nil, fmt.Errorf("get sample vector error: %v", err)
+	}
+	y, err := m.attention.BehaviorAttention(tensor.NewDense(tensor.Float32, tensor.Shape{1, len(vec)}, tensor.WithBacking(vec)))
+	if err != nil {
+		return nil, err
+	}
+	attention, ok := y.Data().([]float32)
+	if !ok {
+		return nil, fmt.Errorf("unexpected attention of shape %v", y.Shape())
+	}
+	weights := make([]float32, len(itemSeq))
+	for i := range itemSeq {
+		if i < len(attention) && embedded[i] {
+			weights[i] = attention[i]
+		}
+	}
+	return weights, nil
 }
 
 func Train(ctx context.Context, recSys RecSys, mlp Fitter) (model Predictor, err error) {
 	ctx = context.WithValue(ctx, StageKey, TrainStage)
+	startedAt := time.Now()
 
 	if preTrain, ok := recSys.(PreTrainer); ok {
 		err = preTrain.PreTrain(ctx)
@@ -204,42 +333,81 @@ func Train(ctx context.Context, recSys RecSys, mlp Fitter) (model Predictor, err
 		}
 	}
 
+	var embMap word2vec.EmbeddingMap32
 	if itemEbd, ok := recSys.(ItemEmbedding); ok {
 		itemEmbeddingModel, err = GetItemEmbeddingModelFromUb(ctx, itemEbd)
 		if err != nil {
 			log.Errorf("get item embedding model error: %v", err)
 			return
 		}
-		itemEmbeddingMap, err = itemEmbeddingModel.GenEmbeddingMap32()
+		embMap, err = itemEmbeddingModel.GenEmbeddingMap32()
 		if err != nil {
 			log.Errorf("get item embedding map error: %v", err)
 			return
 		}
+		embeddingMu.Lock()
+		itemEmbeddingMap = embMap
+		embeddingMu.Unlock()
 	}
 
-	trainSample, err := GetSample(recSys, ctx)
+	sparseFitter, sparse := mlp.(SparseFitter)
+	trainSample, err := getSample(recSys, ctx, sparse)
 	if err != nil {
 		log.Errorf("get train sample error: %v", err)
 		return
 	}
+	manifest, err := newManifest(ctx, recSys, startedAt, trainSample)
+	if err != nil {
+		log.Errorf("get data lineage error: %v", err)
+		return
+	}
+	if reporter, ok := recSys.(SamplingReporter); ok {
+		manifest.Correction = reporter.SamplingCorrection()
+	}
+	if manifest.Quality, err = checkSampleQuality(recSys, trainSample); err != nil {
+		log.Errorf("check data quality error: %v", err)
+		return
+	}
+	if optioner, ok := recSys.(SampleOptioner); ok && optioner.SampleOptions().Normalize != "" {
+		if manifest.Normalizer, err = FitNormalizer(trainSample, optioner.SampleOptions().Normalize); err != nil {
+			log.Errorf("fit normalizer error: %v", err)
+			return
+		}
+		if err = manifest.Normalizer.ApplySample(trainSample); err != nil {
+			log.Errorf("normalize error: %v", err)
+			return
+		}
+	}
 
 	// start training
 	log.Infof("\nstart training with %d x %d samples\n", trainSample.Rows, trainSample.XCols)
 
-	pred, err := mlp.Fit(trainSample)
+	var pred PredictAbstract
+	if sparse {
+		log.Infof("sparse samples of %d non-zeros", trainSample.Sparse.Nnz())
+		pred, err = sparseFitter.FitSparse(trainSample)
+	} else {
+		pred, err = mlp.Fit(trainSample)
+	}
 	if err != nil {
 		log.Errorf("fit error: %v", err)
 		return
 	}
-	type modelImpl struct {
-		UserFeaturer
-		ItemFeaturer
-		PredictAbstract
+	manifest.FinishedAt = time.Now()
+	trained := &trainedModel{
+		UserFeaturer:     recSys,
+		ItemFeaturer:     recSys,
+		PredictAbstract:  pred,
+		manifest:         manifest,
+		itemEmbeddingMap: embMap,
 	}
-	model = &modelImpl{
-		UserFeaturer:    recSys,
-		ItemFeaturer:    recSys,
-		PredictAbstract: pred,
+	model = trained
+	if ub, ok := recSys.(UserBehavior); ok {
+		behavior := &behaviorModel{trainedModel: trained, UserBehavior: ub}
+		model = behavior
+		if attention, ok := pred.(BehaviorAttention); ok {
+			model = &attentionModel{behaviorModel: behavior, attention: attention}
+		}
 	}
 
 	return
@@ -271,7 +439,57 @@ func Rank(ctx context.Context, recSys Predictor, userId int, itemIds []int) (ite
 		}
 	}
 
-	return
+	return reRank(ctx, recSys, userId, itemScores)
+}
+
+func reRank(ctx context.Context, recSys Predictor, userId int, itemScores []ItemScore) ([]ItemScore, error) {
+	if reRanker, ok := recSys.(ReRanker); ok {
+		return reRanker.ReRank(ctx, userId, itemScores)
+	}
+	return itemScores, nil
+}
+
+// RankWithBreakdown is Rank with the contributions of the feature groups to
+// every score, see Breakdown.
+func RankWithBreakdown(ctx context.Context, recSys Predictor, userId int, itemIds []int) (itemScores []ItemScore, err error) {
+	if _, ok := recSys.(SampleScorer); ok {
+		return Rank(ctx, recSys, userId, itemIds)
+	}
+	sampleKeys := make([]Sample, len(itemIds))
+	for i, itemId := range itemIds {
+		sampleKeys[i] = Sample{
+			UserId:    userId,
+			ItemId:    itemId,
+			Timestamp: time.Now().Unix(),
+		}
+	}
+	scores, contributions, err := Breakdown(ctx, recSys, sampleKeys)
+	if err != nil {
+		return
+	}
+	itemScores = make([]ItemScore, len(itemIds))
+	for i, itemId := range itemIds {
+		itemScores[i] = ItemScore{
+			ItemId:        itemId,
+			Score:         scores[i],
+			Contributions: &contributions[i],
+		}
+	}
+	return reRank(ctx, recSys, userId, itemScores)
+}
+
+func scoreSamples(ctx context.Context, scorer SampleScorer, sampleKeys []Sample) (y tensor.Tensor, err error) {
+	scores, err := scorer.ScoreSamples(ctx, sampleKeys)
+	if err != nil {
+		return
+	}
+	if len(scores) != len(sampleKeys) {
+		return nil, fmt.Errorf("scores %d != samples %d", len(scores), len(sampleKeys))
+	}
+	if len(scores) == 0 {
+		return
+	}
+	return tensor.New(tensor.WithShape(len(scores), 1), tensor.WithBacking(scores)), nil
 }
 
 func BatchPredict(ctx context.Context, recSys Predictor, sampleKeys []Sample) (y tensor.Tensor, err error) {
@@ -283,19 +501,23 @@ func BatchPredict(ctx context.Context, recSys Predictor, sampleKeys []Sample) (y
 			return
 		}
 	}
+	if scorer, ok := recSys.(SampleScorer); ok {
+		return scoreSamples(ctx, scorer, sampleKeys)
+	}
 
 	var (
 		xData      []float32
 		xWidth     int
 		zeroSliceX []float32
 		debugIds   = make([]int, 0)
+		assembler  = servingAssembler(ctx, recSys)
 	)
 
 	for i, sKey := range sampleKeys {
 		var (
 			xSlice []float32
 		)
-		xSlice, _, _, err = GetSampleVector(ctx, UserFeatureCache, ItemFeatureCache, recSys, &sKey)
+		xSlice, _, _, err = assembler.Assemble(ctx, &sKey)
 		if err != nil {
 			if i == 0 {
 				log.Errorf("get sample vector error: %v", err)
@@ -324,7 +546,10 @@ func BatchPredict(ctx context.Context, recSys Predictor, sampleKeys []Sample) (y
 	}
 	xDense := tensor.NewDense(tensor.Float32, tensor.Shape{len(sampleKeys), xWidth}, tensor.WithBacking(xData))
 
-	y = recSys.Predict(xDense)
+	if y = recSys.Predict(xDense); y == nil {
+		err = fmt.Errorf("predict failed")
+		return
+	}
 	for _, i := range debugIds {
 		score, er := y.At(i, 0)
 		if er != nil {
@@ -337,6 +562,11 @@ func BatchPredict(ctx context.Context, recSys Predictor, sampleKeys []Sample) (y
 }
 
 func GetSample(recSys RecSys, ctx context.Context) (sample *TrainSample, err error) {
+	return getSample(recSys, ctx, false)
+}
+
+// getSample assembles the samples of recSys into X, or into Sparse if sparse
+func getSample(recSys RecSys, ctx context.Context, sparse bool) (sample *TrainSample, err error) {
 	var (
 		userFeatureWidth int
 		itemFeatureWidth int
@@ -366,11 +596,36 @@ func GetSample(recSys RecSys, ctx context.Context) (sample *TrainSample, err err
 	if err != nil {
 		panic(err)
 	}
+	var opts SampleOptions
+	if optioner, ok := recSys.(SampleOptioner); ok {
+		opts = optioner.SampleOptions()
+	}
+	if ts, ok := ctx.Value(trainUntilKey{}).(int64); ok {
+		opts.TrainUntil = ts
+	}
+	sample = &TrainSample{}
+	sampleCh = guardSamples(ctx, sampleCh, opts, &sample.Dropped)
 
 	var (
 		sampleVecCh = make(chan *sampleVec, 1000)
 		sampleVecWg sync.WaitGroup
+		assembler   = NewFeatureAssembler(recSys, UserFeatureCache, ItemFeatureCache)
 	)
+	assembler.pointInTime = opts.PointInTime
+	assembler.asOf = true
+	sample.FeatureFuncs = featureFuncInfos(assembler.featureFuncs)
+	if opts.Biases != nil {
+		if sparse {
+			return nil, fmt.Errorf("biases of the sparse samples not supported")
+		}
+		if sample.Biases, err = newBiasBuckets(*opts.Biases); err != nil {
+			return nil, err
+		}
+		assembler.biases = sample.Biases
+		defer func() {
+			sample.Biases.seal()
+		}()
+	}
 
 	for c := 0; c < SampleAssembler; c++ {
 		sampleVecWg.Add(1)
@@ -380,12 +635,13 @@ func GetSample(recSys RecSys, ctx context.Context) (sample *TrainSample, err err
 					err  error
 					sVec sampleVec
 				)
-				sVec.vec, sVec.uWidth, sVec.iWidth, err = GetSampleVector(ctx, UserFeatureCache, ItemFeatureCache, recSys, &s)
+				sVec.vec, sVec.uWidth, sVec.iWidth, err = assembler.Assemble(ctx, &s)
 				if err != nil {
 					log.Debugf("get sample vector error: %v", err)
 					continue
 				}
 				sVec.label = s.Label
+				sVec.timestamp = s.Timestamp
 				sampleVecCh <- &sVec
 			}
 			sampleVecWg.Done()
@@ -396,17 +652,15 @@ func GetSample(recSys RecSys, ctx context.Context) (sample *TrainSample, err err
 		close(sampleVecCh)
 	}()
 
-	sample = &TrainSample{}
 	for sv := range sampleVecCh {
 		if userFeatureWidth == 0 {
 			userFeatureWidth = sv.uWidth
-			sample.Info.UserProfileRange[0] = 0
-			sample.Info.UserProfileRange[1] = userFeatureWidth
-			sample.Info.UserBehaviorRange[0] = sample.Info.UserProfileRange[1]
-			sample.Info.UserBehaviorRange[1] = sample.Info.UserProfileRange[1] + ItemEmbDim*UserBehaviorLen
-			// item feature here is only embeddings
-			sample.Info.ItemFeatureRange[0] = sample.Info.UserBehaviorRange[1]
-			sample.Info.ItemFeatureRange[1] = sample.Info.UserBehaviorRange[1] + ItemEmbDim
+			itemFeatureWidth = sv.iWidth
+			var info *SampleInfo
+			if info, err = assembler.SampleInfo(userFeatureWidth, itemFeatureWidth); err != nil {
+				return
+			}
+			sample.Info = *info
 		}
 		if sv.uWidth != userFeatureWidth {
 			err = fmt.Errorf("user feature length mismatch: %v:%v",
@@ -414,12 +668,6 @@ func GetSample(recSys RecSys, ctx context.Context) (sample *TrainSample, err err
 			return
 		}
 
-		if itemFeatureWidth == 0 {
-			itemFeatureWidth = sv.iWidth
-			// non embedding item feature is treated as ctx feature
-			sample.Info.CtxFeatureRange[0] = sample.Info.ItemFeatureRange[1]
-			sample.Info.CtxFeatureRange[1] = sample.Info.ItemFeatureRange[1] + itemFeatureWidth
-		}
 		if sv.iWidth != itemFeatureWidth {
 			err = fmt.Errorf("item feature length mismatch: %v:%v",
 				itemFeatureWidth, sv.iWidth)
@@ -435,7 +683,23 @@ func GetSample(recSys RecSys, ctx context.Context) (sample *TrainSample, err err
 			}
 		}
 
-		sample.X = append(sample.X, sv.vec...)
+		if sample.Rows == 0 || sv.timestamp < sample.MinTimestamp {
+			sample.MinTimestamp = sv.timestamp
+		}
+		if sample.Rows == 0 || sv.timestamp > sample.MaxTimestamp {
+			sample.MaxTimestamp = sv.timestamp
+		}
+		if sparse {
+			if sample.Sparse == nil {
+				sample.Sparse = NewSparseSample(sample.XCols)
+			}
+			if err = sample.Sparse.AppendRow(sv.vec); err != nil {
+				err = fmt.Errorf("sparse sample row %d: %v", sample.Rows, err)
+				return
+			}
+		} else {
+			sample.X = append(sample.X, sv.vec...)
+		}
 		sample.Y = append(sample.Y, sv.label)
 		sample.Rows++
 		if sample.Rows%1000 == 0 {
@@ -446,11 +710,25 @@ func GetSample(recSys RecSys, ctx context.Context) (sample *TrainSample, err err
 		}
 	}
 
+	if sample.Dropped.Duplicated != 0 || sample.Dropped.AfterBoundary != 0 {
+		log.Infof("dropped %d duplicated samples, %d samples after %d",
+			sample.Dropped.Duplicated, sample.Dropped.AfterBoundary, opts.TrainUntil)
+	}
+
 	//check x and y dimension
 	if sample.Rows != len(sample.Y) {
 		err = fmt.Errorf("sample rows not match: %v:%v", sample.Rows, len(sample.Y))
 		return
 	}
+	if sparse {
+		if sample.Sparse == nil {
+			sample.Sparse = NewSparseSample(sample.XCols)
+		}
+		if sample.Sparse.Rows() != sample.Rows {
+			err = fmt.Errorf("sparse sample rows not match: %v:%v", sample.Rows, sample.Sparse.Rows())
+		}
+		return
+	}
 	if sample.Rows*sample.XCols != len(sample.X) {
 		err = fmt.Errorf("sample x size not match: %v:%v", sample.Rows*sample.XCols, len(sample.X))
 		return
@@ -459,80 +737,12 @@ func GetSample(recSys RecSys, ctx context.Context) (sample *TrainSample, err err
 	return
 }
 
+// GetSampleVector assembles the sample vector of sampleKey, see FeatureAssembler
 func GetSampleVector(ctx context.Context,
 	userFeatureCache *ccache.Cache, itemFeatureCache *ccache.Cache,
 	featureProvider BasicFeatureProvider, sampleKey *Sample,
 ) (vec []float32, userFeatureWidth int, itemFeatureWidth int, err error) {
-	var (
-		zeroItemEmb       [ItemEmbDim]float32
-		zeroUserBehaviors [ItemEmbDim * UserBehaviorLen]float32
-
-		user, item *ccache.Item
-	)
-	userIdStr := strconv.Itoa(sampleKey.UserId)
-	user, err = userFeatureCache.Fetch(userIdStr, time.Hour*24, func() (ci interface{}, err error) {
-		ci, err = featureProvider.GetUserFeature(ctx, sampleKey.UserId)
-		return
-	})
-	if err != nil {
-		return
-	}
-	userFeature := user.Value().(Tensor)
-	userFeatureWidth = len(userFeature)
-
-	itemIdStr := strconv.Itoa(sampleKey.ItemId)
-	item, err = itemFeatureCache.Fetch(itemIdStr, time.Hour*24, func() (ci interface{}, err error) {
-		ci, err = featureProvider.GetItemFeature(ctx, sampleKey.ItemId)
-		return
-	})
-	if err != nil {
-		return
-	}
-	itemFeature := item.Value().(Tensor)
-	itemFeatureWidth = len(itemFeature)
-
-	// if ItemEmbedding interface is implemented, use item embedding,
-	// 	else use zero embedding.
-	var (
-		itemEmb       = zeroItemEmb[:]
-		userBehaviors = zeroUserBehaviors[:]
-		ok            bool
-	)
-	if len(itemEmbeddingMap) != 0 {
-		if itemEmb, ok = itemEmbeddingMap.Get(strconv.Itoa(sampleKey.ItemId)); !ok {
-			itemEmb = zeroItemEmb[:]
-			log.Debugf("item embedding not found: %d, using zeros", sampleKey.ItemId)
-		}
-		// if ItemEmbedding and UserBehavior interface are both implemented,
-		// use itemSeq embeddings got from GetUserBehavior as user behavior,
-		//	else use zero embedding.
-		if recSysUb, ok := featureProvider.(UserBehavior); ok {
-			getUbfunc := func(userId int, maxLen int64, maxPk int64, maxTs int64) (ubTensor Tensor, err error) {
-				itemSeq, err := recSysUb.GetUserBehavior(
-					ctx, userId, maxLen, maxPk, maxTs)
-				if err != nil {
-					return
-				}
-				//query items embedding, fill them into user behavior
-				ubTensor = make(Tensor, ItemEmbDim*UserBehaviorLen)
-				for i, itemId := range itemSeq {
-					if itemEmb, ok := itemEmbeddingMap.Get(strconv.Itoa(itemId)); ok {
-						copy(ubTensor[i*ItemEmbDim:], itemEmb)
-					}
-				}
-				return
-			}
-			userBehaviors, err = getUbfunc(sampleKey.UserId, UserBehaviorLen, -1, sampleKey.Timestamp)
-			if err != nil {
-				err = fmt.Errorf("get user behavior error: %v", err)
-				return
-			}
-		}
-	}
-
-	vec = utils.ConcatSlice32(userFeature, userBehaviors, itemEmb, itemFeature)
-
-	return
+	return NewFeatureAssembler(featureProvider, userFeatureCache, itemFeatureCache).Assemble(ctx, sampleKey)
 }
 
 func GetItemEmbeddingModelFromUb(ctx context.Context, iSeq ItemEmbedding) (mod model.Model, err error) {
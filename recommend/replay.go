@@ -0,0 +1,372 @@
+package recommend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	mrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRecordSampleRate is the share of the requests recorded, the records
+// of the resolved features are large
+const DefaultRecordSampleRate = 0.01
+
+// ReplayRecord is a served request with the features resolved, replayed by
+// Replay against another model or config
+type ReplayRecord struct {
+	RequestId string    `json:"requestId"`
+	Time      time.Time `json:"time"`
+	Tenant    string    `json:"tenant,omitempty"`
+	// ModelVersion is of the Predictor if it's a ModelVersioner
+	ModelVersion string `json:"modelVersion,omitempty"`
+	// Request is the request of the headers merged, and ItemIdList of the
+	// candidates recalled or requested, so the replay recalls nothing. It's
+	// not paginated.
+	Request RecApiRequest `json:"request"`
+	// Features are the raw features of the samples scored
+	Features []ReplayFeature `json:"features"`
+	// Response is the ranking of all the pages
+	Response RecApiResponse `json:"response"`
+}
+
+// ReplayFeature is the raw features of a scored sample
+type ReplayFeature struct {
+	UserId    int            `json:"userId"`
+	ItemId    int            `json:"itemId"`
+	Timestamp int64          `json:"timestamp"`
+	Record    *FeatureRecord `json:"record"`
+}
+
+type sampleId struct {
+	userId, itemId int
+}
+
+// recordingTrail collects the features of a recorded request, the first
+// of a sample is kept, the samples could be assembled concurrently, e.g. of
+// the ensembles
+type recordingTrail struct {
+	mu       sync.Mutex
+	features []ReplayFeature
+	seen     map[sampleId]struct{}
+}
+
+type recordingTrailKey struct{}
+
+// recordFeatures records the features of sampleKey in the recording trail
+// of ctx, it's a no-op if the request is not recorded
+func recordFeatures(ctx context.Context, sampleKey *Sample, record *FeatureRecord) {
+	trail, ok := ctx.Value(recordingTrailKey{}).(*recordingTrail)
+	if !ok {
+		return
+	}
+	id := sampleId{userId: sampleKey.UserId, itemId: sampleKey.ItemId}
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+	if _, ok = trail.seen[id]; ok {
+		return
+	}
+	trail.seen[id] = struct{}{}
+	trail.features = append(trail.features, ReplayFeature{
+		UserId:    sampleKey.UserId,
+		ItemId:    sampleKey.ItemId,
+		Timestamp: sampleKey.Timestamp,
+		Record:    record,
+	})
+}
+
+func (t *recordingTrail) recorded() []ReplayFeature {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ReplayFeature(nil), t.features...)
+}
+
+// WithRequestRecording records the sampled requests with the features
+// resolved in r, to be replayed by Replay. The requests are not recorded in
+// the privacy mode.
+func WithRequestRecording(r *RequestRecorder) ApiOption {
+	return func(c *apiConfig) {
+		c.recorder = r
+	}
+}
+
+// RequestRecorder is the append only file of the ReplayRecord json lines
+type RequestRecorder struct {
+	sampleRate float64
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewRequestRecorder opens the record file of path, appended to if it
+// exists, and records sampleRate of the requests. Zero sampleRate means the
+// default.
+func NewRequestRecorder(path string, sampleRate float64) (r *RequestRecorder, err error) {
+	if sampleRate <= 0 {
+		sampleRate = DefaultRecordSampleRate
+	}
+	r = &RequestRecorder{sampleRate: sampleRate}
+	if r.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Append writes record to the file
+func (r *RequestRecorder) Append(record *ReplayRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.file.Write(line)
+	return err
+}
+
+func (r *RequestRecorder) Close() error {
+	return r.file.Close()
+}
+
+// ReadReplayRecords reads the ReplayRecord json lines of r, e.g. the file of
+// a RequestRecorder, the last line torn by the crash is skipped
+func ReadReplayRecords(r io.Reader) (records []ReplayRecord, err error) {
+	reader := bufio.NewReader(r)
+	for {
+		line, er := reader.ReadBytes('\n')
+		if er == io.EOF {
+			return
+		}
+		if er != nil {
+			return nil, er
+		}
+		var record ReplayRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// startRecording returns the ctx of the recording trail of the request if
+// sampled
+func (r *RequestRecorder) startRecording(ctx context.Context) (context.Context, *recordingTrail) {
+	if r == nil || (r.sampleRate < 1 && mrand.Float64() >= r.sampleRate) {
+		return ctx, nil
+	}
+	trail := &recordingTrail{seen: make(map[sampleId]struct{})}
+	return context.WithValue(ctx, recordingTrailKey{}, trail), trail
+}
+
+// record appends the replay record of the ranked resp of req served by
+// predict, the failure is logged and the request is still served
+func (r *RequestRecorder) record(ctx context.Context, trail *recordingTrail, req *RecApiRequest, rc *RequestContext,
+	predict Predictor, candidates []int, resp RecApiResponse) {
+	frozen := *req
+	frozen.ItemIdList = candidates
+	frozen.PageSize, frozen.Cursor = 0, ""
+	if rc != nil {
+		frozen.SessionId, frozen.DeviceClass, frozen.Locale, frozen.ArmOverrides =
+			rc.SessionId, rc.DeviceClass, rc.Locale, rc.ArmOverrides
+	}
+	record := &ReplayRecord{
+		RequestId: resp.RequestId,
+		Time:      time.Now(),
+		Tenant:    tenantName(ctx),
+		Request:   frozen,
+		Features:  trail.recorded(),
+		Response:  resp,
+	}
+	if versioner, ok := predict.(ModelVersioner); ok {
+		record.ModelVersion = versioner.ModelVersion()
+	}
+	if err := r.Append(record); err != nil {
+		log.Errorf("append replay record %s error: %v", record.RequestId, err)
+	}
+}
+
+// replayedFeatures are the recorded features of the replayed request, the
+// samples not recorded are fetched and counted as missed
+type replayedFeatures struct {
+	records map[sampleId]*FeatureRecord
+	missed  int64
+}
+
+type replayedFeaturesKey struct{}
+
+// replayedRecord returns the recorded features of sampleKey of the replayed
+// request of ctx, nil if not replayed or not recorded
+func replayedRecord(ctx context.Context, sampleKey *Sample) *FeatureRecord {
+	replayed, ok := ctx.Value(replayedFeaturesKey{}).(*replayedFeatures)
+	if !ok {
+		return nil
+	}
+	record, ok := replayed.records[sampleId{userId: sampleKey.UserId, itemId: sampleKey.ItemId}]
+	if !ok {
+		atomic.AddInt64(&replayed.missed, 1)
+		return nil
+	}
+	// the record is not changed by the assembler, the vector is a new slice
+	return record
+}
+
+// ReplayDiff is the difference of the replayed ranking of a record from the
+// recorded one, both ordered by sortItemScores
+type ReplayDiff struct {
+	RequestId string `json:"requestId"`
+	// Error is of the failed replay
+	Error    string `json:"error,omitempty"`
+	Recorded []int  `json:"recorded"`
+	Replayed []int  `json:"replayed"`
+	// Added and Removed are the items of Replayed not in Recorded and
+	// the reverse
+	Added   []int `json:"added,omitempty"`
+	Removed []int `json:"removed,omitempty"`
+	// Reordered is true if the items of both are ordered differently
+	Reordered bool `json:"reordered,omitempty"`
+	// MaxScoreDelta is the max absolute score change of the items of both
+	MaxScoreDelta    float64 `json:"maxScoreDelta"`
+	RecordedFallback string  `json:"recordedFallback,omitempty"`
+	ReplayedFallback string  `json:"replayedFallback,omitempty"`
+	// FeaturesMissed is the samples scored of no recorded features, fetched
+	// live so not deterministic, e.g. of the candidates filtered differently
+	FeaturesMissed int `json:"featuresMissed,omitempty"`
+}
+
+// Changed tells whether the replayed ranking differs from the recorded one
+// of the scores changed over tolerance
+func (d *ReplayDiff) Changed(tolerance float64) bool {
+	return d.Error != "" || len(d.Added) != 0 || len(d.Removed) != 0 || d.Reordered ||
+		d.MaxScoreDelta > tolerance || d.RecordedFallback != d.ReplayedFallback
+}
+
+// ReplayReport is the diffs of the replayed records
+type ReplayReport struct {
+	Requests int `json:"requests"`
+	// Changed is the requests ReplayDiff.Changed of Tolerance, including
+	// the Errors
+	Changed       int          `json:"changed"`
+	Errors        int          `json:"errors"`
+	Tolerance     float64      `json:"tolerance"`
+	MaxScoreDelta float64      `json:"maxScoreDelta"`
+	Diffs         []ReplayDiff `json:"diffs"`
+}
+
+// Replay replays the records through the recommendation api of predict and
+// opts, e.g. the model or the rerank config to be deployed, offline and
+// diffs the rankings with the recorded ones. The samples are scored of the
+// recorded features, so the diffs are of the model and config only, except
+// the ones of a SampleScorer which fetches its own. The score changes within
+// tolerance are not counted as changed.
+func Replay(ctx context.Context, predict Predictor, records []ReplayRecord, tolerance float64, opts ...ApiOption) *ReplayReport {
+	var (
+		conf   = newApiConfig(opts)
+		engine = gin.New()
+		report = &ReplayReport{Requests: len(records), Tolerance: tolerance, Diffs: make([]ReplayDiff, len(records))}
+	)
+	// the handler gets the replayed features of the request ctx
+	engine.ContextWithFallback = true
+	engine.POST("/replay", recommendHandler(single(predict), conf))
+	for i := range records {
+		d := replay(ctx, engine, &records[i])
+		if d.Error != "" {
+			report.Errors++
+		}
+		if d.Changed(tolerance) {
+			report.Changed++
+		}
+		report.MaxScoreDelta = math.Max(report.MaxScoreDelta, d.MaxScoreDelta)
+		report.Diffs[i] = d
+	}
+	return report
+}
+
+func replay(ctx context.Context, handler http.Handler, record *ReplayRecord) (d ReplayDiff) {
+	recorded := append([]ItemScore(nil), record.Response.ItemScoreList...)
+	sortItemScores(recorded)
+	d = ReplayDiff{
+		RequestId:        record.RequestId,
+		Recorded:         itemIdsOf(recorded),
+		RecordedFallback: record.Response.Fallback,
+	}
+	body, err := json.Marshal(&record.Request)
+	if err != nil {
+		d.Error = err.Error()
+		return
+	}
+	replayed := &replayedFeatures{records: make(map[sampleId]*FeatureRecord, len(record.Features))}
+	for _, f := range record.Features {
+		replayed.records[sampleId{userId: f.UserId, itemId: f.ItemId}] = f.Record
+	}
+	req := httptest.NewRequest(http.MethodPost, "/replay", bytes.NewReader(body)).
+		WithContext(context.WithValue(ctx, replayedFeaturesKey{}, replayed))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	d.FeaturesMissed = int(atomic.LoadInt64(&replayed.missed))
+	if w.Code != http.StatusOK {
+		d.Error = fmt.Sprintf("replay status %d: %s", w.Code, w.Body.String())
+		return
+	}
+	var resp RecApiResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		d.Error = err.Error()
+		return
+	}
+	sortItemScores(resp.ItemScoreList)
+	d.Replayed, d.ReplayedFallback = itemIdsOf(resp.ItemScoreList), resp.Fallback
+
+	recordedScores := make(map[int]float32, len(recorded))
+	for _, is := range recorded {
+		recordedScores[is.ItemId] = is.Score
+	}
+	var common []int
+	replayedIds := make(map[int]struct{}, len(resp.ItemScoreList))
+	for _, is := range resp.ItemScoreList {
+		replayedIds[is.ItemId] = struct{}{}
+		score, ok := recordedScores[is.ItemId]
+		if !ok {
+			d.Added = append(d.Added, is.ItemId)
+			continue
+		}
+		common = append(common, is.ItemId)
+		d.MaxScoreDelta = math.Max(d.MaxScoreDelta, math.Abs(float64(is.Score-score)))
+	}
+	var recordedCommon []int
+	for _, id := range d.Recorded {
+		if _, ok := replayedIds[id]; !ok {
+			d.Removed = append(d.Removed, id)
+		} else {
+			recordedCommon = append(recordedCommon, id)
+		}
+	}
+	for i := range common {
+		// the dup items of a ranking are not of both in the same order
+		if i >= len(recordedCommon) || common[i] != recordedCommon[i] {
+			d.Reordered = true
+			break
+		}
+	}
+	return
+}
+
+func itemIdsOf(itemScores []ItemScore) []int {
+	ids := make([]int, len(itemScores))
+	for i, is := range itemScores {
+		ids[i] = is.ItemId
+	}
+	return ids
+}
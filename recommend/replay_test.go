@@ -0,0 +1,105 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// driftedPredictor is the sumPredictor of the item features changed since
+// the requests recorded
+type driftedPredictor struct {
+	sumPredictor
+}
+
+func (p *driftedPredictor) GetItemFeature(_ context.Context, itemId int) (Tensor, error) {
+	return Tensor{float32(100 - 10*itemId)}, nil
+}
+
+// negatedPredictor is the model of the reversed scores
+type negatedPredictor struct {
+	sumPredictor
+}
+
+func (p *negatedPredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	y := p.sumPredictor.Predict(X)
+	for i, v := range y.Data().([]float32) {
+		y.Data().([]float32)[i] = -v
+	}
+	return y
+}
+
+func TestReplay(t *testing.T) {
+	Convey("record the requests and replay them", t, func() {
+		path := filepath.Join(t.TempDir(), "replay.jsonl")
+		recorder, err := NewRequestRecorder(path, 1)
+		So(err, ShouldBeNil)
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &sumPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend", WithRequestRecording(recorder), WithPagination(0, 0))
+		post := func(body string) (resp RecApiResponse) {
+			req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(DeviceClassHeader, "TV")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, 200)
+			So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+			return
+		}
+		first := post(`{"userId":1,"itemIdList":[1,2,3]}`)
+		So(first.RequestId, ShouldNotBeEmpty)
+		paged := post(`{"userId":2,"itemIdList":[3,4],"pageSize":1}`)
+		So(paged.ItemScoreList, ShouldHaveLength, 1)
+		So(recorder.Close(), ShouldBeNil)
+
+		f, err := os.Open(path)
+		So(err, ShouldBeNil)
+		defer f.Close()
+		records, err := ReadReplayRecords(f)
+		So(err, ShouldBeNil)
+		So(records, ShouldHaveLength, 2)
+		So(records[0].RequestId, ShouldEqual, first.RequestId)
+		So(records[0].Request.DeviceClass, ShouldEqual, DeviceClassTV)
+		So(records[0].Features, ShouldHaveLength, 3)
+		So(records[0].Features[0].Record.ItemFeature, ShouldResemble, Tensor{1})
+		So(records[0].Response.ItemScoreList, ShouldResemble, first.ItemScoreList)
+		// the full ranking of the paginated request, replayed unpaginated
+		So(records[1].Request.PageSize, ShouldEqual, 0)
+		So(records[1].Response.ItemScoreList, ShouldHaveLength, 2)
+
+		ctx := context.Background()
+		// the features are frozen, the drifted item features are not fetched
+		report := Replay(ctx, &driftedPredictor{}, records, 1e-6)
+		So(report.Requests, ShouldEqual, 2)
+		So(report.Changed, ShouldEqual, 0)
+		So(report.Diffs[0].Recorded, ShouldResemble, []int{3, 2, 1})
+		So(report.Diffs[0].Replayed, ShouldResemble, report.Diffs[0].Recorded)
+		So(report.Diffs[0].FeaturesMissed, ShouldEqual, 0)
+
+		// the new model reverses the rankings
+		report = Replay(ctx, &negatedPredictor{}, records, 1e-6)
+		So(report.Changed, ShouldEqual, 2)
+		So(report.Diffs[0].Replayed, ShouldResemble, []int{1, 2, 3})
+		So(report.Diffs[0].Reordered, ShouldBeTrue)
+		So(report.MaxScoreDelta, ShouldBeGreaterThan, 0)
+
+		// the new rerank blocks the item 2
+		report = Replay(ctx, &auditedPredictor{}, records[:1], 1e-6)
+		So(report.Diffs[0].Removed, ShouldResemble, []int{2})
+		So(report.Diffs[0].Reordered, ShouldBeFalse)
+		So(report.Diffs[0].Changed(1e-6), ShouldBeTrue)
+
+		// the samples of no recorded features are fetched live
+		records[0].Features = records[0].Features[:1]
+		report = Replay(ctx, &sumPredictor{}, records[:1], 1e-6)
+		So(report.Diffs[0].FeaturesMissed, ShouldEqual, 2)
+	})
+}
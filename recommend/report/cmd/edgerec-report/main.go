@@ -0,0 +1,103 @@
+// Command edgerec-report aggregates the impression and feedback logs of the
+// device into the daily metrics of every surface, and writes them to a
+// SQLite table, e.g. a Grafana data source, and or a CSV file. -sqlite is
+// not built in of the nosqlite build tag or CGO_ENABLED=0:
+//
+//	go run ./recommend/report/cmd/edgerec-report -audit audit.jsonl \
+//	  -events events.jsonl -catalog 3883 -sqlite report.db -csv report.csv
+//
+// The impressions are of the Impression json lines of -impressions or the
+// items served of the audit log of -audit, weighted by its sample rate, see
+// package report.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	"github.com/auxten/go-ctr/recommend/report"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	impressionsFlag = flag.String("impressions", "", "the Impression json lines file")
+	auditFlag       = flag.String("audit", "", "the audit log file of the impressions if -impressions is empty")
+	eventsFlag      = flag.String("events", "", "the Event json lines file of the clicks and feedback")
+	catalogFlag     = flag.Int("catalog", 0, "the items of the catalog of the coverage, no coverage if 0")
+	tzFlag          = flag.String("tz", "UTC", "the time zone of the days, e.g. Asia/Shanghai")
+	csvFlag         = flag.String("csv", "", "the csv file of the report")
+	sqliteFlag      = flag.String("sqlite", "", "the SQLite database file of the report")
+	tableFlag       = flag.String("table", "daily_report", "the table of the report in -sqlite")
+)
+
+func read(path string, fn func(io.Reader) error) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err = fn(f); err != nil {
+		log.Fatalf("read %s: %v", path, err)
+	}
+}
+
+func main() {
+	flag.Parse()
+	loc, err := time.LoadLocation(*tzFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var (
+		impressions []report.Impression
+		events      []report.Event
+	)
+	switch {
+	case *impressionsFlag != "":
+		read(*impressionsFlag, func(r io.Reader) (err error) {
+			impressions, err = report.ReadImpressions(r)
+			return
+		})
+	case *auditFlag != "":
+		read(*auditFlag, func(r io.Reader) (err error) {
+			impressions, err = report.ReadAuditImpressions(r)
+			return
+		})
+	default:
+		log.Fatal("no -impressions or -audit to report")
+	}
+	if *eventsFlag != "" {
+		read(*eventsFlag, func(r io.Reader) (err error) {
+			events, err = report.ReadEvents(r)
+			return
+		})
+	}
+
+	rows := report.Aggregate(impressions, events, report.Config{Location: loc, CatalogSize: *catalogFlag})
+	log.Infof("report of %d impressions and %d events: %d rows", len(impressions), len(events), len(rows))
+	if *csvFlag != "" {
+		f, err := os.Create(*csvFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = report.WriteCSV(f, rows); err != nil {
+			log.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *sqliteFlag != "" {
+		db, err := sql.Open("sqlite3", *sqliteFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		if err = report.WriteSQLite(context.Background(), db, *tableFlag, rows); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build cgo && !nosqlite
+
+package main
+
+import (
+	_ "github.com/mattn/go-sqlite3" //keep
+)
@@ -0,0 +1,446 @@
+// Package report is the daily business report of the served recommendations:
+// the impression and feedback logs are aggregated into the metrics of every
+// day and surface, the CTR, the catalog coverage, the top categories and the
+// fallback rate, written to a SQLite table or a CSV file for Grafana or a
+// spreadsheet. Only the aggregates leave the device, the raw logs are kept.
+package report
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+const (
+	// ActionClick is the Event of a click on an impression, the other
+	// actions are the rcmd.FeedbackAction
+	ActionClick = "click"
+	// DefaultTopCategories is the top categories of a Row
+	DefaultTopCategories = 5
+	// dayLayout is the format of Row.Day
+	dayLayout = "2006-01-02"
+)
+
+// Impression is an item served of a request
+type Impression struct {
+	RequestId string `json:"requestId,omitempty"`
+	Surface   string `json:"surface,omitempty"`
+	UserId    int    `json:"userId"`
+	ItemId    int    `json:"itemId"`
+	Position  int    `json:"position"`
+	Category  string `json:"category,omitempty"`
+	// Fallback is the RecApiResponse.Fallback of the request
+	Fallback string `json:"fallback,omitempty"`
+	// Timestamp is in unix seconds
+	Timestamp int64 `json:"timestamp"`
+	// Weight is the impressions the impression stands for, e.g. 1 /
+	// rcmd.AuditRecord.SampleRate of the sampled audit log. 0 is 1.
+	Weight float64 `json:"weight,omitempty"`
+}
+
+func (imp *Impression) weight() float64 {
+	if imp.Weight <= 0 {
+		return 1
+	}
+	return imp.Weight
+}
+
+// Event is a user action on an item served, ActionClick or a
+// rcmd.FeedbackAction. It's attributed to the impression of the same
+// RequestId and ItemId, or of the same UserId and ItemId of the impressions
+// of no RequestId. The clicks of no impression are dropped, as the
+// impressions they are of are not logged, e.g. not sampled. The feedback of
+// no impression is of the day and Surface of itself.
+type Event struct {
+	RequestId string `json:"requestId,omitempty"`
+	Surface   string `json:"surface,omitempty"`
+	UserId    int    `json:"userId"`
+	ItemId    int    `json:"itemId"`
+	Action    string `json:"action"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Config configures Aggregate, the zero values are the defaults
+type Config struct {
+	// Location of the days, UTC if nil
+	Location *time.Location
+	// CatalogSize is the items of the catalog, the Coverage is 0 if 0
+	CatalogSize int
+	// Category returns the category of the impressions of no Category if
+	// not nil, e.g. of the item metadata
+	Category func(itemId int) string
+	// TopCategories is DefaultTopCategories if 0
+	TopCategories int
+}
+
+// Row is the metrics of a day and surface
+type Row struct {
+	// Day is of the format 2006-01-02
+	Day     string `json:"day"`
+	Surface string `json:"surface"`
+	// Requests is the requests of any impression, Impressions and Clicks
+	// the impressions and the impressions clicked, all of the Impression
+	// weights, rounded
+	Requests    int     `json:"requests"`
+	Impressions int     `json:"impressions"`
+	Clicks      int     `json:"clicks"`
+	CTR         float64 `json:"ctr"`
+	// Items is the distinct items of the impressions, Coverage the share of
+	// the catalog
+	Items    int     `json:"items"`
+	Coverage float64 `json:"coverage"`
+	// FallbackRate is the share of the requests served by a fallback
+	FallbackRate float64 `json:"fallbackRate"`
+	// Likes and Negatives are the like and the dislike, hide or block
+	// category feedback
+	Likes     int `json:"likes"`
+	Negatives int `json:"negatives"`
+	// TopCategories is the most served categories and their shares of the
+	// impressions, e.g. "comedy:0.4,drama:0.25"
+	TopCategories string `json:"topCategories"`
+}
+
+// Columns are the columns of the report of the fields of Row
+var Columns = []string{"day", "surface", "requests", "impressions", "clicks", "ctr", "items", "coverage",
+	"fallback_rate", "likes", "negatives", "top_categories"}
+
+func (r *Row) values() []interface{} {
+	return []interface{}{r.Day, r.Surface, r.Requests, r.Impressions, r.Clicks, r.CTR, r.Items, r.Coverage,
+		r.FallbackRate, r.Likes, r.Negatives, r.TopCategories}
+}
+
+// group is the running aggregates of a day and surface, of the Impression
+// weights
+type group struct {
+	row         Row
+	impressions float64
+	clicks      float64
+	requests    map[string]*request
+	items       map[int]struct{}
+	categories  map[string]float64
+	clicked     map[impressionKey]struct{}
+}
+
+// request is the weight of a request and if it's served by a fallback
+type request struct {
+	weight   float64
+	fallback bool
+}
+
+type groupKey struct {
+	day, surface string
+}
+
+// impressionKey is the RequestId and ItemId of an impression, or the UserId
+// and ItemId of the impressions of no RequestId
+type impressionKey struct {
+	requestId string
+	userId    int
+	itemId    int
+}
+
+func newImpressionKey(requestId string, userId, itemId int) impressionKey {
+	if requestId != "" {
+		return impressionKey{requestId: requestId, itemId: itemId}
+	}
+	return impressionKey{userId: userId, itemId: itemId}
+}
+
+// servedImpression is the group and the weight of an impression
+type servedImpression struct {
+	key    groupKey
+	weight float64
+}
+
+// Aggregate returns the rows of every day and surface of the impressions
+// and events, ordered by day then surface
+func Aggregate(impressions []Impression, events []Event, conf Config) []Row {
+	if conf.Location == nil {
+		conf.Location = time.UTC
+	}
+	if conf.TopCategories <= 0 {
+		conf.TopCategories = DefaultTopCategories
+	}
+	var (
+		groups = make(map[groupKey]*group)
+		served = make(map[impressionKey]servedImpression)
+	)
+	get := func(key groupKey) *group {
+		g, ok := groups[key]
+		if !ok {
+			g = &group{
+				row:        Row{Day: key.day, Surface: key.surface},
+				requests:   make(map[string]*request),
+				items:      make(map[int]struct{}),
+				categories: make(map[string]float64),
+				clicked:    make(map[impressionKey]struct{}),
+			}
+			groups[key] = g
+		}
+		return g
+	}
+	day := func(ts int64) string {
+		return time.Unix(ts, 0).In(conf.Location).Format(dayLayout)
+	}
+
+	for i := range impressions {
+		imp := &impressions[i]
+		key := groupKey{day: day(imp.Timestamp), surface: imp.Surface}
+		g := get(key)
+		weight := imp.weight()
+		g.impressions += weight
+		id := imp.RequestId
+		if id == "" {
+			// the impressions of a request share the user and the time
+			id = fmt.Sprintf("%d@%d", imp.UserId, imp.Timestamp)
+		}
+		r, ok := g.requests[id]
+		if !ok {
+			r = &request{weight: weight}
+			g.requests[id] = r
+		}
+		r.fallback = r.fallback || imp.Fallback != ""
+		g.items[imp.ItemId] = struct{}{}
+		category := imp.Category
+		if category == "" && conf.Category != nil {
+			category = conf.Category(imp.ItemId)
+		}
+		if category != "" {
+			g.categories[category] += weight
+		}
+		served[newImpressionKey(imp.RequestId, imp.UserId, imp.ItemId)] = servedImpression{key: key, weight: weight}
+	}
+	for _, e := range events {
+		ik := newImpressionKey(e.RequestId, e.UserId, e.ItemId)
+		imp, matched := served[ik]
+		switch rcmd.FeedbackAction(e.Action) {
+		case ActionClick:
+			if !matched {
+				continue
+			}
+			g := get(imp.key)
+			if _, ok := g.clicked[ik]; ok {
+				continue
+			}
+			g.clicked[ik] = struct{}{}
+			g.clicks += imp.weight
+		case rcmd.Like, rcmd.Dislike, rcmd.Hide, rcmd.BlockCategory:
+			key := imp.key
+			if !matched {
+				key = groupKey{day: day(e.Timestamp), surface: e.Surface}
+			}
+			g := get(key)
+			if rcmd.FeedbackAction(e.Action) == rcmd.Like {
+				g.row.Likes++
+			} else {
+				g.row.Negatives++
+			}
+		}
+	}
+
+	rows := make([]Row, 0, len(groups))
+	for _, g := range groups {
+		r := g.row
+		r.Impressions, r.Clicks, r.Items = round(g.impressions), round(g.clicks), len(g.items)
+		if g.impressions != 0 {
+			r.CTR = g.clicks / g.impressions
+		}
+		if conf.CatalogSize > 0 {
+			r.Coverage = float64(r.Items) / float64(conf.CatalogSize)
+		}
+		var requests, fallbacks float64
+		for _, request := range g.requests {
+			requests += request.weight
+			if request.fallback {
+				fallbacks += request.weight
+			}
+		}
+		r.Requests = round(requests)
+		if requests != 0 {
+			r.FallbackRate = fallbacks / requests
+		}
+		r.TopCategories = topCategories(g.categories, g.impressions, conf.TopCategories)
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Day != rows[j].Day {
+			return rows[i].Day < rows[j].Day
+		}
+		return rows[i].Surface < rows[j].Surface
+	})
+	return rows
+}
+
+func round(x float64) int {
+	return int(math.Round(x))
+}
+
+// topCategories formats the n most counted categories and their shares of
+// total, the ties by name
+func topCategories(counts map[string]float64, total float64, n int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ":" + strconv.FormatFloat(counts[name]/total, 'g', 4, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ReadImpressions reads the Impression json lines of r
+func ReadImpressions(r io.Reader) (impressions []Impression, err error) {
+	err = readLines(r, func(line []byte) error {
+		var imp Impression
+		if err := json.Unmarshal(line, &imp); err != nil {
+			return err
+		}
+		impressions = append(impressions, imp)
+		return nil
+	})
+	return
+}
+
+// ReadEvents reads the Event json lines of r
+func ReadEvents(r io.Reader) (events []Event, err error) {
+	err = readLines(r, func(line []byte) error {
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		events = append(events, e)
+		return nil
+	})
+	return
+}
+
+// ReadAuditImpressions reads the impressions of the rankings of the
+// rcmd.AuditRecord json lines of r, e.g. the file of rcmd.NewAuditLog. Only
+// the items served are of the impressions: the first page of a paginated
+// request, and the next pages of the page records. The impressions are
+// weighted by the sample rate of the audit log, so the counts are of all the
+// requests.
+func ReadAuditImpressions(r io.Reader) (impressions []Impression, err error) {
+	err = readLines(r, func(line []byte) error {
+		var record rcmd.AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		ranking := record.Ranking
+		if record.Served > 0 && record.Served < len(ranking) {
+			ranking = ranking[:record.Served]
+		}
+		var weight float64
+		if record.SampleRate > 0 && record.SampleRate < 1 {
+			weight = 1 / record.SampleRate
+		}
+		for i, is := range ranking {
+			impressions = append(impressions, Impression{
+				RequestId: record.RequestId,
+				Surface:   record.Surface,
+				UserId:    record.UserId,
+				ItemId:    is.ItemId,
+				Position:  record.Offset + i,
+				Fallback:  record.Fallback,
+				Timestamp: record.Time.Unix(),
+				Weight:    weight,
+			})
+		}
+		return nil
+	})
+	return
+}
+
+// readLines calls fn of every line of r, the torn last line of a crash is
+// skipped
+func readLines(r io.Reader, fn func(line []byte) error) error {
+	reader := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		if err = fn(line); err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+}
+
+// WriteCSV writes the rows as the csv of the Columns
+func WriteCSV(w io.Writer, rows []Row) error {
+	records := [][]string{Columns}
+	for i := range rows {
+		values := rows[i].values()
+		record := make([]string, len(values))
+		for j, v := range values {
+			switch v := v.(type) {
+			case float64:
+				record[j] = strconv.FormatFloat(v, 'g', -1, 64)
+			default:
+				record[j] = fmt.Sprint(v)
+			}
+		}
+		records = append(records, record)
+	}
+	return csv.NewWriter(w).WriteAll(records)
+}
+
+// WriteSQLite writes the rows to the SQLite table of the Columns, created if
+// not exists. The rows of a day and surface replace the former ones, so a
+// day is reported again idempotently.
+func WriteSQLite(ctx context.Context, db *sql.DB, table string, rows []Row) (err error) {
+	if _, err = db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	day TEXT, surface TEXT, requests INTEGER, impressions INTEGER, clicks INTEGER, ctr REAL,
+	items INTEGER, coverage REAL, fallback_rate REAL, likes INTEGER, negatives INTEGER,
+	top_categories TEXT, PRIMARY KEY (day, surface))`, table)); err != nil {
+		return
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (?%s)",
+		table, strings.Join(Columns, ", "), strings.Repeat(", ?", len(Columns)-1)))
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	for i := range rows {
+		if _, err = stmt.ExecContext(ctx, rows[i].values()...); err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,127 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fixture returns the logs of two days of the surfaces
+func fixture() ([]Impression, []Event, Config) {
+	var (
+		day0 = time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC).Unix()
+		day1 = time.Date(2022, 3, 2, 10, 0, 0, 0, time.UTC).Unix()
+	)
+	impressions := []Impression{
+		{RequestId: "r1", Surface: "home", UserId: 1, ItemId: 1, Category: "comedy", Timestamp: day0},
+		{RequestId: "r1", Surface: "home", UserId: 1, ItemId: 2, Position: 1, Timestamp: day0},
+		{RequestId: "r2", Surface: "home", UserId: 2, ItemId: 1, Fallback: "popular", Timestamp: day0},
+		{RequestId: "r3", Surface: "detail", UserId: 1, ItemId: 3, Timestamp: day0},
+		// the request of no id is of the user and the time
+		{Surface: "home", UserId: 3, ItemId: 4, Timestamp: day1},
+		{Surface: "home", UserId: 3, ItemId: 5, Position: 1, Timestamp: day1},
+	}
+	events := []Event{
+		// the dup click counted once, attributed to the day of the impression
+		{RequestId: "r1", ItemId: 2, Action: ActionClick, Timestamp: day1},
+		{RequestId: "r1", ItemId: 2, Action: ActionClick, Timestamp: day1},
+		{RequestId: "r1", ItemId: 1, Action: string(rcmd.Like), Timestamp: day0},
+		{RequestId: "r2", ItemId: 1, Action: string(rcmd.Hide), Timestamp: day0},
+		// the impressions of no request id are clicked of the user and the
+		// item, the clicks of no impression are dropped
+		{Surface: "home", UserId: 3, ItemId: 4, Action: ActionClick, Timestamp: day1},
+		{Surface: "search", ItemId: 9, Action: ActionClick, Timestamp: day1},
+		{RequestId: "r9", ItemId: 1, Action: ActionClick, Timestamp: day1},
+		{RequestId: "r3", ItemId: 3, Action: "share", Timestamp: day0},
+	}
+	categories := map[int]string{2: "drama", 3: "drama", 4: "comedy"}
+	conf := Config{
+		CatalogSize:   10,
+		Category:      func(itemId int) string { return categories[itemId] },
+		TopCategories: 1,
+	}
+	return impressions, events, conf
+}
+
+func TestAggregate(t *testing.T) {
+	var (
+		impressions, events, conf = fixture()
+		day0                      = time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC).Unix()
+	)
+
+	Convey("aggregate the daily metrics of the surfaces", t, func() {
+		rows := Aggregate(impressions, events, conf)
+		So(rows, ShouldResemble, []Row{
+			{Day: "2022-03-01", Surface: "detail", Requests: 1, Impressions: 1, Items: 1, Coverage: 0.1,
+				TopCategories: "drama:1"},
+			{Day: "2022-03-01", Surface: "home", Requests: 2, Impressions: 3, Clicks: 1, CTR: 1. / 3, Items: 2,
+				Coverage: 0.2, FallbackRate: 0.5, Likes: 1, Negatives: 1, TopCategories: "comedy:0.3333"},
+			{Day: "2022-03-02", Surface: "home", Requests: 1, Impressions: 2, Clicks: 1, CTR: 0.5, Items: 2,
+				Coverage: 0.2, TopCategories: "comedy:0.5"},
+		})
+
+		// the weighted impressions of the sampled log
+		weighted := make([]Impression, len(impressions))
+		for i, imp := range impressions {
+			imp.Weight = 10
+			weighted[i] = imp
+		}
+		rows = Aggregate(weighted, events, conf)
+		So(rows[1], ShouldResemble, Row{Day: "2022-03-01", Surface: "home", Requests: 20, Impressions: 30,
+			Clicks: 10, CTR: 1. / 3, Items: 2, Coverage: 0.2, FallbackRate: 0.5, Likes: 1, Negatives: 1,
+			TopCategories: "comedy:0.3333"})
+
+		// the days of the time zone
+		rows = Aggregate(impressions, nil, Config{Location: time.FixedZone("UTC-11", -11*3600)})
+		So(rows[0].Day, ShouldEqual, "2022-02-28")
+		So(rows[0].Coverage, ShouldEqual, 0)
+	})
+
+	Convey("read the logs", t, func() {
+		imps, err := ReadImpressions(strings.NewReader(
+			`{"requestId":"r1","surface":"home","userId":1,"itemId":2,"position":1,"timestamp":1646128800}` + "\n\n"))
+		So(err, ShouldBeNil)
+		So(imps, ShouldResemble, []Impression{{RequestId: "r1", Surface: "home", UserId: 1, ItemId: 2, Position: 1,
+			Timestamp: 1646128800}})
+		evs, err := ReadEvents(strings.NewReader(`{"itemId":2,"action":"click","timestamp":1}` + "\n" + `{"itemId":`))
+		So(err, ShouldBeNil)
+		So(evs, ShouldResemble, []Event{{ItemId: 2, Action: ActionClick, Timestamp: 1}})
+		_, err = ReadEvents(strings.NewReader("{\n"))
+		So(err, ShouldNotBeNil)
+
+		imps, err = ReadAuditImpressions(strings.NewReader(
+			`{"requestId":"r1","time":"2022-03-01T10:00:00Z","userId":1,"surface":"home","fallback":"popular",` +
+				`"ranking":[{"itemId":3,"score":0.9},{"itemId":1,"score":0.5}]}` + "\n"))
+		So(err, ShouldBeNil)
+		So(imps, ShouldResemble, []Impression{
+			{RequestId: "r1", Surface: "home", UserId: 1, ItemId: 3, Fallback: "popular", Timestamp: day0},
+			{RequestId: "r1", Surface: "home", UserId: 1, ItemId: 1, Position: 1, Fallback: "popular", Timestamp: day0},
+		})
+
+		// the served pages of the sampled paginated request
+		imps, err = ReadAuditImpressions(strings.NewReader(
+			`{"requestId":"r2","time":"2022-03-01T10:00:00Z","userId":1,"sampleRate":0.25,"served":1,` +
+				`"ranking":[{"itemId":3,"score":0.9},{"itemId":1,"score":0.5},{"itemId":2,"score":0.1}]}` + "\n" +
+				`{"requestId":"r2","time":"2022-03-01T10:00:00Z","userId":1,"sampleRate":0.25,"served":1,` +
+				`"offset":1,"ranking":[{"itemId":1,"score":0.5}]}` + "\n"))
+		So(err, ShouldBeNil)
+		So(imps, ShouldResemble, []Impression{
+			{RequestId: "r2", UserId: 1, ItemId: 3, Timestamp: day0, Weight: 4},
+			{RequestId: "r2", UserId: 1, ItemId: 1, Position: 1, Timestamp: day0, Weight: 4},
+		})
+	})
+
+	Convey("write the report", t, func() {
+		rows := Aggregate(impressions, events, conf)
+		var buf bytes.Buffer
+		So(WriteCSV(&buf, rows), ShouldBeNil)
+		lines := strings.Split(buf.String(), "\n")
+		So(lines[0], ShouldEqual, strings.Join(Columns, ","))
+		So(lines[2], ShouldEqual, `2022-03-01,home,2,3,1,0.3333333333333333,2,0.2,0.5,1,1,comedy:0.3333`)
+
+	})
+}
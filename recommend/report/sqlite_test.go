@@ -0,0 +1,37 @@
+//go:build cgo && !nosqlite
+
+package report
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" //keep
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteSQLite(t *testing.T) {
+	impressions, events, conf := fixture()
+
+	Convey("write the report to sqlite", t, func() {
+		rows := Aggregate(impressions, events, conf)
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "report.db"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+		ctx := context.Background()
+		So(WriteSQLite(ctx, db, "daily_report", rows), ShouldBeNil)
+		// the day reported again replaces the rows
+		So(WriteSQLite(ctx, db, "daily_report", rows[1:2]), ShouldBeNil)
+		var (
+			count int
+			ctr   float64
+		)
+		So(db.QueryRow("SELECT COUNT(*) FROM daily_report").Scan(&count), ShouldBeNil)
+		So(count, ShouldEqual, len(rows))
+		So(db.QueryRow("SELECT ctr FROM daily_report WHERE day = ? AND surface = ?", "2022-03-01", "home").
+			Scan(&ctr), ShouldBeNil)
+		So(ctr, ShouldAlmostEqual, 1./3)
+	})
+}
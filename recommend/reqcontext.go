@@ -0,0 +1,225 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// the standard headers of the request context, the same of the http headers
+// and the grpc metadata, of which the keys are lower cased
+const (
+	// SessionIdHeader is RecApiRequest.SessionId if it's empty
+	SessionIdHeader = "X-Session-Id"
+	// DeviceClassHeader is one of the DeviceClasses
+	DeviceClassHeader = "X-Device-Class"
+	// LocaleHeader is the BCP 47 tag of the user locale, e.g. "en-US", the
+	// first of Accept-Language if it's empty
+	LocaleHeader = "X-Locale"
+	// ArmOverrideHeader forces the arms of the experiments, e.g.
+	// "canary=control,ranker=b", only taken WithArmOverrides
+	ArmOverrideHeader = "X-Arm-Override"
+)
+
+// the device classes of DeviceClassHeader
+const (
+	DeviceClassPhone   = "phone"
+	DeviceClassTablet  = "tablet"
+	DeviceClassDesktop = "desktop"
+	DeviceClassTV      = "tv"
+	DeviceClassOther   = "other"
+)
+
+// DeviceClasses are the valid device classes
+var DeviceClasses = []string{DeviceClassPhone, DeviceClassTablet, DeviceClassDesktop, DeviceClassTV, DeviceClassOther}
+
+// RequestContext is the context of a request parsed by ParseRequestContext,
+// the FeatureFuncs get it by RequestContextFromContext as the ctx features,
+// e.g. the one-hot of the DeviceClass, and the UserRouters and the layout
+// bandit get the arms forced by ArmOverride.
+type RequestContext struct {
+	SessionId   string `json:"sessionId,omitempty"`
+	DeviceClass string `json:"deviceClass,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+	// ArmOverrides is the arm by experiment name
+	ArmOverrides map[string]string `json:"armOverrides,omitempty"`
+}
+
+// headerValue returns the first value of name in header, the keys are
+// matched case insensitively so both the http.Header and the grpc
+// metadata.MD are looked up
+func headerValue(header map[string][]string, name string) string {
+	if values := header[name]; len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	for key, values := range header {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return strings.TrimSpace(values[0])
+		}
+	}
+	return ""
+}
+
+// ParseRequestContext parses the standard headers of the request, the
+// http.Header of the http api or the metadata.MD of metadata.FromIncomingContext
+// of a grpc server. The malformed DeviceClassHeader, LocaleHeader or
+// ArmOverrideHeader is an error, the malformed Accept-Language is ignored.
+func ParseRequestContext(header map[string][]string) (rc *RequestContext, err error) {
+	rc = &RequestContext{SessionId: headerValue(header, SessionIdHeader)}
+	if rc.DeviceClass, err = parseDeviceClass(headerValue(header, DeviceClassHeader)); err != nil {
+		return nil, err
+	}
+	if locale := headerValue(header, LocaleHeader); locale != "" {
+		if rc.Locale, err = parseLocale(locale); err != nil {
+			return nil, err
+		}
+	} else if accept := headerValue(header, "Accept-Language"); accept != "" {
+		tag := strings.TrimSpace(strings.SplitN(strings.SplitN(accept, ",", 2)[0], ";", 2)[0])
+		if tag != "*" {
+			rc.Locale, _ = parseLocale(tag)
+		}
+	}
+	if rc.ArmOverrides, err = parseArmOverrides(headerValue(header, ArmOverrideHeader)); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func parseDeviceClass(class string) (string, error) {
+	if class == "" {
+		return "", nil
+	}
+	class = strings.ToLower(class)
+	for _, c := range DeviceClasses {
+		if c == class {
+			return class, nil
+		}
+	}
+	return "", fmt.Errorf("device class %q not one of %v", class, DeviceClasses)
+}
+
+// parseLocale canonicalizes the BCP 47 tag, e.g. "en_us" to "en-US"
+func parseLocale(tag string) (string, error) {
+	subtags := strings.Split(strings.ReplaceAll(tag, "_", "-"), "-")
+	for i, s := range subtags {
+		if len(s) == 0 || len(s) > 8 || (i == 0 && (len(s) < 2 || len(s) > 3)) {
+			return "", fmt.Errorf("invalid locale %q", tag)
+		}
+		for _, r := range s {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+				return "", fmt.Errorf("invalid locale %q", tag)
+			}
+		}
+		switch {
+		case i == 0:
+			subtags[i] = strings.ToLower(s)
+		case len(s) == 2:
+			subtags[i] = strings.ToUpper(s)
+		case len(s) == 4:
+			subtags[i] = strings.ToUpper(s[:1]) + strings.ToLower(s[1:])
+		default:
+			subtags[i] = strings.ToLower(s)
+		}
+	}
+	return strings.Join(subtags, "-"), nil
+}
+
+// parseArmOverrides parses the comma separated experiment=arm pairs
+func parseArmOverrides(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	overrides := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid arm override %q, not experiment=arm", strings.TrimSpace(pair))
+		}
+		overrides[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return overrides, nil
+}
+
+// merge sets the fields of rc of the ones of the request body, which take
+// precedence over the headers
+func (rc *RequestContext) merge(req *RecApiRequest) (err error) {
+	if req.SessionId == "" {
+		req.SessionId = rc.SessionId
+	}
+	rc.SessionId = req.SessionId
+	if req.DeviceClass != "" {
+		if rc.DeviceClass, err = parseDeviceClass(req.DeviceClass); err != nil {
+			return
+		}
+	}
+	if req.Locale != "" {
+		if rc.Locale, err = parseLocale(req.Locale); err != nil {
+			return
+		}
+	}
+	for experiment, arm := range req.ArmOverrides {
+		if rc.ArmOverrides == nil {
+			rc.ArmOverrides = make(map[string]string)
+		}
+		rc.ArmOverrides[experiment] = arm
+	}
+	return
+}
+
+type requestContextKey struct{}
+
+// WithRequestContext returns the ctx of rc, the http api sets the one of
+// every request
+func WithRequestContext(ctx context.Context, rc *RequestContext) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, rc)
+}
+
+// RequestContextFromContext returns the RequestContext of ctx, or nil
+func RequestContextFromContext(ctx context.Context) *RequestContext {
+	rc, _ := ctx.Value(requestContextKey{}).(*RequestContext)
+	return rc
+}
+
+// ArmOverride returns the arm of experiment forced by the request of ctx, the
+// UserRouters and layout.Bandit serve it instead of the assigned one
+func ArmOverride(ctx context.Context, experiment string) (arm string, ok bool) {
+	rc := RequestContextFromContext(ctx)
+	if rc == nil {
+		return
+	}
+	arm, ok = rc.ArmOverrides[experiment]
+	return
+}
+
+// armBuckets is the resolution of the percent of AssignArm
+const armBuckets = 10000
+
+// AssignArm returns the arm of userId in experiment, treatment for percent
+// of the users and control for the others. The assignment is sticky for a
+// user and stable across the runs of the same percent, a user of treatment
+// stays in it as the percent grows. The arm forced by ArmOverride of ctx
+// takes precedence if it's treatment or control.
+func AssignArm(ctx context.Context, experiment string, userId int, percent float64, treatment, control string) string {
+	if forced, ok := ArmOverride(ctx, experiment); ok && (forced == treatment || forced == control) {
+		return forced
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(experiment))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(strconv.Itoa(userId)))
+	if float64(h.Sum32()%armBuckets) < percent*armBuckets/100 {
+		return treatment
+	}
+	return control
+}
+
+// WithArmOverrides takes the ArmOverrideHeader and RecApiRequest.ArmOverrides
+// of the requests, e.g. for the QA of an experiment arm. Without it they are
+// ignored so the users can't pick their arms.
+func WithArmOverrides() ApiOption {
+	return func(c *apiConfig) {
+		c.armOverrides = true
+	}
+}
@@ -0,0 +1,142 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// contextRouter records the RequestContext of the routed requests
+type contextRouter struct {
+	*countPredictor
+	rc *RequestContext
+}
+
+func (r *contextRouter) RouteUser(ctx context.Context, _ int) (Predictor, string) {
+	r.rc = RequestContextFromContext(ctx)
+	arm, _ := ArmOverride(ctx, "ranker")
+	return r.countPredictor, arm
+}
+
+func TestRequestContext(t *testing.T) {
+	Convey("sticky arm assignment", t, func() {
+		var (
+			ctx       = context.Background()
+			treatment int
+		)
+		for userId := 0; userId < 10000; userId++ {
+			arm := AssignArm(ctx, "exp", userId, 10, "b", "a")
+			So(AssignArm(ctx, "exp", userId, 10, "b", "a"), ShouldEqual, arm)
+			if arm == "b" {
+				treatment++
+				So(AssignArm(ctx, "exp", userId, 20, "b", "a"), ShouldEqual, "b")
+			}
+		}
+		So(treatment, ShouldBeBetween, 800, 1200)
+
+		forced := WithRequestContext(ctx, &RequestContext{ArmOverrides: map[string]string{"exp": "b"}})
+		So(AssignArm(forced, "exp", 1, 0, "b", "a"), ShouldEqual, "b")
+		So(AssignArm(forced, "other", 1, 0, "b", "a"), ShouldEqual, "a")
+		forced = WithRequestContext(ctx, &RequestContext{ArmOverrides: map[string]string{"exp": "c"}})
+		So(AssignArm(forced, "exp", 1, 0, "b", "a"), ShouldEqual, "a")
+	})
+
+	Convey("parse the headers", t, func() {
+		header := http.Header{}
+		header.Set(SessionIdHeader, " s1 ")
+		header.Set(DeviceClassHeader, "Phone")
+		header.Set("Accept-Language", "zh_hant_tw;q=0.9, en")
+		header.Set(ArmOverrideHeader, "canary=control, ranker = b")
+		rc, err := ParseRequestContext(header)
+		So(err, ShouldBeNil)
+		So(rc, ShouldResemble, &RequestContext{
+			SessionId:    "s1",
+			DeviceClass:  DeviceClassPhone,
+			Locale:       "zh-Hant-TW",
+			ArmOverrides: map[string]string{"canary": "control", "ranker": "b"},
+		})
+
+		// the lower cased keys of the grpc metadata
+		rc, err = ParseRequestContext(map[string][]string{"x-locale": {"en-us"}, "accept-language": {"fr"}})
+		So(err, ShouldBeNil)
+		So(rc, ShouldResemble, &RequestContext{Locale: "en-US"})
+
+		rc, err = ParseRequestContext(map[string][]string{"Accept-Language": {"*"}})
+		So(err, ShouldBeNil)
+		So(rc.Locale, ShouldBeEmpty)
+		rc, err = ParseRequestContext(map[string][]string{"Accept-Language": {"not a locale"}})
+		So(err, ShouldBeNil)
+		So(rc.Locale, ShouldBeEmpty)
+
+		for _, h := range []map[string][]string{
+			{DeviceClassHeader: {"watch"}},
+			{LocaleHeader: {"english"}},
+			{LocaleHeader: {"en--US"}},
+			{ArmOverrideHeader: {"canary"}},
+			{ArmOverrideHeader: {"canary=,ranker=b"}},
+		} {
+			_, err = ParseRequestContext(h)
+			So(err, ShouldNotBeNil)
+		}
+
+		ctx := context.Background()
+		So(RequestContextFromContext(ctx), ShouldBeNil)
+		_, ok := ArmOverride(ctx, "canary")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("request context of the http api", t, func() {
+		post := func(engine http.Handler, body string, header map[string]string) int {
+			req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			for k, v := range header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			return w.Code
+		}
+		router := &contextRouter{countPredictor: &countPredictor{}}
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, router)), ShouldBeNil)
+		headers := map[string]string{
+			SessionIdHeader:   "s1",
+			DeviceClassHeader: "tv",
+			LocaleHeader:      "en-GB",
+			ArmOverrideHeader: "ranker=b",
+		}
+
+		// the overrides are ignored by default
+		engine := newTenantEngine(r, "/api/v1/recommend")
+		So(post(engine, `{"userId":1}`, headers), ShouldEqual, 200)
+		So(router.rc, ShouldResemble, &RequestContext{SessionId: "s1", DeviceClass: DeviceClassTV, Locale: "en-GB"})
+		So(post(engine, `{"userId":1}`, map[string]string{DeviceClassHeader: "watch"}), ShouldEqual, 400)
+		So(post(engine, `{"userId":1,"locale":"english"}`, nil), ShouldEqual, 400)
+
+		// the body takes precedence over the headers
+		engine = newTenantEngine(r, "/api/v1/recommend", WithArmOverrides())
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(
+			`{"userId":1,"sessionId":"s2","deviceClass":"desktop","armOverrides":{"canary":"canary"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		engine.ServeHTTP(w, req)
+		So(w.Code, ShouldEqual, 200)
+		var resp RecApiResponse
+		So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+		So(resp.Arm, ShouldEqual, "b")
+		So(router.rc, ShouldResemble, &RequestContext{
+			SessionId:    "s2",
+			DeviceClass:  DeviceClassDesktop,
+			Locale:       "en-GB",
+			ArmOverrides: map[string]string{"canary": "canary", "ranker": "b"},
+		})
+	})
+}
@@ -0,0 +1,236 @@
+package retrain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ArmControl is the arm of the users served by the current version
+	ArmControl = "control"
+	// ArmCanary is the arm of the users served by the canary version
+	ArmCanary = "canary"
+	// CanaryExperiment is the experiment name of the canary arms forced by
+	// rcmd.ArmOverride, e.g. the header "X-Arm-Override: canary=canary"
+	CanaryExperiment = "canary"
+)
+
+// CanaryConfig is the percentage based rollout of a new version
+type CanaryConfig struct {
+	// Percent of the users routed to the canary, in (0, 100)
+	Percent float64 `json:"percent" yaml:"percent"`
+	// Bake is the time the canary is watched before the decision
+	Bake time.Duration `json:"bake" yaml:"bake"`
+	// MinEvents is the feedback events of each arm needed for the decision,
+	// the bake is extended until enough, it should be positive. The events
+	// are observed by the feedback api, see rcmd.FeedbackObserver.
+	MinEvents int64 `json:"minEvents" yaml:"minEvents"`
+	// Thresholds are the max regressions of the canary "auc" and "logloss"
+	// against the control, DefaultCanaryThresholds if empty
+	Thresholds []Threshold `json:"thresholds" yaml:"thresholds"`
+	// Window is the recent feedback events of the online metrics of every arm
+	Window int `json:"window" yaml:"window"`
+}
+
+// DefaultCanaryThresholds rolls back the canary losing 0.01 of the auc
+func DefaultCanaryThresholds() []Threshold {
+	return []Threshold{{Metric: "auc", MaxRegression: 0.01}}
+}
+
+func (c *CanaryConfig) validate() error {
+	if c.Percent <= 0 || c.Percent >= 100 {
+		return fmt.Errorf("canary percent %v should be in (0, 100)", c.Percent)
+	}
+	if c.Bake < 0 {
+		return fmt.Errorf("canary bake %v should not be negative", c.Bake)
+	}
+	if c.MinEvents <= 0 {
+		return fmt.Errorf("canary min events %d should be positive", c.MinEvents)
+	}
+	return nil
+}
+
+var _ rcmd.FeedbackObserver = &Registry{}
+
+type canary struct {
+	version *Version
+	config  CanaryConfig
+	started time.Time
+	metrics map[string]*rcmd.StreamingMetrics
+}
+
+// CanaryStatus is the state of the running canary
+type CanaryStatus struct {
+	Percent float64                        `json:"percent"`
+	Started time.Time                      `json:"started"`
+	Metrics map[string]rcmd.StreamingStats `json:"metrics"`
+}
+
+// CanaryResult is the decision of a canary
+type CanaryResult struct {
+	Version  *Version `json:"version"`
+	Promoted bool     `json:"promoted"`
+	// Regressions are the online metrics of the canary regressed beyond the
+	// thresholds, it's rolled back if any
+	Regressions []string `json:"regressions,omitempty"`
+}
+
+// StartCanary routes the Percent of the users to v and watches the online
+// metrics of both arms, see Observe and CheckCanary. v replaces the running
+// canary if any. Without a current version v is promoted at once.
+func (r *Registry) StartCanary(v *Version, config CanaryConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if len(config.Thresholds) == 0 {
+		config.Thresholds = DefaultCanaryThresholds()
+	}
+	if r.Current() == nil {
+		r.Promote(v)
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.canary = &canary{
+		version: v,
+		config:  config,
+		started: time.Now(),
+		metrics: map[string]*rcmd.StreamingMetrics{
+			ArmControl: rcmd.NewStreamingMetrics(config.Window),
+			ArmCanary:  rcmd.NewStreamingMetrics(config.Window),
+		},
+	}
+	log.Infof("canary started on %v%% users for %v", config.Percent, config.Bake)
+	return nil
+}
+
+// RouteUser implements rcmd.UserRouter, the users of the canary arm are
+// served by the canary version. The arm of CanaryExperiment forced by the
+// request overrides the one of the user, the feedback of the user is still
+// observed in the arm assigned. The other users are served by r, or by the
+// current version itself if it's a rcmd.SampleScorer, see serving.
+func (r *Registry) RouteUser(ctx context.Context, userId int) (rcmd.Predictor, string) {
+	r.mu.RLock()
+	c := r.canary
+	r.mu.RUnlock()
+	if c == nil {
+		return r.serving(), ""
+	}
+	arm := rcmd.AssignArm(ctx, CanaryExperiment, userId, c.config.Percent, ArmCanary, ArmControl)
+	if arm == ArmCanary {
+		return c.version.Predictor, arm
+	}
+	return r.serving(), arm
+}
+
+// Observe implements rcmd.FeedbackObserver, the served score and the
+// realized label of the feedback of userId are recorded in the metrics of
+// the arm assigned to the user, it's a no-op without canary
+func (r *Registry) Observe(userId int, score, label float32) {
+	r.mu.RLock()
+	c := r.canary
+	r.mu.RUnlock()
+	if c == nil {
+		return
+	}
+	arm := rcmd.AssignArm(context.Background(), CanaryExperiment, userId, c.config.Percent, ArmCanary, ArmControl)
+	c.metrics[arm].Observe(score, label)
+}
+
+// Canary returns the status of the running canary, nil if none
+func (r *Registry) Canary() *CanaryStatus {
+	r.mu.RLock()
+	c := r.canary
+	r.mu.RUnlock()
+	if c == nil {
+		return nil
+	}
+	status := &CanaryStatus{
+		Percent: c.config.Percent,
+		Started: c.started,
+		Metrics: make(map[string]rcmd.StreamingStats, len(c.metrics)),
+	}
+	for arm, m := range c.metrics {
+		status.Metrics[arm] = m.Stats()
+	}
+	return status
+}
+
+// CheckCanary decides the canary after the bake at now: the canary is
+// promoted if its online metrics do not regress against the control, or
+// else rolled back. decided is false if no canary is running or it's still
+// baking.
+func (r *Registry) CheckCanary(now time.Time) (result CanaryResult, decided bool) {
+	r.mu.RLock()
+	c := r.canary
+	r.mu.RUnlock()
+	if c == nil || now.Sub(c.started) < c.config.Bake {
+		return
+	}
+	var (
+		control = c.metrics[ArmControl].Stats()
+		cand    = c.metrics[ArmCanary].Stats()
+	)
+	if control.Events < c.config.MinEvents || cand.Events < c.config.MinEvents {
+		return
+	}
+	result.Version = c.version
+	regressed, err := regressions(onlineMetrics(control), onlineMetrics(cand), c.config.Thresholds)
+	if err != nil {
+		regressed = []string{err.Error()}
+	}
+	result.Regressions = regressed
+
+	r.mu.Lock()
+	if r.canary != c {
+		// replaced or decided meanwhile
+		r.mu.Unlock()
+		return CanaryResult{}, false
+	}
+	r.canary = nil
+	if len(regressed) == 0 {
+		r.promote(c.version)
+		result.Promoted = true
+	}
+	r.mu.Unlock()
+
+	if result.Promoted {
+		log.Infof("canary promoted to v%d, online metrics: %+v", c.version.Id, cand)
+	} else {
+		log.Warnf("canary rolled back, regressions: %v", regressed)
+	}
+	decided = true
+	return
+}
+
+// WatchCanary checks the canary every interval until ctx is done, the
+// decisions are sent to results if not nil
+func (r *Registry) WatchCanary(ctx context.Context, interval time.Duration, results chan<- CanaryResult) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if result, decided := r.CheckCanary(now); decided && results != nil {
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+func onlineMetrics(stats rcmd.StreamingStats) map[string]float64 {
+	return map[string]float64{
+		"auc":     stats.AUC,
+		"logloss": stats.LogLoss,
+	}
+}
@@ -0,0 +1,117 @@
+package retrain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCanary(t *testing.T) {
+	Convey("canary rollback and promotion", t, func() {
+		var (
+			ctx      = context.Background()
+			registry = NewRegistry()
+			config   = CanaryConfig{Percent: 50, Bake: time.Hour, MinEvents: 4}
+			users    = map[string][]int{}
+		)
+		for userId := 0; len(users[ArmCanary]) < 2 || len(users[ArmControl]) < 2; userId++ {
+			arm := rcmd.AssignArm(ctx, CanaryExperiment, userId, config.Percent, ArmCanary, ArmControl)
+			users[arm] = append(users[arm], userId)
+		}
+		So(registry.StartCanary(&Version{Predictor: &fakePredictor{weight: 1}}, config), ShouldBeNil)
+		So(registry.Canary(), ShouldBeNil)
+		So(registry.ModelVersion(), ShouldEqual, "v1")
+		So(registry.StartCanary(&Version{}, CanaryConfig{Percent: 100, MinEvents: 4}), ShouldNotBeNil)
+		// no decision without the evidence
+		So(registry.StartCanary(&Version{}, CanaryConfig{Percent: 50}), ShouldNotBeNil)
+
+		// feedback scores the items by each arm model, the label is item > 2
+		feedback := func() {
+			for _, arm := range []string{ArmControl, ArmCanary} {
+				for _, userId := range users[arm] {
+					predictor, routed := registry.RouteUser(ctx, userId)
+					So(routed, ShouldEqual, arm)
+					itemScores, err := rcmd.Rank(ctx, predictor, userId, []int{1, 4})
+					So(err, ShouldBeNil)
+					registry.Observe(userId, itemScores[0].Score, 0)
+					registry.Observe(userId, itemScores[1].Score, 1)
+				}
+			}
+		}
+
+		So(registry.StartCanary(&Version{Predictor: &fakePredictor{weight: -1}}, config), ShouldBeNil)
+		feedback()
+		status := registry.Canary()
+		So(status.Metrics[ArmControl].AUC, ShouldEqual, 1)
+		So(status.Metrics[ArmCanary].AUC, ShouldEqual, 0)
+		_, decided := registry.CheckCanary(time.Now())
+		So(decided, ShouldBeFalse)
+		result, decided := registry.CheckCanary(time.Now().Add(time.Hour))
+		So(decided, ShouldBeTrue)
+		So(result.Promoted, ShouldBeFalse)
+		So(result.Regressions, ShouldHaveLength, 1)
+		So(registry.ModelVersion(), ShouldEqual, "v1")
+		_, routed := registry.RouteUser(ctx, users[ArmCanary][0])
+		So(routed, ShouldEqual, "")
+
+		config.MinEvents = 100
+		So(registry.StartCanary(&Version{Predictor: &fakePredictor{weight: 2}}, config), ShouldBeNil)
+		feedback()
+		// the arm forced by the request
+		forced := rcmd.WithRequestContext(ctx, &rcmd.RequestContext{ArmOverrides: map[string]string{CanaryExperiment: ArmCanary}})
+		predictor, routed := registry.RouteUser(forced, users[ArmControl][0])
+		So(routed, ShouldEqual, ArmCanary)
+		So(predictor, ShouldEqual, registry.canary.version.Predictor)
+		forced = rcmd.WithRequestContext(ctx, &rcmd.RequestContext{ArmOverrides: map[string]string{CanaryExperiment: "unknown"}})
+		_, routed = registry.RouteUser(forced, users[ArmControl][0])
+		So(routed, ShouldEqual, ArmControl)
+		// not enough events, the bake is extended
+		_, decided = registry.CheckCanary(time.Now().Add(time.Hour))
+		So(decided, ShouldBeFalse)
+		registry.canary.config.MinEvents = 4
+		result, decided = registry.CheckCanary(time.Now().Add(time.Hour))
+		So(decided, ShouldBeTrue)
+		So(result.Promoted, ShouldBeTrue)
+		So(registry.ModelVersion(), ShouldEqual, "v2")
+		So(registry.Canary(), ShouldBeNil)
+	})
+
+	Convey("scheduler rolls out by canary", t, func() {
+		var (
+			ctx      = context.Background()
+			registry = NewRegistry()
+			s        = &Scheduler{
+				Train: func(context.Context, rcmd.Predictor) (rcmd.Predictor, error) {
+					return &fakePredictor{weight: 1}, nil
+				},
+				Evaluate: EvalAUC([]rcmd.Sample{{ItemId: 1, Label: 0}, {ItemId: 2, Label: 1}}),
+				Registry: registry,
+				Canary:   &CanaryConfig{Percent: 10, MinEvents: 1},
+			}
+		)
+		result, err := s.RunOnce(ctx)
+		So(err, ShouldBeNil)
+		So(result.Promoted, ShouldBeTrue)
+		result, err = s.RunOnce(ctx)
+		So(err, ShouldBeNil)
+		So(result.Promoted, ShouldBeFalse)
+		So(result.Canary, ShouldBeTrue)
+		So(registry.Canary().Percent, ShouldEqual, 10)
+		_, decided := registry.CheckCanary(time.Now())
+		So(decided, ShouldBeFalse)
+		for userId, arms := 0, map[string]bool{}; len(arms) < 2; userId++ {
+			arms[rcmd.AssignArm(ctx, CanaryExperiment, userId, 10, ArmCanary, ArmControl)] = true
+			registry.Observe(userId, 0.5, 1)
+		}
+
+		results := make(chan CanaryResult, 1)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go registry.WatchCanary(ctx, time.Millisecond, results)
+		So((<-results).Promoted, ShouldBeTrue)
+		So(registry.ModelVersion(), ShouldEqual, "v2")
+	})
+}
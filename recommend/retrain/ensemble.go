@@ -0,0 +1,387 @@
+package retrain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+	"gorgonia.org/tensor"
+)
+
+// the ensemble methods of EnsembleConfig.Method
+const (
+	// WeightedAverage is the weighted average of the member scores
+	WeightedAverage = "weighted"
+	// RankFusion is the weighted reciprocal rank fusion of the member ranks
+	// of the items of a user, robust to the different score scales
+	RankFusion = "rank_fusion"
+	// Stacking is the logistic regression of the member scores trained on
+	// the validation samples by FitStacking
+	Stacking = "stacking"
+
+	// DefaultRankConstant is the k of the reciprocal rank 1 / (k + rank)
+	DefaultRankConstant = 60
+	// DefaultStackingEpochs is the gradient descent epochs of FitStacking
+	DefaultStackingEpochs = 500
+	// DefaultStackingRate is the learning rate of FitStacking
+	DefaultStackingRate = 0.5
+)
+
+// EnsembleMember is a model of the ensemble by name
+type EnsembleMember struct {
+	Model string `json:"model" yaml:"model"`
+	// Weight of the model, 1 if 0. It's ignored by Stacking.
+	Weight float64 `json:"weight" yaml:"weight"`
+}
+
+// EnsembleConfig blends the scores of the models of Members by Method
+type EnsembleConfig struct {
+	// Method is WeightedAverage by default
+	Method  string           `json:"method" yaml:"method"`
+	Members []EnsembleMember `json:"members" yaml:"members"`
+	// RankConstant is the k of RankFusion, DefaultRankConstant if 0
+	RankConstant float64 `json:"rankConstant" yaml:"rankConstant"`
+}
+
+func (c *EnsembleConfig) validate() error {
+	switch c.Method {
+	case WeightedAverage, RankFusion, Stacking:
+	default:
+		return fmt.Errorf("unknown ensemble method %q", c.Method)
+	}
+	if len(c.Members) == 0 {
+		return fmt.Errorf("ensemble has no member")
+	}
+	seen := make(map[string]bool, len(c.Members))
+	for _, m := range c.Members {
+		if seen[m.Model] {
+			return fmt.Errorf("ensemble member %q is duplicated", m.Model)
+		}
+		seen[m.Model] = true
+		if m.Weight < 0 {
+			return fmt.Errorf("ensemble member %q weight %v is negative", m.Model, m.Weight)
+		}
+	}
+	return nil
+}
+
+type member struct {
+	name      string
+	weight    float64
+	predictor rcmd.Predictor
+}
+
+// stacking is the logistic regression of the standardized member scores
+type stacking struct {
+	mean, std, weights []float64
+	bias               float64
+}
+
+// Ensemble is the rcmd.Predictor blending the scores of several models, e.g.
+// DIN, MF and a PopularityModel, without application code. It's a
+// rcmd.SampleScorer, the members are scored by rcmd.BatchPredict of their own
+// features, in the feature scope of the member name. A member could be a
+// Registry, so its retrained versions are blended once promoted. The
+// Ensemble is served itself, or promoted as the Version of a Registry by
+// NewVersion to be canaried and rolled back like the single models. The
+// optional interfaces of the members are not exposed.
+type Ensemble struct {
+	conf    EnsembleConfig
+	members []member
+
+	mu    sync.RWMutex
+	stack *stacking
+}
+
+// NewEnsemble creates the Ensemble of conf, of which the members are models
+// by name
+func NewEnsemble(conf EnsembleConfig, models map[string]rcmd.Predictor) (*Ensemble, error) {
+	if conf.Method == "" {
+		conf.Method = WeightedAverage
+	}
+	if conf.RankConstant <= 0 {
+		conf.RankConstant = DefaultRankConstant
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	e := &Ensemble{conf: conf}
+	for _, m := range conf.Members {
+		p, ok := models[m.Model]
+		if !ok || p == nil {
+			return nil, fmt.Errorf("ensemble member %q is not found", m.Model)
+		}
+		w := m.Weight
+		if w == 0 {
+			w = 1
+		}
+		e.members = append(e.members, member{name: m.Model, weight: w, predictor: p})
+	}
+	return e, nil
+}
+
+// Config returns the config with the defaults applied
+func (e *Ensemble) Config() EnsembleConfig {
+	return e.conf
+}
+
+// memberScores returns the scores of every member of the samples
+func (e *Ensemble) memberScores(ctx context.Context, sampleKeys []rcmd.Sample) ([][]float32, error) {
+	scores := make([][]float32, len(e.members))
+	for i, m := range e.members {
+		y, err := rcmd.BatchPredict(rcmd.WithFeatureScope(ctx, m.name), m.predictor, sampleKeys)
+		if err != nil {
+			return nil, fmt.Errorf("ensemble member %s: %v", m.name, err)
+		}
+		if y == nil {
+			return nil, fmt.Errorf("ensemble member %s: no prediction", m.name)
+		}
+		s, ok := y.Data().([]float32)
+		if !ok || len(s) < len(sampleKeys) {
+			return nil, fmt.Errorf("ensemble member %s: %d scores of %d samples", m.name, len(s), len(sampleKeys))
+		}
+		// the first output of the multi-output models
+		if cols := len(s) / len(sampleKeys); cols > 1 {
+			first := make([]float32, len(sampleKeys))
+			for j := range first {
+				first[j] = s[j*cols]
+			}
+			s = first
+		}
+		scores[i] = s
+	}
+	return scores, nil
+}
+
+// ScoreSamples implements rcmd.SampleScorer
+func (e *Ensemble) ScoreSamples(ctx context.Context, sampleKeys []rcmd.Sample) ([]float32, error) {
+	if len(sampleKeys) == 0 {
+		return nil, nil
+	}
+	scores, err := e.memberScores(ctx, sampleKeys)
+	if err != nil {
+		return nil, err
+	}
+	switch e.conf.Method {
+	case RankFusion:
+		return e.fuseRanks(sampleKeys, scores), nil
+	case Stacking:
+		e.mu.RLock()
+		stack := e.stack
+		e.mu.RUnlock()
+		if stack == nil {
+			return nil, fmt.Errorf("ensemble stacking is not fitted")
+		}
+		blended := make([]float32, len(sampleKeys))
+		for j := range blended {
+			blended[j] = float32(stack.predict(scores, j))
+		}
+		return blended, nil
+	}
+	var sum float64
+	for _, m := range e.members {
+		sum += m.weight
+	}
+	blended := make([]float32, len(sampleKeys))
+	for j := range blended {
+		var s float64
+		for i, m := range e.members {
+			s += m.weight * float64(scores[i][j])
+		}
+		blended[j] = float32(s / sum)
+	}
+	return blended, nil
+}
+
+// fuseRanks ranks the samples of every user by every member, the score of a
+// sample is the weighted sum of its reciprocal ranks
+func (e *Ensemble) fuseRanks(sampleKeys []rcmd.Sample, scores [][]float32) []float32 {
+	users := make(map[int][]int)
+	for j, s := range sampleKeys {
+		users[s.UserId] = append(users[s.UserId], j)
+	}
+	blended := make([]float32, len(sampleKeys))
+	for _, samples := range users {
+		order := make([]int, len(samples))
+		for i, m := range e.members {
+			copy(order, samples)
+			s := scores[i]
+			sort.SliceStable(order, func(a, b int) bool {
+				return s[order[a]] > s[order[b]]
+			})
+			for rank, j := range order {
+				blended[j] += float32(m.weight / (e.conf.RankConstant + float64(rank+1)))
+			}
+		}
+	}
+	return blended
+}
+
+func (s *stacking) predict(scores [][]float32, j int) float64 {
+	z := s.bias
+	for i := range scores {
+		z += s.weights[i] * (float64(scores[i][j]) - s.mean[i]) / s.std[i]
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+// FitStacking trains the Stacking layer on the labeled validation samples,
+// which should be held out of the training of the members. The labels are
+// in [0, 1], the layer minimizes the log loss. It could be refitted when a
+// member is retrained.
+func (e *Ensemble) FitStacking(ctx context.Context, samples []rcmd.Sample) error {
+	if len(samples) == 0 {
+		return fmt.Errorf("no validation sample")
+	}
+	scores, err := e.memberScores(ctx, samples)
+	if err != nil {
+		return err
+	}
+	n := float64(len(samples))
+	stack := &stacking{
+		mean:    make([]float64, len(scores)),
+		std:     make([]float64, len(scores)),
+		weights: make([]float64, len(scores)),
+	}
+	for i, s := range scores {
+		for _, v := range s[:len(samples)] {
+			stack.mean[i] += float64(v)
+		}
+		stack.mean[i] /= n
+		for _, v := range s[:len(samples)] {
+			d := float64(v) - stack.mean[i]
+			stack.std[i] += d * d
+		}
+		if stack.std[i] = math.Sqrt(stack.std[i] / n); stack.std[i] == 0 {
+			stack.std[i] = 1
+		}
+	}
+	grad := make([]float64, len(scores))
+	for epoch := 0; epoch < DefaultStackingEpochs; epoch++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		var gradBias float64
+		for i := range grad {
+			grad[i] = 0
+		}
+		for j, sample := range samples {
+			d := stack.predict(scores, j) - float64(sample.Label)
+			gradBias += d
+			for i := range scores {
+				grad[i] += d * (float64(scores[i][j]) - stack.mean[i]) / stack.std[i]
+			}
+		}
+		stack.bias -= DefaultStackingRate * gradBias / n
+		for i := range grad {
+			stack.weights[i] -= DefaultStackingRate * grad[i] / n
+		}
+	}
+	e.mu.Lock()
+	e.stack = stack
+	e.mu.Unlock()
+	return nil
+}
+
+// Weights returns the weights of the members by name, of the standardized
+// scores if fitted by FitStacking
+func (e *Ensemble) Weights() map[string]float64 {
+	e.mu.RLock()
+	stack := e.stack
+	e.mu.RUnlock()
+	weights := make(map[string]float64, len(e.members))
+	for i, m := range e.members {
+		weights[m.name] = m.weight
+		if e.conf.Method == Stacking {
+			weights[m.name] = 0
+			if stack != nil {
+				weights[m.name] = stack.weights[i]
+			}
+		}
+	}
+	return weights
+}
+
+// GetUserFeature is of the first member, the members are scored by their
+// own features
+func (e *Ensemble) GetUserFeature(ctx context.Context, userId int) (rcmd.Tensor, error) {
+	return e.members[0].predictor.GetUserFeature(ctx, userId)
+}
+
+// GetItemFeature is of the first member
+func (e *Ensemble) GetItemFeature(ctx context.Context, itemId int) (rcmd.Tensor, error) {
+	return e.members[0].predictor.GetItemFeature(ctx, itemId)
+}
+
+// Predict logs the error and returns nil, the Ensemble is scored by
+// ScoreSamples
+func (e *Ensemble) Predict(tensor.Tensor) tensor.Tensor {
+	log.Errorf("ensemble is scored by ScoreSamples, not Predict")
+	return nil
+}
+
+// NewVersion returns the Version of e to promote, the Metrics are the
+// weights of the members by the "weight_" prefixed names
+func (e *Ensemble) NewVersion() *Version {
+	metrics := make(map[string]float64, len(e.members))
+	for name, w := range e.Weights() {
+		metrics["weight_"+name] = w
+	}
+	return &Version{TrainedAt: time.Now(), Metrics: metrics, Predictor: e}
+}
+
+// PopularityModel is the rcmd.Predictor scoring the items by ranker, e.g.
+// the popularity member of an Ensemble
+func PopularityModel(ranker rcmd.PopularityRanker) rcmd.Predictor {
+	return &popularityModel{ranker: ranker}
+}
+
+type popularityModel struct {
+	ranker rcmd.PopularityRanker
+}
+
+func (p *popularityModel) GetUserFeature(context.Context, int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{}, nil
+}
+
+func (p *popularityModel) GetItemFeature(context.Context, int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{}, nil
+}
+
+func (p *popularityModel) Predict(tensor.Tensor) tensor.Tensor {
+	log.Errorf("popularity model is scored by ScoreSamples, not Predict")
+	return nil
+}
+
+// ScoreSamples implements rcmd.SampleScorer by the PopularityRank of the
+// items of every user, the items not ranked score 0
+func (p *popularityModel) ScoreSamples(ctx context.Context, sampleKeys []rcmd.Sample) ([]float32, error) {
+	users := make(map[int][]int)
+	for j, s := range sampleKeys {
+		users[s.UserId] = append(users[s.UserId], j)
+	}
+	scores := make([]float32, len(sampleKeys))
+	for userId, samples := range users {
+		itemIds := make([]int, len(samples))
+		for i, j := range samples {
+			itemIds[i] = sampleKeys[j].ItemId
+		}
+		itemScores, err := p.ranker.PopularityRank(ctx, userId, itemIds)
+		if err != nil {
+			return nil, fmt.Errorf("popularity rank user %d error: %v", userId, err)
+		}
+		byItem := make(map[int]float32, len(itemScores))
+		for _, is := range itemScores {
+			byItem[is.ItemId] = is.Score
+		}
+		for _, j := range samples {
+			scores[j] = byItem[sampleKeys[j].ItemId]
+		}
+	}
+	return scores, nil
+}
@@ -0,0 +1,155 @@
+package retrain
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// reversedPredictor has 2 item features, it scores by -itemId times weight
+type reversedPredictor struct {
+	fakePredictor
+}
+
+func (p *reversedPredictor) GetItemFeature(_ context.Context, itemId int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{float32(itemId), -float32(itemId)}, nil
+}
+
+// firstPopular ranks item 1 the most popular
+type firstPopular struct{}
+
+func (firstPopular) PopularityRank(_ context.Context, _ int, itemIds []int) ([]rcmd.ItemScore, error) {
+	itemScores := make([]rcmd.ItemScore, len(itemIds))
+	for i, id := range itemIds {
+		itemScores[i] = rcmd.ItemScore{ItemId: id}
+		if id == 1 {
+			itemScores[i].Score = 100
+		}
+	}
+	return itemScores, nil
+}
+
+func TestEnsemble(t *testing.T) {
+	registry := NewRegistry()
+	registry.Promote(&Version{Predictor: &fakePredictor{weight: 1}})
+	models := map[string]rcmd.Predictor{
+		"din":        registry,
+		"mf":         &reversedPredictor{fakePredictor{weight: 1}},
+		"popularity": PopularityModel(firstPopular{}),
+	}
+	// the members of the tenant share its feature caches
+	tenant := rcmd.NewTenant("ensemble", &fakePredictor{})
+	ctx := rcmd.WithTenant(context.Background(), tenant)
+	itemIds := []int{1, 2, 3, 4}
+	// order is the items ranked by e, the best first
+	order := func(e *Ensemble) []int {
+		itemScores, err := rcmd.Rank(ctx, e, 1, itemIds)
+		So(err, ShouldBeNil)
+		sort.Slice(itemScores, func(i, j int) bool { return itemScores[i].Score > itemScores[j].Score })
+		best := make([]int, len(itemScores))
+		for i := range best {
+			best[i] = itemScores[i].ItemId
+		}
+		return best
+	}
+
+	Convey("weighted average", t, func() {
+		e, err := NewEnsemble(EnsembleConfig{Members: []EnsembleMember{
+			{Model: "din", Weight: 3}, {Model: "mf"},
+		}}, models)
+		So(err, ShouldBeNil)
+		So(e.Config().Method, ShouldEqual, WeightedAverage)
+		itemScores, err := rcmd.Rank(ctx, e, 1, itemIds)
+		So(err, ShouldBeNil)
+		for _, is := range itemScores {
+			So(is.Score, ShouldAlmostEqual, float32(is.ItemId)/2, 1e-6)
+		}
+		So(e.Weights(), ShouldResemble, map[string]float64{"din": 3, "mf": 1})
+
+		// the breakdown and the stage budgets fall back to the rank
+		itemScores, err = rcmd.RankWithBreakdown(ctx, e, 1, itemIds)
+		So(err, ShouldBeNil)
+		So(itemScores[3].Score, ShouldAlmostEqual, 2, 1e-6)
+		itemScores, partial, err := rcmd.RankWithBudget(ctx, e, 1, itemIds, rcmd.StageBudget{})
+		So(err, ShouldBeNil)
+		So(partial, ShouldBeFalse)
+		So(itemScores, ShouldHaveLength, 4)
+	})
+
+	Convey("rank fusion", t, func() {
+		e, err := NewEnsemble(EnsembleConfig{Method: RankFusion, Members: []EnsembleMember{
+			{Model: "din", Weight: 2}, {Model: "mf"},
+		}}, models)
+		So(err, ShouldBeNil)
+		So(order(e), ShouldResemble, []int{4, 3, 2, 1})
+
+		e, err = NewEnsemble(EnsembleConfig{Method: RankFusion, Members: []EnsembleMember{
+			{Model: "din"}, {Model: "mf", Weight: 2}, {Model: "popularity", Weight: 2},
+		}}, models)
+		So(err, ShouldBeNil)
+		So(order(e), ShouldResemble, []int{1, 2, 3, 4})
+	})
+
+	Convey("stacking fitted on the validation samples", t, func() {
+		e, err := NewEnsemble(EnsembleConfig{Method: Stacking, Members: []EnsembleMember{
+			{Model: "mf"}, {Model: "popularity"},
+		}}, models)
+		So(err, ShouldBeNil)
+		_, err = rcmd.Rank(ctx, e, 1, itemIds)
+		So(err, ShouldNotBeNil)
+
+		// the larger items are clicked
+		var samples []rcmd.Sample
+		for id := 1; id <= 10; id++ {
+			label := float32(0)
+			if id > 5 {
+				label = 1
+			}
+			samples = append(samples, rcmd.Sample{UserId: 1, ItemId: id, Label: label})
+		}
+		So(e.FitStacking(ctx, samples), ShouldBeNil)
+		So(e.Weights()["mf"], ShouldBeLessThan, 0)
+		metrics, err := EvalAUC(samples)(ctx, e)
+		So(err, ShouldBeNil)
+		So(metrics["auc"], ShouldEqual, 1)
+		So(order(e), ShouldResemble, []int{4, 3, 2, 1})
+	})
+
+	Convey("promoted as a version of the registry", t, func() {
+		e, err := NewEnsemble(EnsembleConfig{Members: []EnsembleMember{
+			{Model: "din", Weight: 3}, {Model: "mf"},
+		}}, models)
+		So(err, ShouldBeNil)
+		served := NewRegistry()
+		served.Promote(&Version{Predictor: &fakePredictor{weight: 1}})
+		v := e.NewVersion()
+		So(v.Metrics, ShouldResemble, map[string]float64{"weight_din": 3, "weight_mf": 1})
+		served.Promote(v)
+		So(served.ModelVersion(), ShouldEqual, "v2")
+		predictor, _ := served.RouteUser(ctx, 1)
+		So(predictor, ShouldEqual, e)
+		itemScores, err := rcmd.Rank(ctx, predictor, 1, itemIds)
+		So(err, ShouldBeNil)
+		So(itemScores[3].Score, ShouldAlmostEqual, 2, 1e-6)
+		// the ensemble is not scored by Predict
+		_, err = rcmd.Rank(ctx, served, 1, itemIds)
+		So(err, ShouldNotBeNil)
+		So(e.Predict(nil), ShouldBeNil)
+	})
+
+	Convey("config errors", t, func() {
+		_, err := NewEnsemble(EnsembleConfig{Method: "vote", Members: []EnsembleMember{{Model: "din"}}}, models)
+		So(err, ShouldNotBeNil)
+		_, err = NewEnsemble(EnsembleConfig{}, models)
+		So(err, ShouldNotBeNil)
+		_, err = NewEnsemble(EnsembleConfig{Members: []EnsembleMember{{Model: "din"}, {Model: "din"}}}, models)
+		So(err, ShouldNotBeNil)
+		_, err = NewEnsemble(EnsembleConfig{Members: []EnsembleMember{{Model: "gbdt"}}}, models)
+		So(err, ShouldNotBeNil)
+		_, err = NewEnsemble(EnsembleConfig{Members: []EnsembleMember{{Model: "din", Weight: -1}}}, models)
+		So(err, ShouldNotBeNil)
+	})
+}
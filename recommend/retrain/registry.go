@@ -0,0 +1,132 @@
+package retrain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"gorgonia.org/tensor"
+)
+
+// Version is a trained model with its evaluation metrics
+type Version struct {
+	Id        int                `json:"id"`
+	TrainedAt time.Time          `json:"trainedAt"`
+	Metrics   map[string]float64 `json:"metrics"`
+	Predictor rcmd.Predictor     `json:"-"`
+}
+
+// Registry keeps the promoted model versions. It's a rcmd.Predictor serving
+// the current version, so it could be passed to rcmd.StartHttpApi or
+// rcmd.NewTenant and the promotions take effect without restart. The
+// optional interfaces of the versions, e.g. rcmd.ReRanker, are not exposed,
+// but the version of rcmd.SampleScorer, e.g. an Ensemble, serves the users
+// routed by RouteUser itself. A request could straddle a promotion, so the
+// versions should keep the same feature layout.
+type Registry struct {
+	mu       sync.RWMutex
+	versions []*Version
+	// canary is the version in rollout, see StartCanary
+	canary *canary
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Current returns the serving version, nil if none promoted
+func (r *Registry) Current() *Version {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.versions) == 0 {
+		return nil
+	}
+	return r.versions[len(r.versions)-1]
+}
+
+// Versions returns all the promoted versions, oldest first
+func (r *Registry) Versions() []*Version {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Version(nil), r.versions...)
+}
+
+// Promote makes v the serving version and assigns its Id
+func (r *Registry) Promote(v *Version) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.promote(v)
+}
+
+func (r *Registry) promote(v *Version) {
+	v.Id = len(r.versions) + 1
+	r.versions = append(r.versions, v)
+}
+
+func (r *Registry) current() (rcmd.Predictor, error) {
+	v := r.Current()
+	if v == nil {
+		return nil, fmt.Errorf("no model version promoted")
+	}
+	return v.Predictor, nil
+}
+
+func (r *Registry) GetUserFeature(ctx context.Context, userId int) (rcmd.Tensor, error) {
+	p, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return p.GetUserFeature(ctx, userId)
+}
+
+func (r *Registry) GetItemFeature(ctx context.Context, itemId int) (rcmd.Tensor, error) {
+	p, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return p.GetItemFeature(ctx, itemId)
+}
+
+// Predict panics if no version is promoted, the features could not be
+// fetched before anyway
+func (r *Registry) Predict(X tensor.Tensor) tensor.Tensor {
+	p, err := r.current()
+	if err != nil {
+		panic(err)
+	}
+	return p.Predict(X)
+}
+
+// serving is the current version if it's a rcmd.SampleScorer, which scores
+// the samples of its own features and so is not served by Predict, or r
+func (r *Registry) serving() rcmd.Predictor {
+	if v := r.Current(); v != nil {
+		if _, ok := v.Predictor.(rcmd.SampleScorer); ok {
+			return v.Predictor
+		}
+	}
+	return r
+}
+
+// ModelVersion implements rcmd.ModelVersioner
+func (r *Registry) ModelVersion() string {
+	v := r.Current()
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("v%d", v.Id)
+}
+
+// Manifest implements rcmd.ManifestProvider if the current version does
+func (r *Registry) Manifest() *rcmd.Manifest {
+	v := r.Current()
+	if v == nil {
+		return nil
+	}
+	if provider, ok := v.Predictor.(rcmd.ManifestProvider); ok {
+		return provider.Manifest()
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+// Package retrain is the scheduled retraining daemon: on every scheduled run
+// it trains a new model on the fresh data, evaluates it, and promotes it to
+// the Registry only if no metric regresses beyond its threshold.
+package retrain
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	nnmetrics "github.com/auxten/go-ctr/nn/metrics"
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/tracking"
+	"github.com/auxten/go-ctr/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// TrainFunc trains a new model on the fresh data, current is the serving
+// model to be updated incrementally, or nil for the first run. Usually it
+// wraps rcmd.Train with a RecSys reading the configured source.
+type TrainFunc func(ctx context.Context, current rcmd.Predictor) (rcmd.Predictor, error)
+
+// EvalFunc evaluates the model, see EvalAUC
+type EvalFunc func(ctx context.Context, predictor rcmd.Predictor) (metrics map[string]float64, err error)
+
+// Threshold is the max regression of Metric allowed against the current
+// version, which is a drop if higher is better (e.g. auc), or a rise if
+// LowerIsBetter (e.g. logloss).
+type Threshold struct {
+	Metric        string  `json:"metric" yaml:"metric"`
+	MaxRegression float64 `json:"maxRegression" yaml:"maxRegression"`
+	LowerIsBetter bool    `json:"lowerIsBetter" yaml:"lowerIsBetter"`
+}
+
+// Scheduler is the retraining daemon
+type Scheduler struct {
+	Schedule   Schedule
+	Train      TrainFunc
+	Evaluate   EvalFunc
+	Thresholds []Threshold
+	Registry   *Registry
+	// Canary rolls out the new version by Registry.StartCanary instead of
+	// promoting it at once if not nil
+	Canary *CanaryConfig
+	// Tracker reports every run to the experiment tracker if not nil, the
+	// TrainFunc could log to the run of its ctx by tracking.FromContext
+	Tracker tracking.Tracker
+	// Experiment of the runs, tracking.DefaultExperiment if empty
+	Experiment string
+
+	triggerOnce sync.Once
+	trigger     chan struct{}
+}
+
+// RunResult is the outcome of a retraining run
+type RunResult struct {
+	Version  *Version
+	Promoted bool
+	// Canary is true if the version is in the canary rollout, see
+	// Scheduler.Canary
+	Canary bool
+	// Regressions are the metrics regressed beyond the thresholds
+	Regressions []string
+}
+
+// RunOnce trains, evaluates and promotes if not regressed
+func (s *Scheduler) RunOnce(ctx context.Context) (result RunResult, err error) {
+	tracker := s.startTracking(ctx)
+	if tracker != nil {
+		ctx = tracking.NewContext(ctx, tracker.run)
+		defer func() {
+			tracker.end(ctx, result, err)
+		}()
+	}
+	var current rcmd.Predictor
+	currentVersion := s.Registry.Current()
+	if currentVersion != nil {
+		current = currentVersion.Predictor
+	}
+	predictor, err := s.Train(ctx, current)
+	if err != nil {
+		return result, fmt.Errorf("retrain error: %v", err)
+	}
+	tracker.trained(ctx, predictor)
+	metrics, err := s.Evaluate(ctx, predictor)
+	if err != nil {
+		return result, fmt.Errorf("evaluate error: %v", err)
+	}
+	tracker.evaluated(ctx, metrics)
+	result.Version = &Version{
+		TrainedAt: time.Now(),
+		Metrics:   metrics,
+		Predictor: predictor,
+	}
+	if currentVersion != nil {
+		if result.Regressions, err = regressions(currentVersion.Metrics, metrics, s.Thresholds); err != nil {
+			return result, err
+		}
+	}
+	if len(result.Regressions) != 0 {
+		log.Warnf("retrained model not promoted, regressions: %v", result.Regressions)
+		return
+	}
+	if s.Canary != nil && currentVersion != nil {
+		if err = s.Registry.StartCanary(result.Version, *s.Canary); err != nil {
+			return
+		}
+		result.Canary = true
+		return
+	}
+	s.Registry.Promote(result.Version)
+	result.Promoted = true
+	log.Infof("promoted model v%d, metrics: %v", result.Version.Id, metrics)
+	return
+}
+
+func regressions(current, candidate map[string]float64, thresholds []Threshold) (regressed []string, err error) {
+	for _, t := range thresholds {
+		cur, ok := current[t.Metric]
+		if !ok {
+			continue
+		}
+		cand, ok := candidate[t.Metric]
+		if !ok {
+			return nil, fmt.Errorf("metric %s of threshold not evaluated", t.Metric)
+		}
+		regression := cur - cand
+		if t.LowerIsBetter {
+			regression = -regression
+		}
+		if regression > t.MaxRegression {
+			regressed = append(regressed, fmt.Sprintf("%s %v -> %v", t.Metric, cur, cand))
+		}
+	}
+	return
+}
+
+// Run runs the first retraining at once if nothing is promoted yet, then on
+// the Schedule or Trigger until ctx is done. The failed runs are logged and
+// retried on the next schedule.
+func (s *Scheduler) Run(ctx context.Context) {
+	if s.Registry.Current() == nil {
+		if _, err := s.RunOnce(ctx); err != nil {
+			log.Errorf("%v", err)
+		}
+	}
+	for {
+		next := s.Schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Errorf("retrain schedule has no next run")
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.triggered():
+			timer.Stop()
+			log.Infof("retrain triggered")
+		case <-timer.C:
+		}
+		if _, err := s.RunOnce(ctx); err != nil {
+			log.Errorf("%v", err)
+		}
+	}
+}
+
+// Trigger requests Run to retrain at once instead of waiting for the next
+// schedule, e.g. on the drift alert. The triggers during a run are merged.
+func (s *Scheduler) Trigger() {
+	select {
+	case s.triggered() <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) triggered() chan struct{} {
+	s.triggerOnce.Do(func() {
+		s.trigger = make(chan struct{}, 1)
+	})
+	return s.trigger
+}
+
+// EvalAUC returns the EvalFunc of the "auc" of the model on the labeled
+// holdout samples
+func EvalAUC(samples []rcmd.Sample) EvalFunc {
+	return func(ctx context.Context, predictor rcmd.Predictor) (metrics map[string]float64, err error) {
+		pred, labels, err := predictHoldout(ctx, predictor, samples)
+		if err != nil {
+			return
+		}
+		return map[string]float64{
+			"auc": float64(utils.RocAuc32(pred, labels)),
+		}, nil
+	}
+}
+
+// EvalRegression returns the EvalFunc of the "rmse" and "mae" of the
+// regression model on the holdout samples, set LowerIsBetter in their
+// Thresholds
+func EvalRegression(samples []rcmd.Sample) EvalFunc {
+	return func(ctx context.Context, predictor rcmd.Predictor) (metrics map[string]float64, err error) {
+		pred, labels, err := predictHoldout(ctx, predictor, samples)
+		if err != nil {
+			return
+		}
+		return map[string]float64{
+			"rmse": float64(utils.RMSE32(pred, labels)),
+			"mae":  float64(utils.MAE32(pred, labels)),
+		}, nil
+	}
+}
+
+// EvalClassification returns the EvalFunc of the "macroF1" and "accuracy" of
+// the multi-class model on the holdout samples, the labels are the class
+// indexes and decode returns the class of every row of the row major
+// predictions, e.g. model.DecodeClasses
+func EvalClassification(samples []rcmd.Sample, classes int, decode func(y []float32) []int) EvalFunc {
+	return func(ctx context.Context, predictor rcmd.Predictor) (metrics map[string]float64, err error) {
+		pred, labels, err := predictHoldout(ctx, predictor, samples)
+		if err != nil {
+			return
+		}
+		var (
+			decoded = decode(pred)
+			y       = make([]int, len(labels))
+			correct int
+		)
+		if len(decoded) != len(y) {
+			return nil, fmt.Errorf("decoded %d classes of %d samples", len(decoded), len(y))
+		}
+		for i, l := range labels {
+			y[i] = int(l)
+			if decoded[i] == y[i] {
+				correct++
+			}
+		}
+		return map[string]float64{
+			"macroF1":  utils.MacroF1(utils.ConfusionMatrix(decoded, y, classes)),
+			"accuracy": float64(correct) / float64(len(y)),
+		}, nil
+	}
+}
+
+// EvalAll returns the EvalFunc of the metrics of all evals, e.g. of the
+// accuracy and the beyond-accuracy ones. The metric of the same name of a
+// later eval replaces the former one.
+func EvalAll(evals ...EvalFunc) EvalFunc {
+	return func(ctx context.Context, predictor rcmd.Predictor) (metrics map[string]float64, err error) {
+		metrics = make(map[string]float64)
+		for _, eval := range evals {
+			var m map[string]float64
+			if m, err = eval(ctx, predictor); err != nil {
+				return nil, err
+			}
+			for name, v := range m {
+				metrics[name] = v
+			}
+		}
+		return
+	}
+}
+
+// DefaultListK is the length of the lists evaluated by EvalBeyondAccuracy
+const DefaultListK = 10
+
+// BeyondAccuracyConfig configures EvalBeyondAccuracy, the zero values are
+// the defaults
+type BeyondAccuracyConfig struct {
+	// Users are recommended the top K of Candidates by the model
+	Users      []int
+	Candidates []int
+	// K is DefaultListK if 0
+	K int
+	// Interactions are the samples of which the positives are the item
+	// popularity of the novelty, and of the popularity lists expected of
+	// the serendipity, e.g. the training samples
+	Interactions []rcmd.Sample
+	// Holdout is the samples of which the positives are relevant to the
+	// users, the serendipity is not evaluated if empty
+	Holdout []rcmd.Sample
+	// Distance is of the intra-list diversity, not evaluated if nil
+	Distance func(a, b int) float64
+}
+
+// EvalBeyondAccuracy returns the EvalFunc of the beyond-accuracy metrics of
+// the top K lists of the model: "coverage" of the candidates, "novelty" by
+// the popularity, and "diversity" and "serendipity" if configured, see
+// package metrics. They are evaluated with an accuracy metric, e.g. by
+// EvalAll, so a model isn't promoted only recommending the popular items.
+func EvalBeyondAccuracy(conf BeyondAccuracyConfig) EvalFunc {
+	if conf.K <= 0 {
+		conf.K = DefaultListK
+	}
+	return func(ctx context.Context, predictor rcmd.Predictor) (map[string]float64, error) {
+		if len(conf.Users) == 0 || len(conf.Candidates) == 0 {
+			return nil, fmt.Errorf("no user or candidate to evaluate")
+		}
+		lists := make([][]int, len(conf.Users))
+		for i, userId := range conf.Users {
+			itemScores, err := rcmd.Rank(ctx, predictor, userId, conf.Candidates)
+			if err != nil {
+				return nil, fmt.Errorf("rank user %d: %v", userId, err)
+			}
+			lists[i] = topK(itemScores, conf.K)
+		}
+
+		var (
+			counts = make(map[int]int)
+			users  = make(map[int]struct{})
+			seen   = make(map[[2]int]struct{})
+		)
+		for _, s := range conf.Interactions {
+			users[s.UserId] = struct{}{}
+			if _, ok := seen[[2]int{s.UserId, s.ItemId}]; s.Label > 0.5 && !ok {
+				seen[[2]int{s.UserId, s.ItemId}] = struct{}{}
+				counts[s.ItemId]++
+			}
+		}
+		result := map[string]float64{
+			"coverage": nnmetrics.CatalogCoverage(lists, len(conf.Candidates)),
+			"novelty":  nnmetrics.Novelty(lists, counts, len(users)),
+		}
+		if conf.Distance != nil {
+			result["diversity"] = nnmetrics.IntraListDiversity(lists, conf.Distance)
+		}
+		if len(conf.Holdout) != 0 {
+			// the same popular candidates are expected of every user
+			popular := make([]rcmd.ItemScore, len(conf.Candidates))
+			for i, id := range conf.Candidates {
+				popular[i] = rcmd.ItemScore{ItemId: id, Score: float32(counts[id])}
+			}
+			var (
+				expected      = topK(popular, conf.K)
+				index         = make(map[int]int, len(conf.Users))
+				relevant      = make([]map[int]struct{}, len(conf.Users))
+				expectedLists = make([][]int, len(conf.Users))
+			)
+			for i, userId := range conf.Users {
+				index[userId] = i
+				relevant[i] = make(map[int]struct{})
+				expectedLists[i] = expected
+			}
+			for _, s := range conf.Holdout {
+				if i, ok := index[s.UserId]; ok && s.Label > 0.5 {
+					relevant[i][s.ItemId] = struct{}{}
+				}
+			}
+			result["serendipity"] = nnmetrics.Serendipity(lists, expectedLists, relevant)
+		}
+		return result, nil
+	}
+}
+
+// topK returns the items of the k highest scores, the ties by item id
+func topK(itemScores []rcmd.ItemScore, k int) []int {
+	sorted := append([]rcmd.ItemScore(nil), itemScores...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].ItemId < sorted[j].ItemId
+	})
+	if len(sorted) > k {
+		sorted = sorted[:k]
+	}
+	ids := make([]int, len(sorted))
+	for i, s := range sorted {
+		ids[i] = s.ItemId
+	}
+	return ids
+}
+
+func predictHoldout(ctx context.Context, predictor rcmd.Predictor, samples []rcmd.Sample) (pred, labels []float32, err error) {
+	y, err := rcmd.BatchPredict(ctx, predictor, samples)
+	if err != nil {
+		return
+	}
+	if y == nil {
+		return nil, nil, fmt.Errorf("no prediction")
+	}
+	labels = make([]float32, len(samples))
+	for i := range samples {
+		labels[i] = samples[i].Label
+	}
+	return y.Data().([]float32), labels, nil
+}
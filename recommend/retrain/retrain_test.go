@@ -0,0 +1,284 @@
+package retrain
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/tracking"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// fakePredictor scores every sample by its item feature times weight
+type fakePredictor struct {
+	weight float32
+}
+
+func (p *fakePredictor) GetUserFeature(context.Context, int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{0}, nil
+}
+
+func (p *fakePredictor) GetItemFeature(_ context.Context, itemId int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{float32(itemId)}, nil
+}
+
+func (p *fakePredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	rows, cols := X.Shape()[0], X.Shape()[1]
+	data := X.Data().([]float32)
+	y := make([]float32, rows)
+	for i := range y {
+		y[i] = data[i*cols+cols-1] * p.weight
+	}
+	return tensor.New(tensor.WithShape(rows, 1), tensor.WithBacking(y))
+}
+
+func TestSchedule(t *testing.T) {
+	Convey("parse schedule", t, func() {
+		base := time.Date(2022, 3, 4, 10, 20, 30, 0, time.UTC) // Friday
+		s, err := ParseSchedule("@every 6h")
+		So(err, ShouldBeNil)
+		So(s.Next(base), ShouldEqual, base.Add(6*time.Hour))
+
+		s, err = ParseSchedule("@daily")
+		So(err, ShouldBeNil)
+		So(s.Next(base), ShouldEqual, time.Date(2022, 3, 5, 0, 0, 0, 0, time.UTC))
+
+		s, err = ParseSchedule("*/15 * * * *")
+		So(err, ShouldBeNil)
+		So(s.Next(base), ShouldEqual, time.Date(2022, 3, 4, 10, 30, 0, 0, time.UTC))
+
+		// weekdays only
+		s, err = ParseSchedule("30 3 * * 1-5")
+		So(err, ShouldBeNil)
+		So(s.Next(base), ShouldEqual, time.Date(2022, 3, 7, 3, 30, 0, 0, time.UTC))
+
+		for _, bad := range []string{"* * *", "60 * * * *", "@every -1h", "*/0 * * * *", "a * * * *"} {
+			_, err = ParseSchedule(bad)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestEvalRegression(t *testing.T) {
+	Convey("rmse and mae of the ratings", t, func() {
+		metrics, err := EvalRegression([]rcmd.Sample{
+			{UserId: 1, ItemId: 1, Label: 2},
+			{UserId: 1, ItemId: 3, Label: 3},
+		})(context.Background(), &fakePredictor{weight: 1})
+		So(err, ShouldBeNil)
+		So(metrics["mae"], ShouldEqual, 0.5)
+		So(metrics["rmse"], ShouldAlmostEqual, 0.7071068, 1e-6)
+	})
+}
+
+func TestEvalClassification(t *testing.T) {
+	Convey("macro f1 and accuracy of the tiers", t, func() {
+		metrics, err := EvalClassification([]rcmd.Sample{
+			{UserId: 1, ItemId: 0, Label: 0},
+			{UserId: 1, ItemId: 1, Label: 1},
+			{UserId: 1, ItemId: 1, Label: 0},
+			{UserId: 1, ItemId: 2, Label: 2},
+		}, 3, func(y []float32) []int {
+			classes := make([]int, len(y))
+			for i, p := range y {
+				classes[i] = int(p)
+			}
+			return classes
+		})(context.Background(), &fakePredictor{weight: 1})
+		So(err, ShouldBeNil)
+		So(metrics["accuracy"], ShouldEqual, 0.75)
+		// f1: 2/3, 2/3, 1
+		So(metrics["macroF1"], ShouldAlmostEqual, (2./3+2./3+1)/3, 1e-9)
+	})
+}
+
+func TestEvalBeyondAccuracy(t *testing.T) {
+	Convey("beyond-accuracy metrics of the top k lists", t, func() {
+		conf := BeyondAccuracyConfig{
+			Users:      []int{1, 2},
+			Candidates: []int{1, 2, 3, 4, 5, 6},
+			K:          2,
+			Interactions: []rcmd.Sample{
+				{UserId: 1, ItemId: 6, Label: 1},
+				{UserId: 1, ItemId: 6, Label: 1},
+				{UserId: 2, ItemId: 6, Label: 1},
+				{UserId: 2, ItemId: 5, Label: 1},
+				{UserId: 3, ItemId: 1, Label: 1},
+				{UserId: 3, ItemId: 2, Label: 0},
+			},
+			Holdout: []rcmd.Sample{
+				{UserId: 1, ItemId: 5, Label: 1},
+				{UserId: 2, ItemId: 3, Label: 1},
+			},
+			Distance: func(a, b int) float64 {
+				if a%2 == b%2 {
+					return 0
+				}
+				return 1
+			},
+		}
+		// the items 6 and 5 of every user
+		metrics, err := EvalBeyondAccuracy(conf)(context.Background(), &fakePredictor{weight: 1})
+		So(err, ShouldBeNil)
+		So(metrics["coverage"], ShouldAlmostEqual, 2./6, 1e-9)
+		So(metrics["novelty"], ShouldAlmostEqual, (-math.Log2(3./4)-math.Log2(2./4))/2, 1e-9)
+		So(metrics["diversity"], ShouldEqual, 1)
+		// the items 6 and 1 are expected, the item 5 of user 1 is serendipitous
+		So(metrics["serendipity"], ShouldEqual, 0.25)
+
+		// the items 1 and 2 of less popularity
+		reversed, err := EvalBeyondAccuracy(conf)(context.Background(), &fakePredictor{weight: -1})
+		So(err, ShouldBeNil)
+		So(reversed["novelty"], ShouldBeGreaterThan, metrics["novelty"])
+		So(reversed["serendipity"], ShouldEqual, 0)
+
+		conf.Distance, conf.Holdout = nil, nil
+		all, err := EvalAll(EvalRegression(conf.Interactions), EvalBeyondAccuracy(conf))(context.Background(), &fakePredictor{weight: 1})
+		So(err, ShouldBeNil)
+		So(all, ShouldContainKey, "rmse")
+		So(all, ShouldContainKey, "coverage")
+		So(all, ShouldNotContainKey, "diversity")
+		So(all, ShouldNotContainKey, "serendipity")
+
+		_, err = EvalAll(EvalRegression(conf.Interactions), EvalBeyondAccuracy(BeyondAccuracyConfig{Candidates: []int{1}}))(
+			context.Background(), &fakePredictor{weight: 1})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestScheduler(t *testing.T) {
+	Convey("promote only if not regressed", t, func() {
+		var (
+			ctx     = context.Background()
+			weights = []float32{1, -1, 1}
+			runs    int
+			holdout = []rcmd.Sample{
+				{UserId: 1, ItemId: 1, Label: 0},
+				{UserId: 1, ItemId: 2, Label: 0},
+				{UserId: 1, ItemId: 3, Label: 1},
+				{UserId: 1, ItemId: 4, Label: 1},
+			}
+			registry = NewRegistry()
+			s        = &Scheduler{
+				Schedule: Every(time.Hour),
+				Train: func(_ context.Context, current rcmd.Predictor) (rcmd.Predictor, error) {
+					So(current == nil, ShouldEqual, runs == 0)
+					runs++
+					return &fakePredictor{weight: weights[runs-1]}, nil
+				},
+				Evaluate:   EvalAUC(holdout),
+				Thresholds: []Threshold{{Metric: "auc", MaxRegression: 0.01}},
+				Registry:   registry,
+			}
+		)
+		result, err := s.RunOnce(ctx)
+		So(err, ShouldBeNil)
+		So(result.Promoted, ShouldBeTrue)
+		So(result.Version.Metrics["auc"], ShouldEqual, 1)
+		So(registry.ModelVersion(), ShouldEqual, "v1")
+
+		// the reversed model has auc 0
+		result, err = s.RunOnce(ctx)
+		So(err, ShouldBeNil)
+		So(result.Promoted, ShouldBeFalse)
+		So(result.Regressions, ShouldHaveLength, 1)
+		So(registry.ModelVersion(), ShouldEqual, "v1")
+
+		result, err = s.RunOnce(ctx)
+		So(err, ShouldBeNil)
+		So(result.Promoted, ShouldBeTrue)
+		So(registry.Versions(), ShouldHaveLength, 2)
+
+		// the registry serves the current version
+		itemScores, err := rcmd.Rank(ctx, registry, 1, []int{2, 5})
+		So(err, ShouldBeNil)
+		So(itemScores[1].Score, ShouldEqual, 5)
+	})
+
+	Convey("regressions", t, func() {
+		regressed, err := regressions(
+			map[string]float64{"auc": 0.8, "logloss": 0.3},
+			map[string]float64{"auc": 0.79, "logloss": 0.5},
+			[]Threshold{{Metric: "auc", MaxRegression: 0.02}, {Metric: "logloss", MaxRegression: 0.1, LowerIsBetter: true}},
+		)
+		So(err, ShouldBeNil)
+		So(regressed, ShouldHaveLength, 1)
+		So(regressed[0], ShouldStartWith, "logloss")
+
+		_, err = regressions(map[string]float64{"auc": 0.8}, map[string]float64{}, []Threshold{{Metric: "auc"}})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("report runs to the tracker", t, func() {
+		var (
+			ctx  = context.Background()
+			root = t.TempDir()
+		)
+		tracker, err := tracking.NewFileTracker(root)
+		So(err, ShouldBeNil)
+		s := &Scheduler{
+			Schedule: Every(time.Hour),
+			Train: func(ctx context.Context, _ rcmd.Predictor) (rcmd.Predictor, error) {
+				run := tracking.FromContext(ctx)
+				So(run, ShouldNotBeNil)
+				return &fakePredictor{weight: 1}, run.LogMetrics(ctx, tracking.Metric{Key: "loss", Value: 0.5})
+			},
+			Evaluate:   EvalAUC([]rcmd.Sample{{ItemId: 1, Label: 0}, {ItemId: 2, Label: 1}}),
+			Registry:   NewRegistry(),
+			Tracker:    tracker,
+			Experiment: "retrain",
+		}
+		_, err = s.RunOnce(ctx)
+		So(err, ShouldBeNil)
+
+		runs, err := filepath.Glob(filepath.Join(root, "1", "*", "meta.yaml"))
+		So(err, ShouldBeNil)
+		So(runs, ShouldHaveLength, 1)
+		dir := filepath.Dir(runs[0])
+		for file, want := range map[string]string{"tags/promoted": "true", "tags/version": "1"} {
+			data, err := os.ReadFile(filepath.Join(dir, file))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, want)
+		}
+		for _, metric := range []string{"loss", "auc"} {
+			_, err = os.Stat(filepath.Join(dir, "metrics", metric))
+			So(err, ShouldBeNil)
+		}
+	})
+
+	Convey("trigger retrains before the schedule", t, func() {
+		var (
+			ctx, cancel = context.WithCancel(context.Background())
+			runs        = make(chan struct{}, 4)
+			s           = &Scheduler{
+				Schedule: Every(time.Hour),
+				Train: func(context.Context, rcmd.Predictor) (rcmd.Predictor, error) {
+					runs <- struct{}{}
+					return &fakePredictor{weight: 1}, nil
+				},
+				Evaluate: EvalAUC([]rcmd.Sample{{ItemId: 1, Label: 0}, {ItemId: 2, Label: 1}}),
+				Registry: NewRegistry(),
+			}
+			done = make(chan struct{})
+		)
+		go func() {
+			s.Run(ctx)
+			close(done)
+		}()
+		<-runs
+		s.Trigger()
+		select {
+		case <-runs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("trigger did not retrain")
+		}
+		cancel()
+		<-done
+		So(s.Registry.Versions(), ShouldHaveLength, 2)
+	})
+}
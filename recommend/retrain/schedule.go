@@ -0,0 +1,126 @@
+package retrain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the next run time after t
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// Every runs every d
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// cronSchedule is the standard 5 fields cron: minute hour day month weekday
+type cronSchedule struct {
+	minute, hour, day, month, weekday uint64 // bit sets
+}
+
+// ParseSchedule parses a cron-like spec:
+//
+//	@every 6h        every duration
+//	@hourly          0 * * * *
+//	@daily           0 0 * * *
+//	30 3 * * 1-5     minute hour day month weekday, with * , - and /
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case strings.HasPrefix(spec, "@every "):
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %v", spec, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("schedule %q: duration should be positive", spec)
+		}
+		return Every(d), nil
+	case spec == "@hourly":
+		spec = "0 * * * *"
+	case spec == "@daily":
+		spec = "0 0 * * *"
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: should have 5 fields", spec)
+	}
+	var (
+		s      cronSchedule
+		err    error
+		ranges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+		sets   = [5]*uint64{&s.minute, &s.hour, &s.day, &s.month, &s.weekday}
+	)
+	for i, field := range fields {
+		if *sets[i], err = parseCronField(field, ranges[i][0], ranges[i][1]); err != nil {
+			return nil, fmt.Errorf("schedule %q field %d: %v", spec, i+1, err)
+		}
+	}
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int) (set uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		var (
+			lo, hi = min, max
+			step   = 1
+			rng    = part
+		)
+		if i := strings.Index(part, "/"); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+			rng = part[:i]
+		}
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of [%d, %d]", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return
+}
+
+// Next skips the unmatched days and hours, and searches at most 4 years for
+// specs like Feb 29
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(4, 0, 0); t.Before(end); {
+		if s.day&(1<<uint(t.Day())) == 0 ||
+			s.month&(1<<uint(t.Month())) == 0 ||
+			s.weekday&(1<<uint(t.Weekday())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) != 0 {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
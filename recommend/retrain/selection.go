@@ -0,0 +1,282 @@
+package retrain
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/utils"
+	log "github.com/sirupsen/logrus"
+	"gorgonia.org/tensor"
+)
+
+// the feature groups of the SampleInfo, in the order of the sample vectors
+const (
+	UserProfileGroup  = "userProfile"
+	UserBehaviorGroup = "userBehavior"
+	ItemFeatureGroup  = "itemFeature"
+	CtxFeatureGroup   = "ctxFeature"
+)
+
+// the directions of SelectionConfig.Direction
+const (
+	// Backward starts of all the groups and removes the group of the least
+	// AUC loss until the loss is over the Tolerance
+	Backward = "backward"
+	// Forward starts of no group and adds the group of the most AUC gain
+	// until the gain is not over the Tolerance
+	Forward = "forward"
+
+	// DefaultFolds is the folds of the cross validation
+	DefaultFolds = 3
+)
+
+var featureGroups = []string{UserProfileGroup, UserBehaviorGroup, ItemFeatureGroup, CtxFeatureGroup}
+
+// groupRanges returns the ranges of si by the featureGroups
+func groupRanges(si *rcmd.SampleInfo) [][2]int {
+	return [][2]int{si.UserProfileRange, si.UserBehaviorRange, si.ItemFeatureRange, si.CtxFeatureRange}
+}
+
+// SelectionConfig of SelectFeatures, the zero values are the defaults
+type SelectionConfig struct {
+	// Direction is Backward by default
+	Direction string `json:"direction" yaml:"direction"`
+	// Folds of the cross validation, DefaultFolds if 0
+	Folds int `json:"folds" yaml:"folds"`
+	// Tolerance is the AUC traded for the fewer groups: the max loss of a
+	// removal, or the min gain of an addition
+	Tolerance float64 `json:"tolerance" yaml:"tolerance"`
+	// Seed of the folds shuffling
+	Seed int64 `json:"seed" yaml:"seed"`
+}
+
+// SelectionStep is a group added or removed by SelectFeatures
+type SelectionStep struct {
+	Group   string `json:"group"`
+	Removed bool   `json:"removed,omitempty"`
+	// AUC is the cross validation AUC of the groups after the step
+	AUC float64 `json:"auc"`
+}
+
+// SelectionResult is the recommended feature groups of SelectFeatures
+type SelectionResult struct {
+	Groups []string `json:"groups"`
+	// AUC is the cross validation AUC of the Groups
+	AUC float64 `json:"auc"`
+	// Width is the sample width of the Groups, FullWidth of all the groups
+	Width     int             `json:"width"`
+	FullWidth int             `json:"fullWidth"`
+	Steps     []SelectionStep `json:"steps"`
+}
+
+// SelectFeatures greedily adds or removes the feature groups of sample.Info
+// by the cross validation AUC of the models of fitter, and recommends the
+// groups to trim the input dimension, see SelectSample. It fits the folds of
+// every candidate group at every step, so it's an offline tool of a sample
+// of the training data. The groups of no column are never selected.
+func SelectFeatures(ctx context.Context, sample *rcmd.TrainSample, fitter rcmd.Fitter, conf SelectionConfig) (result *SelectionResult, err error) {
+	if conf.Direction == "" {
+		conf.Direction = Backward
+	}
+	if conf.Direction != Backward && conf.Direction != Forward {
+		return nil, fmt.Errorf("unknown selection direction %q", conf.Direction)
+	}
+	if conf.Folds == 0 {
+		conf.Folds = DefaultFolds
+	}
+	if conf.Folds < 2 || conf.Folds > sample.Rows {
+		return nil, fmt.Errorf("%d folds of %d samples", conf.Folds, sample.Rows)
+	}
+	if err = sample.Info.Validate(sample.XCols); err != nil {
+		return
+	}
+
+	var (
+		ranges     = groupRanges(&sample.Info)
+		candidates []string
+		selected   = make(map[string]bool)
+		fold       = makeFolds(sample.Rows, conf.Folds, conf.Seed)
+	)
+	for i, g := range featureGroups {
+		if ranges[i][1] > ranges[i][0] {
+			candidates = append(candidates, g)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no feature group to select")
+	}
+	groups := func() (gs []string) {
+		for _, g := range candidates {
+			if selected[g] {
+				gs = append(gs, g)
+			}
+		}
+		return
+	}
+
+	result = &SelectionResult{FullWidth: sample.XCols}
+	if conf.Direction == Backward {
+		for _, g := range candidates {
+			selected[g] = true
+		}
+		if result.AUC, err = crossValidate(ctx, sample, groups(), fold, conf.Folds, fitter); err != nil {
+			return nil, err
+		}
+		log.Infof("cross validation auc of all the groups %v: %.4f", candidates, result.AUC)
+	} else {
+		// of no feature the scores are random
+		result.AUC = 0.5
+	}
+
+	// at least a group is kept
+	for conf.Direction == Forward || len(groups()) > 1 {
+		var (
+			best    string
+			bestAUC = -1.0
+		)
+		for _, g := range candidates {
+			if selected[g] != (conf.Direction == Backward) {
+				continue
+			}
+			selected[g] = !selected[g]
+			auc, er := crossValidate(ctx, sample, groups(), fold, conf.Folds, fitter)
+			selected[g] = !selected[g]
+			if er != nil {
+				return nil, er
+			}
+			log.Infof("cross validation auc of the %s %s: %.4f", conf.Direction, g, auc)
+			if auc > bestAUC {
+				best, bestAUC = g, auc
+			}
+		}
+		if best == "" {
+			break
+		}
+		if conf.Direction == Backward && bestAUC < result.AUC-conf.Tolerance {
+			break
+		}
+		if conf.Direction == Forward && bestAUC <= result.AUC+conf.Tolerance {
+			break
+		}
+		selected[best] = !selected[best]
+		result.AUC = bestAUC
+		result.Steps = append(result.Steps, SelectionStep{Group: best, Removed: !selected[best], AUC: bestAUC})
+	}
+
+	result.Groups = groups()
+	if len(result.Groups) == 0 {
+		return nil, fmt.Errorf("no feature group gains auc over %.4f", result.AUC)
+	}
+	subset, err := SelectSample(sample, result.Groups)
+	if err != nil {
+		return nil, err
+	}
+	result.Width = subset.XCols
+	log.Infof("selected feature groups %v of width %d/%d, auc %.4f", result.Groups, result.Width, result.FullWidth, result.AUC)
+	return
+}
+
+// SelectSample returns the sample of the columns of the feature groups, the
+// SampleInfo ranges of the other groups are empty
+func SelectSample(sample *rcmd.TrainSample, groups []string) (*rcmd.TrainSample, error) {
+	if err := sample.Info.Validate(sample.XCols); err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		keep[g] = true
+	}
+	var (
+		ranges  = groupRanges(&sample.Info)
+		columns []int
+		kept    = make([][2]int, len(ranges))
+	)
+	for i, g := range featureGroups {
+		start := len(columns)
+		if keep[g] {
+			for c := ranges[i][0]; c < ranges[i][1]; c++ {
+				columns = append(columns, c)
+			}
+			delete(keep, g)
+		}
+		kept[i] = [2]int{start, len(columns)}
+	}
+	for g := range keep {
+		return nil, fmt.Errorf("unknown feature group %q", g)
+	}
+	// the id columns of the bias tables are not a feature group, always kept
+	var idRange [2]int
+	if ids := sample.Info.IdRange; ids[1] > ids[0] {
+		idRange[0] = len(columns)
+		for c := ids[0]; c < ids[1]; c++ {
+			columns = append(columns, c)
+		}
+		idRange[1] = len(columns)
+	}
+
+	subset := *sample
+	subset.XCols = len(columns)
+	subset.X = make([]float32, sample.Rows*len(columns))
+	for r := 0; r < sample.Rows; r++ {
+		row, dst := sample.X[r*sample.XCols:], subset.X[r*len(columns):]
+		for j, c := range columns {
+			dst[j] = row[c]
+		}
+	}
+	subset.Info = rcmd.SampleInfo{
+		UserProfileRange:  kept[0],
+		UserBehaviorRange: kept[1],
+		ItemFeatureRange:  kept[2],
+		CtxFeatureRange:   kept[3],
+		IdRange:           idRange,
+	}
+	return &subset, nil
+}
+
+// makeFolds returns the fold of every row, the rows are shuffled by seed
+func makeFolds(rows, folds int, seed int64) []int {
+	fold := make([]int, rows)
+	for i, r := range rand.New(rand.NewSource(seed)).Perm(rows) {
+		fold[r] = i % folds
+	}
+	return fold
+}
+
+// crossValidate returns the mean validation AUC of the folds of the models
+// fitted on the columns of groups, fold is the fold of every row
+func crossValidate(ctx context.Context, sample *rcmd.TrainSample, groups []string, fold []int, folds int, fitter rcmd.Fitter) (auc float64, err error) {
+	subset, err := SelectSample(sample, groups)
+	if err != nil {
+		return
+	}
+	cols := subset.XCols
+	for k := 0; k < folds; k++ {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		train, valid := *subset, *subset
+		train.X, train.Y, train.Rows = nil, nil, 0
+		valid.X, valid.Y, valid.Rows = nil, nil, 0
+		for r, f := range fold {
+			part := &train
+			if f == k {
+				part = &valid
+			}
+			part.X = append(part.X, subset.X[r*cols:(r+1)*cols]...)
+			part.Y = append(part.Y, subset.Y[r])
+			part.Rows++
+		}
+		pred, er := fitter.Fit(&train)
+		if er != nil {
+			return 0, fmt.Errorf("fit fold %d of %v: %v", k, groups, er)
+		}
+		y := pred.Predict(tensor.New(tensor.WithShape(valid.Rows, cols), tensor.WithBacking(valid.X)))
+		if y == nil {
+			return 0, fmt.Errorf("no prediction of fold %d of %v", k, groups)
+		}
+		auc += float64(utils.RocAuc32(y.Data().([]float32), valid.Y))
+	}
+	return auc / float64(folds), nil
+}
@@ -0,0 +1,124 @@
+package retrain
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// meanDiffFitter scores by the weights of the mean differences of the
+// positive and negative samples of every column
+type meanDiffFitter struct {
+	fits int
+}
+
+type linearScorer []float32
+
+func (w linearScorer) Predict(X tensor.Tensor) tensor.Tensor {
+	rows, x := X.Shape()[0], X.Data().([]float32)
+	y := make([]float32, rows)
+	for i := range y {
+		for j, wj := range w {
+			y[i] += wj * x[i*len(w)+j]
+		}
+	}
+	return tensor.New(tensor.WithShape(rows, 1), tensor.WithBacking(y))
+}
+
+func (f *meanDiffFitter) Fit(sample *rcmd.TrainSample) (rcmd.PredictAbstract, error) {
+	f.fits++
+	var (
+		w     = make(linearScorer, sample.XCols)
+		count [2]float32
+	)
+	for i := 0; i < sample.Rows; i++ {
+		count[int(sample.Y[i])]++
+	}
+	for i := 0; i < sample.Rows; i++ {
+		label := int(sample.Y[i])
+		for j := range w {
+			v := sample.X[i*sample.XCols+j] / count[label]
+			if label == 0 {
+				v = -v
+			}
+			w[j] += v
+		}
+	}
+	return w, nil
+}
+
+func TestSelectFeatures(t *testing.T) {
+	// the user profile and the item feature are of the label, the user
+	// behaviors and the context are noise
+	info, err := rcmd.NewSampleInfoBuilder().UserProfile(1).UserBehavior(2, 2).ItemFeature(1).CtxFeature(1).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		rnd    = rand.New(rand.NewSource(1))
+		rows   = 600
+		sample = &rcmd.TrainSample{Rows: rows, XCols: 7, Info: *info}
+	)
+	for i := 0; i < rows; i++ {
+		label := float32(i % 2)
+		sample.Y = append(sample.Y, label)
+		sample.X = append(sample.X, label+float32(rnd.NormFloat64()))
+		for j := 0; j < 4; j++ {
+			sample.X = append(sample.X, float32(rnd.NormFloat64()))
+		}
+		sample.X = append(sample.X, label+float32(rnd.NormFloat64()), float32(rnd.NormFloat64()))
+	}
+
+	Convey("the groups of the label selected", t, func() {
+		for _, direction := range []string{Backward, Forward} {
+			result, err := SelectFeatures(context.Background(), sample, &meanDiffFitter{}, SelectionConfig{Direction: direction})
+			So(err, ShouldBeNil)
+			So(result.Groups, ShouldResemble, []string{UserProfileGroup, ItemFeatureGroup})
+			So(result.Width, ShouldEqual, 2)
+			So(result.FullWidth, ShouldEqual, 7)
+			So(result.AUC, ShouldBeGreaterThan, 0.8)
+			So(result.Steps, ShouldHaveLength, 2)
+			So(result.Steps[1].AUC, ShouldEqual, result.AUC)
+			So(result.Steps[0].Removed, ShouldEqual, direction == Backward)
+		}
+
+		// trading all the auc for the fewest groups
+		fitter := &meanDiffFitter{}
+		result, err := SelectFeatures(context.Background(), sample, fitter, SelectionConfig{Tolerance: 1, Folds: 2})
+		So(err, ShouldBeNil)
+		So(result.Groups, ShouldHaveLength, 1)
+		So(result.Steps, ShouldHaveLength, 3)
+		// all the groups, then 4, 3 and 2 candidates of 2 folds
+		So(fitter.fits, ShouldEqual, 2*(1+4+3+2))
+	})
+
+	Convey("sample of the groups", t, func() {
+		subset, err := SelectSample(sample, []string{ItemFeatureGroup, UserBehaviorGroup})
+		So(err, ShouldBeNil)
+		So(subset.XCols, ShouldEqual, 5)
+		So(subset.X[:5], ShouldResemble, sample.X[1:6])
+		So(subset.Y, ShouldResemble, sample.Y)
+		So(subset.Info, ShouldResemble, rcmd.SampleInfo{
+			UserProfileRange:  [2]int{0, 0},
+			UserBehaviorRange: [2]int{0, 4},
+			ItemFeatureRange:  [2]int{4, 5},
+			CtxFeatureRange:   [2]int{5, 5},
+		})
+		So(subset.Info.Validate(subset.XCols), ShouldBeNil)
+
+		_, err = SelectSample(sample, []string{"itemId"})
+		So(err, ShouldNotBeNil)
+		_, err = SelectFeatures(context.Background(), sample, &meanDiffFitter{}, SelectionConfig{Direction: "random"})
+		So(err, ShouldNotBeNil)
+		_, err = SelectFeatures(context.Background(), sample, &meanDiffFitter{}, SelectionConfig{Folds: 1})
+		So(err, ShouldNotBeNil)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = SelectFeatures(ctx, sample, &meanDiffFitter{}, SelectionConfig{})
+		So(err, ShouldEqual, context.Canceled)
+	})
+}
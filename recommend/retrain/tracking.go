@@ -0,0 +1,93 @@
+package retrain
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"github.com/auxten/go-ctr/recommend/tracking"
+	log "github.com/sirupsen/logrus"
+)
+
+// runTracker reports a retraining run to the Scheduler.Tracker, the tracker
+// errors are logged but never fail the retraining
+type runTracker struct {
+	run tracking.Run
+}
+
+func (s *Scheduler) startTracking(ctx context.Context) *runTracker {
+	if s.Tracker == nil {
+		return nil
+	}
+	run, err := s.Tracker.StartRun(ctx, s.Experiment, "", nil)
+	if err != nil {
+		log.Warnf("start tracking run: %v", err)
+		return nil
+	}
+	return &runTracker{run: run}
+}
+
+func (t *runTracker) warn(what string, err error) {
+	if err != nil {
+		log.Warnf("tracking run %s %s: %v", t.run.Id(), what, err)
+	}
+}
+
+// trained logs the lineage of predictor if it's a rcmd.ManifestProvider
+func (t *runTracker) trained(ctx context.Context, predictor rcmd.Predictor) {
+	if t == nil {
+		return
+	}
+	provider, ok := predictor.(rcmd.ManifestProvider)
+	if !ok || provider.Manifest() == nil {
+		return
+	}
+	manifest := provider.Manifest()
+	t.warn("log params", t.run.LogParams(ctx, map[string]string{
+		"samples":      strconv.Itoa(manifest.Samples),
+		"positives":    strconv.Itoa(manifest.Positives),
+		"featureHash":  manifest.FeatureHash,
+		"codeVersion":  manifest.CodeVersion,
+		"minTimestamp": strconv.FormatInt(manifest.MinTimestamp, 10),
+		"maxTimestamp": strconv.FormatInt(manifest.MaxTimestamp, 10),
+	}))
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		t.warn("marshal manifest", err)
+		return
+	}
+	t.warn("log manifest", t.run.LogArtifact(ctx, "manifest.json", data))
+}
+
+func (t *runTracker) evaluated(ctx context.Context, metrics map[string]float64) {
+	if t == nil {
+		return
+	}
+	t.warn("log metrics", t.run.LogMetrics(ctx, tracking.Metrics(metrics, 0)...))
+}
+
+// end tags the run by the decision of result and ends it, failed by err
+func (t *runTracker) end(ctx context.Context, result RunResult, err error) {
+	if t == nil {
+		return
+	}
+	status := tracking.StatusFinished
+	tags := map[string]string{
+		"promoted": strconv.FormatBool(result.Promoted),
+		"canary":   strconv.FormatBool(result.Canary),
+	}
+	if result.Version != nil && result.Promoted {
+		tags["version"] = strconv.Itoa(result.Version.Id)
+	}
+	if len(result.Regressions) != 0 {
+		tags["regressions"] = strings.Join(result.Regressions, "; ")
+	}
+	if err != nil {
+		status = tracking.StatusFailed
+		tags["error"] = err.Error()
+	}
+	t.warn("set tags", t.run.SetTags(ctx, tags))
+	t.warn("end", t.run.End(ctx, status))
+}
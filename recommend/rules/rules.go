@@ -0,0 +1,247 @@
+// Package rules is the rule based boost/bury/block rerank engine. Rules are
+// loaded from a JSON/YAML file or a DB table and hot reloaded on change.
+package rules
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+type Action string
+
+const (
+	// Boost raises the score, Multiply >= 1 and Add >= 0
+	Boost Action = "boost"
+	// Bury lowers the score, Multiply <= 1 and Add <= 0
+	Bury Action = "bury"
+	// Block removes the item from the result
+	Block Action = "block"
+)
+
+// Rule adjusts the score of the items matching ItemAttributes for the users
+// in UserSegments: score = score * Multiply + Add
+type Rule struct {
+	Name   string `json:"name" yaml:"name"`
+	Action Action `json:"action" yaml:"action"`
+	// ItemAttributes matches the items having all the attributes, empty
+	// matches all items
+	ItemAttributes map[string]string `json:"itemAttributes" yaml:"itemAttributes"`
+	// UserSegments matches the users in any of the segments, empty matches
+	// all users
+	UserSegments []string `json:"userSegments" yaml:"userSegments"`
+	// Multiply is the multiplicative adjustment, 0 means 1
+	Multiply float32 `json:"multiply" yaml:"multiply"`
+	// Add is the additive adjustment applied after Multiply
+	Add float32 `json:"add" yaml:"add"`
+}
+
+// Validate checks the rule is well-formed
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule name is empty")
+	}
+	multiply := r.multiply()
+	switch r.Action {
+	case Boost:
+		if multiply < 1 || r.Add < 0 {
+			return fmt.Errorf("boost rule %s lowers the score: multiply %v, add %v", r.Name, multiply, r.Add)
+		}
+	case Bury:
+		if multiply > 1 || multiply < 0 || r.Add > 0 {
+			return fmt.Errorf("bury rule %s raises the score: multiply %v, add %v", r.Name, multiply, r.Add)
+		}
+	case Block:
+	default:
+		return fmt.Errorf("rule %s has unknown action %q", r.Name, r.Action)
+	}
+	return nil
+}
+
+func (r *Rule) multiply() float32 {
+	if r.Multiply == 0 {
+		return 1
+	}
+	return r.Multiply
+}
+
+func (r *Rule) matchUser(segments map[string]struct{}) bool {
+	if len(r.UserSegments) == 0 {
+		return true
+	}
+	for _, s := range r.UserSegments {
+		if _, ok := segments[s]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchItem(attributes map[string]string) bool {
+	for k, v := range r.ItemAttributes {
+		if attributes[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ItemAttributer provides the item attributes matched by the rules
+type ItemAttributer interface {
+	GetItemAttributes(ctx context.Context, itemId int) (map[string]string, error)
+}
+
+// UserSegmenter provides the segments of the user matched by the rules
+type UserSegmenter interface {
+	GetUserSegments(ctx context.Context, userId int) ([]string, error)
+}
+
+// Source loads the rules, version changes whenever the rules change
+type Source interface {
+	Load(ctx context.Context) (rules []Rule, version string, err error)
+}
+
+// Engine applies the rules loaded from the Source
+type Engine struct {
+	source Source
+
+	mu      sync.RWMutex
+	rules   []Rule
+	version string
+}
+
+// NewEngine creates the Engine and loads the rules from source
+func NewEngine(ctx context.Context, source Source) (e *Engine, err error) {
+	e = &Engine{source: source}
+	if _, err = e.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Rules returns the rules in use and their version
+func (e *Engine) Rules() ([]Rule, string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rules, e.version
+}
+
+// Reload loads the rules if the source version changed. On any invalid rule
+// the rules in use are kept.
+func (e *Engine) Reload(ctx context.Context) (changed bool, err error) {
+	rules, version, err := e.source.Load(ctx)
+	if err != nil {
+		return
+	}
+	if _, current := e.Rules(); current == version && current != "" {
+		return false, nil
+	}
+	for i := range rules {
+		if err = rules[i].Validate(); err != nil {
+			return
+		}
+	}
+	e.mu.Lock()
+	e.rules, e.version = rules, version
+	e.mu.Unlock()
+	log.Infof("loaded %d rerank rules, version %s", len(rules), version)
+	return true, nil
+}
+
+// Watch reloads the rules every interval until ctx is done
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := e.Reload(ctx); err != nil {
+					log.Errorf("reload rerank rules error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// ReRank applies the rules on itemScores of userId, the blocked items are
+// removed, the order of the rest is kept. segmenter could be nil if no rule
+// matches user segments. The rules matched are recorded by
+// rcmd.RecordRuleHit.
+func (e *Engine) ReRank(ctx context.Context, attributer ItemAttributer, segmenter UserSegmenter,
+	userId int, itemScores []rcmd.ItemScore,
+) (result []rcmd.ItemScore, err error) {
+	rules, _ := e.Rules()
+	if len(rules) == 0 {
+		return itemScores, nil
+	}
+	segments := make(map[string]struct{})
+	if segmenter != nil {
+		var segs []string
+		if segs, err = segmenter.GetUserSegments(ctx, userId); err != nil {
+			return
+		}
+		for _, s := range segs {
+			segments[s] = struct{}{}
+		}
+	}
+	var userRules []*Rule
+	for i := range rules {
+		if rules[i].matchUser(segments) {
+			userRules = append(userRules, &rules[i])
+		}
+	}
+	if len(userRules) == 0 {
+		return itemScores, nil
+	}
+	if attributer == nil {
+		return nil, fmt.Errorf("%d rules of the user of no item attributer", len(userRules))
+	}
+
+	result = make([]rcmd.ItemScore, 0, len(itemScores))
+	for _, is := range itemScores {
+		var attributes map[string]string
+		if attributes, err = attributer.GetItemAttributes(ctx, is.ItemId); err != nil {
+			return nil, err
+		}
+		blocked := false
+		for _, r := range userRules {
+			if !r.matchItem(attributes) {
+				continue
+			}
+			rcmd.RecordRuleHit(ctx, r.Name, string(r.Action), is.ItemId)
+			if r.Action == Block {
+				blocked = true
+				break
+			}
+			is.Score = is.Score*r.multiply() + r.Add
+		}
+		if !blocked {
+			result = append(result, is)
+		}
+	}
+	return
+}
+
+// ReRanker returns the rcmd.ReRanker of the engine of the item attributes
+// of attributer and the user segments of segmenter, e.g. of
+// rcmd.WithReRankers
+func (e *Engine) ReRanker(attributer ItemAttributer, segmenter UserSegmenter) rcmd.ReRanker {
+	return &reRanker{engine: e, attributer: attributer, segmenter: segmenter}
+}
+
+type reRanker struct {
+	engine     *Engine
+	attributer ItemAttributer
+	segmenter  UserSegmenter
+}
+
+func (r *reRanker) ReRank(ctx context.Context, userId int, itemScores []rcmd.ItemScore) ([]rcmd.ItemScore, error) {
+	return r.engine.ReRank(ctx, r.attributer, r.segmenter, userId, itemScores)
+}
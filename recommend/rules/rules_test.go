@@ -0,0 +1,125 @@
+package rules
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeCatalog map[int]map[string]string
+
+func (c fakeCatalog) GetItemAttributes(_ context.Context, itemId int) (map[string]string, error) {
+	return c[itemId], nil
+}
+
+type fakeSegmenter map[int][]string
+
+func (s fakeSegmenter) GetUserSegments(_ context.Context, userId int) ([]string, error) {
+	return s[userId], nil
+}
+
+const yamlRules = `
+- name: boost comedy
+  action: boost
+  itemAttributes:
+    genre: comedy
+  multiply: 2
+- name: bury old for new users
+  action: bury
+  userSegments: [new]
+  itemAttributes:
+    year: "1990"
+  add: -0.1
+- name: block adult
+  action: block
+  itemAttributes:
+    adult: "true"
+`
+
+func TestEngine(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		catalog = fakeCatalog{
+			1: {"genre": "comedy"},
+			2: {"genre": "drama", "year": "1990"},
+			3: {"genre": "comedy", "adult": "true"},
+		}
+		segmenter = fakeSegmenter{7: {"new"}}
+		scores    = []rcmd.ItemScore{{ItemId: 1, Score: 0.2}, {ItemId: 2, Score: 0.5}, {ItemId: 3, Score: 0.9}}
+		path      = filepath.Join(t.TempDir(), "rules.yaml")
+	)
+	if err := os.WriteFile(path, []byte(yamlRules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("rerank with yaml rules", t, func() {
+		e, err := NewEngine(ctx, &FileSource{Path: path})
+		So(err, ShouldBeNil)
+		rules, _ := e.Rules()
+		So(rules, ShouldHaveLength, 3)
+
+		result, err := e.ReRank(ctx, catalog, segmenter, 7, scores)
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, 2)
+		So(result[0].Score, ShouldAlmostEqual, 0.4, 1e-6)
+		So(result[1].Score, ShouldAlmostEqual, 0.4, 1e-6)
+
+		// user not in the "new" segment
+		result, err = e.ReRank(ctx, catalog, segmenter, 8, scores)
+		So(err, ShouldBeNil)
+		So(result[1].Score, ShouldAlmostEqual, 0.5, 1e-6)
+
+		result, err = e.ReRanker(catalog, segmenter).ReRank(ctx, 7, scores)
+		So(err, ShouldBeNil)
+		So(result, ShouldHaveLength, 2)
+		_, err = e.ReRanker(nil, segmenter).ReRank(ctx, 7, scores)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("hot reload keeps the rules on invalid change", t, func() {
+		e, err := NewEngine(ctx, &FileSource{Path: path})
+		So(err, ShouldBeNil)
+		changed, err := e.Reload(ctx)
+		So(err, ShouldBeNil)
+		So(changed, ShouldBeFalse)
+
+		So(os.WriteFile(path, []byte(`[{"name": "bad", "action": "boost", "multiply": 0.5}]`), 0644), ShouldBeNil)
+		_, err = e.Reload(ctx)
+		So(err, ShouldNotBeNil)
+		rules, _ := e.Rules()
+		So(rules, ShouldHaveLength, 3)
+
+		jsonPath := filepath.Join(t.TempDir(), "rules.json")
+		So(os.WriteFile(jsonPath, []byte(`[{"name": "block all", "action": "block"}]`), 0644), ShouldBeNil)
+		e.source = &FileSource{Path: jsonPath}
+		changed, err = e.Reload(ctx)
+		So(err, ShouldBeNil)
+		So(changed, ShouldBeTrue)
+		result, err := e.ReRank(ctx, catalog, nil, 7, scores)
+		So(err, ShouldBeNil)
+		So(result, ShouldBeEmpty)
+	})
+
+	Convey("db source", t, func() {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "rules.db"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+		_, err = db.Exec(`CREATE TABLE rerank_rules (name TEXT, action TEXT, item_attributes TEXT,
+			user_segments TEXT, score_multiply REAL, score_add REAL)`)
+		So(err, ShouldBeNil)
+		_, err = db.Exec(`INSERT INTO rerank_rules VALUES ('boost comedy', 'boost', '{"genre": "comedy"}', NULL, 1.5, NULL)`)
+		So(err, ShouldBeNil)
+
+		e, err := NewEngine(ctx, &DBSource{DB: db, Table: "rerank_rules"})
+		So(err, ShouldBeNil)
+		result, err := e.ReRank(ctx, catalog, nil, 7, scores[:1])
+		So(err, ShouldBeNil)
+		So(result[0].Score, ShouldAlmostEqual, 0.3, 1e-6)
+	})
+}
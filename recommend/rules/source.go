@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// contentVersion is the version of the rules by their content
+func contentVersion(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileSource loads the rules from a JSON file, or a YAML file if the path
+// ends with .yaml or .yml. The file holds a list of Rule.
+type FileSource struct {
+	Path string
+}
+
+func (s *FileSource) Load(_ context.Context) (rules []Rule, version string, err error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(filepath.Ext(s.Path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &rules)
+	default:
+		err = json.Unmarshal(data, &rules)
+	}
+	if err != nil {
+		err = fmt.Errorf("parse rules file %s: %v", s.Path, err)
+		return
+	}
+	return rules, contentVersion(data), nil
+}
+
+// DBSource loads the rules from the table of the columns:
+//
+//	name TEXT, action TEXT,
+//	item_attributes TEXT, -- json object, could be NULL
+//	user_segments TEXT,   -- json array, could be NULL
+//	score_multiply REAL, score_add REAL
+type DBSource struct {
+	DB    *sql.DB
+	Table string
+}
+
+func (s *DBSource) Load(ctx context.Context) (rules []Rule, version string, err error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT name, action, item_attributes, user_segments, score_multiply, score_add FROM %s ORDER BY name",
+		s.Table))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			r                   Rule
+			action              string
+			itemAttrs, userSegs sql.NullString
+			multiply, add       sql.NullFloat64
+		)
+		if err = rows.Scan(&r.Name, &action, &itemAttrs, &userSegs, &multiply, &add); err != nil {
+			return
+		}
+		r.Action = Action(action)
+		if itemAttrs.Valid && itemAttrs.String != "" {
+			if err = json.Unmarshal([]byte(itemAttrs.String), &r.ItemAttributes); err != nil {
+				err = fmt.Errorf("rule %s item_attributes: %v", r.Name, err)
+				return
+			}
+		}
+		if userSegs.Valid && userSegs.String != "" {
+			if err = json.Unmarshal([]byte(userSegs.String), &r.UserSegments); err != nil {
+				err = fmt.Errorf("rule %s user_segments: %v", r.Name, err)
+				return
+			}
+		}
+		r.Multiply = float32(multiply.Float64)
+		r.Add = float32(add.Float64)
+		rules = append(rules, r)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return
+	}
+	return rules, contentVersion(data), nil
+}
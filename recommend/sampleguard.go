@@ -0,0 +1,89 @@
+package recommend
+
+import (
+	"context"
+)
+
+// SampleOptions are the leakage guards of GetSample, the zero value keeps
+// every generated sample as is
+type SampleOptions struct {
+	// Dedup drops the duplicated (user, item, timestamp) samples, the first
+	// one generated is kept
+	Dedup bool
+	// PointInTime gets the user behaviors strictly before the sample
+	// timestamp, so the behavior of the label event itself is excluded even
+	// if the UserBehavior includes maxTs
+	PointInTime bool
+	// TrainUntil is the train/test time boundary, the samples at or after it
+	// are dropped and left for the test. 0 disables it.
+	TrainUntil int64
+	// Normalize is the method of the Normalizer fit by Train, e.g.
+	// NormalizeStandard. Empty disables the normalization.
+	Normalize string
+	// Biases appends the columns of the bias table rows of the sample user
+	// and item to the vectors, SampleInfo.IdRange, see BiasBuckets. nil
+	// disables the biases.
+	Biases *BiasConfig
+}
+
+// SampleOptioner could be implemented by the RecSys to set the SampleOptions
+// of GetSample
+type SampleOptioner interface {
+	SampleOptions() SampleOptions
+}
+
+type trainUntilKey struct{}
+
+// WithTrainUntil returns the ctx of which Train drops the samples at or
+// after ts, overriding SampleOptions.TrainUntil, e.g. to train the models
+// as of the days of a backtest
+func WithTrainUntil(ctx context.Context, ts int64) context.Context {
+	return context.WithValue(ctx, trainUntilKey{}, ts)
+}
+
+// DroppedSamples counts the samples dropped by the SampleOptions
+type DroppedSamples struct {
+	Duplicated    int `json:"duplicated"`
+	AfterBoundary int `json:"afterBoundary"`
+}
+
+type sampleKey struct {
+	userId, itemId int
+	timestamp      int64
+}
+
+// guardSamples filters the samples of in by opts, dropped is ready after the
+// returned channel is closed
+func guardSamples(ctx context.Context, in <-chan Sample, opts SampleOptions, dropped *DroppedSamples) <-chan Sample {
+	if !opts.Dedup && opts.TrainUntil == 0 {
+		return in
+	}
+	out := make(chan Sample, cap(in))
+	go func() {
+		defer close(out)
+		var seen map[sampleKey]struct{}
+		if opts.Dedup {
+			seen = make(map[sampleKey]struct{})
+		}
+		for s := range in {
+			if opts.TrainUntil != 0 && s.Timestamp >= opts.TrainUntil {
+				dropped.AfterBoundary++
+				continue
+			}
+			if seen != nil {
+				key := sampleKey{userId: s.UserId, itemId: s.ItemId, timestamp: s.Timestamp}
+				if _, ok := seen[key]; ok {
+					dropped.Duplicated++
+					continue
+				}
+				seen[key] = struct{}{}
+			}
+			select {
+			case out <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
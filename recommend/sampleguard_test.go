@@ -0,0 +1,88 @@
+package recommend
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// guardedRecSys generates a duplicated sample and a sample after 103
+type guardedRecSys struct {
+	fakeProvider
+	opts SampleOptions
+
+	mu    sync.Mutex
+	maxTs []int64
+}
+
+func (r *guardedRecSys) SampleGenerator(context.Context) (<-chan Sample, error) {
+	ch := make(chan Sample, 5)
+	for _, s := range []Sample{
+		{UserId: 1, ItemId: 1, Label: 1, Timestamp: 101},
+		{UserId: 1, ItemId: 1, Label: 1, Timestamp: 101},
+		{UserId: 1, ItemId: 1, Label: 0, Timestamp: 102},
+		{UserId: 2, ItemId: 1, Label: 0, Timestamp: 102},
+		{UserId: 2, ItemId: 2, Label: 1, Timestamp: 103},
+	} {
+		ch <- s
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (r *guardedRecSys) SampleOptions() SampleOptions {
+	return r.opts
+}
+
+func (r *guardedRecSys) GetUserBehavior(_ context.Context, _ int, _ int64, _ int64, maxTs int64) ([]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxTs = append(r.maxTs, maxTs)
+	return []int{1}, nil
+}
+
+func TestSampleGuard(t *testing.T) {
+	userCache, itemCache := UserFeatureCache, ItemFeatureCache
+	defer func() {
+		UserFeatureCache, ItemFeatureCache = userCache, itemCache
+	}()
+
+	Convey("no guard by default", t, func() {
+		sample, err := GetSample(&guardedRecSys{}, context.Background())
+		So(err, ShouldBeNil)
+		So(sample.Rows, ShouldEqual, 5)
+		So(sample.Dropped, ShouldResemble, DroppedSamples{})
+	})
+
+	Convey("dedup and train/test boundary", t, func() {
+		sample, err := GetSample(&guardedRecSys{opts: SampleOptions{Dedup: true, TrainUntil: 103}}, context.Background())
+		So(err, ShouldBeNil)
+		So(sample.Rows, ShouldEqual, 3)
+		So(sample.MaxTimestamp, ShouldEqual, 102)
+		So(sample.Dropped, ShouldResemble, DroppedSamples{Duplicated: 1, AfterBoundary: 1})
+	})
+
+	Convey("train/test boundary of the ctx", t, func() {
+		sample, err := GetSample(&guardedRecSys{opts: SampleOptions{TrainUntil: 103}}, WithTrainUntil(context.Background(), 102))
+		So(err, ShouldBeNil)
+		So(sample.Rows, ShouldEqual, 2)
+		So(sample.Dropped, ShouldResemble, DroppedSamples{AfterBoundary: 3})
+	})
+
+	Convey("point in time user behaviors", t, func() {
+		itemEmbeddingMap = word2vec.EmbeddingMap32{"1": make([]float32, ItemEmbDim)}
+		defer func() { itemEmbeddingMap = nil }()
+
+		recSys := &guardedRecSys{}
+		_, err := NewFeatureAssembler(recSys, nil, nil).Record(context.Background(), &Sample{UserId: 1, ItemId: 1, Timestamp: 101})
+		So(err, ShouldBeNil)
+		a := NewFeatureAssembler(recSys, nil, nil)
+		a.pointInTime = true
+		_, err = a.Record(context.Background(), &Sample{UserId: 1, ItemId: 1, Timestamp: 101})
+		So(err, ShouldBeNil)
+		So(recSys.maxTs, ShouldResemble, []int64{101, 100})
+	})
+}
@@ -0,0 +1,161 @@
+package recommend
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// SampleInfoBuilder derives the SampleInfo ranges from the feature widths.
+// The fields are laid out in the order of FeatureAssembler:
+//
+//	user profile | user behaviors | item feature | context feature | ids
+type SampleInfoBuilder struct {
+	uProfileDim, uBehaviorSize, uBehaviorDim int
+	iFeatureDim                              int
+	cFeatureDim                              int
+	idDim                                    int
+	err                                      error
+}
+
+func NewSampleInfoBuilder() *SampleInfoBuilder {
+	return &SampleInfoBuilder{}
+}
+
+func (b *SampleInfoBuilder) setWidth(name string, width int, dst *int) *SampleInfoBuilder {
+	if width < 0 && b.err == nil {
+		b.err = fmt.Errorf("%s width %d should not be negative", name, width)
+	}
+	*dst = width
+	return b
+}
+
+// UserProfile sets the width of the user profile feature
+func (b *SampleInfoBuilder) UserProfile(width int) *SampleInfoBuilder {
+	return b.setWidth("user profile", width, &b.uProfileDim)
+}
+
+// UserBehavior sets the user behavior sequence of size items of dim
+func (b *SampleInfoBuilder) UserBehavior(size, dim int) *SampleInfoBuilder {
+	b.setWidth("user behavior size", size, &b.uBehaviorSize)
+	return b.setWidth("user behavior dim", dim, &b.uBehaviorDim)
+}
+
+// ItemFeature sets the width of the item feature
+func (b *SampleInfoBuilder) ItemFeature(width int) *SampleInfoBuilder {
+	return b.setWidth("item feature", width, &b.iFeatureDim)
+}
+
+// CtxFeature sets the width of the context feature
+func (b *SampleInfoBuilder) CtxFeature(width int) *SampleInfoBuilder {
+	return b.setWidth("context feature", width, &b.cFeatureDim)
+}
+
+// Ids sets the width of the id columns, e.g. BiasColumns of the bias table
+// rows
+func (b *SampleInfoBuilder) Ids(width int) *SampleInfoBuilder {
+	return b.setWidth("ids", width, &b.idDim)
+}
+
+// Build returns the validated SampleInfo
+func (b *SampleInfoBuilder) Build() (si *SampleInfo, err error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	var (
+		upEnd = b.uProfileDim
+		ubEnd = upEnd + b.uBehaviorSize*b.uBehaviorDim
+		ifEnd = ubEnd + b.iFeatureDim
+		cfEnd = ifEnd + b.cFeatureDim
+		idEnd = cfEnd + b.idDim
+	)
+	si = &SampleInfo{
+		UserProfileRange:  [2]int{0, upEnd},
+		UserBehaviorRange: [2]int{upEnd, ubEnd},
+		ItemFeatureRange:  [2]int{ubEnd, ifEnd},
+		CtxFeatureRange:   [2]int{ifEnd, cfEnd},
+	}
+	if b.idDim != 0 {
+		si.IdRange = [2]int{cfEnd, idEnd}
+	}
+	if err = si.Validate(idEnd); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Width is the sample vector width covered by the ranges
+func (si *SampleInfo) Width() int {
+	width := 0
+	for _, r := range si.ranges() {
+		if r.rng[1] > width {
+			width = r.rng[1]
+		}
+	}
+	return width
+}
+
+type namedRange struct {
+	name string
+	rng  [2]int
+}
+
+func (si *SampleInfo) ranges() []namedRange {
+	return []namedRange{
+		{"UserProfileRange", si.UserProfileRange},
+		{"UserBehaviorRange", si.UserBehaviorRange},
+		{"ItemFeatureRange", si.ItemFeatureRange},
+		{"CtxFeatureRange", si.CtxFeatureRange},
+		{"IdRange", si.IdRange},
+	}
+}
+
+// Validate checks the ranges are valid [start, end), not overlapped and
+// cover the sample vector of width without gap.
+func (si *SampleInfo) Validate(width int) (err error) {
+	ranges := si.ranges()
+	for _, r := range ranges {
+		if r.rng[0] < 0 || r.rng[1] < r.rng[0] {
+			return fmt.Errorf("%s %v is not a valid [start, end)", r.name, r.rng)
+		}
+	}
+	// empty ranges go first to not be taken as overlapped
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].rng[0] != ranges[j].rng[0] {
+			return ranges[i].rng[0] < ranges[j].rng[0]
+		}
+		return ranges[i].rng[1] < ranges[j].rng[1]
+	})
+	end := 0
+	for i, r := range ranges {
+		if r.rng[0] < end {
+			return fmt.Errorf("%s %v overlaps %s %v", r.name, r.rng, ranges[i-1].name, ranges[i-1].rng)
+		}
+		if r.rng[0] > end {
+			return fmt.Errorf("gap [%d, %d) before %s %v", end, r.rng[0], r.name, r.rng)
+		}
+		end = r.rng[1]
+	}
+	if end != width {
+		return fmt.Errorf("sample info covers [0, %d) but sample width is %d", end, width)
+	}
+	return
+}
+
+// Marshal serializes si to json, it should be saved along with the model to
+// cut the input tensor same as the training.
+func (si *SampleInfo) Marshal() (data []byte, err error) {
+	return json.Marshal(si)
+}
+
+// NewSampleInfoFromJson deserializes and validates the SampleInfo
+func NewSampleInfoFromJson(data []byte) (si *SampleInfo, err error) {
+	si = &SampleInfo{}
+	if err = json.Unmarshal(data, si); err != nil {
+		return nil, err
+	}
+	if err = si.Validate(si.Width()); err != nil {
+		return nil, err
+	}
+	return
+}
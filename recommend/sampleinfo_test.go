@@ -0,0 +1,57 @@
+package recommend
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSampleInfo(t *testing.T) {
+	Convey("build sample info", t, func() {
+		si, err := NewSampleInfoBuilder().
+			UserProfile(5).
+			UserBehavior(3, 7).
+			ItemFeature(7).
+			CtxFeature(0).
+			Build()
+		So(err, ShouldBeNil)
+		So(*si, ShouldResemble, SampleInfo{
+			UserProfileRange:  [2]int{0, 5},
+			UserBehaviorRange: [2]int{5, 26},
+			ItemFeatureRange:  [2]int{26, 33},
+			CtxFeatureRange:   [2]int{33, 33},
+		})
+		So(si.Width(), ShouldEqual, 33)
+
+		_, err = NewSampleInfoBuilder().UserProfile(-1).Build()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("validate overlap and coverage", t, func() {
+		si := SampleInfo{
+			UserProfileRange:  [2]int{0, 5},
+			UserBehaviorRange: [2]int{4, 10},
+			ItemFeatureRange:  [2]int{10, 12},
+			CtxFeatureRange:   [2]int{12, 13},
+		}
+		So(si.Validate(13), ShouldNotBeNil)
+		si.UserBehaviorRange[0] = 6
+		So(si.Validate(13), ShouldNotBeNil)
+		si.UserBehaviorRange[0] = 5
+		So(si.Validate(13), ShouldBeNil)
+		So(si.Validate(14), ShouldNotBeNil)
+	})
+
+	Convey("json round trip", t, func() {
+		si, err := NewSampleInfoBuilder().UserProfile(2).UserBehavior(2, 2).ItemFeature(2).CtxFeature(1).Build()
+		So(err, ShouldBeNil)
+		data, err := si.Marshal()
+		So(err, ShouldBeNil)
+		si2, err := NewSampleInfoFromJson(data)
+		So(err, ShouldBeNil)
+		So(si2, ShouldResemble, si)
+
+		_, err = NewSampleInfoFromJson([]byte(`{"userProfileRange":[0,2],"userBehaviorRange":[1,6]}`))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,81 @@
+package recommend
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/auxten/go-ctr/utils/npy"
+	"gorgonia.org/tensor"
+)
+
+// WriteSampleNpz writes the dense sample in the compressed .npz format of
+// the arrays x of (Rows, XCols), y of (Rows,) and info of the SampleInfo
+// ranges (4, 2) in SampleInfo order, e.g. the evaluation set for np.load.
+// The info is of (5, 2) with the IdRange only if it's set.
+func WriteSampleNpz(w io.Writer, sample *TrainSample) error {
+	if sample.X == nil && sample.Sparse != nil {
+		return fmt.Errorf("npz of the sparse sample not supported")
+	}
+	if len(sample.X) != sample.Rows*sample.XCols || len(sample.Y) != sample.Rows {
+		return fmt.Errorf("sample of %d x and %d y of %d rows by %d cols",
+			len(sample.X), len(sample.Y), sample.Rows, sample.XCols)
+	}
+	ranges := sample.Info.ranges()
+	if sample.Info.IdRange == [2]int{} {
+		ranges = ranges[:4]
+	}
+	info := make([]int64, 0, 2*len(ranges))
+	for _, r := range ranges {
+		info = append(info, int64(r.rng[0]), int64(r.rng[1]))
+	}
+	return npy.WriteNpz(w, map[string]tensor.Tensor{
+		"x":    tensor.New(tensor.WithShape(sample.Rows, sample.XCols), tensor.WithBacking(sample.X)),
+		"y":    tensor.New(tensor.WithShape(sample.Rows), tensor.WithBacking(sample.Y)),
+		"info": tensor.New(tensor.WithShape(len(ranges), 2), tensor.WithBacking(info)),
+	}, true)
+}
+
+// ReadSampleNpz reads the sample of size bytes written by WriteSampleNpz,
+// info is optional of the arrays written elsewhere. The x and y should be
+// float32, e.g. of np.float32 in numpy.
+func ReadSampleNpz(r io.ReaderAt, size int64) (sample *TrainSample, err error) {
+	arrays, err := npy.ReadNpz(r, size)
+	if err != nil {
+		return
+	}
+	x, y := arrays["x"], arrays["y"]
+	if x == nil || y == nil || x.Dims() != 2 || y.Dims() != 1 {
+		return nil, fmt.Errorf("sample npz of no 2-D x or 1-D y")
+	}
+	sample = &TrainSample{Rows: x.Shape()[0], XCols: x.Shape()[1]}
+	var ok bool
+	if sample.X, ok = x.Data().([]float32); !ok {
+		return nil, fmt.Errorf("sample npz x of %v, not float32", x.Dtype())
+	}
+	if sample.Y, ok = y.Data().([]float32); !ok {
+		return nil, fmt.Errorf("sample npz y of %v, not float32", y.Dtype())
+	}
+	if len(sample.Y) != sample.Rows {
+		return nil, fmt.Errorf("sample npz y of %d != x rows %d", len(sample.Y), sample.Rows)
+	}
+	if info := arrays["info"]; info != nil {
+		// the info written before the IdRange is of (4, 2)
+		ranges, ok := info.Data().([]int64)
+		if !ok || len(ranges) != 8 && len(ranges) != 10 {
+			return nil, fmt.Errorf("sample npz info of %v %v, not int64 (4, 2) or (5, 2)", info.Dtype(), info.Shape())
+		}
+		for i, rng := range []*[2]int{
+			&sample.Info.UserProfileRange,
+			&sample.Info.UserBehaviorRange,
+			&sample.Info.ItemFeatureRange,
+			&sample.Info.CtxFeatureRange,
+			&sample.Info.IdRange,
+		}[:len(ranges)/2] {
+			*rng = [2]int{int(ranges[2*i]), int(ranges[2*i+1])}
+		}
+		if err = sample.Info.Validate(sample.XCols); err != nil {
+			return nil, fmt.Errorf("sample npz info: %v", err)
+		}
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package recommend
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/auxten/go-ctr/utils/npy"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+func TestSampleNpz(t *testing.T) {
+	Convey("sample npz round trip", t, func() {
+		info, err := NewSampleInfoBuilder().UserProfile(1).ItemFeature(1).CtxFeature(1).Build()
+		So(err, ShouldBeNil)
+		sample := &TrainSample{X: []float32{1, 2, 3, 4, 5, 6}, Y: []float32{0, 1}, Rows: 2, XCols: 3, Info: *info}
+		var buf bytes.Buffer
+		So(WriteSampleNpz(&buf, sample), ShouldBeNil)
+		read, err := ReadSampleNpz(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, sample)
+		// the info of no IdRange is of (4, 2) of the readers before it
+		arrays, err := npy.ReadNpz(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldBeNil)
+		So(arrays["info"].Shape(), ShouldResemble, tensor.Shape{4, 2})
+
+		ids, err := NewSampleInfoBuilder().UserProfile(1).Ids(2).Build()
+		So(err, ShouldBeNil)
+		sample = &TrainSample{X: []float32{1, 2, 3, 4, 5, 6}, Y: []float32{0, 1}, Rows: 2, XCols: 3, Info: *ids}
+		buf.Reset()
+		So(WriteSampleNpz(&buf, sample), ShouldBeNil)
+		arrays, err = npy.ReadNpz(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldBeNil)
+		So(arrays["info"].Shape(), ShouldResemble, tensor.Shape{5, 2})
+		read, err = ReadSampleNpz(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldBeNil)
+		So(read.Info.IdRange, ShouldResemble, [2]int{1, 3})
+
+		So(WriteSampleNpz(&buf, &TrainSample{Sparse: NewSparseSample(3)}), ShouldNotBeNil)
+		So(WriteSampleNpz(&buf, &TrainSample{X: []float32{1}, Rows: 2, XCols: 1}), ShouldNotBeNil)
+	})
+
+	Convey("sample npz of numpy without info", t, func() {
+		var buf bytes.Buffer
+		So(npy.WriteNpz(&buf, map[string]tensor.Tensor{
+			"x": tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float32{1, 2, 3, 4})),
+			"y": tensor.New(tensor.WithShape(2), tensor.WithBacking([]float32{1, 0})),
+		}, false), ShouldBeNil)
+		read, err := ReadSampleNpz(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldBeNil)
+		So(read.Rows, ShouldEqual, 2)
+		So(read.XCols, ShouldEqual, 2)
+
+		buf.Reset()
+		So(npy.WriteNpz(&buf, map[string]tensor.Tensor{
+			"x": tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float64{1, 2, 3, 4})),
+			"y": tensor.New(tensor.WithShape(2), tensor.WithBacking([]float32{1, 0})),
+		}, false), ShouldBeNil)
+		_, err = ReadSampleNpz(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldNotBeNil)
+	})
+}
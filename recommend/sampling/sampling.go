@@ -0,0 +1,306 @@
+// Package sampling downsamples the huge behavior tables to manageable
+// training sets: the negatives are kept at a rate, the samples of every user
+// are capped and the samples of every time stratum, e.g. a day, are capped so
+// the busy days don't dominate. The bias of the sampling is recorded in the
+// Correction of the Report. The RecSys embedding a Sampler downsamples its
+// SampleGenerator by it and is the rcmd.SamplingReporter, so rcmd.Train
+// saves the Correction in the rcmd.Manifest of the model and the scores are
+// calibrated back wherever the model is loaded with its manifest:
+//
+//	type recSys struct {
+//		*sampling.Sampler
+//		...
+//	}
+//
+//	func (r *recSys) SampleGenerator(ctx context.Context) (<-chan rcmd.Sample, error) {
+//		...
+//		return r.Downsample(ctx, samples), nil
+//	}
+//
+// Calibrated calibrates the Predictor trained without the manifest.
+package sampling
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	"gorgonia.org/tensor"
+)
+
+// Config configures the sampling, the zero value keeps every sample
+type Config struct {
+	// NegativeRate is the share of the negative samples kept, of label <= 0.5,
+	// the positives are all kept. 0 or 1 disables it.
+	NegativeRate float64
+	// MaxPerUser caps the samples of every user, chosen uniformly. 0 disables
+	// it.
+	MaxPerUser int
+	// Stratum is the time window of the stratified sampling, PerStratum
+	// samples are kept uniformly in every window of the sample timestamps,
+	// e.g. 24h. 0 of either disables it.
+	Stratum    time.Duration
+	PerStratum int
+	// Seed makes the sampling reproducible, the same samples of the same seed
+	// are kept
+	Seed int64
+}
+
+func (c Config) downsampleNegatives() bool {
+	return c.NegativeRate > 0 && c.NegativeRate < 1
+}
+
+func (c Config) stratified() bool {
+	return c.Stratum > 0 && c.PerStratum > 0
+}
+
+// Report counts the samples of the sampling
+type Report struct {
+	Input     int `json:"input"`
+	Positives int `json:"positives"`
+	// NegativesDropped, UserCapped and StratumCapped are the samples dropped
+	// by every stage, in the order applied
+	NegativesDropped int `json:"negativesDropped"`
+	UserCapped       int `json:"userCapped"`
+	StratumCapped    int `json:"stratumCapped"`
+	Output           int `json:"output"`
+	OutputPositives  int `json:"outputPositives"`
+	// Correction reverses the label bias of the sampling
+	Correction Correction `json:"correction"`
+}
+
+// Correction is the prior shift of the sampling, it's recorded in the
+// rcmd.Manifest by the Sampler
+type Correction = rcmd.Correction
+
+func odds(p float64) float64 {
+	return p / (1 - p)
+}
+
+func newCorrection(conf Config, report *Report) Correction {
+	c := Correction{NegativeRate: 1, Factor: 1}
+	if conf.downsampleNegatives() {
+		c.NegativeRate, c.Factor = conf.NegativeRate, conf.NegativeRate
+	}
+	if report.Input == 0 || report.Output == 0 {
+		return c
+	}
+	c.PositiveRate = float64(report.Positives) / float64(report.Input)
+	c.SampledPositiveRate = float64(report.OutputPositives) / float64(report.Output)
+	if c.PositiveRate > 0 && c.PositiveRate < 1 && c.SampledPositiveRate > 0 && c.SampledPositiveRate < 1 {
+		c.Factor = odds(c.PositiveRate) / odds(c.SampledPositiveRate)
+	}
+	return c
+}
+
+// Downsample samples in by conf, report is ready after the returned channel
+// is closed. The negative downsampling is streamed, the user and the stratum
+// caps keep the samples in memory till in is closed and then send the kept
+// ones in the timestamp order.
+func Downsample(ctx context.Context, in <-chan rcmd.Sample, conf Config, report *Report) <-chan rcmd.Sample {
+	out := make(chan rcmd.Sample, cap(in))
+	go func() {
+		defer close(out)
+		send := func(s rcmd.Sample) bool {
+			report.Output++
+			if s.Label > 0.5 {
+				report.OutputPositives++
+			}
+			select {
+			case out <- s:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		buffered := conf.MaxPerUser > 0 || conf.stratified()
+		var kept []rcmd.Sample
+		for s := range in {
+			report.Input++
+			if s.Label > 0.5 {
+				report.Positives++
+			} else if conf.downsampleNegatives() && uniform(conf.Seed, s) >= conf.NegativeRate {
+				report.NegativesDropped++
+				continue
+			}
+			if buffered {
+				kept = append(kept, s)
+			} else if !send(s) {
+				return
+			}
+		}
+		if buffered {
+			rng := rand.New(rand.NewSource(conf.Seed))
+			if conf.MaxPerUser > 0 {
+				before := len(kept)
+				kept = capGroups(rng, kept, conf.MaxPerUser, func(s rcmd.Sample) int64 { return int64(s.UserId) })
+				report.UserCapped = before - len(kept)
+			}
+			if conf.stratified() {
+				before := len(kept)
+				stratum := int64(conf.Stratum / time.Second)
+				if stratum <= 0 {
+					stratum = 1
+				}
+				kept = capGroups(rng, kept, conf.PerStratum, func(s rcmd.Sample) int64 { return floorDiv(s.Timestamp, stratum) })
+				report.StratumCapped = before - len(kept)
+			}
+			sort.SliceStable(kept, func(i, j int) bool { return kept[i].Timestamp < kept[j].Timestamp })
+			for _, s := range kept {
+				if !send(s) {
+					return
+				}
+			}
+		}
+		report.Correction = newCorrection(conf, report)
+	}()
+	return out
+}
+
+// Sampler downsamples the samples of a RecSys by Config, it's the
+// rcmd.SamplingReporter of the last Downsample
+type Sampler struct {
+	Config Config
+
+	mu     sync.Mutex
+	report *Report
+}
+
+// NewSampler returns the Sampler of conf
+func NewSampler(conf Config) *Sampler {
+	return &Sampler{Config: conf}
+}
+
+// Downsample is Downsample of in by the Config of s
+func (s *Sampler) Downsample(ctx context.Context, in <-chan rcmd.Sample) <-chan rcmd.Sample {
+	report := &Report{}
+	s.mu.Lock()
+	s.report = report
+	s.mu.Unlock()
+	return Downsample(ctx, in, s.Config, report)
+}
+
+// Report is the Report of the last Downsample, it's ready after its channel
+// is closed. nil before Downsample.
+func (s *Sampler) Report() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.report
+}
+
+// SamplingCorrection implements rcmd.SamplingReporter, nil before Downsample
+func (s *Sampler) SamplingCorrection() *rcmd.Correction {
+	report := s.Report()
+	if report == nil {
+		return nil
+	}
+	c := report.Correction
+	return &c
+}
+
+// Apply is Downsample of the samples slice
+func Apply(samples []rcmd.Sample, conf Config) ([]rcmd.Sample, *Report) {
+	in := make(chan rcmd.Sample, len(samples))
+	for _, s := range samples {
+		in <- s
+	}
+	close(in)
+	var (
+		report Report
+		out    []rcmd.Sample
+	)
+	for s := range Downsample(context.Background(), in, conf, &report) {
+		out = append(out, s)
+	}
+	return out, &report
+}
+
+// uniform is the pseudo random number in [0, 1) of s and seed, the same
+// sample is kept or dropped the same in every run
+func uniform(seed int64, s rcmd.Sample) float64 {
+	var buf [32]byte
+	binary.LittleEndian.PutUint64(buf[0:], uint64(seed))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(s.UserId))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(s.ItemId))
+	binary.LittleEndian.PutUint64(buf[24:], uint64(s.Timestamp))
+	h := fnv.New64a()
+	_, _ = h.Write(buf[:])
+	// the splitmix64 finalizer spreads the fnv of the close keys
+	x := h.Sum64()
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// capGroups keeps max samples chosen uniformly of every group of key, the
+// kept samples are in the order of samples
+func capGroups(rng *rand.Rand, samples []rcmd.Sample, max int, key func(rcmd.Sample) int64) []rcmd.Sample {
+	groups := make(map[int64][]int)
+	var keys []int64
+	for i, s := range samples {
+		k := key(s)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], i)
+	}
+	keep := make([]bool, len(samples))
+	// the group order is of the samples, so the draws are reproducible
+	for _, k := range keys {
+		idx := groups[k]
+		if len(idx) > max {
+			rng.Shuffle(len(idx), func(i, j int) { idx[i], idx[j] = idx[j], idx[i] })
+			idx = idx[:max]
+		}
+		for _, i := range idx {
+			keep[i] = true
+		}
+	}
+	kept := samples[:0:0]
+	for i, s := range samples {
+		if keep[i] {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// calibrated is the Predictor of the scores calibrated by the Correction
+type calibrated struct {
+	rcmd.Predictor
+	correction Correction
+}
+
+// Calibrated returns the Predictor of which the scores of p, the
+// probabilities of the model trained on the sampled set, are calibrated by c.
+// The ranking is the same, the calibration is monotonic.
+func Calibrated(p rcmd.Predictor, c Correction) rcmd.Predictor {
+	return &calibrated{Predictor: p, correction: c}
+}
+
+func (p *calibrated) Predict(X tensor.Tensor) tensor.Tensor {
+	y := p.Predictor.Predict(X)
+	scores, ok := y.Data().([]float32)
+	if !ok {
+		return y
+	}
+	calibrated := make([]float32, len(scores))
+	for i, q := range scores {
+		calibrated[i] = float32(p.correction.Calibrate(float64(q)))
+	}
+	return tensor.New(tensor.WithShape(y.Shape().Clone()...), tensor.WithBacking(calibrated))
+}
@@ -0,0 +1,138 @@
+package sampling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// constPredictor scores every sample by score
+type constPredictor struct {
+	score float32
+}
+
+func (p *constPredictor) GetUserFeature(context.Context, int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{0}, nil
+}
+
+func (p *constPredictor) GetItemFeature(context.Context, int) (rcmd.Tensor, error) {
+	return rcmd.Tensor{0}, nil
+}
+
+func (p *constPredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	y := make([]float32, X.Shape()[0])
+	for i := range y {
+		y[i] = p.score
+	}
+	return tensor.New(tensor.WithShape(len(y), 1), tensor.WithBacking(y))
+}
+
+// behaviors are the samples of 10 users, 1 positive in 10
+func behaviors(n int) (samples []rcmd.Sample) {
+	for i := 0; i < n; i++ {
+		s := rcmd.Sample{UserId: i % 10, ItemId: i, Timestamp: int64(i) * 60}
+		if i%10 == 0 {
+			s.Label = 1
+		}
+		samples = append(samples, s)
+	}
+	return
+}
+
+func TestDownsample(t *testing.T) {
+	Convey("negative downsampling", t, func() {
+		samples := behaviors(20000)
+		out, report := Apply(samples, Config{NegativeRate: 0.1, Seed: 1})
+		So(report.Input, ShouldEqual, 20000)
+		So(report.Positives, ShouldEqual, 2000)
+		So(report.OutputPositives, ShouldEqual, 2000)
+		So(report.Output, ShouldEqual, len(out))
+		So(report.NegativesDropped+report.Output, ShouldEqual, 20000)
+		So(float64(report.Output-2000), ShouldAlmostEqual, 1800, 150)
+
+		c := report.Correction
+		So(c.NegativeRate, ShouldEqual, 0.1)
+		So(c.PositiveRate, ShouldAlmostEqual, 0.1, 1e-9)
+		So(c.Factor, ShouldAlmostEqual, 0.1, 0.01)
+		// the sampled positive rate is calibrated back to the original
+		So(c.Calibrate(c.SampledPositiveRate), ShouldAlmostEqual, 0.1, 1e-9)
+		So(c.Bias(c.Calibrate(0.3)), ShouldAlmostEqual, 0.3, 1e-9)
+		So(c.Calibrate(0), ShouldEqual, 0)
+		So(Correction{}.Calibrate(0.3), ShouldEqual, 0.3)
+
+		// the same samples of the same seed
+		again, _ := Apply(samples, Config{NegativeRate: 0.1, Seed: 1})
+		So(again, ShouldResemble, out)
+		other, _ := Apply(samples, Config{NegativeRate: 0.1, Seed: 2})
+		So(other, ShouldNotResemble, out)
+	})
+
+	Convey("per user capping and time strata", t, func() {
+		samples := behaviors(1000)
+		out, report := Apply(samples, Config{MaxPerUser: 30, Seed: 1})
+		So(out, ShouldHaveLength, 300)
+		So(report.UserCapped, ShouldEqual, 700)
+		perUser := make(map[int]int)
+		for i, s := range out {
+			perUser[s.UserId]++
+			if i > 0 {
+				So(s.Timestamp, ShouldBeGreaterThanOrEqualTo, out[i-1].Timestamp)
+			}
+		}
+		So(perUser, ShouldHaveLength, 10)
+		So(perUser[3], ShouldEqual, 30)
+
+		// 1000 minutes are the strata of 6 hours: 360, 360 and 280 samples
+		out, report = Apply(samples, Config{Stratum: 6 * time.Hour, PerStratum: 100, Seed: 1})
+		So(out, ShouldHaveLength, 300)
+		So(report.StratumCapped, ShouldEqual, 700)
+		perStratum := make(map[int64]int)
+		for _, s := range out {
+			perStratum[s.Timestamp/(6*3600)]++
+		}
+		So(perStratum, ShouldResemble, map[int64]int{0: 100, 1: 100, 2: 100})
+		So(report.Correction.NegativeRate, ShouldEqual, 1)
+		So(report.Correction.Factor, ShouldBeGreaterThan, 0)
+
+		// the zero config keeps every sample as is
+		out, report = Apply(samples, Config{})
+		So(out, ShouldResemble, samples)
+		So(report.Correction.Factor, ShouldEqual, 1)
+	})
+
+	Convey("calibrated scores", t, func() {
+		c := Correction{NegativeRate: 0.1, Factor: 0.1}
+		p := Calibrated(&constPredictor{score: 0.5}, c)
+		X := tensor.New(tensor.WithShape(2, 1), tensor.WithBacking([]float32{0, 0}))
+		y := p.Predict(X).Data().([]float32)
+		So(y, ShouldHaveLength, 2)
+		So(y[0], ShouldAlmostEqual, 0.1/1.1, 1e-6)
+		scores, err := rcmd.Rank(context.Background(), p, 1, []int{1, 2})
+		So(err, ShouldBeNil)
+		So(scores[0].Score, ShouldAlmostEqual, 0.1/1.1, 1e-6)
+	})
+	Convey("sampler of the RecSys", t, func() {
+		samples := behaviors(1000)
+		s := NewSampler(Config{NegativeRate: 0.5, Seed: 1})
+		So(s.SamplingCorrection(), ShouldBeNil)
+		in := make(chan rcmd.Sample, len(samples))
+		for _, sample := range samples {
+			in <- sample
+		}
+		close(in)
+		n := 0
+		for range s.Downsample(context.Background(), in) {
+			n++
+		}
+		So(s.Report().Output, ShouldEqual, n)
+		c := s.SamplingCorrection()
+		So(c, ShouldNotBeNil)
+		So(*c, ShouldResemble, s.Report().Correction)
+		So(c.NegativeRate, ShouldEqual, 0.5)
+		var _ rcmd.SamplingReporter = s
+	})
+}
@@ -0,0 +1,129 @@
+package segment
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// KMeansSegmenter assigns the nearest centroid of the user embeddings, the
+// segments are named as "cluster_0" ... "cluster_{k-1}".
+type KMeansSegmenter struct {
+	Centroids [][]float32 `json:"centroids"`
+}
+
+// TrainKMeans clusters the embeddings into k segments with at most
+// iterations of Lloyd's algorithm, the centroids are initialized by k-means++
+// with the seed.
+func TrainKMeans(embeddings [][]float32, k, iterations int, seed int64) (s *KMeansSegmenter, err error) {
+	if k <= 0 || len(embeddings) < k {
+		return nil, fmt.Errorf("can not cluster %d embeddings into %d segments", len(embeddings), k)
+	}
+	dim := len(embeddings[0])
+	for i, e := range embeddings {
+		if len(e) != dim {
+			return nil, fmt.Errorf("embedding %d dim %d != %d", i, len(e), dim)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(seed))
+	s = &KMeansSegmenter{Centroids: initCentroids(rnd, embeddings, k)}
+	assignments := make([]int, len(embeddings))
+	for it := 0; it < iterations; it++ {
+		changed := it == 0
+		for i, e := range embeddings {
+			if c := s.nearest(e); c != assignments[i] {
+				assignments[i] = c
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		// update the centroids, empty cluster keeps its centroid
+		sums := make([][]float64, k)
+		counts := make([]int, k)
+		for i := range sums {
+			sums[i] = make([]float64, dim)
+		}
+		for i, e := range embeddings {
+			c := assignments[i]
+			counts[c]++
+			for j, v := range e {
+				sums[c][j] += float64(v)
+			}
+		}
+		for c := range sums {
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range sums[c] {
+				s.Centroids[c][j] = float32(sums[c][j] / float64(counts[c]))
+			}
+		}
+	}
+	return
+}
+
+// initCentroids is the k-means++ initialization
+func initCentroids(rnd *rand.Rand, embeddings [][]float32, k int) [][]float32 {
+	centroids := make([][]float32, 0, k)
+	first := embeddings[rnd.Intn(len(embeddings))]
+	centroids = append(centroids, append([]float32(nil), first...))
+	dists := make([]float64, len(embeddings))
+	for len(centroids) < k {
+		var total float64
+		for i, e := range embeddings {
+			d := math.MaxFloat64
+			for _, c := range centroids {
+				d = math.Min(d, squaredDistance(e, c))
+			}
+			dists[i] = d
+			total += d
+		}
+		next := len(embeddings) - 1
+		if total > 0 {
+			r := rnd.Float64() * total
+			for i, d := range dists {
+				if r -= d; r <= 0 {
+					next = i
+					break
+				}
+			}
+		} else {
+			next = rnd.Intn(len(embeddings))
+		}
+		centroids = append(centroids, append([]float32(nil), embeddings[next]...))
+	}
+	return centroids
+}
+
+func squaredDistance(x, y []float32) (d float64) {
+	for i := range x {
+		diff := float64(x[i] - y[i])
+		d += diff * diff
+	}
+	return
+}
+
+func (s *KMeansSegmenter) nearest(embedding []float32) (nearest int) {
+	best := math.MaxFloat64
+	for i, c := range s.Centroids {
+		if d := squaredDistance(embedding, c); d < best {
+			best, nearest = d, i
+		}
+	}
+	return
+}
+
+func (s *KMeansSegmenter) Segments() []string {
+	segments := make([]string, len(s.Centroids))
+	for i := range segments {
+		segments[i] = fmt.Sprintf("cluster_%d", i)
+	}
+	return segments
+}
+
+func (s *KMeansSegmenter) Assign(embedding []float32) string {
+	return fmt.Sprintf("cluster_%d", s.nearest(embedding))
+}
@@ -0,0 +1,67 @@
+package segment
+
+import (
+	"sort"
+	"sync"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+// Popularity counts the positive behaviors per segment, the top items are
+// the fallback recommendations for the users of the segment.
+type Popularity struct {
+	mu       sync.RWMutex
+	global   map[int]int
+	segments map[string]map[int]int
+}
+
+func NewPopularity() *Popularity {
+	return &Popularity{
+		global:   make(map[int]int),
+		segments: make(map[string]map[int]int),
+	}
+}
+
+// Add counts a positive behavior on itemId of a user in segment
+func (p *Popularity) Add(segment string, itemId int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.global[itemId]++
+	counts, ok := p.segments[segment]
+	if !ok {
+		counts = make(map[int]int)
+		p.segments[segment] = counts
+	}
+	counts[itemId]++
+}
+
+// Top returns the n most popular items of segment, scored by their share of
+// the segment behaviors. Segments without any behavior fall back to the
+// global popularity.
+func (p *Popularity) Top(segment string, n int) []rcmd.ItemScore {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	counts, ok := p.segments[segment]
+	if !ok {
+		counts = p.global
+	}
+	var total int
+	itemScores := make([]rcmd.ItemScore, 0, len(counts))
+	for itemId, cnt := range counts {
+		total += cnt
+		itemScores = append(itemScores, rcmd.ItemScore{ItemId: itemId, Score: float32(cnt)})
+	}
+	sort.Slice(itemScores, func(i, j int) bool {
+		if itemScores[i].Score == itemScores[j].Score {
+			return itemScores[i].ItemId < itemScores[j].ItemId
+		}
+		return itemScores[i].Score > itemScores[j].Score
+	})
+	if len(itemScores) > n {
+		itemScores = itemScores[:n]
+	}
+	for i := range itemScores {
+		itemScores[i].Score /= float32(total)
+	}
+	return itemScores
+}
@@ -0,0 +1,156 @@
+// Package segment maps users to named segments, by rules on the profile
+// features or k-means on the user embeddings. The segment could be used as a
+// one-hot feature, an A/B dimension, or the key of the per segment
+// popularity fallback.
+package segment
+
+import (
+	"context"
+	"fmt"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+// Segmenter assigns a segment to the user feature vector
+type Segmenter interface {
+	// Segments are all the segment names could be assigned
+	Segments() []string
+	// Assign returns the segment of the feature vector
+	Assign(feature []float32) string
+}
+
+// Op is the comparison of a Condition
+type Op string
+
+const (
+	Gt Op = ">"
+	Ge Op = ">="
+	Lt Op = "<"
+	Le Op = "<="
+	Eq Op = "=="
+)
+
+// Condition compares the Column of the user feature with Value
+type Condition struct {
+	Column int     `json:"column" yaml:"column"`
+	Op     Op      `json:"op" yaml:"op"`
+	Value  float32 `json:"value" yaml:"value"`
+}
+
+func (c *Condition) match(feature []float32) bool {
+	if c.Column < 0 || c.Column >= len(feature) {
+		return false
+	}
+	v := feature[c.Column]
+	switch c.Op {
+	case Gt:
+		return v > c.Value
+	case Ge:
+		return v >= c.Value
+	case Lt:
+		return v < c.Value
+	case Le:
+		return v <= c.Value
+	case Eq:
+		return v == c.Value
+	}
+	return false
+}
+
+// Rule assigns Name to the users matching all the Conditions
+type Rule struct {
+	Name       string      `json:"name" yaml:"name"`
+	Conditions []Condition `json:"conditions" yaml:"conditions"`
+}
+
+// RuleSegmenter assigns the first matched rule, or Default if none matched
+type RuleSegmenter struct {
+	Rules   []Rule `json:"rules" yaml:"rules"`
+	Default string `json:"default" yaml:"default"`
+}
+
+// NewRuleSegmenter validates the rules and creates the RuleSegmenter
+func NewRuleSegmenter(rules []Rule, defaultSegment string) (s *RuleSegmenter, err error) {
+	names := map[string]struct{}{defaultSegment: {}}
+	for _, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("segment rule name is empty")
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("duplicated segment %s", r.Name)
+		}
+		names[r.Name] = struct{}{}
+		for _, c := range r.Conditions {
+			switch c.Op {
+			case Gt, Ge, Lt, Le, Eq:
+			default:
+				return nil, fmt.Errorf("segment %s has unknown op %q", r.Name, c.Op)
+			}
+			if c.Column < 0 {
+				return nil, fmt.Errorf("segment %s has negative column %d", r.Name, c.Column)
+			}
+		}
+	}
+	return &RuleSegmenter{Rules: rules, Default: defaultSegment}, nil
+}
+
+func (s *RuleSegmenter) Segments() []string {
+	segments := make([]string, 0, len(s.Rules)+1)
+	for _, r := range s.Rules {
+		segments = append(segments, r.Name)
+	}
+	return append(segments, s.Default)
+}
+
+func (s *RuleSegmenter) Assign(feature []float32) string {
+rules:
+	for _, r := range s.Rules {
+		for i := range r.Conditions {
+			if !r.Conditions[i].match(feature) {
+				continue rules
+			}
+		}
+		return r.Name
+	}
+	return s.Default
+}
+
+// OneHot is the segment of the feature as a one-hot vector in the order of
+// s.Segments(), it could be appended to the user feature.
+func OneHot(s Segmenter, feature []float32) []float32 {
+	var (
+		segments = s.Segments()
+		segment  = s.Assign(feature)
+		vec      = make([]float32, len(segments))
+	)
+	for i, name := range segments {
+		if name == segment {
+			vec[i] = 1
+			break
+		}
+	}
+	return vec
+}
+
+// UserSegments assigns the segments of users by their features, it
+// implements rules.UserSegmenter to match the rerank rules by segment.
+type UserSegments struct {
+	Segmenter Segmenter
+	Featurer  rcmd.UserFeaturer
+}
+
+func (u *UserSegments) GetUserSegment(ctx context.Context, userId int) (segment string, err error) {
+	feature, err := u.Featurer.GetUserFeature(ctx, userId)
+	if err != nil {
+		return
+	}
+	return u.Segmenter.Assign(feature), nil
+}
+
+func (u *UserSegments) GetUserSegments(ctx context.Context, userId int) ([]string, error) {
+	segment, err := u.GetUserSegment(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return []string{segment}, nil
+}
@@ -0,0 +1,76 @@
+package segment
+
+import (
+	"context"
+	"testing"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeFeaturer map[int]rcmd.Tensor
+
+func (f fakeFeaturer) GetUserFeature(_ context.Context, userId int) (rcmd.Tensor, error) {
+	return f[userId], nil
+}
+
+func TestSegment(t *testing.T) {
+	Convey("rule segmenter", t, func() {
+		s, err := NewRuleSegmenter([]Rule{
+			{Name: "young_active", Conditions: []Condition{{Column: 0, Op: Lt, Value: 0.3}, {Column: 1, Op: Ge, Value: 0.5}}},
+			{Name: "young", Conditions: []Condition{{Column: 0, Op: Lt, Value: 0.3}}},
+		}, "other")
+		So(err, ShouldBeNil)
+		So(s.Segments(), ShouldResemble, []string{"young_active", "young", "other"})
+		So(s.Assign([]float32{0.2, 0.6}), ShouldEqual, "young_active")
+		So(s.Assign([]float32{0.2, 0.1}), ShouldEqual, "young")
+		So(s.Assign([]float32{0.5, 0.6}), ShouldEqual, "other")
+		So(OneHot(s, []float32{0.2, 0.1}), ShouldResemble, []float32{0, 1, 0})
+
+		_, err = NewRuleSegmenter([]Rule{{Name: "bad", Conditions: []Condition{{Op: "!="}}}}, "other")
+		So(err, ShouldNotBeNil)
+		_, err = NewRuleSegmenter([]Rule{{Name: "other"}}, "other")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("k-means segmenter", t, func() {
+		embeddings := [][]float32{
+			{0, 0}, {0.1, 0}, {0, 0.1},
+			{5, 5}, {5.1, 5}, {5, 5.1},
+		}
+		s, err := TrainKMeans(embeddings, 2, 10, 42)
+		So(err, ShouldBeNil)
+		So(s.Segments(), ShouldHaveLength, 2)
+		So(s.Assign([]float32{0.05, 0.05}), ShouldEqual, s.Assign(embeddings[0]))
+		So(s.Assign([]float32{4.9, 4.9}), ShouldEqual, s.Assign(embeddings[3]))
+		So(s.Assign(embeddings[0]), ShouldNotEqual, s.Assign(embeddings[3]))
+
+		_, err = TrainKMeans(embeddings, 7, 10, 42)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("user segments", t, func() {
+		s, err := NewRuleSegmenter([]Rule{{Name: "young", Conditions: []Condition{{Column: 0, Op: Lt, Value: 0.3}}}}, "other")
+		So(err, ShouldBeNil)
+		u := &UserSegments{Segmenter: s, Featurer: fakeFeaturer{1: {0.1}, 2: {0.9}}}
+		segments, err := u.GetUserSegments(context.Background(), 1)
+		So(err, ShouldBeNil)
+		So(segments, ShouldResemble, []string{"young"})
+		segment, err := u.GetUserSegment(context.Background(), 2)
+		So(err, ShouldBeNil)
+		So(segment, ShouldEqual, "other")
+	})
+
+	Convey("per segment popularity", t, func() {
+		p := NewPopularity()
+		p.Add("young", 1)
+		p.Add("young", 1)
+		p.Add("young", 2)
+		p.Add("other", 3)
+		So(p.Top("young", 1), ShouldResemble, []rcmd.ItemScore{{ItemId: 1, Score: 2. / 3}})
+		// unknown segment falls back to the global popularity
+		top := p.Top("unknown", 5)
+		So(top, ShouldHaveLength, 3)
+		So(top[0].ItemId, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,193 @@
+package recommend
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// ReasonSeenInSession counts the candidates excluded as seen in the
+	// session in RecApiResponse.Filtered
+	ReasonSeenInSession = "seen_in_session"
+
+	// DefaultSessionTTL is the idle time a session is forgotten after
+	DefaultSessionTTL = 30 * time.Minute
+	// DefaultSessions is the max sessions tracked
+	DefaultSessions = 100000
+	// DefaultSessionSeen is the max seen items of a session, the oldest
+	// are forgotten first
+	DefaultSessionSeen = 1000
+)
+
+// SessionPolicy is the exclusion of the seen items on a surface, e.g. the
+// home feed or the related items of a detail page
+type SessionPolicy struct {
+	// Exclude removes the items seen in the session from the candidates,
+	// both the tracked ones and RecApiRequest.SeenItemIds
+	Exclude bool `json:"exclude" yaml:"exclude"`
+	// Track records the items returned on the surface as seen
+	Track bool `json:"track" yaml:"track"`
+}
+
+// SessionConfig configures the exclusion of the items seen in the session,
+// it is short term and distinct from the long term frequency capping. The
+// zero values are the defaults.
+type SessionConfig struct {
+	// TTL is the idle time a session is forgotten after
+	TTL time.Duration `json:"ttl" yaml:"ttl"`
+	// MaxSessions is the max sessions tracked, the least recent are
+	// forgotten first
+	MaxSessions int `json:"max_sessions" yaml:"max_sessions"`
+	// MaxSeen is the max seen items tracked of a session
+	MaxSeen int `json:"max_seen" yaml:"max_seen"`
+	// Default is the policy of the surfaces not in Surfaces
+	Default SessionPolicy `json:"default" yaml:"default"`
+	// Surfaces is the policy by RecApiRequest.Surface
+	Surfaces map[string]SessionPolicy `json:"surfaces" yaml:"surfaces"`
+}
+
+// WithSessionExclusion excludes the items seen in the session of
+// RecApiRequest.SessionId from the later requests of the session by conf.
+// Without it the SessionId and SeenItemIds are ignored.
+func WithSessionExclusion(conf SessionConfig) ApiOption {
+	return func(c *apiConfig) {
+		c.sessions = newSessionTracker(conf)
+	}
+}
+
+type session struct {
+	seen  map[int]struct{}
+	order []int
+	last  time.Time
+}
+
+// sessionTracker keeps the seen items of the sessions by tenant and id
+type sessionTracker struct {
+	conf SessionConfig
+	now  func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newSessionTracker(conf SessionConfig) *sessionTracker {
+	if conf.TTL <= 0 {
+		conf.TTL = DefaultSessionTTL
+	}
+	if conf.MaxSessions <= 0 {
+		conf.MaxSessions = DefaultSessions
+	}
+	if conf.MaxSeen <= 0 {
+		conf.MaxSeen = DefaultSessionSeen
+	}
+	return &sessionTracker{
+		conf:     conf,
+		now:      time.Now,
+		sessions: make(map[string]*session),
+	}
+}
+
+func (t *sessionTracker) policy(surface string) SessionPolicy {
+	if p, ok := t.conf.Surfaces[surface]; ok {
+		return p
+	}
+	return t.conf.Default
+}
+
+func sessionKey(ctx context.Context, sessionId string) string {
+	return tenantName(ctx) + "\x00" + sessionId
+}
+
+// get returns the live session of key, nil if none. t.mu must be held.
+func (t *sessionTracker) get(key string, now time.Time) *session {
+	s, ok := t.sessions[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(s.last) > t.conf.TTL {
+		delete(t.sessions, key)
+		return nil
+	}
+	return s
+}
+
+// exclude returns itemIds not seen in sessionId nor in seen, and the count
+// excluded
+func (t *sessionTracker) exclude(ctx context.Context, sessionId string, seen []int, itemIds []int) (kept []int, excluded int) {
+	skip := make(map[int]struct{}, len(seen))
+	for _, id := range seen {
+		skip[id] = struct{}{}
+	}
+	kept = make([]int, 0, len(itemIds))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(sessionKey(ctx, sessionId), t.now())
+	for _, id := range itemIds {
+		if _, ok := skip[id]; ok {
+			excluded++
+			RecordUnscored(ctx, DropSession, ReasonSeenInSession, id)
+			continue
+		}
+		if s != nil {
+			if _, ok := s.seen[id]; ok {
+				excluded++
+				RecordUnscored(ctx, DropSession, ReasonSeenInSession, id)
+				continue
+			}
+		}
+		kept = append(kept, id)
+	}
+	return
+}
+
+// track records itemScores as seen in sessionId
+func (t *sessionTracker) track(ctx context.Context, sessionId string, itemScores []ItemScore) {
+	now := t.now()
+	key := sessionKey(ctx, sessionId)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(key, now)
+	if s == nil {
+		if len(t.sessions) >= t.conf.MaxSessions {
+			t.prune(now)
+		}
+		s = &session{seen: make(map[int]struct{})}
+		t.sessions[key] = s
+	}
+	s.last = now
+	for _, is := range itemScores {
+		if _, ok := s.seen[is.ItemId]; ok {
+			continue
+		}
+		s.seen[is.ItemId] = struct{}{}
+		s.order = append(s.order, is.ItemId)
+	}
+	if over := len(s.order) - t.conf.MaxSeen; over > 0 {
+		for _, id := range s.order[:over] {
+			delete(s.seen, id)
+		}
+		s.order = append([]int(nil), s.order[over:]...)
+	}
+}
+
+// prune removes the idle sessions, and the least recent if still full. t.mu
+// must be held.
+func (t *sessionTracker) prune(now time.Time) {
+	var (
+		oldest string
+		last   time.Time
+	)
+	for key, s := range t.sessions {
+		if now.Sub(s.last) > t.conf.TTL {
+			delete(t.sessions, key)
+			continue
+		}
+		if oldest == "" || s.last.Before(last) {
+			oldest, last = key, s.last
+		}
+	}
+	if len(t.sessions) >= t.conf.MaxSessions && oldest != "" {
+		delete(t.sessions, oldest)
+	}
+}
@@ -0,0 +1,73 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSessionExclusion(t *testing.T) {
+	Convey("exclude the items seen in the session", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &countPredictor{})), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend", WithPagination(time.Minute, 0), WithSessionExclusion(SessionConfig{
+			Default: SessionPolicy{Exclude: true, Track: true},
+			Surfaces: map[string]SessionPolicy{
+				"detail": {Track: true},
+			},
+		}))
+		post := func(body string) (resp RecApiResponse) {
+			req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, 200)
+			So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+			return
+		}
+		ids := func(resp RecApiResponse) (ids []int) {
+			for _, is := range resp.ItemScoreList {
+				ids = append(ids, is.ItemId)
+			}
+			return
+		}
+
+		// only the returned page is seen
+		first := post(`{"userId":1,"sessionId":"s1","pageSize":2}`)
+		So(ids(first), ShouldResemble, []int{1, 2})
+		second := post(`{"userId":1,"sessionId":"s1","seenItemIds":[5]}`)
+		So(ids(second), ShouldResemble, []int{4, 3})
+		So(second.Filtered, ShouldResemble, map[string]int{ReasonSeenInSession: 3})
+
+		// all seen now, the detail surface does not exclude
+		So(post(`{"userId":1,"sessionId":"s1"}`).ItemScoreList, ShouldHaveLength, 1)
+		So(post(`{"userId":1,"sessionId":"s1","surface":"detail"}`).ItemScoreList, ShouldHaveLength, 5)
+		So(post(`{"userId":1,"sessionId":"s1"}`).ItemScoreList, ShouldBeEmpty)
+		So(post(`{"userId":1,"sessionId":"s2"}`).ItemScoreList, ShouldHaveLength, 5)
+		So(post(`{"userId":1}`).ItemScoreList, ShouldHaveLength, 5)
+	})
+
+	Convey("session expiry and limits", t, func() {
+		ctx := context.Background()
+		now := time.Now()
+		tracker := newSessionTracker(SessionConfig{TTL: time.Minute, MaxSessions: 1, MaxSeen: 2})
+		tracker.now = func() time.Time { return now }
+		tracker.track(ctx, "a", []ItemScore{{ItemId: 1}, {ItemId: 2}, {ItemId: 3}})
+		kept, excluded := tracker.exclude(ctx, "a", nil, []int{1, 2, 3})
+		So(kept, ShouldResemble, []int{1})
+		So(excluded, ShouldEqual, 2)
+
+		tracker.track(ctx, "b", []ItemScore{{ItemId: 1}})
+		kept, _ = tracker.exclude(ctx, "a", nil, []int{1, 2, 3})
+		So(kept, ShouldHaveLength, 3)
+
+		now = now.Add(2 * time.Minute)
+		kept, _ = tracker.exclude(ctx, "b", nil, []int{1})
+		So(kept, ShouldResemble, []int{1})
+	})
+}
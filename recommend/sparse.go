@@ -0,0 +1,125 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+)
+
+// SparseSample is the CSR (compressed sparse row) sample vectors of mostly
+// zeros, e.g. the one-hot and hashed features: the non-zero Values of the
+// row i are of the columns Indices[Indptr[i]:Indptr[i+1]], in order.
+type SparseSample struct {
+	Indptr  []int
+	Indices []int
+	Values  []float32
+	Cols    int
+}
+
+// NewSparseSample returns the SparseSample of no row of cols columns
+func NewSparseSample(cols int) *SparseSample {
+	return &SparseSample{Indptr: []int{0}, Cols: cols}
+}
+
+// ToSparse returns the CSR of the dense rows of x of cols columns
+func ToSparse(x []float32, rows, cols int) (s *SparseSample, err error) {
+	if len(x) != rows*cols {
+		return nil, fmt.Errorf("%d values of %d rows by %d cols", len(x), rows, cols)
+	}
+	s = NewSparseSample(cols)
+	for i := 0; i < rows; i++ {
+		_ = s.AppendRow(x[i*cols : (i+1)*cols])
+	}
+	return
+}
+
+// Rows is the number of the rows
+func (s *SparseSample) Rows() int {
+	return len(s.Indptr) - 1
+}
+
+// Nnz is the number of the non-zeros
+func (s *SparseSample) Nnz() int {
+	return s.Indptr[len(s.Indptr)-1] - s.Indptr[0]
+}
+
+// AppendRow appends the non-zeros of the dense vec
+func (s *SparseSample) AppendRow(vec []float32) error {
+	if len(vec) != s.Cols {
+		return fmt.Errorf("sparse sample of %d cols != vector width %d", s.Cols, len(vec))
+	}
+	for j, v := range vec {
+		if v != 0 {
+			s.Indices = append(s.Indices, j)
+			s.Values = append(s.Values, v)
+		}
+	}
+	s.Indptr = append(s.Indptr, len(s.Indices))
+	return nil
+}
+
+// Row returns the column indices and the values of the non-zeros of row i
+func (s *SparseSample) Row(i int) (indices []int, values []float32) {
+	start, end := s.Indptr[i]-s.Indptr[0], s.Indptr[i+1]-s.Indptr[0]
+	return s.Indices[start:end], s.Values[start:end]
+}
+
+// DenseRow returns the dense row i in buf, which is reused if of Cols
+func (s *SparseSample) DenseRow(i int, buf []float32) []float32 {
+	if len(buf) != s.Cols {
+		buf = make([]float32, s.Cols)
+	} else {
+		for j := range buf {
+			buf[j] = 0
+		}
+	}
+	indices, values := s.Row(i)
+	for k, j := range indices {
+		buf[j] = values[k]
+	}
+	return buf
+}
+
+// Batch returns the rows [start, end), it shares the Indices and Values
+// with s, so a batch is sliced without copying the non-zeros
+func (s *SparseSample) Batch(start, end int) *SparseSample {
+	return &SparseSample{
+		Indptr:  s.Indptr[start : end+1],
+		Indices: s.Indices[s.Indptr[start]-s.Indptr[0] : s.Indptr[end]-s.Indptr[0]],
+		Values:  s.Values[s.Indptr[start]-s.Indptr[0] : s.Indptr[end]-s.Indptr[0]],
+		Cols:    s.Cols,
+	}
+}
+
+// MaxRowNnz is the max non-zeros of a row
+func (s *SparseSample) MaxRowNnz() (nnz int) {
+	for i := 1; i < len(s.Indptr); i++ {
+		if n := s.Indptr[i] - s.Indptr[i-1]; n > nnz {
+			nnz = n
+		}
+	}
+	return
+}
+
+// SparseFitter is the Fitter of the sparse samples, Train loads the samples
+// as CSR for it and calls FitSparse, so the dense sample matrix is never
+// held in memory. The PredictAbstract is still served the dense vectors.
+type SparseFitter interface {
+	Fitter
+	// FitSparse fits the Sparse of sample, of which X is nil
+	FitSparse(sample *TrainSample) (PredictAbstract, error)
+}
+
+// Row returns the dense row i of sample, a view of X or the Sparse row
+// densified in buf
+func (sample *TrainSample) Row(i int, buf []float32) []float32 {
+	if sample.Sparse != nil {
+		return sample.Sparse.DenseRow(i, buf)
+	}
+	return sample.X[i*sample.XCols : (i+1)*sample.XCols]
+}
+
+// GetSparseSample is GetSample of the CSR sample vectors in Sparse instead of
+// X, the memory is of the non-zeros only
+func GetSparseSample(recSys RecSys, ctx context.Context) (sample *TrainSample, err error) {
+	return getSample(recSys, ctx, true)
+}
@@ -0,0 +1,64 @@
+package recommend
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sparseRecorder is the SparseFitter keeping the sample of FitSparse
+type sparseRecorder struct {
+	recordPredictor
+	sample *TrainSample
+}
+
+func (f *sparseRecorder) FitSparse(sample *TrainSample) (PredictAbstract, error) {
+	f.sample = sample
+	return &f.recordPredictor, nil
+}
+
+func TestSparseSample(t *testing.T) {
+	Convey("CSR of the dense rows", t, func() {
+		s, err := ToSparse([]float32{0, 2, 0, 1, 0, 0, 3, 0, 4}, 3, 3)
+		So(err, ShouldBeNil)
+		So(s.Rows(), ShouldEqual, 3)
+		So(s.Nnz(), ShouldEqual, 4)
+		So(s.Indptr, ShouldResemble, []int{0, 1, 2, 4})
+		So(s.MaxRowNnz(), ShouldEqual, 2)
+		indices, values := s.Row(2)
+		So(indices, ShouldResemble, []int{0, 2})
+		So(values, ShouldResemble, []float32{3, 4})
+		So(s.DenseRow(2, nil), ShouldResemble, []float32{3, 0, 4})
+
+		b := s.Batch(1, 3)
+		So(b.Rows(), ShouldEqual, 2)
+		So(b.Nnz(), ShouldEqual, 3)
+		So(b.DenseRow(0, nil), ShouldResemble, []float32{1, 0, 0})
+		buf := make([]float32, 3)
+		So(b.DenseRow(1, buf), ShouldResemble, []float32{3, 0, 4})
+
+		So(s.AppendRow([]float32{1}), ShouldNotBeNil)
+		_, err = ToSparse([]float32{1}, 2, 2)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("train of the SparseFitter", t, func() {
+		userCache, itemCache := UserFeatureCache, ItemFeatureCache
+		defer func() {
+			UserFeatureCache, ItemFeatureCache = userCache, itemCache
+		}()
+		f := &sparseRecorder{}
+		_, err := Train(context.Background(), &lineageRecSys{}, f)
+		So(err, ShouldBeNil)
+		So(f.sample, ShouldNotBeNil)
+		So(f.sample.X, ShouldBeNil)
+		So(f.sample.Sparse.Rows(), ShouldEqual, f.sample.Rows)
+
+		dense, err := GetSample(&lineageRecSys{}, context.Background())
+		So(err, ShouldBeNil)
+		for i := 0; i < dense.Rows; i++ {
+			So(f.sample.Row(i, nil), ShouldResemble, dense.Row(i, nil))
+		}
+	})
+}
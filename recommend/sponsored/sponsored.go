@@ -0,0 +1,305 @@
+// Package sponsored mixes the sponsored items into the organic results. The
+// ads of the separate candidate pool are scored by their own Scorer, ranked
+// by the auction of the expected revenue pCTR * bid and priced by the
+// generalized second price, then placed by the Policy at the fixed slots or
+// where they outbid the organic scores. The organic and the sponsored
+// impressions are logged apart, the sponsored ones are the billing records
+// and never the organic training samples.
+package sponsored
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+)
+
+// Ad is a sponsored candidate, Bid is the max cost per click of Campaign
+type Ad struct {
+	ItemId   int     `json:"itemId"`
+	Campaign string  `json:"campaign"`
+	Bid      float64 `json:"bid"`
+}
+
+// Scorer predicts the CTR of the ads for userId, e.g. PredictorScorer of the
+// model trained on the sponsored impressions
+type Scorer interface {
+	ScoreAds(ctx context.Context, userId int, ads []Ad) ([]float32, error)
+}
+
+// PredictorScorer is the Scorer of the CTR of Predictor
+type PredictorScorer struct {
+	Predictor rcmd.Predictor
+}
+
+func (s *PredictorScorer) ScoreAds(ctx context.Context, userId int, ads []Ad) (scores []float32, err error) {
+	if len(ads) == 0 {
+		return
+	}
+	samples := make([]rcmd.Sample, len(ads))
+	now := time.Now().Unix()
+	for i, ad := range ads {
+		samples[i] = rcmd.Sample{UserId: userId, ItemId: ad.ItemId, Timestamp: now}
+	}
+	y, err := rcmd.BatchPredict(ctx, s.Predictor, samples)
+	if err != nil {
+		return
+	}
+	if y == nil {
+		return nil, fmt.Errorf("sponsored scorer no prediction")
+	}
+	return y.Data().([]float32)[:len(ads)], nil
+}
+
+// Bid is an ad of the auction, ECPM = PCTR * Ad.Bid is the expected revenue
+// of an impression, Price is the cost per click charged
+type Bid struct {
+	Ad
+	PCTR  float32 `json:"pctr"`
+	ECPM  float64 `json:"ecpm"`
+	Price float64 `json:"price"`
+}
+
+// Auction ranks the ads by ECPM and prices them by the generalized second
+// price: the ECPM of the next ad or reserve, divided by the PCTR. The ads of
+// ECPM below reserve are dropped, and so the lower ads of the same item.
+func Auction(ads []Ad, pctr []float32, reserve float64) (bids []Bid, err error) {
+	if len(ads) != len(pctr) {
+		return nil, fmt.Errorf("%d ads of %d scores", len(ads), len(pctr))
+	}
+	for i, ad := range ads {
+		b := Bid{Ad: ad, PCTR: pctr[i], ECPM: float64(pctr[i]) * ad.Bid}
+		if b.ECPM > 0 && b.ECPM >= reserve {
+			bids = append(bids, b)
+		}
+	}
+	sort.SliceStable(bids, func(i, j int) bool {
+		return bids[i].ECPM > bids[j].ECPM
+	})
+	items := make(map[int]bool, len(bids))
+	unique := bids[:0]
+	for _, b := range bids {
+		if !items[b.ItemId] {
+			items[b.ItemId] = true
+			unique = append(unique, b)
+		}
+	}
+	bids = unique
+	for i := range bids {
+		next := reserve
+		if i+1 < len(bids) {
+			next = bids[i+1].ECPM
+		}
+		bids[i].Price = next / float64(bids[i].PCTR)
+		if bids[i].Price > bids[i].Bid {
+			bids[i].Price = bids[i].Bid
+		}
+	}
+	return
+}
+
+// Slot is an item of the mixed result, Sponsored is the winning bid of the
+// sponsored items, nil of the organic ones
+type Slot struct {
+	rcmd.ItemScore
+	Sponsored *Bid `json:"sponsored,omitempty"`
+}
+
+// Policy places the bids in the auction order into the organic results
+type Policy interface {
+	Mix(organic []rcmd.ItemScore, bids []Bid) []Slot
+}
+
+// FixedSlots places the bids at Positions, the 0-based indices of the mixed
+// result, in the auction order. The positions past the end are not filled.
+type FixedSlots struct {
+	Positions []int `json:"positions" yaml:"positions"`
+}
+
+func (p *FixedSlots) Mix(organic []rcmd.ItemScore, bids []Bid) (slots []Slot) {
+	positions := make(map[int]bool, len(p.Positions))
+	for _, pos := range p.Positions {
+		positions[pos] = true
+	}
+	slots = make([]Slot, 0, len(organic)+len(bids))
+	for len(organic) > 0 || (len(bids) > 0 && positions[len(slots)]) {
+		if len(bids) > 0 && positions[len(slots)] {
+			bid := bids[0]
+			slots = append(slots, Slot{ItemScore: rcmd.ItemScore{ItemId: bid.ItemId, Score: bid.PCTR}, Sponsored: &bid})
+			bids = bids[1:]
+			continue
+		}
+		slots = append(slots, Slot{ItemScore: organic[0]})
+		organic = organic[1:]
+	}
+	return
+}
+
+// ThresholdInsertion inserts a bid before the first organic item of the
+// score below Weight * ECPM, so an ad wins the slot only if it's worth more
+// than the organic item. MaxAds and MinGap of the organic items between the
+// ads bound the ad load, 0 is no limit.
+type ThresholdInsertion struct {
+	// Weight is the organic score of a unit of ECPM, 0 means 1
+	Weight float64 `json:"weight" yaml:"weight"`
+	MaxAds int     `json:"maxAds" yaml:"maxAds"`
+	MinGap int     `json:"minGap" yaml:"minGap"`
+}
+
+func (p *ThresholdInsertion) Mix(organic []rcmd.ItemScore, bids []Bid) (slots []Slot) {
+	weight := p.Weight
+	if weight == 0 {
+		weight = 1
+	}
+	var (
+		ads = 0
+		gap = p.MinGap
+	)
+	slots = make([]Slot, 0, len(organic)+len(bids))
+	for _, is := range organic {
+		if len(bids) > 0 && (p.MaxAds <= 0 || ads < p.MaxAds) && gap >= p.MinGap &&
+			weight*bids[0].ECPM > float64(is.Score) {
+			bid := bids[0]
+			slots = append(slots, Slot{ItemScore: rcmd.ItemScore{ItemId: bid.ItemId, Score: bid.PCTR}, Sponsored: &bid})
+			bids = bids[1:]
+			ads++
+			gap = 0
+		}
+		slots = append(slots, Slot{ItemScore: is})
+		gap++
+	}
+	return
+}
+
+// Impression is a logged item of the mixed result
+type Impression struct {
+	UserId   int     `json:"userId"`
+	ItemId   int     `json:"itemId"`
+	Position int     `json:"position"`
+	Score    float32 `json:"score"`
+	// Campaign, Bid and Price are only set of the sponsored impressions
+	Campaign  string  `json:"campaign,omitempty"`
+	Bid       float64 `json:"bid,omitempty"`
+	Price     float64 `json:"price,omitempty"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// Logger logs the impressions, e.g. JSONLogger
+type Logger interface {
+	LogImpressions(ctx context.Context, impressions []Impression) error
+}
+
+// JSONLogger logs the impressions as the json lines of W
+type JSONLogger struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+func (l *JSONLogger) LogImpressions(_ context.Context, impressions []Impression) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	enc := json.NewEncoder(l.W)
+	for i := range impressions {
+		if err := enc.Encode(&impressions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mixer mixes the ads into the organic results of the users. The organic
+// items of the placed ads are dropped so no item shows twice.
+type Mixer struct {
+	Scorer Scorer
+	Policy Policy
+	// Reserve is the min ECPM of the ads placed
+	Reserve float64
+	// OrganicLog and SponsoredLog log the impressions of the organic and
+	// the sponsored items apart if not nil
+	OrganicLog   Logger
+	SponsoredLog Logger
+
+	// now is time.Now, overridden by the tests
+	now func() time.Time
+}
+
+// Mix scores and auctions the ads for userId, places the bids into organic
+// by Policy and logs the impressions of the result
+func (m *Mixer) Mix(ctx context.Context, userId int, organic []rcmd.ItemScore, ads []Ad) (slots []Slot, err error) {
+	var bids []Bid
+	if len(ads) != 0 {
+		var pctr []float32
+		if pctr, err = m.Scorer.ScoreAds(ctx, userId, ads); err != nil {
+			return nil, fmt.Errorf("score ads: %v", err)
+		}
+		if bids, err = Auction(ads, pctr, m.Reserve); err != nil {
+			return
+		}
+	}
+	slots = m.place(organic, bids)
+	if placed := placedBids(slots); len(placed) != len(bids) {
+		// the organic copies of the ads not placed are restored
+		slots = m.place(organic, placed)
+	}
+	return slots, m.log(ctx, userId, slots)
+}
+
+// place mixes bids into organic by Policy, the organic copies of the bids
+// are dropped
+func (m *Mixer) place(organic []rcmd.ItemScore, bids []Bid) []Slot {
+	sponsoredItems := make(map[int]bool, len(bids))
+	for _, b := range bids {
+		sponsoredItems[b.ItemId] = true
+	}
+	deduped := make([]rcmd.ItemScore, 0, len(organic))
+	for _, is := range organic {
+		if !sponsoredItems[is.ItemId] {
+			deduped = append(deduped, is)
+		}
+	}
+	return m.Policy.Mix(deduped, bids)
+}
+
+func placedBids(slots []Slot) (bids []Bid) {
+	for _, s := range slots {
+		if s.Sponsored != nil {
+			bids = append(bids, *s.Sponsored)
+		}
+	}
+	return
+}
+
+func (m *Mixer) log(ctx context.Context, userId int, slots []Slot) (err error) {
+	now := time.Now
+	if m.now != nil {
+		now = m.now
+	}
+	ts := now().Unix()
+	var organic, sponsored []Impression
+	for i, s := range slots {
+		imp := Impression{UserId: userId, ItemId: s.ItemId, Position: i, Score: s.Score, Timestamp: ts}
+		if s.Sponsored == nil {
+			organic = append(organic, imp)
+			continue
+		}
+		imp.Campaign, imp.Bid, imp.Price = s.Sponsored.Campaign, s.Sponsored.Bid, s.Sponsored.Price
+		sponsored = append(sponsored, imp)
+	}
+	if m.OrganicLog != nil && len(organic) != 0 {
+		if err = m.OrganicLog.LogImpressions(ctx, organic); err != nil {
+			return fmt.Errorf("log organic impressions: %v", err)
+		}
+	}
+	if m.SponsoredLog != nil && len(sponsored) != 0 {
+		if err = m.SponsoredLog.LogImpressions(ctx, sponsored); err != nil {
+			return fmt.Errorf("log sponsored impressions: %v", err)
+		}
+	}
+	return
+}
@@ -0,0 +1,142 @@
+package sponsored
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeScorer scores the ads by their item ids
+type fakeScorer map[int]float32
+
+func (s fakeScorer) ScoreAds(_ context.Context, _ int, ads []Ad) ([]float32, error) {
+	scores := make([]float32, len(ads))
+	for i, ad := range ads {
+		score, ok := s[ad.ItemId]
+		if !ok {
+			return nil, fmt.Errorf("ad of item %d not found", ad.ItemId)
+		}
+		scores[i] = score
+	}
+	return scores, nil
+}
+
+// memLogger keeps the impressions logged
+type memLogger struct {
+	impressions []Impression
+}
+
+func (l *memLogger) LogImpressions(_ context.Context, impressions []Impression) error {
+	l.impressions = append(l.impressions, impressions...)
+	return nil
+}
+
+func slotIds(slots []Slot) (ids []string) {
+	for _, s := range slots {
+		if s.Sponsored != nil {
+			ids = append(ids, fmt.Sprintf("ad%d", s.ItemId))
+		} else {
+			ids = append(ids, fmt.Sprint(s.ItemId))
+		}
+	}
+	return
+}
+
+func TestAuction(t *testing.T) {
+	Convey("generalized second price", t, func() {
+		bids, err := Auction([]Ad{
+			{ItemId: 1, Campaign: "a", Bid: 1},
+			{ItemId: 2, Campaign: "b", Bid: 2},
+			{ItemId: 3, Campaign: "c", Bid: 10},
+			{ItemId: 2, Campaign: "d", Bid: 1},
+			{ItemId: 4, Campaign: "e", Bid: 0.1},
+		}, []float32{0.5, 0.1, 0.01, 0.1, 0.1}, 0.05)
+		So(err, ShouldBeNil)
+		So(bids, ShouldHaveLength, 3)
+		So(bids[0].ItemId, ShouldEqual, 1)
+		So(bids[0].ECPM, ShouldAlmostEqual, 0.5, 1e-6)
+		// the ecpm of the next 0.2 over the pctr 0.5
+		So(bids[0].Price, ShouldAlmostEqual, 0.4, 1e-6)
+		So(bids[1].Campaign, ShouldEqual, "b")
+		So(bids[1].Price, ShouldAlmostEqual, 1, 1e-6)
+		// the last by the reserve
+		So(bids[2].ItemId, ShouldEqual, 3)
+		So(bids[2].Price, ShouldAlmostEqual, 5, 1e-4)
+
+		_, err = Auction([]Ad{{ItemId: 1}}, nil, 0)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	organic := []rcmd.ItemScore{{ItemId: 1, Score: 0.9}, {ItemId: 2, Score: 0.5}, {ItemId: 3, Score: 0.3}, {ItemId: 4, Score: 0.1}}
+	bids := []Bid{{Ad: Ad{ItemId: 10}, ECPM: 0.6}, {Ad: Ad{ItemId: 11}, ECPM: 0.4}, {Ad: Ad{ItemId: 12}, ECPM: 0.35}}
+
+	Convey("fixed slots", t, func() {
+		slots := (&FixedSlots{Positions: []int{1, 3, 9}}).Mix(organic, bids)
+		So(slotIds(slots), ShouldResemble, []string{"1", "ad10", "2", "ad11", "3", "4"})
+		slots = (&FixedSlots{Positions: []int{0, 2}}).Mix(organic[:1], bids)
+		So(slotIds(slots), ShouldResemble, []string{"ad10", "1", "ad11"})
+	})
+
+	Convey("score threshold insertion", t, func() {
+		slots := (&ThresholdInsertion{}).Mix(organic, bids)
+		So(slotIds(slots), ShouldResemble, []string{"1", "ad10", "2", "ad11", "3", "ad12", "4"})
+		slots = (&ThresholdInsertion{MaxAds: 2, MinGap: 2}).Mix(organic, bids)
+		So(slotIds(slots), ShouldResemble, []string{"1", "ad10", "2", "3", "ad11", "4"})
+		// the ecpm is worth half the organic score
+		slots = (&ThresholdInsertion{Weight: 0.5}).Mix(organic, bids)
+		So(slotIds(slots), ShouldResemble, []string{"1", "2", "3", "ad10", "4"})
+	})
+}
+
+func TestMixer(t *testing.T) {
+	ctx := context.Background()
+	organic := []rcmd.ItemScore{{ItemId: 1, Score: 0.9}, {ItemId: 2, Score: 0.5}, {ItemId: 3, Score: 0.3}}
+
+	Convey("mix and log apart", t, func() {
+		var organicLog memLogger
+		var sponsoredLog bytes.Buffer
+		m := &Mixer{
+			Scorer:       fakeScorer{3: 0.5, 7: 0.1},
+			Policy:       &FixedSlots{Positions: []int{0, 5}},
+			OrganicLog:   &organicLog,
+			SponsoredLog: &JSONLogger{W: &sponsoredLog},
+			now:          func() time.Time { return time.Unix(100, 0) },
+		}
+		slots, err := m.Mix(ctx, 42, organic, []Ad{{ItemId: 3, Campaign: "a", Bid: 2}, {ItemId: 7, Campaign: "b", Bid: 1}})
+		So(err, ShouldBeNil)
+		// the organic 3 is dropped for the ad, ad7 is past the end
+		So(slotIds(slots), ShouldResemble, []string{"ad3", "1", "2"})
+		So(organicLog.impressions, ShouldResemble, []Impression{
+			{UserId: 42, ItemId: 1, Position: 1, Score: 0.9, Timestamp: 100},
+			{UserId: 42, ItemId: 2, Position: 2, Score: 0.5, Timestamp: 100},
+		})
+		lines := strings.Split(strings.TrimSpace(sponsoredLog.String()), "\n")
+		So(lines, ShouldHaveLength, 1)
+		var imp Impression
+		So(json.Unmarshal([]byte(lines[0]), &imp), ShouldBeNil)
+		So(imp.Campaign, ShouldEqual, "a")
+		So(imp.Price, ShouldAlmostEqual, 0.2, 1e-6)
+	})
+
+	Convey("the organic copy of the ad not placed is kept", t, func() {
+		m := &Mixer{Scorer: fakeScorer{2: 0.01, 9: 0.5}, Policy: &ThresholdInsertion{MaxAds: 1}}
+		slots, err := m.Mix(ctx, 42, organic, []Ad{{ItemId: 2, Bid: 1}, {ItemId: 9, Bid: 1}})
+		So(err, ShouldBeNil)
+		So(slotIds(slots), ShouldResemble, []string{"1", "2", "ad9", "3"})
+
+		slots, err = m.Mix(ctx, 42, organic, nil)
+		So(err, ShouldBeNil)
+		So(slotIds(slots), ShouldResemble, []string{"1", "2", "3"})
+		_, err = m.Mix(ctx, 42, organic, []Ad{{ItemId: 5, Bid: 1}})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,123 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Surface is a placement served by the api, e.g. the homepage feed, the item
+// detail page or the cart, with its own recall channels, model, rerank rules
+// and K. It's selected by RecApiRequest.Surface, see WithSurfaces.
+type Surface struct {
+	Name string
+	// Predictor ranks the items of the surface, the Predictor of the api or
+	// the tenant if nil
+	Predictor Predictor
+	// Recallers are the recall channels of the candidates if the request has
+	// no itemIdList, merged in order without duplicates. The Recaller of the
+	// Predictor if empty, or else of the Predictor of the api or the tenant.
+	Recallers []Recaller
+	// ReRanker reranks the ranked items, e.g. by the rules of the surface,
+	// after the ReRanker of the Predictor if not nil
+	ReRanker ReRanker
+	// K is the max items served, the top K by score, all if 0
+	K int
+}
+
+// WithSurfaces serves the surfaces selected by RecApiRequest.Surface, the
+// requests of an unknown surface are rejected with 400. The requests of no
+// surface are served as without WithSurfaces. A surface replaces the former
+// one of the same name.
+func WithSurfaces(surfaces ...*Surface) ApiOption {
+	return func(c *apiConfig) {
+		if c.surfaces == nil {
+			c.surfaces = make(map[string]*Surface, len(surfaces))
+		}
+		for _, s := range surfaces {
+			c.surfaces[s.Name] = s
+		}
+	}
+}
+
+// WithReRankers reranks the items ranked of every request by reRankers in
+// order, after the ReRanker of the Predictor and before the one of the
+// Surface, e.g. the rules or the utility formula of the config. A failed
+// rerank fails the request with 500.
+func WithReRankers(reRankers ...ReRanker) ApiOption {
+	return func(c *apiConfig) {
+		c.reRankers = append(c.reRankers, reRankers...)
+	}
+}
+
+// reRank applies the ReRankers of WithReRankers on itemScores
+func (c *apiConfig) reRank(ctx context.Context, userId int, itemScores []ItemScore) (result []ItemScore, err error) {
+	result = itemScores
+	for _, r := range c.reRankers {
+		if result, err = r.ReRank(ctx, userId, result); err != nil {
+			return nil, fmt.Errorf("rerank: %v", err)
+		}
+	}
+	return
+}
+
+// surface returns the surface of name, nil of the empty name
+func (c *apiConfig) surface(name string) (*Surface, error) {
+	if name == "" || c.surfaces == nil {
+		return nil, nil
+	}
+	s, ok := c.surfaces[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown surface %q", name)
+	}
+	return s, nil
+}
+
+// recall merges the candidates of the recall channels within b.Recall each,
+// at most b.MaxCandidates of all. The failed channels are skipped unless all
+// fail.
+func (s *Surface) recall(ctx context.Context, userId int, b StageBudget) (itemIds []int, err error) {
+	var (
+		seen    = make(map[int]struct{})
+		failed  int
+		channel = b
+	)
+	channel.MaxCandidates = 0
+	for i, r := range s.Recallers {
+		ids, er := RecallWithBudget(ctx, r, userId, channel)
+		if er != nil {
+			log.Errorf("surface %s recall channel %d error: %v", s.Name, i, er)
+			failed++
+			err = er
+			continue
+		}
+		for _, id := range ids {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				itemIds = append(itemIds, id)
+			}
+		}
+	}
+	if failed < len(s.Recallers) {
+		err = nil
+	}
+	return truncateCandidates(ctx, itemIds, b.MaxCandidates), err
+}
+
+// finish reranks itemScores and keeps the top K
+func (s *Surface) finish(ctx context.Context, userId int, itemScores []ItemScore) (result []ItemScore, err error) {
+	result = itemScores
+	if s.ReRanker != nil {
+		if result, err = s.ReRanker.ReRank(ctx, userId, result); err != nil {
+			return nil, fmt.Errorf("surface %s rerank: %v", s.Name, err)
+		}
+	}
+	if s.K > 0 && len(result) > s.K {
+		sorted := make([]ItemScore, len(result))
+		copy(sorted, result)
+		sortItemScores(sorted)
+		result = sorted[:s.K]
+	}
+	return
+}
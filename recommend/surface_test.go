@@ -0,0 +1,141 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recallFunc is the Recaller of the func
+type recallFunc func(ctx context.Context, userId int) ([]int, error)
+
+func (f recallFunc) Recall(ctx context.Context, userId int) ([]int, error) {
+	return f(ctx, userId)
+}
+
+// reRankFunc is the ReRanker of the func
+type reRankFunc func(ctx context.Context, userId int, itemScores []ItemScore) ([]ItemScore, error)
+
+func (f reRankFunc) ReRank(ctx context.Context, userId int, itemScores []ItemScore) ([]ItemScore, error) {
+	return f(ctx, userId, itemScores)
+}
+
+func TestSurfaces(t *testing.T) {
+	Convey("surfaces of their own recall, model, rerank and K", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &countPredictor{})), ShouldBeNil)
+		cart := &Surface{
+			Name: "cart",
+			Recallers: []Recaller{
+				recallFunc(func(context.Context, int) ([]int, error) { return []int{7, 8}, nil }),
+				recallFunc(func(context.Context, int) ([]int, error) { return nil, fmt.Errorf("channel down") }),
+				recallFunc(func(context.Context, int) ([]int, error) { return []int{8, 9}, nil }),
+			},
+			// scores by the item id
+			Predictor: &sumPredictor{},
+			ReRanker: reRankFunc(func(_ context.Context, _ int, itemScores []ItemScore) (result []ItemScore, err error) {
+				for _, is := range itemScores {
+					if is.ItemId != 9 {
+						result = append(result, is)
+					}
+				}
+				return
+			}),
+		}
+		detail := &Surface{Name: "detail", K: 2, Predictor: &sumPredictor{}}
+		broken := &Surface{Name: "broken", Recallers: []Recaller{
+			recallFunc(func(context.Context, int) ([]int, error) { return nil, fmt.Errorf("channel down") }),
+		}}
+		engine := newTenantEngine(r, "/api/v1/recommend", WithPagination(time.Minute, 0), WithSurfaces(cart, detail, broken))
+		post := func(body string) (code int, ids []int) {
+			req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			var resp RecApiResponse
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			for _, is := range resp.ItemScoreList {
+				ids = append(ids, is.ItemId)
+			}
+			return w.Code, ids
+		}
+
+		code, ids := post(`{"userId":1,"surface":"cart"}`)
+		So(code, ShouldEqual, 200)
+		So(ids, ShouldResemble, []int{7, 8})
+
+		// the recall of the tenant predictor, the top 2 by the surface model
+		code, ids = post(`{"userId":1,"surface":"detail"}`)
+		So(code, ShouldEqual, 200)
+		So(ids, ShouldResemble, []int{5, 4})
+		// the pages of the top K
+		_, ids = post(`{"userId":1,"surface":"detail","pageSize":1}`)
+		So(ids, ShouldResemble, []int{5})
+
+		// no surface
+		code, ids = post(`{"userId":1}`)
+		So(code, ShouldEqual, 200)
+		So(ids, ShouldHaveLength, 5)
+
+		code, _ = post(`{"userId":1,"surface":"checkout"}`)
+		So(code, ShouldEqual, 400)
+		code, _ = post(`{"userId":1,"surface":"broken"}`)
+		So(code, ShouldEqual, 500)
+	})
+
+	Convey("rerankers of every request before the surface", t, func() {
+		r := NewTenantRegistry()
+		So(r.Register(NewTenant(DefaultTenant, &countPredictor{})), ShouldBeNil)
+		var reRanked []int
+		reverse := reRankFunc(func(_ context.Context, _ int, itemScores []ItemScore) ([]ItemScore, error) {
+			result := make([]ItemScore, len(itemScores))
+			for i, is := range itemScores {
+				result[len(result)-1-i] = is
+			}
+			reRanked = nil
+			for _, is := range result {
+				reRanked = append(reRanked, is.ItemId)
+			}
+			return result, nil
+		})
+		detail := &Surface{Name: "detail", ReRanker: reRankFunc(func(_ context.Context, _ int, itemScores []ItemScore) ([]ItemScore, error) {
+			return itemScores[:1], nil
+		})}
+		engine := newTenantEngine(r, "/api/v1/recommend", WithReRankers(reverse), WithSurfaces(detail))
+		post := func(body string) (code int, ids []int) {
+			req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			var resp RecApiResponse
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			for _, is := range resp.ItemScoreList {
+				ids = append(ids, is.ItemId)
+			}
+			return w.Code, ids
+		}
+
+		code, ids := post(`{"userId":1,"itemIdList":[1,2,3]}`)
+		So(code, ShouldEqual, 200)
+		So(ids, ShouldResemble, []int{3, 2, 1})
+		code, ids = post(`{"userId":1,"itemIdList":[1,2,3],"surface":"detail"}`)
+		So(code, ShouldEqual, 200)
+		So(reRanked, ShouldResemble, []int{3, 2, 1})
+		So(ids, ShouldResemble, []int{3})
+
+		failing := newTenantEngine(r, "/api/v1/recommend", WithReRankers(reRankFunc(func(context.Context, int, []ItemScore) ([]ItemScore, error) {
+			return nil, fmt.Errorf("rules unavailable")
+		})))
+		req := httptest.NewRequest("POST", "/api/v1/recommend", strings.NewReader(`{"userId":1,"itemIdList":[1]}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		failing.ServeHTTP(w, req)
+		So(w.Code, ShouldEqual, 500)
+	})
+}
@@ -0,0 +1,224 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/auxten/go-ctr/feature/embedding/model/word2vec"
+	"github.com/karlseguin/ccache/v2"
+)
+
+const (
+	// TenantHeader selects the tenant by name
+	TenantHeader = "X-Tenant"
+	// ApiKeyHeader selects the tenant by one of its api keys, it takes
+	// precedence over TenantHeader
+	ApiKeyHeader = "X-Api-Key"
+	// DefaultTenant serves the requests without tenant headers if registered
+	DefaultTenant = "default"
+)
+
+type tenantKey struct{}
+
+// Tenant is an app or site served by the process, with its own model,
+// feature caches, item embeddings and stats. The feature and behavior stores
+// of the Predictor could get the tenant by TenantFromContext to isolate the
+// data.
+type Tenant struct {
+	Name      string
+	ApiKeys   []string
+	Predictor Predictor
+
+	userFeatureCache *ccache.Cache
+	itemFeatureCache *ccache.Cache
+	// itemEmbeddingMap is of UpdateItemEmbeddings, the item embeddings of
+	// the Predictor are served if nil
+	itemEmbeddingMap word2vec.EmbeddingMap32
+
+	requests int64
+	errors   int64
+}
+
+// TenantStats is the serving metrics of a tenant
+type TenantStats struct {
+	Name     string `json:"name"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+}
+
+// NewTenant creates the Tenant serving predictor. The item embeddings served
+// are of the predictor if it's an ItemEmbeddingsProvider, e.g. of Train,
+// read by every request so they follow the Predictor swapped, or none.
+func NewTenant(name string, predictor Predictor, apiKeys ...string) *Tenant {
+	return &Tenant{
+		Name:      name,
+		ApiKeys:   apiKeys,
+		Predictor: predictor,
+		userFeatureCache: ccache.New(
+			ccache.Configure().MaxSize(userFeatureCacheSize).ItemsToPrune(userFeatureCacheSize / 100),
+		),
+		itemFeatureCache: ccache.New(
+			ccache.Configure().MaxSize(itemFeatureCacheSize).ItemsToPrune(itemFeatureCacheSize / 100),
+		),
+	}
+}
+
+// itemEmbeddings returns the item embeddings of t, of UpdateItemEmbeddings
+// or else of its Predictor, embeddingMu should be held
+func (t *Tenant) itemEmbeddings() word2vec.EmbeddingMap32 {
+	if t.itemEmbeddingMap != nil {
+		return t.itemEmbeddingMap
+	}
+	if p, ok := t.Predictor.(ItemEmbeddingsProvider); ok {
+		return p.ItemEmbeddings()
+	}
+	return nil
+}
+
+// Stats returns the serving metrics of the tenant
+func (t *Tenant) Stats() TenantStats {
+	return TenantStats{
+		Name:     t.Name,
+		Requests: atomic.LoadInt64(&t.requests),
+		Errors:   atomic.LoadInt64(&t.errors),
+	}
+}
+
+func (t *Tenant) record(err error) {
+	atomic.AddInt64(&t.requests, 1)
+	if err != nil {
+		atomic.AddInt64(&t.errors, 1)
+	}
+}
+
+// WithTenant returns the ctx serving t
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// TenantFromContext returns the tenant of ctx, or nil if not multi-tenant
+func TenantFromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(tenantKey{}).(*Tenant)
+	return t
+}
+
+// servingAssembler is the FeatureAssembler of the tenant of ctx, or of the
+// global caches and item embeddings if there is no tenant
+func servingAssembler(ctx context.Context, provider BasicFeatureProvider) *FeatureAssembler {
+	var assembler *FeatureAssembler
+	if t := TenantFromContext(ctx); t == nil {
+		assembler = NewFeatureAssembler(provider, UserFeatureCache, ItemFeatureCache)
+	} else {
+		assembler = NewFeatureAssembler(provider, t.userFeatureCache, t.itemFeatureCache)
+		assembler.itemEmbeddingMap = ItemEmbeddings(ctx)
+	}
+	assembler.normalizer = servingNormalizer(provider)
+	if mp, ok := provider.(ManifestProvider); ok && mp.Manifest() != nil {
+		assembler.featureFuncs, assembler.featureFuncsErr = lookupFeatureFuncs(mp.Manifest().FeatureFuncs)
+		assembler.biases = mp.Manifest().Biases
+	}
+	return assembler
+}
+
+// TenantRegistry holds the tenants by name and api key
+type TenantRegistry struct {
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+	apiKeys map[string]*Tenant
+}
+
+func NewTenantRegistry() *TenantRegistry {
+	return &TenantRegistry{
+		tenants: make(map[string]*Tenant),
+		apiKeys: make(map[string]*Tenant),
+	}
+}
+
+// Register adds t, the tenant name and api keys should be unique
+func (r *TenantRegistry) Register(t *Tenant) error {
+	if t.Name == "" {
+		return fmt.Errorf("tenant name is empty")
+	}
+	if t.Predictor == nil {
+		return fmt.Errorf("tenant %s has no predictor", t.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tenants[t.Name]; ok {
+		return fmt.Errorf("duplicated tenant %s", t.Name)
+	}
+	for _, key := range t.ApiKeys {
+		if other, ok := r.apiKeys[key]; ok {
+			return fmt.Errorf("api key of tenant %s is used by tenant %s", t.Name, other.Name)
+		}
+	}
+	r.tenants[t.Name] = t
+	for _, key := range t.ApiKeys {
+		r.apiKeys[key] = t
+	}
+	return nil
+}
+
+// Get returns the tenant of name
+func (r *TenantRegistry) Get(name string) (t *Tenant, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok = r.tenants[name]
+	return
+}
+
+// Resolve selects the tenant of the request by ApiKeyHeader, TenantHeader,
+// then DefaultTenant. A tenant of any ApiKeys is only resolved by one of its
+// keys, the requests selecting it by TenantHeader or as the DefaultTenant
+// without the key fail.
+func (r *TenantRegistry) Resolve(req *http.Request) (t *Tenant, err error) {
+	if key := req.Header.Get(ApiKeyHeader); key != "" {
+		r.mu.RLock()
+		t, ok := r.apiKeys[key]
+		r.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown api key")
+		}
+		return t, nil
+	}
+	name := req.Header.Get(TenantHeader)
+	if name == "" {
+		name = DefaultTenant
+	}
+	t, ok := r.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q", name)
+	}
+	if len(t.ApiKeys) != 0 {
+		return nil, fmt.Errorf("tenant %q requires the api key", name)
+	}
+	return t, nil
+}
+
+// Tenants returns all the tenants ordered by name
+func (r *TenantRegistry) Tenants() []*Tenant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tenants := make([]*Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		tenants = append(tenants, t)
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].Name < tenants[j].Name
+	})
+	return tenants
+}
+
+// Stats returns the metrics of all the tenants ordered by name
+func (r *TenantRegistry) Stats() []TenantStats {
+	tenants := r.Tenants()
+	stats := make([]TenantStats, len(tenants))
+	for i, t := range tenants {
+		stats[i] = t.Stats()
+	}
+	return stats
+}
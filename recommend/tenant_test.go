@@ -0,0 +1,108 @@
+package recommend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// constPredictor scores every sample by score
+type constPredictor struct {
+	fakeProvider
+	score float32
+}
+
+func (p *constPredictor) Predict(X tensor.Tensor) tensor.Tensor {
+	rows := X.Shape()[0]
+	y := make([]float32, rows)
+	for i := range y {
+		y[i] = p.score
+	}
+	return tensor.New(tensor.WithShape(rows, 1), tensor.WithBacking(y))
+}
+
+func TestTenant(t *testing.T) {
+	Convey("tenant registry", t, func() {
+		r := NewTenantRegistry()
+		a := NewTenant("a", &constPredictor{score: 1}, "key-a")
+		b := NewTenant("b", &constPredictor{score: 2}, "key-b")
+		So(r.Register(a), ShouldBeNil)
+		So(r.Register(b), ShouldBeNil)
+		So(r.Register(NewTenant("a", &constPredictor{})), ShouldNotBeNil)
+		So(r.Register(NewTenant("c", &constPredictor{}, "key-a")), ShouldNotBeNil)
+		So(r.Register(NewTenant("d", nil)), ShouldNotBeNil)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		_, err := r.Resolve(req)
+		So(err, ShouldNotBeNil)
+		So(r.Register(NewTenant(DefaultTenant, &constPredictor{})), ShouldBeNil)
+		tenant, err := r.Resolve(req)
+		So(err, ShouldBeNil)
+		So(tenant.Name, ShouldEqual, DefaultTenant)
+
+		// the tenant of the api keys is not resolved by the name alone
+		req.Header.Set(TenantHeader, "b")
+		_, err = r.Resolve(req)
+		So(err, ShouldNotBeNil)
+		req.Header.Set(ApiKeyHeader, "key-b")
+		tenant, err = r.Resolve(req)
+		So(err, ShouldBeNil)
+		So(tenant, ShouldEqual, b)
+		// api key takes precedence over the tenant name
+		req.Header.Set(ApiKeyHeader, "key-a")
+		tenant, err = r.Resolve(req)
+		So(err, ShouldBeNil)
+		So(tenant, ShouldEqual, a)
+		req.Header.Set(ApiKeyHeader, "bad")
+		_, err = r.Resolve(req)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("tenant http api", t, func() {
+		r := NewTenantRegistry()
+		a := NewTenant("a", &constPredictor{score: 1}, "key-a")
+		b := NewTenant("b", &constPredictor{score: 2}, "key-b")
+		So(r.Register(a), ShouldBeNil)
+		So(r.Register(b), ShouldBeNil)
+		engine := newTenantEngine(r, "/api/v1/recommend")
+
+		recommend := func(headers ...string) (int, RecApiResponse) {
+			req := httptest.NewRequest("POST", "/api/v1/recommend",
+				strings.NewReader(`{"userId":1,"itemIdList":[3,4]}`))
+			req.Header.Set("Content-Type", "application/json")
+			for i := 0; i < len(headers); i += 2 {
+				req.Header.Set(headers[i], headers[i+1])
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			var resp RecApiResponse
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			return w.Code, resp
+		}
+		code, resp := recommend(TenantHeader, "b", ApiKeyHeader, "key-b")
+		So(code, ShouldEqual, http.StatusOK)
+		So(resp.ItemScoreList, ShouldResemble, []ItemScore{{ItemId: 3, Score: 2}, {ItemId: 4, Score: 2}})
+		code, resp = recommend(ApiKeyHeader, "key-a")
+		So(code, ShouldEqual, http.StatusOK)
+		So(resp.ItemScoreList[0].Score, ShouldEqual, 1)
+		code, _ = recommend(TenantHeader, "c")
+		So(code, ShouldEqual, http.StatusUnauthorized)
+		// no isolation bypass by the tenant name without the api key
+		code, _ = recommend(TenantHeader, "b")
+		So(code, ShouldEqual, http.StatusUnauthorized)
+		code, _ = recommend(TenantHeader, "b", ApiKeyHeader, "bad")
+		So(code, ShouldEqual, http.StatusUnauthorized)
+
+		So(b.userFeatureCache.ItemCount(), ShouldEqual, 1)
+		So(b.itemFeatureCache.ItemCount(), ShouldEqual, 2)
+		So(r.Stats(), ShouldResemble, []TenantStats{
+			{Name: "a", Requests: 1},
+			{Name: "b", Requests: 1},
+		})
+	})
+}
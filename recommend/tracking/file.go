@@ -0,0 +1,303 @@
+package tracking
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the run status of the MLflow file store
+var fileStatus = map[Status]int{
+	StatusRunning:  1,
+	StatusFinished: 3,
+	StatusFailed:   4,
+	StatusKilled:   5,
+}
+
+// FileTracker logs to a local directory in the layout of the MLflow file
+// store: <root>/<experiment id>/<run id>/{meta.yaml,params,metrics,tags,artifacts}
+type FileTracker struct {
+	root string
+	mu   sync.Mutex
+}
+
+// NewFileTracker creates the tracker of root, e.g. "mlruns"
+func NewFileTracker(root string) (t *FileTracker, err error) {
+	if root, err = filepath.Abs(root); err != nil {
+		return
+	}
+	if err = os.MkdirAll(root, 0755); err != nil {
+		return
+	}
+	return &FileTracker{root: root}, nil
+}
+
+type fileExperiment struct {
+	ArtifactLocation string `yaml:"artifact_location"`
+	CreationTime     int64  `yaml:"creation_time"`
+	ExperimentId     string `yaml:"experiment_id"`
+	LastUpdateTime   int64  `yaml:"last_update_time"`
+	LifecycleStage   string `yaml:"lifecycle_stage"`
+	Name             string `yaml:"name"`
+}
+
+type fileRunMeta struct {
+	ArtifactUri    string   `yaml:"artifact_uri"`
+	EndTime        *int64   `yaml:"end_time"`
+	EntryPointName string   `yaml:"entry_point_name"`
+	ExperimentId   string   `yaml:"experiment_id"`
+	LifecycleStage string   `yaml:"lifecycle_stage"`
+	RunId          string   `yaml:"run_id"`
+	RunName        string   `yaml:"run_name"`
+	RunUuid        string   `yaml:"run_uuid"`
+	SourceName     string   `yaml:"source_name"`
+	SourceType     int      `yaml:"source_type"`
+	SourceVersion  string   `yaml:"source_version"`
+	StartTime      int64    `yaml:"start_time"`
+	Status         int      `yaml:"status"`
+	Tags           []string `yaml:"tags"`
+	UserId         string   `yaml:"user_id"`
+}
+
+func fileUri(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func writeYaml(path string, v interface{}) (err error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, path)
+}
+
+// experimentId returns the id of the experiment name, which is created of
+// the next integer id if not found. The DefaultExperiment is "0".
+func (t *FileTracker) experimentId(name string) (id string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries, err := os.ReadDir(t.root)
+	if err != nil {
+		return
+	}
+	next := 1
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(t.root, e.Name(), "meta.yaml"))
+		if err != nil {
+			continue
+		}
+		var exp fileExperiment
+		if yaml.Unmarshal(data, &exp) != nil {
+			continue
+		}
+		if exp.Name == name && exp.LifecycleStage == "active" {
+			return exp.ExperimentId, nil
+		}
+		if n, err := strconv.Atoi(exp.ExperimentId); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	id = strconv.Itoa(next)
+	if name == DefaultExperiment {
+		id = "0"
+	}
+	dir := filepath.Join(t.root, id)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	now := timestamp(time.Time{})
+	err = writeYaml(filepath.Join(dir, "meta.yaml"), fileExperiment{
+		ArtifactLocation: fileUri(dir),
+		CreationTime:     now,
+		ExperimentId:     id,
+		LastUpdateTime:   now,
+		LifecycleStage:   "active",
+		Name:             name,
+	})
+	return
+}
+
+func (t *FileTracker) StartRun(_ context.Context, experiment, runName string, tags map[string]string) (Run, error) {
+	if experiment == "" {
+		experiment = DefaultExperiment
+	}
+	experimentId, err := t.experimentId(experiment)
+	if err != nil {
+		return nil, err
+	}
+	var b [16]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	runId := hex.EncodeToString(b[:])
+	dir := filepath.Join(t.root, experimentId, runId)
+	for _, sub := range []string{"params", "metrics", "tags", "artifacts"} {
+		if err = os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	var userId string
+	if u, err := user.Current(); err == nil {
+		userId = u.Username
+	}
+	r := &fileRun{
+		dir: dir,
+		meta: fileRunMeta{
+			ArtifactUri:    fileUri(filepath.Join(dir, "artifacts")),
+			ExperimentId:   experimentId,
+			LifecycleStage: "active",
+			RunId:          runId,
+			RunName:        runName,
+			RunUuid:        runId,
+			// LOCAL
+			SourceType: 4,
+			StartTime:  timestamp(time.Time{}),
+			Status:     fileStatus[StatusRunning],
+			Tags:       []string{},
+			UserId:     userId,
+		},
+	}
+	if err = writeYaml(filepath.Join(dir, "meta.yaml"), r.meta); err != nil {
+		return nil, err
+	}
+	if runName != "" {
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		tags["mlflow.runName"] = runName
+	}
+	if err = r.SetTags(context.Background(), tags); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type fileRun struct {
+	dir  string
+	mu   sync.Mutex
+	meta fileRunMeta
+}
+
+func (r *fileRun) Id() string {
+	return r.meta.RunId
+}
+
+// path returns the file of key in the sub directory, the parents are created
+func (r *fileRun) path(sub, key string) (path string, err error) {
+	if err = validKey(key); err != nil {
+		return
+	}
+	path = filepath.Join(r.dir, sub, filepath.FromSlash(key))
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	return
+}
+
+func (r *fileRun) LogParams(_ context.Context, params map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, v := range params {
+		path, err := r.path("params", k)
+		if err != nil {
+			return err
+		}
+		if old, err := os.ReadFile(path); err == nil {
+			if string(old) != v {
+				return fmt.Errorf("param %s already logged as %q != %q", k, old, v)
+			}
+			continue
+		}
+		if err = os.WriteFile(path, []byte(v), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *fileRun) LogMetrics(_ context.Context, metrics ...Metric) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := make(map[string]*strings.Builder)
+	var keys []string
+	for _, m := range metrics {
+		b, ok := lines[m.Key]
+		if !ok {
+			b = new(strings.Builder)
+			lines[m.Key] = b
+			keys = append(keys, m.Key)
+		}
+		fmt.Fprintf(b, "%d %s %d\n", timestamp(m.Timestamp), strconv.FormatFloat(m.Value, 'g', -1, 64), m.Step)
+	}
+	for _, k := range keys {
+		path, err := r.path("metrics", k)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err = f.WriteString(lines[k].String()); err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *fileRun) SetTags(_ context.Context, tags map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, v := range tags {
+		path, err := r.path("tags", k)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(path, []byte(v), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *fileRun) LogArtifact(_ context.Context, path string, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file, err := r.path("artifacts", path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+func (r *fileRun) End(_ context.Context, status Status) error {
+	code, ok := fileStatus[status]
+	if !ok {
+		return fmt.Errorf("unknown run status %s", status)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	end := timestamp(time.Time{})
+	r.meta.Status, r.meta.EndTime = code, &end
+	return writeYaml(filepath.Join(r.dir, "meta.yaml"), r.meta)
+}
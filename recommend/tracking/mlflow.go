@@ -0,0 +1,280 @@
+package tracking
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	mlflowApi          = "/api/2.0/mlflow/"
+	mlflowArtifactsApi = "/api/2.0/mlflow-artifacts/artifacts/"
+	// mlflowBatchSize is the max metrics, params and tags of a log-batch call
+	mlflowBatchSize = 1000
+	// mlflowBatchParams and mlflowBatchTags are the max params and tags of
+	// a log-batch call
+	mlflowBatchParams = 100
+	mlflowBatchTags   = 100
+)
+
+// MLflowTracker logs to an MLflow tracking server. The artifacts are uploaded
+// by the artifacts proxy of the server, which is served by
+// `mlflow server --serve-artifacts`.
+type MLflowTracker struct {
+	baseUrl string
+	client  *http.Client
+	// Token is the bearer token of the requests if not empty
+	Token string
+}
+
+// NewMLflowTracker creates the tracker of the server at baseUrl, e.g.
+// "http://localhost:5000". client is http.DefaultClient if nil.
+func NewMLflowTracker(baseUrl string, client *http.Client) *MLflowTracker {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &MLflowTracker{baseUrl: strings.TrimRight(baseUrl, "/"), client: client}
+}
+
+type mlflowTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type mlflowMetric struct {
+	Key       string  `json:"key"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	Step      int64   `json:"step"`
+}
+
+type mlflowError struct {
+	ErrorCode string `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (t *MLflowTracker) call(ctx context.Context, method, api string, req, resp interface{}) (err error) {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, t.baseUrl+mlflowApi+api, body)
+	if err != nil {
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return t.do(httpReq, api, resp)
+}
+
+func (t *MLflowTracker) do(req *http.Request, api string, resp interface{}) (err error) {
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	httpResp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mlflow %s: %v", api, err)
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("mlflow %s: %v", api, err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var e mlflowError
+		if json.Unmarshal(data, &e) == nil && e.ErrorCode != "" {
+			return &APIError{Status: httpResp.StatusCode, Code: e.ErrorCode, Message: e.Message}
+		}
+		return &APIError{Status: httpResp.StatusCode, Message: string(data)}
+	}
+	if resp != nil && len(data) != 0 {
+		if err = json.Unmarshal(data, resp); err != nil {
+			return fmt.Errorf("mlflow %s response: %v", api, err)
+		}
+	}
+	return
+}
+
+// APIError is the error response of the MLflow server
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("mlflow error %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// experimentId returns the id of the experiment name, which is created if
+// not found
+func (t *MLflowTracker) experimentId(ctx context.Context, name string) (id string, err error) {
+	var got struct {
+		Experiment struct {
+			ExperimentId string `json:"experiment_id"`
+		} `json:"experiment"`
+	}
+	err = t.call(ctx, http.MethodGet, "experiments/get-by-name?experiment_name="+url.QueryEscape(name), nil, &got)
+	if err == nil {
+		return got.Experiment.ExperimentId, nil
+	}
+	if e, ok := err.(*APIError); !ok || e.Code != "RESOURCE_DOES_NOT_EXIST" {
+		return
+	}
+	var created struct {
+		ExperimentId string `json:"experiment_id"`
+	}
+	if err = t.call(ctx, http.MethodPost, "experiments/create", map[string]string{"name": name}, &created); err != nil {
+		return
+	}
+	return created.ExperimentId, nil
+}
+
+func (t *MLflowTracker) StartRun(ctx context.Context, experiment, runName string, tags map[string]string) (Run, error) {
+	if experiment == "" {
+		experiment = DefaultExperiment
+	}
+	experimentId, err := t.experimentId(ctx, experiment)
+	if err != nil {
+		return nil, err
+	}
+	req := struct {
+		ExperimentId string      `json:"experiment_id"`
+		RunName      string      `json:"run_name,omitempty"`
+		StartTime    int64       `json:"start_time"`
+		Tags         []mlflowTag `json:"tags,omitempty"`
+	}{ExperimentId: experimentId, RunName: runName, StartTime: timestamp(time.Time{}), Tags: mlflowTags(tags)}
+	var resp struct {
+		Run struct {
+			Info struct {
+				RunId       string `json:"run_id"`
+				ArtifactUri string `json:"artifact_uri"`
+			} `json:"info"`
+		} `json:"run"`
+	}
+	if err = t.call(ctx, http.MethodPost, "runs/create", req, &resp); err != nil {
+		return nil, err
+	}
+	return &mlflowRun{
+		tracker:      t,
+		id:           resp.Run.Info.RunId,
+		experimentId: experimentId,
+		artifactUri:  resp.Run.Info.ArtifactUri,
+	}, nil
+}
+
+func mlflowTags(tags map[string]string) []mlflowTag {
+	var list []mlflowTag
+	for k, v := range tags {
+		list = append(list, mlflowTag{Key: k, Value: v})
+	}
+	return list
+}
+
+type mlflowRun struct {
+	tracker      *MLflowTracker
+	id           string
+	experimentId string
+	artifactUri  string
+}
+
+func (r *mlflowRun) Id() string {
+	return r.id
+}
+
+type mlflowBatch struct {
+	RunId   string         `json:"run_id"`
+	Metrics []mlflowMetric `json:"metrics,omitempty"`
+	Params  []mlflowTag    `json:"params,omitempty"`
+	Tags    []mlflowTag    `json:"tags,omitempty"`
+}
+
+// logBatch logs by the log-batch calls of at most mlflowBatchSize entries,
+// of which at most mlflowBatchParams params and mlflowBatchTags tags
+func (r *mlflowRun) logBatch(ctx context.Context, metrics []mlflowMetric, params, tags []mlflowTag) (err error) {
+	for len(metrics)+len(params)+len(tags) != 0 {
+		batch := mlflowBatch{RunId: r.id}
+		n := mlflowBatchSize
+		take := func(list []mlflowTag, limit int) (head, rest []mlflowTag) {
+			if len(list) > limit {
+				head, rest = list[:limit], list[limit:]
+			} else {
+				head = list
+			}
+			n -= len(head)
+			return
+		}
+		batch.Params, params = take(params, mlflowBatchParams)
+		batch.Tags, tags = take(tags, mlflowBatchTags)
+		if len(metrics) > n {
+			batch.Metrics, metrics = metrics[:n], metrics[n:]
+		} else {
+			batch.Metrics, metrics = metrics, nil
+		}
+		if err = r.tracker.call(ctx, http.MethodPost, "runs/log-batch", batch, nil); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (r *mlflowRun) LogParams(ctx context.Context, params map[string]string) error {
+	return r.logBatch(ctx, nil, mlflowTags(params), nil)
+}
+
+func (r *mlflowRun) LogMetrics(ctx context.Context, metrics ...Metric) error {
+	list := make([]mlflowMetric, len(metrics))
+	for i, m := range metrics {
+		list[i] = mlflowMetric{Key: m.Key, Value: m.Value, Timestamp: timestamp(m.Timestamp), Step: m.Step}
+	}
+	return r.logBatch(ctx, list, nil, nil)
+}
+
+func (r *mlflowRun) SetTags(ctx context.Context, tags map[string]string) error {
+	return r.logBatch(ctx, nil, nil, mlflowTags(tags))
+}
+
+// LogArtifact uploads by the artifacts proxy, the run artifact uri should be
+// of the "mlflow-artifacts:" scheme
+func (r *mlflowRun) LogArtifact(ctx context.Context, path string, data []byte) (err error) {
+	if err = validKey(path); err != nil {
+		return
+	}
+	root := strings.TrimPrefix(r.artifactUri, "mlflow-artifacts:")
+	if root == r.artifactUri {
+		return fmt.Errorf("artifact uri %q is not proxied by the mlflow server", r.artifactUri)
+	}
+	// "mlflow-artifacts://host/path" or "mlflow-artifacts:/path"
+	if strings.HasPrefix(root, "//") {
+		if i := strings.Index(root[2:], "/"); i >= 0 {
+			root = root[2+i:]
+		} else {
+			root = ""
+		}
+	}
+	api := strings.TrimPrefix(strings.TrimRight(root, "/")+"/"+path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, r.tracker.baseUrl+mlflowArtifactsApi+api, bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return r.tracker.do(req, "artifacts/"+api, nil)
+}
+
+func (r *mlflowRun) End(ctx context.Context, status Status) error {
+	req := struct {
+		RunId   string `json:"run_id"`
+		Status  Status `json:"status"`
+		EndTime int64  `json:"end_time"`
+	}{RunId: r.id, Status: status, EndTime: timestamp(time.Time{})}
+	return r.tracker.call(ctx, http.MethodPost, "runs/update", req, nil)
+}
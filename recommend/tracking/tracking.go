@@ -0,0 +1,106 @@
+// Package tracking reports the training runs to an experiment tracker, the
+// params, metrics and artifacts of every run are logged in the schema of
+// MLflow. NewMLflowTracker logs to an MLflow tracking server by its REST API,
+// NewFileTracker to a local directory in the layout of the MLflow file store,
+// which could be browsed by `mlflow ui --backend-store-uri <dir>`.
+package tracking
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultExperiment is the experiment of the runs started with no name
+const DefaultExperiment = "Default"
+
+// Status is the MLflow run status
+type Status string
+
+const (
+	StatusRunning  Status = "RUNNING"
+	StatusFinished Status = "FINISHED"
+	StatusFailed   Status = "FAILED"
+	StatusKilled   Status = "KILLED"
+)
+
+// Metric is a value of a metric at a step, e.g. the loss of an epoch
+type Metric struct {
+	Key       string
+	Value     float64
+	Step      int64
+	Timestamp time.Time
+}
+
+// Tracker starts the runs of the experiments
+type Tracker interface {
+	// StartRun creates a run of experiment, which is created if not found.
+	// tags are set on the run, e.g. the code version.
+	StartRun(ctx context.Context, experiment, runName string, tags map[string]string) (Run, error)
+}
+
+// Run is a training run in progress, it must be finished by End
+type Run interface {
+	// Id is the run id of the tracker
+	Id() string
+	// LogParams logs the hyper parameters, a param could be logged only once
+	LogParams(ctx context.Context, params map[string]string) error
+	// LogMetrics logs the metrics, the zero Timestamp is now
+	LogMetrics(ctx context.Context, metrics ...Metric) error
+	// SetTags sets the tags, the existing are overwritten
+	SetTags(ctx context.Context, tags map[string]string) error
+	// LogArtifact stores data as the artifact of path relative to the run
+	// artifact root, e.g. "model/manifest.json"
+	LogArtifact(ctx context.Context, path string, data []byte) error
+	// End marks the run status and the end time
+	End(ctx context.Context, status Status) error
+}
+
+// Metrics returns the metrics of values at step
+func Metrics(values map[string]float64, step int64) []Metric {
+	now := time.Now()
+	metrics := make([]Metric, 0, len(values))
+	for k, v := range values {
+		metrics = append(metrics, Metric{Key: k, Value: v, Step: step, Timestamp: now})
+	}
+	return metrics
+}
+
+func timestamp(t time.Time) int64 {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UnixMilli()
+}
+
+// validKey checks the param, metric, tag or artifact name is a relative path
+// without "..", as MLflow requires
+func validKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("empty key")
+	}
+	if strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("key %q should be a relative path", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+type runKey struct{}
+
+// NewContext returns the ctx of run, e.g. for the TrainFunc of the retrain
+// Scheduler to log the epoch losses
+func NewContext(ctx context.Context, run Run) context.Context {
+	return context.WithValue(ctx, runKey{}, run)
+}
+
+// FromContext returns the run of ctx, nil if none
+func FromContext(ctx context.Context) Run {
+	run, _ := ctx.Value(runKey{}).(Run)
+	return run
+}
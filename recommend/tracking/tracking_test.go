@@ -0,0 +1,189 @@
+package tracking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFileTracker(t *testing.T) {
+	ctx := context.Background()
+	Convey("run in the mlflow file store layout", t, func() {
+		root := t.TempDir()
+		tracker, err := NewFileTracker(root)
+		So(err, ShouldBeNil)
+		run, err := tracker.StartRun(ctx, "ctr", "nightly", map[string]string{"codeVersion": "abc"})
+		So(err, ShouldBeNil)
+
+		So(run.LogParams(ctx, map[string]string{"batchSize": "200", "lr": "0.01"}), ShouldBeNil)
+		So(run.LogParams(ctx, map[string]string{"batchSize": "200"}), ShouldBeNil)
+		So(run.LogParams(ctx, map[string]string{"batchSize": "100"}), ShouldNotBeNil)
+		So(run.LogMetrics(ctx, Metric{Key: "loss", Value: 0.5, Step: 0}, Metric{Key: "loss", Value: 0.25, Step: 1}), ShouldBeNil)
+		So(run.LogMetrics(ctx, Metrics(map[string]float64{"auc": 0.75}, 0)...), ShouldBeNil)
+		So(run.LogArtifact(ctx, "model/manifest.json", []byte("{}")), ShouldBeNil)
+		So(run.LogArtifact(ctx, "../escape", []byte("x")), ShouldNotBeNil)
+		So(run.End(ctx, StatusFinished), ShouldBeNil)
+
+		// the first experiment of name is 1, the Default is 0
+		dir := filepath.Join(root, "1", run.Id())
+		var exp fileExperiment
+		data, err := os.ReadFile(filepath.Join(root, "1", "meta.yaml"))
+		So(err, ShouldBeNil)
+		So(yaml.Unmarshal(data, &exp), ShouldBeNil)
+		So(exp.Name, ShouldEqual, "ctr")
+		So(exp.ExperimentId, ShouldEqual, "1")
+
+		var meta fileRunMeta
+		data, err = os.ReadFile(filepath.Join(dir, "meta.yaml"))
+		So(err, ShouldBeNil)
+		So(yaml.Unmarshal(data, &meta), ShouldBeNil)
+		So(meta.Status, ShouldEqual, 3)
+		So(meta.EndTime, ShouldNotBeNil)
+		So(meta.RunName, ShouldEqual, "nightly")
+
+		read := func(path string) string {
+			data, err := os.ReadFile(filepath.Join(dir, path))
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+		So(read("params/lr"), ShouldEqual, "0.01")
+		So(read("tags/codeVersion"), ShouldEqual, "abc")
+		So(read("tags/mlflow.runName"), ShouldEqual, "nightly")
+		So(read("artifacts/model/manifest.json"), ShouldEqual, "{}")
+		lines := strings.Split(strings.TrimSpace(read("metrics/loss")), "\n")
+		So(lines, ShouldHaveLength, 2)
+		So(strings.Fields(lines[1])[1:], ShouldResemble, []string{"0.25", "1"})
+
+		Convey("the experiment is reused by name", func() {
+			again, err := tracker.StartRun(ctx, "ctr", "", nil)
+			So(err, ShouldBeNil)
+			So(again.Id(), ShouldNotEqual, run.Id())
+			_, err = os.Stat(filepath.Join(root, "1", again.Id(), "meta.yaml"))
+			So(err, ShouldBeNil)
+
+			other, err := tracker.StartRun(ctx, "", "", nil)
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(root, "0", other.Id()))
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+// fakeMLflow is the subset of the MLflow REST API used by MLflowTracker
+type fakeMLflow struct {
+	mu          sync.Mutex
+	experiments map[string]string
+	batches     []mlflowBatch
+	status      Status
+	artifacts   map[string]string
+}
+
+func (f *fakeMLflow) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	reply := func(v interface{}) {
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	switch {
+	case r.URL.Path == mlflowApi+"experiments/get-by-name":
+		id, ok := f.experiments[r.URL.Query().Get("experiment_name")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			reply(mlflowError{ErrorCode: "RESOURCE_DOES_NOT_EXIST", Message: "not found"})
+			return
+		}
+		reply(map[string]interface{}{"experiment": map[string]string{"experiment_id": id}})
+	case r.URL.Path == mlflowApi+"experiments/create":
+		var req map[string]string
+		_ = json.Unmarshal(body, &req)
+		f.experiments[req["name"]] = "7"
+		reply(map[string]string{"experiment_id": "7"})
+	case r.URL.Path == mlflowApi+"runs/create":
+		reply(map[string]interface{}{"run": map[string]interface{}{"info": map[string]string{
+			"run_id":       "r1",
+			"artifact_uri": "mlflow-artifacts:/7/r1/artifacts",
+		}}})
+	case r.URL.Path == mlflowApi+"runs/log-batch":
+		var batch mlflowBatch
+		_ = json.Unmarshal(body, &batch)
+		f.batches = append(f.batches, batch)
+		reply(map[string]string{})
+	case r.URL.Path == mlflowApi+"runs/update":
+		var req struct {
+			Status Status `json:"status"`
+		}
+		_ = json.Unmarshal(body, &req)
+		f.status = req.Status
+		reply(map[string]string{})
+	case strings.HasPrefix(r.URL.Path, mlflowArtifactsApi) && r.Method == http.MethodPut:
+		f.artifacts[strings.TrimPrefix(r.URL.Path, mlflowArtifactsApi)] = string(body)
+		reply(map[string]string{})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestMLflowTracker(t *testing.T) {
+	ctx := context.Background()
+	Convey("run on the mlflow server", t, func() {
+		fake := &fakeMLflow{experiments: map[string]string{}, artifacts: map[string]string{}}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		tracker := NewMLflowTracker(server.URL+"/", nil)
+		run, err := tracker.StartRun(ctx, "ctr", "nightly", nil)
+		So(err, ShouldBeNil)
+		So(run.Id(), ShouldEqual, "r1")
+		So(fake.experiments["ctr"], ShouldEqual, "7")
+
+		So(run.LogParams(ctx, map[string]string{"lr": "0.01"}), ShouldBeNil)
+		metrics := make([]Metric, mlflowBatchSize+1)
+		for i := range metrics {
+			metrics[i] = Metric{Key: "loss", Value: 1 / float64(i+1), Step: int64(i)}
+		}
+		So(run.LogMetrics(ctx, metrics...), ShouldBeNil)
+		So(run.LogArtifact(ctx, "manifest.json", []byte("{}")), ShouldBeNil)
+		So(run.End(ctx, StatusFinished), ShouldBeNil)
+
+		So(fake.batches, ShouldHaveLength, 3)
+		So(fake.batches[0].Params, ShouldResemble, []mlflowTag{{Key: "lr", Value: "0.01"}})
+		So(fake.batches[1].Metrics, ShouldHaveLength, mlflowBatchSize)
+		So(fake.batches[2].Metrics[0].Step, ShouldEqual, mlflowBatchSize)
+		So(fake.artifacts["7/r1/artifacts/manifest.json"], ShouldEqual, "{}")
+		So(fake.status, ShouldEqual, StatusFinished)
+
+		Convey("the params and tags chunked by their own limits", func() {
+			fake.batches = nil
+			params := make(map[string]string, mlflowBatchParams+1)
+			for i := 0; i <= mlflowBatchParams; i++ {
+				params[fmt.Sprintf("p%d", i)] = "1"
+			}
+			So(run.LogParams(ctx, params), ShouldBeNil)
+			So(run.SetTags(ctx, params), ShouldBeNil)
+			So(fake.batches, ShouldHaveLength, 4)
+			So(fake.batches[0].Params, ShouldHaveLength, mlflowBatchParams)
+			So(fake.batches[1].Params, ShouldHaveLength, 1)
+			So(fake.batches[2].Tags, ShouldHaveLength, mlflowBatchTags)
+			So(fake.batches[3].Tags, ShouldHaveLength, 1)
+		})
+
+		Convey("the server errors", func() {
+			err := run.SetTags(ctx, nil)
+			So(err, ShouldBeNil)
+			_, err = NewMLflowTracker(server.URL+"/missing", nil).StartRun(ctx, "ctr", "", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
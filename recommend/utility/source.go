@@ -0,0 +1,48 @@
+package utility
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileSource loads the formula from a JSON file, or a YAML file if the path
+// ends with .yaml or .yml. The file holds a Formula, e.g.
+//
+//	combine: product
+//	terms:
+//	  - value: price
+//	    weight: 1
+//	  - value: publishedAt
+//	    weight: 0.5
+//	    transform: freshness
+//	    halfLife: 72h
+type FileSource struct {
+	Path string
+}
+
+func (s *FileSource) Load(_ context.Context) (formula Formula, version string, err error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(filepath.Ext(s.Path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &formula)
+	default:
+		err = json.Unmarshal(data, &formula)
+	}
+	if err != nil {
+		err = fmt.Errorf("parse utility formula file %s: %v", s.Path, err)
+		return
+	}
+	sum := sha1.Sum(data)
+	return formula, hex.EncodeToString(sum[:]), nil
+}
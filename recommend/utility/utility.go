@@ -0,0 +1,290 @@
+// Package utility is the value model rerank stage: the predicted CTR of the
+// items is blended with their business values, e.g. price, margin or the
+// content freshness, by the utility Formula. The Formula is loaded from a
+// JSON/YAML file and hot reloaded on change, so the term weights are tuned
+// without restart.
+package utility
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	log "github.com/sirupsen/logrus"
+)
+
+type Combine string
+
+const (
+	// Sum is the utility ctrWeight * ctr + Σ weight * term
+	Sum Combine = "sum"
+	// Product is the utility ctr ^ ctrWeight * Π term ^ weight, e.g. the
+	// expected revenue ctr * price of the weights 1
+	Product Combine = "product"
+)
+
+type Transform string
+
+const (
+	// Identity is the value as is
+	Identity Transform = "identity"
+	// Log1p is log(1 + value) of the long tailed values like price
+	Log1p Transform = "log1p"
+	// Freshness is 2 ^ (-age / HalfLife) of the value in unix seconds, 1 of
+	// the items just published
+	Freshness Transform = "freshness"
+)
+
+// Duration is the time.Duration of the formula files, of the text of
+// time.ParseDuration, e.g. "72h", or the number of nanoseconds in JSON
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return d.UnmarshalText([]byte(text))
+	}
+	var ns int64
+	if err := json.Unmarshal(data, &ns); err != nil {
+		return fmt.Errorf("duration %s should be a string like \"72h\" or the nanoseconds", data)
+	}
+	*d = Duration(ns)
+	return nil
+}
+
+// Term is a business value of the items in the utility
+type Term struct {
+	// Value is the name of the item value, see ItemValuer
+	Value  string  `json:"value" yaml:"value"`
+	Weight float64 `json:"weight" yaml:"weight"`
+	// Transform of the value, Identity if empty
+	Transform Transform `json:"transform" yaml:"transform"`
+	// HalfLife of the Freshness transform
+	HalfLife Duration `json:"halfLife" yaml:"halfLife"`
+	// Default is the value of the items of no such value
+	Default float64 `json:"default" yaml:"default"`
+}
+
+// Formula is the utility of the items of the predicted CTR and the Terms
+type Formula struct {
+	// Combine is Sum or Product, Sum if empty
+	Combine Combine `json:"combine" yaml:"combine"`
+	// CTRWeight of the predicted CTR, 0 means 1
+	CTRWeight float64 `json:"ctrWeight" yaml:"ctrWeight"`
+	Terms     []Term  `json:"terms" yaml:"terms"`
+}
+
+// Validate checks the formula is well-formed
+func (f *Formula) Validate() error {
+	switch f.Combine {
+	case "", Sum, Product:
+	default:
+		return fmt.Errorf("unknown combine %q", f.Combine)
+	}
+	seen := make(map[string]bool, len(f.Terms))
+	for _, t := range f.Terms {
+		if t.Value == "" {
+			return fmt.Errorf("term of empty value")
+		}
+		if seen[t.Value] {
+			return fmt.Errorf("duplicated term %s", t.Value)
+		}
+		seen[t.Value] = true
+		switch t.Transform {
+		case "", Identity, Log1p:
+		case Freshness:
+			if t.HalfLife <= 0 {
+				return fmt.Errorf("freshness term %s of half life %v, should be positive", t.Value, t.HalfLife)
+			}
+		default:
+			return fmt.Errorf("term %s has unknown transform %q", t.Value, t.Transform)
+		}
+	}
+	return nil
+}
+
+func (f *Formula) ctrWeight() float64 {
+	if f.CTRWeight == 0 {
+		return 1
+	}
+	return f.CTRWeight
+}
+
+// transform returns the term of value at now
+func (t *Term) transform(value float64, now time.Time) float64 {
+	switch t.Transform {
+	case Log1p:
+		return math.Log1p(value)
+	case Freshness:
+		age := now.Sub(time.Unix(int64(value), 0))
+		if age < 0 {
+			age = 0
+		}
+		return math.Exp2(-float64(age) / float64(t.HalfLife))
+	default:
+		return value
+	}
+}
+
+// Utility is the utility of the item of ctr and values at now
+func (f *Formula) Utility(ctr float64, values map[string]float64, now time.Time) float64 {
+	product := f.Combine == Product
+	u := f.ctrWeight() * ctr
+	if product {
+		u = math.Pow(ctr, f.ctrWeight())
+	}
+	for i := range f.Terms {
+		t := &f.Terms[i]
+		value, ok := values[t.Value]
+		if !ok {
+			value = t.Default
+		}
+		if product {
+			u *= math.Pow(t.transform(value, now), t.Weight)
+		} else {
+			u += t.Weight * t.transform(value, now)
+		}
+	}
+	return u
+}
+
+// ItemValuer provides the business values of the items of the Terms
+type ItemValuer interface {
+	GetItemValues(ctx context.Context, itemId int) (map[string]float64, error)
+}
+
+// Source loads the formula, version changes whenever the formula changes
+type Source interface {
+	Load(ctx context.Context) (formula Formula, version string, err error)
+}
+
+// Engine applies the formula loaded from the Source
+type Engine struct {
+	source Source
+	// now is time.Now, overridden by the tests
+	now func() time.Time
+
+	mu      sync.RWMutex
+	formula Formula
+	version string
+}
+
+// NewEngine creates the Engine and loads the formula from source
+func NewEngine(ctx context.Context, source Source) (e *Engine, err error) {
+	e = &Engine{source: source, now: time.Now}
+	if _, err = e.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Formula returns the formula in use and its version
+func (e *Engine) Formula() (Formula, string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.formula, e.version
+}
+
+// Reload loads the formula if the source version changed. On the invalid
+// formula the one in use is kept.
+func (e *Engine) Reload(ctx context.Context) (changed bool, err error) {
+	formula, version, err := e.source.Load(ctx)
+	if err != nil {
+		return
+	}
+	if _, current := e.Formula(); current == version && current != "" {
+		return false, nil
+	}
+	if err = formula.Validate(); err != nil {
+		return
+	}
+	e.mu.Lock()
+	e.formula, e.version = formula, version
+	e.mu.Unlock()
+	log.Infof("loaded utility formula of %d terms, version %s", len(formula.Terms), version)
+	return true, nil
+}
+
+// Watch reloads the formula every interval until ctx is done
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := e.Reload(ctx); err != nil {
+					log.Errorf("reload utility formula error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// ReRank replaces the CTR scores of itemScores by the utility of the item
+// values of valuer, and sorts them by the utility descending. valuer could
+// be nil if the formula has no terms.
+func (e *Engine) ReRank(ctx context.Context, valuer ItemValuer, itemScores []rcmd.ItemScore) (result []rcmd.ItemScore, err error) {
+	formula, _ := e.Formula()
+	if len(formula.Terms) == 0 && formula.ctrWeight() == 1 {
+		return itemScores, nil
+	}
+	if len(formula.Terms) != 0 && valuer == nil {
+		return nil, fmt.Errorf("utility formula of %d terms of no item valuer", len(formula.Terms))
+	}
+	now := e.now()
+	result = make([]rcmd.ItemScore, len(itemScores))
+	for i, is := range itemScores {
+		var values map[string]float64
+		if len(formula.Terms) != 0 {
+			if values, err = valuer.GetItemValues(ctx, is.ItemId); err != nil {
+				return nil, err
+			}
+		}
+		is.Score = float32(formula.Utility(float64(is.Score), values, now))
+		result[i] = is
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Score > result[j].Score
+	})
+	return
+}
+
+// ReRanker returns the rcmd.ReRanker of the engine of the item values of
+// valuer, e.g. of rcmd.WithReRankers
+func (e *Engine) ReRanker(valuer ItemValuer) rcmd.ReRanker {
+	return &reRanker{engine: e, valuer: valuer}
+}
+
+type reRanker struct {
+	engine *Engine
+	valuer ItemValuer
+}
+
+func (r *reRanker) ReRank(ctx context.Context, _ int, itemScores []rcmd.ItemScore) ([]rcmd.ItemScore, error) {
+	return r.engine.ReRank(ctx, r.valuer, itemScores)
+}
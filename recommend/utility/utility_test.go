@@ -0,0 +1,163 @@
+package utility
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rcmd "github.com/auxten/go-ctr/recommend"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeCatalog map[int]map[string]float64
+
+func (c fakeCatalog) GetItemValues(_ context.Context, itemId int) (map[string]float64, error) {
+	return c[itemId], nil
+}
+
+const yamlFormula = `
+combine: product
+terms:
+  - value: price
+    weight: 1
+  - value: publishedAt
+    weight: 1
+    transform: freshness
+    halfLife: 24h
+    default: 0
+`
+
+func TestFormula(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	Convey("sum of the terms", t, func() {
+		f := Formula{CTRWeight: 2, Terms: []Term{
+			{Value: "margin", Weight: 0.5},
+			{Value: "price", Weight: 0.1, Transform: Log1p, Default: math.E - 1},
+		}}
+		So(f.Validate(), ShouldBeNil)
+		u := f.Utility(0.2, map[string]float64{"margin": 0.4}, now)
+		So(u, ShouldAlmostEqual, 0.4+0.2+0.1, 1e-9)
+	})
+
+	Convey("product of the terms", t, func() {
+		f := Formula{Combine: Product, Terms: []Term{
+			{Value: "price", Weight: 1},
+			{Value: "publishedAt", Weight: 1, Transform: Freshness, HalfLife: Duration(time.Hour)},
+		}}
+		So(f.Validate(), ShouldBeNil)
+		values := map[string]float64{"price": 10, "publishedAt": float64(now.Add(-2 * time.Hour).Unix())}
+		So(f.Utility(0.2, values, now), ShouldAlmostEqual, 0.2*10*0.25, 1e-9)
+		// published in the future is fresh
+		values["publishedAt"] = float64(now.Add(time.Hour).Unix())
+		So(f.Utility(0.2, values, now), ShouldAlmostEqual, 2, 1e-9)
+	})
+
+	Convey("invalid formulas", t, func() {
+		for _, f := range []Formula{
+			{Combine: "max"},
+			{Terms: []Term{{Weight: 1}}},
+			{Terms: []Term{{Value: "price"}, {Value: "price"}}},
+			{Terms: []Term{{Value: "price", Transform: "sqrt"}}},
+			{Terms: []Term{{Value: "publishedAt", Transform: Freshness}}},
+		} {
+			So(f.Validate(), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestEngine(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		now     = time.Unix(1700000000, 0)
+		catalog = fakeCatalog{
+			1: {"price": 10, "publishedAt": float64(now.Unix())},
+			2: {"price": 100, "publishedAt": float64(now.Add(-48 * time.Hour).Unix())},
+			3: {"price": 5},
+		}
+		scores = []rcmd.ItemScore{{ItemId: 1, Score: 0.2}, {ItemId: 2, Score: 0.1}, {ItemId: 3, Score: 0.9}}
+		path   = filepath.Join(t.TempDir(), "utility.yaml")
+	)
+	if err := os.WriteFile(path, []byte(yamlFormula), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("rerank by the yaml formula", t, func() {
+		e, err := NewEngine(ctx, &FileSource{Path: path})
+		So(err, ShouldBeNil)
+		e.now = func() time.Time { return now }
+		formula, _ := e.Formula()
+		So(formula.Terms[1].HalfLife, ShouldEqual, Duration(24*time.Hour))
+
+		result, err := e.ReRank(ctx, catalog, scores)
+		So(err, ShouldBeNil)
+		// sorted by the utility
+		So(result, ShouldHaveLength, 3)
+		So(result[0].ItemId, ShouldEqual, 2)
+		So(result[0].Score, ShouldAlmostEqual, 2.5, 1e-6)
+		So(result[1].ItemId, ShouldEqual, 1)
+		So(result[1].Score, ShouldAlmostEqual, 2, 1e-6)
+		// no publishedAt, stale by default
+		So(result[2].ItemId, ShouldEqual, 3)
+		So(result[2].Score, ShouldEqual, 0)
+		So(scores[0].Score, ShouldEqual, 0.2)
+
+		result, err = e.ReRanker(catalog).ReRank(ctx, 1, scores)
+		So(err, ShouldBeNil)
+		So(result[0].ItemId, ShouldEqual, 2)
+		_, err = e.ReRanker(nil).ReRank(ctx, 1, scores)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("half life of the json formula", t, func() {
+		for _, halfLife := range []string{`"72h"`, "259200000000000"} {
+			var f Formula
+			So(json.Unmarshal([]byte(`{"terms": [{"value": "publishedAt", "transform": "freshness", "halfLife": `+halfLife+`}]}`), &f), ShouldBeNil)
+			So(f.Terms[0].HalfLife, ShouldEqual, Duration(72*time.Hour))
+			So(f.Validate(), ShouldBeNil)
+		}
+		var f Formula
+		So(json.Unmarshal([]byte(`{"terms": [{"value": "publishedAt", "halfLife": "3 days"}]}`), &f), ShouldNotBeNil)
+	})
+
+	Convey("hot reload keeps the formula on invalid change", t, func() {
+		e, err := NewEngine(ctx, &FileSource{Path: path})
+		So(err, ShouldBeNil)
+		_, version := e.Formula()
+
+		changed, err := e.Reload(ctx)
+		So(err, ShouldBeNil)
+		So(changed, ShouldBeFalse)
+
+		So(os.WriteFile(path, []byte("terms:\n  - value: price\n    transform: sqrt\n"), 0644), ShouldBeNil)
+		_, err = e.Reload(ctx)
+		So(err, ShouldNotBeNil)
+		_, current := e.Formula()
+		So(current, ShouldEqual, version)
+
+		jsonPath := filepath.Join(t.TempDir(), "utility.json")
+		So(os.WriteFile(jsonPath, []byte(`{"ctrWeight": 1, "terms": [{"value": "margin", "weight": 0.5}]}`), 0644), ShouldBeNil)
+		e, err = NewEngine(ctx, &FileSource{Path: jsonPath})
+		So(err, ShouldBeNil)
+		result, err := e.ReRank(ctx, fakeCatalog{1: {"margin": 1}}, []rcmd.ItemScore{{ItemId: 1, Score: 0.2}})
+		So(err, ShouldBeNil)
+		So(result[0].Score, ShouldAlmostEqual, 0.7, 1e-6)
+
+		_, err = NewEngine(ctx, &FileSource{Path: filepath.Join(t.TempDir(), "missing.yaml")})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("no terms keep the scores", t, func() {
+		jsonPath := filepath.Join(t.TempDir(), "utility.json")
+		So(os.WriteFile(jsonPath, []byte(`{}`), 0644), ShouldBeNil)
+		e, err := NewEngine(ctx, &FileSource{Path: jsonPath})
+		So(err, ShouldBeNil)
+		result, err := e.ReRank(ctx, nil, scores)
+		So(err, ShouldBeNil)
+		So(result, ShouldResemble, scores)
+	})
+}
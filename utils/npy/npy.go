@@ -0,0 +1,341 @@
+// Package npy reads and writes the tensors in the NumPy .npy and .npz
+// formats, so the features, embeddings and evaluation sets are exchanged
+// with the Python tooling without the lossy CSV round-trips:
+//
+//	x, err := npy.LoadNpy("x.npy")             // np.load("x.npy")
+//	err = npy.SaveNpz("eval.npz", arrays, true) // np.savez_compressed
+//
+// The little and big-endian float, int, uint and bool dtypes are read, in C
+// or Fortran order. The tensors are written in C order of little-endian,
+// tensor.Int as <i8 which is read back as tensor.Int64.
+package npy
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorgonia.org/tensor"
+)
+
+var magic = []byte("\x93NUMPY")
+
+var (
+	descrRE = regexp.MustCompile(`'descr':\s*'([<>|=])([a-z])(\d+)'`)
+	orderRE = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	shapeRE = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+// dtypes are the numpy kind and size of the tensor dtypes
+var dtypes = []struct {
+	dt   tensor.Dtype
+	kind byte
+	size int
+}{
+	{tensor.Float32, 'f', 4},
+	{tensor.Float64, 'f', 8},
+	{tensor.Int8, 'i', 1},
+	{tensor.Int16, 'i', 2},
+	{tensor.Int32, 'i', 4},
+	{tensor.Int64, 'i', 8},
+	{tensor.Uint8, 'u', 1},
+	{tensor.Uint16, 'u', 2},
+	{tensor.Uint32, 'u', 4},
+	{tensor.Uint64, 'u', 8},
+	{tensor.Bool, 'b', 1},
+}
+
+// descr returns the numpy dtype descr of dt
+func descr(dt tensor.Dtype) (string, error) {
+	for _, d := range dtypes {
+		if d.dt == dt {
+			order := "<"
+			if d.size == 1 {
+				order = "|"
+			}
+			return fmt.Sprintf("%s%c%d", order, d.kind, d.size), nil
+		}
+	}
+	return "", fmt.Errorf("npy: dtype %v not supported", dt)
+}
+
+// shapeString formats shape as the python tuple
+func shapeString(shape tensor.Shape) string {
+	dims := make([]string, len(shape))
+	for i, d := range shape {
+		dims[i] = strconv.Itoa(d)
+	}
+	if len(dims) == 1 {
+		return "(" + dims[0] + ",)"
+	}
+	return "(" + strings.Join(dims, ", ") + ")"
+}
+
+// flatData returns the C ordered data slice of t, the tensor.Int data is
+// converted to []int64
+func flatData(t tensor.Tensor) (data interface{}, dt tensor.Dtype, err error) {
+	if d, ok := t.(*tensor.Dense); ok && d.IsView() {
+		t = d.Materialize()
+	}
+	data, dt = t.Data(), t.Dtype()
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice {
+		// the scalar of the 0-d tensor
+		s := reflect.MakeSlice(reflect.SliceOf(v.Type()), 1, 1)
+		s.Index(0).Set(v)
+		data, v = s.Interface(), s
+	}
+	if dt == tensor.Int {
+		ints := make([]int64, v.Len())
+		for i := range ints {
+			ints[i] = v.Index(i).Int()
+		}
+		data, dt = ints, tensor.Int64
+	}
+	if v := reflect.ValueOf(data); v.Len() != t.Shape().TotalSize() {
+		return nil, dt, fmt.Errorf("npy: tensor data %d != shape %v", v.Len(), t.Shape())
+	}
+	return
+}
+
+// Write writes t in the .npy format
+func Write(w io.Writer, t tensor.Tensor) (err error) {
+	data, dt, err := flatData(t)
+	if err != nil {
+		return
+	}
+	d, err := descr(dt)
+	if err != nil {
+		return
+	}
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': %s, }", d, shapeString(t.Shape()))
+	// the header is padded by spaces and ended by a newline to align the data
+	// by 64 bytes, of the version 1.0 if the length fits in uint16
+	prefix := len(magic) + 2 + 2
+	if len(header)+prefix+64 > 0xffff {
+		prefix += 2
+	}
+	header += strings.Repeat(" ", 63-(prefix+len(header))%64) + "\n"
+
+	bw := bufio.NewWriter(w)
+	if _, err = bw.Write(magic); err != nil {
+		return
+	}
+	if prefix == len(magic)+4 {
+		_, err = bw.Write([]byte{1, 0})
+		if err == nil {
+			err = binary.Write(bw, binary.LittleEndian, uint16(len(header)))
+		}
+	} else {
+		_, err = bw.Write([]byte{2, 0})
+		if err == nil {
+			err = binary.Write(bw, binary.LittleEndian, uint32(len(header)))
+		}
+	}
+	if err != nil {
+		return
+	}
+	if _, err = bw.WriteString(header); err != nil {
+		return
+	}
+	if err = binary.Write(bw, binary.LittleEndian, data); err != nil {
+		return
+	}
+	return bw.Flush()
+}
+
+// Read reads the tensor of the .npy format
+func Read(r io.Reader) (t *tensor.Dense, err error) {
+	prefix := make([]byte, len(magic)+2)
+	if _, err = io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("npy: read magic: %v", err)
+	}
+	if !bytes.Equal(prefix[:len(magic)], magic) {
+		return nil, fmt.Errorf("npy: bad magic %q", prefix[:len(magic)])
+	}
+	var headerLen int
+	switch major := prefix[len(magic)]; major {
+	case 1:
+		var n uint16
+		err = binary.Read(r, binary.LittleEndian, &n)
+		headerLen = int(n)
+	case 2, 3:
+		var n uint32
+		err = binary.Read(r, binary.LittleEndian, &n)
+		headerLen = int(n)
+	default:
+		return nil, fmt.Errorf("npy: version %d not supported", major)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("npy: read header length: %v", err)
+	}
+	header := make([]byte, headerLen)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("npy: read header: %v", err)
+	}
+
+	descrMatch, orderMatch, shapeMatch := descrRE.FindSubmatch(header), orderRE.FindSubmatch(header), shapeRE.FindSubmatch(header)
+	if descrMatch == nil || orderMatch == nil || shapeMatch == nil {
+		return nil, fmt.Errorf("npy: header %q of no descr, fortran_order or shape", header)
+	}
+	var (
+		dt    tensor.Dtype
+		found bool
+	)
+	size, _ := strconv.Atoi(string(descrMatch[3]))
+	for _, d := range dtypes {
+		if d.kind == descrMatch[2][0] && d.size == size {
+			dt, found = d.dt, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("npy: dtype %s%s%s not supported", descrMatch[1], descrMatch[2], descrMatch[3])
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if descrMatch[1][0] == '>' {
+		order = binary.BigEndian
+	}
+	var shape tensor.Shape
+	for _, s := range strings.Split(string(shapeMatch[1]), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		var d int
+		if d, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("npy: shape %q: %v", shapeMatch[1], err)
+		}
+		shape = append(shape, d)
+	}
+	fortran := string(orderMatch[1]) == "True"
+
+	n := shape.TotalSize()
+	data := reflect.MakeSlice(reflect.SliceOf(dt.Type), n, n)
+	if err = binary.Read(r, order, data.Interface()); err != nil {
+		return nil, fmt.Errorf("npy: read %d values of %v: %v", n, dt, err)
+	}
+	if len(shape) == 0 {
+		return tensor.New(tensor.FromScalar(data.Index(0).Interface())), nil
+	}
+	if !fortran || len(shape) == 1 {
+		return tensor.New(tensor.WithShape(shape...), tensor.WithBacking(data.Interface())), nil
+	}
+	// the Fortran order is the C order of the reversed shape transposed
+	reversed := make(tensor.Shape, len(shape))
+	for i, d := range shape {
+		reversed[len(shape)-1-i] = d
+	}
+	t = tensor.New(tensor.WithShape(reversed...), tensor.WithBacking(data.Interface()))
+	if err = t.T(); err != nil {
+		return nil, fmt.Errorf("npy: transpose of fortran order: %v", err)
+	}
+	return t.Materialize().(*tensor.Dense), nil
+}
+
+// WriteNpz writes the arrays in the .npz format of np.savez, or of
+// np.savez_compressed if compressed. The arrays are stored by the names in
+// order.
+func WriteNpz(w io.Writer, arrays map[string]tensor.Tensor, compressed bool) (err error) {
+	names := make([]string, 0, len(arrays))
+	for name := range arrays {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	method := zip.Store
+	if compressed {
+		method = zip.Deflate
+	}
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		var fw io.Writer
+		if fw, err = zw.CreateHeader(&zip.FileHeader{Name: name + ".npy", Method: method}); err != nil {
+			return
+		}
+		if err = Write(fw, arrays[name]); err != nil {
+			return fmt.Errorf("npz: array %s: %v", name, err)
+		}
+	}
+	return zw.Close()
+}
+
+// ReadNpz reads the arrays of the .npz format of size bytes by the names
+// without the .npy suffix
+func ReadNpz(r io.ReaderAt, size int64) (arrays map[string]*tensor.Dense, err error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("npz: %v", err)
+	}
+	arrays = make(map[string]*tensor.Dense, len(zr.File))
+	for _, f := range zr.File {
+		var rc io.ReadCloser
+		if rc, err = f.Open(); err != nil {
+			return nil, fmt.Errorf("npz: array %s: %v", f.Name, err)
+		}
+		t, er := Read(bufio.NewReader(rc))
+		_ = rc.Close()
+		if er != nil {
+			return nil, fmt.Errorf("npz: array %s: %v", f.Name, er)
+		}
+		arrays[strings.TrimSuffix(f.Name, ".npy")] = t
+	}
+	return
+}
+
+// LoadNpy reads the .npy file of path
+func LoadNpy(path string) (*tensor.Dense, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(bufio.NewReader(f))
+}
+
+// SaveNpy writes t to the .npy file of path
+func SaveNpy(path string, t tensor.Tensor) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	if err = Write(f, t); err != nil {
+		_ = f.Close()
+		return
+	}
+	return f.Close()
+}
+
+// LoadNpz reads the arrays of the .npz file of path
+func LoadNpz(path string) (map[string]*tensor.Dense, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ReadNpz(f, info.Size())
+}
+
+// SaveNpz writes the arrays to the .npz file of path, see WriteNpz
+func SaveNpz(path string, arrays map[string]tensor.Tensor, compressed bool) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	if err = WriteNpz(f, arrays, compressed); err != nil {
+		_ = f.Close()
+		return
+	}
+	return f.Close()
+}
@@ -0,0 +1,134 @@
+package npy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorgonia.org/tensor"
+)
+
+// rawNpy is the .npy v1 of the header dict and the data written in order
+func rawNpy(dict string, order binary.ByteOrder, data interface{}) []byte {
+	header := dict + strings.Repeat(" ", 63-(10+len(dict))%64) + "\n"
+	var buf bytes.Buffer
+	buf.WriteString("\x93NUMPY\x01\x00")
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(len(header)))
+	buf.WriteString(header)
+	_ = binary.Write(&buf, order, data)
+	return buf.Bytes()
+}
+
+func roundTrip(t tensor.Tensor) (*tensor.Dense, error) {
+	var buf bytes.Buffer
+	if err := Write(&buf, t); err != nil {
+		return nil, err
+	}
+	return Read(&buf)
+}
+
+func TestNpy(t *testing.T) {
+	Convey("the header of numpy", t, func() {
+		var buf bytes.Buffer
+		x := tensor.New(tensor.WithShape(2, 3), tensor.WithBacking([]float32{1, 2, 3, 4, 5, 6}))
+		So(Write(&buf, x), ShouldBeNil)
+		So(buf.Bytes(), ShouldResemble, rawNpy("{'descr': '<f4', 'fortran_order': False, 'shape': (2, 3), }",
+			binary.LittleEndian, []float32{1, 2, 3, 4, 5, 6}))
+		// the data is aligned by 64 bytes
+		So((buf.Len()-6*4)%64, ShouldEqual, 0)
+	})
+
+	Convey("round trip", t, func() {
+		for _, x := range []*tensor.Dense{
+			tensor.New(tensor.WithShape(2, 3), tensor.WithBacking([]float32{1, 2, 3, 4, 5, 6})),
+			tensor.New(tensor.WithShape(2, 1, 2), tensor.WithBacking([]float64{0.1, 0.2, 0.3, 0.4})),
+			tensor.New(tensor.WithShape(3), tensor.WithBacking([]int32{-1, 0, 1})),
+			tensor.New(tensor.WithShape(2), tensor.WithBacking([]uint8{0, 255})),
+			tensor.New(tensor.WithShape(2), tensor.WithBacking([]bool{true, false})),
+			tensor.New(tensor.FromScalar(float32(3.5))),
+		} {
+			y, err := roundTrip(x)
+			So(err, ShouldBeNil)
+			So(y.Shape(), ShouldResemble, x.Shape())
+			So(y.Dtype(), ShouldResemble, x.Dtype())
+			So(y.Data(), ShouldResemble, x.Data())
+		}
+
+		// tensor.Int is written as <i8
+		y, err := roundTrip(tensor.New(tensor.WithShape(2), tensor.WithBacking([]int{7, -7})))
+		So(err, ShouldBeNil)
+		So(y.Data(), ShouldResemble, []int64{7, -7})
+
+		// the views are written of the data viewed
+		x := tensor.New(tensor.WithShape(3, 2), tensor.WithBacking([]float32{1, 2, 3, 4, 5, 6}))
+		view, err := x.Slice(tensor.S(1, 3))
+		So(err, ShouldBeNil)
+		y, err = roundTrip(view)
+		So(err, ShouldBeNil)
+		So(y.Shape(), ShouldResemble, tensor.Shape{2, 2})
+		So(y.Data(), ShouldResemble, []float32{3, 4, 5, 6})
+
+		_, err = roundTrip(tensor.New(tensor.WithShape(1), tensor.WithBacking([]complex64{1})))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("read the big-endian and fortran order", t, func() {
+		y, err := Read(bytes.NewReader(rawNpy("{'descr': '>i4', 'fortran_order': False, 'shape': (2, 2), }",
+			binary.BigEndian, []int32{1, 2, 3, 256})))
+		So(err, ShouldBeNil)
+		So(y.Data(), ShouldResemble, []int32{1, 2, 3, 256})
+
+		y, err = Read(bytes.NewReader(rawNpy("{'descr': '<f8', 'fortran_order': True, 'shape': (2, 3), }",
+			binary.LittleEndian, []float64{1, 4, 2, 5, 3, 6})))
+		So(err, ShouldBeNil)
+		So(y.Shape(), ShouldResemble, tensor.Shape{2, 3})
+		So(y.Data(), ShouldResemble, []float64{1, 2, 3, 4, 5, 6})
+
+		_, err = Read(bytes.NewReader(rawNpy("{'descr': '<c8', 'fortran_order': False, 'shape': (1,), }",
+			binary.LittleEndian, []float32{1, 0})))
+		So(err, ShouldNotBeNil)
+		_, err = Read(strings.NewReader("1,2,3\n"))
+		So(err, ShouldNotBeNil)
+		data := rawNpy("{'descr': '<f4', 'fortran_order': False, 'shape': (3,), }", binary.LittleEndian, []float32{1, 2})
+		_, err = Read(bytes.NewReader(data))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestNpz(t *testing.T) {
+	arrays := map[string]tensor.Tensor{
+		"x": tensor.New(tensor.WithShape(2, 2), tensor.WithBacking([]float32{1, 2, 3, 4})),
+		"y": tensor.New(tensor.WithShape(2), tensor.WithBacking([]float32{0, 1})),
+	}
+	Convey("npz of savez and savez_compressed", t, func() {
+		for _, compressed := range []bool{false, true} {
+			var buf bytes.Buffer
+			So(WriteNpz(&buf, arrays, compressed), ShouldBeNil)
+			read, err := ReadNpz(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			So(err, ShouldBeNil)
+			So(read, ShouldHaveLength, 2)
+			So(read["x"].Data(), ShouldResemble, []float32{1, 2, 3, 4})
+			So(read["y"].Shape(), ShouldResemble, tensor.Shape{2})
+		}
+		_, err := ReadNpz(strings.NewReader("not a zip"), 9)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("files", t, func() {
+		dir := t.TempDir()
+		So(SaveNpz(filepath.Join(dir, "eval.npz"), arrays, true), ShouldBeNil)
+		read, err := LoadNpz(filepath.Join(dir, "eval.npz"))
+		So(err, ShouldBeNil)
+		So(read["y"].Data(), ShouldResemble, []float32{0, 1})
+
+		So(SaveNpy(filepath.Join(dir, "x.npy"), arrays["x"]), ShouldBeNil)
+		x, err := LoadNpy(filepath.Join(dir, "x.npy"))
+		So(err, ShouldBeNil)
+		So(x.Data(), ShouldResemble, []float32{1, 2, 3, 4})
+		_, err = LoadNpy(filepath.Join(dir, "missing.npy"))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -146,3 +146,60 @@ func RocAuc32(pred, y []float32) float32 {
 
 	return float32(metrics.ROCAUCScore(yTrue, yScore, "", nil))
 }
+
+// RMSE32 is the root mean squared error of the regression
+func RMSE32(pred, y []float32) float32 {
+	var sum float64
+	for i := 0; i < len(y); i++ {
+		d := float64(pred[i] - y[i])
+		sum += d * d
+	}
+	return float32(math.Sqrt(sum / float64(len(y))))
+}
+
+// MAE32 is the mean absolute error of the regression
+func MAE32(pred, y []float32) float32 {
+	var sum float64
+	for i := 0; i < len(y); i++ {
+		sum += math.Abs(float64(pred[i] - y[i]))
+	}
+	return float32(sum / float64(len(y)))
+}
+
+// ConfusionMatrix counts the rows of the true class y[i] and the predicted
+// class pred[i] in confusion[y[i]][pred[i]], the classes out of [0, classes)
+// are ignored
+func ConfusionMatrix(pred, y []int, classes int) (confusion [][]int) {
+	confusion = make([][]int, classes)
+	for i := range confusion {
+		confusion[i] = make([]int, classes)
+	}
+	for i := 0; i < len(y); i++ {
+		if y[i] < 0 || y[i] >= classes || pred[i] < 0 || pred[i] >= classes {
+			continue
+		}
+		confusion[y[i]][pred[i]]++
+	}
+	return
+}
+
+// MacroF1 is the unweighted mean of the F1 of every class of the confusion
+// matrix, the F1 of a class never true nor predicted is 0
+func MacroF1(confusion [][]int) float64 {
+	if len(confusion) == 0 {
+		return 0
+	}
+	var sum float64
+	for k := range confusion {
+		var tp, predicted, actual int
+		tp = confusion[k][k]
+		for j := range confusion {
+			predicted += confusion[j][k]
+			actual += confusion[k][j]
+		}
+		if predicted+actual > 0 {
+			sum += 2 * float64(tp) / float64(predicted+actual)
+		}
+	}
+	return sum / float64(len(confusion))
+}
@@ -31,6 +31,29 @@ func TestGetAUC(t *testing.T) {
 	})
 }
 
+func TestRegressionMetrics(t *testing.T) {
+	Convey("test rmse and mae", t, func() {
+		pred, y := []float32{3, 4, 2, 5}, []float32{4, 4, 4, 5}
+		So(RMSE32(pred, y), ShouldAlmostEqual, 1.118034, 1e-6)
+		So(MAE32(pred, y), ShouldEqual, 0.75)
+	})
+}
+
+func TestClassificationMetrics(t *testing.T) {
+	Convey("test confusion matrix and macro f1", t, func() {
+		pred, y := []int{0, 1, 1, 2, 2, 0}, []int{0, 1, 2, 2, 2, 1}
+		confusion := ConfusionMatrix(pred, y, 3)
+		So(confusion, ShouldResemble, [][]int{
+			{1, 0, 0},
+			{1, 1, 0},
+			{0, 1, 2},
+		})
+		// f1: 2/3, 1/2, 4/5
+		So(MacroF1(confusion), ShouldAlmostEqual, (2./3+0.5+0.8)/3, 1e-9)
+		So(MacroF1(ConfusionMatrix(nil, nil, 2)), ShouldEqual, 0)
+	})
+}
+
 func TestParseInt64Seq(t *testing.T) {
 	Convey("test parse int64 seq", t, func() {
 		seq := ParseInt64Seq("1,2,3,4,5")